@@ -52,6 +52,11 @@ if [ ! -d "$FRONTEND_OUT_DIR" ]; then
     exit 1
 fi
 
+# 将前端打包进二进制（go:embed），frontend 目录缺失时服务端直接使用内嵌版本
+EMBED_DIR="$SERVER_DIR/embedded_frontend"
+find "$EMBED_DIR" -mindepth 1 ! -name '.gitkeep' -exec rm -rf {} +
+cp -R "$FRONTEND_OUT_DIR"/. "$EMBED_DIR/"
+
 echo
 echo "Building XXTCloudControl servers for multiple platforms..."
 echo "Build Time: $BUILD_TIME"
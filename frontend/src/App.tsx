@@ -1,5 +1,6 @@
 import { Component, createSignal, onCleanup, createMemo, createEffect } from 'solid-js';
 import { useToast } from './components/ToastContext';
+import { useDialog } from './components/DialogContext';
 import { WebSocketService, Device } from './services/WebSocketService';
 import { AuthService, LoginCredentials } from './services/AuthService';
 import { createGroupStore } from './services/GroupStore';
@@ -30,7 +31,7 @@ interface PendingLargeDownload {
   savePath: string;
 }
 
-type UpdateBusyAction = '' | 'check' | 'download' | 'apply';
+type UpdateBusyAction = '' | 'check' | 'download' | 'apply' | 'rollback';
 
 interface UpdateState {
   stage: string;
@@ -43,6 +44,7 @@ interface UpdateState {
   downloadedBytes?: number;
   downloadedVersion?: string;
   appliedVersion?: string;
+  rolledBackFrom?: string;
 }
 
 interface UpdateConfig {
@@ -53,10 +55,12 @@ interface UpdateStatusPayload {
   currentVersion: string;
   config?: UpdateConfig;
   state: UpdateState;
+  rollbackAvailable?: boolean;
 }
 
 const App: Component = () => {
   const toast = useToast();
+  const dialog = useDialog();
   const { themeMode, cycleTheme } = useTheme();
   
   // Check if current URL is the public bind page
@@ -316,7 +320,7 @@ const App: Component = () => {
 
   const performUpdateAction = async (
     action: Exclude<UpdateBusyAction, ''>,
-    path: '/api/update/check' | '/api/update/download' | '/api/update/apply'
+    path: '/api/update/check' | '/api/update/download' | '/api/update/apply' | '/api/update/rollback'
   ) => {
     if (!isAuthenticated()) {
       toast.showWarning('请先完成鉴权登录后再执行更新操作');
@@ -360,6 +364,9 @@ const App: Component = () => {
       } else if (action === 'apply') {
         toast.showInfo('正在应用更新，服务将短暂重启');
         startUpdateReconnectPolling(previousVersion);
+      } else if (action === 'rollback') {
+        toast.showInfo('正在回滚到上一版本，服务将短暂重启');
+        startUpdateReconnectPolling(previousVersion);
       }
     } catch {
       toast.showError('更新操作失败');
@@ -380,6 +387,11 @@ const App: Component = () => {
     await performUpdateAction('apply', '/api/update/apply');
   };
 
+  const handleRollbackUpdate = async () => {
+    if (!await dialog.confirm('确定回滚到上一次更新前的版本吗？服务将短暂重启。')) return;
+    await performUpdateAction('rollback', '/api/update/rollback');
+  };
+
   const handleCancelDownload = async () => {
     if (!isAuthenticated()) {
       toast.showWarning('请先完成鉴权登录后再执行更新操作');
@@ -1256,6 +1268,15 @@ const App: Component = () => {
                         >
                           {updateMainButtonLabel()}
                         </button>
+                        {updateStatus()?.rollbackAvailable && (
+                          <button
+                            class={`${styles.updateActionButton} ${styles.updateActionDanger}`}
+                            disabled={!!updateBusyAction() || !isAuthenticated()}
+                            onClick={handleRollbackUpdate}
+                          >
+                            {updateBusyAction() === 'rollback' ? '回滚中...' : '回滚版本'}
+                          </button>
+                        )}
                       </div>
                     </div>
                   )}
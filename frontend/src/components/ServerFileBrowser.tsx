@@ -136,6 +136,7 @@ export default function ServerFileBrowser(props: ServerFileBrowserProps) {
   const [showEditorModal, setShowEditorModal] = createSignal(false);
   const [editorFileName, setEditorFileName] = createSignal('');
   const [editorContent, setEditorContent] = createSignal('');
+  const [editorModTime, setEditorModTime] = createSignal<number | undefined>(undefined);
   const [editorSaving, setEditorSaving] = createSignal(false);
   
   // 图片预览
@@ -822,24 +823,38 @@ export default function ServerFileBrowser(props: ServerFileBrowserProps) {
       const response = await authFetch(`${props.serverBaseUrl}/api/server-files/read?${params}`);
       const data = await response.json();
       if (data.error) { await dialog.alert('读取失败: ' + data.error); return; }
+      if (data.binary) { await dialog.alert(`无法编辑二进制文件（${data.mimeType || '未知类型'}）`); return; }
       setEditorFileName(file.name);
       setEditorContent(data.content);
+      setEditorModTime(data.modTime);
       setShowEditorModal(true);
     } catch (err) {
       await dialog.alert('读取失败: ' + (err as Error).message);
     }
   };
 
-  const handleSaveFile = async () => {
+  const handleSaveFile = async (force = false) => {
     const filePath = currentPath() ? `${currentPath()}/${editorFileName()}` : editorFileName();
     setEditorSaving(true);
     try {
       const response = await authFetch(`${props.serverBaseUrl}/api/server-files/save`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ category: currentCategory(), path: filePath, content: editorContent() })
+        body: JSON.stringify({
+          category: currentCategory(),
+          path: filePath,
+          content: editorContent(),
+          expectedModTime: force ? undefined : editorModTime(),
+        })
       });
       const data = await response.json();
+      if (response.status === 409) {
+        setEditorSaving(false);
+        if (await dialog.confirm('文件在打开后已被其他人修改，是否仍要覆盖保存？')) {
+          await handleSaveFile(true);
+        }
+        return;
+      }
       if (data.error) await dialog.alert('保存失败: ' + data.error);
       else setShowEditorModal(false);
     } catch (err) {
@@ -1225,7 +1240,7 @@ export default function ServerFileBrowser(props: ServerFileBrowserProps) {
             <textarea class={styles.editorTextarea} value={editorContent()} onInput={(e) => setEditorContent(e.currentTarget.value)} />
             <div class={styles.editorFooter}>
               <button class={styles.cancelBtn} onClick={() => setShowEditorModal(false)}>取消</button>
-              <button class={styles.confirmBtn} onClick={handleSaveFile} disabled={editorSaving()}>{editorSaving() ? '保存中...' : '保存'}</button>
+              <button class={styles.confirmBtn} onClick={() => handleSaveFile()} disabled={editorSaving()}>{editorSaving() ? '保存中...' : '保存'}</button>
             </div>
           </div>
         </div>
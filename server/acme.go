@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeChallengePort is where Let's Encrypt connects for the HTTP-01 challenge.
+const acmeChallengePort = 80
+
+// acmeEnabled reports whether certificates come from ACME; it takes precedence over
+// tlsCertFile/tlsKeyFile.
+func acmeEnabled() bool {
+	return len(getServerConfig().ACMEDomains) > 0
+}
+
+// advertisedHost returns the host generated URLs should use: the first ACME domain when
+// ACME is enabled (certificates are only valid for those names), otherwise fallback.
+func advertisedHost(fallback string) string {
+	if !acmeEnabled() {
+		return fallback
+	}
+	for _, domain := range getServerConfig().ACMEDomains {
+		if strings.EqualFold(domain, fallback) {
+			return fallback
+		}
+	}
+	return getServerConfig().ACMEDomains[0]
+}
+
+func acmeCacheDir() string {
+	if getServerConfig().ACMECacheDir != "" {
+		return getServerConfig().ACMECacheDir
+	}
+	return filepath.Join(getServerConfig().DataDir, "acme")
+}
+
+// validateACMEDomains accepts plain DNS names; HTTP-01 cannot issue for IPs or wildcards.
+func validateACMEDomains(domains []string) error {
+	for _, domain := range domains {
+		switch {
+		case strings.TrimSpace(domain) != domain || domain == "":
+			return fmt.Errorf("acmeDomains entries cannot be empty or padded")
+		case strings.ContainsAny(domain, "*:/"):
+			return fmt.Errorf("acmeDomains entry %q must be a plain domain name", domain)
+		case net.ParseIP(domain) != nil:
+			return fmt.Errorf("acmeDomains entry %q must be a domain name, not an IP", domain)
+		}
+	}
+	return nil
+}
+
+func newACMEManager() *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(getServerConfig().ACMEDomains...),
+		Cache:      autocert.DirCache(acmeCacheDir()),
+	}
+}
+
+// startACMEChallengeServer answers HTTP-01 challenges on port 80 of bindAddress and
+// redirects every other request to HTTPS.
+func startACMEChallengeServer(manager *autocert.Manager, bindAddress string) *http.Server {
+	addr := net.JoinHostPort(bindAddress, strconv.Itoa(acmeChallengePort))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           manager.HTTPHandler(httpsRedirectHandler(getServerConfig().Port)),
+		ReadHeaderTimeout: httpServerReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("ACME challenge server failed; certificates cannot be issued or renewed", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("ACME certificates enabled", "domains", getServerConfig().ACMEDomains, "challenge_addr", addr, "cache_dir", acmeCacheDir())
+	return server
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateACMEDomains(t *testing.T) {
+	if err := validateACMEDomains([]string{"cloud.example.com", "xxt.example.org"}); err != nil {
+		t.Fatalf("expected plain domains to be accepted, got %v", err)
+	}
+	for _, domain := range []string{"", " cloud.example.com", "*.example.com", "cloud.example.com:443", "https://cloud.example.com", "203.0.113.7"} {
+		if err := validateACMEDomains([]string{domain}); err == nil {
+			t.Fatalf("expected %q to be rejected", domain)
+		}
+	}
+}
+
+func TestValidateServerConfigACMEConflicts(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.ACMEDomains = []string{"cloud.example.com"}
+	if err := validateServerConfig(cfg); err != nil {
+		t.Fatalf("expected ACME config to be valid, got %v", err)
+	}
+
+	withPort80 := cfg
+	withPort80.Port = acmeChallengePort
+	if err := validateServerConfig(withPort80); err == nil {
+		t.Fatalf("expected port 80 to conflict with the ACME challenge listener")
+	}
+	loopback := cfg
+	loopback.BindAddress = "127.0.0.1"
+	if err := validateServerConfig(loopback); err == nil {
+		t.Fatalf("expected a loopback bindAddress to be rejected with ACME")
+	}
+}
+
+func TestACMEDomainAdvertisedInGeneratedURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+	setServerConfig(DefaultConfig)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ACMEDomains = []string{"cloud.example.com", "xxt.example.org"} })
+
+	if host := advertisedHost("192.168.1.5"); host != "cloud.example.com" {
+		t.Fatalf("expected the first ACME domain, got %q", host)
+	}
+	if host := advertisedHost("XXT.example.org"); host != "XXT.example.org" {
+		t.Fatalf("expected a listed ACME domain to be kept, got %q", host)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/download-bind-script?host=192.168.1.5", nil)
+	downloadBindScriptHandler(c)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `local cloud_host = "cloud.example.com";`) ||
+		!strings.Contains(w.Body.String(), `local ws_proto = "wss";`) {
+		t.Fatalf("expected bind script to target wss://cloud.example.com, got %d %.200s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/config?format=json", nil)
+	configHandler(c)
+	var resp struct {
+		WebSocket struct {
+			Protocol string `json:"protocol"`
+			Host     string `json:"host"`
+		} `json:"websocket"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.WebSocket.Protocol != "wss" || resp.WebSocket.Host != "cloud.example.com" {
+		t.Fatalf("unexpected websocket config: %+v", resp.WebSocket)
+	}
+}
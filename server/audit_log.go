@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditQueueSize      = 4096
+	auditMaxFileBytes   = 16 * 1024 * 1024
+	auditActiveFileName = "audit.jsonl"
+	auditDefaultLimit   = 100
+	auditMaxLimit       = 1000
+)
+
+// auditEntry is one line of <dataDir>/audit/audit.jsonl: who sent what to which devices.
+type auditEntry struct {
+	TS           int64    `json:"ts"`
+	RemoteAddr   string   `json:"remoteAddr"`
+	ControllerID string   `json:"controllerId,omitempty"`
+	Type         string   `json:"type"`
+	Commands     []string `json:"commands,omitempty"`
+	Devices      []string `json:"devices"`
+	RequestID    string   `json:"requestId,omitempty"`
+	BatchID      string   `json:"batchId,omitempty"`
+	Detail       string   `json:"detail,omitempty"`
+}
+
+// auditRequest carries either an entry to write or a flush waiting for the queue to drain.
+type auditRequest struct {
+	entry   *auditEntry
+	flushed chan struct{}
+}
+
+// auditLog owns the writer goroutine, started on first use. Entries are dropped rather
+// than blocking the fan-out path when the queue is full.
+var auditLog struct {
+	once    sync.Once
+	queue   chan auditRequest
+	dropped atomic.Int64
+}
+
+func auditDir() string {
+	return filepath.Join(getServerConfig().DataDir, "audit")
+}
+
+func auditQueue() chan auditRequest {
+	auditLog.once.Do(func() {
+		auditLog.queue = make(chan auditRequest, auditQueueSize)
+		go runAuditWriter(auditLog.queue)
+	})
+	return auditLog.queue
+}
+
+// connRemoteAddr is conn's peer address, or "" for sentinel connections.
+func connRemoteAddr(conn *SafeConn) string {
+	if conn == nil || conn.conn == nil {
+		return ""
+	}
+	return conn.RemoteAddr()
+}
+
+// recordAudit queues entry for the audit log without waiting for the write.
+func recordAudit(entry auditEntry) {
+	if !getServerConfig().AuditLogEnabled {
+		return
+	}
+	if entry.TS == 0 {
+		entry.TS = time.Now().UnixMilli()
+	}
+	if entry.Devices == nil {
+		entry.Devices = []string{}
+	}
+	select {
+	case auditQueue() <- auditRequest{entry: &entry}:
+	default:
+		if auditLog.dropped.Add(1) == 1 {
+			slog.Warn("Audit log queue full, dropping entries")
+		}
+	}
+}
+
+// flushAuditLog waits until every entry queued so far has been written.
+func flushAuditLog() {
+	done := make(chan struct{})
+	auditQueue() <- auditRequest{flushed: done}
+	<-done
+}
+
+func runAuditWriter(queue chan auditRequest) {
+	var buf bytes.Buffer
+	var waiting []chan struct{}
+	for req := range queue {
+		// Write everything already queued in one append.
+		for {
+			if req.entry != nil {
+				if line, err := json.Marshal(req.entry); err == nil {
+					buf.Write(line)
+					buf.WriteByte('\n')
+				}
+			}
+			if req.flushed != nil {
+				waiting = append(waiting, req.flushed)
+			}
+			if buf.Len() >= 256*1024 {
+				break
+			}
+			select {
+			case req = <-queue:
+				continue
+			default:
+			}
+			break
+		}
+		if buf.Len() > 0 {
+			if err := appendAuditLines(buf.Bytes(), time.Now()); err != nil {
+				slog.Warn("Failed to write audit log", "error", err)
+			}
+			buf.Reset()
+		}
+		for _, done := range waiting {
+			close(done)
+		}
+		waiting = waiting[:0]
+	}
+}
+
+// appendAuditLines appends to audit.jsonl, first rotating it to audit-<time>.jsonl when it
+// was last written on an earlier day or has reached auditMaxFileBytes.
+func appendAuditLines(data []byte, now time.Time) error {
+	dir := auditDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	active := filepath.Join(dir, auditActiveFileName)
+	if info, err := os.Stat(active); err == nil {
+		y1, m1, d1 := info.ModTime().Date()
+		y2, m2, d2 := now.Date()
+		if info.Size()+int64(len(data)) > auditMaxFileBytes || y1 != y2 || m1 != m2 || d1 != d2 {
+			rotated := filepath.Join(dir, "audit-"+info.ModTime().Format("20060102T150405.000")+".jsonl")
+			if err := os.Rename(active, rotated); err != nil {
+				return err
+			}
+		}
+	}
+
+	f, err := os.OpenFile(active, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+// auditFilesNewestFirst lists the active file and then rotated files, newest first.
+func auditFilesNewestFirst() ([]string, error) {
+	dirEntries, err := os.ReadDir(auditDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var rotated []string
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, "audit-") && strings.HasSuffix(name, ".jsonl") {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+	files := append([]string{auditActiveFileName}, rotated...)
+	for i, name := range files {
+		files[i] = filepath.Join(auditDir(), name)
+	}
+	return files, nil
+}
+
+// loadAuditEntries returns up to limit of the most recent entries at or after since
+// (unix ms), oldest first.
+func loadAuditEntries(since int64, limit int) ([]auditEntry, error) {
+	files, err := auditFilesNewestFirst()
+	if err != nil {
+		return nil, err
+	}
+	var collected []auditEntry
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var fileEntries []auditEntry
+		olderThanSince := false
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			var entry auditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			if entry.TS < since {
+				olderThanSince = true
+				continue
+			}
+			fileEntries = append(fileEntries, entry)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		collected = append(fileEntries, collected...)
+		if len(collected) >= limit || olderThanSince {
+			break
+		}
+	}
+	if len(collected) > limit {
+		collected = collected[len(collected)-limit:]
+	}
+	if collected == nil {
+		collected = []auditEntry{}
+	}
+	return collected, nil
+}
+
+// auditHandler handles GET /api/audit
+func auditHandler(c *gin.Context) {
+	var since int64
+	if value := c.Query("since"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a unix timestamp in milliseconds"})
+			return
+		}
+		since = parsed
+	}
+	limit := auditDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(parsed, auditMaxLimit)
+	}
+
+	flushAuditLog()
+	entries, err := loadAuditEntries(since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "dropped": auditLog.dropped.Load()})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog_RecordsAndQueriesRecentEntries(t *testing.T) {
+	setupFileHandlersTestDataDir(t)
+	prevEnabled := getServerConfig().AuditLogEnabled
+	updateServerConfig(func(cfg *ServerConfig) { cfg.AuditLogEnabled = true })
+	t.Cleanup(func() {
+		flushAuditLog()
+		updateServerConfig(func(cfg *ServerConfig) { cfg.AuditLogEnabled = prevEnabled })
+	})
+
+	base := time.Now().Add(-time.Hour).UnixMilli()
+	for i := 0; i < 5; i++ {
+		recordAudit(auditEntry{
+			TS:         base + int64(i),
+			RemoteAddr: "10.0.0.5:5000",
+			Type:       "control/command",
+			Commands:   []string{"script/run"},
+			Devices:    []string{fmt.Sprintf("dev-%d", i)},
+			RequestID:  fmt.Sprintf("req-%d", i),
+		})
+	}
+
+	target := fmt.Sprintf("/api/audit?since=%d&limit=2", base+1)
+	w := performJSONHandlerRequest(t, http.MethodGet, target, nil, auditHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Entries []auditEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.Entries) != 2 || resp.Entries[0].RequestID != "req-3" || resp.Entries[1].RequestID != "req-4" {
+		t.Fatalf("expected the two most recent entries oldest first, got %+v", resp.Entries)
+	}
+	if resp.Entries[1].RemoteAddr != "10.0.0.5:5000" || resp.Entries[1].Devices[0] != "dev-4" {
+		t.Fatalf("unexpected entry: %+v", resp.Entries[1])
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/audit?limit=abc", nil, auditHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid limit to be rejected, got %d", w.Code)
+	}
+}
+
+func TestAuditLog_RotatesByDateAndReadsAcrossFiles(t *testing.T) {
+	setupFileHandlersTestDataDir(t)
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	if err := appendAuditLines([]byte(`{"ts":1,"type":"control/http","devices":["a"]}`+"\n"), yesterday); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	active := filepath.Join(auditDir(), auditActiveFileName)
+	if err := os.Chtimes(active, yesterday, yesterday); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+	if err := appendAuditLines([]byte(`{"ts":2,"type":"scripts/send","devices":["b"]}`+"\n"), time.Now()); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	files, err := auditFilesNewestFirst()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected the active file and one rotated file, got %v (%v)", files, err)
+	}
+	entries, err := loadAuditEntries(0, 10)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Type != "control/http" || entries[1].Type != "scripts/send" {
+		t.Fatalf("expected entries from both files in order, got %+v", entries)
+	}
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"bytes"
+	"container/list"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/url"
 	"sort"
 	"strings"
@@ -20,15 +21,23 @@ const (
 	authSkewSeconds   int64 = 60
 	nonceTTLSeconds   int64 = 120
 	nonceCleanupEvery       = 30 * time.Second
+	nonceRejectWindow       = 5 * time.Minute
 	authQueryTSKey          = "ts"
 	authQueryNonceKey       = "nonce"
 	authQuerySignKey        = "sign"
 )
 
+// maxStoredNonces bounds the replay cache. Nonces must outlive the timestamp window,
+// so a full cache rejects new nonces instead of evicting live ones.
+const maxStoredNonces = 200000
+
 var usedNonces = struct {
 	sync.Mutex
 	store         map[string]int64
 	expiryBuckets map[int64]map[string]struct{}
+	rejectedTotal int64
+	overflowTotal int64       // nonces rejected because the cache was full
+	rejectedAt    []time.Time // replay rejections within nonceRejectWindow, oldest first
 }{
 	store:         make(map[string]int64),
 	expiryBuckets: make(map[int64]map[string]struct{}),
@@ -36,8 +45,25 @@ var usedNonces = struct {
 
 func debugAuthf(format string, args ...interface{}) {
 	if authDebugEnabled() {
-		log.Printf(format, args...)
+		slog.Debug(fmt.Sprintf(format, args...))
+	}
+}
+
+// getSignatureSkewSeconds returns the allowed distance between a signed ts and server time.
+func getSignatureSkewSeconds() int64 {
+	if getServerConfig().SignatureSkewSeconds > 0 {
+		return int64(getServerConfig().SignatureSkewSeconds)
+	}
+	return authSkewSeconds
+}
+
+// getNonceTTLSeconds keeps nonces for at least the whole timestamp window (2 × skew),
+// otherwise a message could be replayed after its nonce expired.
+func getNonceTTLSeconds() int64 {
+	if ttl := 2 * getSignatureSkewSeconds(); ttl > nonceTTLSeconds {
+		return ttl
 	}
+	return nonceTTLSeconds
 }
 
 func isTimestampValid(ts int64) bool {
@@ -45,13 +71,29 @@ func isTimestampValid(ts int64) bool {
 		return false
 	}
 	currentTime := time.Now().Unix()
-	return ts >= currentTime-authSkewSeconds && ts <= currentTime+authSkewSeconds
+	skew := getSignatureSkewSeconds()
+	return ts >= currentTime-skew && ts <= currentTime+skew
 }
 
-func cleanupExpiredNonces(now int64) int {
-	removed := 0
+// warnIfOnlySkewInvalid logs a distinct warning when a signature with an out-of-window
+// timestamp is otherwise correct, which points at a drifting client clock.
+func warnIfOnlySkewInvalid(kind, detail string, ts int64, signatureBase, sign string) {
+	if ts == 0 || !verifySignature(computeSignatureHex(signatureBase), sign) {
+		return
+	}
+	slog.Warn("Rejected request with valid signature but skewed timestamp, check the client clock",
+		"kind", kind, "detail", detail, "skew_seconds", ts-time.Now().Unix(), "allowed_seconds", getSignatureSkewSeconds())
+}
 
+func cleanupExpiredNonces(now int64) int {
 	usedNonces.Lock()
+	defer usedNonces.Unlock()
+	return cleanupExpiredNoncesLocked(now)
+}
+
+// cleanupExpiredNoncesLocked drops expired nonces. Caller must hold usedNonces.
+func cleanupExpiredNoncesLocked(now int64) int {
+	removed := 0
 	for expiresAt, keys := range usedNonces.expiryBuckets {
 		if expiresAt > now {
 			continue
@@ -64,8 +106,6 @@ func cleanupExpiredNonces(now int64) int {
 		}
 		delete(usedNonces.expiryBuckets, expiresAt)
 	}
-	usedNonces.Unlock()
-
 	return removed
 }
 
@@ -84,7 +124,7 @@ func checkAndStoreNonce(namespace, nonce string) bool {
 		return false
 	}
 	now := time.Now().Unix()
-	expiresAt := now + nonceTTLSeconds
+	expiresAt := now + getNonceTTLSeconds()
 	key := namespace + ":" + nonce
 
 	usedNonces.Lock()
@@ -93,6 +133,7 @@ func checkAndStoreNonce(namespace, nonce string) bool {
 	if storedExpiresAt, exists := usedNonces.store[key]; exists {
 		if storedExpiresAt > now {
 			debugAuthf("[auth] nonce replay rejected: ns=%s nonce=%s", namespace, nonce)
+			recordNonceRejectionLocked(time.Now())
 			return false
 		}
 		// Clean stale index entry from its previous expiry bucket.
@@ -104,6 +145,15 @@ func checkAndStoreNonce(namespace, nonce string) bool {
 		}
 	}
 
+	if len(usedNonces.store) >= maxStoredNonces {
+		cleanupExpiredNoncesLocked(now)
+	}
+	if len(usedNonces.store) >= maxStoredNonces {
+		debugAuthf("[auth] nonce cache full, rejected: ns=%s nonce=%s", namespace, nonce)
+		usedNonces.overflowTotal++
+		return false
+	}
+
 	usedNonces.store[key] = expiresAt
 	bucket := usedNonces.expiryBuckets[expiresAt]
 	if bucket == nil {
@@ -114,6 +164,55 @@ func checkAndStoreNonce(namespace, nonce string) bool {
 	return true
 }
 
+// recordNonceRejectionLocked counts a replay rejection. Caller must hold usedNonces.
+func recordNonceRejectionLocked(now time.Time) {
+	usedNonces.rejectedTotal++
+	usedNonces.rejectedAt = append(pruneNonceRejectionsLocked(now), now)
+}
+
+func pruneNonceRejectionsLocked(now time.Time) []time.Time {
+	cutoff := now.Add(-nonceRejectWindow)
+	i := 0
+	for i < len(usedNonces.rejectedAt) && usedNonces.rejectedAt[i].Before(cutoff) {
+		i++
+	}
+	usedNonces.rejectedAt = usedNonces.rejectedAt[i:]
+	return usedNonces.rejectedAt
+}
+
+type nonceStoreStats struct {
+	Stored         int   `json:"stored"`
+	RecentRejected int   `json:"recentRejected"`
+	TotalRejected  int64 `json:"totalRejected"`
+	WindowSeconds  int64 `json:"windowSeconds"`
+	Capacity       int   `json:"capacity"`
+	OverflowTotal  int64 `json:"overflowTotal"`
+}
+
+func getNonceStoreStats(now time.Time) nonceStoreStats {
+	usedNonces.Lock()
+	defer usedNonces.Unlock()
+	return nonceStoreStats{
+		Stored:         len(usedNonces.store),
+		RecentRejected: len(pruneNonceRejectionsLocked(now)),
+		TotalRejected:  usedNonces.rejectedTotal,
+		WindowSeconds:  int64(nonceRejectWindow / time.Second),
+		Capacity:       maxStoredNonces,
+		OverflowTotal:  usedNonces.overflowTotal,
+	}
+}
+
+// clearNonceStore drops every stored nonce and returns how many were removed.
+// Rejection counters are kept so the flush itself remains visible in stats.
+func clearNonceStore() int {
+	usedNonces.Lock()
+	defer usedNonces.Unlock()
+	removed := len(usedNonces.store)
+	usedNonces.store = make(map[string]int64)
+	usedNonces.expiryBuckets = make(map[int64]map[string]struct{})
+	return removed
+}
+
 func hashBytesHex(data []byte) string {
 	if len(data) == 0 {
 		return ""
@@ -142,7 +241,9 @@ func buildMessageSignatureString(ts int64, nonce, msgType, bodyHash string) stri
 }
 
 func computeSignatureHex(message string) string {
+	passhashMu.RLock()
 	h := hmac.New(sha256.New, passhash)
+	passhashMu.RUnlock()
 	h.Write([]byte(message))
 	return hex.EncodeToString(h.Sum(nil))
 }
@@ -151,6 +252,94 @@ func verifySignature(expected, actual string) bool {
 	return hmac.Equal([]byte(expected), []byte(actual))
 }
 
+type signatureCacheEntry struct {
+	key       string
+	expiresAt int64
+}
+
+// signatureCache is an LRU of recently verified (signature base, sign) pairs. The
+// signature base covers ts, nonce, type and body hash, so a hit only skips the HMAC;
+// timestamp and nonce checks still run on every message.
+var signatureCache = struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}{
+	entries: make(map[string]*list.Element),
+	order:   list.New(),
+}
+
+// setPasshash replaces the signing key and forgets signatures verified with the old one.
+func setPasshash(value string) {
+	passhashMu.Lock()
+	passhash = []byte(value)
+	passhashMu.Unlock()
+
+	signatureCache.Lock()
+	signatureCache.entries = make(map[string]*list.Element)
+	signatureCache.order.Init()
+	signatureCache.Unlock()
+}
+
+func signatureCacheKey(signatureBase, sign string) string {
+	return signatureBase + "\n" + sign
+}
+
+func lookupSignatureCache(key string, now int64) bool {
+	signatureCache.Lock()
+	defer signatureCache.Unlock()
+	elem, ok := signatureCache.entries[key]
+	if !ok {
+		return false
+	}
+	entry := elem.Value.(*signatureCacheEntry)
+	if entry.expiresAt <= now {
+		signatureCache.order.Remove(elem)
+		delete(signatureCache.entries, key)
+		return false
+	}
+	signatureCache.order.MoveToFront(elem)
+	return true
+}
+
+func storeSignatureCache(key string, expiresAt int64, limit int) {
+	signatureCache.Lock()
+	defer signatureCache.Unlock()
+	if elem, ok := signatureCache.entries[key]; ok {
+		elem.Value.(*signatureCacheEntry).expiresAt = expiresAt
+		signatureCache.order.MoveToFront(elem)
+		return
+	}
+	signatureCache.entries[key] = signatureCache.order.PushFront(&signatureCacheEntry{key: key, expiresAt: expiresAt})
+	for signatureCache.order.Len() > limit {
+		oldest := signatureCache.order.Back()
+		signatureCache.order.Remove(oldest)
+		delete(signatureCache.entries, oldest.Value.(*signatureCacheEntry).key)
+	}
+}
+
+// verifySignatureCached checks sign against signatureBase, consulting the LRU first
+// when signatureCacheSize is enabled. Only successful verifications are cached, and
+// only until ts leaves the accepted timestamp window.
+func verifySignatureCached(signatureBase string, ts int64, sign string) (bool, string) {
+	limit := getServerConfig().SignatureCacheSize
+	if limit <= 0 {
+		expected := computeSignatureHex(signatureBase)
+		return verifySignature(expected, sign), expected
+	}
+
+	key := signatureCacheKey(signatureBase, sign)
+	if lookupSignatureCache(key, time.Now().Unix()) {
+		return true, sign
+	}
+	expected := computeSignatureHex(signatureBase)
+	if !verifySignature(expected, sign) {
+		return false, expected
+	}
+	storeSignatureCache(key, ts+getSignatureSkewSeconds()+1, limit)
+	return true, expected
+}
+
 func canonicalRequestPath(u *url.URL) string {
 	path := u.EscapedPath()
 	if path == "" {
@@ -204,14 +393,14 @@ func readRequestBodyBytes(r io.ReadCloser) ([]byte, io.ReadCloser, error) {
 }
 
 func verifyHTTPRequestSignature(ts int64, nonce, sign, method, path string, bodyBytes []byte) bool {
+	bodyHash := hashBytesHex(bodyBytes)
+	signatureBase := buildHTTPSignatureString(ts, nonce, method, path, bodyHash)
 	if !isTimestampValid(ts) {
 		debugAuthf("[auth] http invalid timestamp: ts=%d method=%s path=%s", ts, method, path)
+		warnIfOnlySkewInvalid("HTTP request", method+" "+path, ts, signatureBase, sign)
 		return false
 	}
-	bodyHash := hashBytesHex(bodyBytes)
-	signatureBase := buildHTTPSignatureString(ts, nonce, method, path, bodyHash)
-	expected := computeSignatureHex(signatureBase)
-	if !verifySignature(expected, sign) {
+	if ok, expected := verifySignatureCached(signatureBase, ts, sign); !ok {
 		debugAuthf("[auth] http signature mismatch: method=%s path=%s ts=%d nonce=%s expected=%s got=%s bodyHash=%s",
 			method, path, ts, nonce, expected, sign, bodyHash)
 		return false
@@ -220,14 +409,14 @@ func verifyHTTPRequestSignature(ts int64, nonce, sign, method, path string, body
 }
 
 func verifyMessageSignature(data Message) bool {
+	bodyHash := hashJSONHex(data.Body)
+	signatureBase := buildMessageSignatureString(data.TS, data.Nonce, data.Type, bodyHash)
 	if !isTimestampValid(data.TS) {
 		debugAuthf("[auth] ws invalid timestamp: ts=%d type=%s", data.TS, data.Type)
+		warnIfOnlySkewInvalid("ws message", data.Type, data.TS, signatureBase, data.Sign)
 		return false
 	}
-	bodyHash := hashJSONHex(data.Body)
-	signatureBase := buildMessageSignatureString(data.TS, data.Nonce, data.Type, bodyHash)
-	expected := computeSignatureHex(signatureBase)
-	if !verifySignature(expected, data.Sign) {
+	if ok, expected := verifySignatureCached(signatureBase, data.TS, data.Sign); !ok {
 		debugAuthf("[auth] ws signature mismatch: type=%s ts=%d nonce=%s expected=%s got=%s bodyHash=%s",
 			data.Type, data.TS, data.Nonce, expected, data.Sign, bodyHash)
 		return false
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -43,6 +48,42 @@ func TestCheckAndStoreNonceAllowsReuseAfterTTL(t *testing.T) {
 	}
 }
 
+func TestCheckAndStoreNonceBoundedCapacity(t *testing.T) {
+	resetUsedNoncesForTest()
+	t.Cleanup(resetUsedNoncesForTest)
+
+	fill := func(expiresAt int64) {
+		usedNonces.Lock()
+		bucket := make(map[string]struct{}, maxStoredNonces)
+		for i := 0; i < maxStoredNonces; i++ {
+			key := fmt.Sprintf("ws:fill-%d", i)
+			usedNonces.store[key] = expiresAt
+			bucket[key] = struct{}{}
+		}
+		usedNonces.expiryBuckets[expiresAt] = bucket
+		usedNonces.Unlock()
+	}
+
+	overflowBefore := getNonceStoreStats(time.Now()).OverflowTotal
+	now := time.Now().Unix()
+	fill(now + nonceTTLSeconds)
+	if ok := checkAndStoreNonce("ws", "overflow"); ok {
+		t.Fatalf("expected new nonce to be rejected when cache is full of live entries")
+	}
+	if stats := getNonceStoreStats(time.Now()); stats.OverflowTotal != overflowBefore+1 || stats.Stored != maxStoredNonces {
+		t.Fatalf("unexpected stats after overflow: %+v", stats)
+	}
+
+	resetUsedNoncesForTest()
+	fill(now - 1)
+	if ok := checkAndStoreNonce("ws", "after-sweep"); !ok {
+		t.Fatalf("expected expired entries to be swept to make room")
+	}
+	if stats := getNonceStoreStats(time.Now()); stats.Stored != 1 {
+		t.Fatalf("expected only the new nonce after sweep, got %+v", stats)
+	}
+}
+
 func TestCleanupExpiredNonces(t *testing.T) {
 	resetUsedNoncesForTest()
 
@@ -77,3 +118,146 @@ func TestCleanupExpiredNonces(t *testing.T) {
 		t.Fatalf("fresh nonce should be kept")
 	}
 }
+
+func TestNonceStoreStatsAndClear(t *testing.T) {
+	resetUsedNoncesForTest()
+
+	usedNonces.Lock()
+	totalBefore := usedNonces.rejectedTotal
+	usedNonces.rejectedAt = []time.Time{time.Now().Add(-2 * nonceRejectWindow)}
+	usedNonces.Unlock()
+
+	checkAndStoreNonce("ws", "stats-1")
+	checkAndStoreNonce("http", "stats-2")
+	checkAndStoreNonce("ws", "stats-1")
+
+	stats := getNonceStoreStats(time.Now())
+	if stats.Stored != 2 {
+		t.Fatalf("expected 2 stored nonces, got %d", stats.Stored)
+	}
+	if stats.RecentRejected != 1 {
+		t.Fatalf("expected stale rejection to be pruned, got %d recent", stats.RecentRejected)
+	}
+	if stats.TotalRejected != totalBefore+1 {
+		t.Fatalf("expected total rejections to increase by 1, got %d", stats.TotalRejected-totalBefore)
+	}
+
+	if removed := clearNonceStore(); removed != 2 {
+		t.Fatalf("expected 2 removed nonces, got %d", removed)
+	}
+	if ok := checkAndStoreNonce("ws", "stats-1"); !ok {
+		t.Fatalf("nonce should be accepted after clearing the store")
+	}
+}
+
+func resetSignatureCacheForTest(t *testing.T, size int) {
+	t.Helper()
+	prevSize := getServerConfig().SignatureCacheSize
+	updateServerConfig(func(cfg *ServerConfig) { cfg.SignatureCacheSize = size })
+	reset := func() {
+		signatureCache.Lock()
+		signatureCache.entries = make(map[string]*list.Element)
+		signatureCache.order = list.New()
+		signatureCache.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.SignatureCacheSize = prevSize })
+		reset()
+	})
+}
+
+func signTestMessage(msg Message) Message {
+	msg.Sign = computeSignatureHex(buildMessageSignatureString(msg.TS, msg.Nonce, msg.Type, hashJSONHex(msg.Body)))
+	return msg
+}
+
+func TestVerifyMessageSignature_CacheKeepsNonceAndSignChecks(t *testing.T) {
+	resetUsedNoncesForTest()
+	resetSignatureCacheForTest(t, 2)
+
+	msg := signTestMessage(Message{Type: "control/devices", TS: time.Now().Unix(), Nonce: "cache-1"})
+	if !verifyMessageSignature(msg) {
+		t.Fatalf("valid message should verify")
+	}
+	if !lookupSignatureCache(signatureCacheKey(buildMessageSignatureString(msg.TS, msg.Nonce, msg.Type, ""), msg.Sign), time.Now().Unix()) {
+		t.Fatalf("verified signature should be cached")
+	}
+	if verifyMessageSignature(msg) {
+		t.Fatalf("cached signature must not bypass nonce replay protection")
+	}
+
+	tampered := msg
+	tampered.Nonce = "cache-2"
+	if verifyMessageSignature(tampered) {
+		t.Fatalf("signature for a different nonce should not verify from cache")
+	}
+}
+
+func TestSignatureCache_EvictsLeastRecentlyUsedAndExpired(t *testing.T) {
+	resetSignatureCacheForTest(t, 2)
+	now := time.Now().Unix()
+
+	storeSignatureCache("a", now+60, 2)
+	storeSignatureCache("b", now+60, 2)
+	lookupSignatureCache("a", now)
+	storeSignatureCache("c", now+60, 2)
+
+	if lookupSignatureCache("b", now) {
+		t.Fatalf("least recently used entry should be evicted")
+	}
+	if !lookupSignatureCache("a", now) || !lookupSignatureCache("c", now) {
+		t.Fatalf("recent entries should remain cached")
+	}
+	if lookupSignatureCache("a", now+60) {
+		t.Fatalf("expired entry should miss")
+	}
+}
+
+func TestToPasshashUsesSigningSecret(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.SigningSecret = "" })
+	mac := hmac.New(sha256.New, []byte("XXTouch"))
+	mac.Write([]byte("12345678"))
+	if got, want := toPasshash("12345678"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("expected default derivation %s, got %s", want, got)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.SigningSecret = "deployment-secret" })
+	mac = hmac.New(sha256.New, []byte("deployment-secret"))
+	mac.Write([]byte("12345678"))
+	if got, want := toPasshash("12345678"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Fatalf("expected signing secret derivation %s, got %s", want, got)
+	}
+}
+
+func TestVerifyMessageSignatureHonoursSignatureSkewSeconds(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+	resetUsedNoncesForTest()
+	resetSignatureCacheForTest(t, 0)
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.SignatureSkewSeconds = 10 })
+	stale := signTestMessage(Message{Type: "control/devices", TS: time.Now().Unix() - 30, Nonce: "skew-1"})
+	if verifyMessageSignature(stale) {
+		t.Fatalf("message outside a 10s window should be rejected")
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.SignatureSkewSeconds = 300 })
+	if !verifyMessageSignature(stale) {
+		t.Fatalf("message inside a 300s window should verify")
+	}
+	if got := getNonceTTLSeconds(); got != 600 {
+		t.Fatalf("expected nonce ttl to cover the whole window, got %d", got)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.SignatureSkewSeconds = 0 })
+	if got := getSignatureSkewSeconds(); got != authSkewSeconds {
+		t.Fatalf("expected fallback to %d, got %d", authSkewSeconds, got)
+	}
+	if got := getNonceTTLSeconds(); got != nonceTTLSeconds {
+		t.Fatalf("expected default nonce ttl %d, got %d", nonceTTLSeconds, got)
+	}
+}
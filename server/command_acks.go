@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const defaultCommandAckTimeout = 10 * time.Second
+
+// pendingCommand tracks a control/command waiting for device replies.
+type pendingCommand struct {
+	Controller *SafeConn
+	Type       string
+	Pending    map[string]bool // devices that have not replied yet
+	Timer      *time.Timer
+	// Collect receives the replies of a POST /api/control/command-collect instead of
+	// Controller; it has room for one result per pending device.
+	Collect chan commandCollectResult
+}
+
+// pendingCommands maps RequestID to its pending command. Guarded by mu.
+var pendingCommands = make(map[string]*pendingCommand)
+
+func commandAckTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultCommandAckTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// trackPendingCommand registers requestID for the connected devices among udids and arms
+// its timeout. Commands without a request ID cannot be correlated and are not tracked.
+func trackPendingCommand(controller *SafeConn, requestID, cmdType string, udids []string, timeout time.Duration) {
+	if controller == nil || requestID == "" {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	pending := make(map[string]bool, len(udids))
+	for _, udid := range udids {
+		if _, connected := deviceLinks[udid]; connected {
+			pending[udid] = true
+		}
+	}
+	if existing, ok := pendingCommands[requestID]; ok {
+		existing.Timer.Stop()
+		delete(pendingCommands, requestID)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	cmd := &pendingCommand{Controller: controller, Type: cmdType, Pending: pending}
+	cmd.Timer = time.AfterFunc(timeout, func() {
+		expirePendingCommand(requestID, cmd)
+	})
+	pendingCommands[requestID] = cmd
+}
+
+// expirePendingCommand reports devices that never replied to the originating controller.
+func expirePendingCommand(requestID string, cmd *pendingCommand) {
+	mu.Lock()
+	if pendingCommands[requestID] != cmd {
+		mu.Unlock()
+		return
+	}
+	delete(pendingCommands, requestID)
+	if cmd.Collect != nil {
+		mu.Unlock()
+		return
+	}
+	devices := make([]string, 0, len(cmd.Pending))
+	for udid := range cmd.Pending {
+		devices = append(devices, udid)
+	}
+	mu.Unlock()
+	sort.Strings(devices)
+
+	sendMessageAsync(cmd.Controller, Message{
+		Type:      "control/command/timeout",
+		RequestID: requestID,
+		Body: map[string]interface{}{
+			"type":    cmd.Type,
+			"devices": devices,
+		},
+	})
+}
+
+// acknowledgePendingCommand sends control/command/ack when udid replies to a tracked command.
+func acknowledgePendingCommand(udid string, data Message) {
+	if data.RequestID == "" {
+		return
+	}
+
+	mu.Lock()
+	cmd, ok := pendingCommands[data.RequestID]
+	if !ok || !cmd.Pending[udid] {
+		mu.Unlock()
+		return
+	}
+	delete(cmd.Pending, udid)
+	if len(cmd.Pending) == 0 {
+		cmd.Timer.Stop()
+		delete(pendingCommands, data.RequestID)
+	}
+	if cmd.Collect != nil {
+		// Sent under mu so finishCollectedCommand sees every reply it no longer waits for.
+		cmd.Collect <- newCommandCollectResult(udid, data)
+		mu.Unlock()
+		return
+	}
+	mu.Unlock()
+
+	sendMessageAsync(cmd.Controller, Message{
+		Type:      "control/command/ack",
+		RequestID: data.RequestID,
+		UDID:      udid,
+		Body: map[string]interface{}{
+			"type":  cmd.Type,
+			"reply": data.Type,
+		},
+	})
+}
+
+// failPendingCommandsForDeviceLocked removes udid from pending commands and returns the
+// timeout notices to send for it. Caller must hold mu.Lock.
+func failPendingCommandsForDeviceLocked(udid string) []pendingCommandNotice {
+	var notices []pendingCommandNotice
+	for requestID, cmd := range pendingCommands {
+		if !cmd.Pending[udid] {
+			continue
+		}
+		delete(cmd.Pending, udid)
+		if len(cmd.Pending) == 0 {
+			cmd.Timer.Stop()
+			delete(pendingCommands, requestID)
+		}
+		if cmd.Collect != nil {
+			cmd.Collect <- commandCollectResult{UDID: udid, Status: "disconnected"}
+			continue
+		}
+		notices = append(notices, pendingCommandNotice{
+			conn: cmd.Controller,
+			msg: Message{
+				Type:      "control/command/timeout",
+				RequestID: requestID,
+				Body: map[string]interface{}{
+					"type":    cmd.Type,
+					"devices": []string{udid},
+					"reason":  "device disconnected",
+				},
+			},
+		})
+	}
+	return notices
+}
+
+// dropPendingCommandsForControllerLocked forgets commands sent by a disconnected controller.
+// Caller must hold mu.Lock.
+func dropPendingCommandsForControllerLocked(conn *SafeConn) {
+	for requestID, cmd := range pendingCommands {
+		if cmd.Controller == conn {
+			cmd.Timer.Stop()
+			delete(pendingCommands, requestID)
+		}
+	}
+}
+
+type pendingCommandNotice struct {
+	conn *SafeConn
+	msg  Message
+}
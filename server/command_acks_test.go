@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setupCommandAckFixture(t *testing.T) *SafeConn {
+	t.Helper()
+	deviceConn, _ := newTestWebSocketPair(t)
+
+	mu.Lock()
+	linksBackup, linksMapBackup := deviceLinks, deviceLinksMap
+	controllersBackup, pendingBackup := controllers, pendingCommands
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn}
+	deviceLinksMap = map[*SafeConn]string{deviceConn: "d1"}
+	controllers = map[*SafeConn]bool{}
+	pendingCommands = make(map[string]*pendingCommand)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		for _, cmd := range pendingCommands {
+			cmd.Timer.Stop()
+		}
+		deviceLinks, deviceLinksMap = linksBackup, linksMapBackup
+		controllers, pendingCommands = controllersBackup, pendingBackup
+		mu.Unlock()
+	})
+	return deviceConn
+}
+
+func TestPendingCommand_AckOnDeviceReply(t *testing.T) {
+	deviceConn := setupCommandAckFixture(t)
+	controllerConn, client := newTestWebSocketPair(t)
+
+	trackPendingCommand(controllerConn, "r1", "app/open", []string{"d1", "offline"}, time.Minute)
+
+	mu.RLock()
+	cmd := pendingCommands["r1"]
+	mu.RUnlock()
+	if cmd == nil || !reflect.DeepEqual(cmd.Pending, map[string]bool{"d1": true}) {
+		t.Fatalf("expected only connected device to be pending, got %#v", cmd)
+	}
+
+	if err := handleMessage(deviceConn, Message{Type: "app/open", RequestID: "r1"}); err != nil {
+		t.Fatalf("handle reply failed: %v", err)
+	}
+	msg := readTestMessage(t, client)
+	if msg.Type != "control/command/ack" || msg.RequestID != "r1" || msg.UDID != "d1" {
+		t.Fatalf("unexpected ack message: %#v", msg)
+	}
+	mu.RLock()
+	_, stillPending := pendingCommands["r1"]
+	mu.RUnlock()
+	if stillPending {
+		t.Fatalf("command should be cleared once all devices acked")
+	}
+}
+
+func TestPendingCommand_TimeoutReportsMissingDevices(t *testing.T) {
+	setupCommandAckFixture(t)
+	controllerConn, client := newTestWebSocketPair(t)
+
+	trackPendingCommand(controllerConn, "r2", "app/open", []string{"d1"}, 20*time.Millisecond)
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "control/command/timeout" || msg.RequestID != "r2" {
+		t.Fatalf("unexpected timeout message: %#v", msg)
+	}
+	body, _ := msg.Body.(map[string]interface{})
+	if !reflect.DeepEqual(body["devices"], []interface{}{"d1"}) {
+		t.Fatalf("expected d1 in timeout devices, got %#v", body["devices"])
+	}
+}
+
+func TestPendingCommand_DeviceDisconnectFailsPending(t *testing.T) {
+	setupCommandAckFixture(t)
+	controllerConn, _ := newTestWebSocketPair(t)
+
+	trackPendingCommand(controllerConn, "r3", "app/open", []string{"d1"}, time.Minute)
+
+	mu.Lock()
+	notices := failPendingCommandsForDeviceLocked("d1")
+	_, stillPending := pendingCommands["r3"]
+	mu.Unlock()
+	if len(notices) != 1 || notices[0].msg.Type != "control/command/timeout" || notices[0].conn != controllerConn {
+		t.Fatalf("expected one timeout notice for controller, got %#v", notices)
+	}
+	if stillPending {
+		t.Fatalf("pending command should be removed after its last device disconnects")
+	}
+}
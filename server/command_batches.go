@@ -0,0 +1,228 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// batchReplyKey identifies one expected device reply within a control/commands batch.
+type batchReplyKey struct {
+	RequestID string
+	UDID      string
+}
+
+// batchCommandResult is one entry of a commands/result message.
+type batchCommandResult struct {
+	RequestID string `json:"requestId"`
+	UDID      string `json:"udid"`
+	Type      string `json:"type"`
+	Reply     string `json:"reply,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Status    string `json:"status"` // "ok", "error", "timeout" or "disconnected"
+}
+
+// pendingBatch aggregates device replies for a control/commands batch with a batchId.
+type pendingBatch struct {
+	Controller *SafeConn
+	Types      map[string]string // requestID -> command type
+	Pending    map[batchReplyKey]bool
+	Results    []batchCommandResult
+	Timer      *time.Timer
+}
+
+// pendingBatches maps batch ID to its pending batch and batchRequestIDs maps each
+// command requestId back to its batch ID. Both guarded by mu.
+var (
+	pendingBatches  = make(map[string]*pendingBatch)
+	batchRequestIDs = make(map[string]string)
+)
+
+// trackPendingBatch registers the commands of a batch that carry a requestId, expecting
+// one reply per connected device among udids. Replies are reported together as
+// commands/result once all arrive or timeout elapses.
+func trackPendingBatch(controller *SafeConn, batchID string, commands []Command, udids []string, timeout time.Duration) {
+	if controller == nil || batchID == "" {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if existing, ok := pendingBatches[batchID]; ok {
+		existing.Timer.Stop()
+		forgetPendingBatchLocked(batchID, existing)
+	}
+
+	batch := &pendingBatch{
+		Controller: controller,
+		Types:      make(map[string]string),
+		Pending:    make(map[batchReplyKey]bool),
+	}
+	for _, cmd := range commands {
+		if cmd.RequestID == "" {
+			continue
+		}
+		batch.Types[cmd.RequestID] = cmd.Type
+		for _, udid := range udids {
+			if _, connected := deviceLinks[udid]; connected {
+				batch.Pending[batchReplyKey{RequestID: cmd.RequestID, UDID: udid}] = true
+			}
+		}
+	}
+	if len(batch.Pending) == 0 {
+		return
+	}
+
+	for requestID := range batch.Types {
+		batchRequestIDs[requestID] = batchID
+	}
+	batch.Timer = time.AfterFunc(timeout, func() {
+		expirePendingBatch(batchID, batch)
+	})
+	pendingBatches[batchID] = batch
+}
+
+// forgetPendingBatchLocked removes a batch and its requestId index. Caller must hold mu.Lock.
+func forgetPendingBatchLocked(batchID string, batch *pendingBatch) {
+	delete(pendingBatches, batchID)
+	for requestID := range batch.Types {
+		if batchRequestIDs[requestID] == batchID {
+			delete(batchRequestIDs, requestID)
+		}
+	}
+}
+
+// closePendingBatchLocked marks every outstanding reply with status and returns the
+// commands/result message; "complete" is false if any device timed out or disconnected.
+// Caller must hold mu.Lock.
+func closePendingBatchLocked(batchID string, batch *pendingBatch, status string) Message {
+	for key := range batch.Pending {
+		batch.Results = append(batch.Results, batchCommandResult{
+			RequestID: key.RequestID,
+			UDID:      key.UDID,
+			Type:      batch.Types[key.RequestID],
+			Status:    status,
+		})
+	}
+	batch.Pending = nil
+	forgetPendingBatchLocked(batchID, batch)
+
+	results := batch.Results
+	complete := true
+	for _, result := range results {
+		if result.Status == "timeout" || result.Status == "disconnected" {
+			complete = false
+			break
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].RequestID != results[j].RequestID {
+			return results[i].RequestID < results[j].RequestID
+		}
+		return results[i].UDID < results[j].UDID
+	})
+	return Message{
+		Type:      "commands/result",
+		RequestID: batchID,
+		Body: map[string]interface{}{
+			"batchId":  batchID,
+			"complete": complete,
+			"results":  results,
+		},
+	}
+}
+
+func expirePendingBatch(batchID string, batch *pendingBatch) {
+	mu.Lock()
+	if pendingBatches[batchID] != batch {
+		mu.Unlock()
+		return
+	}
+	msg := closePendingBatchLocked(batchID, batch, "timeout")
+	mu.Unlock()
+
+	sendMessageAsync(batch.Controller, msg)
+}
+
+// collectBatchReply records udid's reply to a batched command, sending commands/result
+// when it was the last one outstanding.
+func collectBatchReply(udid string, data Message) {
+	if data.RequestID == "" {
+		return
+	}
+
+	mu.Lock()
+	batchID, ok := batchRequestIDs[data.RequestID]
+	if !ok {
+		mu.Unlock()
+		return
+	}
+	batch := pendingBatches[batchID]
+	key := batchReplyKey{RequestID: data.RequestID, UDID: udid}
+	if batch == nil || !batch.Pending[key] {
+		mu.Unlock()
+		return
+	}
+	delete(batch.Pending, key)
+	result := batchCommandResult{
+		RequestID: data.RequestID,
+		UDID:      udid,
+		Type:      batch.Types[data.RequestID],
+		Reply:     data.Type,
+		Error:     data.Error,
+		Status:    "ok",
+	}
+	if data.Error != "" {
+		result.Status = "error"
+	}
+	batch.Results = append(batch.Results, result)
+	if len(batch.Pending) > 0 {
+		mu.Unlock()
+		return
+	}
+	batch.Timer.Stop()
+	msg := closePendingBatchLocked(batchID, batch, "")
+	mu.Unlock()
+
+	sendMessageAsync(batch.Controller, msg)
+}
+
+// failPendingBatchesForDeviceLocked marks udid's outstanding batch replies as disconnected
+// and returns the commands/result notices of batches that are now finished.
+// Caller must hold mu.Lock.
+func failPendingBatchesForDeviceLocked(udid string) []pendingCommandNotice {
+	var notices []pendingCommandNotice
+	for batchID, batch := range pendingBatches {
+		for key := range batch.Pending {
+			if key.UDID != udid {
+				continue
+			}
+			delete(batch.Pending, key)
+			batch.Results = append(batch.Results, batchCommandResult{
+				RequestID: key.RequestID,
+				UDID:      udid,
+				Type:      batch.Types[key.RequestID],
+				Status:    "disconnected",
+			})
+		}
+		if len(batch.Pending) == 0 {
+			batch.Timer.Stop()
+			notices = append(notices, pendingCommandNotice{
+				conn: batch.Controller,
+				msg:  closePendingBatchLocked(batchID, batch, ""),
+			})
+		}
+	}
+	return notices
+}
+
+// dropPendingBatchesForControllerLocked forgets batches sent by a disconnected controller.
+// Caller must hold mu.Lock.
+func dropPendingBatchesForControllerLocked(conn *SafeConn) {
+	for batchID, batch := range pendingBatches {
+		if batch.Controller == conn {
+			batch.Timer.Stop()
+			forgetPendingBatchLocked(batchID, batch)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func setupCommandBatchFixture(t *testing.T) {
+	t.Helper()
+	setupCommandAckFixture(t)
+	deviceConn2, _ := newTestWebSocketPair(t)
+
+	mu.Lock()
+	deviceLinks["d2"] = deviceConn2
+	deviceLinksMap[deviceConn2] = "d2"
+	batchesBackup, requestIDsBackup := pendingBatches, batchRequestIDs
+	pendingBatches = make(map[string]*pendingBatch)
+	batchRequestIDs = make(map[string]string)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		for _, batch := range pendingBatches {
+			batch.Timer.Stop()
+		}
+		pendingBatches, batchRequestIDs = batchesBackup, requestIDsBackup
+		mu.Unlock()
+	})
+}
+
+func batchResults(t *testing.T, msg Message) []interface{} {
+	t.Helper()
+	body, _ := msg.Body.(map[string]interface{})
+	results, _ := body["results"].([]interface{})
+	return results
+}
+
+func TestCommandBatch_ResultAfterAllReplies(t *testing.T) {
+	setupCommandBatchFixture(t)
+	controllerConn, client := newTestWebSocketPair(t)
+
+	commands := []Command{
+		{Type: "app/open", RequestID: "c1"},
+		{Type: "screen/lock"},
+		{Type: "app/close", RequestID: "c2"},
+	}
+	trackPendingBatch(controllerConn, "b1", commands, []string{"d1", "d2", "offline"}, time.Minute)
+
+	collectBatchReply("d1", Message{Type: "app/open", RequestID: "c1"})
+	collectBatchReply("d2", Message{Type: "app/open", RequestID: "c1", Error: "not installed"})
+	collectBatchReply("d1", Message{Type: "app/close", RequestID: "c2"})
+	mu.RLock()
+	_, stillPending := pendingBatches["b1"]
+	mu.RUnlock()
+	if !stillPending {
+		t.Fatalf("batch should wait for the remaining reply")
+	}
+	collectBatchReply("d2", Message{Type: "app/close", RequestID: "c2"})
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "commands/result" || msg.RequestID != "b1" {
+		t.Fatalf("unexpected batch result message: %#v", msg)
+	}
+	body, _ := msg.Body.(map[string]interface{})
+	if body["complete"] != true {
+		t.Fatalf("expected complete batch, got %#v", body)
+	}
+	results := batchResults(t, msg)
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %#v", results)
+	}
+	second, _ := results[1].(map[string]interface{})
+	if second["requestId"] != "c1" || second["udid"] != "d2" || second["status"] != "error" || second["error"] != "not installed" {
+		t.Fatalf("unexpected error result: %#v", second)
+	}
+
+	mu.RLock()
+	remaining := len(pendingBatches) + len(batchRequestIDs)
+	mu.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("batch state should be cleared after the result")
+	}
+}
+
+func TestCommandBatch_TimeoutMarksMissingReplies(t *testing.T) {
+	setupCommandBatchFixture(t)
+	controllerConn, client := newTestWebSocketPair(t)
+
+	trackPendingBatch(controllerConn, "b2", []Command{{Type: "app/open", RequestID: "c3"}}, []string{"d1", "d2"}, 20*time.Millisecond)
+	collectBatchReply("d1", Message{Type: "app/open", RequestID: "c3"})
+
+	msg := readTestMessage(t, client)
+	body, _ := msg.Body.(map[string]interface{})
+	if msg.Type != "commands/result" || body["complete"] != false {
+		t.Fatalf("unexpected timeout result: %#v", msg)
+	}
+	results := batchResults(t, msg)
+	last, _ := results[len(results)-1].(map[string]interface{})
+	if len(results) != 2 || last["udid"] != "d2" || last["status"] != "timeout" {
+		t.Fatalf("expected d2 to time out, got %#v", results)
+	}
+}
+
+func TestCommandBatch_DeviceDisconnectFinishesBatch(t *testing.T) {
+	setupCommandBatchFixture(t)
+	controllerConn, _ := newTestWebSocketPair(t)
+
+	trackPendingBatch(controllerConn, "b3", []Command{{Type: "app/open", RequestID: "c4"}}, []string{"d1", "d2"}, time.Minute)
+	collectBatchReply("d2", Message{Type: "app/open", RequestID: "c4"})
+
+	mu.Lock()
+	notices := failPendingBatchesForDeviceLocked("d1")
+	mu.Unlock()
+	if len(notices) != 1 || notices[0].conn != controllerConn {
+		t.Fatalf("expected one notice for the controller, got %#v", notices)
+	}
+	body, _ := notices[0].msg.Body.(map[string]interface{})
+	results, _ := body["results"].([]batchCommandResult)
+	if body["complete"] != false || len(results) != 2 || results[0].UDID != "d1" || results[0].Status != "disconnected" {
+		t.Fatalf("unexpected disconnect result: %#v", body)
+	}
+}
+
+func TestParseControlCommandsBody_BatchFields(t *testing.T) {
+	cmds, err := parseControlCommandsBody(map[string]interface{}{
+		"devices":  []interface{}{"d1"},
+		"batchId":  "b4",
+		"timeout":  float64(5),
+		"commands": []interface{}{map[string]interface{}{"type": "app/open", "requestId": "c5"}},
+	})
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if cmds.BatchID != "b4" || cmds.Timeout != 5 || len(cmds.Commands) != 1 || cmds.Commands[0].RequestID != "c5" {
+		t.Fatalf("unexpected parsed commands: %#v", cmds)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	maxCommandCollectDevices = 200
+	maxCommandCollectTimeout = 60 * time.Second
+)
+
+// commandCollectResult is one device's outcome in POST /api/control/command-collect.
+type commandCollectResult struct {
+	UDID   string      `json:"-"`
+	Status string      `json:"status"` // "ok", "error", "timeout", "offline", "disconnected", "leased" or "unsupported"
+	Reply  string      `json:"reply,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type commandCollectRequest struct {
+	Devices      []string    `json:"devices"`
+	View         string      `json:"view"`
+	Groups       []string    `json:"groups"`
+	Tags         []string    `json:"tags"`
+	Type         string      `json:"type"`
+	Body         interface{} `json:"body"`
+	Timeout      int         `json:"timeout"` // seconds to wait for replies (default 10, max 60)
+	ConfirmToken string      `json:"confirmToken"`
+}
+
+func newCommandCollectResult(udid string, data Message) commandCollectResult {
+	result := commandCollectResult{UDID: udid, Status: "ok", Reply: data.Type, Body: data.Body, Error: data.Error}
+	if data.Error != "" {
+		result.Status = "error"
+	}
+	return result
+}
+
+// trackCollectedCommand registers requestID for the connected devices among udids and
+// returns them; their replies arrive on the command's Collect channel.
+func trackCollectedCommand(requestID, cmdType string, udids []string, timeout time.Duration) (*pendingCommand, []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	cmd := &pendingCommand{Type: cmdType, Pending: make(map[string]bool, len(udids))}
+	online := make([]string, 0, len(udids))
+	for _, udid := range udids {
+		if _, connected := deviceLinks[udid]; connected {
+			cmd.Pending[udid] = true
+			online = append(online, udid)
+		}
+	}
+	if len(online) == 0 {
+		return nil, nil
+	}
+	cmd.Collect = make(chan commandCollectResult, len(online))
+	cmd.Timer = time.AfterFunc(timeout, func() {
+		expirePendingCommand(requestID, cmd)
+	})
+	pendingCommands[requestID] = cmd
+	return cmd, online
+}
+
+// finishCollectedCommand stops waiting for requestID and adds the replies received so
+// far to results, marking devices that never replied as timed out.
+func finishCollectedCommand(requestID string, cmd *pendingCommand, results map[string]commandCollectResult) {
+	mu.Lock()
+	if pendingCommands[requestID] == cmd {
+		cmd.Timer.Stop()
+		delete(pendingCommands, requestID)
+	}
+	unanswered := make([]string, 0, len(cmd.Pending))
+	for udid := range cmd.Pending {
+		unanswered = append(unanswered, udid)
+	}
+	mu.Unlock()
+
+	for drained := false; !drained; {
+		select {
+		case result := <-cmd.Collect:
+			results[result.UDID] = result
+		default:
+			drained = true
+		}
+	}
+	for _, udid := range unanswered {
+		if _, ok := results[udid]; !ok {
+			results[udid] = commandCollectResult{UDID: udid, Status: "timeout"}
+		}
+	}
+}
+
+// commandCollectHandler handles POST /api/control/command-collect
+// Sends one command to the target devices and waits for each reply, returning them keyed
+// by UDID.
+func commandCollectHandler(c *gin.Context) {
+	var req commandCollectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	cmdType := strings.TrimSpace(req.Type)
+	if cmdType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type is required"})
+		return
+	}
+	devices := uniqueDeviceIDs(expandDevicesWithSelectors(expandDevicesWithView(req.Devices, req.View), req.Groups, req.Tags))
+	if len(devices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "devices are required"})
+		return
+	}
+	if len(devices) > maxCommandCollectDevices {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d devices per request", maxCommandCollectDevices)})
+		return
+	}
+	if isRebootCommand(cmdType) {
+		if err := checkConfirmToken(confirmOpDeviceReboot, deviceRebootConfirmScope(devices), len(devices), req.ConfirmToken); err != nil {
+			respondConfirmationError(c, len(devices), err)
+			return
+		}
+	}
+	timeout := min(commandAckTimeout(req.Timeout), maxCommandCollectTimeout)
+
+	results := make(map[string]commandCollectResult, len(devices))
+	devices, leased := filterLeasedDevicesFor("", devices)
+	for _, udid := range leased {
+		results[udid] = commandCollectResult{Status: "leased", Error: errDeviceLeased.Error()}
+	}
+	devices, remote := splitFederatedDevices(devices)
+	for _, regionDevices := range remote {
+		for _, udid := range regionDevices {
+			results[udid] = commandCollectResult{Status: "unsupported", Error: "devices of upstream servers are not supported"}
+		}
+	}
+
+	requestID := uuid.New().String()
+	recordAudit(auditEntry{
+		RemoteAddr: c.ClientIP(),
+		Type:       "control/command-collect",
+		Commands:   []string{cmdType},
+		Devices:    devices,
+		RequestID:  requestID,
+	})
+	cmd, online := trackCollectedCommand(requestID, cmdType, devices, timeout)
+	if cmd != nil {
+		if _, err := dispatchCommandToDevices(online, cmdType, req.Body, requestID, ""); err != nil {
+			finishCollectedCommand(requestID, cmd, results)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send command"})
+			return
+		}
+
+		deadline := time.NewTimer(timeout)
+	wait:
+		for received := 0; received < len(online); received++ {
+			select {
+			case result := <-cmd.Collect:
+				results[result.UDID] = result
+			case <-deadline.C:
+				break wait
+			case <-c.Request.Context().Done():
+				break wait
+			}
+		}
+		deadline.Stop()
+		finishCollectedCommand(requestID, cmd, results)
+	}
+	for _, udid := range devices {
+		if _, ok := results[udid]; !ok {
+			results[udid] = commandCollectResult{Status: "offline"}
+		}
+	}
+
+	complete := true
+	for _, result := range results {
+		if result.Status != "ok" && result.Status != "error" {
+			complete = false
+			break
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"requestId": requestID,
+		"type":      cmdType,
+		"complete":  complete,
+		"results":   results,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestCommandCollect_GathersRepliesAndMarksTimeouts(t *testing.T) {
+	setupCommandAckFixture(t)
+	replier, replierClient := newTestWebSocketPair(t)
+	silent, _ := newTestWebSocketPair(t)
+	mu.Lock()
+	deviceLinks = map[string]*SafeConn{"d1": replier, "d2": silent}
+	deviceLinksMap = map[*SafeConn]string{replier: "d1", silent: "d2"}
+	mu.Unlock()
+
+	go func() {
+		var cmd Message
+		if err := replierClient.ReadJSON(&cmd); err != nil {
+			return
+		}
+		_ = handleMessage(replier, Message{Type: cmd.Type, RequestID: cmd.RequestID, Body: map[string]interface{}{"value": "42"}})
+	}()
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/control/command-collect", map[string]interface{}{
+		"devices": []string{"d1", "d2", "gone"},
+		"type":    "system/config/get",
+		"timeout": 1,
+	}, commandCollectHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		RequestID string                          `json:"requestId"`
+		Complete  bool                            `json:"complete"`
+		Results   map[string]commandCollectResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if resp.Complete || resp.RequestID == "" {
+		t.Fatalf("expected an incomplete result with a requestId, got %s", w.Body.String())
+	}
+	got := resp.Results["d1"]
+	if body, _ := got.Body.(map[string]interface{}); got.Status != "ok" || got.Reply != "system/config/get" || body["value"] != "42" {
+		t.Fatalf("unexpected d1 result: %+v", got)
+	}
+	if resp.Results["d2"].Status != "timeout" || resp.Results["gone"].Status != "offline" {
+		t.Fatalf("unexpected results: %+v", resp.Results)
+	}
+
+	mu.RLock()
+	_, stillPending := pendingCommands[resp.RequestID]
+	mu.RUnlock()
+	if stillPending {
+		t.Fatalf("collected command should be forgotten once the request returns")
+	}
+}
+
+func TestCommandCollect_RejectsTooManyDevices(t *testing.T) {
+	devices := make([]string, maxCommandCollectDevices+1)
+	for i := range devices {
+		devices[i] = "d" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/control/command-collect", map[string]interface{}{
+		"devices": devices,
+		"type":    "system/config/get",
+	}, commandCollectHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for too many devices, got %d", w.Code)
+	}
+}
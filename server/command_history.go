@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// commandHistoryResultMaxBytes caps the reply body kept per entry; larger results (such
+// as screenshots) are dropped and the entry is marked resultTruncated.
+const commandHistoryResultMaxBytes = 4096
+
+// commandHistoryRecord is one line of <dataDir>/command_history/<udid>.jsonl. A "sent"
+// record is written when a command goes out and a "result" record when the device replies
+// to it with the same requestId; queries merge the two.
+type commandHistoryRecord struct {
+	Kind            string      `json:"kind"`
+	RequestID       string      `json:"requestId,omitempty"`
+	Type            string      `json:"type,omitempty"`
+	ControllerID    string      `json:"controllerId,omitempty"`
+	At              int64       `json:"at"`
+	Reply           string      `json:"reply,omitempty"`
+	Result          interface{} `json:"result,omitempty"`
+	ResultTruncated bool        `json:"resultTruncated,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+// commandHistoryEntry is one command in GET /api/devices/command-history.
+type commandHistoryEntry struct {
+	RequestID       string      `json:"requestId,omitempty"`
+	Type            string      `json:"type"`
+	ControllerID    string      `json:"controllerId,omitempty"`
+	SentAt          int64       `json:"sentAt"`
+	RepliedAt       int64       `json:"repliedAt,omitempty"`
+	Reply           string      `json:"reply,omitempty"`
+	Result          interface{} `json:"result,omitempty"`
+	ResultTruncated bool        `json:"resultTruncated,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+type commandHistoryKey struct {
+	UDID      string
+	RequestID string
+}
+
+// commandHistory guards the history files. lines counts records per file once it has been
+// read; open holds sent commands still waiting for a reply.
+var commandHistory = struct {
+	sync.Mutex
+	lines map[string]int
+	open  map[commandHistoryKey]bool
+}{
+	lines: make(map[string]int),
+	open:  make(map[commandHistoryKey]bool),
+}
+
+func commandHistoryEnabled() bool {
+	return getServerConfig().CommandHistoryLimit > 0
+}
+
+func commandHistoryPath(udid string) string {
+	return filepath.Join(getServerConfig().DataDir, "command_history", udid+".jsonl")
+}
+
+// readCommandHistoryLines returns the raw (possibly sealed) lines of a history file.
+func readCommandHistoryLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// appendCommandHistoryLocked appends rec to udid's history and trims the file back to
+// CommandHistoryLimit records once it has grown half as large again. Caller must hold
+// commandHistory.
+func appendCommandHistoryLocked(udid string, rec commandHistoryRecord) error {
+	if err := validateFileName(udid); err != nil {
+		return fmt.Errorf("invalid udid for command history: %q", udid)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line, err := sealDeviceDataLine(string(data) + "\n")
+	if err != nil {
+		return err
+	}
+
+	path := commandHistoryPath(udid)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	count, known := commandHistory.lines[udid]
+	if !known {
+		existing, err := readCommandHistoryLines(path)
+		if err != nil {
+			return err
+		}
+		count = len(existing)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(line)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	count++
+
+	limit := getServerConfig().CommandHistoryLimit
+	if count > limit+limit/2 {
+		lines, err := readCommandHistoryLines(path)
+		if err != nil {
+			return err
+		}
+		if len(lines) > limit {
+			lines = lines[len(lines)-limit:]
+		}
+		if err := writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		count = len(lines)
+	}
+	commandHistory.lines[udid] = count
+	return nil
+}
+
+// recordCommandsSent writes a sent record for cmdType to each of udids. Commands with a
+// requestId are remembered so the device's reply can be recorded as their result.
+func recordCommandsSent(udids []string, cmdType, requestID, controllerID string) {
+	if !commandHistoryEnabled() || len(udids) == 0 {
+		return
+	}
+	rec := commandHistoryRecord{
+		Kind:         "sent",
+		RequestID:    requestID,
+		Type:         cmdType,
+		ControllerID: controllerID,
+		At:           time.Now().UnixMilli(),
+	}
+
+	commandHistory.Lock()
+	defer commandHistory.Unlock()
+	for _, udid := range udids {
+		if err := appendCommandHistoryLocked(udid, rec); err != nil {
+			slog.Warn("Failed to record command history", "udid", udid, "error", err)
+			continue
+		}
+		if requestID != "" {
+			commandHistory.open[commandHistoryKey{UDID: udid, RequestID: requestID}] = true
+		}
+	}
+}
+
+// recordCommandResult writes udid's reply to a recorded command. Replies to commands that
+// were not recorded, or that already have a result, are ignored.
+func recordCommandResult(udid string, data Message) {
+	if data.RequestID == "" {
+		return
+	}
+	key := commandHistoryKey{UDID: udid, RequestID: data.RequestID}
+
+	commandHistory.Lock()
+	defer commandHistory.Unlock()
+	if !commandHistory.open[key] {
+		return
+	}
+	delete(commandHistory.open, key)
+	if !commandHistoryEnabled() {
+		return
+	}
+
+	rec := commandHistoryRecord{
+		Kind:      "result",
+		RequestID: data.RequestID,
+		At:        time.Now().UnixMilli(),
+		Reply:     data.Type,
+		Error:     data.Error,
+	}
+	if data.Body != nil {
+		if encoded, err := json.Marshal(data.Body); err == nil && len(encoded) <= commandHistoryResultMaxBytes {
+			rec.Result = data.Body
+		} else {
+			rec.ResultTruncated = true
+		}
+	}
+	if err := appendCommandHistoryLocked(udid, rec); err != nil {
+		slog.Warn("Failed to record command result", "udid", udid, "error", err)
+	}
+}
+
+// forgetOpenCommandHistory stops waiting for replies from a disconnected device; its
+// unanswered commands stay in the history without a result.
+func forgetOpenCommandHistory(udid string) {
+	commandHistory.Lock()
+	for key := range commandHistory.open {
+		if key.UDID == udid {
+			delete(commandHistory.open, key)
+		}
+	}
+	commandHistory.Unlock()
+}
+
+// loadCommandHistory returns udid's commands sent at or after since (unix ms), oldest
+// first, optionally filtered by type and capped to the most recent limit entries.
+func loadCommandHistory(udid, cmdType string, since int64, limit int) ([]commandHistoryEntry, error) {
+	commandHistory.Lock()
+	lines, err := readCommandHistoryLines(commandHistoryPath(udid))
+	commandHistory.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]commandHistoryEntry, 0)
+	byRequestID := make(map[string]int)
+	for _, line := range lines {
+		plain, err := openDeviceData([]byte(line))
+		if err != nil {
+			return nil, err
+		}
+		var rec commandHistoryRecord
+		if err := json.Unmarshal(plain, &rec); err != nil {
+			continue
+		}
+		switch rec.Kind {
+		case "sent":
+			if rec.At < since || (cmdType != "" && rec.Type != cmdType) {
+				continue
+			}
+			if rec.RequestID != "" {
+				byRequestID[rec.RequestID] = len(entries)
+			}
+			entries = append(entries, commandHistoryEntry{
+				RequestID:    rec.RequestID,
+				Type:         rec.Type,
+				ControllerID: rec.ControllerID,
+				SentAt:       rec.At,
+			})
+		case "result":
+			i, ok := byRequestID[rec.RequestID]
+			if !ok {
+				continue
+			}
+			delete(byRequestID, rec.RequestID)
+			entries[i].RepliedAt = rec.At
+			entries[i].Reply = rec.Reply
+			entries[i].Result = rec.Result
+			entries[i].ResultTruncated = rec.ResultTruncated
+			entries[i].Error = rec.Error
+		}
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+// commandHistoryHandler handles GET /api/devices/command-history
+func commandHistoryHandler(c *gin.Context) {
+	udid := strings.TrimSpace(c.Query("udid"))
+	if udid == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "udid is required"})
+		return
+	}
+	if err := validateFileName(udid); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid udid"})
+		return
+	}
+	var since int64
+	if value := c.Query("since"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a unix timestamp in milliseconds"})
+			return
+		}
+		since = parsed
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative integer"})
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := loadCommandHistory(udid, strings.TrimSpace(c.Query("type")), since, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read command history"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"udid": udid, "entries": entries})
+}
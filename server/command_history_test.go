@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func resetCommandHistoryForTest(t *testing.T, limit int) {
+	t.Helper()
+	setupFileHandlersTestDataDir(t)
+	prevLimit := getServerConfig().CommandHistoryLimit
+	updateServerConfig(func(cfg *ServerConfig) { cfg.CommandHistoryLimit = limit })
+	reset := func() {
+		commandHistory.Lock()
+		commandHistory.lines = make(map[string]int)
+		commandHistory.open = make(map[commandHistoryKey]bool)
+		commandHistory.Unlock()
+	}
+	reset()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.CommandHistoryLimit = prevLimit })
+		reset()
+	})
+}
+
+func TestCommandHistoryRecordsCorrelatedResults(t *testing.T) {
+	resetCommandHistoryForTest(t, 100)
+
+	recordCommandsSent([]string{"d1", "d2"}, "system/reboot", "req-1", "ctrl-a")
+	recordCommandsSent([]string{"d1"}, "screen/snapshot", "req-2", "ctrl-b")
+	recordCommandsSent([]string{"d1"}, "script/stop", "", "http")
+
+	recordCommandResult("d1", Message{Type: "system/reboot", RequestID: "req-1", Body: map[string]interface{}{"ok": true}})
+	recordCommandResult("d1", Message{Type: "system/reboot", RequestID: "req-1", Error: "duplicate"})
+	recordCommandResult("d1", Message{Type: "screen/snapshot", RequestID: "req-2", Body: string(make([]byte, commandHistoryResultMaxBytes+1))})
+	recordCommandResult("d1", Message{Type: "app/state", RequestID: "unknown"})
+	forgetOpenCommandHistory("d2")
+	recordCommandResult("d2", Message{Type: "system/reboot", RequestID: "req-1"})
+
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/devices/command-history?udid=d1", nil, commandHistoryHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Entries []commandHistoryEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.Entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", resp.Entries)
+	}
+	reboot := resp.Entries[0]
+	if reboot.Type != "system/reboot" || reboot.ControllerID != "ctrl-a" || reboot.Reply != "system/reboot" || reboot.Error != "" || reboot.RepliedAt == 0 {
+		t.Fatalf("unexpected reboot entry: %+v", reboot)
+	}
+	if snapshot := resp.Entries[1]; !snapshot.ResultTruncated || snapshot.Result != nil {
+		t.Fatalf("large result should be truncated: %+v", snapshot)
+	}
+	if stop := resp.Entries[2]; stop.RepliedAt != 0 || stop.ControllerID != "http" {
+		t.Fatalf("unexpected script/stop entry: %+v", stop)
+	}
+
+	entries, err := loadCommandHistory("d2", "system/reboot", 0, 0)
+	if err != nil || len(entries) != 1 || entries[0].RepliedAt != 0 {
+		t.Fatalf("disconnected device should keep an unanswered entry: %+v, %v", entries, err)
+	}
+	if entries, _ := loadCommandHistory("d1", "screen/snapshot", reboot.SentAt, 1); len(entries) != 1 || entries[0].RequestID != "req-2" {
+		t.Fatalf("type filter failed: %+v", entries)
+	}
+	if entries, _ := loadCommandHistory("d1", "", reboot.SentAt+60_000, 0); len(entries) != 0 {
+		t.Fatalf("since filter failed: %+v", entries)
+	}
+}
+
+func TestCommandHistoryStaysBounded(t *testing.T) {
+	resetCommandHistoryForTest(t, 4)
+
+	for i := 0; i < 20; i++ {
+		recordCommandsSent([]string{"d1"}, "touch/tap", "", "ctrl")
+	}
+	lines, err := readCommandHistoryLines(commandHistoryPath("d1"))
+	if err != nil {
+		t.Fatalf("read history failed: %v", err)
+	}
+	if len(lines) < 4 || len(lines) > 6 {
+		t.Fatalf("expected history trimmed near the limit, got %d lines", len(lines))
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/devices/command-history?udid=../d1", nil, commandHistoryHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid udid, got %d", w.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHTTPCompressionMinBytes is the smallest response body gzipped when
+// httpCompressionMinBytes is 0; shorter bodies are not worth the framing overhead.
+const defaultHTTPCompressionMinBytes = 1024
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return gz
+	},
+}
+
+func httpCompressionMinBytes() int {
+	if getServerConfig().HTTPCompressionMinBytes > 0 {
+		return getServerConfig().HTTPCompressionMinBytes
+	}
+	return defaultHTTPCompressionMinBytes
+}
+
+// compressibleContentType reports whether responses of contentType are worth gzipping:
+// text, JSON, JavaScript, XML and SVG. Images, archives and application/octet-stream
+// downloads are already compressed or opaque and are left alone.
+func compressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return mediaType != "text/event-stream"
+	case mediaType == "application/json", mediaType == "application/javascript",
+		mediaType == "application/xml", mediaType == "image/svg+xml",
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return false
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip (q=0 refuses it).
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil && q <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressionMiddleware gzips text, JSON, JavaScript and CSS responses of at least
+// httpCompressionMinBytes for clients that send Accept-Encoding: gzip. WebSocket upgrades,
+// HEAD and Range requests pass through untouched.
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !getServerConfig().HTTPCompression ||
+			c.Request.Method == http.MethodHead ||
+			c.Request.Header.Get("Range") != "" ||
+			c.Request.Header.Get("Upgrade") != "" ||
+			!acceptsGzip(c.Request.Header.Get("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		w := &gzipResponseWriter{ResponseWriter: c.Writer, minBytes: httpCompressionMinBytes()}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether the body
+// reaches minBytes, then either streams it through gzip or writes it unchanged.
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	minBytes int
+	buf      []byte
+	decided  bool
+	gz       *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.minBytes {
+			return len(data), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// decide picks compression from the response headers and the buffered size, then
+// writes out the buffer.
+func (w *gzipResponseWriter) decide() error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if len(w.buf) >= w.minBytes && !w.ResponseWriter.Written() && header.Get("Content-Encoding") == "" &&
+		w.ResponseWriter.Status() != http.StatusPartialContent &&
+		compressibleContentType(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", "gzip")
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.gz = gzipWriterPool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// finish writes a body that stayed below minBytes as is and closes the gzip stream.
+func (w *gzipResponseWriter) finish() {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.gz.Reset(nil)
+		gzipWriterPool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// Flush is used by streaming handlers; the compression decision is made with whatever
+// has been buffered so far.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		_ = w.decide()
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) Size() int {
+	if !w.decided {
+		return len(w.buf)
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *gzipResponseWriter) Written() bool {
+	return w.ResponseWriter.Written() || len(w.buf) > 0
+}
+
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.Hijack()
+}
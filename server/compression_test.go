@@ -0,0 +1,72 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func performCompressedRequest(t *testing.T, target, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(compressionMiddleware())
+	r.GET("/json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"files": strings.Repeat("entry,", 1000)})
+	})
+	r.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	r.GET("/download", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/octet-stream", []byte(strings.Repeat("x", 4096)))
+	})
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.HTTPCompression = true })
+
+	w := performCompressedRequest(t, "/json", "br, gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzipped JSON response, got %d %v", w.Code, w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip reader failed: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil || !strings.Contains(string(body), `"files":"entry,entry,`) {
+		t.Fatalf("unexpected decompressed body %q (%v)", body, err)
+	}
+
+	if w := performCompressedRequest(t, "/small", "gzip"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != `{"ok":true}` {
+		t.Fatalf("bodies below the threshold should be sent as is, got %v %q", w.Header(), w.Body.String())
+	}
+	if w := performCompressedRequest(t, "/download", "gzip"); w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 4096 {
+		t.Fatalf("octet-stream downloads must not be compressed, got %v", w.Header())
+	}
+	if w := performCompressedRequest(t, "/json", "gzip;q=0"); w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("gzip;q=0 should disable compression, got %v", w.Header())
+	}
+	if w := performCompressedRequest(t, "/json", ""); w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("clients without Accept-Encoding should get plain responses, got %v", w.Header())
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.HTTPCompression = false })
+	if w := performCompressedRequest(t, "/json", "gzip"); w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("httpCompression=false should disable compression, got %v", w.Header())
+	}
+}
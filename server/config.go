@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"math/big"
 	"os"
 	"path/filepath"
@@ -26,40 +27,60 @@ func generateRandomPassword(length int) string {
 	return string(password)
 }
 
-// toPasshash converts a password to its HMAC-SHA256 hash
+// defaultSigningSecret is the passhash derivation key used when signingSecret is unset.
+const defaultSigningSecret = "XXTouch"
+
+func getSigningSecret() []byte {
+	return signingSecretOf(getServerConfig())
+}
+
+// signingSecretOf returns the signing secret configured in cfg, or the built-in default.
+func signingSecretOf(cfg *ServerConfig) []byte {
+	if cfg.SigningSecret != "" {
+		return []byte(cfg.SigningSecret)
+	}
+	return []byte(defaultSigningSecret)
+}
+
+// toPasshash converts a password to its HMAC-SHA256 hash keyed by the signing secret
 func toPasshash(password string) string {
-	h := hmac.New(sha256.New, []byte("XXTouch"))
+	return toPasshashWithSecret(password, getSigningSecret())
+}
+
+func toPasshashWithSecret(password string, secret []byte) string {
+	h := hmac.New(sha256.New, secret)
 	h.Write([]byte(password))
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// loadOrCreateDefaultConfig loads or creates the default configuration file
-func loadOrCreateDefaultConfig() error {
+// loadOrCreateDefaultConfig loads or creates the default configuration file into cfg
+func loadOrCreateDefaultConfig(cfg *ServerConfig) error {
 	data, err := os.ReadFile(DefaultConfigFile)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Printf("Configuration file %s not found, creating new one...\n", DefaultConfigFile)
+			slog.Info("Configuration file not found, creating new one", "path", DefaultConfigFile)
 			password := generateRandomPassword(8)
+			// Console only: the password must not end up in shipped structured logs.
 			fmt.Printf("Generated password: %s\n", password)
-			serverConfig.Passhash = toPasshash(password)
-			return saveConfig(DefaultConfigFile, serverConfig)
+			cfg.Passhash = toPasshashWithSecret(password, signingSecretOf(cfg))
+			return saveConfig(DefaultConfigFile, *cfg)
 		}
 		return fmt.Errorf("failed to read config file: %v", err)
 	}
 
-	if err = json.Unmarshal(data, &serverConfig); err != nil {
+	if err = json.Unmarshal(data, cfg); err != nil {
 		return fmt.Errorf("failed to parse config file: %v", err)
 	}
 
-	if serverConfig.Passhash == "" || len(serverConfig.Passhash) != PasshashLength {
-		fmt.Println("Passhash invalid in config, generating new password...")
+	if cfg.Passhash == "" || len(cfg.Passhash) != PasshashLength {
+		slog.Warn("Passhash invalid in config, generating new password")
 		password := generateRandomPassword(8)
 		fmt.Printf("Generated password: %s\n", password)
-		serverConfig.Passhash = toPasshash(password)
-		return saveConfig(DefaultConfigFile, serverConfig)
+		cfg.Passhash = toPasshashWithSecret(password, signingSecretOf(cfg))
+		return saveConfig(DefaultConfigFile, *cfg)
 	}
 
-	fmt.Printf("Configuration loaded from %s\n", DefaultConfigFile)
+	slog.Info("Configuration loaded", "path", DefaultConfigFile)
 	return nil
 }
 
@@ -74,13 +95,32 @@ func saveConfig(configPath string, config ServerConfig) error {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
 
-	fmt.Printf("Configuration saved to %s\n", configPath)
+	slog.Info("Configuration saved", "path", configPath)
+	return nil
+}
+
+// serverConfigPath is the file the active configuration was loaded from (empty when running without one)
+var serverConfigPath string
+
+// validateStartupConfig checks the loaded config before the server starts, adding the
+// checks that only make sense at startup to validateServerConfig.
+func validateStartupConfig(cfg ServerConfig) error {
+	if err := validateServerConfig(cfg); err != nil {
+		return err
+	}
+	if cfg.FrontendDir == "" {
+		return fmt.Errorf("frontend_dir cannot be empty")
+	}
+	if _, err := hex.DecodeString(cfg.Passhash); err != nil || len(cfg.Passhash) != PasshashLength {
+		return fmt.Errorf("passhash must be %d hex characters; set a password with -set-password or XXTCC_PASSWORD", PasshashLength)
+	}
 	return nil
 }
 
 // loadConfig loads configuration from the specified path or default
 func loadConfig(configPath string) error {
-	serverConfig = DefaultConfig
+	cfg := DefaultConfig
+	serverConfigPath = ""
 
 	if configPath == "" {
 		if envConfig, ok := envString("XXTCC_CONFIG"); ok {
@@ -95,28 +135,31 @@ func loadConfig(configPath string) error {
 				return fmt.Errorf("failed to read config file: %v", err)
 			}
 
-			if err := json.Unmarshal(configData, &serverConfig); err != nil {
+			if err := json.Unmarshal(configData, &cfg); err != nil {
 				return fmt.Errorf("failed to parse config file: %v", err)
 			}
 
-			fmt.Printf("✅ Configuration loaded from: %s\n", configPath)
+			serverConfigPath = configPath
+			slog.Info("Configuration loaded", "path", configPath)
 		} else {
-			fmt.Printf("⚠️ Config file not found: %s, using defaults\n", configPath)
+			slog.Warn("Config file not found, using defaults", "path", configPath)
 		}
 	} else {
 		if noConfig, ok := envBool("XXTCC_NO_CONFIG"); ok && noConfig {
-			fmt.Println("📝 Using defaults without config file (XXTCC_NO_CONFIG=1)")
+			slog.Info("Using defaults without config file (XXTCC_NO_CONFIG=1)")
 		} else {
-			if err := loadOrCreateDefaultConfig(); err != nil {
+			if err := loadOrCreateDefaultConfig(&cfg); err != nil {
 				log.Fatal("Failed to load configuration:", err)
 			}
-			fmt.Println("📝 Using default configuration")
+			serverConfigPath = DefaultConfigFile
+			slog.Info("Using default configuration")
 		}
 	}
 
-	applyEnvOverrides()
+	applyEnvOverrides(&cfg)
+	setServerConfig(cfg)
 
-	passhash = []byte(serverConfig.Passhash)
+	passhash = []byte(cfg.Passhash)
 	return nil
 }
 
@@ -132,6 +175,19 @@ func envString(key string) (string, bool) {
 	return value, true
 }
 
+// splitEnvList splits a comma-separated env value, dropping empty entries.
+func splitEnvList(value string) []string {
+	parts := strings.Split(value, ",")
+	out := make([]string, 0, len(parts))
+	for _, part := range parts {
+		trimmed := strings.TrimSpace(part)
+		if trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
 func envBool(key string) (bool, bool) {
 	value, ok := envString(key)
 	if !ok {
@@ -139,243 +195,609 @@ func envBool(key string) (bool, bool) {
 	}
 	parsed, err := strconv.ParseBool(value)
 	if err != nil {
-		log.Printf("⚠️ Invalid %s: %s", key, value)
+		slog.Warn("Invalid environment variable", "key", key, "value", value)
 		return false, true
 	}
 	return parsed, true
 }
 
-func applyEnvOverrides() {
+func applyEnvOverrides(cfg *ServerConfig) {
+	before, beforeErr := serverConfigAsMap(*cfg)
+	applyEnvOverridesTo(cfg)
+	after, afterErr := serverConfigAsMap(*cfg)
+	if beforeErr == nil && afterErr == nil {
+		if keys := changedConfigKeys(before, after); len(keys) > 0 {
+			slog.Info("Configuration overridden by environment", "keys", keys)
+		}
+	}
+}
+
+// applyEnvOverridesTo applies the XXTCC_* environment variables to cfg.
+func applyEnvOverridesTo(cfg *ServerConfig) {
+	// Must precede XXTCC_PASSWORD, which is derived with the signing secret.
+	if value, ok := envString("XXTCC_SIGNING_SECRET"); ok {
+		cfg.SigningSecret = value
+	}
 	if value, ok := envString("XXTCC_PASSWORD"); ok {
-		serverConfig.Passhash = toPasshash(value)
+		cfg.Passhash = toPasshashWithSecret(value, signingSecretOf(cfg))
 	} else if value, ok := envString("XXTCC_PASSHASH"); ok {
-		serverConfig.Passhash = value
+		cfg.Passhash = value
 	}
 
 	if value, ok := envString("XXTCC_PORT"); ok {
 		if port, err := strconv.Atoi(value); err == nil && port > 0 && port <= 65535 {
-			serverConfig.Port = port
+			cfg.Port = port
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_PORT: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_PORT", "value", value)
 		}
 	}
 
+	if value, ok := envString("XXTCC_BIND_ADDRESS"); ok {
+		cfg.BindAddress = value
+	}
+
 	if value, ok := envString("XXTCC_PING_INTERVAL"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 {
-			serverConfig.PingInterval = v
+			cfg.PingInterval = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_PING_INTERVAL: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_PING_INTERVAL", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_PING_TIMEOUT"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 {
-			serverConfig.PingTimeout = v
+			cfg.PingTimeout = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_PING_TIMEOUT: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_PING_TIMEOUT", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_STATE_INTERVAL"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 {
-			serverConfig.StateInterval = v
+			cfg.StateInterval = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_STATE_INTERVAL: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_STATE_INTERVAL", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_FRONTEND_DIR"); ok {
-		serverConfig.FrontendDir = value
+		cfg.FrontendDir = value
 	}
 
 	if value, ok := envString("XXTCC_DATA_DIR"); ok {
-		serverConfig.DataDir = value
+		cfg.DataDir = value
 	}
 
 	if value, ok := envString("XXTCC_TLS_ENABLED"); ok {
 		if v, err := strconv.ParseBool(value); err == nil {
-			serverConfig.TLSEnabled = v
+			cfg.TLSEnabled = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_TLS_ENABLED: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TLS_ENABLED", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_TLS_CERT_FILE"); ok {
-		serverConfig.TLSCertFile = value
+		cfg.TLSCertFile = value
 	}
 
 	if value, ok := envString("XXTCC_TLS_KEY_FILE"); ok {
-		serverConfig.TLSKeyFile = value
+		cfg.TLSKeyFile = value
+	}
+
+	if value, ok := envString("XXTCC_HTTPS_REDIRECT_PORT"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 && v <= 65535 {
+			cfg.HTTPSRedirectPort = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_HTTPS_REDIRECT_PORT", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_ACME_DOMAINS"); ok {
+		cfg.ACMEDomains = splitEnvList(value)
+	}
+
+	if value, ok := envString("XXTCC_ACME_CACHE_DIR"); ok {
+		cfg.ACMECacheDir = value
 	}
 
 	if value, ok := envString("XXTCC_TURN_ENABLED"); ok {
 		if v, err := strconv.ParseBool(value); err == nil {
-			serverConfig.TURNEnabled = v
+			cfg.TURNEnabled = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_TURN_ENABLED: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TURN_ENABLED", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_TURN_PORT"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 && v <= 65535 {
-			serverConfig.TURNPort = v
+			cfg.TURNPort = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_TURN_PORT: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TURN_PORT", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_TURN_PUBLIC_IP"); ok {
-		serverConfig.TURNPublicIP = value
+		cfg.TURNPublicIP = value
 	}
 
 	if value, ok := envString("XXTCC_TURN_PUBLIC_ADDR"); ok {
-		serverConfig.TURNPublicAddr = value
+		cfg.TURNPublicAddr = value
 	}
 
 	if value, ok := envString("XXTCC_TURN_REALM"); ok {
-		serverConfig.TURNRealm = value
+		cfg.TURNRealm = value
 	}
 
 	if value, ok := envString("XXTCC_TURN_SECRET_KEY"); ok {
-		serverConfig.TURNSecretKey = value
+		cfg.TURNSecretKey = value
 	}
 
 	if value, ok := envString("XXTCC_TURN_CREDENTIAL_TTL"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 {
-			serverConfig.TURNCredentialTTL = v
+			cfg.TURNCredentialTTL = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_TURN_CREDENTIAL_TTL: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TURN_CREDENTIAL_TTL", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_TURN_RELAY_PORT_MIN"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 && v <= 65535 {
-			serverConfig.TURNRelayPortMin = v
+			cfg.TURNRelayPortMin = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_TURN_RELAY_PORT_MIN: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TURN_RELAY_PORT_MIN", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_TURN_RELAY_PORT_MAX"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 && v <= 65535 {
-			serverConfig.TURNRelayPortMax = v
+			cfg.TURNRelayPortMax = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_TURN_RELAY_PORT_MAX: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TURN_RELAY_PORT_MAX", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_CUSTOM_ICE_SERVERS"); ok {
 		var servers []ICEServer
 		if err := json.Unmarshal([]byte(value), &servers); err != nil {
-			log.Printf("⚠️ Invalid XXTCC_CUSTOM_ICE_SERVERS JSON: %v", err)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_CUSTOM_ICE_SERVERS", "error", err)
+		} else {
+			cfg.CustomICEServers = servers
+		}
+	}
+
+	if value, ok := envString("XXTCC_SCRIPT_SKIP_UNREADABLE"); ok {
+		if v, err := strconv.ParseBool(value); err == nil {
+			cfg.ScriptSkipUnreadable = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SCRIPT_SKIP_UNREADABLE", "value", value)
+		}
+	}
+
+	if value, ok := envBool("XXTCC_SCRIPT_SEND_DEDUP"); ok {
+		cfg.ScriptSendDedup = value
+	}
+
+	if value, ok := envBool("XXTCC_SCRIPT_GZIP_PAYLOADS"); ok {
+		cfg.ScriptGzipPayloads = value
+	}
+
+	if value, ok := envString("XXTCC_DESTRUCTIVE_CONFIRM_THRESHOLD"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DestructiveConfirmThreshold = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DESTRUCTIVE_CONFIRM_THRESHOLD", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_CLOCK_SKEW_THRESHOLD_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v > 0 {
+			cfg.ClockSkewThresholdSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_CLOCK_SKEW_THRESHOLD_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_REFRESH_COALESCE_MS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.RefreshCoalesceMs = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_REFRESH_COALESCE_MS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DEVICES_PAGE_SIZE"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DevicesPageSize = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DEVICES_PAGE_SIZE", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_LOG_ARCHIVE_DIR"); ok {
+		cfg.LogArchiveDir = value
+	}
+
+	if value, ok := envString("XXTCC_BINARY_MAX_CHUNK_COUNT"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.BinaryMaxChunkCount = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_BINARY_MAX_CHUNK_COUNT", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_BINARY_MAX_CHUNK_BYTES"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.BinaryMaxChunkBytes = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_BINARY_MAX_CHUNK_BYTES", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_BASE_PATH"); ok {
+		cfg.DeviceBasePath = value
+	}
+
+	if value, ok := envString("XXTCC_SIGNATURE_CACHE_SIZE"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.SignatureCacheSize = v
 		} else {
-			serverConfig.CustomICEServers = servers
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SIGNATURE_CACHE_SIZE", "value", value)
 		}
 	}
 
+	if value, ok := envString("XXTCC_LANDING_REDIRECT"); ok {
+		cfg.LandingRedirect = value
+	}
+
+	if value, ok := envBool("XXTCC_PREFER_EMBEDDED_FRONTEND"); ok {
+		cfg.PreferEmbeddedFrontend = value
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_STATE_FILE"); ok {
+		cfg.DeviceStateFile = value
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_STATE_FLUSH_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DeviceStateFlushSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DEVICE_STATE_FLUSH_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_EXPORT_FILE"); ok {
+		cfg.DeviceExportFile = value
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_EXPORT_URL"); ok {
+		cfg.DeviceExportURL = value
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_EXPORT_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DeviceExportSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DEVICE_EXPORT_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_SHUTDOWN_GRACE_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.ShutdownGraceSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SHUTDOWN_GRACE_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_TRANSFER_CHUNK_SIZE"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.TransferChunkSize = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_TRANSFER_CHUNK_SIZE", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DISABLED_ENDPOINTS"); ok {
+		cfg.DisabledEndpoints = splitEnvList(value)
+	}
+
+	if value, ok := envString("XXTCC_FORWARD_DENY_TYPES"); ok {
+		cfg.ForwardDenyTypes = splitEnvList(value)
+	}
+
+	if value, ok := envString("XXTCC_FORWARD_ALLOW_TYPES"); ok {
+		cfg.ForwardAllowTypes = splitEnvList(value)
+	}
+
+	if value, ok := envString("XXTCC_SIGNATURE_SKEW_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v > 0 {
+			cfg.SignatureSkewSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SIGNATURE_SKEW_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_CONTROL_RATE_LIMIT"); ok {
+		if v, err := strconv.ParseFloat(value, 64); err == nil && v >= 0 {
+			cfg.ControlRateLimit = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_CONTROL_RATE_LIMIT", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_CONTROL_RATE_BURST"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.ControlRateBurst = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_CONTROL_RATE_BURST", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_RATE_LIMIT"); ok {
+		if v, err := strconv.ParseFloat(value, 64); err == nil && v >= 0 {
+			cfg.DeviceRateLimit = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DEVICE_RATE_LIMIT", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_RATE_BURST"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DeviceRateBurst = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DEVICE_RATE_BURST", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_LOG_FORMAT"); ok {
+		cfg.LogFormat = value
+	}
+
+	if value, ok := envString("XXTCC_LOG_LEVEL"); ok {
+		cfg.LogLevel = value
+	}
+
+	if value, ok := envBool("XXTCC_METRICS_ENABLED"); ok {
+		cfg.MetricsEnabled = value
+	}
+
+	if value, ok := envString("XXTCC_METRICS_ADDR"); ok {
+		cfg.MetricsAddr = value
+	}
+
+	if value, ok := envBool("XXTCC_PERSIST_TRANSFER_TOKENS"); ok {
+		cfg.PersistTransferTokens = value
+	}
+
+	if value, ok := envString("XXTCC_LOG_STALE_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.LogStaleSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_LOG_STALE_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envBool("XXTCC_ENCRYPT_DEVICE_DATA"); ok {
+		cfg.EncryptDeviceData = value
+	}
+
+	if value, ok := envString("XXTCC_SCREEN_FRAME_MAX_FPS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.ScreenFrameMaxFPS = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SCREEN_FRAME_MAX_FPS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_SCREENSHOT_CACHE_SIZE"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.ScreenshotCacheSize = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SCREENSHOT_CACHE_SIZE", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_SCREENSHOT_MAX_AGE_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.ScreenshotMaxAgeSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_SCREENSHOT_MAX_AGE_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DEVICE_WRITE_QUEUE_DEPTH"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DeviceWriteQueueDepth = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DEVICE_WRITE_QUEUE_DEPTH", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_DISCONNECT_GRACE_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.DisconnectGraceSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_DISCONNECT_GRACE_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envBool("XXTCC_WEBSOCKET_COMPRESSION"); ok {
+		cfg.WebSocketCompression = value
+	}
+
+	if value, ok := envString("XXTCC_WEBSOCKET_COMPRESSION_MIN_BYTES"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.WebSocketCompressionMinBytes = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_WEBSOCKET_COMPRESSION_MIN_BYTES", "value", value)
+		}
+	}
+
+	if value, ok := envBool("XXTCC_HTTP_COMPRESSION"); ok {
+		cfg.HTTPCompression = value
+	}
+
+	if value, ok := envString("XXTCC_HTTP_COMPRESSION_MIN_BYTES"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.HTTPCompressionMinBytes = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_HTTP_COMPRESSION_MIN_BYTES", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_MAX_WEBSOCKET_MESSAGE_BYTES"); ok {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+			cfg.MaxWebSocketMessageBytes = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_MAX_WEBSOCKET_MESSAGE_BYTES", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_MAX_CONN_AGE_SECONDS"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.MaxConnAgeSeconds = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_MAX_CONN_AGE_SECONDS", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_MAX_DEVICES"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.MaxDevices = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_MAX_DEVICES", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_COMMAND_HISTORY_LIMIT"); ok {
+		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
+			cfg.CommandHistoryLimit = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_COMMAND_HISTORY_LIMIT", "value", value)
+		}
+	}
+
+	if value, ok := envBool("XXTCC_LOCAL_ADMIN_ENABLED"); ok {
+		cfg.LocalAdminEnabled = value
+	}
+
+	if value, ok := envString("XXTCC_LARGE_FILE_THRESHOLD_BYTES"); ok {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+			cfg.LargeFileThresholdBytes = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_LARGE_FILE_THRESHOLD_BYTES", "value", value)
+		}
+	}
+
+	if value, ok := envString("XXTCC_UPLOAD_ZIP_MAX_BYTES"); ok {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil && v >= 0 {
+			cfg.UploadZipMaxBytes = v
+		} else {
+			slog.Warn("Invalid environment variable", "key", "XXTCC_UPLOAD_ZIP_MAX_BYTES", "value", value)
+		}
+	}
+
+	if value, ok := envBool("XXTCC_AUDIT_LOG_ENABLED"); ok {
+		cfg.AuditLogEnabled = value
+	}
+
+	if value, ok := envString("XXTCC_WEBHOOKS"); ok {
+		cfg.Webhooks = splitEnvList(value)
+	}
+
 	if value, ok := envString("XXTCC_UPDATE_ENABLED"); ok {
 		if v, err := strconv.ParseBool(value); err == nil {
-			serverConfig.Update.Enabled = v
+			cfg.Update.Enabled = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_UPDATE_ENABLED: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_UPDATE_ENABLED", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_CHANNEL"); ok {
-		serverConfig.Update.Channel = strings.TrimSpace(value)
+		cfg.Update.Channel = strings.TrimSpace(value)
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_CHECK_INTERVAL_HOURS"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v > 0 {
-			serverConfig.Update.CheckIntervalHours = v
+			cfg.Update.CheckIntervalHours = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_UPDATE_CHECK_INTERVAL_HOURS: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_UPDATE_CHECK_INTERVAL_HOURS", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_PROMPT_ON_NEW_VERSION"); ok {
 		if v, err := strconv.ParseBool(value); err == nil {
-			serverConfig.Update.PromptOnNewVersion = v
+			cfg.Update.PromptOnNewVersion = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_UPDATE_PROMPT_ON_NEW_VERSION: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_UPDATE_PROMPT_ON_NEW_VERSION", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_IGNORED_VERSIONS"); ok {
-		parts := strings.Split(value, ",")
-		ignored := make([]string, 0, len(parts))
-		for _, part := range parts {
-			trimmed := strings.TrimSpace(part)
-			if trimmed != "" {
-				ignored = append(ignored, trimmed)
-			}
-		}
-		serverConfig.Update.IgnoredVersions = ignored
+		cfg.Update.IgnoredVersions = splitEnvList(value)
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_REPOSITORY"); ok {
-		serverConfig.Update.Source.Repository = strings.TrimSpace(value)
+		cfg.Update.Source.Repository = strings.TrimSpace(value)
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_MANIFEST_URLS"); ok {
-		serverConfig.Update.Source.ManifestURLs = splitCSVList(value)
+		cfg.Update.Source.ManifestURLs = splitCSVList(value)
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_MANIFEST_URL"); ok {
-		serverConfig.Update.Source.ManifestURL = strings.TrimSpace(value)
+		cfg.Update.Source.ManifestURL = strings.TrimSpace(value)
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_TIMEOUT_SECONDS"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
-			serverConfig.Update.Source.RequestTimeoutSeconds = v
+			cfg.Update.Source.RequestTimeoutSeconds = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_UPDATE_TIMEOUT_SECONDS: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_UPDATE_TIMEOUT_SECONDS", "value", value)
 		}
 	}
 
 	if value, ok := envString("XXTCC_UPDATE_DOWNLOAD_CONNECT_TIMEOUT_SECONDS"); ok {
 		if v, err := strconv.Atoi(value); err == nil && v >= 0 {
-			serverConfig.Update.Source.DownloadConnectTimeoutSeconds = v
+			cfg.Update.Source.DownloadConnectTimeoutSeconds = v
 		} else {
-			log.Printf("⚠️ Invalid XXTCC_UPDATE_DOWNLOAD_CONNECT_TIMEOUT_SECONDS: %s", value)
+			slog.Warn("Invalid environment variable", "key", "XXTCC_UPDATE_DOWNLOAD_CONNECT_TIMEOUT_SECONDS", "value", value)
 		}
 	}
 }
 
 // initDataDirectories initializes the data storage directories
 func initDataDirectories() error {
-	if err := os.MkdirAll(serverConfig.DataDir, 0755); err != nil {
+	if err := os.MkdirAll(getServerConfig().DataDir, 0755); err != nil {
 		return fmt.Errorf("failed to create data directory: %v", err)
 	}
 
 	for _, category := range AllowedCategories {
-		subDir := filepath.Join(serverConfig.DataDir, category)
+		subDir := filepath.Join(getServerConfig().DataDir, category)
 		if err := os.MkdirAll(subDir, 0755); err != nil {
 			return fmt.Errorf("failed to create %s directory: %v", category, err)
 		}
 	}
 
 	// Clean up temporary transfer files on startup
-	tempDir := filepath.Join(serverConfig.DataDir, "files", "_temp")
-	if err := os.RemoveAll(tempDir); err != nil {
-		fmt.Printf("⚠️ Failed to clean temp directory: %v\n", err)
+	tempDir := filepath.Join(getServerConfig().DataDir, "files", "_temp")
+	if err := cleanTempTransferDir(tempDir, persistedTempFilePaths()); err != nil {
+		slog.Warn("Failed to clean temp directory", "error", err)
 	} else {
-		// Recreate empty _temp directory
-		os.MkdirAll(tempDir, 0755)
-		fmt.Printf("🧹 Cleaned temp transfer directory: %s\n", tempDir)
+		slog.Info("Cleaned temp transfer directory", "dir", tempDir)
 	}
 
-	fmt.Printf("✅ Data directories initialized: %s\n", serverConfig.DataDir)
-	fmt.Printf("   - Scripts: %s/scripts/\n", serverConfig.DataDir)
-	fmt.Printf("   - Files: %s/files/\n", serverConfig.DataDir)
-	fmt.Printf("   - Reports: %s/reports/\n", serverConfig.DataDir)
+	slog.Info("Data directories initialized", "data_dir", getServerConfig().DataDir,
+		"scripts", getServerConfig().DataDir+"/scripts/",
+		"files", getServerConfig().DataDir+"/files/",
+		"reports", getServerConfig().DataDir+"/reports/")
 
 	return nil
 }
 
 // getGroupsFilePath returns the path to the groups data file
 func getGroupsFilePath() string {
-	return filepath.Join(serverConfig.DataDir, "groups.json")
+	return filepath.Join(getServerConfig().DataDir, "groups.json")
 }
 
 func cloneGroupInfos(src []GroupInfo) []GroupInfo {
@@ -407,12 +829,63 @@ func cloneGroupScriptConfigsSnapshot(src map[string]map[string]map[string]interf
 
 // getGroupScriptConfigsFilePath returns the path to the group script configs file
 func getGroupScriptConfigsFilePath() string {
-	return filepath.Join(serverConfig.DataDir, "group_script_configs.json")
+	return filepath.Join(getServerConfig().DataDir, "group_script_configs.json")
 }
 
 // getAppSettingsFilePath returns the path to the app settings file
 func getAppSettingsFilePath() string {
-	return filepath.Join(serverConfig.DataDir, "app_settings.json")
+	return filepath.Join(getServerConfig().DataDir, "app_settings.json")
+}
+
+// getSavedViewsFilePath returns the path to the saved views file
+func getSavedViewsFilePath() string {
+	return filepath.Join(getServerConfig().DataDir, "views.json")
+}
+
+func cloneSavedViews(src []SavedView) []SavedView {
+	out := make([]SavedView, len(src))
+	for i, view := range src {
+		out[i] = view
+		if view.DeviceIDs != nil {
+			out[i].DeviceIDs = append([]string(nil), view.DeviceIDs...)
+		}
+		if view.GroupIDs != nil {
+			out[i].GroupIDs = append([]string(nil), view.GroupIDs...)
+		}
+	}
+	return out
+}
+
+// getScheduledCommandsFilePath returns the path to the scheduled commands file
+func getScheduledCommandsFilePath() string {
+	return filepath.Join(getServerConfig().DataDir, "scheduled_commands.json")
+}
+
+func cloneScheduledCommands(src []ScheduledCommand) []ScheduledCommand {
+	out := make([]ScheduledCommand, len(src))
+	for i, cmd := range src {
+		out[i] = cmd
+		if cmd.Devices != nil {
+			out[i].Devices = append([]string(nil), cmd.Devices...)
+		}
+	}
+	return out
+}
+
+// getMacrosFilePath returns the path to the command macros file
+func getMacrosFilePath() string {
+	return filepath.Join(getServerConfig().DataDir, "macros.json")
+}
+
+func cloneMacros(src []Macro) []Macro {
+	out := make([]Macro, len(src))
+	for i, macro := range src {
+		out[i] = macro
+		if macro.Steps != nil {
+			out[i].Steps = append([]MacroStep(nil), macro.Steps...)
+		}
+	}
+	return out
 }
 
 // loadGroups loads device groups from disk
@@ -450,6 +923,84 @@ func saveGroupsSnapshot(groups []GroupInfo) error {
 	return os.WriteFile(filePath, data, 0644)
 }
 
+// loadSavedViews loads saved device views from disk
+func loadSavedViews() error {
+	savedViewsMu.Lock()
+	defer savedViewsMu.Unlock()
+
+	filePath := getSavedViewsFilePath()
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &savedViews)
+}
+
+func saveSavedViewsSnapshot(views []SavedView) error {
+	data, err := json.MarshalIndent(views, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getSavedViewsFilePath(), data, 0644)
+}
+
+// loadMacros loads command macros from disk
+func loadMacros() error {
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+
+	filePath := getMacrosFilePath()
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &macros)
+}
+
+func saveMacrosSnapshot(list []Macro) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getMacrosFilePath(), data, 0644)
+}
+
+// loadScheduledCommands loads pending one-shot commands from disk
+func loadScheduledCommands() error {
+	scheduledCommandsMu.Lock()
+	defer scheduledCommandsMu.Unlock()
+
+	filePath := getScheduledCommandsFilePath()
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &scheduledCommands)
+}
+
+func saveScheduledCommandsSnapshot(commands []ScheduledCommand) error {
+	data, err := json.MarshalIndent(commands, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getScheduledCommandsFilePath(), data, 0644)
+}
+
 // loadGroupScriptConfigs loads group script configurations from disk
 func loadGroupScriptConfigs() error {
 	groupScriptConfigsMu.Lock()
@@ -518,6 +1069,48 @@ func saveAppSettingsSnapshot(snapshot AppSettings) error {
 	return os.WriteFile(getAppSettingsFilePath(), data, 0644)
 }
 
+// reloadPersistedData resets and reloads all persisted server-side state from the data directory
+func reloadPersistedData() error {
+	deviceGroupsMu.Lock()
+	deviceGroups = make([]GroupInfo, 0)
+	deviceGroupsMu.Unlock()
+	if err := loadGroups(); err != nil {
+		return fmt.Errorf("failed to load groups: %v", err)
+	}
+
+	savedViewsMu.Lock()
+	savedViews = make([]SavedView, 0)
+	savedViewsMu.Unlock()
+	if err := loadSavedViews(); err != nil {
+		return fmt.Errorf("failed to load saved views: %v", err)
+	}
+
+	stopScheduledCommandTimers()
+	scheduledCommandsMu.Lock()
+	scheduledCommands = make([]ScheduledCommand, 0)
+	scheduledCommandsMu.Unlock()
+	if err := loadScheduledCommands(); err != nil {
+		return fmt.Errorf("failed to load scheduled commands: %v", err)
+	}
+	armScheduledCommands()
+
+	groupScriptConfigsMu.Lock()
+	groupScriptConfigs = make(map[string]map[string]map[string]interface{})
+	groupScriptConfigsMu.Unlock()
+	if err := loadGroupScriptConfigs(); err != nil {
+		return fmt.Errorf("failed to load group script configs: %v", err)
+	}
+
+	appSettingsMu.Lock()
+	appSettings = AppSettings{}
+	appSettingsMu.Unlock()
+	if err := loadAppSettings(); err != nil {
+		return fmt.Errorf("failed to load app settings: %v", err)
+	}
+
+	return nil
+}
+
 // showVersion displays the build version
 func showVersion() {
 	fmt.Printf("%s\n", BuildTime)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchConfigReloadSignal reloads the config file on SIGHUP until ctx is done.
+func watchConfigReloadSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reloadConfigOnSignal()
+			}
+		}
+	}()
+}
+
+// reloadConfigOnSignal applies the config file like POST /api/local-admin/reload-config,
+// but also picks up a new passhash. A config that fails validation is not applied.
+func reloadConfigOnSignal() {
+	changed, restartRequired, err := reloadServerConfigFromFile(true)
+	if err != nil {
+		slog.Warn("Config reload rejected, keeping the running config", "error", err)
+		return
+	}
+	slog.Info("Config reloaded on SIGHUP", "changed", changed, "restart_required", restartRequired)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfigOnSignalAppliesPasshashAndHotKeys(t *testing.T) {
+	configBackup := *getServerConfig()
+	configPathBackup := serverConfigPath
+	passhashBackup := passhash
+	serverConfigPath = filepath.Join(t.TempDir(), "xxtcloudserver.json")
+	t.Cleanup(func() {
+		setServerConfig(configBackup)
+		serverConfigPath = configPathBackup
+		setPasshash(string(passhashBackup))
+	})
+
+	oldHash := strings.Repeat("a", PasshashLength)
+	newHash := strings.Repeat("b", PasshashLength)
+	setServerConfig(DefaultConfig)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.Passhash = oldHash })
+	setPasshash(oldHash)
+	oldSignature := computeSignatureHex("payload")
+
+	writeConfig := func(cfg map[string]interface{}) {
+		t.Helper()
+		data, _ := json.Marshal(cfg)
+		if err := os.WriteFile(serverConfigPath, data, 0o644); err != nil {
+			t.Fatalf("write config failed: %v", err)
+		}
+	}
+	writeConfig(map[string]interface{}{"passhash": newHash, "ping_interval": 7, "port": 40003})
+
+	changed, restartRequired, err := reloadServerConfigFromFile(true)
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"passhash", "ping_interval"}) || !reflect.DeepEqual(restartRequired, []string{"port"}) {
+		t.Fatalf("unexpected changed=%v restartRequired=%v", changed, restartRequired)
+	}
+	if getServerConfig().PingInterval != 7 || getServerConfig().Port != DefaultConfig.Port {
+		t.Fatalf("expected ping_interval applied and port kept, got ping=%d port=%d", getServerConfig().PingInterval, getServerConfig().Port)
+	}
+	if getServerConfig().Passhash != newHash || computeSignatureHex("payload") == oldSignature {
+		t.Fatalf("expected the new passhash to sign messages")
+	}
+
+	writeConfig(map[string]interface{}{"passhash": "short", "ping_interval": 9})
+	reloadConfigOnSignal()
+	if getServerConfig().PingInterval != 7 || getServerConfig().Passhash != newHash {
+		t.Fatalf("invalid config should not be applied, got ping=%d", getServerConfig().PingInterval)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateStartupConfig(t *testing.T) {
+	valid := DefaultConfig
+	valid.Passhash = strings.Repeat("0f", PasshashLength/2)
+	if err := validateStartupConfig(valid); err != nil {
+		t.Fatalf("expected default config with a passhash to be valid, got %v", err)
+	}
+
+	cases := map[string]func(cfg *ServerConfig){
+		"port":          func(cfg *ServerConfig) { cfg.Port = -1 },
+		"port range":    func(cfg *ServerConfig) { cfg.Port = 70000 },
+		"ping_interval": func(cfg *ServerConfig) { cfg.PingInterval = 0 },
+		"data_dir":      func(cfg *ServerConfig) { cfg.DataDir = "" },
+		"frontend_dir":  func(cfg *ServerConfig) { cfg.FrontendDir = "" },
+		"empty hash":    func(cfg *ServerConfig) { cfg.Passhash = "" },
+		"non-hex hash":  func(cfg *ServerConfig) { cfg.Passhash = strings.Repeat("zz", PasshashLength/2) },
+	}
+	for name, mutate := range cases {
+		cfg := valid
+		mutate(&cfg)
+		if err := validateStartupConfig(cfg); err == nil {
+			t.Fatalf("%s: expected config to be rejected", name)
+		}
+	}
+}
+
+func TestLoadConfigAppliesEnvOverridesOverFile(t *testing.T) {
+	configBackup := *getServerConfig()
+	configPathBackup := serverConfigPath
+	passhashBackup := passhash
+	t.Cleanup(func() {
+		setServerConfig(configBackup)
+		serverConfigPath = configPathBackup
+		passhash = passhashBackup
+	})
+
+	configPath := filepath.Join(t.TempDir(), "xxtcloudserver.json")
+	fileConfig := `{"port": 40000, "ping_interval": 20, "data_dir": "/srv/file-data", "controlRateBurst": 9}`
+	if err := os.WriteFile(configPath, []byte(fileConfig), 0o644); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+	t.Setenv("XXTCC_PORT", "40001")
+	t.Setenv("XXTCC_PING_INTERVAL", "often")
+	t.Setenv("XXTCC_DATA_DIR", "/srv/env-data")
+	t.Setenv("XXTCC_CONTROL_RATE_BURST", "5")
+	t.Setenv("XXTCC_METRICS_ENABLED", "1")
+
+	if err := loadConfig(configPath); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if getServerConfig().Port != 40001 || getServerConfig().DataDir != "/srv/env-data" || getServerConfig().ControlRateBurst != 5 || !getServerConfig().MetricsEnabled {
+		t.Fatalf("expected env to win over the file, got port=%d dataDir=%q burst=%d metrics=%v",
+			getServerConfig().Port, getServerConfig().DataDir, getServerConfig().ControlRateBurst, getServerConfig().MetricsEnabled)
+	}
+	if getServerConfig().PingInterval != 20 {
+		t.Fatalf("expected an invalid env value to keep the file value, got %d", getServerConfig().PingInterval)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const confirmTokenTTL = 60 * time.Second
+
+// Destructive operations that can require a confirmation token.
+const (
+	confirmOpDelete       = "delete"
+	confirmOpBatchMove    = "batch-move"
+	confirmOpDeviceReboot = "device-reboot"
+)
+
+var errConfirmationRequired = errors.New("confirmation required")
+
+type confirmToken struct {
+	Op        string
+	Scope     string
+	Impact    int
+	ExpiresAt time.Time
+}
+
+var confirmTokens = struct {
+	sync.Mutex
+	entries map[string]confirmToken
+}{
+	entries: make(map[string]confirmToken),
+}
+
+// confirmationRequired reports whether an operation with the given impact needs a token.
+func confirmationRequired(impact int) bool {
+	threshold := getServerConfig().DestructiveConfirmThreshold
+	return threshold > 0 && impact >= threshold
+}
+
+func issueConfirmToken(op, scope string, impact int) (string, time.Time) {
+	now := time.Now()
+	token := uuid.New().String()
+	expiresAt := now.Add(confirmTokenTTL)
+
+	confirmTokens.Lock()
+	for key, entry := range confirmTokens.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(confirmTokens.entries, key)
+		}
+	}
+	confirmTokens.entries[token] = confirmToken{Op: op, Scope: scope, Impact: impact, ExpiresAt: expiresAt}
+	confirmTokens.Unlock()
+
+	return token, expiresAt
+}
+
+// checkConfirmToken enforces the confirmation step for a destructive operation.
+// Tokens are single-use and only valid for the exact op and scope they were issued for.
+func checkConfirmToken(op, scope string, impact int, token string) error {
+	if !confirmationRequired(impact) {
+		return nil
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return errConfirmationRequired
+	}
+
+	confirmTokens.Lock()
+	entry, ok := confirmTokens.entries[token]
+	if ok {
+		delete(confirmTokens.entries, token)
+	}
+	confirmTokens.Unlock()
+
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		return fmt.Errorf("confirmation token is invalid or expired")
+	}
+	if entry.Op != op || entry.Scope != scope {
+		return fmt.Errorf("confirmation token does not match this operation")
+	}
+	return nil
+}
+
+func respondConfirmationError(c *gin.Context, impact int, err error) {
+	c.JSON(http.StatusPreconditionRequired, gin.H{
+		"error":           err.Error(),
+		"confirmRequired": true,
+		"impact":          impact,
+	})
+}
+
+// countPathFiles counts the files that removing path would affect.
+// Symlinks count as a single entry and are never followed.
+func countPathFiles(path string) (int, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return 1, nil
+	}
+
+	count := 0
+	err = filepath.WalkDir(path, func(_ string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func deleteConfirmScope(category, subPath string) string {
+	return confirmOpDelete + "|" + category + "|" + filepath.ToSlash(filepath.Clean(subPath))
+}
+
+func batchMoveConfirmScope(srcCategory, srcPath string, items []string) string {
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	return confirmOpBatchMove + "|" + srcCategory + "|" + filepath.ToSlash(filepath.Clean(srcPath)) + "|" + strings.Join(sorted, "\x00")
+}
+
+func deviceRebootConfirmScope(devices []string) string {
+	sorted := uniqueDeviceIDs(devices)
+	sort.Strings(sorted)
+	return confirmOpDeviceReboot + "|" + strings.Join(sorted, ",")
+}
+
+func isRebootCommand(cmdType string) bool {
+	return cmdType == "system/reboot" || cmdType == "device/reboot"
+}
+
+// batchMoveImpact counts files under each requested source item.
+func batchMoveImpact(srcDir string, items []string) int {
+	impact := 0
+	for _, item := range items {
+		cleanItem, err := sanitizeRelativeItemPath(item)
+		if err != nil {
+			continue
+		}
+		if n, err := countPathFiles(filepath.Join(srcDir, cleanItem)); err == nil {
+			impact += n
+		}
+	}
+	return impact
+}
+
+// confirmImpactHandler handles GET /api/confirm/impact
+// Returns the impact of a destructive operation plus a short-lived token to echo back.
+func confirmImpactHandler(c *gin.Context) {
+	op := c.Query("op")
+
+	var (
+		scope       string
+		impact      int
+		description string
+	)
+
+	switch op {
+	case confirmOpDelete:
+		category := c.Query("category")
+		subPath := c.Query("path")
+		if category == "" || subPath == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "category and path are required"})
+			return
+		}
+		targetPath, err := validatePath(category, subPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		impact, err = countPathFiles(targetPath)
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file or directory not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		scope = deleteConfirmScope(category, subPath)
+		description = fmt.Sprintf("this will delete %d files", impact)
+
+	case confirmOpBatchMove:
+		srcCategory := c.Query("srcCategory")
+		if srcCategory == "" {
+			srcCategory = c.Query("category")
+		}
+		srcPath := c.Query("srcPath")
+		items := c.QueryArray("items")
+		if len(items) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no items to move"})
+			return
+		}
+		srcDir, err := validatePath(srcCategory, srcPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		impact = batchMoveImpact(srcDir, items)
+		scope = batchMoveConfirmScope(srcCategory, srcPath, items)
+		description = fmt.Sprintf("this will move %d files", impact)
+
+	case confirmOpDeviceReboot:
+		devices := uniqueDeviceIDs(c.QueryArray("devices"))
+		if len(devices) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "devices are required"})
+			return
+		}
+		impact = len(devices)
+		scope = deviceRebootConfirmScope(devices)
+		description = fmt.Sprintf("this will reboot %d devices", impact)
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported op"})
+		return
+	}
+
+	token, expiresAt := issueConfirmToken(op, scope, impact)
+	c.JSON(http.StatusOK, gin.H{
+		"token":       token,
+		"op":          op,
+		"impact":      impact,
+		"description": description,
+		"required":    confirmationRequired(impact),
+		"expiresAt":   expiresAt.Unix(),
+	})
+}
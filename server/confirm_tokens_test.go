@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerFilesDeleteHandler_RequiresConfirmTokenAboveThreshold(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	prevThreshold := getServerConfig().DestructiveConfirmThreshold
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DestructiveConfirmThreshold = 2 })
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.DestructiveConfirmThreshold = prevThreshold })
+	})
+
+	folder := filepath.Join(dataDir, "files", "bulk")
+	if err := os.MkdirAll(filepath.Join(folder, "nested"), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "nested/c.txt"} {
+		if err := os.WriteFile(filepath.Join(folder, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write %s failed: %v", name, err)
+		}
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodDelete, "/api/server-files/delete?category=files&path=bulk", nil, serverFilesDeleteHandler)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected status 428 without token, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/confirm/impact?op=delete&category=files&path=bulk", nil, confirmImpactHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for impact, got %d body=%s", w.Code, w.Body.String())
+	}
+	var impactResp struct {
+		Token    string `json:"token"`
+		Impact   int    `json:"impact"`
+		Required bool   `json:"required"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &impactResp); err != nil {
+		t.Fatalf("decode impact response failed: %v", err)
+	}
+	if impactResp.Impact != 3 || !impactResp.Required || impactResp.Token == "" {
+		t.Fatalf("unexpected impact response: %+v", impactResp)
+	}
+
+	// A token issued for a different target must not be accepted.
+	otherToken, _ := issueConfirmToken(confirmOpDelete, deleteConfirmScope("files", "other"), 3)
+	w = performJSONHandlerRequest(t, http.MethodDelete, "/api/server-files/delete?category=files&path=bulk&confirmToken="+otherToken, nil, serverFilesDeleteHandler)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected mismatched token to be rejected, got %d", w.Code)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodDelete, "/api/server-files/delete?category=files&path=bulk&confirmToken="+impactResp.Token, nil, serverFilesDeleteHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 with token, got %d body=%s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(folder); !os.IsNotExist(err) {
+		t.Fatalf("expected folder to be deleted, stat err=%v", err)
+	}
+}
+
+func TestCheckConfirmToken_SkipsBelowThresholdAndIsSingleUse(t *testing.T) {
+	prevThreshold := getServerConfig().DestructiveConfirmThreshold
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DestructiveConfirmThreshold = 5 })
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.DestructiveConfirmThreshold = prevThreshold })
+	})
+
+	scope := deviceRebootConfirmScope([]string{"d2", "d1"})
+	if err := checkConfirmToken(confirmOpDeviceReboot, scope, 4, ""); err != nil {
+		t.Fatalf("expected below-threshold op to pass without token, got %v", err)
+	}
+
+	token, _ := issueConfirmToken(confirmOpDeviceReboot, deviceRebootConfirmScope([]string{"d1", "d2"}), 5)
+	if err := checkConfirmToken(confirmOpDeviceReboot, scope, 5, token); err != nil {
+		t.Fatalf("expected token to be accepted regardless of device order, got %v", err)
+	}
+	if err := checkConfirmToken(confirmOpDeviceReboot, scope, 5, token); err == nil {
+		t.Fatalf("expected token reuse to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// deviceCapLogInterval spaces out the warning logged while devices are being rejected,
+// since rejected devices keep reconnecting.
+const deviceCapLogInterval = time.Minute
+
+var (
+	deviceCapRejected    atomic.Int64
+	deviceCapLastWarning atomic.Int64 // unix nanoseconds
+)
+
+// registeredDeviceCountLocked counts the devices holding a slot under maxDevices:
+// connected ones plus those retained during their offline grace period.
+// Caller must hold mu (read or write).
+func registeredDeviceCountLocked() int {
+	count := len(deviceLinks)
+	for udid := range pendingOfflineDevices {
+		if _, connected := deviceLinks[udid]; !connected {
+			count++
+		}
+	}
+	return count
+}
+
+// deviceCapReachedLocked reports whether registering udid would exceed maxDevices.
+// A device that is connected already or resuming within its grace period keeps its slot.
+// Caller must hold mu (read or write).
+func deviceCapReachedLocked(udid string) bool {
+	if getServerConfig().MaxDevices <= 0 {
+		return false
+	}
+	if _, connected := deviceLinks[udid]; connected {
+		return false
+	}
+	if _, retained := pendingOfflineDevices[udid]; retained {
+		return false
+	}
+	return registeredDeviceCountLocked() >= getServerConfig().MaxDevices
+}
+
+// rejectDeviceOverCap tells a device it cannot register because maxDevices is reached
+// and closes its connection.
+func rejectDeviceOverCap(conn *SafeConn, udid string) {
+	rejected := deviceCapRejected.Add(1)
+	now := time.Now().UnixNano()
+	if last := deviceCapLastWarning.Load(); now-last >= int64(deviceCapLogInterval) && deviceCapLastWarning.CompareAndSwap(last, now) {
+		slog.Warn("Device cap reached, rejecting new device", "udid", udid, "max_devices", getServerConfig().MaxDevices,
+			"remote_addr", conn.RemoteAddr(), "rejected_total", rejected)
+	}
+	_ = sendMessage(conn, Message{Type: "error", Error: "server device limit reached"})
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server device limit reached")
+	_ = conn.WriteMessage(websocket.CloseMessage, closeMsg)
+	conn.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestDeviceCap_RejectsNewDevicesButKeepsExistingSlots(t *testing.T) {
+	backupMax := getServerConfig().MaxDevices
+	updateServerConfig(func(cfg *ServerConfig) { cfg.MaxDevices = 1 })
+	mu.Lock()
+	linksBackup, linksMapBackup, controllersBackup := deviceLinks, deviceLinksMap, controllers
+	tableBackup, lifeBackup, pendingBackup := deviceTable, deviceLife, pendingOfflineDevices
+	deviceLinks = make(map[string]*SafeConn)
+	deviceLinksMap = make(map[*SafeConn]string)
+	controllers = make(map[*SafeConn]bool)
+	deviceTable = make(map[string]interface{})
+	deviceLife = make(map[string]int)
+	pendingOfflineDevices = make(map[string]*pendingOffline)
+	mu.Unlock()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.MaxDevices = backupMax })
+		mu.Lock()
+		deviceLinks, deviceLinksMap, controllers = linksBackup, linksMapBackup, controllersBackup
+		deviceTable, deviceLife, pendingOfflineDevices = tableBackup, lifeBackup, pendingBackup
+		mu.Unlock()
+	})
+
+	appState := func(udid string) Message {
+		return Message{Type: "app/state", Body: map[string]interface{}{"system": map[string]interface{}{"udid": udid}}}
+	}
+
+	first, _ := newTestWebSocketPair(t)
+	if err := handleMessage(first, appState("d1")); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+
+	rejectedBefore := deviceCapRejected.Load()
+	extra, extraPeer := newTestWebSocketPair(t)
+	if err := handleMessage(extra, appState("d2")); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	if msg := readTestMessage(t, extraPeer); msg.Type != "error" || msg.Error != "server device limit reached" {
+		t.Fatalf("expected device limit error, got %+v", msg)
+	}
+	if got := deviceCapRejected.Load() - rejectedBefore; got != 1 {
+		t.Fatalf("expected one rejection counted, got %d", got)
+	}
+	mu.RLock()
+	_, linked := deviceLinks["d2"]
+	_, stored := deviceTable["d2"]
+	mu.RUnlock()
+	if linked || stored {
+		t.Fatalf("rejected device should not be registered, linked=%v stored=%v", linked, stored)
+	}
+
+	replacement, _ := newTestWebSocketPair(t)
+	if err := handleMessage(replacement, appState("d1")); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	mu.RLock()
+	current := deviceLinks["d1"]
+	mu.RUnlock()
+	if current != replacement {
+		t.Fatalf("existing device should be able to reconnect at the cap")
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.MaxDevices = 0 })
+	unlimited, _ := newTestWebSocketPair(t)
+	if err := handleMessage(unlimited, appState("d2")); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	mu.RLock()
+	_, linked = deviceLinks["d2"]
+	mu.RUnlock()
+	if !linked {
+		t.Fatalf("maxDevices 0 should not limit registrations")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// deviceClockSkewFlagged tracks devices currently flagged for clock skew.
+// Guarded by mu.
+var deviceClockSkewFlagged = make(map[string]bool)
+
+// getClockSkewThresholdSeconds returns the skew (in seconds) above which a device is flagged.
+func getClockSkewThresholdSeconds() int64 {
+	if getServerConfig().ClockSkewThresholdSeconds > 0 {
+		return int64(getServerConfig().ClockSkewThresholdSeconds)
+	}
+	if DefaultConfig.ClockSkewThresholdSeconds > 0 {
+		return int64(DefaultConfig.ClockSkewThresholdSeconds)
+	}
+	return getSignatureSkewSeconds()
+}
+
+// normalizeUnixSeconds accepts a unix timestamp in seconds or milliseconds.
+func normalizeUnixSeconds(ts int64) int64 {
+	if ts > 1e12 {
+		return ts / 1000
+	}
+	return ts
+}
+
+// extractDeviceTimestamp returns the device-reported time from an app/state message:
+// the message-level ts, or system.time / system.ts in the body.
+func extractDeviceTimestamp(data Message, systemMap map[string]interface{}) (int64, bool) {
+	if data.TS > 0 {
+		return normalizeUnixSeconds(data.TS), true
+	}
+	for _, key := range []string{"time", "ts"} {
+		if raw, exists := systemMap[key]; exists {
+			if f, ok := raw.(float64); ok && f > 0 {
+				return normalizeUnixSeconds(int64(math.Round(f))), true
+			}
+		}
+	}
+	return 0, false
+}
+
+type deviceClockSkewInfo struct {
+	UDID        string `json:"udid"`
+	SkewSeconds int64  `json:"skewSeconds"` // device time minus server time
+	DeviceTime  int64  `json:"deviceTime"`
+	ServerTime  int64  `json:"serverTime"`
+	Flagged     bool   `json:"flagged"`
+}
+
+// measureDeviceClockSkew compares a device timestamp against server time.
+func measureDeviceClockSkew(udid string, deviceTS int64, now time.Time) deviceClockSkewInfo {
+	serverTS := now.Unix()
+	skew := deviceTS - serverTS
+	absSkew := skew
+	if absSkew < 0 {
+		absSkew = -absSkew
+	}
+	return deviceClockSkewInfo{
+		UDID:        udid,
+		SkewSeconds: skew,
+		DeviceTime:  deviceTS,
+		ServerTime:  serverTS,
+		Flagged:     absSkew > getClockSkewThresholdSeconds(),
+	}
+}
+
+// updateDeviceClockSkewFlagLocked records the flag state and reports whether the device
+// became flagged with this update. Caller must hold mu.Lock.
+func updateDeviceClockSkewFlagLocked(udid string, flagged bool) bool {
+	wasFlagged := deviceClockSkewFlagged[udid]
+	if flagged {
+		deviceClockSkewFlagged[udid] = true
+	} else {
+		delete(deviceClockSkewFlagged, udid)
+	}
+	return flagged && !wasFlagged
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtractDeviceTimestamp_PrefersMessageTS(t *testing.T) {
+	if ts, ok := extractDeviceTimestamp(Message{TS: 1700000000000}, nil); !ok || ts != 1700000000 {
+		t.Fatalf("expected millisecond ts to normalize, got %d ok=%t", ts, ok)
+	}
+	if ts, ok := extractDeviceTimestamp(Message{}, map[string]interface{}{"time": float64(1700000005)}); !ok || ts != 1700000005 {
+		t.Fatalf("expected system.time fallback, got %d ok=%t", ts, ok)
+	}
+	if _, ok := extractDeviceTimestamp(Message{}, map[string]interface{}{"time": "now"}); ok {
+		t.Fatalf("expected non-numeric time to be ignored")
+	}
+}
+
+func TestMeasureDeviceClockSkew_FlagsBeyondThreshold(t *testing.T) {
+	backup := getServerConfig().ClockSkewThresholdSeconds
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ClockSkewThresholdSeconds = 30 })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.ClockSkewThresholdSeconds = backup }) })
+
+	now := time.Unix(1700000000, 0)
+	if info := measureDeviceClockSkew("d1", now.Unix()-20, now); info.Flagged || info.SkewSeconds != -20 {
+		t.Fatalf("expected small skew to pass, got %+v", info)
+	}
+	info := measureDeviceClockSkew("d1", now.Unix()+45, now)
+	if !info.Flagged || info.SkewSeconds != 45 {
+		t.Fatalf("expected skew to be flagged, got %+v", info)
+	}
+
+	mu.Lock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(deviceClockSkewFlagged, "d1")
+		mu.Unlock()
+	})
+	first := updateDeviceClockSkewFlagLocked("d1", true)
+	second := updateDeviceClockSkewFlagLocked("d1", true)
+	cleared := updateDeviceClockSkewFlagLocked("d1", false)
+	again := updateDeviceClockSkewFlagLocked("d1", true)
+	mu.Unlock()
+	if !first || second || cleared || !again {
+		t.Fatalf("unexpected transitions: first=%t second=%t cleared=%t again=%t", first, second, cleared, again)
+	}
+}
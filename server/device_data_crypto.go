@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+)
+
+// deviceDataSealedPrefix marks an encrypted record: the prefix followed by
+// base64(nonce || AES-256-GCM ciphertext) on a single line.
+const deviceDataSealedPrefix = "XXTCC-ENC1:"
+
+// deviceDataKeyEnv supplies the key for encrypted device data files. It is deliberately
+// env-only so the key is never written to the config file or a config bundle.
+const deviceDataKeyEnv = "XXTCC_DEVICE_DATA_KEY"
+
+// deviceDataAEAD is set at startup when deviceDataKeyEnv is present. Encrypted files can
+// still be read with encryptDeviceData disabled, so turning the option off is lossless.
+var deviceDataAEAD cipher.AEAD
+
+func newDeviceDataAEAD(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// initDeviceDataCipher loads the device data key from the environment.
+func initDeviceDataCipher() error {
+	deviceDataAEAD = nil
+	if key := os.Getenv(deviceDataKeyEnv); key != "" {
+		aead, err := newDeviceDataAEAD(key)
+		if err != nil {
+			return err
+		}
+		deviceDataAEAD = aead
+	}
+	if getServerConfig().EncryptDeviceData && deviceDataAEAD == nil {
+		return errors.New("encryptDeviceData requires " + deviceDataKeyEnv)
+	}
+	return nil
+}
+
+func deviceDataEncryptionEnabled() bool {
+	return getServerConfig().EncryptDeviceData && deviceDataAEAD != nil
+}
+
+func sealDeviceData(plain []byte) ([]byte, error) {
+	nonce := make([]byte, deviceDataAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := deviceDataAEAD.Seal(nonce, nonce, plain, nil)
+	out := make([]byte, len(deviceDataSealedPrefix)+base64.StdEncoding.EncodedLen(len(sealed)))
+	copy(out, deviceDataSealedPrefix)
+	base64.StdEncoding.Encode(out[len(deviceDataSealedPrefix):], sealed)
+	return out, nil
+}
+
+// openDeviceData decrypts a sealed record; data without the prefix is returned as-is so
+// files written before encryption was enabled stay readable.
+func openDeviceData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(deviceDataSealedPrefix)) {
+		return data, nil
+	}
+	if deviceDataAEAD == nil {
+		return nil, errors.New("device data is encrypted but " + deviceDataKeyEnv + " is not set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data[len(deviceDataSealedPrefix):])))
+	if err != nil {
+		return nil, errors.New("malformed encrypted device data")
+	}
+	nonceSize := deviceDataAEAD.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("malformed encrypted device data")
+	}
+	plain, err := deviceDataAEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt device data (wrong " + deviceDataKeyEnv + "?)")
+	}
+	return plain, nil
+}
+
+// writeDeviceDataFile atomically writes a device data file, encrypting it when
+// encryptDeviceData is enabled.
+func writeDeviceDataFile(path string, data []byte, perm os.FileMode) error {
+	if deviceDataEncryptionEnabled() {
+		sealed, err := sealDeviceData(data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+// readDeviceDataFile reads a file written by writeDeviceDataFile, decrypting it if needed.
+func readDeviceDataFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return openDeviceData(data)
+}
+
+// sealDeviceDataLine encrypts one line of an append-only file (such as the log archive)
+// as its own record when encryptDeviceData is enabled.
+func sealDeviceDataLine(line string) (string, error) {
+	if !deviceDataEncryptionEnabled() {
+		return line, nil
+	}
+	sealed, err := sealDeviceData([]byte(line))
+	if err != nil {
+		return "", err
+	}
+	return string(sealed) + "\n", nil
+}
+
+// decryptDeviceDataTo writes the plaintext of a device data file to w. Sealed and plain
+// lines may be mixed, as in a log archive written before and after enabling encryption.
+func decryptDeviceDataTo(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if bytes.HasPrefix(line, []byte(deviceDataSealedPrefix)) {
+				plain, err := openDeviceData(line)
+				if err != nil {
+					return err
+				}
+				line = plain
+			}
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func enableDeviceDataEncryptionForTest(t *testing.T, key string) {
+	t.Helper()
+	prevAEAD, prevEnabled := deviceDataAEAD, getServerConfig().EncryptDeviceData
+	t.Cleanup(func() {
+		deviceDataAEAD = prevAEAD
+		updateServerConfig(func(cfg *ServerConfig) { cfg.EncryptDeviceData = prevEnabled })
+	})
+	t.Setenv(deviceDataKeyEnv, key)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.EncryptDeviceData = true })
+	if err := initDeviceDataCipher(); err != nil {
+		t.Fatalf("init cipher: %v", err)
+	}
+}
+
+func TestDeviceDataFile_EncryptedRoundTrip(t *testing.T) {
+	enableDeviceDataEncryptionForTest(t, "secret-key")
+	path := filepath.Join(t.TempDir(), "device-labels.json")
+
+	plain := []byte(`{"d1":{"label":"pasteboard secret"}}`)
+	if err := writeDeviceDataFile(path, plain, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	raw, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(raw, []byte(deviceDataSealedPrefix)) || bytes.Contains(raw, []byte("pasteboard")) {
+		t.Fatalf("file should be encrypted on disk, got %q", raw)
+	}
+	got, err := readDeviceDataFile(path)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("read back %q, %v", got, err)
+	}
+
+	// Plaintext files from before encryption was enabled stay readable
+	legacy := filepath.Join(t.TempDir(), "legacy.json")
+	if err := os.WriteFile(legacy, plain, 0644); err != nil {
+		t.Fatalf("write legacy: %v", err)
+	}
+	if got, err := readDeviceDataFile(legacy); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("legacy read %q, %v", got, err)
+	}
+
+	// A different key cannot decrypt
+	enableDeviceDataEncryptionForTest(t, "other-key")
+	if _, err := readDeviceDataFile(path); err == nil {
+		t.Fatalf("expected decrypt failure with the wrong key")
+	}
+}
+
+func TestDeviceDataCipher_RequiresKeyWhenEnabled(t *testing.T) {
+	prevAEAD, prevEnabled := deviceDataAEAD, getServerConfig().EncryptDeviceData
+	t.Cleanup(func() {
+		deviceDataAEAD = prevAEAD
+		updateServerConfig(func(cfg *ServerConfig) { cfg.EncryptDeviceData = prevEnabled })
+	})
+	t.Setenv(deviceDataKeyEnv, "")
+	updateServerConfig(func(cfg *ServerConfig) { cfg.EncryptDeviceData = true })
+	if err := initDeviceDataCipher(); err == nil {
+		t.Fatalf("expected error when the key is missing")
+	}
+}
+
+func TestLogArchive_EncryptsEachLine(t *testing.T) {
+	enableDeviceDataEncryptionForTest(t, "secret-key")
+	dir := t.TempDir()
+	prevDir := getServerConfig().LogArchiveDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LogArchiveDir = dir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.LogArchiveDir = prevDir }) })
+
+	path := filepath.Join(dir, "d1.log")
+	if err := os.WriteFile(path, []byte("2024-01-01T00:00:00Z plain line\n"), 0644); err != nil {
+		t.Fatalf("write plain line: %v", err)
+	}
+	for _, msg := range []string{"first secret", "second secret"} {
+		if err := appendDeviceLogArchive("d1", msg); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatalf("archived lines should be encrypted, got %q", raw)
+	}
+
+	var out bytes.Buffer
+	if err := decryptDeviceDataTo(path, &out); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], "plain line") || !strings.HasSuffix(lines[2], "second secret") {
+		t.Fatalf("unexpected decrypted log: %q", out.String())
+	}
+}
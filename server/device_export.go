@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deviceExportEntry is the sanitized, read-only view of one device in the export.
+type deviceExportEntry struct {
+	UDID     string      `json:"udid"`
+	Alias    string      `json:"alias,omitempty"`
+	Tags     []string    `json:"tags,omitempty"`
+	Online   bool        `json:"online"`
+	LastSeen int64       `json:"lastSeen,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	IP       string      `json:"ip,omitempty"`
+	Version  string      `json:"version,omitempty"`
+	Battery  interface{} `json:"battery,omitempty"`
+	Running  bool        `json:"running"`
+	Paused   bool        `json:"paused"`
+	Script   string      `json:"script,omitempty"`
+}
+
+var (
+	deviceExportMu        sync.Mutex // serializes exports
+	deviceExportFileSaved []byte     // devices JSON last written to deviceExportFile
+	deviceExportFilePath  string
+	deviceExportURLSaved  []byte // devices JSON last accepted by deviceExportUrl
+	deviceExportURLTarget string
+	deviceExportStop      chan struct{}
+	deviceExportDone      chan struct{}
+	deviceExportStart     sync.Once
+
+	deviceExportClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func isDeviceExportURL(raw string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func stateString(m map[string]interface{}, key string) string {
+	value, _ := m[key].(string)
+	return value
+}
+
+func stateBool(m map[string]interface{}, key string) bool {
+	value, _ := m[key].(bool)
+	return value
+}
+
+// buildDeviceExport returns the sanitized device table sorted by UDID.
+func buildDeviceExport() []deviceExportEntry {
+	tagsByDevice := make(map[string][]string)
+	deviceGroupsMu.RLock()
+	for _, group := range deviceGroups {
+		name := strings.TrimSpace(group.Name)
+		if name == "" {
+			continue
+		}
+		for _, udid := range group.DeviceIDs {
+			tagsByDevice[udid] = append(tagsByDevice[udid], name)
+		}
+	}
+	deviceGroupsMu.RUnlock()
+
+	mu.RLock()
+	entries := make([]deviceExportEntry, 0, len(deviceTable))
+	for udid, state := range deviceTable {
+		_, online := deviceLinks[udid]
+		entry := deviceExportEntry{
+			UDID:     udid,
+			Tags:     tagsByDevice[udid],
+			Online:   online,
+			LastSeen: deviceLastSeen[udid],
+		}
+		if stateMap, ok := state.(map[string]interface{}); ok {
+			if system, ok := stateMap["system"].(map[string]interface{}); ok {
+				entry.Name = stateString(system, "name")
+				entry.IP = stateString(system, "ip")
+				entry.Version = stateString(system, "version")
+				entry.Battery = system["battery"]
+				entry.Running = stateBool(system, "running")
+				entry.Paused = stateBool(system, "paused")
+			}
+			if script, ok := stateMap["script"].(map[string]interface{}); ok {
+				entry.Script = stateString(script, "select")
+			}
+		}
+		entries = append(entries, entry)
+	}
+	mu.RUnlock()
+
+	for i := range entries {
+		if label, ok := getDeviceLabel(entries[i].UDID); ok {
+			entries[i].Alias = label.Label
+		}
+		sort.Strings(entries[i].Tags)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UDID < entries[j].UDID })
+	return entries
+}
+
+// marshalDeviceExport wraps the devices array as {"exportedAt": ..., "devices": [...]}.
+func marshalDeviceExport(devicesJSON []byte) ([]byte, error) {
+	return json.MarshalIndent(struct {
+		ExportedAt int64           `json:"exportedAt"`
+		Devices    json.RawMessage `json:"devices"`
+	}{time.Now().Unix(), devicesJSON}, "", "  ")
+}
+
+// exportDeviceTable writes the device export to deviceExportFile and/or POSTs it to
+// deviceExportUrl. Each target is only updated when the devices changed since its
+// last successful export, so a failing URL is retried on the next tick.
+func exportDeviceTable() error {
+	filePath := strings.TrimSpace(getServerConfig().DeviceExportFile)
+	target := strings.TrimSpace(getServerConfig().DeviceExportURL)
+	if filePath == "" && target == "" {
+		return nil
+	}
+
+	deviceExportMu.Lock()
+	defer deviceExportMu.Unlock()
+
+	devicesJSON, err := json.Marshal(buildDeviceExport())
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	if filePath != "" && (filePath != deviceExportFilePath || !bytes.Equal(devicesJSON, deviceExportFileSaved)) {
+		if err := writeDeviceExportFile(filePath, devicesJSON); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			deviceExportFileSaved = devicesJSON
+			deviceExportFilePath = filePath
+		}
+	}
+	if target != "" && (target != deviceExportURLTarget || !bytes.Equal(devicesJSON, deviceExportURLSaved)) {
+		if err := postDeviceExport(target, devicesJSON); err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			deviceExportURLSaved = devicesJSON
+			deviceExportURLTarget = target
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func writeDeviceExportFile(path string, devicesJSON []byte) error {
+	data, err := marshalDeviceExport(devicesJSON)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+func postDeviceExport(target string, devicesJSON []byte) error {
+	data, err := marshalDeviceExport(devicesJSON)
+	if err != nil {
+		return err
+	}
+	resp, err := deviceExportClient.Post(target, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("device export POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("device export POST returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// startDeviceExportTimer checks the device table every DeviceExportSeconds. The targets
+// are read on each tick, so they can be changed without a restart.
+func startDeviceExportTimer() {
+	interval := time.Duration(getServerConfig().DeviceExportSeconds) * time.Second
+	if interval <= 0 {
+		return
+	}
+	deviceExportStart.Do(func() {
+		deviceExportStop = make(chan struct{})
+		deviceExportDone = make(chan struct{})
+		ticker := time.NewTicker(interval)
+		go func() {
+			defer close(deviceExportDone)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := exportDeviceTable(); err != nil {
+						slog.Warn("Failed to export device table", "error", err)
+					}
+				case <-deviceExportStop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// stopDeviceExportTimer stops the periodic export and exports one last time.
+func stopDeviceExportTimer() {
+	if deviceExportStop != nil {
+		select {
+		case <-deviceExportStop:
+		default:
+			close(deviceExportStop)
+		}
+		<-deviceExportDone
+	}
+	if err := exportDeviceTable(); err != nil {
+		slog.Warn("Failed to export device table", "error", err)
+	}
+}
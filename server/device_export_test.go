@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExportDeviceTable_WritesSanitizedFileAndPostsOnChange(t *testing.T) {
+	prevConfig := *getServerConfig()
+	mu.Lock()
+	tableBackup, linksBackup, lastSeenBackup := deviceTable, deviceLinks, deviceLastSeen
+	deviceTable = map[string]interface{}{
+		"d1": map[string]interface{}{
+			"system": map[string]interface{}{"name": "iPhone", "ip": "10.0.0.5", "running": true, "secret": "x"},
+			"script": map[string]interface{}{"select": "main.lua"},
+		},
+	}
+	deviceLinks = map[string]*SafeConn{"d1": {}}
+	deviceLastSeen = map[string]int64{"d1": 1700000000}
+	mu.Unlock()
+	deviceGroupsMu.Lock()
+	groupsBackup := deviceGroups
+	deviceGroups = []GroupInfo{{ID: "g1", Name: "farm-a", DeviceIDs: []string{"d1"}}}
+	deviceGroupsMu.Unlock()
+	t.Cleanup(func() {
+		setServerConfig(prevConfig)
+		mu.Lock()
+		deviceTable, deviceLinks, deviceLastSeen = tableBackup, linksBackup, lastSeenBackup
+		mu.Unlock()
+		deviceGroupsMu.Lock()
+		deviceGroups = groupsBackup
+		deviceGroupsMu.Unlock()
+	})
+
+	var posts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		posts.Add(1)
+	}))
+	t.Cleanup(server.Close)
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceExportFile = filepath.Join(t.TempDir(), "export", "devices.json") })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceExportURL = server.URL })
+	if err := exportDeviceTable(); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if err := exportDeviceTable(); err != nil {
+		t.Fatalf("second export failed: %v", err)
+	}
+	if n := posts.Load(); n != 1 {
+		t.Fatalf("unchanged table should be posted once, got %d", n)
+	}
+
+	data, err := os.ReadFile(getServerConfig().DeviceExportFile)
+	if err != nil {
+		t.Fatalf("expected export file: %v", err)
+	}
+	var doc struct {
+		ExportedAt int64                    `json:"exportedAt"`
+		Devices    []map[string]interface{} `json:"devices"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil || len(doc.Devices) != 1 {
+		t.Fatalf("unexpected export %s (%v)", data, err)
+	}
+	d := doc.Devices[0]
+	if d["udid"] != "d1" || d["name"] != "iPhone" || d["online"] != true || d["script"] != "main.lua" || d["secret"] != nil {
+		t.Fatalf("unexpected device entry %v", d)
+	}
+	if tags, _ := d["tags"].([]interface{}); len(tags) != 1 || tags[0] != "farm-a" {
+		t.Fatalf("expected group name as tag, got %v", d["tags"])
+	}
+
+	mu.Lock()
+	delete(deviceLinks, "d1")
+	mu.Unlock()
+	if err := exportDeviceTable(); err != nil {
+		t.Fatalf("export after change failed: %v", err)
+	}
+	if n := posts.Load(); n != 2 {
+		t.Fatalf("changed table should be posted again, got %d", n)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxDeviceLabelRunes = 64
+	maxDeviceColorRunes = 32
+)
+
+// DeviceLabel is a human-friendly name (and optional color) for a UDID.
+type DeviceLabel struct {
+	Label string `json:"label"`
+	Color string `json:"color,omitempty"`
+}
+
+var (
+	// deviceLabels maps UDID to its label. Unlike deviceTable it survives disconnects.
+	deviceLabels   = make(map[string]DeviceLabel)
+	deviceLabelsMu sync.RWMutex
+)
+
+// getDeviceLabelsFilePath returns the path to the device labels file
+func getDeviceLabelsFilePath() string {
+	return filepath.Join(getServerConfig().DataDir, "device-labels.json")
+}
+
+// loadDeviceLabels loads device labels from disk
+func loadDeviceLabels() error {
+	deviceLabelsMu.Lock()
+	defer deviceLabelsMu.Unlock()
+
+	data, err := readDeviceDataFile(getDeviceLabelsFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	labels := make(map[string]DeviceLabel)
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return err
+	}
+	deviceLabels = labels
+	return nil
+}
+
+func saveDeviceLabelsSnapshot(labels map[string]DeviceLabel) error {
+	data, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDeviceDataFile(getDeviceLabelsFilePath(), data, 0644)
+}
+
+// sanitizeDeviceLabelText strips control characters and surrounding whitespace.
+func sanitizeDeviceLabelText(value string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+// normalizeDeviceLabel validates a label/color pair from a controller.
+func normalizeDeviceLabel(label, color string) (DeviceLabel, error) {
+	label = sanitizeDeviceLabelText(label)
+	if label == "" {
+		return DeviceLabel{}, fmt.Errorf("label cannot be empty")
+	}
+	if utf8.RuneCountInString(label) > maxDeviceLabelRunes {
+		return DeviceLabel{}, fmt.Errorf("label must be at most %d characters", maxDeviceLabelRunes)
+	}
+	color = sanitizeDeviceLabelText(color)
+	if utf8.RuneCountInString(color) > maxDeviceColorRunes {
+		return DeviceLabel{}, fmt.Errorf("color must be at most %d characters", maxDeviceColorRunes)
+	}
+	return DeviceLabel{Label: label, Color: color}, nil
+}
+
+func getDeviceLabel(udid string) (DeviceLabel, bool) {
+	deviceLabelsMu.RLock()
+	defer deviceLabelsMu.RUnlock()
+	label, ok := deviceLabels[udid]
+	return label, ok
+}
+
+// setDeviceLabel stores and persists the label for udid, rolling back on save failure.
+func setDeviceLabel(udid string, label DeviceLabel) error {
+	deviceLabelsMu.Lock()
+	defer deviceLabelsMu.Unlock()
+
+	previous, existed := deviceLabels[udid]
+	deviceLabels[udid] = label
+	if err := saveDeviceLabelsSnapshot(deviceLabels); err != nil {
+		if existed {
+			deviceLabels[udid] = previous
+		} else {
+			delete(deviceLabels, udid)
+		}
+		return err
+	}
+	return nil
+}
+
+// applyDeviceLabel merges udid's label into an app/state body.
+func applyDeviceLabel(udid string, bodyMap map[string]interface{}) {
+	label, ok := getDeviceLabel(udid)
+	if !ok {
+		return
+	}
+	bodyMap["label"] = label.Label
+	if label.Color != "" {
+		bodyMap["labelColor"] = label.Color
+	} else {
+		delete(bodyMap, "labelColor")
+	}
+}
+
+// relabelDeviceTableEntry refreshes the label in udid's deviceTable entry. The entry is
+// replaced with a copy because other goroutines may be marshaling the old map.
+func relabelDeviceTableEntry(udid string) {
+	mu.Lock()
+	defer mu.Unlock()
+	stateMap, ok := deviceTable[udid].(map[string]interface{})
+	if !ok {
+		return
+	}
+	updated := make(map[string]interface{}, len(stateMap)+2)
+	for key, value := range stateMap {
+		updated[key] = value
+	}
+	applyDeviceLabel(udid, updated)
+	deviceTable[udid] = updated
+	deviceTableVersion++
+}
+
+func parseDeviceLabelBody(body interface{}) (string, DeviceLabel, error) {
+	bodyMap, ok := body.(map[string]interface{})
+	if !ok {
+		return "", DeviceLabel{}, fmt.Errorf("invalid control/device/label body")
+	}
+	udid, ok := toString(bodyMap["udid"])
+	udid = strings.TrimSpace(udid)
+	if !ok || udid == "" {
+		return "", DeviceLabel{}, fmt.Errorf("invalid udid in control/device/label")
+	}
+	rawLabel, _ := toString(bodyMap["label"])
+	var rawColor string
+	if value, exists := bodyMap["color"]; exists && value != nil {
+		if rawColor, ok = toString(value); !ok {
+			return "", DeviceLabel{}, fmt.Errorf("invalid color in control/device/label")
+		}
+	}
+	label, err := normalizeDeviceLabel(rawLabel, rawColor)
+	if err != nil {
+		return "", DeviceLabel{}, err
+	}
+	return udid, label, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func setupDeviceLabelsFixture(t *testing.T) {
+	t.Helper()
+	setupPersistenceWritableDataDir(t)
+
+	deviceLabelsMu.Lock()
+	backupLabels := deviceLabels
+	deviceLabels = make(map[string]DeviceLabel)
+	deviceLabelsMu.Unlock()
+
+	mu.Lock()
+	backupTable := deviceTable
+	deviceTable = make(map[string]interface{})
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		deviceLabelsMu.Lock()
+		deviceLabels = backupLabels
+		deviceLabelsMu.Unlock()
+		mu.Lock()
+		deviceTable = backupTable
+		mu.Unlock()
+	})
+}
+
+func TestNormalizeDeviceLabel(t *testing.T) {
+	label, err := normalizeDeviceLabel("  Rack\x00 A\n-01 ", "#ff0000\t")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if label.Label != "Rack A-01" || label.Color != "#ff0000" {
+		t.Fatalf("unexpected label: %+v", label)
+	}
+
+	if _, err := normalizeDeviceLabel(" \x07 ", ""); err == nil {
+		t.Fatalf("expected empty label to be rejected")
+	}
+	if _, err := normalizeDeviceLabel(strings.Repeat("设", maxDeviceLabelRunes), ""); err != nil {
+		t.Fatalf("expected %d runes to be accepted: %v", maxDeviceLabelRunes, err)
+	}
+	if _, err := normalizeDeviceLabel(strings.Repeat("设", maxDeviceLabelRunes+1), ""); err == nil {
+		t.Fatalf("expected overlong label to be rejected")
+	}
+}
+
+func TestSetDeviceLabel_PersistsAndReloads(t *testing.T) {
+	setupDeviceLabelsFixture(t)
+
+	if err := setDeviceLabel("d1", DeviceLabel{Label: "Phone 1", Color: "blue"}); err != nil {
+		t.Fatalf("setDeviceLabel failed: %v", err)
+	}
+
+	deviceLabelsMu.Lock()
+	deviceLabels = make(map[string]DeviceLabel)
+	deviceLabelsMu.Unlock()
+
+	if err := loadDeviceLabels(); err != nil {
+		t.Fatalf("loadDeviceLabels failed: %v", err)
+	}
+	got, ok := getDeviceLabel("d1")
+	if !ok || got.Label != "Phone 1" || got.Color != "blue" {
+		t.Fatalf("unexpected reloaded label: %+v ok=%v", got, ok)
+	}
+}
+
+func TestDeviceLabel_MergedIntoDeviceState(t *testing.T) {
+	setupDeviceLabelsFixture(t)
+
+	original := map[string]interface{}{"system": map[string]interface{}{"udid": "d1"}}
+	mu.Lock()
+	deviceTable["d1"] = original
+	mu.Unlock()
+
+	if err := setDeviceLabel("d1", DeviceLabel{Label: "Phone 1"}); err != nil {
+		t.Fatalf("setDeviceLabel failed: %v", err)
+	}
+	relabelDeviceTableEntry("d1")
+
+	mu.RLock()
+	state := deviceTable["d1"].(map[string]interface{})
+	mu.RUnlock()
+	if state["label"] != "Phone 1" {
+		t.Fatalf("expected label in device table, got %v", state["label"])
+	}
+	if _, exists := original["label"]; exists {
+		t.Fatalf("expected original state map to be left untouched")
+	}
+
+	bodyMap := map[string]interface{}{}
+	applyDeviceLabel("d1", bodyMap)
+	if bodyMap["label"] != "Phone 1" {
+		t.Fatalf("expected label merged into app/state body, got %v", bodyMap)
+	}
+	if _, exists := bodyMap["labelColor"]; exists {
+		t.Fatalf("expected no labelColor without color, got %v", bodyMap)
+	}
+}
+
+func TestParseDeviceLabelBody(t *testing.T) {
+	udid, label, err := parseDeviceLabelBody(map[string]interface{}{"udid": " d1 ", "label": "Phone", "color": "red"})
+	if err != nil || udid != "d1" || label.Label != "Phone" || label.Color != "red" {
+		t.Fatalf("unexpected parse result: udid=%q label=%+v err=%v", udid, label, err)
+	}
+	if _, _, err := parseDeviceLabelBody(map[string]interface{}{"label": "Phone"}); err == nil {
+		t.Fatalf("expected missing udid to be rejected")
+	}
+	if _, _, err := parseDeviceLabelBody(map[string]interface{}{"udid": "d1", "color": 1}); err == nil {
+		t.Fatalf("expected invalid color to be rejected")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	deviceLeaseDefaultTTL = 60 * time.Second
+	deviceLeaseMaxTTL     = time.Hour
+)
+
+var errDeviceLeased = fmt.Errorf("device leased by another controller")
+
+// controllerIDs gives each controller socket a stable ID so HTTP lease requests can name
+// the controller that should hold the lease. Guarded by mu.
+var controllerIDs = make(map[*SafeConn]string)
+
+// deviceLease grants one controller exclusive command rights to a device.
+type deviceLease struct {
+	UDID         string `json:"udid"`
+	ControllerID string `json:"controllerId"`
+	ExpiresAt    int64  `json:"expiresAt"`
+}
+
+var deviceLeases = struct {
+	sync.Mutex
+	entries map[string]deviceLease
+}{
+	entries: make(map[string]deviceLease),
+}
+
+// assignControllerIDLocked returns the ID of conn, creating one on first use.
+// Caller must hold mu.Lock.
+func assignControllerIDLocked(conn *SafeConn) string {
+	if id, ok := controllerIDs[conn]; ok {
+		return id
+	}
+	id := uuid.New().String()
+	controllerIDs[conn] = id
+	return id
+}
+
+func getControllerID(conn *SafeConn) string {
+	mu.RLock()
+	id, ok := controllerIDs[conn]
+	mu.RUnlock()
+	if ok {
+		return id
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return assignControllerIDLocked(conn)
+}
+
+func isControllerIDConnected(id string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	for conn, connID := range controllerIDs {
+		if connID == id && controllers[conn] {
+			return true
+		}
+	}
+	return false
+}
+
+// activeDeviceLeaseLocked returns the unexpired lease on udid, dropping it if expired.
+// Caller must hold deviceLeases.
+func activeDeviceLeaseLocked(udid string, now time.Time) (deviceLease, bool) {
+	lease, ok := deviceLeases.entries[udid]
+	if !ok {
+		return deviceLease{}, false
+	}
+	if now.Unix() >= lease.ExpiresAt {
+		delete(deviceLeases.entries, udid)
+		return deviceLease{}, false
+	}
+	return lease, true
+}
+
+// acquireDeviceLease grants or renews a lease. It fails if another controller holds one.
+func acquireDeviceLease(udid, controllerID string, ttl time.Duration) (deviceLease, error) {
+	now := time.Now()
+	deviceLeases.Lock()
+	defer deviceLeases.Unlock()
+
+	if existing, ok := activeDeviceLeaseLocked(udid, now); ok && existing.ControllerID != controllerID {
+		return existing, errDeviceLeased
+	}
+	lease := deviceLease{
+		UDID:         udid,
+		ControllerID: controllerID,
+		ExpiresAt:    now.Add(ttl).Unix(),
+	}
+	deviceLeases.entries[udid] = lease
+	return lease, nil
+}
+
+// releaseDeviceLease removes the lease on udid if it is held by controllerID.
+func releaseDeviceLease(udid, controllerID string) bool {
+	deviceLeases.Lock()
+	defer deviceLeases.Unlock()
+	lease, ok := deviceLeases.entries[udid]
+	if !ok || lease.ControllerID != controllerID {
+		return false
+	}
+	delete(deviceLeases.entries, udid)
+	return true
+}
+
+// releaseControllerLeases drops every lease held by controllerID.
+func releaseControllerLeases(controllerID string) int {
+	if controllerID == "" {
+		return 0
+	}
+	deviceLeases.Lock()
+	defer deviceLeases.Unlock()
+	released := 0
+	for udid, lease := range deviceLeases.entries {
+		if lease.ControllerID == controllerID {
+			delete(deviceLeases.entries, udid)
+			released++
+		}
+	}
+	return released
+}
+
+// filterLeasedDevices splits devices into those conn may command and those leased
+// by another controller.
+func filterLeasedDevices(conn *SafeConn, devices []string) (allowed, leased []string) {
+	deviceLeases.Lock()
+	empty := len(deviceLeases.entries) == 0
+	deviceLeases.Unlock()
+	if empty {
+		return devices, nil
+	}
+	return filterLeasedDevicesFor(getControllerID(conn), devices)
+}
+
+// filterLeasedDevicesFor is filterLeasedDevices for a controller ID; HTTP callers pass ""
+// and may only command devices nobody has leased.
+func filterLeasedDevicesFor(controllerID string, devices []string) (allowed, leased []string) {
+	now := time.Now()
+	allowed = make([]string, 0, len(devices))
+
+	deviceLeases.Lock()
+	defer deviceLeases.Unlock()
+	for _, udid := range devices {
+		if lease, ok := activeDeviceLeaseLocked(udid, now); ok && lease.ControllerID != controllerID {
+			leased = append(leased, udid)
+			continue
+		}
+		allowed = append(allowed, udid)
+	}
+	return allowed, leased
+}
+
+func listDeviceLeases() []deviceLease {
+	now := time.Now()
+	deviceLeases.Lock()
+	defer deviceLeases.Unlock()
+	leases := make([]deviceLease, 0, len(deviceLeases.entries))
+	for udid := range deviceLeases.entries {
+		if lease, ok := activeDeviceLeaseLocked(udid, now); ok {
+			leases = append(leases, lease)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].UDID < leases[j].UDID })
+	return leases
+}
+
+type deviceLeaseRequest struct {
+	UDID         string `json:"udid"`
+	TTL          int    `json:"ttl"`
+	ControllerID string `json:"controllerId"`
+}
+
+// deviceLeaseAcquireHandler handles POST /api/devices/lease
+func deviceLeaseAcquireHandler(c *gin.Context) {
+	var req deviceLeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	udid := strings.TrimSpace(req.UDID)
+	controllerID := strings.TrimSpace(req.ControllerID)
+	if udid == "" || controllerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "udid and controllerId are required"})
+		return
+	}
+	if req.TTL < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttl cannot be negative"})
+		return
+	}
+	if !isControllerIDConnected(controllerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "controller not connected"})
+		return
+	}
+
+	ttl := deviceLeaseDefaultTTL
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl > deviceLeaseMaxTTL {
+		ttl = deviceLeaseMaxTTL
+	}
+
+	lease, err := acquireDeviceLease(udid, controllerID, ttl)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "expiresAt": lease.ExpiresAt})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "lease": lease})
+}
+
+// deviceLeaseReleaseHandler handles DELETE /api/devices/lease
+func deviceLeaseReleaseHandler(c *gin.Context) {
+	var req deviceLeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	udid := strings.TrimSpace(req.UDID)
+	controllerID := strings.TrimSpace(req.ControllerID)
+	if udid == "" || controllerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "udid and controllerId are required"})
+		return
+	}
+	if !releaseDeviceLease(udid, controllerID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "lease not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// deviceLeasesListHandler handles GET /api/devices/leases
+func deviceLeasesListHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"leases": listDeviceLeases()})
+}
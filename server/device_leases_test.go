@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setupDeviceLeaseControllers(t *testing.T) (*SafeConn, *SafeConn) {
+	t.Helper()
+	owner, _ := newTestWebSocketPair(t)
+	other, _ := newTestWebSocketPair(t)
+
+	mu.Lock()
+	controllersBackup := controllers
+	controllerIDsBackup := controllerIDs
+	controllers = make(map[*SafeConn]bool)
+	controllerIDs = make(map[*SafeConn]string)
+	mu.Unlock()
+
+	deviceLeases.Lock()
+	leasesBackup := deviceLeases.entries
+	deviceLeases.entries = make(map[string]deviceLease)
+	deviceLeases.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		controllers = controllersBackup
+		controllerIDs = controllerIDsBackup
+		mu.Unlock()
+		deviceLeases.Lock()
+		deviceLeases.entries = leasesBackup
+		deviceLeases.Unlock()
+	})
+
+	ensureController(owner)
+	ensureController(other)
+	return owner, other
+}
+
+func TestDeviceLease_RejectsOtherControllersUntilReleasedOnDisconnect(t *testing.T) {
+	owner, other := setupDeviceLeaseControllers(t)
+	ownerID := getControllerID(owner)
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/devices/lease", map[string]interface{}{
+		"udid":         "d1",
+		"ttl":          30,
+		"controllerId": ownerID,
+	}, deviceLeaseAcquireHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected lease to be granted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/devices/lease", map[string]interface{}{
+		"udid":         "d1",
+		"controllerId": getControllerID(other),
+	}, deviceLeaseAcquireHandler)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected conflict for second controller, got %d", w.Code)
+	}
+
+	allowed, leased := filterLeasedDevices(other, []string{"d1", "d2"})
+	if !reflect.DeepEqual(allowed, []string{"d2"}) || !reflect.DeepEqual(leased, []string{"d1"}) {
+		t.Fatalf("unexpected filter result for other controller: allowed=%v leased=%v", allowed, leased)
+	}
+	allowed, leased = filterLeasedDevices(owner, []string{"d1", "d2"})
+	if len(allowed) != 2 || len(leased) != 0 {
+		t.Fatalf("lease holder should command all devices: allowed=%v leased=%v", allowed, leased)
+	}
+
+	handleDisconnection(owner)
+	allowed, leased = filterLeasedDevices(other, []string{"d1"})
+	if len(allowed) != 1 || len(leased) != 0 {
+		t.Fatalf("lease should be released on disconnect: allowed=%v leased=%v", allowed, leased)
+	}
+}
+
+func TestDeviceLease_ExpiredLeaseIsIgnored(t *testing.T) {
+	owner, other := setupDeviceLeaseControllers(t)
+
+	deviceLeases.Lock()
+	deviceLeases.entries["d1"] = deviceLease{
+		UDID:         "d1",
+		ControllerID: getControllerID(owner),
+		ExpiresAt:    time.Now().Add(-time.Second).Unix(),
+	}
+	deviceLeases.Unlock()
+
+	if _, leased := filterLeasedDevices(other, []string{"d1"}); len(leased) != 0 {
+		t.Fatalf("expired lease should not block commands, got leased=%v", leased)
+	}
+	if _, err := acquireDeviceLease("d1", getControllerID(other), time.Minute); err != nil {
+		t.Fatalf("expected expired lease to be replaceable: %v", err)
+	}
+}
+
+func TestDeviceLease_RequiresConnectedController(t *testing.T) {
+	setupDeviceLeaseControllers(t)
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/devices/lease", map[string]interface{}{
+		"udid":         "d1",
+		"controllerId": "missing",
+	}, deviceLeaseAcquireHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown controller, got %d", w.Code)
+	}
+}
+
+func TestDeviceLease_FiltersHTTPProxyRequests(t *testing.T) {
+	resetUsedNoncesForTest()
+	owner, _ := setupDeviceLeaseControllers(t)
+	if _, err := acquireDeviceLease("d1", getControllerID(owner), time.Minute); err != nil {
+		t.Fatalf("acquire lease failed: %v", err)
+	}
+
+	deviceConn, deviceClient := newTestWebSocketPair(t)
+	mu.Lock()
+	linksBackup, linksMapBackup := deviceLinks, deviceLinksMap
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn}
+	deviceLinksMap = map[*SafeConn]string{deviceConn: "d1"}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceLinks, deviceLinksMap = linksBackup, linksMapBackup
+		delete(binaryRoutes, "bin-1")
+		mu.Unlock()
+	})
+
+	requester, requesterClient := newTestWebSocketPair(t)
+	ensureController(requester)
+	send := func(conn *SafeConn, msgType, nonce, requestID string) {
+		t.Helper()
+		msg := signTestMessage(Message{Type: msgType, TS: time.Now().Unix(), Nonce: nonce, Body: map[string]interface{}{
+			"devices":   []interface{}{"d1"},
+			"requestId": requestID,
+			"method":    "GET",
+			"path":      "/api/status",
+		}})
+		if err := handleMessage(conn, msg); err != nil {
+			t.Fatalf("%s failed: %v", msgType, err)
+		}
+	}
+
+	for i, msgType := range []string{"control/http", "control/http-bin"} {
+		send(requester, msgType, fmt.Sprintf("lease-http-%d", i), "bin-1")
+		msg := readTestMessage(t, requesterClient)
+		body, _ := msg.Body.(map[string]interface{})
+		if msg.Type != msgType+"/error" || msg.Error != errDeviceLeased.Error() || !reflect.DeepEqual(body["devices"], []interface{}{"d1"}) {
+			t.Fatalf("expected %s/error for the leased device, got %#v", msgType, msg)
+		}
+	}
+	mu.RLock()
+	_, routed := binaryRoutes["bin-1"]
+	mu.RUnlock()
+	if routed {
+		t.Fatalf("rejected control/http-bin must not register a binary route")
+	}
+
+	send(owner, "control/http", "lease-http-owner", "owner-1")
+	msg := readTestMessage(t, deviceClient)
+	body, _ := msg.Body.(map[string]interface{})
+	if msg.Type != "http/request" || body["requestId"] != "owner-1" {
+		t.Fatalf("expected only the lease holder's request to reach the device, got %#v", msg)
+	}
+}
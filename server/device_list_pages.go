@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// requestedDevicesPageSize returns the page size a control/devices body asks for: its own
+// "pageSize", or DevicesPageSize when it only sets "paged": true. 0 means no paging.
+func requestedDevicesPageSize(body interface{}) int {
+	bodyMap, ok := body.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if size, ok := bodyMap["pageSize"].(float64); ok && size >= 1 {
+		return int(size)
+	}
+	if paged, _ := bodyMap["paged"].(bool); !paged {
+		return 0
+	}
+	if getServerConfig().DevicesPageSize > 0 {
+		return getServerConfig().DevicesPageSize
+	}
+	return DefaultConfig.DevicesPageSize
+}
+
+// buildDevicesPages splits the device table into control/devices/page messages sorted by
+// UDID, followed by control/devices/end. Returns nil when the table fits in one page.
+func buildDevicesPages(table map[string]interface{}, pageSize int, requestID string) []Message {
+	if pageSize <= 0 || len(table) <= pageSize {
+		return nil
+	}
+
+	udids := make([]string, 0, len(table))
+	for udid := range table {
+		udids = append(udids, udid)
+	}
+	sort.Strings(udids)
+
+	pages := (len(udids) + pageSize - 1) / pageSize
+	messages := make([]Message, 0, pages+1)
+	for page := 0; page < pages; page++ {
+		end := min((page+1)*pageSize, len(udids))
+		devices := make(map[string]interface{}, end-page*pageSize)
+		for _, udid := range udids[page*pageSize : end] {
+			devices[udid] = table[udid]
+		}
+		messages = append(messages, Message{
+			Type:      "control/devices/page",
+			RequestID: requestID,
+			Body: map[string]interface{}{
+				"page":    page + 1,
+				"pages":   pages,
+				"devices": devices,
+			},
+		})
+	}
+	messages = append(messages, Message{
+		Type:      "control/devices/end",
+		RequestID: requestID,
+		Body: map[string]interface{}{
+			"total": len(udids),
+			"pages": pages,
+		},
+	})
+	return messages
+}
+
+// sendDevicesList answers control/devices, paging the table when the controller asked
+// for it and the fleet is larger than one page.
+func sendDevicesList(conn *SafeConn, data Message) error {
+	table := snapshotDeviceTable(false)
+	messages := buildDevicesPages(table, requestedDevicesPageSize(data.Body), data.RequestID)
+	if messages == nil {
+		messages = []Message{{Type: "control/devices", Body: table}}
+	}
+
+	for _, msg := range messages {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if err := writeTextMessage(conn, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestRequestedDevicesPageSize(t *testing.T) {
+	backup := *getServerConfig()
+	defer func() {
+		setServerConfig(backup)
+	}()
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DevicesPageSize = 50 })
+
+	cases := []struct {
+		body interface{}
+		want int
+	}{
+		{nil, 0},
+		{map[string]interface{}{}, 0},
+		{map[string]interface{}{"paged": true}, 50},
+		{map[string]interface{}{"pageSize": float64(10)}, 10},
+		{map[string]interface{}{"paged": true, "pageSize": float64(0)}, 50},
+	}
+	for _, tc := range cases {
+		if got := requestedDevicesPageSize(tc.body); got != tc.want {
+			t.Fatalf("requestedDevicesPageSize(%#v) = %d, want %d", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestBuildDevicesPagesSplitsSortedTable(t *testing.T) {
+	table := map[string]interface{}{"d3": 3, "d1": 1, "d5": 5, "d2": 2, "d4": 4}
+
+	if pages := buildDevicesPages(table, 5, ""); pages != nil {
+		t.Fatalf("table that fits one page should use the single message, got %d messages", len(pages))
+	}
+
+	messages := buildDevicesPages(table, 2, "req-1")
+	if len(messages) != 4 {
+		t.Fatalf("expected 3 pages and an end message, got %d", len(messages))
+	}
+	wantPages := [][]string{{"d1", "d2"}, {"d3", "d4"}, {"d5"}}
+	for i, want := range wantPages {
+		msg := messages[i]
+		body := msg.Body.(map[string]interface{})
+		devices := body["devices"].(map[string]interface{})
+		if msg.Type != "control/devices/page" || msg.RequestID != "req-1" || body["page"] != i+1 || body["pages"] != 3 || len(devices) != len(want) {
+			t.Fatalf("unexpected page %d: %#v", i+1, msg)
+		}
+		for _, udid := range want {
+			if _, ok := devices[udid]; !ok {
+				t.Fatalf("page %d missing %s: %#v", i+1, udid, devices)
+			}
+		}
+	}
+	end := messages[3]
+	body := end.Body.(map[string]interface{})
+	if end.Type != "control/devices/end" || body["total"] != 5 || body["pages"] != 3 {
+		t.Fatalf("unexpected end message: %#v", end)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// pendingOffline is the grace timer of a device that disconnected but has not been
+// announced offline yet.
+type pendingOffline struct {
+	timer *time.Timer
+}
+
+// pendingOfflineDevices maps device UDID to its grace timer. Guarded by mu.
+var pendingOfflineDevices = make(map[string]*pendingOffline)
+
+func getDisconnectGrace() time.Duration {
+	return time.Duration(getServerConfig().DisconnectGraceSeconds) * time.Second
+}
+
+// forgetOfflineDeviceLocked drops the state kept for a device until it is announced
+// offline: its last app/state and its log and screen subscriptions. Caller must hold mu.Lock.
+func forgetOfflineDeviceLocked(udid string) {
+	delete(deviceTable, udid)
+	forgetDeviceStateLocked(udid)
+	delete(logSubscriptions, udid)
+	forgetLogStream(udid)
+	delete(screenSubscriptions, udid)
+	closeMJPEGViewersLocked(udid)
+}
+
+// markDeviceRetainedLocked flags udid's last state with "disconnectedAt" (unix seconds) while
+// it waits out the grace period, so listings can tell a retained device from a connected
+// one. The entry is replaced with a copy because other goroutines may be marshaling the old
+// map; the next app/state replaces it. Caller must hold mu.Lock.
+func markDeviceRetainedLocked(udid string, now time.Time) {
+	stateMap, ok := deviceTable[udid].(map[string]interface{})
+	if !ok {
+		return
+	}
+	retained := make(map[string]interface{}, len(stateMap)+1)
+	for key, value := range stateMap {
+		retained[key] = value
+	}
+	retained["disconnectedAt"] = now.Unix()
+	deviceTable[udid] = retained
+	deviceTableVersion++
+}
+
+// scheduleDeviceOfflineLocked announces udid offline after grace unless it reconnects
+// first. Caller must hold mu.Lock.
+func scheduleDeviceOfflineLocked(udid string, grace time.Duration) {
+	if pending, ok := pendingOfflineDevices[udid]; ok {
+		pending.timer.Stop()
+	}
+	pending := &pendingOffline{}
+	pending.timer = time.AfterFunc(grace, func() {
+		expireDeviceOfflineGrace(udid, pending)
+	})
+	pendingOfflineDevices[udid] = pending
+}
+
+// cancelDeviceOfflineLocked stops udid's grace timer when it reconnects in time and
+// reports whether one was pending. Caller must hold mu.Lock.
+func cancelDeviceOfflineLocked(udid string) bool {
+	pending, ok := pendingOfflineDevices[udid]
+	if !ok {
+		return false
+	}
+	pending.timer.Stop()
+	delete(pendingOfflineDevices, udid)
+	return true
+}
+
+func expireDeviceOfflineGrace(udid string, pending *pendingOffline) {
+	var controllerList []*SafeConn
+	mu.Lock()
+	if pendingOfflineDevices[udid] != pending {
+		mu.Unlock()
+		return
+	}
+	delete(pendingOfflineDevices, udid)
+	if _, online := deviceLinks[udid]; online {
+		mu.Unlock()
+		return
+	}
+	system := deviceSystemInfoLocked(udid)
+	forgetOfflineDeviceLocked(udid)
+	if len(controllers) > 0 {
+		controllerList = snapshotControllerConnsLocked()
+	}
+	mu.Unlock()
+
+	wsDebug("Device offline grace expired", "udid", udid)
+	announceDeviceDisconnect(udid, system, controllerList)
+}
+
+// announceDeviceDisconnect records device/disconnect, notifies webhooks with the device's
+// last system info and sends it to controllers.
+func announceDeviceDisconnect(udid string, system map[string]interface{}, controllerList []*SafeConn) {
+	recordServerEvent("device/disconnect", udid, nil)
+	notifyDeviceWebhooks("device/disconnect", udid, system)
+	if len(controllerList) == 0 {
+		return
+	}
+
+	disconnectPayload, err := json.Marshal(Message{
+		Type: "device/disconnect",
+		Body: udid,
+	})
+	if err != nil {
+		slog.Error("Failed to marshal disconnect message", "udid", udid, "error", err)
+		return
+	}
+	for _, controllerConn := range controllerList {
+		writeTextMessageAsync(controllerConn, disconnectPayload)
+	}
+}
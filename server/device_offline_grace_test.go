@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDisconnectGrace_SuppressesBlipsAndAnnouncesLongOutages(t *testing.T) {
+	backupGrace := getServerConfig().DisconnectGraceSeconds
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DisconnectGraceSeconds = 60 })
+	mu.Lock()
+	linksBackup, linksMapBackup, controllersBackup := deviceLinks, deviceLinksMap, controllers
+	tableBackup, lifeBackup, pendingBackup := deviceTable, deviceLife, pendingOfflineDevices
+	deviceLinks = make(map[string]*SafeConn)
+	deviceLinksMap = make(map[*SafeConn]string)
+	controllers = make(map[*SafeConn]bool)
+	deviceTable = make(map[string]interface{})
+	deviceLife = make(map[string]int)
+	pendingOfflineDevices = make(map[string]*pendingOffline)
+	mu.Unlock()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.DisconnectGraceSeconds = backupGrace })
+		mu.Lock()
+		for _, pending := range pendingOfflineDevices {
+			pending.timer.Stop()
+		}
+		deviceLinks, deviceLinksMap, controllers = linksBackup, linksMapBackup, controllersBackup
+		deviceTable, deviceLife, pendingOfflineDevices = tableBackup, lifeBackup, pendingBackup
+		mu.Unlock()
+	})
+
+	controllerConn, controller := newTestWebSocketPair(t)
+	mu.Lock()
+	controllers[controllerConn] = true
+	mu.Unlock()
+	appState := Message{Type: "app/state", Body: map[string]interface{}{"system": map[string]interface{}{"udid": "d1"}}}
+
+	first, _ := newTestWebSocketPair(t)
+	if err := handleMessage(first, appState); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	readTestMessage(t, controller)
+
+	handleDisconnection(first)
+	mu.RLock()
+	_, kept := deviceTable["d1"]
+	_, pending := pendingOfflineDevices["d1"]
+	mu.RUnlock()
+	if !kept || !pending {
+		t.Fatalf("expected last state kept during grace, kept=%v pending=%v", kept, pending)
+	}
+	mu.RLock()
+	retained, _ := deviceTable["d1"].(map[string]interface{})
+	mu.RUnlock()
+	if _, marked := retained["disconnectedAt"]; !marked {
+		t.Fatalf("expected retained state to carry disconnectedAt, got %v", retained)
+	}
+
+	second, _ := newTestWebSocketPair(t)
+	if err := handleMessage(second, appState); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	if msg := readTestMessage(t, controller); msg.Type != "app/state" {
+		t.Fatalf("expected no device/disconnect for a blip, got %q", msg.Type)
+	}
+	mu.RLock()
+	_, pending = pendingOfflineDevices["d1"]
+	mu.RUnlock()
+	if pending {
+		t.Fatalf("reconnect should cancel the grace timer")
+	}
+	mu.RLock()
+	current, _ := deviceTable["d1"].(map[string]interface{})
+	mu.RUnlock()
+	if _, marked := current["disconnectedAt"]; marked {
+		t.Fatalf("reconnected state should not carry disconnectedAt, got %v", current)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DisconnectGraceSeconds = 0 })
+	mu.Lock()
+	scheduleDeviceOfflineLocked("d1", 10*time.Millisecond)
+	delete(deviceLinks, "d1")
+	delete(deviceLinksMap, second)
+	mu.Unlock()
+	msg := readTestMessage(t, controller)
+	if msg.Type != "device/disconnect" || msg.Body != "d1" {
+		t.Fatalf("expected device/disconnect after the grace period, got %+v", msg)
+	}
+	mu.RLock()
+	_, kept = deviceTable["d1"]
+	mu.RUnlock()
+	if kept {
+		t.Fatalf("expected state dropped after the grace period")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const mjpegBoundary = "xxtccframe"
+
+// mjpegScreenSink stands in for all MJPEG viewers of a device in screenSubscriptions, so
+// the device streams while only HTTP viewers are watching. Frames are not written to it.
+var mjpegScreenSink = &SafeConn{}
+
+// mjpegViewer is one open GET /api/devices/:udid/stream.mjpeg response.
+type mjpegViewer struct {
+	frames chan []byte // latest JPEG frame; older unsent frames are replaced
+	done   chan struct{}
+}
+
+// mjpegViewers maps device UDID to its MJPEG viewers. Guarded by mu.
+var mjpegViewers = make(map[string]map[*mjpegViewer]bool)
+
+func newMJPEGViewer() *mjpegViewer {
+	return &mjpegViewer{frames: make(chan []byte, 1), done: make(chan struct{})}
+}
+
+// offer hands frame to the viewer without blocking, dropping a frame it has not sent yet.
+func (v *mjpegViewer) offer(frame []byte) {
+	for {
+		select {
+		case v.frames <- frame:
+			return
+		default:
+		}
+		select {
+		case <-v.frames:
+		default:
+		}
+	}
+}
+
+// addMJPEGViewer registers viewer for udid and returns the device connection to send
+// screen/stream/start to when the device had no screen subscribers yet.
+func addMJPEGViewer(udid string, viewer *mjpegViewer) (*SafeConn, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	deviceConn, online := deviceLinks[udid]
+	if !online {
+		return nil, false
+	}
+	viewers := mjpegViewers[udid]
+	if viewers == nil {
+		viewers = make(map[*mjpegViewer]bool)
+		mjpegViewers[udid] = viewers
+	}
+	viewers[viewer] = true
+	if addSubscriberLocked(screenSubscriptions, udid, mjpegScreenSink) {
+		return deviceConn, true
+	}
+	return nil, true
+}
+
+// removeMJPEGViewer unregisters viewer and returns the device connection to send
+// screen/stream/stop to when it was the device's last screen subscriber.
+func removeMJPEGViewer(udid string, viewer *mjpegViewer) *SafeConn {
+	mu.Lock()
+	defer mu.Unlock()
+	viewers := mjpegViewers[udid]
+	if !viewers[viewer] {
+		return nil
+	}
+	delete(viewers, viewer)
+	if len(viewers) > 0 {
+		return nil
+	}
+	delete(mjpegViewers, udid)
+	if removeSubscriberLocked(screenSubscriptions, udid, mjpegScreenSink) {
+		return deviceLinks[udid]
+	}
+	return nil
+}
+
+// snapshotMJPEGViewersLocked returns udid's viewers. Caller must hold mu.RLock.
+func snapshotMJPEGViewersLocked(udid string) []*mjpegViewer {
+	viewers := mjpegViewers[udid]
+	if len(viewers) == 0 {
+		return nil
+	}
+	list := make([]*mjpegViewer, 0, len(viewers))
+	for viewer := range viewers {
+		list = append(list, viewer)
+	}
+	return list
+}
+
+// closeMJPEGViewersLocked ends the streams of a disconnected device. Caller must hold mu.Lock.
+func closeMJPEGViewersLocked(udid string) {
+	for viewer := range mjpegViewers[udid] {
+		close(viewer.done)
+	}
+	delete(mjpegViewers, udid)
+}
+
+func writeMJPEGPart(c *gin.Context, frame []byte) error {
+	if _, err := fmt.Fprintf(c.Writer, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame)); err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write(frame); err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write([]byte("\r\n")); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// deviceScreenMJPEGHandler handles GET /api/devices/:udid/stream.mjpeg
+func deviceScreenMJPEGHandler(c *gin.Context) {
+	udid := c.Param("udid")
+	viewer := newMJPEGViewer()
+	startConn, online := addMJPEGViewer(udid, viewer)
+	if !online {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not connected"})
+		return
+	}
+	if startConn != nil {
+		sendScreenStreamControl("screen/stream/start", []*SafeConn{startConn})
+	}
+	defer func() {
+		if stopConn := removeMJPEGViewer(udid, viewer); stopConn != nil {
+			sendScreenStreamControl("screen/stream/stop", []*SafeConn{stopConn})
+		}
+	}()
+
+	c.Header("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	c.Header("Cache-Control", "no-cache, no-store")
+	clearTransferRequestDeadlines(c)
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	if shot, ok := latestDeviceScreenshot(udid, time.Now()); ok && shot.contentType == "image/jpeg" {
+		if err := writeMJPEGPart(c, shot.data); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case frame := <-viewer.frames:
+			if err := writeMJPEGPart(c, frame); err != nil {
+				return
+			}
+		case <-viewer.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
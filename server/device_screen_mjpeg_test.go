@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeviceScreenMJPEGHandlerStreamsFramesAndStopsCapture(t *testing.T) {
+	backup := *getServerConfig()
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenshotCacheSize = 0 })
+	deviceConn, deviceClient := newTestWebSocketPair(t)
+
+	mu.Lock()
+	linksBackup, linkMapBackup, subsBackup := deviceLinks, deviceLinksMap, screenSubscriptions
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn}
+	deviceLinksMap = map[*SafeConn]string{deviceConn: "d1"}
+	screenSubscriptions = make(map[string]map[*SafeConn]bool)
+	mu.Unlock()
+	t.Cleanup(func() {
+		setServerConfig(backup)
+		mu.Lock()
+		deviceLinks, deviceLinksMap, screenSubscriptions = linksBackup, linkMapBackup, subsBackup
+		mjpegViewers = make(map[string]map[*mjpegViewer]bool)
+		mu.Unlock()
+		resetScreenFrameLimiter("d1")
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/devices/:udid/stream.mjpeg", deviceScreenMJPEGHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	missing, err := http.Get(server.URL + "/api/devices/missing/stream.mjpeg")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for offline device, got %d", missing.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/devices/d1/stream.mjpeg", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if msg := readTestMessage(t, deviceClient); msg.Type != "screen/stream/start" {
+		t.Fatalf("expected screen/stream/start, got %q", msg.Type)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	frame := []byte("\xff\xd8\xff\xe0jpeg-frame")
+	if err := forwardScreenFrame(deviceConn, Message{Type: "screen/frame", Body: screenFrameBody("jpeg", frame)}); err != nil {
+		t.Fatalf("forward frame failed: %v", err)
+	}
+	part, err := multipart.NewReader(resp.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("read part failed: %v", err)
+	}
+	data := make([]byte, len(frame))
+	if _, err := io.ReadFull(part, data); err != nil {
+		t.Fatalf("read frame failed: %v", err)
+	}
+	if part.Header.Get("Content-Type") != "image/jpeg" || string(data) != string(frame) {
+		t.Fatalf("unexpected part %v %q", part.Header, data)
+	}
+
+	cancel()
+	if msg := readTestMessage(t, deviceClient); msg.Type != "screen/stream/stop" {
+		t.Fatalf("expected screen/stream/stop after the last viewer left, got %q", msg.Type)
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(mjpegViewers["d1"]) != 0 || len(screenSubscriptions["d1"]) != 0 {
+		t.Fatalf("viewer subscription should be removed, got %v %v", mjpegViewers, screenSubscriptions)
+	}
+}
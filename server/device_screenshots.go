@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// screenshotCacheMaxAge is the Cache-Control max-age of served screenshots. Streaming
+// devices replace their frame several times per second, so clients should refetch soon.
+const screenshotCacheMaxAge = 2
+
+type deviceScreenshot struct {
+	udid        string
+	contentType string
+	data        []byte
+	capturedAt  time.Time
+}
+
+// deviceScreenshots is an LRU of the latest screen/frame image per device.
+var deviceScreenshots = struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently updated first
+}{
+	entries: make(map[string]*list.Element),
+	order:   list.New(),
+}
+
+func screenshotMaxAge() time.Duration {
+	return time.Duration(getServerConfig().ScreenshotMaxAgeSeconds) * time.Second
+}
+
+// decodeScreenshotBody extracts the image from a {"format": "jpeg"|"png", "data": base64}
+// frame body. Bodies without an image, or with anything but JPEG or PNG, are ignored.
+func decodeScreenshotBody(body interface{}) (string, []byte, bool) {
+	bodyMap, ok := body.(map[string]interface{})
+	if !ok {
+		return "", nil, false
+	}
+	encoded, _ := bodyMap["data"].(string)
+	if encoded == "" {
+		return "", nil, false
+	}
+	if comma := strings.IndexByte(encoded, ','); comma >= 0 && strings.HasPrefix(encoded, "data:") {
+		encoded = encoded[comma+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) == 0 {
+		return "", nil, false
+	}
+
+	format, _ := bodyMap["format"].(string)
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "jpeg", "jpg":
+		return "image/jpeg", data, true
+	case "png":
+		return "image/png", data, true
+	}
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png":
+		return contentType, data, true
+	}
+	return "", nil, false
+}
+
+// publishScreenFrameImage decodes the image in a screen frame from udid once, offering
+// JPEG frames to viewers and caching it as the device's latest screenshot, evicting the
+// least recently updated devices beyond ScreenshotCacheSize.
+func publishScreenFrameImage(udid string, body interface{}, viewers []*mjpegViewer, now time.Time) {
+	limit := getServerConfig().ScreenshotCacheSize
+	if udid == "" || (limit <= 0 && len(viewers) == 0) {
+		return
+	}
+	contentType, data, ok := decodeScreenshotBody(body)
+	if !ok {
+		return
+	}
+	if contentType == "image/jpeg" {
+		for _, viewer := range viewers {
+			viewer.offer(data)
+		}
+	}
+	if limit <= 0 {
+		return
+	}
+
+	shot := &deviceScreenshot{udid: udid, contentType: contentType, data: data, capturedAt: now}
+	deviceScreenshots.Lock()
+	defer deviceScreenshots.Unlock()
+	if elem, ok := deviceScreenshots.entries[udid]; ok {
+		elem.Value = shot
+		deviceScreenshots.order.MoveToFront(elem)
+	} else {
+		deviceScreenshots.entries[udid] = deviceScreenshots.order.PushFront(shot)
+	}
+	for deviceScreenshots.order.Len() > limit {
+		oldest := deviceScreenshots.order.Back()
+		deviceScreenshots.order.Remove(oldest)
+		delete(deviceScreenshots.entries, oldest.Value.(*deviceScreenshot).udid)
+	}
+}
+
+// latestDeviceScreenshot returns udid's cached screenshot unless it is older than
+// ScreenshotMaxAgeSeconds, in which case it is dropped.
+func latestDeviceScreenshot(udid string, now time.Time) (*deviceScreenshot, bool) {
+	deviceScreenshots.Lock()
+	defer deviceScreenshots.Unlock()
+	elem, ok := deviceScreenshots.entries[udid]
+	if !ok {
+		return nil, false
+	}
+	shot := elem.Value.(*deviceScreenshot)
+	if maxAge := screenshotMaxAge(); maxAge > 0 && now.Sub(shot.capturedAt) > maxAge {
+		deviceScreenshots.order.Remove(elem)
+		delete(deviceScreenshots.entries, udid)
+		return nil, false
+	}
+	return shot, true
+}
+
+// deviceScreenshotHandler handles GET /api/devices/:udid/screenshot
+func deviceScreenshotHandler(c *gin.Context) {
+	shot, ok := latestDeviceScreenshot(c.Param("udid"), time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no recent screenshot"})
+		return
+	}
+
+	c.Header("Content-Type", shot.contentType)
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", screenshotCacheMaxAge))
+	http.ServeContent(c.Writer, c.Request, "", shot.capturedAt, bytes.NewReader(shot.data))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"container/list"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testPNGHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func resetDeviceScreenshotsForTest(t *testing.T) {
+	t.Helper()
+	backup := *getServerConfig()
+	deviceScreenshots.Lock()
+	deviceScreenshots.entries = make(map[string]*list.Element)
+	deviceScreenshots.order = list.New()
+	deviceScreenshots.Unlock()
+	t.Cleanup(func() {
+		setServerConfig(backup)
+		deviceScreenshots.Lock()
+		deviceScreenshots.entries = make(map[string]*list.Element)
+		deviceScreenshots.order = list.New()
+		deviceScreenshots.Unlock()
+	})
+}
+
+func screenFrameBody(format string, data []byte) map[string]interface{} {
+	return map[string]interface{}{"format": format, "data": base64.StdEncoding.EncodeToString(data)}
+}
+
+func performScreenshotRequest(t *testing.T, udid string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/devices/"+udid+"/screenshot", nil)
+	for key, values := range header {
+		c.Request.Header[key] = values
+	}
+	c.Params = gin.Params{{Key: "udid", Value: udid}}
+	deviceScreenshotHandler(c)
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+func TestDeviceScreenshotHandlerServesLatestFrame(t *testing.T) {
+	resetDeviceScreenshotsForTest(t)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenshotCacheSize = 4 })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenshotMaxAgeSeconds = 300 })
+
+	if w := performScreenshotRequest(t, "d1", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before any frame, got %d", w.Code)
+	}
+
+	publishScreenFrameImage("d1", screenFrameBody("jpeg", []byte("old")), nil, time.Now().Add(-time.Minute))
+	publishScreenFrameImage("d1", screenFrameBody("", testPNGHeader), nil, time.Now())
+	w := performScreenshotRequest(t, "d1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Fatalf("expected detected image/png, got %q", got)
+	}
+	if w.Body.String() != string(testPNGHeader) {
+		t.Fatalf("expected latest frame bytes, got %q", w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") == "" || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("expected caching headers, got %v", w.Header())
+	}
+
+	header := http.Header{"If-Modified-Since": {w.Header().Get("Last-Modified")}}
+	if w := performScreenshotRequest(t, "d1", header); w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for unchanged frame, got %d", w.Code)
+	}
+}
+
+func TestDeviceScreenshotCacheEvictsAndExpires(t *testing.T) {
+	resetDeviceScreenshotsForTest(t)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenshotCacheSize = 2 })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenshotMaxAgeSeconds = 60 })
+	now := time.Now()
+
+	publishScreenFrameImage("d1", screenFrameBody("jpeg", []byte("1")), nil, now.Add(-2*time.Minute))
+	publishScreenFrameImage("d2", screenFrameBody("jpeg", []byte("2")), nil, now)
+	publishScreenFrameImage("d3", screenFrameBody("jpeg", []byte("3")), nil, now)
+	publishScreenFrameImage("d4", map[string]interface{}{"seq": 1}, nil, now)
+
+	if _, ok := latestDeviceScreenshot("d1", now); ok {
+		t.Fatalf("least recently updated device should be evicted")
+	}
+	if _, ok := latestDeviceScreenshot("d4", now); ok {
+		t.Fatalf("frames without image data should not be cached")
+	}
+	if shot, ok := latestDeviceScreenshot("d3", now); !ok || shot.contentType != "image/jpeg" {
+		t.Fatalf("expected d3 jpeg screenshot, got %+v", shot)
+	}
+	if _, ok := latestDeviceScreenshot("d2", now.Add(2*time.Minute)); ok {
+		t.Fatalf("screenshot past screenshotMaxAgeSeconds should not be served")
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenshotCacheSize = 0 })
+	publishScreenFrameImage("d5", screenFrameBody("jpeg", []byte("5")), nil, now)
+	if _, ok := latestDeviceScreenshot("d5", now); ok {
+		t.Fatalf("cache size 0 should disable caching")
+	}
+}
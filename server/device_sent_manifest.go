@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"sync"
+)
+
+// sentFetchPending is a large file whose transfer/fetch has not completed yet.
+type sentFetchPending struct {
+	targetPath  string
+	fingerprint string
+}
+
+// deviceSentManifest records script files already delivered to one device connection.
+type deviceSentManifest struct {
+	files   map[string]string           // targetPath -> fingerprint
+	pending map[string]sentFetchPending // requestID -> large file awaiting completion
+}
+
+var deviceSentManifests = struct {
+	sync.Mutex
+	entries map[string]*deviceSentManifest
+}{
+	entries: make(map[string]*deviceSentManifest),
+}
+
+func scriptSendDedupEnabled() bool {
+	return getServerConfig().ScriptSendDedup
+}
+
+func smallFileFingerprint(payload []byte) string {
+	sum := md5.Sum(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func largeFileFingerprint(md5Hash string, size int64) string {
+	return md5Hash + ":" + strconv.FormatInt(size, 10)
+}
+
+// getDeviceSentManifestLocked returns udid's manifest, creating it on demand.
+// Caller must hold deviceSentManifests.Lock.
+func getDeviceSentManifestLocked(udid string) *deviceSentManifest {
+	manifest := deviceSentManifests.entries[udid]
+	if manifest == nil {
+		manifest = &deviceSentManifest{
+			files:   make(map[string]string),
+			pending: make(map[string]sentFetchPending),
+		}
+		deviceSentManifests.entries[udid] = manifest
+	}
+	return manifest
+}
+
+// deviceHasSentFile reports whether targetPath was already sent to udid with fingerprint.
+func deviceHasSentFile(udid, targetPath, fingerprint string) bool {
+	deviceSentManifests.Lock()
+	defer deviceSentManifests.Unlock()
+	manifest := deviceSentManifests.entries[udid]
+	return manifest != nil && manifest.files[targetPath] == fingerprint
+}
+
+func recordDeviceSentFile(udid, targetPath, fingerprint string) {
+	deviceSentManifests.Lock()
+	getDeviceSentManifestLocked(udid).files[targetPath] = fingerprint
+	deviceSentManifests.Unlock()
+}
+
+// recordDeviceSentFetchPending remembers a large file until its transfer/fetch/complete.
+// Any earlier record for targetPath is dropped since the device file is being replaced.
+func recordDeviceSentFetchPending(udid, requestID, targetPath, fingerprint string) {
+	deviceSentManifests.Lock()
+	manifest := getDeviceSentManifestLocked(udid)
+	delete(manifest.files, targetPath)
+	manifest.pending[requestID] = sentFetchPending{targetPath: targetPath, fingerprint: fingerprint}
+	deviceSentManifests.Unlock()
+}
+
+// completeDeviceSentFetch records a finished transfer/fetch as sent when it succeeded.
+func completeDeviceSentFetch(udid, requestID string, success bool) {
+	deviceSentManifests.Lock()
+	defer deviceSentManifests.Unlock()
+	manifest := deviceSentManifests.entries[udid]
+	if manifest == nil {
+		return
+	}
+	pending, ok := manifest.pending[requestID]
+	if !ok {
+		return
+	}
+	delete(manifest.pending, requestID)
+	if success {
+		manifest.files[pending.targetPath] = pending.fingerprint
+	}
+}
+
+// clearDeviceSentManifest forgets everything sent to udid (disconnect or forceResend).
+func clearDeviceSentManifest(udid string) {
+	deviceSentManifests.Lock()
+	delete(deviceSentManifests.entries, udid)
+	deviceSentManifests.Unlock()
+}
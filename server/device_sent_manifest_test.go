@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestSendSmallFilesToConn_DedupSkipsUnchangedFiles(t *testing.T) {
+	conn, client := newTestWebSocketPair(t)
+	t.Cleanup(func() { clearDeviceSentManifest("d1") })
+
+	files := []scriptFileData{
+		{Path: "/lua/scripts/a.lua", Data: base64.StdEncoding.EncodeToString([]byte("print(1)")), Size: 8},
+		{Path: "/lua/scripts/b.lua", Data: base64.StdEncoding.EncodeToString([]byte("print(2)")), Size: 8},
+	}
+	if unchanged := newScriptFileSender(files, nil).sendSmallFilesToConn(conn, "d1", true); unchanged != 0 {
+		t.Fatalf("first send should not skip, got %d", unchanged)
+	}
+	for range files {
+		if msg := readTestMessage(t, client); msg.Type != "file/put" {
+			t.Fatalf("expected file/put, got %s", msg.Type)
+		}
+	}
+
+	files[1].Data = base64.StdEncoding.EncodeToString([]byte("print(3)"))
+	if unchanged := newScriptFileSender(files, nil).sendSmallFilesToConn(conn, "d1", true); unchanged != 1 {
+		t.Fatalf("expected unchanged a.lua to be skipped, got %d", unchanged)
+	}
+	msg := readTestMessage(t, client)
+	if body, _ := msg.Body.(map[string]interface{}); body["path"] != "/lua/scripts/b.lua" {
+		t.Fatalf("expected only the changed file to be resent, got %v", msg.Body)
+	}
+
+	clearDeviceSentManifest("d1")
+	if unchanged := newScriptFileSender(files, nil).sendSmallFilesToConn(conn, "d1", true); unchanged != 0 {
+		t.Fatalf("cleared manifest should resend everything, got %d skipped", unchanged)
+	}
+}
+
+func TestCompleteDeviceSentFetch_RecordsOnlySuccessfulTransfers(t *testing.T) {
+	t.Cleanup(func() { clearDeviceSentManifest("d1") })
+	fingerprint := largeFileFingerprint("abc", 1024)
+
+	recordDeviceSentFetchPending("d1", "r1", "/lua/scripts/big.bin", fingerprint)
+	completeDeviceSentFetch("d1", "r1", false)
+	if deviceHasSentFile("d1", "/lua/scripts/big.bin", fingerprint) {
+		t.Fatalf("failed transfer must not be recorded")
+	}
+
+	recordDeviceSentFetchPending("d1", "r2", "/lua/scripts/big.bin", fingerprint)
+	if deviceHasSentFile("d1", "/lua/scripts/big.bin", fingerprint) {
+		t.Fatalf("pending transfer must not count as sent")
+	}
+	completeDeviceSentFetch("d1", "r2", true)
+	if !deviceHasSentFile("d1", "/lua/scripts/big.bin", fingerprint) {
+		t.Fatalf("expected completed transfer to be recorded")
+	}
+	if deviceHasSentFile("d1", "/lua/scripts/big.bin", largeFileFingerprint("def", 1024)) {
+		t.Fatalf("changed content must not match")
+	}
+}
@@ -172,7 +172,7 @@ func persistDeviceScreenshot(deviceName, deviceIP string, data []byte, now time.
 		sanitizeSnapshotPathSegment(deviceName, "device"),
 		sanitizeSnapshotPathSegment(deviceIP, "unknown"),
 	)
-	baseDir := filepath.Join(serverConfig.DataDir, "files", "snapshots", folderName)
+	baseDir := filepath.Join(getServerConfig().DataDir, "files", "snapshots", folderName)
 	if err := os.MkdirAll(baseDir, 0o755); err != nil {
 		return "", err
 	}
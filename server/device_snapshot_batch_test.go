@@ -109,7 +109,7 @@ func TestSnapshotSaveBatchHandlerWritesScreenshotAndSanitizesPath(t *testing.T)
 		t.Fatalf("unexpected path: %s", resp.Results[0].Path)
 	}
 
-	data, err := os.ReadFile(filepath.Join(serverConfig.DataDir, filepath.FromSlash(resp.Results[0].Path)))
+	data, err := os.ReadFile(filepath.Join(getServerConfig().DataDir, filepath.FromSlash(resp.Results[0].Path)))
 	if err != nil {
 		t.Fatalf("read saved file: %v", err)
 	}
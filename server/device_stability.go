@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deviceStabilityEntry tracks one UDID's current session and how often it re-registered.
+type deviceStabilityEntry struct {
+	ConnectedAt    int64 // unix seconds of the current session (0 = offline)
+	ReconnectCount int   // registrations after the first since server start
+	Registered     bool  // registered since server start (false for entries restored from disk)
+
+	// Kept in the device state snapshot across restarts
+	Sessions        int   // sessions ever started
+	LastConnectedAt int64 // unix seconds the latest session started
+	OnlineSeconds   int64 // time online in ended sessions
+}
+
+// deviceStats is the persisted part of a deviceStabilityEntry; OnlineSeconds includes the
+// current session up to the time it was saved.
+type deviceStats struct {
+	Sessions        int   `json:"sessions"`
+	LastConnectedAt int64 `json:"lastConnectedAt"`
+	OnlineSeconds   int64 `json:"onlineSeconds"`
+}
+
+// deviceStability is keyed by UDID and kept across disconnects. Guarded by mu.
+var deviceStability = make(map[string]*deviceStabilityEntry)
+
+// recordDeviceConnectLocked starts a new session for udid, counting it as a reconnect if
+// the UDID registered before. Caller must hold mu.Lock.
+func recordDeviceConnectLocked(udid string, now time.Time) {
+	entry := deviceStability[udid]
+	if entry == nil {
+		entry = &deviceStabilityEntry{}
+		deviceStability[udid] = entry
+	}
+	if entry.Registered {
+		entry.ReconnectCount++
+	}
+	entry.Registered = true
+	entry.ConnectedAt = now.Unix()
+	entry.LastConnectedAt = entry.ConnectedAt
+	entry.Sessions++
+}
+
+// recordDeviceDisconnectLocked ends udid's current session. Caller must hold mu.Lock.
+func recordDeviceDisconnectLocked(udid string) {
+	endDeviceSessionLocked(udid, time.Now())
+}
+
+// endDeviceSessionLocked adds udid's current session to its online time and marks it
+// offline. Caller must hold mu.Lock.
+func endDeviceSessionLocked(udid string, now time.Time) {
+	entry := deviceStability[udid]
+	if entry == nil || entry.ConnectedAt == 0 {
+		return
+	}
+	if elapsed := now.Unix() - entry.ConnectedAt; elapsed > 0 {
+		entry.OnlineSeconds += elapsed
+	}
+	entry.ConnectedAt = 0
+	deviceTableVersion++
+}
+
+// deviceStatsLocked returns the persisted view of entry at now. Caller must hold mu.
+func deviceStatsLocked(entry *deviceStabilityEntry, now time.Time) deviceStats {
+	stats := deviceStats{
+		Sessions:        entry.Sessions,
+		LastConnectedAt: entry.LastConnectedAt,
+		OnlineSeconds:   entry.OnlineSeconds,
+	}
+	if entry.ConnectedAt > 0 {
+		if elapsed := now.Unix() - entry.ConnectedAt; elapsed > 0 {
+			stats.OnlineSeconds += elapsed
+		}
+	}
+	return stats
+}
+
+// snapshotDeviceStatsLocked returns the stats of every tracked device for the device state
+// snapshot. Caller must hold mu.
+func snapshotDeviceStatsLocked(now time.Time) map[string]deviceStats {
+	if len(deviceStability) == 0 {
+		return nil
+	}
+	stats := make(map[string]deviceStats, len(deviceStability))
+	for udid, entry := range deviceStability {
+		stats[udid] = deviceStatsLocked(entry, now)
+	}
+	return stats
+}
+
+// restoreDeviceStatsLocked loads saved stats for devices that have not registered since
+// the server started. Caller must hold mu.Lock.
+func restoreDeviceStatsLocked(saved map[string]deviceStats) {
+	for udid, stats := range saved {
+		if udid == "" || deviceStability[udid] != nil {
+			continue
+		}
+		deviceStability[udid] = &deviceStabilityEntry{
+			Sessions:        stats.Sessions,
+			LastConnectedAt: stats.LastConnectedAt,
+			OnlineSeconds:   stats.OnlineSeconds,
+		}
+	}
+}
+
+// deviceStabilityStateLocked returns the "stability" object embedded in a device's
+// app/state. Caller must hold mu.
+func deviceStabilityStateLocked(udid string) gin.H {
+	entry := deviceStability[udid]
+	if entry == nil {
+		return gin.H{"connectedAt": int64(0), "reconnectCount": 0}
+	}
+	return gin.H{"connectedAt": entry.ConnectedAt, "reconnectCount": entry.ReconnectCount}
+}
+
+type deviceStabilityInfo struct {
+	UDID           string `json:"udid"`
+	Online         bool   `json:"online"`
+	ConnectedAt    int64  `json:"connectedAt"`
+	UptimeSeconds  int64  `json:"uptimeSeconds"`
+	ReconnectCount int    `json:"reconnectCount"`
+}
+
+// listDeviceStability returns every tracked device, most reconnects first.
+func listDeviceStability(now time.Time) []deviceStabilityInfo {
+	mu.RLock()
+	list := make([]deviceStabilityInfo, 0, len(deviceStability))
+	for udid, entry := range deviceStability {
+		info := deviceStabilityInfo{
+			UDID:           udid,
+			ReconnectCount: entry.ReconnectCount,
+		}
+		if _, online := deviceLinks[udid]; online && entry.ConnectedAt > 0 {
+			info.Online = true
+			info.ConnectedAt = entry.ConnectedAt
+			if uptime := now.Unix() - entry.ConnectedAt; uptime > 0 {
+				info.UptimeSeconds = uptime
+			}
+		}
+		list = append(list, info)
+	}
+	mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ReconnectCount != list[j].ReconnectCount {
+			return list[i].ReconnectCount > list[j].ReconnectCount
+		}
+		return list[i].UDID < list[j].UDID
+	})
+	return list
+}
+
+type deviceStatsInfo struct {
+	UDID           string `json:"udid"`
+	Online         bool   `json:"online"`
+	ConnectedAt    int64  `json:"connectedAt"`
+	UptimeSeconds  int64  `json:"uptimeSeconds"`
+	ReconnectCount int    `json:"reconnectCount"`
+	deviceStats
+	AvgSessionSeconds int64 `json:"avgSessionSeconds"`
+}
+
+// getDeviceStats returns udid's connection history, counting the current session's uptime
+// in onlineSeconds.
+func getDeviceStats(udid string, now time.Time) (deviceStatsInfo, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	entry := deviceStability[udid]
+	if entry == nil {
+		return deviceStatsInfo{}, false
+	}
+	info := deviceStatsInfo{
+		UDID:           udid,
+		ReconnectCount: entry.ReconnectCount,
+		deviceStats:    deviceStatsLocked(entry, now),
+	}
+	if _, online := deviceLinks[udid]; online && entry.ConnectedAt > 0 {
+		info.Online = true
+		info.ConnectedAt = entry.ConnectedAt
+		if uptime := now.Unix() - entry.ConnectedAt; uptime > 0 {
+			info.UptimeSeconds = uptime
+		}
+	}
+	if info.Sessions > 0 {
+		info.AvgSessionSeconds = info.OnlineSeconds / int64(info.Sessions)
+	}
+	return info, true
+}
+
+// deviceStatsHandler handles GET /api/devices/:udid/stats
+func deviceStatsHandler(c *gin.Context) {
+	info, ok := getDeviceStats(c.Param("udid"), time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no connection history for device"})
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// deviceStabilityHandler handles GET /api/devices/stability
+func deviceStabilityHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"devices": listDeviceStability(time.Now())})
+}
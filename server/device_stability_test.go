@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeviceStability_CountsReconnectsAndUptime(t *testing.T) {
+	mu.Lock()
+	stabilityBackup := deviceStability
+	linksBackup := deviceLinks
+	deviceStability = make(map[string]*deviceStabilityEntry)
+	deviceLinks = make(map[string]*SafeConn)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceStability = stabilityBackup
+		deviceLinks = linksBackup
+		mu.Unlock()
+	})
+
+	start := time.Unix(1700000000, 0)
+	mu.Lock()
+	recordDeviceConnectLocked("stable", start)
+	recordDeviceConnectLocked("flaky", start)
+	for i := 1; i <= 3; i++ {
+		recordDeviceDisconnectLocked("flaky")
+		recordDeviceConnectLocked("flaky", start.Add(time.Duration(i)*time.Minute))
+	}
+	recordDeviceConnectLocked("gone", start)
+	recordDeviceDisconnectLocked("gone")
+	deviceLinks["stable"] = &SafeConn{}
+	deviceLinks["flaky"] = &SafeConn{}
+	state := deviceStabilityStateLocked("flaky")
+	mu.Unlock()
+	if state["reconnectCount"] != 3 || state["connectedAt"] != start.Add(3*time.Minute).Unix() {
+		t.Fatalf("unexpected stability state: %v", state)
+	}
+
+	list := listDeviceStability(start.Add(time.Hour))
+	if len(list) != 3 || list[0].UDID != "flaky" || list[0].ReconnectCount != 3 {
+		t.Fatalf("expected flaky device first, got %+v", list)
+	}
+	if list[0].UptimeSeconds != int64(57*60) || !list[0].Online {
+		t.Fatalf("unexpected flaky uptime: %+v", list[0])
+	}
+	for _, info := range list[1:] {
+		switch info.UDID {
+		case "stable":
+			if !info.Online || info.UptimeSeconds != 3600 || info.ReconnectCount != 0 {
+				t.Fatalf("unexpected stable entry: %+v", info)
+			}
+		case "gone":
+			if info.Online || info.UptimeSeconds != 0 || info.ConnectedAt != 0 {
+				t.Fatalf("unexpected offline entry: %+v", info)
+			}
+		}
+	}
+}
+
+func TestDeviceStats_AccumulatesSessionsAndSurvivesSnapshot(t *testing.T) {
+	setupDeviceStateSnapshotTest(t)
+	mu.Lock()
+	stabilityBackup := deviceStability
+	linksBackup := deviceLinks
+	deviceStability = make(map[string]*deviceStabilityEntry)
+	deviceLinks = make(map[string]*SafeConn)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceStability = stabilityBackup
+		deviceLinks = linksBackup
+		mu.Unlock()
+	})
+
+	start := time.Now().Add(-time.Hour)
+	mu.Lock()
+	recordDeviceConnectLocked("d1", start)
+	endDeviceSessionLocked("d1", start.Add(10*time.Minute))
+	recordDeviceConnectLocked("d1", start.Add(20*time.Minute))
+	endDeviceSessionLocked("d1", start.Add(30*time.Minute))
+	recordDeviceConnectLocked("d1", start.Add(50*time.Minute))
+	deviceLinks["d1"] = &SafeConn{}
+	mu.Unlock()
+
+	info, ok := getDeviceStats("d1", start.Add(time.Hour))
+	if !ok || info.Sessions != 3 || info.ReconnectCount != 2 || !info.Online {
+		t.Fatalf("unexpected stats: %+v", info)
+	}
+	if info.OnlineSeconds != 30*60 || info.UptimeSeconds != 10*60 || info.AvgSessionSeconds != 10*60 {
+		t.Fatalf("unexpected durations: %+v", info)
+	}
+
+	router := gin.New()
+	router.GET("/api/devices/:udid/stats", deviceStatsHandler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/devices/unknown/stats", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown device, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/devices/d1/stats", nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["sessions"] != float64(3) {
+		t.Fatalf("unexpected stats response %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := flushDeviceStateSnapshot(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	mu.Lock()
+	deviceStability = make(map[string]*deviceStabilityEntry)
+	deviceLinks = make(map[string]*SafeConn)
+	mu.Unlock()
+	if _, err := loadDeviceStateSnapshot(); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	restored, ok := getDeviceStats("d1", time.Now())
+	if !ok || restored.Online || restored.Sessions != 3 || restored.OnlineSeconds < 30*60 {
+		t.Fatalf("expected stats restored offline, got %+v", restored)
+	}
+	mu.Lock()
+	recordDeviceConnectLocked("d1", time.Now())
+	reconnects := deviceStability["d1"].ReconnectCount
+	mu.Unlock()
+	if reconnects != 0 {
+		t.Fatalf("first registration after restart should not count as a reconnect, got %d", reconnects)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deviceStateSnapshotEntry is one device in the on-disk device table snapshot.
+type deviceStateSnapshotEntry struct {
+	State    interface{} `json:"state"`
+	LastSeen int64       `json:"lastSeen"`
+}
+
+type deviceStateSnapshotFile struct {
+	SavedAt int64                               `json:"savedAt"`
+	Devices map[string]deviceStateSnapshotEntry `json:"devices"`
+	Stats   map[string]deviceStats              `json:"deviceStats,omitempty"`
+}
+
+var (
+	// deviceLastSeen records when each device last reported app/state. Guarded by mu.
+	deviceLastSeen = make(map[string]int64)
+	// deviceStateSeq records the last stateSeq accepted from each device. Guarded by mu.
+	deviceStateSeq = make(map[string]float64)
+	// deviceTableVersion increases on every deviceTable change. Guarded by mu.
+	deviceTableVersion uint64
+
+	deviceStateSnapshotMu    sync.Mutex // serializes snapshot writes
+	deviceStateSavedVersion  uint64
+	deviceStateSavedPath     string
+	deviceStateSnapshotStop  chan struct{}
+	deviceStateSnapshotDone  chan struct{}
+	deviceStateSnapshotStart sync.Once
+)
+
+// touchDeviceStateLocked records a fresh app/state from udid. Caller must hold mu.Lock.
+func touchDeviceStateLocked(udid string, now time.Time) {
+	deviceLastSeen[udid] = now.Unix()
+	deviceTableVersion++
+}
+
+// acceptDeviceStateSeqLocked reports whether an app/state body may replace udid's stored
+// state. Bodies without a numeric stateSeq are always accepted; otherwise the seq must be
+// greater than the last one accepted, so a delayed update cannot overwrite a newer one.
+// Caller must hold mu.Lock.
+func acceptDeviceStateSeqLocked(udid string, bodyMap map[string]interface{}) bool {
+	seq, ok := bodyMap["stateSeq"].(float64)
+	if !ok {
+		return true
+	}
+	if last, seen := deviceStateSeq[udid]; seen && seq <= last {
+		return false
+	}
+	deviceStateSeq[udid] = seq
+	return true
+}
+
+// resetDeviceStateSeqLocked forgets udid's last stateSeq when the device registers a new
+// connection: the counter may restart on reconnect, and the stored seq belongs to the old
+// socket. Caller must hold mu.Lock.
+func resetDeviceStateSeqLocked(udid string) {
+	delete(deviceStateSeq, udid)
+}
+
+// forgetDeviceStateLocked records that udid left deviceTable. Caller must hold mu.Lock.
+func forgetDeviceStateLocked(udid string) {
+	delete(deviceLastSeen, udid)
+	delete(deviceStateSeq, udid)
+	deviceTableVersion++
+}
+
+func getDeviceStateFlushInterval() time.Duration {
+	return time.Duration(getServerConfig().DeviceStateFlushSeconds) * time.Second
+}
+
+// flushDeviceStateSnapshot writes deviceTable to DeviceStateFile. Nothing is written when
+// the feature is disabled or the table has not changed since the last successful write.
+func flushDeviceStateSnapshot() error {
+	path := strings.TrimSpace(getServerConfig().DeviceStateFile)
+	if path == "" {
+		return nil
+	}
+
+	deviceStateSnapshotMu.Lock()
+	defer deviceStateSnapshotMu.Unlock()
+
+	mu.RLock()
+	version := deviceTableVersion
+	if version == deviceStateSavedVersion && path == deviceStateSavedPath {
+		mu.RUnlock()
+		return nil
+	}
+	now := time.Now()
+	snapshot := deviceStateSnapshotFile{
+		SavedAt: now.Unix(),
+		Devices: make(map[string]deviceStateSnapshotEntry, len(deviceTable)),
+		Stats:   snapshotDeviceStatsLocked(now),
+	}
+	for udid, state := range deviceTable {
+		snapshot.Devices[udid] = deviceStateSnapshotEntry{State: state, LastSeen: deviceLastSeen[udid]}
+	}
+	mu.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := writeDeviceDataFile(path, data, 0644); err != nil {
+		return err
+	}
+	deviceStateSavedVersion = version
+	deviceStateSavedPath = path
+	return nil
+}
+
+// loadDeviceStateSnapshot restores the last saved device table and connection stats.
+// Restored devices are marked "stale" with their "lastSeen" time until they reconnect and
+// send app/state.
+func loadDeviceStateSnapshot() (int, error) {
+	path := strings.TrimSpace(getServerConfig().DeviceStateFile)
+	if path == "" {
+		return 0, nil
+	}
+
+	data, err := readDeviceDataFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var snapshot deviceStateSnapshotFile
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("failed to parse device state file: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	restoreDeviceStatsLocked(snapshot.Stats)
+	restored := 0
+	for udid, entry := range snapshot.Devices {
+		stateMap, ok := entry.State.(map[string]interface{})
+		if !ok || udid == "" {
+			continue
+		}
+		if _, exists := deviceTable[udid]; exists {
+			continue
+		}
+		stateMap["stale"] = true
+		stateMap["lastSeen"] = entry.LastSeen
+		deviceTable[udid] = stateMap
+		deviceLastSeen[udid] = entry.LastSeen
+		restored++
+	}
+	deviceStateSnapshotMu.Lock()
+	deviceStateSavedVersion = deviceTableVersion
+	deviceStateSavedPath = path
+	deviceStateSnapshotMu.Unlock()
+	return restored, nil
+}
+
+// startDeviceStateSnapshotTimer flushes the device table every DeviceStateFlushSeconds.
+func startDeviceStateSnapshotTimer() {
+	interval := getDeviceStateFlushInterval()
+	if interval <= 0 {
+		return
+	}
+	deviceStateSnapshotStart.Do(func() {
+		deviceStateSnapshotStop = make(chan struct{})
+		deviceStateSnapshotDone = make(chan struct{})
+		ticker := time.NewTicker(interval)
+		go func() {
+			defer close(deviceStateSnapshotDone)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := flushDeviceStateSnapshot(); err != nil {
+						slog.Warn("Failed to save device state snapshot", "error", err)
+					}
+				case <-deviceStateSnapshotStop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// stopDeviceStateSnapshotTimer stops the periodic flush and writes a final snapshot.
+func stopDeviceStateSnapshotTimer() {
+	if deviceStateSnapshotStop != nil {
+		select {
+		case <-deviceStateSnapshotStop:
+		default:
+			close(deviceStateSnapshotStop)
+		}
+		<-deviceStateSnapshotDone
+	}
+	if err := flushDeviceStateSnapshot(); err != nil {
+		slog.Warn("Failed to save device state snapshot", "error", err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupDeviceStateSnapshotTest(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state", "devices.json")
+
+	prevConfig := *getServerConfig()
+	mu.Lock()
+	tableBackup := deviceTable
+	lastSeenBackup := deviceLastSeen
+	deviceTable = make(map[string]interface{})
+	deviceLastSeen = make(map[string]int64)
+	mu.Unlock()
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceStateFile = path })
+
+	t.Cleanup(func() {
+		setServerConfig(prevConfig)
+		mu.Lock()
+		deviceTable = tableBackup
+		deviceLastSeen = lastSeenBackup
+		mu.Unlock()
+	})
+	return path
+}
+
+func TestDeviceStateSnapshot_RoundTripMarksStale(t *testing.T) {
+	path := setupDeviceStateSnapshotTest(t)
+	seenAt := time.Unix(1700000000, 0)
+
+	mu.Lock()
+	deviceTable["d1"] = map[string]interface{}{"system": map[string]interface{}{"udid": "d1", "name": "iPhone"}}
+	touchDeviceStateLocked("d1", seenAt)
+	mu.Unlock()
+
+	if err := flushDeviceStateSnapshot(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("snapshot file should exist: %v", err)
+	}
+
+	// Unchanged table must not be rewritten.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := flushDeviceStateSnapshot(); err != nil {
+		t.Fatalf("second flush failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unchanged table should not be written again, stat err=%v", err)
+	}
+
+	mu.Lock()
+	touchDeviceStateLocked("d1", seenAt)
+	mu.Unlock()
+	if err := flushDeviceStateSnapshot(); err != nil {
+		t.Fatalf("third flush failed: %v", err)
+	}
+
+	mu.Lock()
+	deviceTable = make(map[string]interface{})
+	deviceLastSeen = make(map[string]int64)
+	mu.Unlock()
+
+	restored, err := loadDeviceStateSnapshot()
+	if err != nil || restored != 1 {
+		t.Fatalf("expected 1 restored device, got %d err=%v", restored, err)
+	}
+	mu.RLock()
+	state, _ := deviceTable["d1"].(map[string]interface{})
+	mu.RUnlock()
+	if state == nil || state["stale"] != true {
+		t.Fatalf("restored device should be marked stale, got %#v", deviceTable["d1"])
+	}
+	if state["lastSeen"] != seenAt.Unix() {
+		t.Fatalf("expected lastSeen %d, got %v", seenAt.Unix(), state["lastSeen"])
+	}
+}
+
+func TestDeviceStateSnapshot_DisabledWithoutFile(t *testing.T) {
+	setupDeviceStateSnapshotTest(t)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceStateFile = "" })
+
+	if err := flushDeviceStateSnapshot(); err != nil {
+		t.Fatalf("flush should be a no-op, got %v", err)
+	}
+	if restored, err := loadDeviceStateSnapshot(); err != nil || restored != 0 {
+		t.Fatalf("load should be a no-op, got %d err=%v", restored, err)
+	}
+}
+
+func TestAcceptDeviceStateSeq_IgnoresOutOfOrderUpdates(t *testing.T) {
+	mu.Lock()
+	defer mu.Unlock()
+	seqBackup := deviceStateSeq
+	deviceStateSeq = make(map[string]float64)
+	defer func() { deviceStateSeq = seqBackup }()
+
+	if !acceptDeviceStateSeqLocked("d1", map[string]interface{}{"stateSeq": float64(5)}) {
+		t.Fatalf("first seq should be accepted")
+	}
+	if acceptDeviceStateSeqLocked("d1", map[string]interface{}{"stateSeq": float64(4)}) {
+		t.Fatalf("older seq should be ignored")
+	}
+	if acceptDeviceStateSeqLocked("d1", map[string]interface{}{"stateSeq": float64(5)}) {
+		t.Fatalf("repeated seq should be ignored")
+	}
+	if !acceptDeviceStateSeqLocked("d1", map[string]interface{}{}) {
+		t.Fatalf("update without stateSeq should be accepted")
+	}
+	if !acceptDeviceStateSeqLocked("d1", map[string]interface{}{"stateSeq": float64(6)}) {
+		t.Fatalf("newer seq should be accepted")
+	}
+	if !acceptDeviceStateSeqLocked("d2", map[string]interface{}{"stateSeq": float64(1)}) {
+		t.Fatalf("seq is tracked per device")
+	}
+
+	forgetDeviceStateLocked("d1")
+	if !acceptDeviceStateSeqLocked("d1", map[string]interface{}{"stateSeq": float64(1)}) {
+		t.Fatalf("seq should restart after the device leaves the table")
+	}
+}
+
+func TestAppStateFromNewConnectionResetsStateSeq(t *testing.T) {
+	mu.Lock()
+	linksBackup, linksMapBackup, controllersBackup := deviceLinks, deviceLinksMap, controllers
+	tableBackup, lifeBackup, seqBackup := deviceTable, deviceLife, deviceStateSeq
+	deviceLinks = make(map[string]*SafeConn)
+	deviceLinksMap = make(map[*SafeConn]string)
+	controllers = make(map[*SafeConn]bool)
+	deviceTable = make(map[string]interface{})
+	deviceLife = make(map[string]int)
+	deviceStateSeq = make(map[string]float64)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		for conn := range deviceLinksMap {
+			stopDeviceWriteQueue(conn)
+		}
+		deviceLinks, deviceLinksMap, controllers = linksBackup, linksMapBackup, controllersBackup
+		deviceTable, deviceLife, deviceStateSeq = tableBackup, lifeBackup, seqBackup
+		mu.Unlock()
+	})
+	appState := func(seq float64) Message {
+		return Message{Type: "app/state", Body: map[string]interface{}{
+			"system":   map[string]interface{}{"udid": "d1"},
+			"stateSeq": seq,
+		}}
+	}
+
+	first, _ := newTestWebSocketPair(t)
+	if err := handleMessage(first, appState(10)); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+
+	// The device reconnects with a restarted counter before the old socket is cleaned up.
+	second, _ := newTestWebSocketPair(t)
+	if err := handleMessage(second, appState(1)); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	mu.RLock()
+	registered := deviceLinks["d1"]
+	mu.RUnlock()
+	if registered != second {
+		t.Fatalf("expected the new connection to be registered despite a lower stateSeq")
+	}
+
+	if err := handleMessage(second, appState(0)); err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+	mu.RLock()
+	seq := deviceStateSeq["d1"]
+	mu.RUnlock()
+	if seq != 1 {
+		t.Fatalf("out-of-order update on the same connection should still be ignored, got seq %v", seq)
+	}
+}
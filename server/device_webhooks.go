@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const deviceWebhookAttempts = 3
+
+var (
+	deviceWebhookBackoff = 2 * time.Second
+	deviceWebhookClient  = &http.Client{Timeout: 5 * time.Second}
+)
+
+// deviceWebhookEvent is the JSON body POSTed to each configured webhook. System is the
+// device's last-known app/state system info, if any.
+type deviceWebhookEvent struct {
+	Event  string                 `json:"event"` // "device/connect", "device/disconnect" or "device/life-exhausted"
+	UDID   string                 `json:"udid"`
+	TS     int64                  `json:"ts"`
+	System map[string]interface{} `json:"system,omitempty"`
+}
+
+func validWebhookURLs(urls []string) bool {
+	for _, raw := range urls {
+		if !isDeviceExportURL(raw) {
+			return false
+		}
+	}
+	return true
+}
+
+// deviceSystemInfoLocked returns the system info of udid's last app/state. Caller must
+// hold mu.RLock.
+func deviceSystemInfoLocked(udid string) map[string]interface{} {
+	stateMap, ok := deviceTable[udid].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	system, _ := stateMap["system"].(map[string]interface{})
+	return system
+}
+
+// notifyDeviceWebhooks POSTs event to every configured webhook in the background.
+func notifyDeviceWebhooks(event, udid string, system map[string]interface{}) {
+	urls := getServerConfig().Webhooks
+	if len(urls) == 0 {
+		return
+	}
+	payload, err := json.Marshal(deviceWebhookEvent{
+		Event:  event,
+		UDID:   udid,
+		TS:     time.Now().Unix(),
+		System: system,
+	})
+	if err != nil {
+		slog.Error("Failed to marshal webhook event", "event", event, "udid", udid, "error", err)
+		return
+	}
+	signature := computeSignatureHex(string(payload))
+	for _, target := range urls {
+		go deliverDeviceWebhook(target, payload, signature)
+	}
+}
+
+// deliverDeviceWebhook tries a webhook up to deviceWebhookAttempts times, backing off
+// between attempts. Any 2xx response counts as delivered.
+func deliverDeviceWebhook(target string, payload []byte, signature string) {
+	var lastErr error
+	for attempt := 1; attempt <= deviceWebhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * deviceWebhookBackoff)
+		}
+		if lastErr = postDeviceWebhook(target, payload, signature); lastErr == nil {
+			return
+		}
+	}
+	slog.Warn("Device webhook failed", "url", target, "attempts", deviceWebhookAttempts, "error", lastErr)
+}
+
+func postDeviceWebhook(target string, payload []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-XXTCC-Signature", "sha256="+signature)
+	resp, err := deviceWebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifyDeviceWebhooks_RetriesAndSignsPayload(t *testing.T) {
+	prevConfig := *getServerConfig()
+	prevBackoff := deviceWebhookBackoff
+	passhashMu.RLock()
+	prevPasshash := string(passhash)
+	passhashMu.RUnlock()
+	t.Cleanup(func() {
+		setServerConfig(prevConfig)
+		deviceWebhookBackoff = prevBackoff
+		setPasshash(prevPasshash)
+	})
+	setPasshash("webhook-test-hash")
+	deviceWebhookBackoff = 10 * time.Millisecond
+
+	type delivery struct {
+		body      []byte
+		signature string
+	}
+	deliveries := make(chan delivery, 4)
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		deliveries <- delivery{body: body, signature: r.Header.Get("X-XXTCC-Signature")}
+	}))
+	t.Cleanup(server.Close)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.Webhooks = []string{server.URL} })
+
+	notifyDeviceWebhooks("device/disconnect", "d1", map[string]interface{}{"name": "iPhone"})
+
+	var got delivery
+	select {
+	case got = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not retried after a failed attempt")
+	}
+
+	mac := hmac.New(sha256.New, []byte("webhook-test-hash"))
+	mac.Write(got.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.signature != want {
+		t.Fatalf("expected signature %s, got %s", want, got.signature)
+	}
+	var event deviceWebhookEvent
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatalf("decode payload failed: %v", err)
+	}
+	if event.Event != "device/disconnect" || event.UDID != "d1" || event.TS == 0 || event.System["name"] != "iPhone" {
+		t.Fatalf("unexpected payload: %s", got.body)
+	}
+}
+
+func TestValidateServerConfig_RejectsNonHTTPWebhooks(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Webhooks = []string{"https://hooks.example.com/devices"}
+	if err := validateServerConfig(cfg); err != nil {
+		t.Fatalf("expected https webhook to be accepted, got %v", err)
+	}
+	cfg.Webhooks = []string{"ftp://hooks.example.com/devices"}
+	if err := validateServerConfig(cfg); err == nil {
+		t.Fatal("expected ftp webhook to be rejected")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writePriority selects the lane of a device's write queue.
+type writePriority int
+
+const (
+	writePriorityNormal writePriority = iota
+	writePriorityHigh
+)
+
+const (
+	deviceWriteQueueHighSize     = 64
+	deviceWriteQueueDefaultDepth = 512
+)
+
+// deviceWriteStallTimeout is how long a bulk send waits for room while the writer makes
+// no progress before the device is treated as stuck and disconnected.
+var deviceWriteStallTimeout = 15 * time.Second
+
+// deviceWriteWaitRecheck bounds how long a waiting bulk send sleeps between checks.
+const deviceWriteWaitRecheck = 100 * time.Millisecond
+
+// highPriorityCommandTypes jump ahead of bulk traffic (file pushes, binary chunks)
+// queued for the same device.
+var highPriorityCommandTypes = map[string]bool{
+	"script/stop":     true,
+	"device/lock":     true,
+	"device/unlock":   true,
+	"device/home":     true,
+	"device/reboot":   true,
+	"device/respring": true,
+	"app/close":       true,
+}
+
+func commandWritePriority(cmdType string) writePriority {
+	if highPriorityCommandTypes[cmdType] {
+		return writePriorityHigh
+	}
+	return writePriorityNormal
+}
+
+type queuedWrite struct {
+	messageType int
+	payload     []byte
+}
+
+// deviceWriteQueue serializes async writes to one device through a single writer
+// goroutine that always drains the high-priority lane first.
+type deviceWriteQueue struct {
+	high         chan queuedWrite
+	normal       chan queuedWrite
+	done         chan struct{}
+	closeOnce    sync.Once
+	overflowOnce sync.Once
+
+	// wrote is signalled after each write; lastWrite is its UnixNano time.
+	wrote     chan struct{}
+	lastWrite atomic.Int64
+}
+
+// deviceWriteQueueDepth is the normal lane size for new device connections.
+func deviceWriteQueueDepth() int {
+	if getServerConfig().DeviceWriteQueueDepth > 0 {
+		return getServerConfig().DeviceWriteQueueDepth
+	}
+	return deviceWriteQueueDefaultDepth
+}
+
+func newDeviceWriteQueue() *deviceWriteQueue {
+	q := &deviceWriteQueue{
+		high:   make(chan queuedWrite, deviceWriteQueueHighSize),
+		normal: make(chan queuedWrite, deviceWriteQueueDepth()),
+		done:   make(chan struct{}),
+		wrote:  make(chan struct{}, 1),
+	}
+	q.lastWrite.Store(time.Now().UnixNano())
+	return q
+}
+
+// enqueue never blocks, so fan-out loops are not held up by one slow device. It reports
+// false when the lane is full; writes to a stopped queue are dropped.
+func (q *deviceWriteQueue) enqueue(w queuedWrite, priority writePriority) bool {
+	lane := q.normal
+	if priority == writePriorityHigh {
+		lane = q.high
+	}
+	select {
+	case <-q.done:
+		return true
+	default:
+	}
+	select {
+	case lane <- w:
+		return true
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueueWait is enqueue for bulk sends (file pushes, fetch commands, binary chunks):
+// it waits while the normal lane is more than three quarters full, leaving the rest for
+// non-blocking fan-out writes. It reports false only once the writer has made no
+// progress for deviceWriteStallTimeout. Callers must not hold mu.
+func (q *deviceWriteQueue) enqueueWait(w queuedWrite) bool {
+	limit := cap(q.normal) - cap(q.normal)/4
+	waitStart := time.Now().UnixNano()
+	recheck := time.NewTimer(deviceWriteWaitRecheck)
+	defer recheck.Stop()
+	for {
+		if len(q.normal) < limit {
+			select {
+			case q.normal <- w:
+				return true
+			case <-q.done:
+				return true
+			default:
+			}
+		}
+		// An idle writer's last write predates the wait, so count from whichever is later.
+		progress := q.lastWrite.Load()
+		if progress < waitStart {
+			progress = waitStart
+		}
+		if time.Since(time.Unix(0, progress)) > deviceWriteStallTimeout {
+			return false
+		}
+		select {
+		case <-q.wrote:
+		case <-q.done:
+			return true
+		case <-recheck.C:
+			recheck.Reset(deviceWriteWaitRecheck)
+		}
+	}
+}
+
+func (q *deviceWriteQueue) run(conn *SafeConn) {
+	for {
+		select {
+		case w := <-q.high:
+			q.write(conn, w)
+			continue
+		default:
+		}
+
+		select {
+		case w := <-q.high:
+			q.write(conn, w)
+		case w := <-q.normal:
+			q.write(conn, w)
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *deviceWriteQueue) write(conn *SafeConn, w queuedWrite) {
+	if w.messageType == websocket.BinaryMessage {
+		_ = sendBinaryMessage(conn, w.payload)
+	} else {
+		_ = writeTextMessage(conn, w.payload)
+	}
+	q.lastWrite.Store(time.Now().UnixNano())
+	select {
+	case q.wrote <- struct{}{}:
+	default:
+	}
+}
+
+func (q *deviceWriteQueue) stop() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
+
+// overflow stops a backed-up queue and disconnects the device: it is not reading fast
+// enough, and everything still queued for it is dropped.
+func (q *deviceWriteQueue) overflow(conn *SafeConn) {
+	q.overflowOnce.Do(func() {
+		q.stop()
+		// Callers may hold mu, so look up the device and notify controllers asynchronously.
+		go func() {
+			udid, _ := getDeviceUDIDByConn(conn)
+			slog.Warn("Device write queue overflowed, closing connection", "udid", udid, "remote_addr", conn.RemoteAddr())
+			if udid != "" {
+				broadcastDeviceMessage(udid, "设备发送队列积压，已断开连接")
+			}
+			conn.Close()
+		}()
+	})
+}
+
+// startDeviceWriteQueue attaches a write queue to a device connection (no-op if present).
+func startDeviceWriteQueue(conn *SafeConn) {
+	if conn == nil || conn.writeQueue.Load() != nil {
+		return
+	}
+	q := newDeviceWriteQueue()
+	if conn.writeQueue.CompareAndSwap(nil, q) {
+		go q.run(conn)
+	}
+}
+
+// stopDeviceWriteQueue stops conn's writer goroutine, dropping writes still queued.
+func stopDeviceWriteQueue(conn *SafeConn) {
+	if conn == nil {
+		return
+	}
+	if q := conn.writeQueue.Load(); q != nil {
+		q.stop()
+	}
+}
+
+// enqueueDeviceWrite queues a write if conn has a device write queue. A full queue
+// disconnects the device instead of blocking the caller.
+func enqueueDeviceWrite(conn *SafeConn, messageType int, payload []byte, priority writePriority) bool {
+	if conn == nil {
+		return false
+	}
+	q := conn.writeQueue.Load()
+	if q == nil {
+		return false
+	}
+	if !q.enqueue(queuedWrite{messageType: messageType, payload: payload}, priority) {
+		q.overflow(conn)
+	}
+	return true
+}
+
+// enqueueDeviceWriteWait is enqueueDeviceWrite for bulk sends: it blocks for room in
+// the normal lane and disconnects the device only when its writer has stalled.
+func enqueueDeviceWriteWait(conn *SafeConn, messageType int, payload []byte) bool {
+	if conn == nil {
+		return false
+	}
+	q := conn.writeQueue.Load()
+	if q == nil {
+		return false
+	}
+	if !q.enqueueWait(queuedWrite{messageType: messageType, payload: payload}) {
+		q.overflow(conn)
+	}
+	return true
+}
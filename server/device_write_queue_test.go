@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDeviceWriteQueueDrainsHighPriorityFirst(t *testing.T) {
+	conn, client := newTestWebSocketPair(t)
+
+	q := newDeviceWriteQueue()
+	t.Cleanup(q.stop)
+	q.enqueue(queuedWrite{messageType: websocket.TextMessage, payload: []byte(`{"type":"file/put"}`)}, writePriorityNormal)
+	q.enqueue(queuedWrite{messageType: websocket.TextMessage, payload: []byte(`{"type":"transfer/fetch"}`)}, writePriorityNormal)
+	q.enqueue(queuedWrite{messageType: websocket.TextMessage, payload: []byte(`{"type":"device/lock"}`)}, commandWritePriority("device/lock"))
+	go q.run(conn)
+
+	for _, want := range []string{"device/lock", "file/put", "transfer/fetch"} {
+		if msg := readTestMessage(t, client); msg.Type != want {
+			t.Fatalf("expected %s, got %s", want, msg.Type)
+		}
+	}
+}
+
+func TestEnqueueDeviceWriteRequiresStartedQueue(t *testing.T) {
+	conn, client := newTestWebSocketPair(t)
+	if enqueueDeviceWrite(conn, websocket.TextMessage, []byte(`{}`), writePriorityNormal) {
+		t.Fatalf("connection without a queue should not enqueue")
+	}
+
+	startDeviceWriteQueue(conn)
+	writeTextMessageAsyncWithPriority(conn, []byte(`{"type":"script/stop"}`), commandWritePriority("script/stop"))
+	if msg := readTestMessage(t, client); msg.Type != "script/stop" {
+		t.Fatalf("expected queued write to be delivered, got %s", msg.Type)
+	}
+
+	stopDeviceWriteQueue(conn)
+	stopDeviceWriteQueue(conn)
+	if !enqueueDeviceWrite(conn, websocket.TextMessage, []byte(`{}`), writePriorityHigh) {
+		t.Fatalf("stopped queue should still swallow writes")
+	}
+}
+
+func TestEnqueueDeviceWriteOverflowClosesDevice(t *testing.T) {
+	deviceConn := setupCommandAckFixture(t)
+	controllerConn, controllerClient := newTestWebSocketPair(t)
+	mu.Lock()
+	controllers[controllerConn] = true
+	mu.Unlock()
+
+	prevDepth := getServerConfig().DeviceWriteQueueDepth
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceWriteQueueDepth = 1 })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceWriteQueueDepth = prevDepth }) })
+
+	// A queue with no writer running backs up like a device that stopped reading.
+	q := newDeviceWriteQueue()
+	deviceConn.writeQueue.Store(q)
+	t.Cleanup(q.stop)
+
+	writeTextMessageAsync(deviceConn, []byte(`{"type":"file/put"}`))
+	select {
+	case <-q.done:
+		t.Fatalf("queue within its depth should stay open")
+	default:
+	}
+	writeTextMessageAsync(deviceConn, []byte(`{"type":"file/put"}`))
+
+	msg := readTestMessage(t, controllerClient)
+	body, _ := msg.Body.(map[string]interface{})
+	if msg.Type != "device/message" || body["udid"] != "d1" {
+		t.Fatalf("expected overflow notice for d1, got %#v", msg)
+	}
+	select {
+	case <-q.done:
+	default:
+		t.Fatalf("overflowed queue should be stopped")
+	}
+}
+
+func TestSendSmallFilesBeyondQueueDepthKeepsDeviceConnected(t *testing.T) {
+	root := t.TempDir()
+	const fileCount = 20
+	for i := 0; i < fileCount; i++ {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("f%02d.lua", i)), []byte("print(1)"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _, err := collectScriptPackage(root, "demo", true, false, false)
+	if err != nil || len(files) != fileCount {
+		t.Fatalf("expected %d files, got %d (%v)", fileCount, len(files), err)
+	}
+
+	prevDepth := getServerConfig().DeviceWriteQueueDepth
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceWriteQueueDepth = 4 })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceWriteQueueDepth = prevDepth }) })
+
+	conn, client := newTestWebSocketPair(t)
+	q := newDeviceWriteQueue()
+	conn.writeQueue.Store(q)
+	t.Cleanup(q.stop)
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		newScriptFileSender(files, nil).sendSmallFilesToConn(conn, "d1", false)
+	}()
+	// Let the push back up against the full lane before the writer starts draining it.
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-q.done:
+		t.Fatalf("a full queue with a live writer must not disconnect the device")
+	default:
+	}
+	go q.run(conn)
+
+	for i := 0; i < fileCount; i++ {
+		if msg := readTestMessage(t, client); msg.Type != "file/put" {
+			t.Fatalf("expected file/put, got %s", msg.Type)
+		}
+	}
+	<-sent
+	select {
+	case <-q.done:
+		t.Fatalf("device should stay connected after the push")
+	default:
+	}
+}
+
+func TestWriteTextMessageBulkDisconnectsStalledDevice(t *testing.T) {
+	deviceConn := setupCommandAckFixture(t)
+	prevDepth, prevStall := getServerConfig().DeviceWriteQueueDepth, deviceWriteStallTimeout
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceWriteQueueDepth = 1 })
+	deviceWriteStallTimeout = 50 * time.Millisecond
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceWriteQueueDepth = prevDepth })
+		deviceWriteStallTimeout = prevStall
+	})
+
+	// No writer runs, like a device that stopped reading.
+	q := newDeviceWriteQueue()
+	deviceConn.writeQueue.Store(q)
+	t.Cleanup(q.stop)
+
+	writeTextMessageBulk(deviceConn, []byte(`{"type":"file/put"}`))
+	writeTextMessageBulk(deviceConn, []byte(`{"type":"file/put"}`))
+	select {
+	case <-q.done:
+	default:
+		t.Fatalf("stalled queue should be stopped")
+	}
+}
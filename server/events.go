@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventRingSize         = 1000
+	defaultEventPollWait  = 25 * time.Second
+	maxEventPollWait      = 60 * time.Second
+	defaultEventPollLimit = 100
+)
+
+// serverEvent is one entry of the event log served by /api/events/poll.
+type serverEvent struct {
+	Seq  uint64      `json:"seq"`
+	TS   int64       `json:"ts"` // Unix milliseconds
+	Type string      `json:"type"`
+	UDID string      `json:"udid,omitempty"`
+	Body interface{} `json:"body,omitempty"`
+}
+
+// eventLog is a bounded ring of recent events. notify is closed (and replaced)
+// whenever an event is recorded to wake long-poll waiters.
+var eventLog = struct {
+	sync.Mutex
+	ring    [eventRingSize]serverEvent
+	lastSeq uint64
+	notify  chan struct{}
+}{
+	notify: make(chan struct{}),
+}
+
+// recordServerEvent appends an event to the ring buffer.
+func recordServerEvent(eventType, udid string, body interface{}) {
+	eventLog.Lock()
+	eventLog.lastSeq++
+	eventLog.ring[eventLog.lastSeq%eventRingSize] = serverEvent{
+		Seq:  eventLog.lastSeq,
+		TS:   time.Now().UnixMilli(),
+		Type: eventType,
+		UDID: udid,
+		Body: body,
+	}
+	close(eventLog.notify)
+	eventLog.notify = make(chan struct{})
+	eventLog.Unlock()
+}
+
+// eventsSince returns up to limit events after cursor, the cursor to use next and whether
+// events between cursor and the oldest buffered event were already overwritten.
+// The channel is closed when a newer event is recorded.
+func eventsSince(cursor uint64, limit int) ([]serverEvent, uint64, bool, <-chan struct{}) {
+	eventLog.Lock()
+	defer eventLog.Unlock()
+
+	last := eventLog.lastSeq
+	if cursor > last {
+		// Cursor from before a restart; start over from the buffered events.
+		cursor = 0
+	}
+	oldest := uint64(1)
+	if last > eventRingSize {
+		oldest = last - eventRingSize + 1
+	}
+	truncated := false
+	if cursor+1 < oldest {
+		truncated = cursor > 0
+		cursor = oldest - 1
+	}
+
+	var events []serverEvent
+	for seq := cursor + 1; seq <= last && len(events) < limit; seq++ {
+		events = append(events, eventLog.ring[seq%eventRingSize])
+	}
+	next := cursor
+	if len(events) > 0 {
+		next = events[len(events)-1].Seq
+	}
+	return events, next, truncated, eventLog.notify
+}
+
+// eventsPollHandler handles GET /api/events/poll?since=<cursor>&wait=<seconds>&limit=<n>
+func eventsPollHandler(c *gin.Context) {
+	var since uint64
+	if raw := c.Query("since"); raw != "" {
+		value, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+		since = value
+	}
+
+	wait := defaultEventPollWait
+	if raw := c.Query("wait"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait"})
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > maxEventPollWait {
+			wait = maxEventPollWait
+		}
+	}
+
+	limit := defaultEventPollLimit
+	if raw := c.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		if value < eventRingSize {
+			limit = value
+		} else {
+			limit = eventRingSize
+		}
+	}
+
+	events, next, truncated, notify := eventsSince(since, limit)
+	if len(events) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-notify:
+			events, next, truncated, _ = eventsSince(since, limit)
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+	if events == nil {
+		events = []serverEvent{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":    events,
+		"next":      next,
+		"truncated": truncated,
+	})
+}
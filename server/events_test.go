@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func resetEventLogForTest(t *testing.T) {
+	t.Helper()
+	eventLog.Lock()
+	eventLog.ring = [eventRingSize]serverEvent{}
+	eventLog.lastSeq = 0
+	eventLog.Unlock()
+}
+
+func TestEventsSince_CursorAndTruncation(t *testing.T) {
+	resetEventLogForTest(t)
+
+	recordServerEvent("device/connect", "d1", nil)
+	recordServerEvent("device/disconnect", "d1", nil)
+
+	events, next, truncated, _ := eventsSince(1, 10)
+	if len(events) != 1 || events[0].Type != "device/disconnect" || next != 2 || truncated {
+		t.Fatalf("unexpected events after cursor 1: %+v next=%d truncated=%v", events, next, truncated)
+	}
+	if events, next, _, _ := eventsSince(2, 10); len(events) != 0 || next != 2 {
+		t.Fatalf("expected no new events, got %+v next=%d", events, next)
+	}
+
+	for i := 0; i < eventRingSize; i++ {
+		recordServerEvent("control/command", "", nil)
+	}
+	events, next, truncated, _ = eventsSince(1, 5)
+	if !truncated || len(events) != 5 || events[0].Seq != 3 || next != 7 {
+		t.Fatalf("expected truncated read from oldest buffered event, got first=%+v next=%d truncated=%v", events[0], next, truncated)
+	}
+	if _, _, truncated, _ := eventsSince(0, 5); truncated {
+		t.Fatalf("a fresh cursor should not report truncation")
+	}
+}
+
+func TestEventsPollHandler_LongPollWakesOnEvent(t *testing.T) {
+	resetEventLogForTest(t)
+	recordServerEvent("device/connect", "d1", nil)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		recordServerEvent("transfer/push", "d1", map[string]interface{}{"path": "a.lua"})
+	}()
+
+	start := time.Now()
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/events/poll?since=1&wait=5", nil, eventsPollHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("long-poll did not wake on new event (took %v)", elapsed)
+	}
+
+	var resp struct {
+		Events []serverEvent `json:"events"`
+		Next   uint64        `json:"next"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.Events) != 1 || resp.Events[0].Type != "transfer/push" || resp.Next != 2 {
+		t.Fatalf("unexpected poll response: %+v", resp)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/events/poll?since=2&wait=0", nil, eventsPollHandler)
+	if w.Code != http.StatusOK || w.Body.String() != `{"events":[],"next":2,"truncated":false}` {
+		t.Fatalf("unexpected empty poll response: %d %s", w.Code, w.Body.String())
+	}
+}
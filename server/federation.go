@@ -0,0 +1,411 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Devices of an upstream server are exposed locally as "<region>/<udid>".
+const federatedDeviceSeparator = "/"
+
+const (
+	upstreamReconnectMin  = 2 * time.Second
+	upstreamReconnectMax  = 60 * time.Second
+	upstreamWriteTimeout  = 10 * time.Second
+	upstreamHandshakeWait = 10 * time.Second
+)
+
+var errUpstreamOffline = errors.New("upstream not connected")
+
+// upstreamLink is this server's outbound controller connection to one upstream server.
+type upstreamLink struct {
+	cfg UpstreamConfig
+
+	writeMu sync.Mutex
+	conn    *websocket.Conn // guarded by writeMu
+
+	// devices mirrors the upstream device table keyed by upstream UDID. Guarded by federationMu.
+	devices map[string]interface{}
+}
+
+var (
+	federationMu   sync.RWMutex
+	upstreamLinks  = make(map[string]*upstreamLink) // region -> link
+	federationStop chan struct{}
+	federationWG   sync.WaitGroup
+)
+
+// validateUpstreams checks that every upstream has a unique region, a ws(s) URL and a passhash.
+func validateUpstreams(upstreams []UpstreamConfig) error {
+	seen := make(map[string]bool, len(upstreams))
+	for _, upstream := range upstreams {
+		switch {
+		case upstream.Region == "" || strings.Contains(upstream.Region, federatedDeviceSeparator):
+			return fmt.Errorf("upstream region must be non-empty and cannot contain %q", federatedDeviceSeparator)
+		case seen[upstream.Region]:
+			return fmt.Errorf("duplicate upstream region %q", upstream.Region)
+		case !strings.HasPrefix(upstream.URL, "ws://") && !strings.HasPrefix(upstream.URL, "wss://"):
+			return fmt.Errorf("upstream %q url must start with ws:// or wss://", upstream.Region)
+		case len(upstream.Passhash) != PasshashLength:
+			return fmt.Errorf("upstream %q passhash must be %d hex characters", upstream.Region, PasshashLength)
+		}
+		seen[upstream.Region] = true
+	}
+	return nil
+}
+
+func federatedDeviceID(region, udid string) string {
+	return region + federatedDeviceSeparator + udid
+}
+
+// splitFederatedDevices separates local device IDs from "<region>/<udid>" IDs of
+// configured upstreams, grouping the latter by region with the prefix removed.
+func splitFederatedDevices(devices []string) ([]string, map[string][]string) {
+	federationMu.RLock()
+	defer federationMu.RUnlock()
+	if len(upstreamLinks) == 0 {
+		return devices, nil
+	}
+
+	local := make([]string, 0, len(devices))
+	var remote map[string][]string
+	for _, id := range devices {
+		region, udid, ok := strings.Cut(id, federatedDeviceSeparator)
+		if ok && udid != "" {
+			if _, known := upstreamLinks[region]; known {
+				if remote == nil {
+					remote = make(map[string][]string)
+				}
+				remote[region] = append(remote[region], udid)
+				continue
+			}
+		}
+		local = append(local, id)
+	}
+	return local, remote
+}
+
+// federatedDeviceState copies an upstream device state, rewriting system.udid to the
+// federated ID and tagging it with its region.
+func federatedDeviceState(region, udid string, state interface{}) interface{} {
+	stateMap, ok := state.(map[string]interface{})
+	if !ok {
+		return state
+	}
+	out := make(map[string]interface{}, len(stateMap)+1)
+	for key, value := range stateMap {
+		out[key] = value
+	}
+	if systemMap, ok := stateMap["system"].(map[string]interface{}); ok {
+		system := make(map[string]interface{}, len(systemMap))
+		for key, value := range systemMap {
+			system[key] = value
+		}
+		system["udid"] = federatedDeviceID(region, udid)
+		out["system"] = system
+	}
+	out["region"] = region
+	return out
+}
+
+// appendFederatedDevices adds every upstream device to snapshot under its federated ID.
+func appendFederatedDevices(snapshot map[string]interface{}) {
+	federationMu.RLock()
+	defer federationMu.RUnlock()
+	for region, link := range upstreamLinks {
+		for udid, state := range link.devices {
+			snapshot[federatedDeviceID(region, udid)] = federatedDeviceState(region, udid, state)
+		}
+	}
+}
+
+func getUpstreamLink(region string) *upstreamLink {
+	federationMu.RLock()
+	defer federationMu.RUnlock()
+	return upstreamLinks[region]
+}
+
+// signUpstreamMessage signs msg with the upstream's passhash, like a browser controller would.
+func signUpstreamMessage(passhash string, msg *Message) {
+	msg.TS = time.Now().Unix()
+	msg.Nonce = uuid.New().String()
+	h := hmac.New(sha256.New, []byte(passhash))
+	h.Write([]byte(buildMessageSignatureString(msg.TS, msg.Nonce, msg.Type, hashJSONHex(msg.Body))))
+	msg.Sign = hex.EncodeToString(h.Sum(nil))
+}
+
+// send writes a signed control message to the upstream. Bodies must be JSON objects
+// decoded as map[string]interface{} so the upstream computes the same body hash.
+func (l *upstreamLink) send(msgType string, body interface{}) error {
+	msg := Message{Type: msgType, Body: body}
+	signUpstreamMessage(l.cfg.Passhash, &msg)
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	if l.conn == nil {
+		return errUpstreamOffline
+	}
+	_ = l.conn.SetWriteDeadline(time.Now().Add(upstreamWriteTimeout))
+	return l.conn.WriteMessage(websocket.TextMessage, payload)
+}
+
+// relayControlCommandUpstream forwards a control/command to the upstream owning devices.
+func relayControlCommandUpstream(region string, devices []string, cmd ControlCommand) error {
+	link := getUpstreamLink(region)
+	if link == nil {
+		return errUpstreamOffline
+	}
+	body := map[string]interface{}{
+		"devices": stringsToInterfaces(devices),
+		"type":    cmd.Type,
+	}
+	if cmd.Body != nil {
+		body["body"] = cmd.Body
+	}
+	if cmd.RequestID != "" {
+		body["requestId"] = cmd.RequestID
+	}
+	if cmd.Timeout > 0 {
+		body["timeout"] = float64(cmd.Timeout)
+	}
+	return link.send("control/command", body)
+}
+
+// relayControlCommandsUpstream forwards a control/commands batch to the upstream owning devices.
+func relayControlCommandsUpstream(region string, devices []string, commands []Command) error {
+	link := getUpstreamLink(region)
+	if link == nil {
+		return errUpstreamOffline
+	}
+	list := make([]interface{}, 0, len(commands))
+	for _, cmd := range commands {
+		item := map[string]interface{}{"type": cmd.Type}
+		if cmd.Body != nil {
+			item["body"] = cmd.Body
+		}
+		list = append(list, item)
+	}
+	return link.send("control/commands", map[string]interface{}{
+		"devices":  stringsToInterfaces(devices),
+		"commands": list,
+	})
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, value := range values {
+		out[i] = value
+	}
+	return out
+}
+
+// broadcastFederatedMessage sends an upstream message to local controllers.
+func broadcastFederatedMessage(msg Message) {
+	controllerList := snapshotControllerConns()
+	if len(controllerList) == 0 {
+		return
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Failed to marshal federated message", "type", msg.Type, "error", err)
+		return
+	}
+	for _, conn := range controllerList {
+		writeTextMessageAsync(conn, payload)
+	}
+}
+
+// handleUpstreamMessage mirrors upstream device state and relays device traffic to local
+// controllers with device IDs prefixed by the region.
+func handleUpstreamMessage(l *upstreamLink, msg Message) {
+	region := l.cfg.Region
+	switch msg.Type {
+	case "control/devices":
+		table, ok := msg.Body.(map[string]interface{})
+		if !ok {
+			return
+		}
+		federationMu.Lock()
+		l.devices = table
+		federationMu.Unlock()
+		for udid, state := range table {
+			broadcastFederatedMessage(Message{
+				Type: "app/state",
+				UDID: federatedDeviceID(region, udid),
+				Body: federatedDeviceState(region, udid, state),
+			})
+		}
+
+	case "app/state":
+		if msg.UDID == "" {
+			return
+		}
+		federationMu.Lock()
+		if l.devices == nil {
+			l.devices = make(map[string]interface{})
+		}
+		l.devices[msg.UDID] = msg.Body
+		federationMu.Unlock()
+		msg.Body = federatedDeviceState(region, msg.UDID, msg.Body)
+		msg.UDID = federatedDeviceID(region, msg.UDID)
+		broadcastFederatedMessage(msg)
+
+	case "device/disconnect":
+		udid, ok := msg.Body.(string)
+		if !ok || udid == "" {
+			return
+		}
+		federationMu.Lock()
+		delete(l.devices, udid)
+		federationMu.Unlock()
+		broadcastFederatedMessage(Message{Type: "device/disconnect", Body: federatedDeviceID(region, udid)})
+
+	case "server/shutdown":
+		// The read loop notices the close and reconnects.
+
+	default:
+		if msg.UDID == "" {
+			return
+		}
+		msg.UDID = federatedDeviceID(region, msg.UDID)
+		msg.TS, msg.Nonce, msg.Sign = 0, "", ""
+		broadcastFederatedMessage(msg)
+	}
+}
+
+// dropUpstreamDevices forgets an upstream's devices after its link drops.
+func dropUpstreamDevices(l *upstreamLink) {
+	federationMu.Lock()
+	devices := l.devices
+	l.devices = nil
+	federationMu.Unlock()
+	for udid := range devices {
+		broadcastFederatedMessage(Message{Type: "device/disconnect", Body: federatedDeviceID(l.cfg.Region, udid)})
+	}
+}
+
+// connectOnce dials the upstream, requests its device table and reads until the link drops.
+func (l *upstreamLink) connectOnce(stop <-chan struct{}) error {
+	dialer := websocket.Dialer{HandshakeTimeout: upstreamHandshakeWait}
+	conn, _, err := dialer.Dial(l.cfg.URL, nil)
+	if err != nil {
+		return err
+	}
+	l.writeMu.Lock()
+	l.conn = conn
+	l.writeMu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			conn.Close()
+		case <-done:
+		}
+	}()
+	defer func() {
+		l.writeMu.Lock()
+		l.conn = nil
+		l.writeMu.Unlock()
+		conn.Close()
+		dropUpstreamDevices(l)
+	}()
+
+	if err := l.send("control/devices", nil); err != nil {
+		return err
+	}
+	slog.Info("Connected to upstream", "region", l.cfg.Region, "url", l.cfg.URL)
+
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			continue
+		}
+		handleUpstreamMessage(l, msg)
+	}
+}
+
+func (l *upstreamLink) run(stop <-chan struct{}) {
+	defer federationWG.Done()
+	backoff := upstreamReconnectMin
+	for {
+		started := time.Now()
+		err := l.connectOnce(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if time.Since(started) > upstreamReconnectMax {
+			backoff = upstreamReconnectMin
+		}
+		slog.Warn("Upstream disconnected", "region", l.cfg.Region, "error", err, "retry_in", backoff)
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > upstreamReconnectMax {
+			backoff = upstreamReconnectMax
+		}
+	}
+}
+
+// startFederation connects to every configured upstream server.
+func startFederation() {
+	if len(getServerConfig().Upstreams) == 0 {
+		return
+	}
+	federationMu.Lock()
+	federationStop = make(chan struct{})
+	links := make([]*upstreamLink, 0, len(getServerConfig().Upstreams))
+	for _, cfg := range getServerConfig().Upstreams {
+		link := &upstreamLink{cfg: cfg}
+		upstreamLinks[cfg.Region] = link
+		links = append(links, link)
+	}
+	federationMu.Unlock()
+
+	for _, link := range links {
+		federationWG.Add(1)
+		go link.run(federationStop)
+	}
+}
+
+// stopFederation closes all upstream links and waits for their goroutines.
+func stopFederation() {
+	federationMu.Lock()
+	stop := federationStop
+	federationStop = nil
+	federationMu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	federationWG.Wait()
+
+	federationMu.Lock()
+	upstreamLinks = make(map[string]*upstreamLink)
+	federationMu.Unlock()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testUpstreamPasshash = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func verifyTestUpstreamSignature(t *testing.T, msg Message) {
+	t.Helper()
+	h := hmac.New(sha256.New, []byte(testUpstreamPasshash))
+	h.Write([]byte(buildMessageSignatureString(msg.TS, msg.Nonce, msg.Type, hashJSONHex(msg.Body))))
+	if msg.Nonce == "" || msg.Sign != hex.EncodeToString(h.Sum(nil)) {
+		t.Errorf("upstream received badly signed %s: %#v", msg.Type, msg)
+	}
+}
+
+func TestSplitFederatedDevices(t *testing.T) {
+	federationMu.Lock()
+	backup := upstreamLinks
+	upstreamLinks = map[string]*upstreamLink{"east": {}}
+	federationMu.Unlock()
+	t.Cleanup(func() {
+		federationMu.Lock()
+		upstreamLinks = backup
+		federationMu.Unlock()
+	})
+
+	local, remote := splitFederatedDevices([]string{"d1", "east/u1", "west/u2", "east/u3"})
+	if !reflect.DeepEqual(local, []string{"d1", "west/u2"}) {
+		t.Fatalf("unexpected local devices: %v", local)
+	}
+	if !reflect.DeepEqual(remote, map[string][]string{"east": {"u1", "u3"}}) {
+		t.Fatalf("unexpected remote devices: %v", remote)
+	}
+}
+
+func TestValidateUpstreams(t *testing.T) {
+	valid := UpstreamConfig{Region: "east", URL: "ws://10.0.0.2:46980/api/ws", Passhash: testUpstreamPasshash}
+	if err := validateUpstreams([]UpstreamConfig{valid}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	invalid := [][]UpstreamConfig{
+		{{Region: "a/b", URL: valid.URL, Passhash: valid.Passhash}},
+		{valid, valid},
+		{{Region: "east", URL: "http://x", Passhash: valid.Passhash}},
+		{{Region: "east", URL: valid.URL, Passhash: "short"}},
+	}
+	for _, upstreams := range invalid {
+		if err := validateUpstreams(upstreams); err == nil {
+			t.Fatalf("expected %+v to be rejected", upstreams)
+		}
+	}
+}
+
+func TestFederation_MirrorsUpstreamDevicesAndRelaysCommands(t *testing.T) {
+	received := make(chan Message, 4)
+	done := make(chan struct{})
+	var upstreamHandlers sync.WaitGroup
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHandlers.Add(1)
+		defer upstreamHandlers.Done()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			verifyTestUpstreamSignature(t, msg)
+			select {
+			case received <- msg:
+			case <-done:
+				return
+			}
+			if msg.Type == "control/devices" {
+				_ = conn.WriteJSON(Message{Type: "control/devices", Body: map[string]interface{}{
+					"u1": map[string]interface{}{"system": map[string]interface{}{"udid": "u1"}},
+				}})
+			}
+		}
+	}))
+	controllerConn, client := newTestWebSocketPair(t)
+	mu.Lock()
+	controllersBackup, tableBackup := controllers, deviceTable
+	controllers = map[*SafeConn]bool{controllerConn: true}
+	deviceTable = map[string]interface{}{}
+	mu.Unlock()
+	prev := *getServerConfig()
+	updateServerConfig(func(cfg *ServerConfig) {
+		cfg.Upstreams = []UpstreamConfig{{Region: "east", URL: "ws" + strings.TrimPrefix(upstream.URL, "http"), Passhash: testUpstreamPasshash}}
+	})
+	t.Cleanup(func() {
+		// Join the links and the upstream handlers before the config goes back.
+		stopFederation()
+		close(done)
+		upstream.Close()
+		upstreamHandlers.Wait()
+		setServerConfig(prev)
+		mu.Lock()
+		controllers, deviceTable = controllersBackup, tableBackup
+		mu.Unlock()
+	})
+
+	startFederation()
+
+	if msg := <-received; msg.Type != "control/devices" {
+		t.Fatalf("expected control/devices first, got %#v", msg)
+	}
+	msg := readTestMessage(t, client)
+	if msg.Type != "app/state" || msg.UDID != "east/u1" {
+		t.Fatalf("unexpected relayed state: %#v", msg)
+	}
+	state, _ := snapshotDeviceTable(false)["east/u1"].(map[string]interface{})
+	system, _ := state["system"].(map[string]interface{})
+	if state["region"] != "east" || system["udid"] != "east/u1" {
+		t.Fatalf("unexpected federated state: %#v", state)
+	}
+
+	if err := relayControlCommandUpstream("east", []string{"u1"}, ControlCommand{Type: "app/open", RequestID: "r1"}); err != nil {
+		t.Fatalf("relay failed: %v", err)
+	}
+	select {
+	case msg := <-received:
+		body, _ := msg.Body.(map[string]interface{})
+		if msg.Type != "control/command" || body["type"] != "app/open" || body["requestId"] != "r1" || !reflect.DeepEqual(body["devices"], []interface{}{"u1"}) {
+			t.Fatalf("unexpected relayed command: %#v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for relayed command")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// embeddedFrontendFiles holds the frontend build copied into embedded_frontend/ by
+// build.sh (or the Dockerfile) before go build. A plain checkout only contains a
+// placeholder, in which case no embedded frontend is available.
+//
+//go:embed all:embedded_frontend
+var embeddedFrontendFiles embed.FS
+
+// embeddedFrontendFS is the embedded frontend rooted at its dist directory; tests swap it.
+var embeddedFrontendFS fs.FS = mustSubFS(embeddedFrontendFiles, "embedded_frontend")
+
+// embeddedFrontendETags caches the content-hash ETag of each embedded file, which never
+// changes for the lifetime of the binary.
+var embeddedFrontendETags sync.Map
+
+func mustSubFS(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// hasEmbeddedFrontend reports whether the binary was built with a frontend embedded.
+func hasEmbeddedFrontend() bool {
+	info, err := fs.Stat(embeddedFrontendFS, "index.html")
+	return err == nil && info.Mode().IsRegular()
+}
+
+// useEmbeddedFrontend reports whether static files should come from the embedded
+// frontend: when preferEmbeddedFrontend is set, or when frontendDir does not exist.
+func useEmbeddedFrontend(frontendDir string) bool {
+	if !hasEmbeddedFrontend() {
+		return false
+	}
+	if getServerConfig().PreferEmbeddedFrontend {
+		return true
+	}
+	_, err := os.Stat(frontendDir)
+	return os.IsNotExist(err)
+}
+
+// serveEmbeddedFrontendFile serves urlPath from the embedded frontend with the same SPA
+// fallback, cache headers and ETag revalidation as files served from FrontendDir.
+func serveEmbeddedFrontendFile(c *gin.Context, urlPath string) {
+	name := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(urlPath, "\\", "/")), "/")
+	data, err := readEmbeddedFrontendFile(name)
+	if errors.Is(err, fs.ErrNotExist) && name != "index.html" {
+		name = "index.html"
+		data, err = readEmbeddedFrontendFile(name)
+	}
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	setContentTypeAndCache(c, name)
+	if strings.ToLower(path.Ext(name)) != ".html" {
+		etag := embeddedFrontendETag(name, data)
+		c.Header("ETag", etag)
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	http.ServeContent(c.Writer, c.Request, name, time.Time{}, bytes.NewReader(data))
+}
+
+// readEmbeddedFrontendFile reads a regular file from the embedded frontend; directories
+// are reported as missing so they fall back to index.html.
+func readEmbeddedFrontendFile(name string) ([]byte, error) {
+	if name == "" {
+		return nil, fs.ErrNotExist
+	}
+	info, err := fs.Stat(embeddedFrontendFS, name)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fs.ErrNotExist
+	}
+	return fs.ReadFile(embeddedFrontendFS, name)
+}
+
+// embeddedFrontendETag is a weak ETag from the file's content hash; embedded files carry
+// no modification time.
+func embeddedFrontendETag(name string, data []byte) string {
+	if etag, ok := embeddedFrontendETags.Load(name); ok {
+		return etag.(string)
+	}
+	sum := sha256.Sum256(data)
+	etag := `W/"` + hex.EncodeToString(sum[:8]) + `"`
+	embeddedFrontendETags.Store(name, etag)
+	return etag
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	configBundleMaxBytes      = 32 * 1024 * 1024
+	configBundleConfigEntry   = "config.json"
+	configBundleManifest      = "manifest.json"
+	configBundleDataPrefix    = "data/"
+	configBundleFormatVersion = 1
+)
+
+type configBundleManifestInfo struct {
+	FormatVersion   int    `json:"formatVersion"`
+	ServerVersion   string `json:"serverVersion"`
+	ExportedAt      string `json:"exportedAt"`
+	SecretsIncluded bool   `json:"secretsIncluded"`
+}
+
+// redactConfigSecrets clears the credentials in cfg: passhash, signingSecret,
+// turnSecretKey and the upstream passhashes.
+func redactConfigSecrets(cfg *ServerConfig) {
+	cfg.Passhash = ""
+	cfg.SigningSecret = ""
+	cfg.TURNSecretKey = ""
+	upstreams := make([]UpstreamConfig, len(cfg.Upstreams))
+	for i, upstream := range cfg.Upstreams {
+		upstream.Passhash = ""
+		upstreams[i] = upstream
+	}
+	cfg.Upstreams = upstreams
+}
+
+// keepRedactedConfigSecrets fills the credentials left empty in an imported config
+// from the stored one, so importing a redacted bundle does not wipe them.
+func keepRedactedConfigSecrets(imported *ServerConfig, stored ServerConfig) {
+	if imported.Passhash == "" {
+		imported.Passhash = stored.Passhash
+	}
+	if imported.SigningSecret == "" {
+		imported.SigningSecret = stored.SigningSecret
+	}
+	if imported.TURNSecretKey == "" {
+		imported.TURNSecretKey = stored.TURNSecretKey
+	}
+	storedUpstreams := make(map[string]string, len(stored.Upstreams))
+	for _, upstream := range stored.Upstreams {
+		storedUpstreams[upstream.Region] = upstream.Passhash
+	}
+	for i := range imported.Upstreams {
+		if imported.Upstreams[i].Passhash == "" {
+			imported.Upstreams[i].Passhash = storedUpstreams[imported.Upstreams[i].Region]
+		}
+	}
+}
+
+// listConfigBundleDataFiles returns the server-side state files (JSON files at the
+// data directory root). Category directories with device-uploaded files are excluded.
+func listConfigBundleDataFiles() ([]string, error) {
+	entries, err := os.ReadDir(getServerConfig().DataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if strings.ToLower(filepath.Ext(entry.Name())) != ".json" {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// writeConfigBundle writes the persisted config and state files as a zip archive to w.
+// Credentials in the config are redacted unless includeSecrets is set.
+func writeConfigBundle(w io.Writer, includeSecrets bool) error {
+	zw := zip.NewWriter(w)
+
+	writeEntry := func(name string, data []byte) error {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		return err
+	}
+
+	manifest, err := json.MarshalIndent(configBundleManifestInfo{
+		FormatVersion:   configBundleFormatVersion,
+		ServerVersion:   Version,
+		ExportedAt:      time.Now().Format(time.RFC3339),
+		SecretsIncluded: includeSecrets,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(configBundleManifest, manifest); err != nil {
+		return err
+	}
+
+	// Export what is on disk: env overrides and runtime-only values stay out of the bundle.
+	cfg, err := readPersistedServerConfig(serverConfigPath)
+	if err != nil {
+		return err
+	}
+	if !includeSecrets {
+		redactConfigSecrets(&cfg)
+	}
+	configData, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(configBundleConfigEntry, configData); err != nil {
+		return err
+	}
+
+	names, err := listConfigBundleDataFiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(getServerConfig().DataDir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if err := writeEntry(configBundleDataPrefix+name, data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeFileAtomic writes data to a temp file next to target and renames it into place.
+func writeFileAtomic(target string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+type configBundleImportResult struct {
+	Restored        []string `json:"restored"`
+	ConfigRestored  bool     `json:"configRestored"`
+	RestartRequired bool     `json:"restartRequired"`
+}
+
+// importConfigBundle validates every entry first, then restores state files and config.
+func importConfigBundle(data []byte) (configBundleImportResult, error) {
+	var result configBundleImportResult
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return result, fmt.Errorf("invalid bundle: %v", err)
+	}
+
+	readEntry := func(f *zip.File) ([]byte, error) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		limited := &io.LimitedReader{R: rc, N: configBundleMaxBytes + 1}
+		content, err := io.ReadAll(limited)
+		if err != nil {
+			return nil, err
+		}
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("bundle entry too large: %s", f.Name)
+		}
+		return content, nil
+	}
+
+	dataFiles := make(map[string][]byte)
+	var configData []byte
+	var manifest configBundleManifestInfo
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		switch {
+		case f.Name == configBundleManifest:
+			content, err := readEntry(f)
+			if err != nil {
+				return result, err
+			}
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				return result, fmt.Errorf("invalid bundle manifest: %v", err)
+			}
+		case f.Name == configBundleConfigEntry:
+			content, err := readEntry(f)
+			if err != nil {
+				return result, err
+			}
+			configData = content
+		case strings.HasPrefix(f.Name, configBundleDataPrefix):
+			name := strings.TrimPrefix(f.Name, configBundleDataPrefix)
+			if name != path.Base(name) || validateFileName(name) != nil || strings.ToLower(filepath.Ext(name)) != ".json" {
+				return result, fmt.Errorf("illegal bundle entry: %s", f.Name)
+			}
+			content, err := readEntry(f)
+			if err != nil {
+				return result, err
+			}
+			if !json.Valid(content) {
+				return result, fmt.Errorf("bundle entry is not valid JSON: %s", f.Name)
+			}
+			dataFiles[name] = content
+		default:
+			return result, fmt.Errorf("illegal bundle entry: %s", f.Name)
+		}
+	}
+
+	var importedConfig ServerConfig
+	if configData != nil {
+		importedConfig = DefaultConfig
+		if err := json.Unmarshal(configData, &importedConfig); err != nil {
+			return result, fmt.Errorf("invalid config in bundle: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(getServerConfig().DataDir, 0755); err != nil {
+		return result, fmt.Errorf("failed to create data directory: %v", err)
+	}
+	result.Restored = make([]string, 0, len(dataFiles))
+	for name, content := range dataFiles {
+		if err := writeFileAtomic(filepath.Join(getServerConfig().DataDir, name), content, 0644); err != nil {
+			return result, fmt.Errorf("failed to restore %s: %v", name, err)
+		}
+		result.Restored = append(result.Restored, name)
+	}
+
+	if err := reloadPersistedData(); err != nil {
+		return result, err
+	}
+
+	if configData != nil && serverConfigPath != "" {
+		if !manifest.SecretsIncluded {
+			stored, err := readPersistedServerConfig(serverConfigPath)
+			if err != nil {
+				return result, err
+			}
+			keepRedactedConfigSecrets(&importedConfig, stored)
+		}
+		if err := saveConfig(serverConfigPath, importedConfig); err != nil {
+			return result, err
+		}
+		result.ConfigRestored = true
+		result.RestartRequired = true
+	}
+
+	return result, nil
+}
+
+// adminExportBundleHandler handles GET /api/admin/export-bundle[?includeSecrets=1]
+func adminExportBundleHandler(c *gin.Context) {
+	includeSecrets := c.Query("includeSecrets") == "1" || c.Query("includeSecrets") == "true"
+	var buf bytes.Buffer
+	if err := writeConfigBundle(&buf, includeSecrets); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if includeSecrets {
+		slog.Warn("Exported config bundle with secrets", "remote_addr", c.ClientIP())
+	}
+
+	fileName := fmt.Sprintf("xxtcloud-bundle-%s.zip", time.Now().Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// adminImportBundleHandler handles POST /api/admin/import-bundle
+func adminImportBundleHandler(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		fileHeader, err = c.FormFile("bundle")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no bundle uploaded"})
+		return
+	}
+	if fileHeader.Size > configBundleMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "bundle too large"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to open bundle"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, configBundleMaxBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read bundle"})
+		return
+	}
+	if len(data) > configBundleMaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "bundle too large"})
+		return
+	}
+
+	result, err := importConfigBundle(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	broadcastGroupUpdated("", groupChangeReloaded)
+	slog.Info("Imported config bundle", "state_files", len(result.Restored), "config", result.ConfigRestored)
+	c.JSON(http.StatusOK, gin.H{"success": true, "result": result})
+}
+
+// adminNonceStatsHandler handles GET /api/admin/nonce-stats
+func adminNonceStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, getNonceStoreStats(time.Now()))
+}
+
+// adminNonceClearHandler handles POST /api/admin/nonce-clear
+func adminNonceClearHandler(c *gin.Context) {
+	removed := clearNonceStore()
+	slog.Info("Cleared nonce store", "removed", removed)
+	c.JSON(http.StatusOK, gin.H{"success": true, "removed": removed})
+}
+
+// adminSetPasswordHandler handles POST /api/admin/set-password
+// Like -set-password, but for a running server: the new passhash is written to the
+// config file and signs requests immediately. Only accepted from the local machine without
+// proxy headers (as for /api/local-admin/*), on top of the usual signature check.
+func adminSetPasswordHandler(c *gin.Context) {
+	if !isDirectLocalRequest(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only allowed from local machine"})
+		return
+	}
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Password) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return
+	}
+	if _, ok := envString("XXTCC_PASSWORD"); ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "password is set by XXTCC_PASSWORD; change the environment instead"})
+		return
+	}
+	if _, ok := envString("XXTCC_PASSHASH"); ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "passhash is set by XXTCC_PASSHASH; change the environment instead"})
+		return
+	}
+
+	serverConfigPatchMu.Lock()
+	defer serverConfigPatchMu.Unlock()
+
+	newHash := toPasshash(req.Password)
+	persisted := false
+	if serverConfigPath != "" {
+		stored, err := readPersistedServerConfig(serverConfigPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		stored.Passhash = newHash
+		data, err := json.MarshalIndent(stored, "", "  ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := writeFileAtomic(serverConfigPath, data, 0644); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save config"})
+			return
+		}
+		persisted = true
+	}
+
+	current := *getServerConfig()
+	updated := current
+	updated.Passhash = newHash
+	applyServerConfigChanges(current, updated)
+	slog.Info("Password changed via API", "persisted", persisted, "remote_addr", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"success": true, "persisted": persisted})
+}
+
+// validateFrontendDir checks that dir is a directory containing an index.html file.
+func validateFrontendDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("frontend directory not found: %s", dir)
+	}
+	index, err := os.Stat(filepath.Join(dir, "index.html"))
+	if err != nil || !index.Mode().IsRegular() {
+		return fmt.Errorf("index.html not found in %s", dir)
+	}
+	return nil
+}
+
+// adminReloadFrontendHandler handles POST /api/admin/reload-frontend
+// It switches the directory static files are served from without a restart. The change
+// is not persisted; set frontend_dir in the config to keep it across restarts.
+func adminReloadFrontendHandler(c *gin.Context) {
+	var req struct {
+		Dir string `json:"dir"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Dir) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dir is required"})
+		return
+	}
+	dir := filepath.Clean(strings.TrimSpace(req.Dir))
+	if err := validateFrontendDir(dir); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Hold the config patch lock too so a concurrent PATCH cannot restore the old value.
+	serverConfigPatchMu.Lock()
+	previous := getServerConfig().FrontendDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.FrontendDir = dir })
+	serverConfigPatchMu.Unlock()
+
+	slog.Info("Frontend directory switched", "previous", previous, "dir", dir)
+	c.JSON(http.StatusOK, gin.H{"success": true, "dir": dir, "previous": previous})
+}
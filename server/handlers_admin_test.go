@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConfigBundle_ExportImportRoundTrip(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	configBackup := *getServerConfig()
+	configPathBackup := serverConfigPath
+	serverConfigPath = filepath.Join(t.TempDir(), "xxtcloudserver.json")
+	t.Cleanup(func() {
+		setServerConfig(configBackup)
+		serverConfigPath = configPathBackup
+	})
+
+	deviceGroupsMu.Lock()
+	groupsBackup := cloneGroupInfos(deviceGroups)
+	deviceGroupsMu.Unlock()
+	t.Cleanup(func() {
+		deviceGroupsMu.Lock()
+		deviceGroups = groupsBackup
+		deviceGroupsMu.Unlock()
+	})
+
+	if err := saveGroupsSnapshot([]GroupInfo{{ID: "g1", Name: "Exported", DeviceIDs: []string{"d1"}}}); err != nil {
+		t.Fatalf("save groups failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "files", "device-upload.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write device file failed: %v", err)
+	}
+	stored := DefaultConfig
+	stored.Port = 40001
+	stored.Passhash = strings.Repeat("a", PasshashLength)
+	stored.SigningSecret = "exported-secret"
+	if err := saveConfig(serverConfigPath, stored); err != nil {
+		t.Fatalf("save config failed: %v", err)
+	}
+	// The live config differs from the file; the bundle carries the file.
+	updateServerConfig(func(cfg *ServerConfig) { cfg.Port = 40002 })
+
+	var buf bytes.Buffer
+	if err := writeConfigBundle(&buf, false); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("exported bundle is not a zip: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range zr.File {
+		names[f.Name] = true
+		if f.Name != "config.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open config entry failed: %v", err)
+		}
+		var exported ServerConfig
+		err = json.NewDecoder(rc).Decode(&exported)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("decode exported config failed: %v", err)
+		}
+		if exported.Port != 40001 || exported.Passhash != "" || exported.SigningSecret != "" {
+			t.Fatalf("expected the persisted config with secrets redacted, got port=%d passhash=%q signingSecret=%q", exported.Port, exported.Passhash, exported.SigningSecret)
+		}
+	}
+	if !names["config.json"] || !names["data/groups.json"] {
+		t.Fatalf("expected config and groups in bundle, got %v", names)
+	}
+	if names["data/files/device-upload.txt"] {
+		t.Fatalf("device-uploaded files must not be exported")
+	}
+
+	if err := saveGroupsSnapshot([]GroupInfo{{ID: "g2", Name: "Changed"}}); err != nil {
+		t.Fatalf("save groups failed: %v", err)
+	}
+	stored.Port = 40003
+	stored.Passhash = strings.Repeat("b", PasshashLength)
+	if err := saveConfig(serverConfigPath, stored); err != nil {
+		t.Fatalf("save config failed: %v", err)
+	}
+
+	result, err := importConfigBundle(buf.Bytes())
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if !result.ConfigRestored || !result.RestartRequired {
+		t.Fatalf("expected config to be restored, got %+v", result)
+	}
+
+	deviceGroupsMu.RLock()
+	got := cloneGroupInfos(deviceGroups)
+	deviceGroupsMu.RUnlock()
+	if len(got) != 1 || got[0].ID != "g1" || got[0].Name != "Exported" {
+		t.Fatalf("expected groups to be restored and reloaded, got %+v", got)
+	}
+
+	configData, err := os.ReadFile(serverConfigPath)
+	if err != nil {
+		t.Fatalf("expected config file to be written: %v", err)
+	}
+	var restored ServerConfig
+	if err := json.Unmarshal(configData, &restored); err != nil {
+		t.Fatalf("decode restored config failed: %v", err)
+	}
+	if restored.Port != 40001 {
+		t.Fatalf("expected restored port 40001, got %d", restored.Port)
+	}
+	if restored.Passhash != stored.Passhash || restored.SigningSecret != "exported-secret" {
+		t.Fatalf("expected redacted secrets to keep the stored values, got passhash=%q signingSecret=%q", restored.Passhash, restored.SigningSecret)
+	}
+
+	var withSecrets bytes.Buffer
+	if err := writeConfigBundle(&withSecrets, true); err != nil {
+		t.Fatalf("export with secrets failed: %v", err)
+	}
+	stored.Passhash = strings.Repeat("c", PasshashLength)
+	if err := saveConfig(serverConfigPath, stored); err != nil {
+		t.Fatalf("save config failed: %v", err)
+	}
+	if _, err := importConfigBundle(withSecrets.Bytes()); err != nil {
+		t.Fatalf("import with secrets failed: %v", err)
+	}
+	restored, err = readPersistedServerConfig(serverConfigPath)
+	if err != nil {
+		t.Fatalf("read restored config failed: %v", err)
+	}
+	if restored.Passhash != strings.Repeat("b", PasshashLength) {
+		t.Fatalf("expected the bundled passhash to be restored, got %q", restored.Passhash)
+	}
+}
+
+func TestImportConfigBundle_RejectsTraversalEntries(t *testing.T) {
+	setupFileHandlersTestDataDir(t)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create("data/../evil.json")
+	if err != nil {
+		t.Fatalf("create entry failed: %v", err)
+	}
+	_, _ = fw.Write([]byte("{}"))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip failed: %v", err)
+	}
+
+	if _, err := importConfigBundle(buf.Bytes()); err == nil {
+		t.Fatalf("expected traversal entry to be rejected")
+	}
+}
+
+func TestAdminReloadFrontend_SwitchesStaticDir(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	prevDir := getServerConfig().FrontendDir
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.FrontendDir = prevDir }) })
+
+	newDir := t.TempDir()
+	if w := performJSONHandlerRequest(t, http.MethodPost, "/api/admin/reload-frontend", map[string]string{"dir": newDir}, adminReloadFrontendHandler); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without index.html, got %d body=%s", w.Code, w.Body.String())
+	}
+	if currentFrontendDir() != prevDir {
+		t.Fatalf("frontend dir should not change on validation failure")
+	}
+
+	if err := os.WriteFile(filepath.Join(newDir, "index.html"), []byte("<html>v2</html>"), 0o644); err != nil {
+		t.Fatalf("write index failed: %v", err)
+	}
+	if w := performJSONHandlerRequest(t, http.MethodPost, "/api/admin/reload-frontend", map[string]string{"dir": newDir}, adminReloadFrontendHandler); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if currentFrontendDir() != newDir {
+		t.Fatalf("expected frontend dir %s, got %s", newDir, currentFrontendDir())
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/some/route", nil)
+	staticFileHandler(c)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "v2") {
+		t.Fatalf("expected new index.html to be served, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminSetPassword_RotatesPasshashFromLocalhost(t *testing.T) {
+	configPath := setupServerConfigPatchFixture(t)
+	passhashBackup := passhash
+	t.Cleanup(func() { setPasshash(string(passhashBackup)) })
+	setPasshash(strings.Repeat("a", PasshashLength))
+	oldSignature := computeSignatureHex("payload")
+
+	payload := map[string]any{"password": "n3w-pa55"}
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/admin/set-password", payload, adminSetPasswordHandler)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected remote request to be rejected, got %d", w.Code)
+	}
+
+	local := func(c *gin.Context) {
+		c.Request.RemoteAddr = "127.0.0.1:50000"
+		adminSetPasswordHandler(c)
+	}
+	proxied := func(c *gin.Context) {
+		c.Request.RemoteAddr = "127.0.0.1:50000"
+		c.Request.Header.Set("X-Forwarded-For", "203.0.113.9")
+		adminSetPasswordHandler(c)
+	}
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/admin/set-password", payload, proxied)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected request through a local proxy to be rejected, got %d", w.Code)
+	}
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/admin/set-password", map[string]any{"password": " "}, local)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected empty password to be rejected, got %d", w.Code)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/admin/set-password", payload, local)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	want := toPasshash("n3w-pa55")
+	stored, err := readPersistedServerConfig(configPath)
+	if err != nil {
+		t.Fatalf("read persisted config failed: %v", err)
+	}
+	if stored.Passhash != want || getServerConfig().Passhash != want {
+		t.Fatalf("expected new passhash persisted and running, got stored=%q running=%q", stored.Passhash, getServerConfig().Passhash)
+	}
+	if computeSignatureHex("payload") == oldSignature {
+		t.Fatalf("expected signatures to use the new passhash immediately")
+	}
+
+	t.Setenv("XXTCC_PASSWORD", "from-env")
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/admin/set-password", payload, local)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected env-managed password to conflict, got %d", w.Code)
+	}
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -65,7 +67,8 @@ func apiAuthMiddleware() gin.HandlerFunc {
 			c.Next()
 			return
 		}
-		if path == "/api/download-bind-script" || path == "/api/ws" || path == "/api/config" || path == "/api/control/info" {
+		if path == "/api/download-bind-script" || path == "/api/ws" || path == "/api/ws/device" || path == "/api/ws/control" ||
+			path == "/api/config" || path == "/api/control/info" || path == "/api/health" || path == "/api/ready" {
 			c.Next()
 			return
 		}
@@ -74,6 +77,11 @@ func apiAuthMiddleware() gin.HandlerFunc {
 			c.Next()
 			return
 		}
+		// Local admin endpoints are gated by localAdminMiddleware instead
+		if strings.HasPrefix(path, localAdminPathPrefix) {
+			c.Next()
+			return
+		}
 		if c.Request.Method == http.MethodOptions {
 			c.Next()
 			return
@@ -87,6 +95,28 @@ func apiAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// accessLogMiddleware keeps gin's console access log for the text format and emits
+// structured request records for the json format. The format is read per request so
+// logFormat changes apply without a restart.
+func accessLogMiddleware() gin.HandlerFunc {
+	consoleLogger := gin.Logger()
+	return func(c *gin.Context) {
+		if !strings.EqualFold(strings.TrimSpace(getServerConfig().LogFormat), "json") {
+			consoleLogger(c)
+			return
+		}
+		start := time.Now()
+		c.Next()
+		slog.Info("HTTP request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"remote_addr", c.ClientIP(),
+		)
+	}
+}
+
 // corsMiddleware provides CORS support
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -102,6 +132,47 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// validDisabledEndpoints reports whether every disabledEndpoints entry is an /api/ path.
+func validDisabledEndpoints(patterns []string) bool {
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(strings.TrimSpace(pattern), "/api/") {
+			return false
+		}
+	}
+	return true
+}
+
+// isEndpointDisabled checks the request path and the matched route pattern
+// (e.g. "/api/groups/:id") against DisabledEndpoints.
+func isEndpointDisabled(path, route string) bool {
+	for _, raw := range getServerConfig().DisabledEndpoints {
+		pattern := strings.TrimSpace(raw)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if prefix != "" && (strings.HasPrefix(path, prefix) || (route != "" && strings.HasPrefix(route, prefix))) {
+				return true
+			}
+			continue
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern != "" && (strings.TrimSuffix(path, "/") == pattern || route == pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// disabledEndpointsMiddleware hides routes listed in DisabledEndpoints
+func disabledEndpointsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(getServerConfig().DisabledEndpoints) > 0 && isEndpointDisabled(path.Clean(c.Request.URL.Path), c.FullPath()) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "endpoint disabled"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 // isLocalRequest checks if the request is from localhost
 func isLocalRequest(c *gin.Context) bool {
 	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
@@ -279,11 +350,18 @@ func sanitizeBindHost(host string) (string, error) {
 func configHandler(c *gin.Context) {
 	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
 
+	wsProto, httpProto := "ws", "http"
+	if nativeTLSEnabled() {
+		wsProto, httpProto = "wss", "https"
+	}
+
 	config := gin.H{
 		"version":    Version,
 		"serverTime": time.Now().Unix(),
+		"protocol":   httpProto,
 		"websocket": gin.H{
-			"port":              serverConfig.Port,
+			"protocol":          wsProto,
+			"port":              getServerConfig().Port,
 			"path":              "/api/ws",
 			"autoReconnect":     true,
 			"reconnectInterval": 3000,
@@ -296,6 +374,10 @@ func configHandler(c *gin.Context) {
 		},
 	}
 
+	if acmeEnabled() {
+		config["websocket"].(gin.H)["host"] = advertisedHost("")
+	}
+
 	if c.Query("format") == "json" || strings.Contains(c.GetHeader("Accept"), "application/json") {
 		c.JSON(http.StatusOK, config)
 		return
@@ -312,7 +394,7 @@ func configHandler(c *gin.Context) {
 	configJS := fmt.Sprintf(`// Dynamically generated configuration
 window.XXTConfig = %s;
 
-console.log('Server config loaded (port: %d):', window.XXTConfig);`, string(configBytes), serverConfig.Port)
+console.log('Server config loaded (port: %d):', window.XXTConfig);`, string(configBytes), getServerConfig().Port)
 
 	c.String(http.StatusOK, configJS)
 }
@@ -326,7 +408,7 @@ func controlInfoHandler(c *gin.Context) {
 		"version":    Version,
 		"serverTime": time.Now().Unix(),
 		"websocket": gin.H{
-			"port":              serverConfig.Port,
+			"port":              getServerConfig().Port,
 			"path":              "/api/ws",
 			"autoReconnect":     true,
 			"reconnectInterval": 3000,
@@ -343,6 +425,9 @@ func controlInfoHandler(c *gin.Context) {
 // downloadBindScriptHandler handles the /api/download-bind-script endpoint
 func downloadBindScriptHandler(c *gin.Context) {
 	hostParam := c.Query("host")
+	if hostParam == "" && acmeEnabled() {
+		hostParam = advertisedHost("")
+	}
 	if hostParam == "" {
 		c.JSON(http.StatusNotFound, gin.H{"error": "host parameter is required"})
 		return
@@ -352,8 +437,9 @@ func downloadBindScriptHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	host = advertisedHost(host)
 
-	port := serverConfig.Port
+	port := getServerConfig().Port
 	if portParam := strings.TrimSpace(c.Query("port")); portParam != "" {
 		p, err := strconv.Atoi(portParam)
 		if err != nil || p < 1 || p > 65535 {
@@ -372,7 +458,7 @@ func downloadBindScriptHandler(c *gin.Context) {
 	}
 	if proto == "https" || proto == "wss" {
 		wsProto = "wss"
-	} else if proto == "" && serverConfig.TLSEnabled && serverConfig.TLSCertFile != "" && serverConfig.TLSKeyFile != "" {
+	} else if proto == "" && nativeTLSEnabled() {
 		// Native TLS mode enabled
 		wsProto = "wss"
 	}
@@ -431,19 +517,36 @@ os.exit()
 	c.String(http.StatusOK, luaScript)
 }
 
+// currentFrontendDir returns the directory static files are served from, which can be
+// switched at runtime by POST /api/admin/reload-frontend.
+func currentFrontendDir() string {
+	return getServerConfig().FrontendDir
+}
+
 // staticFileHandler handles static file serving
 func staticFileHandler(c *gin.Context) {
 	path := filepath.Clean(c.Request.URL.Path)
 
 	if path == "/" || path == "." {
+		if target := landingRedirectTarget(); target != "" {
+			c.Redirect(http.StatusFound, target)
+			return
+		}
 		path = "/index.html"
 	}
 
-	fullPath := filepath.Join(serverConfig.FrontendDir, path)
+	frontendDir := currentFrontendDir()
+	if useEmbeddedFrontend(frontendDir) {
+		serveEmbeddedFrontendFile(c, path)
+		return
+	}
+	fullPath := filepath.Join(frontendDir, path)
 
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
 		if path != "/" {
-			fullPath = filepath.Join(serverConfig.FrontendDir, "index.html")
+			fullPath = filepath.Join(frontendDir, "index.html")
+			info, err = os.Stat(fullPath)
 		} else {
 			c.Status(http.StatusNotFound)
 			return
@@ -451,9 +554,54 @@ func staticFileHandler(c *gin.Context) {
 	}
 
 	setContentTypeAndCache(c, fullPath)
+	// The ETag is taken from the file actually served, so the SPA fallback never answers
+	// with another file's tag; HTML is no-store and gets none.
+	if err == nil && info.Mode().IsRegular() && strings.ToLower(filepath.Ext(fullPath)) != ".html" {
+		etag := staticFileETag(info)
+		c.Header("ETag", etag)
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
 	c.File(fullPath)
 }
 
+// staticFileETag is a weak ETag from the file's size and modification time.
+func staticFileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the weak
+// comparison (a W/ prefix is ignored on either side).
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalRedirectPath reports whether target is a same-origin path ("/x", not "//host").
+func isLocalRedirectPath(target string) bool {
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, "/\\")
+}
+
+// landingRedirectTarget returns where "/" should redirect, or "" to serve index.html.
+func landingRedirectTarget() string {
+	target := strings.TrimSpace(getServerConfig().LandingRedirect)
+	if target == "" || target == "/" || !isLocalRedirectPath(target) {
+		return ""
+	}
+	return target
+}
+
 // setContentTypeAndCache sets appropriate Content-Type and cache headers
 func setContentTypeAndCache(c *gin.Context, filePath string) {
 	ext := strings.ToLower(filepath.Ext(filePath))
@@ -535,14 +683,9 @@ func setAppSettingsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// printNetworkEndpoints prints available network endpoints
-func printNetworkEndpoints(port int, tlsEnabled bool) {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		fmt.Printf("Failed to get network interfaces: %v\n", err)
-		return
-	}
-
+// printNetworkEndpoints logs available network endpoints. When bound to a single address
+// only that address is listed.
+func printNetworkEndpoints(bindAddress string, port int, tlsEnabled bool) {
 	httpScheme := "http"
 	wsScheme := "ws"
 	if tlsEnabled {
@@ -550,7 +693,25 @@ func printNetworkEndpoints(port int, tlsEnabled bool) {
 		wsScheme = "wss"
 	}
 
-	fmt.Println("\n=== Available Network Endpoints ===")
+	if bindIP := net.ParseIP(bindAddress); bindIP != nil && !bindIP.IsUnspecified() {
+		host := net.JoinHostPort(bindIP.String(), strconv.Itoa(port))
+		if bindIP.IsLoopback() {
+			slog.Info("Local access only",
+				"frontend", fmt.Sprintf("%s://%s/", httpScheme, host),
+				"websocket", fmt.Sprintf("%s://%s/api/ws", wsScheme, host))
+		} else {
+			slog.Info("Network endpoint", "ip", bindIP.String(),
+				"frontend", fmt.Sprintf("%s://%s/", httpScheme, host),
+				"websocket", fmt.Sprintf("%s://%s/api/ws", wsScheme, host))
+		}
+		return
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		slog.Warn("Failed to get network interfaces", "error", err)
+		return
+	}
 
 	for _, iface := range interfaces {
 		if iface.Flags&net.FlagUp == 0 {
@@ -582,16 +743,14 @@ func printNetworkEndpoints(port int, tlsEnabled bool) {
 				if ip.To4()[0] == 169 && ip.To4()[1] == 254 {
 					continue
 				}
-				fmt.Printf("Interface: %-15s IP: %-15s\n", iface.Name, ip.String())
-				fmt.Printf("  Frontend:    %s://%s:%d/\n", httpScheme, ip.String(), port)
-				fmt.Printf("  WebSocket:   %s://%s:%d/api/ws\n", wsScheme, ip.String(), port)
-				fmt.Println()
+				slog.Info("Network endpoint", "interface", iface.Name, "ip", ip.String(),
+					"frontend", fmt.Sprintf("%s://%s:%d/", httpScheme, ip.String(), port),
+					"websocket", fmt.Sprintf("%s://%s:%d/api/ws", wsScheme, ip.String(), port))
 			}
 		}
 	}
 
-	fmt.Printf("Local access:\n")
-	fmt.Printf("  Frontend:    %s://localhost:%d/\n", httpScheme, port)
-	fmt.Printf("  WebSocket:   %s://localhost:%d/api/ws\n", wsScheme, port)
-	fmt.Println("===================================")
+	slog.Info("Local access",
+		"frontend", fmt.Sprintf("%s://localhost:%d/", httpScheme, port),
+		"websocket", fmt.Sprintf("%s://localhost:%d/api/ws", wsScheme, port))
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDisabledEndpointsMiddleware(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+	updateServerConfig(func(cfg *ServerConfig) {
+		cfg.DisabledEndpoints = []string{"/api/server-files/open-local", "/api/update/*", "/api/groups/:id"}
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(disabledEndpointsMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.POST("/api/server-files/open-local", ok)
+	r.POST("/api/server-files/create", ok)
+	r.GET("/api/update/status", ok)
+	r.PUT("/api/groups/:id", ok)
+	r.GET("/api/groups", ok)
+
+	cases := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{http.MethodPost, "/api/server-files/open-local", http.StatusNotFound},
+		{http.MethodPost, "/api/server-files/create", http.StatusOK},
+		{http.MethodGet, "/api/update/status", http.StatusNotFound},
+		{http.MethodPut, "/api/groups/g1", http.StatusNotFound},
+		{http.MethodGet, "/api/groups", http.StatusOK},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.want {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.target, tc.want, w.Code)
+		}
+	}
+}
+
+func TestValidDisabledEndpoints(t *testing.T) {
+	if !validDisabledEndpoints([]string{"/api/update/*", " /api/pulse "}) {
+		t.Fatalf("expected /api/ entries to be valid")
+	}
+	if validDisabledEndpoints([]string{"/index.html"}) {
+		t.Fatalf("expected non-API entry to be rejected")
+	}
+}
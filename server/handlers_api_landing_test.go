@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func performStaticRequest(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(staticFileHandler)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestStaticFileHandler_LandingRedirect(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.FrontendDir = t.TempDir() })
+	if err := os.WriteFile(filepath.Join(getServerConfig().FrontendDir, "index.html"), []byte("<html></html>"), 0644); err != nil {
+		t.Fatalf("write index failed: %v", err)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LandingRedirect = "" })
+	if w := performStaticRequest(t, "/"); w.Code != http.StatusOK {
+		t.Fatalf("expected index to be served without redirect, got %d", w.Code)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LandingRedirect = "/devices/overview" })
+	w := performStaticRequest(t, "/")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/devices/overview" {
+		t.Fatalf("expected 302 to /devices/overview, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := performStaticRequest(t, "/devices/overview"); w.Code != http.StatusOK {
+		t.Fatalf("redirect target should fall back to SPA index, got %d", w.Code)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LandingRedirect = "//evil.example" })
+	if w := performStaticRequest(t, "/"); w.Code != http.StatusOK {
+		t.Fatalf("non-local redirect should be ignored, got %d", w.Code)
+	}
+}
+
+func TestStaticFileHandler_ETagRevalidation(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.FrontendDir = t.TempDir() })
+	if err := os.WriteFile(filepath.Join(getServerConfig().FrontendDir, "index.html"), []byte("<html></html>"), 0644); err != nil {
+		t.Fatalf("write index failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(getServerConfig().FrontendDir, "app.js"), []byte("console.log(1)"), 0644); err != nil {
+		t.Fatalf("write app.js failed: %v", err)
+	}
+
+	w := performStaticRequest(t, "/app.js")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected a weak ETag on app.js, got %d %q", w.Code, etag)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(staticFileHandler)
+	revalidate := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := revalidate("/app.js", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := revalidate("/app.js", `W/"other"`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a stale ETag, got %d", w.Code)
+	}
+
+	// The SPA fallback serves index.html, which must never be revalidated with a file's ETag.
+	w = revalidate("/devices/overview", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "" || !strings.Contains(w.Body.String(), "<html>") {
+		t.Fatalf("expected the SPA index without ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestStaticFileHandler_EmbeddedFrontendFallback(t *testing.T) {
+	prev := *getServerConfig()
+	prevFS := embeddedFrontendFS
+	t.Cleanup(func() {
+		setServerConfig(prev)
+		embeddedFrontendFS = prevFS
+		embeddedFrontendETags = sync.Map{}
+	})
+	embeddedFrontendETags = sync.Map{}
+	embeddedFrontendFS = fstest.MapFS{
+		"index.html":    {Data: []byte("<html>embedded</html>")},
+		"assets/app.js": {Data: []byte("console.log('embedded')")},
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.FrontendDir = filepath.Join(t.TempDir(), "missing") })
+	w := performStaticRequest(t, "/devices/overview")
+	if w.Code != http.StatusOK || w.Body.String() != "<html>embedded</html>" {
+		t.Fatalf("expected SPA fallback to embedded index, got %d %q", w.Code, w.Body.String())
+	}
+	if w := performStaticRequest(t, "/assets"); w.Body.String() != "<html>embedded</html>" {
+		t.Fatalf("directories should fall back to the embedded index, got %q", w.Body.String())
+	}
+
+	w = performStaticRequest(t, "/assets/app.js")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "console.log('embedded')" || etag == "" {
+		t.Fatalf("expected embedded asset with an ETag, got %d %q etag=%q", w.Code, w.Body.String(), etag)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/javascript; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(staticFileHandler)
+	req := httptest.NewRequest(http.MethodGet, "/assets/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	revalidated := httptest.NewRecorder()
+	r.ServeHTTP(revalidated, req)
+	if revalidated.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for a matching ETag, got %d", revalidated.Code)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.FrontendDir = t.TempDir() })
+	if err := os.WriteFile(filepath.Join(getServerConfig().FrontendDir, "index.html"), []byte("<html>disk</html>"), 0644); err != nil {
+		t.Fatalf("write index failed: %v", err)
+	}
+	if w := performStaticRequest(t, "/"); w.Body.String() != "<html>disk</html>" {
+		t.Fatalf("an existing frontend_dir should win by default, got %q", w.Body.String())
+	}
+	updateServerConfig(func(cfg *ServerConfig) { cfg.PreferEmbeddedFrontend = true })
+	if w := performStaticRequest(t, "/"); w.Body.String() != "<html>embedded</html>" {
+		t.Fatalf("preferEmbeddedFrontend should force the embedded index, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snapshotDeviceTable copies deviceTable plus federated upstream devices; with onlineOnly,
+// restored (stale) entries of devices that are not connected are left out.
+func snapshotDeviceTable(onlineOnly bool) map[string]interface{} {
+	mu.RLock()
+	defer mu.RUnlock()
+	snapshot := make(map[string]interface{}, len(deviceTable))
+	for udid, deviceState := range deviceTable {
+		if onlineOnly {
+			if _, connected := deviceLinks[udid]; !connected {
+				continue
+			}
+		}
+		snapshot[udid] = deviceState
+	}
+	appendFederatedDevices(snapshot)
+	return snapshot
+}
+
+// parseDeviceFields splits a ?fields= value ("system.udid,system.battery") into key paths.
+func parseDeviceFields(raw string) [][]string {
+	var fields [][]string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields = append(fields, strings.Split(part, "."))
+	}
+	return fields
+}
+
+// projectDeviceState copies only the given key paths of a device state into a new map.
+// Missing keys are omitted.
+func projectDeviceState(state interface{}, fields [][]string) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, path := range fields {
+		value, ok := lookupDeviceStatePath(state, path)
+		if !ok {
+			continue
+		}
+		node := out
+		for _, key := range path[:len(path)-1] {
+			child, ok := node[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[key] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+	return out
+}
+
+func lookupDeviceStatePath(state interface{}, path []string) (interface{}, bool) {
+	current := state
+	for _, key := range path {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// devicesListHandler handles GET /api/devices (same body as the control/devices reply)
+func devicesListHandler(c *gin.Context) {
+	online := c.Query("online")
+	devices := snapshotDeviceTable(online == "1" || strings.EqualFold(online, "true"))
+
+	if fields := parseDeviceFields(c.Query("fields")); len(fields) > 0 {
+		for udid, state := range devices {
+			devices[udid] = projectDeviceState(state, fields)
+		}
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func setupDevicesListFixture(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	tableBackup, linksBackup := deviceTable, deviceLinks
+	deviceTable = map[string]interface{}{
+		"d1": map[string]interface{}{
+			"system": map[string]interface{}{"udid": "d1", "battery": 80.0, "name": "Phone 1"},
+			"script": map[string]interface{}{"running": true},
+		},
+		"d2": map[string]interface{}{
+			"system": map[string]interface{}{"udid": "d2", "name": "Phone 2"},
+			"stale":  true,
+		},
+	}
+	deviceLinks = map[string]*SafeConn{"d1": {}}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceTable, deviceLinks = tableBackup, linksBackup
+		mu.Unlock()
+	})
+}
+
+func decodeDevicesList(t *testing.T, target string) map[string]interface{} {
+	t.Helper()
+	w := performJSONHandlerRequest(t, http.MethodGet, target, nil, devicesListHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var devices map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &devices); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	return devices
+}
+
+func TestDevicesListHandler_OnlineAndFields(t *testing.T) {
+	setupDevicesListFixture(t)
+
+	if devices := decodeDevicesList(t, "/api/devices"); len(devices) != 2 {
+		t.Fatalf("expected both devices, got %v", devices)
+	}
+
+	devices := decodeDevicesList(t, "/api/devices?online=1&fields=system.udid,system.battery,missing.key")
+	want := map[string]interface{}{
+		"d1": map[string]interface{}{
+			"system": map[string]interface{}{"udid": "d1", "battery": 80.0},
+		},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Fatalf("unexpected projection: got=%v want=%v", devices, want)
+	}
+
+	devices = decodeDevicesList(t, "/api/devices?fields=stale")
+	if !reflect.DeepEqual(devices["d2"], map[string]interface{}{"stale": true}) || !reflect.DeepEqual(devices["d1"], map[string]interface{}{}) {
+		t.Fatalf("unexpected top-level projection: %v", devices)
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
@@ -10,7 +13,11 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
@@ -122,7 +129,7 @@ func validatePath(category, subPath string) (string, error) {
 		return "", fmt.Errorf("invalid category: %s", category)
 	}
 
-	baseDir := filepath.Join(serverConfig.DataDir, category)
+	baseDir := filepath.Join(getServerConfig().DataDir, category)
 	absBaseDir, err := filepath.Abs(baseDir)
 	if err != nil {
 		return "", err
@@ -161,7 +168,112 @@ func validateFileName(name string) error {
 	return nil
 }
 
+// fileListOptions are the paging and sorting parameters of GET /api/server-files/list.
+type fileListOptions struct {
+	sortKey   string // "", "name", "size" or "modTime"; "" keeps directory order
+	desc      bool
+	dirsFirst bool
+	offset    int
+	limit     int // 0 = no limit
+}
+
+// parseFileListOptions reads offset, limit, sort, order and dirsFirst from the query.
+func parseFileListOptions(c *gin.Context) (fileListOptions, error) {
+	var opts fileListOptions
+	switch sortKey := c.Query("sort"); sortKey {
+	case "", "name", "size", "modTime":
+		opts.sortKey = sortKey
+	default:
+		return opts, fmt.Errorf("sort must be name, size or modTime")
+	}
+	switch strings.ToLower(c.Query("order")) {
+	case "", "asc":
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+	switch strings.ToLower(c.Query("dirsFirst")) {
+	case "1", "true", "yes":
+		opts.dirsFirst = true
+	}
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{{"offset", &opts.offset}, {"limit", &opts.limit}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return opts, fmt.Errorf("%s must be a non-negative integer", param.name)
+		}
+		*param.target = parsed
+	}
+	return opts, nil
+}
+
+// page returns the [offset, offset+limit) window of n items.
+func (opts fileListOptions) page(n int) (int, int) {
+	start := min(opts.offset, n)
+	end := n
+	if opts.limit > 0 {
+		end = min(start+opts.limit, n)
+	}
+	return start, end
+}
+
+// sortFileItems orders files by opts; names break ties (see compareFileNames).
+func sortFileItems(files []ServerFileItem, opts fileListOptions) {
+	if opts.sortKey == "" && !opts.dirsFirst {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if opts.dirsFirst && (a.Type == "dir") != (b.Type == "dir") {
+			return a.Type == "dir"
+		}
+		cmp := 0
+		switch opts.sortKey {
+		case "size":
+			cmp = compareInt64(a.Size, b.Size)
+		case "modTime":
+			// ModTime is "2006-01-02 15:04:05", so string order is time order
+			cmp = strings.Compare(a.ModTime, b.ModTime)
+		}
+		if cmp == 0 && opts.sortKey != "" {
+			cmp = compareFileNames(a.Name, b.Name)
+		}
+		if opts.desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+}
+
+// compareFileNames compares names case-insensitively, falling back to exact order.
+func compareFileNames(a, b string) int {
+	if cmp := strings.Compare(strings.ToLower(a), strings.ToLower(b)); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
 // serverFilesListHandler handles GET /api/server-files/list
+// Optional offset/limit return one page (total is the full count); sort (name, size or
+// modTime), order (asc/desc) and dirsFirst reorder entries before paging. Without them
+// every entry is returned in directory order, as before.
 func serverFilesListHandler(c *gin.Context) {
 	category := c.DefaultQuery("category", "scripts")
 	subPath := c.DefaultQuery("path", "")
@@ -174,6 +286,14 @@ func serverFilesListHandler(c *gin.Context) {
 			includeMeta = true
 		}
 	}
+	opts, err := parseFileListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if opts.sortKey == "size" || opts.sortKey == "modTime" {
+		includeMeta = true
+	}
 
 	targetPath, err := validatePath(category, subPath)
 	if err != nil {
@@ -183,7 +303,7 @@ func serverFilesListHandler(c *gin.Context) {
 
 	info, err := os.Stat(targetPath)
 	if os.IsNotExist(err) {
-		c.JSON(http.StatusOK, gin.H{"files": []ServerFileItem{}})
+		c.JSON(http.StatusOK, gin.H{"files": []ServerFileItem{}, "total": 0})
 		return
 	}
 	if err != nil {
@@ -201,6 +321,18 @@ func serverFilesListHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	total := len(entries)
+
+	// Paging in directory or name order needs no per-entry stat, so only the page is
+	// classified; other orders have to classify every entry first.
+	classifyAll := opts.dirsFirst || (opts.sortKey != "" && opts.sortKey != "name")
+	if !classifyAll {
+		if opts.sortKey == "name" {
+			sortDirEntriesByName(entries, opts.desc)
+		}
+		start, end := opts.page(len(entries))
+		entries = entries[start:end]
+	}
 
 	files := make([]ServerFileItem, 0, len(entries))
 	for _, entry := range entries {
@@ -214,8 +346,24 @@ func serverFilesListHandler(c *gin.Context) {
 			IsSymlink: isSymlink,
 		})
 	}
+	if classifyAll {
+		sortFileItems(files, opts)
+		start, end := opts.page(len(files))
+		files = files[start:end]
+	}
 
-	c.JSON(http.StatusOK, gin.H{"files": files, "path": subPath, "category": category})
+	c.JSON(http.StatusOK, gin.H{"files": files, "path": subPath, "category": category, "total": total})
+}
+
+// sortDirEntriesByName orders entries like sortFileItems does for sort=name.
+func sortDirEntriesByName(entries []os.DirEntry, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		cmp := compareFileNames(entries[i].Name(), entries[j].Name())
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
 }
 
 // serverFilesUploadHandler handles POST /api/server-files/upload
@@ -248,7 +396,7 @@ func serverFilesUploadHandler(c *gin.Context) {
 	}
 
 	if category == "scripts" && isLanControlArchiveFileName(fileName) {
-		result, err := installLanControlArchiveFromReader(serverConfig.DataDir, fileName, file, "", false)
+		result, err := installLanControlArchiveFromReader(getServerConfig().DataDir, fileName, file, "", false)
 		if err != nil {
 			status := http.StatusBadRequest
 			if strings.Contains(err.Error(), "already exists") {
@@ -271,7 +419,7 @@ func serverFilesUploadHandler(c *gin.Context) {
 
 	targetFilePath := filepath.Join(targetDir, fileName)
 
-	baseDir := filepath.Join(serverConfig.DataDir, category)
+	baseDir := filepath.Join(getServerConfig().DataDir, category)
 	absBaseDir, err := filepath.Abs(baseDir)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve base path"})
@@ -299,7 +447,7 @@ func serverFilesUploadHandler(c *gin.Context) {
 		return
 	}
 
-	debugLogf("📤 File uploaded: %s/%s/%s", category, subPath, header.Filename)
+	logDebug("File uploaded", "category", category, "path", subPath, "file", header.Filename)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
@@ -344,7 +492,7 @@ func serverFilesDownloadHandler(c *gin.Context) {
 	}
 
 	if info.IsDir() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot download a directory"})
+		streamDirectoryZip(c, targetPath)
 		return
 	}
 
@@ -363,6 +511,54 @@ func serverFilesDownloadHandler(c *gin.Context) {
 	c.File(targetPath)
 }
 
+// streamDirectoryZip writes dirPath as a zip archive built on the fly. Nested directory
+// symlinks are skipped like when sending scripts; unreadable entries are logged and left out
+// since the response status has already been sent.
+func streamDirectoryZip(c *gin.Context, dirPath string) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", filepath.Base(dirPath)))
+	clearTransferRequestDeadlines(c)
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	addFile := func(filePath string, info os.FileInfo) error {
+		relPath, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		header.Method = zip.Deflate
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			slog.Warn("Skipping unreadable file in directory download", "path", filePath, "error", err)
+			return nil
+		}
+		defer file.Close()
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, file)
+		return err
+	}
+	onError := func(entryPath string, err error) error {
+		slog.Warn("Skipping entry in directory download", "path", entryPath, "error", err)
+		return nil
+	}
+	if err := walkScriptFilesWithErrorHandler(dirPath, addFile, onError); err != nil {
+		slog.Error("Directory download failed", "path", dirPath, "error", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		slog.Error("Directory download failed", "path", dirPath, "error", err)
+	}
+}
+
 // serverFilesDeleteHandler handles DELETE /api/server-files/delete
 func serverFilesDeleteHandler(c *gin.Context) {
 	category := c.Query("category")
@@ -379,7 +575,7 @@ func serverFilesDeleteHandler(c *gin.Context) {
 		return
 	}
 
-	baseDir := filepath.Join(serverConfig.DataDir, category)
+	baseDir := filepath.Join(getServerConfig().DataDir, category)
 	absBaseDir, _ := filepath.Abs(baseDir)
 	if targetPath == absBaseDir {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot delete root category directory"})
@@ -396,6 +592,14 @@ func serverFilesDeleteHandler(c *gin.Context) {
 		return
 	}
 
+	if getServerConfig().DestructiveConfirmThreshold > 0 {
+		impact, _ := countPathFiles(targetPath)
+		if confirmErr := checkConfirmToken(confirmOpDelete, deleteConfirmScope(category, subPath), impact, c.Query("confirmToken")); confirmErr != nil {
+			respondConfirmationError(c, impact, confirmErr)
+			return
+		}
+	}
+
 	// Never recurse into symlink targets; remove the symlink itself only.
 	if info.Mode()&os.ModeSymlink != 0 {
 		err = os.Remove(targetPath)
@@ -410,7 +614,7 @@ func serverFilesDeleteHandler(c *gin.Context) {
 		return
 	}
 
-	debugLogf("🗑️ Deleted: %s/%s", category, subPath)
+	logDebug("File deleted", "category", category, "path", subPath)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":  true,
@@ -461,7 +665,7 @@ func serverFilesCreateHandler(c *gin.Context) {
 
 	targetPath := filepath.Join(targetDir, req.Name)
 
-	baseDir := filepath.Join(serverConfig.DataDir, req.Category)
+	baseDir := filepath.Join(getServerConfig().DataDir, req.Category)
 	absBaseDir, err := filepath.Abs(baseDir)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve base path"})
@@ -487,7 +691,7 @@ func serverFilesCreateHandler(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create directory"})
 			return
 		}
-		debugLogf("📁 Created directory: %s/%s/%s", req.Category, req.Path, req.Name)
+		logDebug("Directory created", "category", req.Category, "path", req.Path, "name", req.Name)
 	} else {
 		file, err := os.Create(targetPath)
 		if err != nil {
@@ -502,7 +706,7 @@ func serverFilesCreateHandler(c *gin.Context) {
 				return
 			}
 		}
-		debugLogf("📄 Created file: %s/%s/%s", req.Category, req.Path, req.Name)
+		logDebug("File created", "category", req.Category, "path", req.Path, "name", req.Name)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -555,7 +759,7 @@ func serverFilesRenameHandler(c *gin.Context) {
 		return
 	}
 
-	debugLogf("📝 Renamed: %s/%s -> %s", req.Category, req.OldName, req.NewName)
+	logDebug("File renamed", "category", req.Category, "old_name", req.OldName, "new_name", req.NewName)
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -602,19 +806,45 @@ func serverFilesReadHandler(c *gin.Context) {
 		return
 	}
 
+	mimeType, isText := detectTextContent(content)
+	if !isText {
+		// Binary content would be mangled by a JSON string, so send it as base64
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"content":  base64.StdEncoding.EncodeToString(content),
+			"encoding": "base64",
+			"binary":   true,
+			"mimeType": mimeType,
+			"size":     info.Size(),
+			"modTime":  fileModTimeMillis(info),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"content": string(content),
-		"size":    info.Size(),
+		"success":  true,
+		"content":  string(content),
+		"binary":   false,
+		"mimeType": mimeType,
+		"size":     info.Size(),
+		"modTime":  fileModTimeMillis(info),
 	})
 }
 
+// detectTextContent sniffs content with http.DetectContentType and reports its MIME type
+// and whether it is text: valid UTF-8 that sniffs as text/*.
+func detectTextContent(content []byte) (string, bool) {
+	mimeType := http.DetectContentType(content)
+	return mimeType, strings.HasPrefix(mimeType, "text/") && utf8.Valid(content)
+}
+
 // serverFilesSaveHandler handles POST /api/server-files/save
 func serverFilesSaveHandler(c *gin.Context) {
 	var req struct {
-		Category string `json:"category"`
-		Path     string `json:"path"`
-		Content  string `json:"content"`
+		Category        string `json:"category"`
+		Path            string `json:"path"`
+		Content         string `json:"content"`
+		ExpectedModTime *int64 `json:"expectedModTime"` // modTime from the read; omit to write unconditionally
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -648,17 +878,48 @@ func serverFilesSaveHandler(c *gin.Context) {
 		return
 	}
 
+	fileSaveMu.Lock()
+	defer fileSaveMu.Unlock()
+
+	if req.ExpectedModTime != nil {
+		// Re-stat under the lock so two saves carrying the same modTime cannot both pass
+		if info, err = os.Stat(targetPath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if modTime := fileModTimeMillis(info); modTime > *req.ExpectedModTime {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "file was modified after it was read",
+				"modTime": modTime,
+			})
+			return
+		}
+	}
+
 	if err := os.WriteFile(targetPath, []byte(req.Content), 0644); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
 		return
 	}
 
-	debugLogf("💾 Saved file: %s/%s", req.Category, req.Path)
+	logDebug("File saved", "category", req.Category, "path", req.Path)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"path":    req.Path,
-	})
+	}
+	if info, err := os.Stat(targetPath); err == nil {
+		response["modTime"] = fileModTimeMillis(info)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// fileSaveMu serializes the conflict check and write of serverFilesSaveHandler.
+var fileSaveMu sync.Mutex
+
+// fileModTimeMillis is the modTime returned by the read/save handlers and compared with
+// expectedModTime: Unix milliseconds, which stay exact as JavaScript numbers.
+func fileModTimeMillis(info os.FileInfo) int64 {
+	return info.ModTime().UnixMilli()
 }
 
 // serverFilesOpenLocalHandler handles POST /api/server-files/open-local
@@ -710,22 +971,45 @@ func copySymlink(src, dst string) error {
 }
 
 func copyPathPreserveSymlink(src, dst string) error {
+	return copyPathPreserveSymlinkWithProgress(src, dst, nil)
+}
+
+// copyPathPreserveSymlinkWithProgress is copyPathPreserveSymlink reporting each copied
+// entry (file or symlink) and its size through onEntry (may be nil).
+func copyPathPreserveSymlinkWithProgress(src, dst string, onEntry func(bytes int64)) error {
 	srcInfo, err := os.Lstat(src)
 	if err != nil {
 		return err
 	}
 
 	if srcInfo.Mode()&os.ModeSymlink != 0 {
-		return copySymlink(src, dst)
+		if err := copySymlink(src, dst); err != nil {
+			return err
+		}
+		if onEntry != nil {
+			onEntry(0)
+		}
+		return nil
 	}
 	if srcInfo.IsDir() {
-		return copyDirRecursive(src, dst)
+		return copyDirRecursiveWithProgress(src, dst, onEntry)
 	}
-	return copyFile(src, dst)
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	if onEntry != nil {
+		onEntry(srcInfo.Size())
+	}
+	return nil
 }
 
 // copyDirRecursive recursively copies a directory while preserving symlink entries.
 func copyDirRecursive(src, dst string) error {
+	return copyDirRecursiveWithProgress(src, dst, nil)
+}
+
+// copyDirRecursiveWithProgress is copyDirRecursive reporting each copied entry through onEntry.
+func copyDirRecursiveWithProgress(src, dst string, onEntry func(bytes int64)) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -753,14 +1037,20 @@ func copyDirRecursive(src, dst string) error {
 			if err := copySymlink(srcPath, dstPath); err != nil {
 				return err
 			}
+			if onEntry != nil {
+				onEntry(0)
+			}
 		} else if entry.IsDir() {
-			if err := copyDirRecursive(srcPath, dstPath); err != nil {
+			if err := copyDirRecursiveWithProgress(srcPath, dstPath, onEntry); err != nil {
 				return err
 			}
 		} else {
 			if err := copyFile(srcPath, dstPath); err != nil {
 				return err
 			}
+			if onEntry != nil {
+				onEntry(entryInfo.Size())
+			}
 		}
 	}
 
@@ -802,6 +1092,9 @@ func serverFilesBatchCopyHandler(c *gin.Context) {
 		Items       []string `json:"items"`       // Items to copy (relative paths in source)
 		SrcPath     string   `json:"srcPath"`     // Source directory
 		DstPath     string   `json:"dstPath"`     // Destination directory
+		Async       bool     `json:"async"`       // Run as a background job reporting file/batch-progress
+		DryRun      bool     `json:"dryRun"`      // Validate and report the planned operations without copying
+		OnConflict  string   `json:"onConflict"`  // Existing destination: "skip" (default), "overwrite" or "merge"
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -813,6 +1106,11 @@ func serverFilesBatchCopyHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no items to copy"})
 		return
 	}
+	onConflict, err := parseBatchConflictPolicy(req.OnConflict)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Support both old (category) and new (srcCategory/dstCategory) API
 	srcCategory := req.SrcCategory
@@ -836,102 +1134,78 @@ func serverFilesBatchCopyHandler(c *gin.Context) {
 		return
 	}
 
+	// A dry run only validates; the destination directory is not created
+	if req.DryRun {
+		paths, err := newBatchPaths(srcCategory, dstCategory, srcDir, dstDir)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		paths.onConflict = onConflict
+		respondBatchDryRun(c, batchJobOpCopy, paths, req.Items)
+		return
+	}
+
 	// Ensure destination directory exists
 	if err := os.MkdirAll(dstDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create destination directory"})
 		return
 	}
 
-	srcBaseDir := filepath.Join(serverConfig.DataDir, srcCategory)
-	absSrcBaseDir, err := filepath.Abs(srcBaseDir)
+	paths, err := newBatchPaths(srcCategory, dstCategory, srcDir, dstDir)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve source base path"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	dstBaseDir := filepath.Join(serverConfig.DataDir, dstCategory)
-	absDstBaseDir, err := filepath.Abs(dstBaseDir)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve destination base path"})
+	paths.onConflict = onConflict
+
+	if req.Async {
+		job := startBatchJob(batchJobOpCopy, req.Items, func(item string, onEntry func(int64)) error {
+			_, err := paths.copyItem(item, onEntry)
+			return err
+		})
+		c.JSON(http.StatusOK, gin.H{"success": true, "async": true, "jobId": job.ID, "totalCount": len(req.Items)})
 		return
 	}
 
 	successCount := 0
 	var errors []string
+	results := make([]batchItemResult, 0, len(req.Items))
 
 	for _, item := range req.Items {
-		cleanItem, cleanErr := sanitizeRelativeItemPath(item)
-		if cleanErr != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", item, cleanErr))
-			continue
-		}
-		srcPath := filepath.Join(srcDir, cleanItem)
-		dstPath := filepath.Join(dstDir, cleanItem)
-
-		// Validate source path doesn't escape
-		absSrcPath, err := filepath.Abs(srcPath)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: failed to resolve source path", item))
-			continue
-		}
-		if !isPathWithinAbsBase(absSrcBaseDir, absSrcPath) {
-			errors = append(errors, fmt.Sprintf("%s: source path traversal detected", item))
-			continue
-		}
-
-		// Validate destination path doesn't escape
-		absDstPath, err := filepath.Abs(dstPath)
+		action, err := paths.copyItem(item, nil)
+		results = append(results, newBatchItemResult(item, action, err))
 		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: failed to resolve destination path", item))
-			continue
-		}
-		if !isPathWithinAbsBase(absDstBaseDir, absDstPath) {
-			errors = append(errors, fmt.Sprintf("%s: destination path traversal detected", item))
-			continue
-		}
-
-		_, err = os.Lstat(srcPath)
-		if os.IsNotExist(err) {
-			errors = append(errors, fmt.Sprintf("%s: not found", item))
-			continue
-		}
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", item, err))
-			continue
-		}
-
-		// Check if destination already exists
-		if _, err := os.Lstat(dstPath); !os.IsNotExist(err) {
-			errors = append(errors, fmt.Sprintf("%s: already exists at destination", item))
-			continue
-		}
-
-		if err := copyPathPreserveSymlink(srcPath, dstPath); err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", item, err))
 			continue
 		}
-
 		successCount++
 	}
 
-	debugLogf("📋 Batch copy: %d/%d items copied from %s/%s to %s/%s", successCount, len(req.Items), srcCategory, req.SrcPath, dstCategory, req.DstPath)
+	logDebug("Batch copy finished", "copied", successCount, "total", len(req.Items), "src_category", srcCategory, "src_path", req.SrcPath, "dst_category", dstCategory, "dst_path", req.DstPath)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":      successCount == len(req.Items),
 		"successCount": successCount,
 		"totalCount":   len(req.Items),
 		"errors":       errors,
+		"results":      results,
 	})
 }
 
 // serverFilesBatchMoveHandler handles POST /api/server-files/batch-move
 func serverFilesBatchMoveHandler(c *gin.Context) {
 	var req struct {
-		Category    string   `json:"category"`    // Deprecated: for backwards compatibility
-		SrcCategory string   `json:"srcCategory"` // Source category (scripts/files/reports)
-		DstCategory string   `json:"dstCategory"` // Destination category
-		Items       []string `json:"items"`       // Items to move (relative paths in source)
-		SrcPath     string   `json:"srcPath"`     // Source directory
-		DstPath     string   `json:"dstPath"`     // Destination directory
+		Category     string   `json:"category"`     // Deprecated: for backwards compatibility
+		SrcCategory  string   `json:"srcCategory"`  // Source category (scripts/files/reports)
+		DstCategory  string   `json:"dstCategory"`  // Destination category
+		Items        []string `json:"items"`        // Items to move (relative paths in source)
+		SrcPath      string   `json:"srcPath"`      // Source directory
+		DstPath      string   `json:"dstPath"`      // Destination directory
+		ConfirmToken string   `json:"confirmToken"` // Token from /api/confirm/impact when confirmation is enforced
+		Async        bool     `json:"async"`        // Run as a background job reporting file/batch-progress
+		DryRun       bool     `json:"dryRun"`       // Validate and report the planned operations without moving
+		OnConflict   string   `json:"onConflict"`   // Existing destination: "skip" (default), "overwrite" or "merge"
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -943,6 +1217,11 @@ func serverFilesBatchMoveHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no items to move"})
 		return
 	}
+	onConflict, err := parseBatchConflictPolicy(req.OnConflict)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Support both old (category) and new (srcCategory/dstCategory) API
 	srcCategory := req.SrcCategory
@@ -966,119 +1245,69 @@ func serverFilesBatchMoveHandler(c *gin.Context) {
 		return
 	}
 
+	// A dry run changes nothing, so it needs no confirmation token and creates no directories
+	if req.DryRun {
+		paths, err := newBatchPaths(srcCategory, dstCategory, srcDir, dstDir)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		paths.onConflict = onConflict
+		respondBatchDryRun(c, batchJobOpMove, paths, req.Items)
+		return
+	}
+
+	if getServerConfig().DestructiveConfirmThreshold > 0 {
+		impact := batchMoveImpact(srcDir, req.Items)
+		if confirmErr := checkConfirmToken(confirmOpBatchMove, batchMoveConfirmScope(srcCategory, req.SrcPath, req.Items), impact, req.ConfirmToken); confirmErr != nil {
+			respondConfirmationError(c, impact, confirmErr)
+			return
+		}
+	}
+
 	// Ensure destination directory exists
 	if err := os.MkdirAll(dstDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create destination directory"})
 		return
 	}
 
-	srcBaseDir := filepath.Join(serverConfig.DataDir, srcCategory)
-	absSrcBaseDir, err := filepath.Abs(srcBaseDir)
+	paths, err := newBatchPaths(srcCategory, dstCategory, srcDir, dstDir)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve source base path"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	dstBaseDir := filepath.Join(serverConfig.DataDir, dstCategory)
-	absDstBaseDir, err := filepath.Abs(dstBaseDir)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve destination base path"})
+	paths.onConflict = onConflict
+
+	if req.Async {
+		job := startBatchJob(batchJobOpMove, req.Items, func(item string, onEntry func(int64)) error {
+			_, err := paths.moveItem(item, onEntry)
+			return err
+		})
+		c.JSON(http.StatusOK, gin.H{"success": true, "async": true, "jobId": job.ID, "totalCount": len(req.Items)})
 		return
 	}
 
 	successCount := 0
 	var errors []string
+	results := make([]batchItemResult, 0, len(req.Items))
 
 	for _, item := range req.Items {
-		cleanItem, cleanErr := sanitizeRelativeItemPath(item)
-		if cleanErr != nil {
-			errors = append(errors, fmt.Sprintf("%s: %v", item, cleanErr))
-			continue
-		}
-		srcPath := filepath.Join(srcDir, cleanItem)
-		dstPath := filepath.Join(dstDir, cleanItem)
-
-		// Validate source path doesn't escape
-		absSrcPath, err := filepath.Abs(srcPath)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: failed to resolve source path", item))
-			continue
-		}
-		if !isPathWithinAbsBase(absSrcBaseDir, absSrcPath) {
-			errors = append(errors, fmt.Sprintf("%s: source path traversal detected", item))
-			continue
-		}
-
-		// Validate destination path doesn't escape
-		absDstPath, err := filepath.Abs(dstPath)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("%s: failed to resolve destination path", item))
-			continue
-		}
-		if !isPathWithinAbsBase(absDstBaseDir, absDstPath) {
-			errors = append(errors, fmt.Sprintf("%s: destination path traversal detected", item))
-			continue
-		}
-
-		_, err = os.Lstat(srcPath)
-		if os.IsNotExist(err) {
-			errors = append(errors, fmt.Sprintf("%s: not found", item))
-			continue
-		}
+		action, err := paths.moveItem(item, nil)
+		results = append(results, newBatchItemResult(item, action, err))
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", item, err))
 			continue
 		}
-
-		// Check if destination already exists
-		if _, err := os.Lstat(dstPath); !os.IsNotExist(err) {
-			errors = append(errors, fmt.Sprintf("%s: already exists at destination", item))
-			continue
-		}
-
-		// Move the file/directory (use copy+delete for cross-filesystem moves)
-		if err := os.Rename(srcPath, dstPath); err != nil {
-			// os.Rename may fail across filesystems, so try copy+delete while preserving symlinks.
-			srcInfo, statErr := os.Lstat(srcPath)
-			if os.IsNotExist(statErr) {
-				errors = append(errors, fmt.Sprintf("%s: not found", item))
-				continue
-			}
-			if statErr != nil {
-				errors = append(errors, fmt.Sprintf("%s: %v", item, err))
-				continue
-			}
-			if copyErr := copyPathPreserveSymlink(srcPath, dstPath); copyErr != nil {
-				errors = append(errors, fmt.Sprintf("%s: %v", item, copyErr))
-				continue
-			}
-			// Remove source after successful copy.
-			if srcInfo.Mode()&os.ModeSymlink != 0 {
-				if removeErr := os.Remove(srcPath); removeErr != nil {
-					errors = append(errors, fmt.Sprintf("%s: failed to remove source symlink: %v", item, removeErr))
-					continue
-				}
-			} else if srcInfo.IsDir() {
-				if removeErr := os.RemoveAll(srcPath); removeErr != nil {
-					errors = append(errors, fmt.Sprintf("%s: failed to remove source directory: %v", item, removeErr))
-					continue
-				}
-			} else {
-				if removeErr := os.Remove(srcPath); removeErr != nil {
-					errors = append(errors, fmt.Sprintf("%s: failed to remove source file: %v", item, removeErr))
-					continue
-				}
-			}
-		}
-
 		successCount++
 	}
 
-	debugLogf("✂️ Batch move: %d/%d items moved from %s/%s to %s/%s", successCount, len(req.Items), srcCategory, req.SrcPath, dstCategory, req.DstPath)
+	logDebug("Batch move finished", "moved", successCount, "total", len(req.Items), "src_category", srcCategory, "src_path", req.SrcPath, "dst_category", dstCategory, "dst_path", req.DstPath)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":      successCount == len(req.Items),
 		"successCount": successCount,
 		"totalCount":   len(req.Items),
 		"errors":       errors,
+		"results":      results,
 	})
 }
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	batchJobOpCopy = "copy"
+	batchJobOpMove = "move"
+
+	batchJobStatusRunning   = "running"
+	batchJobStatusCompleted = "completed"
+
+	batchJobRetention        = 10 * time.Minute
+	batchJobProgressInterval = 200 * time.Millisecond
+
+	// onConflict policies for an item whose destination already exists
+	batchConflictSkip      = "skip"
+	batchConflictOverwrite = "overwrite"
+	batchConflictMerge     = "merge"
+)
+
+// errBatchDestinationExists reports a batch item whose destination is already taken.
+var errBatchDestinationExists = errors.New("already exists at destination")
+
+// batchPaths holds the validated source/destination directories of a batch copy or move.
+type batchPaths struct {
+	srcDir        string
+	dstDir        string
+	absSrcBaseDir string
+	absDstBaseDir string
+	onConflict    string
+}
+
+// parseBatchConflictPolicy validates the onConflict field of a batch copy/move.
+func parseBatchConflictPolicy(value string) (string, error) {
+	switch value {
+	case "", batchConflictSkip:
+		return batchConflictSkip, nil
+	case batchConflictOverwrite, batchConflictMerge:
+		return value, nil
+	}
+	return "", fmt.Errorf("onConflict must be skip, overwrite or merge")
+}
+
+func newBatchPaths(srcCategory, dstCategory, srcDir, dstDir string) (batchPaths, error) {
+	absSrcBaseDir, err := filepath.Abs(filepath.Join(getServerConfig().DataDir, srcCategory))
+	if err != nil {
+		return batchPaths{}, fmt.Errorf("failed to resolve source base path")
+	}
+	absDstBaseDir, err := filepath.Abs(filepath.Join(getServerConfig().DataDir, dstCategory))
+	if err != nil {
+		return batchPaths{}, fmt.Errorf("failed to resolve destination base path")
+	}
+	return batchPaths{
+		srcDir:        srcDir,
+		dstDir:        dstDir,
+		absSrcBaseDir: absSrcBaseDir,
+		absDstBaseDir: absDstBaseDir,
+	}, nil
+}
+
+// resolveItem validates a batch item and returns its source and destination paths.
+func (p batchPaths) resolveItem(item string) (string, string, error) {
+	cleanItem, err := sanitizeRelativeItemPath(item)
+	if err != nil {
+		return "", "", err
+	}
+	srcPath := filepath.Join(p.srcDir, cleanItem)
+	dstPath := filepath.Join(p.dstDir, cleanItem)
+
+	// Validate source path doesn't escape
+	absSrcPath, err := filepath.Abs(srcPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve source path")
+	}
+	if !isPathWithinAbsBase(p.absSrcBaseDir, absSrcPath) {
+		return "", "", fmt.Errorf("source path traversal detected")
+	}
+
+	// Validate destination path doesn't escape
+	absDstPath, err := filepath.Abs(dstPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve destination path")
+	}
+	if !isPathWithinAbsBase(p.absDstBaseDir, absDstPath) {
+		return "", "", fmt.Errorf("destination path traversal detected")
+	}
+	// Copying or moving an item onto itself or into its own subtree would let
+	// overwrite/merge delete the source before it is read
+	if isPathWithinAbsBase(absSrcPath, absDstPath) {
+		return "", "", fmt.Errorf("destination is the source or inside it")
+	}
+
+	_, err = os.Lstat(srcPath)
+	if os.IsNotExist(err) {
+		return "", "", fmt.Errorf("not found")
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// Check if destination already exists; the paths are still returned so the
+	// onConflict policy can act on them
+	if _, err := os.Lstat(dstPath); !os.IsNotExist(err) {
+		return srcPath, dstPath, errBatchDestinationExists
+	}
+	return srcPath, dstPath, nil
+}
+
+// resolveItemAction resolves a batch item and decides what op does with it: op itself,
+// "overwrite" or "merge" for an existing destination allowed by onConflict, or
+// "conflict"/"skip" together with the error that stops the item.
+func (p batchPaths) resolveItemAction(op, item string) (string, string, string, error) {
+	srcPath, dstPath, err := p.resolveItem(item)
+	if errors.Is(err, errBatchDestinationExists) {
+		if action := p.conflictAction(srcPath, dstPath); action != "" {
+			return srcPath, dstPath, action, nil
+		}
+		return "", "", "conflict", err
+	}
+	if err != nil {
+		return "", "", "skip", err
+	}
+	return srcPath, dstPath, op, nil
+}
+
+// conflictAction returns how onConflict handles an existing dstPath: "merge" only when
+// both sides are real directories, otherwise "overwrite"; "" keeps the conflict.
+func (p batchPaths) conflictAction(srcPath, dstPath string) string {
+	switch p.onConflict {
+	case batchConflictOverwrite:
+		return batchConflictOverwrite
+	case batchConflictMerge:
+		srcInfo, srcErr := os.Lstat(srcPath)
+		dstInfo, dstErr := os.Lstat(dstPath)
+		if srcErr == nil && dstErr == nil && srcInfo.IsDir() && dstInfo.IsDir() {
+			return batchConflictMerge
+		}
+		return batchConflictOverwrite
+	}
+	return ""
+}
+
+// removeBatchDestination removes an existing destination before it is overwritten. It
+// uses Lstat so a symlink is removed itself and its target is never followed.
+func removeBatchDestination(dstPath string) error {
+	info, err := os.Lstat(dstPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = os.RemoveAll(dstPath)
+	} else {
+		err = os.Remove(dstPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove destination: %v", err)
+	}
+	return nil
+}
+
+// mergeDirWithProgress copies src into the existing directory dst: subdirectories present
+// on both sides are merged, any other existing entry is replaced.
+func mergeDirWithProgress(src, dst string, onEntry func(bytes int64)) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+		srcInfo, err := os.Lstat(srcPath)
+		if err != nil {
+			return err
+		}
+		if dstInfo, err := os.Lstat(dstPath); err == nil {
+			if srcInfo.IsDir() && dstInfo.IsDir() {
+				if err := mergeDirWithProgress(srcPath, dstPath, onEntry); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := removeBatchDestination(dstPath); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := copyPathPreserveSymlinkWithProgress(srcPath, dstPath, onEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyItem copies one batch item, reporting copied entries through onEntry (may be nil).
+// It returns the action taken (see resolveItemAction).
+func (p batchPaths) copyItem(item string, onEntry func(bytes int64)) (string, error) {
+	srcPath, dstPath, action, err := p.resolveItemAction(batchJobOpCopy, item)
+	if err != nil {
+		return action, err
+	}
+	switch action {
+	case batchConflictMerge:
+		return action, mergeDirWithProgress(srcPath, dstPath, onEntry)
+	case batchConflictOverwrite:
+		if err := removeBatchDestination(dstPath); err != nil {
+			return action, err
+		}
+	}
+	return action, copyPathPreserveSymlinkWithProgress(srcPath, dstPath, onEntry)
+}
+
+// moveItem moves one batch item and returns the action taken (see resolveItemAction).
+func (p batchPaths) moveItem(item string, onEntry func(bytes int64)) (string, error) {
+	srcPath, dstPath, action, err := p.resolveItemAction(batchJobOpMove, item)
+	if err != nil {
+		return action, err
+	}
+	switch action {
+	case batchConflictMerge:
+		if err := mergeDirWithProgress(srcPath, dstPath, onEntry); err != nil {
+			return action, err
+		}
+		if err := os.RemoveAll(srcPath); err != nil {
+			return action, fmt.Errorf("failed to remove source directory: %v", err)
+		}
+		return action, nil
+	case batchConflictOverwrite:
+		if err := removeBatchDestination(dstPath); err != nil {
+			return action, err
+		}
+	}
+	return action, movePathWithProgress(srcPath, dstPath, onEntry)
+}
+
+// movePathWithProgress renames srcPath to dstPath, falling back to copy+delete across
+// filesystems.
+func movePathWithProgress(srcPath, dstPath string, onEntry func(bytes int64)) error {
+	renameErr := os.Rename(srcPath, dstPath)
+	if renameErr == nil {
+		return nil
+	}
+
+	// os.Rename may fail across filesystems, so try copy+delete while preserving symlinks.
+	srcInfo, statErr := os.Lstat(srcPath)
+	if os.IsNotExist(statErr) {
+		return fmt.Errorf("not found")
+	}
+	if statErr != nil {
+		return renameErr
+	}
+	if err := copyPathPreserveSymlinkWithProgress(srcPath, dstPath, onEntry); err != nil {
+		return err
+	}
+	// Remove source after successful copy.
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(srcPath); err != nil {
+			return fmt.Errorf("failed to remove source symlink: %v", err)
+		}
+	} else if srcInfo.IsDir() {
+		if err := os.RemoveAll(srcPath); err != nil {
+			return fmt.Errorf("failed to remove source directory: %v", err)
+		}
+	} else {
+		if err := os.Remove(srcPath); err != nil {
+			return fmt.Errorf("failed to remove source file: %v", err)
+		}
+	}
+	return nil
+}
+
+// batchItemResult is the action taken (or, in a dry run, planned) for one batch item.
+type batchItemResult struct {
+	Item   string `json:"item"`
+	Action string `json:"action"` // "copy", "move", "overwrite" or "merge"; "conflict" or "skip" when not run
+	IsDir  bool   `json:"isDir,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newBatchItemResult(item, action string, err error) batchItemResult {
+	result := batchItemResult{Item: item, Action: action}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// planItems runs the same per-item validation as copyItem/moveItem without touching the
+// filesystem. An item reaching a destination claimed earlier in the batch is planned as
+// if that destination already existed, as it would when run in order.
+func (p batchPaths) planItems(op string, items []string) ([]batchItemResult, int, []string) {
+	operations := make([]batchItemResult, 0, len(items))
+	claimed := make(map[string]bool, len(items))
+	successCount := 0
+	var errs []string
+	for _, item := range items {
+		srcPath, dstPath, action, err := p.resolveItemAction(op, item)
+		var srcInfo os.FileInfo
+		if err == nil {
+			srcInfo, err = os.Lstat(srcPath)
+		}
+		if err == nil && claimed[dstPath] {
+			switch {
+			case op == batchJobOpMove:
+				// The earlier item has already moved the source away, so the real
+				// run reports "not found" for this one
+				action, err = "skip", fmt.Errorf("not found")
+			case p.onConflict == batchConflictSkip:
+				action, err = "conflict", errBatchDestinationExists
+			case p.onConflict == batchConflictMerge && srcInfo.IsDir():
+				action = batchConflictMerge
+			default:
+				action = batchConflictOverwrite
+			}
+		}
+		planned := newBatchItemResult(item, action, err)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", item, err))
+			operations = append(operations, planned)
+			continue
+		}
+		claimed[dstPath] = true
+		planned.IsDir = srcInfo.IsDir()
+		if srcInfo.Mode().IsRegular() {
+			planned.Size = srcInfo.Size()
+		}
+		successCount++
+		operations = append(operations, planned)
+	}
+	return operations, successCount, errs
+}
+
+// respondBatchDryRun answers a batch copy/move with dryRun set, in the same shape as a
+// real run plus the planned operations.
+func respondBatchDryRun(c *gin.Context, op string, paths batchPaths, items []string) {
+	operations, successCount, errs := paths.planItems(op, items)
+	c.JSON(http.StatusOK, gin.H{
+		"success":      successCount == len(items),
+		"dryRun":       true,
+		"successCount": successCount,
+		"totalCount":   len(items),
+		"errors":       errs,
+		"operations":   operations,
+	})
+}
+
+// batchJob tracks an asynchronous batch copy/move.
+type batchJob struct {
+	ID           string   `json:"jobId"`
+	Op           string   `json:"op"`
+	Status       string   `json:"status"`
+	TotalCount   int      `json:"totalCount"`
+	DoneCount    int      `json:"doneCount"`
+	SuccessCount int      `json:"successCount"`
+	CurrentItem  string   `json:"currentItem,omitempty"`
+	FilesCopied  int      `json:"filesCopied"`
+	BytesCopied  int64    `json:"bytesCopied"`
+	Errors       []string `json:"errors"`
+	StartedAt    int64    `json:"startedAt"`
+	FinishedAt   int64    `json:"finishedAt,omitempty"`
+}
+
+var batchJobs = struct {
+	sync.Mutex
+	jobs map[string]*batchJob
+}{
+	jobs: make(map[string]*batchJob),
+}
+
+// snapshotBatchJobLocked returns a copy of job safe to marshal. Caller must hold batchJobs.
+func snapshotBatchJobLocked(job *batchJob) batchJob {
+	snapshot := *job
+	snapshot.Errors = append([]string(nil), job.Errors...)
+	return snapshot
+}
+
+func getBatchJob(id string) (batchJob, bool) {
+	batchJobs.Lock()
+	defer batchJobs.Unlock()
+	job, ok := batchJobs.jobs[id]
+	if !ok {
+		return batchJob{}, false
+	}
+	return snapshotBatchJobLocked(job), true
+}
+
+// startBatchJob runs process for each item in the background, broadcasting
+// file/batch-progress to controllers as items and copied entries complete.
+func startBatchJob(op string, items []string, process func(item string, onEntry func(bytes int64)) error) batchJob {
+	now := time.Now()
+	job := &batchJob{
+		ID:         uuid.New().String(),
+		Op:         op,
+		Status:     batchJobStatusRunning,
+		TotalCount: len(items),
+		Errors:     []string{},
+		StartedAt:  now.Unix(),
+	}
+
+	batchJobs.Lock()
+	for id, existing := range batchJobs.jobs {
+		if existing.FinishedAt > 0 && now.Sub(time.Unix(existing.FinishedAt, 0)) > batchJobRetention {
+			delete(batchJobs.jobs, id)
+		}
+	}
+	batchJobs.jobs[job.ID] = job
+	snapshot := snapshotBatchJobLocked(job)
+	batchJobs.Unlock()
+
+	go runBatchJob(job, append([]string(nil), items...), process)
+	return snapshot
+}
+
+func runBatchJob(job *batchJob, items []string, process func(item string, onEntry func(bytes int64)) error) {
+	var lastBroadcast time.Time
+	publish := func(force bool) {
+		batchJobs.Lock()
+		if !force && time.Since(lastBroadcast) < batchJobProgressInterval {
+			batchJobs.Unlock()
+			return
+		}
+		lastBroadcast = time.Now()
+		snapshot := snapshotBatchJobLocked(job)
+		batchJobs.Unlock()
+		broadcastControllerEvent("file/batch-progress", snapshot)
+	}
+
+	onEntry := func(bytes int64) {
+		batchJobs.Lock()
+		job.FilesCopied++
+		job.BytesCopied += bytes
+		batchJobs.Unlock()
+		publish(false)
+	}
+
+	for _, item := range items {
+		batchJobs.Lock()
+		job.CurrentItem = item
+		batchJobs.Unlock()
+
+		err := process(item, onEntry)
+
+		batchJobs.Lock()
+		job.DoneCount++
+		if err != nil {
+			job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", item, err))
+		} else {
+			job.SuccessCount++
+		}
+		batchJobs.Unlock()
+		publish(true)
+	}
+
+	batchJobs.Lock()
+	job.Status = batchJobStatusCompleted
+	job.CurrentItem = ""
+	job.FinishedAt = time.Now().Unix()
+	batchJobs.Unlock()
+	publish(true)
+
+	logDebug("Batch file job finished", "op", job.Op, "job_id", job.ID, "succeeded", job.SuccessCount, "total", job.TotalCount)
+}
+
+// serverFilesBatchJobHandler handles GET /api/server-files/batch/:job
+func serverFilesBatchJobHandler(c *gin.Context) {
+	job, ok := getBatchJob(c.Param("job"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
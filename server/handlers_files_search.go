@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	fileSearchDefaultLimit = 100
+	fileSearchMaxLimit     = 1000
+	fileSearchPreviewRunes = 120
+)
+
+// fileSearchResult is one match of GET /api/server-files/search.
+type fileSearchResult struct {
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Match   string `json:"match"`             // "name" or "content"
+	Line    int    `json:"line,omitempty"`    // first matching line (content matches)
+	Preview string `json:"preview,omitempty"` // that line, trimmed
+}
+
+// normalizeSearchExt turns "lua" or ".LUA" into ".lua".
+func normalizeSearchExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// searchFileContent looks for the lower-cased query in a text file no larger than
+// MaxFileSize and returns the first matching line. Binary files never match.
+func searchFileContent(path string, info os.FileInfo, query string) (int, string, bool) {
+	if info.Size() == 0 || info.Size() > MaxFileSize {
+		return 0, "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", false
+	}
+	if _, isText := detectTextContent(data); !isText {
+		return 0, "", false
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(strings.ToLower(line), query) {
+			preview := strings.TrimSpace(line)
+			if runes := []rune(preview); len(runes) > fileSearchPreviewRunes {
+				preview = string(runes[:fileSearchPreviewRunes])
+			}
+			return i + 1, preview, true
+		}
+	}
+	return 0, "", false
+}
+
+// serverFilesSearchHandler handles GET /api/server-files/search?category=&q=&ext=&limit=&maxDepth=&content=
+// Walks the category recursively and returns files whose name contains q
+// (case-insensitive), optionally restricted to one extension. With content=1 small text
+// files are also searched for q.
+func serverFilesSearchHandler(c *gin.Context) {
+	category := c.DefaultQuery("category", "scripts")
+	if !isValidCategory(category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category: " + category})
+		return
+	}
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	ext := normalizeSearchExt(c.Query("ext"))
+	if query == "" && ext == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q or ext is required"})
+		return
+	}
+
+	limit := fileSearchDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(parsed, fileSearchMaxLimit)
+	}
+	maxDepth := 0
+	if value := c.Query("maxDepth"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "maxDepth must be a non-negative integer"})
+			return
+		}
+		maxDepth = parsed
+	}
+	searchContent := query != "" && (c.Query("content") == "1" || c.Query("content") == "true")
+
+	results := []fileSearchResult{}
+	truncated := false
+	err := walkCategoryFiles(category, maxDepth, func(path, relPath string, info os.FileInfo) error {
+		name := filepath.Base(relPath)
+		if ext != "" && strings.ToLower(filepath.Ext(name)) != ext {
+			return nil
+		}
+		result := fileSearchResult{
+			Path:    filepath.ToSlash(relPath),
+			Name:    name,
+			Size:    info.Size(),
+			ModTime: info.ModTime().Unix(),
+		}
+		switch {
+		case query == "" || strings.Contains(strings.ToLower(name), query):
+			result.Match = "name"
+		case searchContent:
+			line, preview, ok := searchFileContent(path, info, query)
+			if !ok {
+				return nil
+			}
+			result.Match, result.Line, result.Preview = "content", line, preview
+		default:
+			return nil
+		}
+		if len(results) >= limit {
+			truncated = true
+			return filepath.SkipAll
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search files"})
+		return
+	}
+
+	// Name matches first, then by path
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Match != results[j].Match {
+			return results[i].Match == "name"
+		}
+		return results[i].Path < results[j].Path
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"category":  category,
+		"results":   results,
+		"truncated": truncated,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerFilesSearchHandler_MatchesNamesAndContent(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	write := func(rel, content string) {
+		path := filepath.Join(dataDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	write("scripts/Login.lua", "-- entry")
+	write("scripts/game/deep/auto_login.lua", "return 1")
+	write("scripts/game/login.txt", "notes")
+	write("scripts/game/main.lua", "local ok = doLogin()\nprint(ok)")
+	write("scripts/game/blob.lua", "login\x00binary")
+
+	outside := filepath.Join(t.TempDir(), "login.lua")
+	if err := os.WriteFile(outside, []byte("x"), 0o644); err != nil {
+		t.Fatalf("write outside file failed: %v", err)
+	}
+	createSymlinkOrSkip(t, filepath.Dir(outside), filepath.Join(dataDir, "scripts", "escape-dir"))
+
+	search := func(query string) (int, []fileSearchResult, bool) {
+		t.Helper()
+		w := performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/search?"+query, nil, serverFilesSearchHandler)
+		var resp struct {
+			Results   []fileSearchResult `json:"results"`
+			Truncated bool               `json:"truncated"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Results, resp.Truncated
+	}
+	paths := func(results []fileSearchResult) []string {
+		out := make([]string, len(results))
+		for i, result := range results {
+			out[i] = result.Path
+		}
+		return out
+	}
+
+	code, results, _ := search("category=scripts&q=login&ext=lua")
+	if code != http.StatusOK || len(results) != 2 || results[0].Path != "Login.lua" || results[1].Path != "game/deep/auto_login.lua" {
+		t.Fatalf("unexpected name matches (%d): %v", code, paths(results))
+	}
+
+	_, results, _ = search("category=scripts&q=login&ext=.lua&maxDepth=2")
+	if len(results) != 1 || results[0].Path != "Login.lua" {
+		t.Fatalf("maxDepth=2 should not enter game/deep, got %v", paths(results))
+	}
+
+	_, results, _ = search("category=scripts&q=login&ext=.lua&content=1")
+	if len(results) != 3 {
+		t.Fatalf("expected two name matches and one content match, got %v", paths(results))
+	}
+	if got := results[2]; got.Path != "game/main.lua" || got.Match != "content" || got.Line != 1 || got.Preview != "local ok = doLogin()" {
+		t.Fatalf("unexpected content match: %+v", got)
+	}
+
+	_, results, truncated := search("category=scripts&q=login&limit=1")
+	if len(results) != 1 || !truncated {
+		t.Fatalf("expected a truncated single result, got %v truncated=%v", paths(results), truncated)
+	}
+
+	if code, _, _ := search("category=secret&q=login"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid category, got %d", code)
+	}
+	if code, _, _ := search("category=scripts"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without q or ext, got %d", code)
+	}
+}
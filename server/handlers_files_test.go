@@ -1,15 +1,21 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,9 +32,9 @@ func setupFileHandlersTestDataDir(t *testing.T) string {
 	gin.SetMode(gin.TestMode)
 
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	for _, category := range AllowedCategories {
 		if err := os.MkdirAll(filepath.Join(dataDir, category), 0o755); err != nil {
@@ -63,9 +69,9 @@ func performJSONHandlerRequest(t *testing.T, method, target string, payload any,
 func TestServerFilesListHandler_MetaParam(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	scriptsDir := filepath.Join(dataDir, "scripts")
 	if err := os.MkdirAll(scriptsDir, 0755); err != nil {
@@ -455,9 +461,9 @@ func TestServerFilesBatchMoveHandler_MovesDirectorySymlinkItself(t *testing.T) {
 func TestServerFilesBatchCopyHandler_CopiesFiles(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	srcDir := filepath.Join(dataDir, "scripts")
 	dstDir := filepath.Join(dataDir, "files")
@@ -507,9 +513,9 @@ func TestServerFilesBatchCopyHandler_CopiesFiles(t *testing.T) {
 func TestServerFilesBatchMoveHandler_MovesFiles(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	srcDir := filepath.Join(dataDir, "scripts")
 	dstDir := filepath.Join(dataDir, "files")
@@ -563,9 +569,9 @@ func TestServerFilesBatchMoveHandler_MovesFiles(t *testing.T) {
 func TestServerFilesCreateHandler_RejectsTraversalName(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	if err := os.MkdirAll(filepath.Join(dataDir, "scripts"), 0o755); err != nil {
 		t.Fatalf("mkdir scripts dir: %v", err)
@@ -602,9 +608,9 @@ func TestServerFilesCreateHandler_RejectsTraversalName(t *testing.T) {
 func TestServerFilesBatchCopyHandler_RejectsTraversalItem(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	if err := os.MkdirAll(filepath.Join(dataDir, "scripts"), 0o755); err != nil {
 		t.Fatalf("mkdir scripts dir: %v", err)
@@ -664,9 +670,9 @@ func TestServerFilesBatchCopyHandler_RejectsTraversalItem(t *testing.T) {
 func TestServerFilesBatchMoveHandler_RejectsTraversalItem(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	if err := os.MkdirAll(filepath.Join(dataDir, "scripts"), 0o755); err != nil {
 		t.Fatalf("mkdir scripts dir: %v", err)
@@ -1061,3 +1067,480 @@ func TestServerFilesCreateHandler_AllowsUnicodeAndSpaceName(t *testing.T) {
 		t.Fatalf("unexpected content: %q", resp.Content)
 	}
 }
+
+func TestServerFilesBatchCopyHandler_AsyncJobReportsProgress(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	controllerConn, client := newTestWebSocketPair(t)
+
+	mu.Lock()
+	controllersBackup := controllers
+	controllers = map[*SafeConn]bool{controllerConn: true}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		controllers = controllersBackup
+		mu.Unlock()
+	})
+
+	srcDir := filepath.Join(dataDir, "scripts", "tree")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir src tree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("12345"), 0o644); err != nil {
+		t.Fatalf("write a.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("678"), 0o644); err != nil {
+		t.Fatalf("write b.txt: %v", err)
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/server-files/batch-copy", map[string]any{
+		"srcCategory": "scripts",
+		"dstCategory": "files",
+		"items":       []string{"tree", "missing"},
+		"async":       true,
+	}, serverFilesBatchCopyHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d body=%s", w.Code, w.Body.String())
+	}
+	var started struct {
+		JobID string `json:"jobId"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || started.JobID == "" {
+		t.Fatalf("expected job id, got %s err=%v", w.Body.String(), err)
+	}
+
+	var final batchJob
+	for {
+		msg := readTestMessage(t, client)
+		if msg.Type != "file/batch-progress" {
+			t.Fatalf("unexpected event %q", msg.Type)
+		}
+		data, _ := json.Marshal(msg.Body)
+		if err := json.Unmarshal(data, &final); err != nil {
+			t.Fatalf("decode progress: %v", err)
+		}
+		if final.Status == batchJobStatusCompleted {
+			break
+		}
+	}
+	if final.SuccessCount != 1 || final.DoneCount != 2 || len(final.Errors) != 1 {
+		t.Fatalf("unexpected final job state: %+v", final)
+	}
+	if final.FilesCopied != 2 || final.BytesCopied != 8 {
+		t.Fatalf("expected 2 files / 8 bytes copied, got %d / %d", final.FilesCopied, final.BytesCopied)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "files", "tree", "sub", "b.txt")); err != nil {
+		t.Fatalf("expected nested file to be copied: %v", err)
+	}
+
+	for jobID, wantStatus := range map[string]int{started.JobID: http.StatusOK, "missing": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Params = gin.Params{{Key: "job", Value: jobID}}
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/server-files/batch/"+jobID, nil)
+		serverFilesBatchJobHandler(c)
+		if rec.Code != wantStatus {
+			t.Fatalf("job %s: expected status %d, got %d", jobID, wantStatus, rec.Code)
+		}
+	}
+}
+
+func TestServerFilesDownloadHandler_StreamsDirectoryAsZip(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+
+	pkgDir := filepath.Join(dataDir, "scripts", "pkg")
+	if err := os.MkdirAll(filepath.Join(pkgDir, "lib"), 0o755); err != nil {
+		t.Fatalf("mkdir pkg dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "main.lua"), []byte("print(1)"), 0o644); err != nil {
+		t.Fatalf("write main.lua: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "lib", "util.lua"), []byte("return {}"), 0o644); err != nil {
+		t.Fatalf("write util.lua: %v", err)
+	}
+	outsideDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outsideDir, "secret.txt"), []byte("external"), 0o644); err != nil {
+		t.Fatalf("write outside file: %v", err)
+	}
+	createSymlinkOrSkip(t, outsideDir, filepath.Join(pkgDir, "linked-dir"))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "path", Value: "/scripts/pkg"}}
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/server-files/download/scripts/pkg", nil)
+	serverFilesDownloadHandler(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("download status=%d body=%s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="pkg.zip"` {
+		t.Fatalf("unexpected Content-Disposition: %q", got)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open zip entry %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+	want := map[string]string{"main.lua": "print(1)", "lib/util.lua": "return {}"}
+	if !reflect.DeepEqual(contents, want) {
+		t.Fatalf("unexpected zip contents: %#v", contents)
+	}
+}
+
+func TestServerFilesBatchMoveHandler_DryRunReportsPlanWithoutChanges(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	backup := getServerConfig().DestructiveConfirmThreshold
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DestructiveConfirmThreshold = 1 })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DestructiveConfirmThreshold = backup }) })
+
+	for _, name := range []string{"a.lua", "taken.lua"} {
+		if err := os.WriteFile(filepath.Join(dataDir, "scripts", name), []byte(name), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dataDir, "scripts", "dir"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	dstDir := filepath.Join(dataDir, "files", "target")
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		t.Fatalf("mkdir target: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, "taken.lua"), []byte("old"), 0o644); err != nil {
+		t.Fatalf("write existing: %v", err)
+	}
+
+	payload := map[string]any{
+		"srcCategory": "scripts",
+		"dstCategory": "files",
+		"items":       []string{"a.lua", "dir", "taken.lua", "missing.lua", "a.lua", "../escape"},
+		"dstPath":     "target",
+		"dryRun":      true,
+	}
+	w := performJSONHandlerRequest(t, "POST", "/api/server-files/batch-move", payload, serverFilesBatchMoveHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("dry run status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Success      bool              `json:"success"`
+		DryRun       bool              `json:"dryRun"`
+		SuccessCount int               `json:"successCount"`
+		TotalCount   int               `json:"totalCount"`
+		Errors       []string          `json:"errors"`
+		Operations   []batchItemResult `json:"operations"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !resp.DryRun || resp.Success || resp.SuccessCount != 2 || resp.TotalCount != 6 || len(resp.Errors) != 4 {
+		t.Fatalf("unexpected summary: %+v", resp)
+	}
+	wantActions := []string{"move", "move", "conflict", "skip", "skip", "skip"}
+	for i, op := range resp.Operations {
+		if op.Action != wantActions[i] {
+			t.Fatalf("operation %d (%s): action %q, want %q", i, op.Item, op.Action, wantActions[i])
+		}
+	}
+	if resp.Operations[0].Size != int64(len("a.lua")) || !resp.Operations[1].IsDir {
+		t.Fatalf("unexpected operation details: %+v", resp.Operations[:2])
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, "scripts", "a.lua")); err != nil {
+		t.Fatalf("dry run must not move the source: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "a.lua")); !os.IsNotExist(err) {
+		t.Fatalf("dry run must not create the destination, err=%v", err)
+	}
+}
+
+func TestServerFilesBatchCopyHandler_OnConflictOverwriteAndMerge(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	write(filepath.Join(dataDir, "scripts", "app", "main.lua"), "new main")
+	write(filepath.Join(dataDir, "scripts", "app", "lib", "util.lua"), "new util")
+	write(filepath.Join(dataDir, "scripts", "config.json"), "new config")
+	write(filepath.Join(dataDir, "files", "app", "main.lua"), "old main")
+	write(filepath.Join(dataDir, "files", "app", "keep.txt"), "keep")
+
+	outsideFile := filepath.Join(t.TempDir(), "outside.json")
+	write(outsideFile, "outside")
+	createSymlinkOrSkip(t, outsideFile, filepath.Join(dataDir, "files", "config.json"))
+
+	copyItems := func(onConflict string) (int, []batchItemResult) {
+		t.Helper()
+		w := performJSONHandlerRequest(t, "POST", "/api/server-files/batch-copy", map[string]any{
+			"srcCategory": "scripts",
+			"dstCategory": "files",
+			"items":       []string{"app", "config.json"},
+			"onConflict":  onConflict,
+		}, serverFilesBatchCopyHandler)
+		var resp struct {
+			SuccessCount int               `json:"successCount"`
+			Results      []batchItemResult `json:"results"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp.SuccessCount, resp.Results
+	}
+
+	if count, results := copyItems(""); count != 0 || results[0].Action != "conflict" || results[1].Action != "conflict" {
+		t.Fatalf("default policy should keep conflicts, got %d %+v", count, results)
+	}
+
+	count, results := copyItems("merge")
+	if count != 2 || results[0].Action != "merge" || results[1].Action != "overwrite" {
+		t.Fatalf("unexpected merge results: %d %+v", count, results)
+	}
+	for path, want := range map[string]string{
+		filepath.Join(dataDir, "files", "app", "main.lua"):        "new main",
+		filepath.Join(dataDir, "files", "app", "lib", "util.lua"): "new util",
+		filepath.Join(dataDir, "files", "app", "keep.txt"):        "keep",
+		filepath.Join(dataDir, "files", "config.json"):            "new config",
+		outsideFile: "outside",
+	} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Fatalf("%s: got %q (%v), want %q", path, data, err, want)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(dataDir, "files", "config.json")); err != nil || info.Mode()&os.ModeSymlink != 0 {
+		t.Fatalf("overwriting a symlink should replace the link itself, info=%v err=%v", info, err)
+	}
+
+	count, results = copyItems("overwrite")
+	if count != 2 || results[0].Action != "overwrite" {
+		t.Fatalf("unexpected overwrite results: %d %+v", count, results)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "files", "app", "keep.txt")); !os.IsNotExist(err) {
+		t.Fatalf("overwrite should replace the destination directory, err=%v", err)
+	}
+
+	w := performJSONHandlerRequest(t, "POST", "/api/server-files/batch-copy", map[string]any{
+		"srcCategory": "scripts", "dstCategory": "files", "items": []string{"app"}, "onConflict": "replace",
+	}, serverFilesBatchCopyHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown onConflict, got %d", w.Code)
+	}
+}
+
+func TestServerFilesBatchHandlers_RejectDestinationInsideSource(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	keepFile := filepath.Join(dataDir, "scripts", "keep.lua")
+	appFile := filepath.Join(dataDir, "scripts", "app", "main.lua")
+	for _, path := range []string{keepFile, appFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("keep"), 0o644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler gin.HandlerFunc
+		payload map[string]any
+	}{
+		{"copy onto itself", serverFilesBatchCopyHandler, map[string]any{"items": []string{"keep.lua"}, "onConflict": "overwrite"}},
+		{"merge onto itself", serverFilesBatchCopyHandler, map[string]any{"items": []string{"app"}, "onConflict": "merge"}},
+		{"copy into own subtree", serverFilesBatchCopyHandler, map[string]any{"items": []string{"app"}, "dstPath": "app"}},
+		{"move onto itself", serverFilesBatchMoveHandler, map[string]any{"items": []string{"keep.lua", "app"}, "onConflict": "overwrite"}},
+		{"move into own subtree", serverFilesBatchMoveHandler, map[string]any{"items": []string{"app"}, "dstPath": "app/sub"}},
+	} {
+		tc.payload["srcCategory"] = "scripts"
+		tc.payload["dstCategory"] = "scripts"
+		w := performJSONHandlerRequest(t, "POST", "/api/server-files/batch", tc.payload, tc.handler)
+		var resp struct {
+			SuccessCount int               `json:"successCount"`
+			Results      []batchItemResult `json:"results"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: decode response: %v body=%s", tc.name, err, w.Body.String())
+		}
+		if resp.SuccessCount != 0 || len(resp.Results) == 0 {
+			t.Fatalf("%s: expected every item rejected, got %+v", tc.name, resp)
+		}
+		for _, result := range resp.Results {
+			if !strings.Contains(result.Error, "inside it") {
+				t.Fatalf("%s: unexpected result %+v", tc.name, result)
+			}
+		}
+		for _, path := range []string{keepFile, appFile} {
+			if data, err := os.ReadFile(path); err != nil || string(data) != "keep" {
+				t.Fatalf("%s: %s must be untouched, got %q (%v)", tc.name, path, data, err)
+			}
+		}
+	}
+}
+
+func TestServerFilesReadHandler_ReturnsBinaryAsBase64(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\xff\xfe")
+	if err := os.WriteFile(filepath.Join(dataDir, "files", "icon.png"), png, 0o644); err != nil {
+		t.Fatalf("write binary file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "files", "notes.txt"), []byte("你好\n"), 0o644); err != nil {
+		t.Fatalf("write text file: %v", err)
+	}
+
+	type readResponse struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+		Binary   bool   `json:"binary"`
+		MimeType string `json:"mimeType"`
+	}
+	read := func(name string) readResponse {
+		t.Helper()
+		w := performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/read?category=files&path="+name, nil, serverFilesReadHandler)
+		if w.Code != http.StatusOK {
+			t.Fatalf("read %s status=%d body=%s", name, w.Code, w.Body.String())
+		}
+		var resp readResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode read response: %v", err)
+		}
+		return resp
+	}
+
+	resp := read("icon.png")
+	decoded, err := base64.StdEncoding.DecodeString(resp.Content)
+	if !resp.Binary || resp.Encoding != "base64" || resp.MimeType != "image/png" || err != nil || !bytes.Equal(decoded, png) {
+		t.Fatalf("unexpected binary read: %+v (decode err %v)", resp, err)
+	}
+
+	resp = read("notes.txt")
+	if resp.Binary || resp.Encoding != "" || resp.Content != "你好\n" || resp.MimeType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected text read: %+v", resp)
+	}
+}
+
+func TestServerFilesSaveHandler_RejectsStaleExpectedModTime(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	path := filepath.Join(dataDir, "scripts", "shared.lua")
+	if err := os.WriteFile(path, []byte("v1"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	readAt := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, readAt, readAt); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/read?category=scripts&path=shared.lua", nil, serverFilesReadHandler)
+	var read struct {
+		ModTime int64 `json:"modTime"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&read); err != nil || read.ModTime != readAt.UnixMilli() {
+		t.Fatalf("expected read modTime %d, got %d (%v)", readAt.UnixMilli(), read.ModTime, err)
+	}
+
+	save := func(payload map[string]any) *httptest.ResponseRecorder {
+		t.Helper()
+		return performJSONHandlerRequest(t, http.MethodPost, "/api/server-files/save", payload, serverFilesSaveHandler)
+	}
+	w = save(map[string]any{"category": "scripts", "path": "shared.lua", "content": "first editor", "expectedModTime": read.ModTime})
+	var saved struct {
+		ModTime int64 `json:"modTime"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&saved); w.Code != http.StatusOK || err != nil || saved.ModTime <= read.ModTime {
+		t.Fatalf("first save should succeed with a newer modTime, got %d modTime=%d", w.Code, saved.ModTime)
+	}
+
+	w = save(map[string]any{"category": "scripts", "path": "shared.lua", "content": "second editor", "expectedModTime": read.ModTime})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("stale save should return 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "first editor" {
+		t.Fatalf("conflicting save must not overwrite, got %q", data)
+	}
+
+	if w := save(map[string]any{"category": "scripts", "path": "shared.lua", "content": "forced"}); w.Code != http.StatusOK {
+		t.Fatalf("save without expectedModTime should write unconditionally, got %d", w.Code)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "forced" {
+		t.Fatalf("unexpected content after forced save: %q", data)
+	}
+}
+
+func TestServerFilesListHandler_SortsAndPaginates(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	reportsDir := filepath.Join(dataDir, "reports")
+	base := time.Now().Add(-time.Hour)
+	for i, spec := range []struct {
+		name string
+		size int
+	}{{"b.log", 30}, {"A.log", 10}, {"c.log", 20}} {
+		path := filepath.Join(reportsDir, spec.name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), spec.size), 0o644); err != nil {
+			t.Fatalf("write %s: %v", spec.name, err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(reportsDir, "z-dir"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	list := func(query string) ([]string, int) {
+		t.Helper()
+		w := performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/list?category=reports&"+query, nil, serverFilesListHandler)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list %q status=%d body=%s", query, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Files []ServerFileItem `json:"files"`
+			Total int              `json:"total"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		names := make([]string, len(resp.Files))
+		for i, file := range resp.Files {
+			names[i] = file.Name
+		}
+		return names, resp.Total
+	}
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"sort=name", []string{"A.log", "b.log", "c.log", "z-dir"}},
+		{"sort=name&order=desc&offset=1&limit=2", []string{"c.log", "b.log"}},
+		{"sort=size&order=desc&dirsFirst=1&offset=1&limit=2", []string{"b.log", "c.log"}},
+		{"sort=modTime&dirsFirst=1", []string{"z-dir", "b.log", "A.log", "c.log"}},
+		{"offset=10", []string{}},
+	}
+	for _, tc := range cases {
+		names, total := list(tc.query)
+		if total != 4 || !reflect.DeepEqual(names, tc.want) {
+			t.Fatalf("%s: got %v (total %d), want %v", tc.query, names, total, tc.want)
+		}
+	}
+
+	if names, _ := list(""); len(names) != 4 {
+		t.Fatalf("unpaginated listing should return every entry, got %v", names)
+	}
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/list?category=reports&sort=date", nil, serverFilesListHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown sort, got %d", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"container/heap"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	storageUsageCacheTTL = 30 * time.Second
+	storageUsageMaxTop   = 100
+)
+
+type categoryUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// storageUsageItem is a file or folder in the largest-consumers lists.
+type storageUsageItem struct {
+	Category string `json:"category"`
+	Path     string `json:"path"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// storageUsageReport keeps the storageUsageMaxTop largest files and folders, largest
+// first; requests slice them to their own top.
+type storageUsageReport struct {
+	Categories   map[string]categoryUsage
+	TotalBytes   int64
+	TotalFiles   int
+	LargestFiles []storageUsageItem
+	LargestDirs  []storageUsageItem
+	ComputedAt   int64
+}
+
+// storageUsageCache holds the last report. The lock is held while walking so concurrent
+// requests wait for one walk instead of starting their own.
+var storageUsageCache = struct {
+	sync.Mutex
+	dataDir string
+	report  *storageUsageReport
+	at      time.Time
+}{}
+
+// smallestFirst is a min-heap that keeps the storageUsageMaxTop largest items seen.
+type smallestFirst []storageUsageItem
+
+func (h smallestFirst) Len() int            { return len(h) }
+func (h smallestFirst) Less(i, j int) bool  { return h[i].Bytes < h[j].Bytes }
+func (h smallestFirst) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *smallestFirst) Push(x interface{}) { *h = append(*h, x.(storageUsageItem)) }
+func (h *smallestFirst) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+func (h *smallestFirst) offer(item storageUsageItem) {
+	if h.Len() < storageUsageMaxTop {
+		heap.Push(h, item)
+	} else if item.Bytes > (*h)[0].Bytes {
+		(*h)[0] = item
+		heap.Fix(h, 0)
+	}
+}
+
+func (h smallestFirst) sortedLargestFirst() []storageUsageItem {
+	items := append([]storageUsageItem(nil), h...)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Bytes != items[j].Bytes {
+			return items[i].Bytes > items[j].Bytes
+		}
+		return items[i].Category+"/"+items[i].Path < items[j].Category+"/"+items[j].Path
+	})
+	return items
+}
+
+// walkCategoryFiles calls visit for every file under category with its path relative
+// to the category root. Directory symlinks are skipped, file symlinks are followed only
+// when they resolve inside the category, and unreadable entries are ignored. maxDepth > 0
+// limits how many directory levels are entered (1 = files directly in the category).
+// visit may return filepath.SkipAll to stop early; a missing category walks nothing.
+func walkCategoryFiles(category string, maxDepth int, visit func(path, relPath string, info os.FileInfo) error) error {
+	absBaseDir, err := filepath.Abs(filepath.Join(getServerConfig().DataDir, category))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(absBaseDir); os.IsNotExist(err) {
+		return nil
+	}
+	// Walk the resolved root so a symlinked category directory is still traversed.
+	realBaseDir, err := filepath.EvalSymlinks(absBaseDir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(realBaseDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != nil && entry.IsDir() && path != realBaseDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if maxDepth > 0 && path != realBaseDir {
+				if relPath, relErr := filepath.Rel(realBaseDir, path); relErr == nil &&
+					len(strings.Split(relPath, string(filepath.Separator))) >= maxDepth {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		var info os.FileInfo
+		if entry.Type()&os.ModeSymlink != 0 {
+			resolved, resolveErr := filepath.EvalSymlinks(path)
+			if resolveErr != nil || !isPathWithinAbsBase(realBaseDir, resolved) {
+				return nil
+			}
+			if info, err = os.Stat(resolved); err != nil || info.IsDir() {
+				return nil
+			}
+		} else if !entry.Type().IsRegular() {
+			return nil
+		} else if info, err = entry.Info(); err != nil {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(realBaseDir, path)
+		if err != nil {
+			return nil
+		}
+		return visit(path, relPath, info)
+	})
+}
+
+// walkCategoryUsage sums the files under one category (see walkCategoryFiles for which
+// entries count). Folder sizes include everything below them.
+func walkCategoryUsage(category string, files, dirs *smallestFirst) (categoryUsage, error) {
+	var usage categoryUsage
+	dirBytes := make(map[string]int64)
+	walkErr := walkCategoryFiles(category, 0, func(_, relPath string, info os.FileInfo) error {
+		size := info.Size()
+		usage.Bytes += size
+		usage.Files++
+		files.offer(storageUsageItem{Category: category, Path: filepath.ToSlash(relPath), Bytes: size})
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			dirBytes[dir] += size
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return usage, walkErr
+	}
+
+	for dir, size := range dirBytes {
+		dirs.offer(storageUsageItem{Category: category, Path: filepath.ToSlash(dir), Bytes: size})
+	}
+	return usage, nil
+}
+
+func computeStorageUsage() (*storageUsageReport, error) {
+	report := &storageUsageReport{
+		Categories: make(map[string]categoryUsage, len(AllowedCategories)),
+		ComputedAt: time.Now().Unix(),
+	}
+	files := &smallestFirst{}
+	dirs := &smallestFirst{}
+	for _, category := range AllowedCategories {
+		usage, err := walkCategoryUsage(category, files, dirs)
+		if err != nil {
+			return nil, err
+		}
+		report.Categories[category] = usage
+		report.TotalBytes += usage.Bytes
+		report.TotalFiles += usage.Files
+	}
+	report.LargestFiles = files.sortedLargestFirst()
+	report.LargestDirs = dirs.sortedLargestFirst()
+	return report, nil
+}
+
+// getStorageUsage returns the cached report unless it is older than storageUsageCacheTTL,
+// the data directory changed, or refresh is set.
+func getStorageUsage(refresh bool) (*storageUsageReport, bool, error) {
+	storageUsageCache.Lock()
+	defer storageUsageCache.Unlock()
+
+	if !refresh && storageUsageCache.report != nil && storageUsageCache.dataDir == getServerConfig().DataDir &&
+		time.Since(storageUsageCache.at) < storageUsageCacheTTL {
+		return storageUsageCache.report, true, nil
+	}
+	report, err := computeStorageUsage()
+	if err != nil {
+		return nil, false, err
+	}
+	storageUsageCache.dataDir = getServerConfig().DataDir
+	storageUsageCache.report = report
+	storageUsageCache.at = time.Now()
+	return report, false, nil
+}
+
+// serverFilesUsageHandler handles GET /api/server-files/usage
+func serverFilesUsageHandler(c *gin.Context) {
+	top := 0
+	if value := c.Query("top"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "top must be a non-negative integer"})
+			return
+		}
+		top = min(parsed, storageUsageMaxTop)
+	}
+	refresh := c.Query("refresh") == "1" || c.Query("refresh") == "true"
+
+	report, cached, err := getStorageUsage(refresh)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compute storage usage"})
+		return
+	}
+
+	response := gin.H{
+		"categories": report.Categories,
+		"totalBytes": report.TotalBytes,
+		"totalFiles": report.TotalFiles,
+		"computedAt": report.ComputedAt,
+		"cached":     cached,
+	}
+	if top > 0 {
+		response["largestFiles"] = report.LargestFiles[:min(top, len(report.LargestFiles))]
+		response["largestDirs"] = report.LargestDirs[:min(top, len(report.LargestDirs))]
+	}
+	c.JSON(http.StatusOK, response)
+}
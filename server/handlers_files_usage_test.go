@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServerFilesUsageHandler_SumsCategoriesAndRanksLargest(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	storageUsageCache.Lock()
+	storageUsageCache.report = nil
+	storageUsageCache.Unlock()
+
+	write := func(rel string, size int) {
+		path := filepath.Join(dataDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	write("scripts/main.lua", 10)
+	write("reports/d1/a.log", 300)
+	write("reports/d1/old/b.log", 200)
+	write("files/pic.png", 50)
+
+	outside := filepath.Join(t.TempDir(), "outside.bin")
+	if err := os.WriteFile(outside, []byte(strings.Repeat("x", 5000)), 0o644); err != nil {
+		t.Fatalf("write outside file failed: %v", err)
+	}
+	createSymlinkOrSkip(t, outside, filepath.Join(dataDir, "files", "escape.bin"))
+	createSymlinkOrSkip(t, filepath.Dir(outside), filepath.Join(dataDir, "files", "escape-dir"))
+
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/usage?top=2", nil, serverFilesUsageHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Categories   map[string]categoryUsage `json:"categories"`
+		TotalBytes   int64                    `json:"totalBytes"`
+		TotalFiles   int                      `json:"totalFiles"`
+		LargestFiles []storageUsageItem       `json:"largestFiles"`
+		LargestDirs  []storageUsageItem       `json:"largestDirs"`
+		Cached       bool                     `json:"cached"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if resp.Categories["reports"] != (categoryUsage{Bytes: 500, Files: 2}) || resp.Categories["files"] != (categoryUsage{Bytes: 50, Files: 1}) {
+		t.Fatalf("unexpected categories (symlinks leaving the category must not count): %+v", resp.Categories)
+	}
+	if resp.TotalBytes != 560 || resp.TotalFiles != 4 || resp.Cached {
+		t.Fatalf("unexpected totals: %+v", resp)
+	}
+	if len(resp.LargestFiles) != 2 || resp.LargestFiles[0].Path != "d1/a.log" || resp.LargestFiles[1].Path != "d1/old/b.log" {
+		t.Fatalf("unexpected largest files: %+v", resp.LargestFiles)
+	}
+	if len(resp.LargestDirs) != 2 || resp.LargestDirs[0] != (storageUsageItem{Category: "reports", Path: "d1", Bytes: 500}) {
+		t.Fatalf("unexpected largest dirs: %+v", resp.LargestDirs)
+	}
+
+	write("scripts/new.lua", 1000)
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/usage", nil, serverFilesUsageHandler)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Cached || resp.TotalBytes != 560 {
+		t.Fatalf("expected the cached report, got %s", w.Body.String())
+	}
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/server-files/usage?refresh=1", nil, serverFilesUsageHandler)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Cached || resp.TotalBytes != 1560 {
+		t.Fatalf("expected a fresh report, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errUploadZipTooLarge = errors.New("uncompressed size limit exceeded")
+
+// extractUploadedZipEntry writes one file entry to cleanPath, allowing at most budget bytes.
+// It returns the number of bytes written; a partially written file is removed on error.
+func extractUploadedZipEntry(f *zip.File, cleanPath string, budget int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(cleanPath), 0755); err != nil {
+		return 0, err
+	}
+	in, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(cleanPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	var src io.Reader = in
+	if budget >= 0 {
+		src = io.LimitReader(in, budget+1)
+	}
+	written, err := io.Copy(out, src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && budget >= 0 && written > budget {
+		err = errUploadZipTooLarge
+	}
+	if err != nil {
+		os.Remove(cleanPath)
+		return 0, err
+	}
+	return written, nil
+}
+
+// serverFilesUploadZipHandler handles POST /api/server-files/upload-zip
+// The uploaded zip is extracted into category/path, keeping its directory structure.
+func serverFilesUploadZipHandler(c *gin.Context) {
+	category := c.DefaultPostForm("category", "scripts")
+	subPath := c.DefaultPostForm("path", "")
+
+	targetDir, err := validatePath(category, subPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid zip archive"})
+		return
+	}
+
+	// Reject obvious zip bombs up front; the declared sizes are enforced again while copying.
+	totalCount := 0
+	var declared uint64
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			totalCount++
+		}
+		declared += f.UncompressedSize64
+	}
+	maxBytes := getServerConfig().UploadZipMaxBytes
+	budget := int64(-1)
+	if maxBytes > 0 {
+		if declared > uint64(maxBytes) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("uncompressed size exceeds limit of %d bytes", maxBytes)})
+			return
+		}
+		budget = maxBytes
+	}
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create directory"})
+		return
+	}
+
+	absBaseDir, err := filepath.Abs(filepath.Join(getServerConfig().DataDir, category))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve base path"})
+		return
+	}
+
+	successCount := 0
+	var extracted []string
+	var errs []string
+	for _, f := range zr.File {
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+		isDir := f.FileInfo().IsDir()
+
+		cleanPath, err := secureZipEntryPath(targetDir, name)
+		if err == nil && !isPathWithinAbsBase(absBaseDir, cleanPath) {
+			err = fmt.Errorf("illegal zip entry path: %s", name)
+		}
+		if err == nil && f.Mode()&os.ModeSymlink != 0 {
+			err = fmt.Errorf("symlink entries are not supported")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		if isDir {
+			if err := os.MkdirAll(cleanPath, 0755); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			}
+			continue
+		}
+
+		written, err := extractUploadedZipEntry(f, cleanPath, budget)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			if errors.Is(err, errUploadZipTooLarge) {
+				break
+			}
+			continue
+		}
+		if budget >= 0 {
+			budget -= written
+		}
+		successCount++
+		extracted = append(extracted, strings.TrimSuffix(name, "/"))
+	}
+
+	logDebug("Zip upload extracted", "file", header.Filename, "extracted", successCount, "total", totalCount, "category", category, "path", subPath)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      len(errs) == 0,
+		"successCount": successCount,
+		"totalCount":   totalCount,
+		"extracted":    extracted,
+		"errors":       errs,
+	})
+}
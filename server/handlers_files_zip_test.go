@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func buildTestZip(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range entries {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create zip entry %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("write zip entry %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func performZipUpload(t *testing.T, path string, data []byte) (int, map[string]interface{}) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("category", "scripts"); err != nil {
+		t.Fatalf("write category field: %v", err)
+	}
+	if err := writer.WriteField("path", path); err != nil {
+		t.Fatalf("write path field: %v", err)
+	}
+	part, err := writer.CreateFormFile("file", "pkg.zip")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatalf("write form file content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close multipart writer: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/server-files/upload-zip", &body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	serverFilesUploadZipHandler(c)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestServerFilesUploadZipHandler_ExtractsAndRejectsTraversal(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+
+	data := buildTestZip(t, map[string]string{
+		"main.lua":         "print(1)",
+		"lib/util.lua":     "return {}",
+		"../../escape.lua": "bad",
+	})
+	code, resp := performZipUpload(t, "pkg", data)
+	if code != http.StatusOK {
+		t.Fatalf("upload status=%d resp=%v", code, resp)
+	}
+	if resp["success"] != false || resp["successCount"] != float64(2) || resp["totalCount"] != float64(3) {
+		t.Fatalf("unexpected summary: %v", resp)
+	}
+	if errs, _ := resp["errors"].([]interface{}); len(errs) != 1 {
+		t.Fatalf("expected one failed entry, got %v", resp["errors"])
+	}
+
+	got, err := os.ReadFile(filepath.Join(dataDir, "scripts", "pkg", "lib", "util.lua"))
+	if err != nil || string(got) != "return {}" {
+		t.Fatalf("nested file not extracted: %q %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "escape.lua")); !os.IsNotExist(err) {
+		t.Fatalf("traversal entry should not be written")
+	}
+}
+
+func TestServerFilesUploadZipHandler_EnforcesMaxUncompressedSize(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	prevMax := getServerConfig().UploadZipMaxBytes
+	updateServerConfig(func(cfg *ServerConfig) { cfg.UploadZipMaxBytes = 16 })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.UploadZipMaxBytes = prevMax }) })
+
+	data := buildTestZip(t, map[string]string{"big.txt": string(bytes.Repeat([]byte("a"), 64))})
+	code, resp := performZipUpload(t, "", data)
+	if code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d resp=%v", code, resp)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "scripts", "big.txt")); !os.IsNotExist(err) {
+		t.Fatalf("oversized archive should not be extracted")
+	}
+}
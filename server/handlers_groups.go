@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,6 +15,95 @@ func generateGroupID() string {
 	return fmt.Sprintf("g%d", time.Now().UnixNano())
 }
 
+// Change types carried by group/updated events
+const (
+	groupChangeCreated             = "created"
+	groupChangeRenamed             = "renamed"
+	groupChangeDeleted             = "deleted"
+	groupChangeReordered           = "reordered"
+	groupChangeDevicesAdded        = "devices-added"
+	groupChangeDevicesRemoved      = "devices-removed"
+	groupChangeScriptBound         = "script-bound"
+	groupChangeScriptConfigUpdated = "script-config-updated"
+	groupChangeScriptConfigDeleted = "script-config-deleted"
+	groupChangeReloaded            = "reloaded"
+)
+
+// broadcastGroupUpdated notifies controllers that a group (or, with an empty groupID,
+// the whole group list) changed, so they can refresh just what is affected.
+func broadcastGroupUpdated(groupID, change string) {
+	broadcastControllerEvent("group/updated", gin.H{
+		"groupId": groupID,
+		"change":  change,
+	})
+}
+
+// allDevicesSelector in a groups/tags selector targets every connected device.
+const allDevicesSelector = "__all__"
+
+// resolveGroupSelectors returns the members of the groups matching groupIDs (by ID) or
+// tags (by group name, case-insensitive). Each device appears once.
+func resolveGroupSelectors(groupIDs, tags []string) []string {
+	selectedIDs := make(map[string]struct{}, len(groupIDs))
+	for _, id := range groupIDs {
+		id = strings.TrimSpace(id)
+		if id == allDevicesSelector {
+			return connectedDeviceIDs()
+		}
+		selectedIDs[id] = struct{}{}
+	}
+	selectedTags := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == allDevicesSelector {
+			return connectedDeviceIDs()
+		}
+		if tag != "" {
+			selectedTags = append(selectedTags, tag)
+		}
+	}
+
+	var devices []string
+	deviceGroupsMu.RLock()
+	for _, group := range deviceGroups {
+		matched := false
+		if _, ok := selectedIDs[group.ID]; ok {
+			matched = true
+		}
+		for _, tag := range selectedTags {
+			if strings.EqualFold(strings.TrimSpace(group.Name), tag) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			devices = append(devices, group.DeviceIDs...)
+		}
+	}
+	deviceGroupsMu.RUnlock()
+	return uniqueDeviceIDs(devices)
+}
+
+func connectedDeviceIDs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	devices := make([]string, 0, len(deviceLinks))
+	for udid := range deviceLinks {
+		devices = append(devices, udid)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// expandDevicesWithSelectors appends devices selected by group IDs and tags to an explicit
+// device list, deduplicating the result.
+func expandDevicesWithSelectors(devices, groupIDs, tags []string) []string {
+	if len(groupIDs) == 0 && len(tags) == 0 {
+		return devices
+	}
+	return uniqueDeviceIDs(append(append([]string(nil), devices...), resolveGroupSelectors(groupIDs, tags)...))
+}
+
 // groupsListHandler handles GET /api/groups
 func groupsListHandler(c *gin.Context) {
 	deviceGroupsMu.RLock()
@@ -55,6 +145,7 @@ func groupsCreateHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated(newGroup.ID, groupChangeCreated)
 	c.JSON(http.StatusOK, gin.H{"success": true, "group": newGroup})
 }
 
@@ -100,6 +191,7 @@ func groupsUpdateHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeRenamed)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -135,6 +227,7 @@ func groupsDeleteHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeDeleted)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -194,6 +287,7 @@ func groupsReorderHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated("", groupChangeReordered)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -242,6 +336,7 @@ func groupsAddDevicesHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeDevicesAdded)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -291,6 +386,7 @@ func groupsRemoveDevicesHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeDevicesRemoved)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -331,6 +427,7 @@ func groupsBindScriptHandler(c *gin.Context) {
 	}
 	deviceGroupsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeScriptBound)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -385,6 +482,7 @@ func groupsSetScriptConfigHandler(c *gin.Context) {
 	}
 	groupScriptConfigsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeScriptConfigUpdated)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -415,5 +513,6 @@ func groupsDeleteScriptConfigHandler(c *gin.Context) {
 	}
 	groupScriptConfigsMu.Unlock()
 
+	broadcastGroupUpdated(groupID, groupChangeScriptConfigDeleted)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGroupHandlers_BroadcastGroupUpdated(t *testing.T) {
+	setupGroupsReorderFixture(t)
+	controllerConn, client := newTestWebSocketPair(t)
+
+	mu.Lock()
+	controllersBackup := controllers
+	controllers = map[*SafeConn]bool{controllerConn: true}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		controllers = controllersBackup
+		mu.Unlock()
+	})
+
+	w := performJSONRequestWithGroupID(t, http.MethodPost, "/api/groups/g2/devices", "g2", map[string]any{
+		"deviceIds": []string{"d9"},
+	}, groupsAddDevicesHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	msg := readTestMessage(t, client)
+	body, _ := msg.Body.(map[string]interface{})
+	if msg.Type != "group/updated" || body["groupId"] != "g2" || body["change"] != groupChangeDevicesAdded {
+		t.Fatalf("unexpected event: %+v", msg)
+	}
+
+	w = performJSONRequestWithGroupID(t, http.MethodPut, "/api/groups/missing", "missing", map[string]any{
+		"name": "x",
+	}, groupsUpdateHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPut, "/api/groups/reorder", map[string]any{
+		"order": []string{"g3", "g2", "g1"},
+	}, groupsReorderHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	// The failed rename must not have produced an event, so the next one is the reorder.
+	msg = readTestMessage(t, client)
+	body, _ = msg.Body.(map[string]interface{})
+	if body["groupId"] != "" || body["change"] != groupChangeReordered {
+		t.Fatalf("unexpected event: %+v", msg)
+	}
+}
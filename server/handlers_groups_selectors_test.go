@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandDevicesWithSelectors_ResolvesGroupsAndTags(t *testing.T) {
+	setupGroupsReorderFixture(t)
+
+	got := expandDevicesWithSelectors([]string{"d2", "x1"}, []string{"g1", "g2"}, []string{" group 3 "})
+	want := []string{"d2", "x1", "d1", "d3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices: got=%v want=%v", got, want)
+	}
+
+	if got := expandDevicesWithSelectors([]string{"d1"}, nil, nil); !reflect.DeepEqual(got, []string{"d1"}) {
+		t.Fatalf("expected devices unchanged without selectors, got %v", got)
+	}
+	if got := expandDevicesWithSelectors(nil, []string{"missing"}, nil); len(got) != 0 {
+		t.Fatalf("expected no devices for unknown group, got %v", got)
+	}
+}
+
+func TestExpandDevicesWithSelectors_AllSelectsConnectedDevices(t *testing.T) {
+	setupGroupsReorderFixture(t)
+
+	mu.Lock()
+	backupLinks := deviceLinks
+	deviceLinks = map[string]*SafeConn{"d9": {}, "d1": {}}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceLinks = backupLinks
+		mu.Unlock()
+	})
+
+	got := expandDevicesWithSelectors([]string{"d1"}, nil, []string{allDevicesSelector})
+	want := []string{"d1", "d9"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected devices: got=%v want=%v", got, want)
+	}
+}
@@ -75,7 +75,7 @@ func lanControlArchiveInspectHandler(c *gin.Context) {
 		defer cleanup()
 	}
 
-	result, err := inspectLanControlArchivePath(serverConfig.DataDir, archivePath, sourceName)
+	result, err := inspectLanControlArchivePath(getServerConfig().DataDir, archivePath, sourceName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -95,7 +95,7 @@ func lanControlArchiveInstallHandler(c *gin.Context) {
 
 	installName := strings.TrimSpace(firstNonEmpty(c.Query("installName"), c.PostForm("installName")))
 	overwrite := parseBoolString(firstNonEmpty(c.Query("overwrite"), c.PostForm("overwrite")))
-	result, err := installLanControlArchivePath(serverConfig.DataDir, archivePath, sourceName, installName, overwrite)
+	result, err := installLanControlArchivePath(getServerConfig().DataDir, archivePath, sourceName, installName, overwrite)
 	if err != nil {
 		status := http.StatusBadRequest
 		if strings.Contains(err.Error(), "already exists") {
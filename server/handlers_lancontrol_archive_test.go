@@ -19,9 +19,9 @@ func setupLanControlArchiveTestDataDir(t *testing.T) string {
 	gin.SetMode(gin.TestMode)
 
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	for _, category := range AllowedCategories {
 		if err := os.MkdirAll(filepath.Join(dataDir, category), 0o755); err != nil {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	localAdminPathPrefix = "/api/local-admin/"
+	localAdminHeader     = "X-Local-Admin"
+)
+
+// isDirectLocalRequest reports whether the request comes from loopback without proxy
+// headers, since a local reverse proxy would make any client look local.
+func isDirectLocalRequest(c *gin.Context) bool {
+	return isLocalRequest(c) && c.GetHeader("X-Forwarded-For") == "" && c.GetHeader("X-Real-IP") == "" && c.GetHeader("Forwarded") == ""
+}
+
+// localAdminMiddleware guards /api/local-admin/*, which skips signature auth so scripts on
+// the server host can drive it without the password. Requests must be direct local requests
+// carrying "X-Local-Admin: 1" and no Origin: a browser page cannot add the custom header
+// without a CORS preflight, so a site open on the server cannot forge these requests.
+func localAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !getServerConfig().LocalAdminEnabled {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			c.Abort()
+			return
+		}
+		if !isDirectLocalRequest(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only allowed from local machine"})
+			c.Abort()
+			return
+		}
+		if c.GetHeader("Origin") != "" || c.GetHeader(localAdminHeader) != "1" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "local admin requests require X-Local-Admin: 1 and no Origin"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// localAdminReloadConfigHandler handles POST /api/local-admin/reload-config
+func localAdminReloadConfigHandler(c *gin.Context) {
+	changed, restartRequired, err := reloadServerConfigFromFile(false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "changed": changed, "restartRequired": restartRequired})
+}
+
+// localAdminKickHandler handles POST /api/local-admin/kick
+func localAdminKickHandler(c *gin.Context) {
+	var req struct {
+		UDID string `json:"udid"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.UDID) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "udid is required"})
+		return
+	}
+	udid := strings.TrimSpace(req.UDID)
+
+	mu.RLock()
+	deviceConn, online := deviceLinks[udid]
+	mu.RUnlock()
+	if !online {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not connected"})
+		return
+	}
+
+	deviceConn.Close()
+	handleDisconnection(deviceConn)
+	c.JSON(http.StatusOK, gin.H{"success": true, "udid": udid})
+}
+
+func registerLocalAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/api/local-admin", localAdminMiddleware())
+	admin.POST("/reload-config", localAdminReloadConfigHandler)
+	admin.POST("/kick", localAdminKickHandler)
+	admin.POST("/update-check", updateCheckHandler)
+	admin.GET("/metrics", metricsHandler)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLocalAdminMiddlewareRequiresLoopbackWithoutProxyHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	configBackup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(configBackup) })
+
+	r := gin.New()
+	r.Use(apiAuthMiddleware())
+	r.Group("/api/local-admin", localAdminMiddleware()).GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	marked := map[string]string{"X-Local-Admin": "1"}
+	cases := []struct {
+		name       string
+		enabled    bool
+		remoteAddr string
+		headers    map[string]string
+		want       int
+	}{
+		{"disabled", false, "127.0.0.1:5000", marked, http.StatusNotFound},
+		{"local", true, "127.0.0.1:5000", marked, http.StatusOK},
+		{"local ipv6", true, "[::1]:5000", marked, http.StatusOK},
+		{"remote", true, "192.168.1.20:5000", marked, http.StatusForbidden},
+		{"proxied", true, "127.0.0.1:5000", map[string]string{"X-Local-Admin": "1", "X-Forwarded-For": "203.0.113.9"}, http.StatusForbidden},
+		{"unmarked", true, "127.0.0.1:5000", nil, http.StatusForbidden},
+		{"browser origin", true, "127.0.0.1:5000", map[string]string{"X-Local-Admin": "1", "Origin": "http://evil.example"}, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.LocalAdminEnabled = tc.enabled })
+		req := httptest.NewRequest(http.MethodGet, "/api/local-admin/ping", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestReloadServerConfigFromFileAppliesHotKeysOnly(t *testing.T) {
+	configBackup := *getServerConfig()
+	configPathBackup := serverConfigPath
+	serverConfigPath = filepath.Join(t.TempDir(), "xxtcloudserver.json")
+	t.Cleanup(func() {
+		setServerConfig(configBackup)
+		serverConfigPath = configPathBackup
+	})
+
+	setServerConfig(DefaultConfig)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.Passhash = "running-hash" })
+	fileConfig := map[string]interface{}{
+		"port":              40002,
+		"passhash":          "file-hash",
+		"screenFrameMaxFps": 3,
+		"devicesPageSize":   50,
+	}
+	data, _ := json.Marshal(fileConfig)
+	if err := os.WriteFile(serverConfigPath, data, 0o644); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/local-admin/reload-config", nil, localAdminReloadConfigHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		RestartRequired []string `json:"restartRequired"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	if len(resp.RestartRequired) != 1 || resp.RestartRequired[0] != "port" {
+		t.Fatalf("expected port to require a restart, got %v", resp.RestartRequired)
+	}
+	if getServerConfig().ScreenFrameMaxFPS != 3 || getServerConfig().DevicesPageSize != 50 {
+		t.Fatalf("hot keys not applied: fps=%d pageSize=%d", getServerConfig().ScreenFrameMaxFPS, getServerConfig().DevicesPageSize)
+	}
+	if getServerConfig().Port != DefaultConfig.Port || getServerConfig().Passhash != "running-hash" {
+		t.Fatalf("startup-only or forbidden keys changed: port=%d passhash=%q", getServerConfig().Port, getServerConfig().Passhash)
+	}
+
+	if err := os.WriteFile(serverConfigPath, []byte(`{"screenFrameMaxFps": -1}`), 0o644); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/local-admin/reload-config", nil, localAdminReloadConfigHandler)
+	if w.Code != http.StatusBadRequest || getServerConfig().ScreenFrameMaxFPS != 3 {
+		t.Fatalf("invalid config should be rejected, got %d fps=%d", w.Code, getServerConfig().ScreenFrameMaxFPS)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resendLogSubscriptions re-sends system/log/subscribe to connected devices that
+// still have subscribed controllers. When deviceIDs is empty, every subscribed device is targeted.
+// Returns the UDIDs that were re-subscribed.
+func resendLogSubscriptions(deviceIDs []string) ([]string, error) {
+	targets := make([]deviceTarget, 0)
+	mu.RLock()
+	if len(deviceIDs) == 0 {
+		for udid, subs := range logSubscriptions {
+			if len(subs) == 0 {
+				continue
+			}
+			if deviceConn, exists := deviceLinks[udid]; exists {
+				targets = append(targets, deviceTarget{udid: udid, conn: deviceConn})
+			}
+		}
+	} else {
+		for _, udid := range uniqueDeviceIDs(deviceIDs) {
+			if subs, ok := logSubscriptions[udid]; !ok || len(subs) == 0 {
+				continue
+			}
+			if deviceConn, exists := deviceLinks[udid]; exists {
+				targets = append(targets, deviceTarget{udid: udid, conn: deviceConn})
+			}
+		}
+	}
+	mu.RUnlock()
+
+	resubscribed := make([]string, 0, len(targets))
+	if len(targets) == 0 {
+		return resubscribed, nil
+	}
+
+	subscribePayload, err := json.Marshal(Message{Type: "system/log/subscribe"})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, target := range targets {
+		writeTextMessageAsync(target.conn, subscribePayload)
+		markLogStreamActive(target.udid, now)
+		resubscribed = append(resubscribed, target.udid)
+	}
+	return resubscribed, nil
+}
+
+// logResubscribeHandler handles POST /api/log/resubscribe
+// Re-sends system/log/subscribe to devices whose log stream has subscribers,
+// recovering from device-side log pipelines that restarted without reconnecting.
+func logResubscribeHandler(c *gin.Context) {
+	var req LogSubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	resubscribed, err := resendLogSubscriptions(req.Devices)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "resubscribed": resubscribed})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestWebSocketPair returns a server-side SafeConn and the client end reading from it.
+func newTestWebSocketPair(t *testing.T) (*SafeConn, *websocket.Conn) {
+	t.Helper()
+
+	serverConns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case conn := <-serverConns:
+		t.Cleanup(func() { conn.Close() })
+		return &SafeConn{conn: conn}, client
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for websocket upgrade")
+	}
+	return nil, nil
+}
+
+// readTestMessage reads one JSON message from a test websocket client.
+func readTestMessage(t *testing.T, client *websocket.Conn) Message {
+	t.Helper()
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, payload, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("read message failed: %v", err)
+	}
+	var msg Message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		t.Fatalf("decode message failed: %v", err)
+	}
+	return msg
+}
+
+func TestResendLogSubscriptions_OnlyTargetsSubscribedConnectedDevices(t *testing.T) {
+	deviceConn, client := newTestWebSocketPair(t)
+	controller := &SafeConn{}
+
+	mu.Lock()
+	linksBackup := deviceLinks
+	subsBackup := logSubscriptions
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn, "d2": &SafeConn{}}
+	logSubscriptions = map[string]map[*SafeConn]bool{
+		"d1": {controller: true},
+		"d3": {controller: true},
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceLinks = linksBackup
+		logSubscriptions = subsBackup
+		mu.Unlock()
+	})
+
+	got, err := resendLogSubscriptions([]string{"d1", "d2", "d3", "d1"})
+	if err != nil {
+		t.Fatalf("resend failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"d1"}) {
+		t.Fatalf("expected only d1 to be resubscribed, got %v", got)
+	}
+	if msg := readTestMessage(t, client); msg.Type != "system/log/subscribe" {
+		t.Fatalf("expected system/log/subscribe, got %q", msg.Type)
+	}
+
+	got, err = resendLogSubscriptions(nil)
+	if err != nil {
+		t.Fatalf("resend all failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"d1"}) {
+		t.Fatalf("expected resend-all to target d1, got %v", got)
+	}
+}
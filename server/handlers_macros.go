@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxMacroNameRunes = 64
+	maxMacroSteps     = 100
+	maxMacroStepDelay = 10 * time.Minute
+	// maxMacroRuns bounds how many run reports are kept in memory (oldest dropped first).
+	maxMacroRuns = 50
+)
+
+type macroRequest struct {
+	Name  string      `json:"name"`
+	Steps []MacroStep `json:"steps"`
+}
+
+type macroRunRequest struct {
+	Name         string   `json:"name"`
+	Devices      []string `json:"devices"`
+	View         string   `json:"view"`
+	ConfirmToken string   `json:"confirmToken"`
+}
+
+// macroStepResult reports how one macro step went on one device.
+type macroStepResult struct {
+	Step   int    `json:"step"`
+	Type   string `json:"type"`
+	Status string `json:"status"` // pending, sent, failed, skipped
+	Error  string `json:"error,omitempty"`
+	SentAt int64  `json:"sentAt,omitempty"` // Unix milliseconds
+}
+
+// macroRun is the per-device, per-step report of one macro execution.
+type macroRun struct {
+	ID         string                       `json:"id"`
+	Macro      string                       `json:"macro"`
+	StartedAt  int64                        `json:"startedAt"`
+	FinishedAt int64                        `json:"finishedAt,omitempty"`
+	Devices    map[string][]macroStepResult `json:"devices"`
+}
+
+var (
+	macroRuns      = make(map[string]*macroRun)
+	macroRunOrder  []string
+	macroRunsMu    sync.Mutex
+	macroRunActive = make(map[string]int) // run ID -> devices still executing
+)
+
+// generateMacroRunID generates a unique macro run ID
+func generateMacroRunID() string {
+	return fmt.Sprintf("m%d", time.Now().UnixNano())
+}
+
+// normalizeMacro validates a macro definition and trims its fields.
+func normalizeMacro(req macroRequest) (Macro, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return Macro{}, fmt.Errorf("macro name cannot be empty")
+	}
+	if utf8.RuneCountInString(name) > maxMacroNameRunes || strings.ContainsAny(name, "/\\") {
+		return Macro{}, fmt.Errorf("invalid macro name")
+	}
+	if len(req.Steps) == 0 {
+		return Macro{}, fmt.Errorf("macro must have at least one step")
+	}
+	if len(req.Steps) > maxMacroSteps {
+		return Macro{}, fmt.Errorf("macro cannot have more than %d steps", maxMacroSteps)
+	}
+
+	steps := make([]MacroStep, len(req.Steps))
+	for i, step := range req.Steps {
+		step.Type = strings.TrimSpace(step.Type)
+		if step.Type == "" {
+			return Macro{}, fmt.Errorf("step %d: type is required", i+1)
+		}
+		if step.DelayMs < 0 || time.Duration(step.DelayMs)*time.Millisecond > maxMacroStepDelay {
+			return Macro{}, fmt.Errorf("step %d: delayMs must be between 0 and %d", i+1, maxMacroStepDelay.Milliseconds())
+		}
+		steps[i] = step
+	}
+
+	return Macro{Name: name, Steps: steps, UpdatedAt: time.Now().Unix()}, nil
+}
+
+func findMacroIndexLocked(name string) int {
+	for i, macro := range macros {
+		if macro.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func getMacro(name string) (Macro, bool) {
+	macrosMu.RLock()
+	defer macrosMu.RUnlock()
+	if i := findMacroIndexLocked(name); i >= 0 {
+		return cloneMacros(macros[i : i+1])[0], true
+	}
+	return Macro{}, false
+}
+
+func macroHasReboot(macro Macro) bool {
+	for _, step := range macro.Steps {
+		if isRebootCommand(step.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// macrosListHandler handles GET /api/macros
+func macrosListHandler(c *gin.Context) {
+	macrosMu.RLock()
+	defer macrosMu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{"macros": macros})
+}
+
+// macrosCreateHandler handles POST /api/macros
+func macrosCreateHandler(c *gin.Context) {
+	var req macroRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	macro, err := normalizeMacro(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	if findMacroIndexLocked(macro.Name) >= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Macro already exists"})
+		return
+	}
+	backup := cloneMacros(macros)
+	macros = append(macros, macro)
+	if err := saveMacrosSnapshot(macros); err != nil {
+		macros = backup
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save macros"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "macro": macro})
+}
+
+// macrosUpdateHandler handles PUT /api/macros/:name
+func macrosUpdateHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	var req macroRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = name
+	}
+	macro, err := normalizeMacro(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	i := findMacroIndexLocked(name)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Macro not found"})
+		return
+	}
+	if macro.Name != name && findMacroIndexLocked(macro.Name) >= 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Macro already exists"})
+		return
+	}
+	backup := cloneMacros(macros)
+	macros[i] = macro
+	if err := saveMacrosSnapshot(macros); err != nil {
+		macros = backup
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save macros"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "macro": macro})
+}
+
+// macrosDeleteHandler handles DELETE /api/macros/:name
+func macrosDeleteHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	macrosMu.Lock()
+	defer macrosMu.Unlock()
+	i := findMacroIndexLocked(name)
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Macro not found"})
+		return
+	}
+	backup := cloneMacros(macros)
+	macros = append(macros[:i:i], macros[i+1:]...)
+	if err := saveMacrosSnapshot(macros); err != nil {
+		macros = backup
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save macros"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// macrosRunHandler handles POST /api/macros/run
+func macrosRunHandler(c *gin.Context) {
+	var req macroRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	macro, ok := getMacro(strings.TrimSpace(req.Name))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Macro not found"})
+		return
+	}
+
+	devices := uniqueDeviceIDs(expandDevicesWithView(req.Devices, req.View))
+	if len(devices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "devices are required"})
+		return
+	}
+
+	if macroHasReboot(macro) {
+		if err := checkConfirmToken(confirmOpDeviceReboot, deviceRebootConfirmScope(devices), len(devices), req.ConfirmToken); err != nil {
+			respondConfirmationError(c, len(devices), err)
+			return
+		}
+	}
+
+	run := startMacroRun(macro, devices)
+	c.JSON(http.StatusOK, gin.H{"success": true, "run": run})
+}
+
+// macroRunStatusHandler handles GET /api/macros/runs/:id
+func macroRunStatusHandler(c *gin.Context) {
+	run, ok := getMacroRunSnapshot(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Macro run not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"run": run})
+}
+
+// startMacroRun registers a run report and executes the macro on every device in parallel.
+// The returned snapshot has all steps pending.
+func startMacroRun(macro Macro, devices []string) macroRun {
+	run := &macroRun{
+		ID:        generateMacroRunID(),
+		Macro:     macro.Name,
+		StartedAt: time.Now().Unix(),
+		Devices:   make(map[string][]macroStepResult, len(devices)),
+	}
+	for _, udid := range devices {
+		results := make([]macroStepResult, len(macro.Steps))
+		for i, step := range macro.Steps {
+			results[i] = macroStepResult{Step: i, Type: step.Type, Status: "pending"}
+		}
+		run.Devices[udid] = results
+	}
+
+	macroRunsMu.Lock()
+	macroRuns[run.ID] = run
+	macroRunOrder = append(macroRunOrder, run.ID)
+	for len(macroRunOrder) > maxMacroRuns {
+		delete(macroRuns, macroRunOrder[0])
+		delete(macroRunActive, macroRunOrder[0])
+		macroRunOrder = macroRunOrder[1:]
+	}
+	macroRunActive[run.ID] = len(devices)
+	snapshot := cloneMacroRunLocked(run)
+	macroRunsMu.Unlock()
+
+	slog.Info("Macro started", "macro", macro.Name, "devices", len(devices), "run", run.ID)
+	for _, udid := range devices {
+		go runMacroOnDevice(run, macro, udid)
+	}
+	return snapshot
+}
+
+// runMacroOnDevice sends each step to udid after its delay. Once a step cannot be sent
+// (device offline or write failure) the remaining steps are skipped.
+func runMacroOnDevice(run *macroRun, macro Macro, udid string) {
+	defer finishMacroRunDevice(run)
+
+	for i, step := range macro.Steps {
+		if step.DelayMs > 0 {
+			time.Sleep(time.Duration(step.DelayMs) * time.Millisecond)
+		}
+
+		mu.RLock()
+		conn, exists := deviceLinks[udid]
+		mu.RUnlock()
+
+		var err error
+		if !exists {
+			err = fmt.Errorf("device offline")
+		} else {
+			err = sendMessage(conn, Message{
+				Type:      step.Type,
+				Body:      step.Body,
+				RequestID: fmt.Sprintf("%s-%d", run.ID, i),
+			})
+		}
+
+		macroRunsMu.Lock()
+		results := run.Devices[udid]
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			for j := i + 1; j < len(results); j++ {
+				results[j].Status = "skipped"
+			}
+		} else {
+			results[i].Status = "sent"
+			results[i].SentAt = time.Now().UnixMilli()
+		}
+		macroRunsMu.Unlock()
+
+		if err != nil {
+			return
+		}
+		if name := getReadableCommandName(step.Type); name != "" {
+			broadcastDeviceMessage(udid, name)
+		}
+	}
+}
+
+func finishMacroRunDevice(run *macroRun) {
+	macroRunsMu.Lock()
+	active, tracked := macroRunActive[run.ID]
+	if !tracked {
+		// Pruned from the run history while still executing
+		macroRunsMu.Unlock()
+		return
+	}
+	remaining := active - 1
+	if remaining > 0 {
+		macroRunActive[run.ID] = remaining
+		macroRunsMu.Unlock()
+		return
+	}
+	delete(macroRunActive, run.ID)
+	run.FinishedAt = time.Now().Unix()
+	snapshot := cloneMacroRunLocked(run)
+	macroRunsMu.Unlock()
+
+	slog.Info("Macro finished", "macro", run.Macro, "run", run.ID)
+	broadcastControllerEvent("macro/run/finished", snapshot)
+}
+
+// cloneMacroRunLocked copies run for use outside macroRunsMu. Caller must hold macroRunsMu.
+func cloneMacroRunLocked(run *macroRun) macroRun {
+	out := *run
+	out.Devices = make(map[string][]macroStepResult, len(run.Devices))
+	for udid, results := range run.Devices {
+		out.Devices[udid] = append([]macroStepResult(nil), results...)
+	}
+	return out
+}
+
+func getMacroRunSnapshot(id string) (macroRun, bool) {
+	macroRunsMu.Lock()
+	defer macroRunsMu.Unlock()
+	run, ok := macroRuns[id]
+	if !ok {
+		return macroRun{}, false
+	}
+	return cloneMacroRunLocked(run), true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func setupMacrosFixture(t *testing.T) {
+	t.Helper()
+	setupPersistenceWritableDataDir(t)
+
+	macrosMu.Lock()
+	backup := macros
+	macros = make([]Macro, 0)
+	macrosMu.Unlock()
+	t.Cleanup(func() {
+		macrosMu.Lock()
+		macros = backup
+		macrosMu.Unlock()
+	})
+}
+
+func TestNormalizeMacro_Validation(t *testing.T) {
+	cases := []macroRequest{
+		{Name: " ", Steps: []MacroStep{{Type: "app/open"}}},
+		{Name: "a/b", Steps: []MacroStep{{Type: "app/open"}}},
+		{Name: "empty"},
+		{Name: "no-type", Steps: []MacroStep{{Type: " "}}},
+		{Name: "negative", Steps: []MacroStep{{Type: "app/open", DelayMs: -1}}},
+		{Name: "too-long", Steps: []MacroStep{{Type: "app/open", DelayMs: int(maxMacroStepDelay.Milliseconds()) + 1}}},
+	}
+	for _, req := range cases {
+		if _, err := normalizeMacro(req); err == nil {
+			t.Fatalf("expected %+v to be rejected", req)
+		}
+	}
+
+	macro, err := normalizeMacro(macroRequest{Name: " open ", Steps: []MacroStep{{Type: " app/open ", DelayMs: 500}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if macro.Name != "open" || macro.Steps[0].Type != "app/open" || macro.Steps[0].DelayMs != 500 {
+		t.Fatalf("unexpected macro: %+v", macro)
+	}
+}
+
+func TestMacrosCreateHandler_PersistsAndRejectsDuplicate(t *testing.T) {
+	setupMacrosFixture(t)
+
+	payload := map[string]any{
+		"name":  "open-and-shoot",
+		"steps": []map[string]any{{"type": "app/open", "body": map[string]any{"bid": "com.example"}}, {"type": "screen/snapshot", "delayMs": 1000}},
+	}
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/macros", payload, macrosCreateHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(getMacrosFilePath()); err != nil {
+		t.Fatalf("expected macros file to be written: %v", err)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/macros", payload, macrosCreateHandler)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate macro, got %d", w.Code)
+	}
+
+	macrosMu.Lock()
+	macros = make([]Macro, 0)
+	macrosMu.Unlock()
+	if err := loadMacros(); err != nil {
+		t.Fatalf("loadMacros failed: %v", err)
+	}
+	macro, ok := getMacro("open-and-shoot")
+	if !ok || len(macro.Steps) != 2 || macro.Steps[1].DelayMs != 1000 {
+		t.Fatalf("unexpected reloaded macro: %+v ok=%v", macro, ok)
+	}
+}
+
+func TestStartMacroRun_ReportsPerDeviceSteps(t *testing.T) {
+	deviceConn, client := newTestWebSocketPair(t)
+
+	mu.Lock()
+	linksBackup, controllersBackup := deviceLinks, controllers
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn}
+	controllers = map[*SafeConn]bool{}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceLinks, controllers = linksBackup, controllersBackup
+		mu.Unlock()
+	})
+
+	macro := Macro{Name: "m", Steps: []MacroStep{{Type: "app/open"}, {Type: "screen/snapshot", DelayMs: 10}}}
+	run := startMacroRun(macro, []string{"d1", "offline"})
+	if len(run.Devices) != 2 || run.Devices["d1"][0].Status != "pending" {
+		t.Fatalf("unexpected initial run: %+v", run)
+	}
+
+	for i, wantType := range []string{"app/open", "screen/snapshot"} {
+		msg := readTestMessage(t, client)
+		if msg.Type != wantType || msg.RequestID != fmt.Sprintf("%s-%d", run.ID, i) {
+			t.Fatalf("unexpected step %d message: %#v", i, msg)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		snapshot, ok := getMacroRunSnapshot(run.ID)
+		if !ok {
+			t.Fatalf("run %s not found", run.ID)
+		}
+		if snapshot.FinishedAt != 0 {
+			if got := snapshot.Devices["d1"]; got[0].Status != "sent" || got[1].Status != "sent" {
+				t.Fatalf("unexpected online device results: %+v", got)
+			}
+			if got := snapshot.Devices["offline"]; got[0].Status != "failed" || got[0].Error != "device offline" || got[1].Status != "skipped" {
+				t.Fatalf("unexpected offline device results: %+v", got)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("macro run did not finish: %+v", snapshot)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
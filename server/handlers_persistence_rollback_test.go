@@ -18,9 +18,9 @@ func setupPersistenceWritableDataDir(t *testing.T) string {
 	gin.SetMode(gin.TestMode)
 
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	return dataDir
 }
@@ -34,7 +34,7 @@ func setupPersistenceBrokenDataDir(t *testing.T) string {
 		t.Fatalf("create broken data dir marker failed: %v", err)
 	}
 
-	serverConfig.DataDir = brokenPath
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = brokenPath })
 	return brokenPath
 }
 
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// generateScheduledCommandID generates a unique scheduled command ID
+func generateScheduledCommandID() string {
+	return fmt.Sprintf("s%d", time.Now().UnixNano())
+}
+
+type scheduleOnceRequest struct {
+	At           json.RawMessage `json:"at"`
+	Devices      []string        `json:"devices"`
+	View         string          `json:"view"`
+	Type         string          `json:"type"`
+	Body         interface{}     `json:"body"`
+	ConfirmToken string          `json:"confirmToken"`
+}
+
+// parseScheduleTime accepts a Unix timestamp (seconds or milliseconds) or an RFC3339 string.
+func parseScheduleTime(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, fmt.Errorf("at is required")
+	}
+
+	var ts float64
+	if err := json.Unmarshal(raw, &ts); err == nil {
+		if ts <= 0 {
+			return time.Time{}, fmt.Errorf("invalid at")
+		}
+		return time.Unix(normalizeUnixSeconds(int64(ts)), 0), nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return time.Time{}, fmt.Errorf("invalid at")
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid at, expected unix timestamp or RFC3339 time")
+	}
+	return t, nil
+}
+
+// armScheduledCommandLocked starts the timer for cmd. Overdue commands (e.g. missed
+// while the server was down) fire immediately. Caller must hold scheduledCommandsMu.
+func armScheduledCommandLocked(cmd ScheduledCommand) {
+	if existing, ok := scheduledCommandTimers[cmd.ID]; ok {
+		existing.Stop()
+	}
+	delay := time.Until(time.Unix(cmd.At, 0))
+	if delay < 0 {
+		delay = 0
+	}
+	id := cmd.ID
+	scheduledCommandTimers[id] = time.AfterFunc(delay, func() {
+		fireScheduledCommand(id)
+	})
+}
+
+// armScheduledCommands starts timers for all loaded scheduled commands
+func armScheduledCommands() {
+	scheduledCommandsMu.Lock()
+	defer scheduledCommandsMu.Unlock()
+	for _, cmd := range scheduledCommands {
+		armScheduledCommandLocked(cmd)
+	}
+}
+
+// stopScheduledCommandTimers stops all pending timers without touching the stored commands
+func stopScheduledCommandTimers() {
+	scheduledCommandsMu.Lock()
+	defer scheduledCommandsMu.Unlock()
+	for id, timer := range scheduledCommandTimers {
+		timer.Stop()
+		delete(scheduledCommandTimers, id)
+	}
+}
+
+// fireScheduledCommand removes the command from the schedule and sends it to its devices.
+// The command is removed before sending so a persistence failure can never fire it twice.
+func fireScheduledCommand(id string) {
+	scheduledCommandsMu.Lock()
+	delete(scheduledCommandTimers, id)
+
+	var (
+		cmd   ScheduledCommand
+		found bool
+	)
+	remaining := make([]ScheduledCommand, 0, len(scheduledCommands))
+	for _, c := range scheduledCommands {
+		if c.ID == id {
+			cmd = c
+			found = true
+			continue
+		}
+		remaining = append(remaining, c)
+	}
+	if !found {
+		scheduledCommandsMu.Unlock()
+		return
+	}
+	scheduledCommands = remaining
+	if err := saveScheduledCommandsSnapshot(scheduledCommands); err != nil {
+		slog.Warn("Failed to save scheduled commands after firing", "id", id, "error", err)
+	}
+	scheduledCommandsMu.Unlock()
+
+	sent, err := dispatchCommandToDevices(cmd.Devices, cmd.Type, cmd.Body, "", "schedule:"+id)
+	if err != nil {
+		slog.Error("Scheduled command failed", "id", id, "type", cmd.Type, "error", err)
+		return
+	}
+	slog.Info("Scheduled command sent", "id", id, "type", cmd.Type, "sent", sent, "devices", len(cmd.Devices))
+}
+
+// scheduleOnceHandler handles POST /api/commands/schedule-once
+func scheduleOnceHandler(c *gin.Context) {
+	var req scheduleOnceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	at, err := parseScheduleTime(req.At)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !at.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at must be in the future"})
+		return
+	}
+
+	cmdType := strings.TrimSpace(req.Type)
+	if cmdType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type is required"})
+		return
+	}
+
+	devices := uniqueDeviceIDs(expandDevicesWithView(req.Devices, req.View))
+	if len(devices) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "devices are required"})
+		return
+	}
+
+	if isRebootCommand(cmdType) {
+		if err := checkConfirmToken(confirmOpDeviceReboot, deviceRebootConfirmScope(devices), len(devices), req.ConfirmToken); err != nil {
+			respondConfirmationError(c, len(devices), err)
+			return
+		}
+	}
+
+	cmd := ScheduledCommand{
+		ID:        generateScheduledCommandID(),
+		At:        at.Unix(),
+		Devices:   devices,
+		Type:      cmdType,
+		Body:      req.Body,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	scheduledCommandsMu.Lock()
+	backup := cloneScheduledCommands(scheduledCommands)
+	scheduledCommands = append(scheduledCommands, cmd)
+	if err := saveScheduledCommandsSnapshot(scheduledCommands); err != nil {
+		scheduledCommands = backup
+		scheduledCommandsMu.Unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save scheduled commands"})
+		return
+	}
+	armScheduledCommandLocked(cmd)
+	scheduledCommandsMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "command": cmd})
+}
+
+// scheduledCommandsListHandler handles GET /api/commands/scheduled
+func scheduledCommandsListHandler(c *gin.Context) {
+	scheduledCommandsMu.Lock()
+	commands := cloneScheduledCommands(scheduledCommands)
+	scheduledCommandsMu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"commands": commands})
+}
+
+// scheduledCommandCancelHandler handles DELETE /api/commands/scheduled/:id
+func scheduledCommandCancelHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	scheduledCommandsMu.Lock()
+	defer scheduledCommandsMu.Unlock()
+
+	backup := cloneScheduledCommands(scheduledCommands)
+	found := false
+	remaining := make([]ScheduledCommand, 0, len(scheduledCommands))
+	for _, cmd := range scheduledCommands {
+		if cmd.ID == id {
+			found = true
+			continue
+		}
+		remaining = append(remaining, cmd)
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled command not found"})
+		return
+	}
+
+	scheduledCommands = remaining
+	if err := saveScheduledCommandsSnapshot(scheduledCommands); err != nil {
+		scheduledCommands = backup
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save scheduled commands"})
+		return
+	}
+	if timer, ok := scheduledCommandTimers[id]; ok {
+		timer.Stop()
+		delete(scheduledCommandTimers, id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
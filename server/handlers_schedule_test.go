@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func setupScheduledCommandsFixture(t *testing.T) {
+	t.Helper()
+	setupPersistenceWritableDataDir(t)
+
+	scheduledCommandsMu.Lock()
+	backup := cloneScheduledCommands(scheduledCommands)
+	scheduledCommands = make([]ScheduledCommand, 0)
+	scheduledCommandsMu.Unlock()
+
+	t.Cleanup(func() {
+		stopScheduledCommandTimers()
+		scheduledCommandsMu.Lock()
+		scheduledCommands = backup
+		scheduledCommandsMu.Unlock()
+	})
+}
+
+func TestParseScheduleTime(t *testing.T) {
+	if got, err := parseScheduleTime(json.RawMessage(`1700000000000`)); err != nil || got.Unix() != 1700000000 {
+		t.Fatalf("expected millisecond timestamp to parse, got %v err=%v", got, err)
+	}
+	if got, err := parseScheduleTime(json.RawMessage(`"2023-11-14T22:13:20Z"`)); err != nil || got.Unix() != 1700000000 {
+		t.Fatalf("expected RFC3339 time to parse, got %v err=%v", got, err)
+	}
+	if _, err := parseScheduleTime(json.RawMessage(`"tonight"`)); err == nil {
+		t.Fatalf("expected invalid time to fail")
+	}
+	if _, err := parseScheduleTime(nil); err == nil {
+		t.Fatalf("expected missing time to fail")
+	}
+}
+
+func TestScheduleOnce_FiresOnceAndRemovesJob(t *testing.T) {
+	setupScheduledCommandsFixture(t)
+	deviceConn, client := newTestWebSocketPair(t)
+
+	mu.Lock()
+	linksBackup := deviceLinks
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceLinks = linksBackup
+		mu.Unlock()
+	})
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/commands/schedule-once", map[string]any{
+		"at":      time.Now().Add(time.Hour).Unix(),
+		"devices": []string{"d1", "d2"},
+		"type":    "script/run",
+		"body":    map[string]any{"name": "main.lua"},
+	}, scheduleOnceHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Command ScheduledCommand `json:"command"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	data, err := os.ReadFile(getScheduledCommandsFilePath())
+	if err != nil {
+		t.Fatalf("expected scheduled commands file to be written: %v", err)
+	}
+	var persisted []ScheduledCommand
+	if err := json.Unmarshal(data, &persisted); err != nil || len(persisted) != 1 {
+		t.Fatalf("expected 1 persisted command, got %v err=%v", persisted, err)
+	}
+
+	fireScheduledCommand(created.Command.ID)
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "script/run" {
+		t.Fatalf("expected script/run to be sent, got %q", msg.Type)
+	}
+
+	scheduledCommandsMu.Lock()
+	remaining := len(scheduledCommands)
+	_, timerLeft := scheduledCommandTimers[created.Command.ID]
+	scheduledCommandsMu.Unlock()
+	if remaining != 0 || timerLeft {
+		t.Fatalf("expected fired command to be removed, remaining=%d timer=%t", remaining, timerLeft)
+	}
+
+	w = performJSONRequestWithGroupID(t, http.MethodDelete, "/api/commands/scheduled/"+created.Command.ID, created.Command.ID, nil, scheduledCommandCancelHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected fired command to be gone, got %d", w.Code)
+	}
+}
+
+func TestScheduleOnce_RejectsPastTimeAndCancels(t *testing.T) {
+	setupScheduledCommandsFixture(t)
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/commands/schedule-once", map[string]any{
+		"at":      time.Now().Add(-time.Minute).Unix(),
+		"devices": []string{"d1"},
+		"type":    "script/run",
+	}, scheduleOnceHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for past time, got %d", w.Code)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/commands/schedule-once", map[string]any{
+		"at":      time.Now().Add(time.Hour).Format(time.RFC3339),
+		"devices": []string{"d1"},
+		"type":    "script/stop",
+	}, scheduleOnceHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Command ScheduledCommand `json:"command"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	w = performJSONRequestWithGroupID(t, http.MethodDelete, "/api/commands/scheduled/"+created.Command.ID, created.Command.ID, nil, scheduledCommandCancelHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	scheduledCommandsMu.Lock()
+	remaining := len(scheduledCommands)
+	timers := len(scheduledCommandTimers)
+	scheduledCommandsMu.Unlock()
+	if remaining != 0 || timers != 0 {
+		t.Fatalf("expected cancelled command and timer to be removed, remaining=%d timers=%d", remaining, timers)
+	}
+}
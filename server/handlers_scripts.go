@@ -5,7 +5,9 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -20,7 +22,6 @@ import (
 )
 
 const (
-	scriptLargeFileThreshold = 128 * 1024
 	scriptPackageCacheMax    = 64
 	scriptPackageCacheTrimTo = 48
 )
@@ -41,6 +42,7 @@ type scriptFileData struct {
 	NormalizedPath string
 	SourcePath     string
 	Data           string
+	GzipData       string // base64 of gzip-compressed content, "" when not smaller than Data
 	Size           int64
 	IsMainJSON     bool
 }
@@ -367,6 +369,7 @@ func startScriptOnDevice(
 			failScriptStartSession(deviceID, generation, "脚本启动失败: 发送启动命令失败")
 			return
 		}
+		setRunningScript(deviceID, runName, true)
 		if !clearScriptStartSessionIfGeneration(deviceID, generation) {
 			return
 		}
@@ -492,33 +495,19 @@ func hasPendingScriptStart(deviceID string) bool {
 }
 
 func handleTransferFetchCompletionForScriptStart(deviceID string, body interface{}) {
-	bodyMap, ok := body.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	requestID, _ := bodyMap["requestId"].(string)
-	if strings.TrimSpace(requestID) == "" {
-		requestID, _ = bodyMap["requestID"].(string)
+	if result, ok := parseTransferFetchResult(body); ok {
+		completeScriptStartFetch(deviceID, result)
 	}
-	requestID = strings.TrimSpace(requestID)
-
-	targetPath, _ := bodyMap["targetPath"].(string)
-	targetPath = strings.TrimSpace(targetPath)
+}
 
-	success := false
-	switch value := bodyMap["success"].(type) {
-	case bool:
-		success = value
-	case string:
-		success = strings.EqualFold(value, "true")
-	case float64:
-		success = value != 0
-	}
+// completeScriptStartFetch is the script-start consumer of transfer/fetch/complete: it
+// records the sent file and starts the script once its last large file has arrived.
+func completeScriptStartFetch(deviceID string, result transferFetchResult) {
+	requestID, targetPath := result.RequestID, result.TargetPath
+	success, errMsg := result.Success, result.Error
 
-	errMsg := ""
-	if value, ok := bodyMap["error"].(string); ok {
-		errMsg = value
+	if requestID != "" {
+		completeDeviceSentFetch(deviceID, requestID, success)
 	}
 
 	var (
@@ -595,13 +584,32 @@ func cloneScriptFileDataSlice(src []scriptFileData) []scriptFileData {
 	return dst
 }
 
+// scriptPackageCacheKey includes the large-file threshold because cached files embed the
+// inline/transfer split, so changing largeFileThresholdBytes misses old entries.
 func scriptPackageCacheKey(scriptRootPath string, scriptName string, isDir bool, isPiled bool) string {
-	return fmt.Sprintf("%s|%s|%t|%t", scriptRootPath, scriptName, isDir, isPiled)
+	return fmt.Sprintf("%s|%s|%t|%t|%d", scriptRootPath, scriptName, isDir, isPiled, largeFileThreshold())
 }
 
 // walkScriptFiles visits files under scriptRootPath.
 // Directory symlinks are skipped; file symlinks are treated as files (using resolved metadata).
 func walkScriptFiles(scriptRootPath string, visit func(path string, info os.FileInfo) error) error {
+	return walkScriptFilesWithErrorHandler(scriptRootPath, visit, nil)
+}
+
+// walkScriptFilesWithErrorHandler is walkScriptFiles with control over per-entry errors.
+// When onError is nil the walk fails fast; otherwise returning nil from onError skips the entry.
+func walkScriptFilesWithErrorHandler(
+	scriptRootPath string,
+	visit func(path string, info os.FileInfo) error,
+	onError func(path string, err error) error,
+) error {
+	handleErr := func(path string, err error) error {
+		if onError == nil {
+			return err
+		}
+		return onError(path, err)
+	}
+
 	rootInfo, err := os.Stat(scriptRootPath)
 	if err != nil {
 		return err
@@ -614,20 +622,29 @@ func walkScriptFiles(scriptRootPath string, visit func(path string, info os.File
 	walkDir = func(dirPath string) error {
 		entries, readErr := os.ReadDir(dirPath)
 		if readErr != nil {
-			return readErr
+			if dirPath == scriptRootPath {
+				return readErr
+			}
+			return handleErr(dirPath, readErr)
 		}
 
 		for _, entry := range entries {
 			entryPath := filepath.Join(dirPath, entry.Name())
 			lstatInfo, lstatErr := os.Lstat(entryPath)
 			if lstatErr != nil {
-				return lstatErr
+				if err := handleErr(entryPath, lstatErr); err != nil {
+					return err
+				}
+				continue
 			}
 
 			if lstatInfo.Mode()&os.ModeSymlink != 0 {
 				resolvedInfo, statErr := os.Stat(entryPath)
 				if statErr != nil {
-					return statErr
+					if err := handleErr(entryPath, statErr); err != nil {
+						return err
+					}
+					continue
 				}
 				if resolvedInfo.IsDir() {
 					// Skip nested directory symlinks to avoid traversing outside trees.
@@ -659,7 +676,7 @@ func walkScriptFiles(scriptRootPath string, visit func(path string, info os.File
 
 // buildScriptSourceSignature computes a content signature using relative path + size + mtime.
 // It avoids reading full file contents, and is used for cache invalidation.
-func buildScriptSourceSignature(scriptRootPath string, isDir bool) (string, error) {
+func buildScriptSourceSignature(scriptRootPath string, isDir bool, skipUnreadable bool) (string, error) {
 	signatureHash := sha256.New()
 	writePart := func(value string) {
 		_, _ = signatureHash.Write([]byte(value))
@@ -677,7 +694,11 @@ func buildScriptSourceSignature(scriptRootPath string, isDir bool) (string, erro
 		return hex.EncodeToString(signatureHash.Sum(nil)), nil
 	}
 
-	walkErr := walkScriptFiles(scriptRootPath, func(path string, info os.FileInfo) error {
+	var onError func(path string, err error) error
+	if skipUnreadable {
+		onError = func(path string, err error) error { return nil }
+	}
+	walkErr := walkScriptFilesWithErrorHandler(scriptRootPath, func(path string, info os.FileInfo) error {
 		relPath, relErr := filepath.Rel(scriptRootPath, path)
 		if relErr != nil {
 			return relErr
@@ -686,7 +707,7 @@ func buildScriptSourceSignature(scriptRootPath string, isDir bool) (string, erro
 		writePart(strconv.FormatInt(info.Size(), 10))
 		writePart(strconv.FormatInt(info.ModTime().UnixNano(), 10))
 		return nil
-	})
+	}, onError)
 	if walkErr != nil {
 		return "", walkErr
 	}
@@ -712,9 +733,16 @@ func trimScriptPackageCacheLocked() {
 }
 
 func collectScriptFilesCached(scriptRootPath string, scriptName string, isDir bool, isPiled bool) ([]scriptFileData, error) {
-	signature, err := buildScriptSourceSignature(scriptRootPath, isDir)
+	filesToSend, _, err := collectScriptPackageCached(scriptRootPath, scriptName, isDir, isPiled, false)
+	return filesToSend, err
+}
+
+// collectScriptPackageCached is collectScriptFilesCached with optional skipping of unreadable files.
+// Packages that skipped files are not cached so a later send retries reading them.
+func collectScriptPackageCached(scriptRootPath string, scriptName string, isDir bool, isPiled bool, skipUnreadable bool) ([]scriptFileData, []skippedScriptFile, error) {
+	signature, err := buildScriptSourceSignature(scriptRootPath, isDir, skipUnreadable)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	cacheKey := scriptPackageCacheKey(scriptRootPath, scriptName, isDir, isPiled)
@@ -722,12 +750,15 @@ func collectScriptFilesCached(scriptRootPath string, scriptName string, isDir bo
 	entry, ok := scriptPackageCache.entries[cacheKey]
 	scriptPackageCache.RUnlock()
 	if ok && entry.signature == signature {
-		return cloneScriptFileDataSlice(entry.files), nil
+		return cloneScriptFileDataSlice(entry.files), nil, nil
 	}
 
-	filesToSend, err := collectScriptFiles(scriptRootPath, scriptName, isDir, isPiled)
+	filesToSend, skipped, err := collectScriptPackage(scriptRootPath, scriptName, isDir, isPiled, skipUnreadable)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if len(skipped) > 0 {
+		return filesToSend, skipped, nil
 	}
 
 	scriptPackageCache.Lock()
@@ -738,7 +769,7 @@ func collectScriptFilesCached(scriptRootPath string, scriptName string, isDir bo
 	}
 	scriptPackageCache.Unlock()
 
-	return filesToSend, nil
+	return filesToSend, nil, nil
 }
 
 func getSelectableScriptPath(basePath string, name string, isDir bool) (string, bool) {
@@ -770,16 +801,45 @@ func getSelectableScriptPath(basePath string, name string, isDir bool) (string,
 	return "", false
 }
 
+// skippedScriptFile records a file left out of a script package because it could not be read.
+type skippedScriptFile struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+func newSkippedScriptFile(scriptRootPath string, path string, err error) skippedScriptFile {
+	relPath, relErr := filepath.Rel(scriptRootPath, path)
+	if relErr != nil {
+		relPath = filepath.Base(path)
+	}
+	reason := err.Error()
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		reason = pathErr.Err.Error()
+	}
+	return skippedScriptFile{Path: normalizeScriptPath(relPath), Error: reason}
+}
+
 func collectScriptFiles(scriptRootPath string, scriptName string, isDir bool, isPiled bool) ([]scriptFileData, error) {
+	filesToSend, _, err := collectScriptPackage(scriptRootPath, scriptName, isDir, isPiled, false)
+	return filesToSend, err
+}
+
+// collectScriptPackage collects script files; when skipUnreadable is set, files and
+// directories that cannot be read are recorded in the skipped list instead of failing.
+func collectScriptPackage(scriptRootPath string, scriptName string, isDir bool, isPiled bool, skipUnreadable bool) ([]scriptFileData, []skippedScriptFile, error) {
 	filesToSend := make([]scriptFileData, 0)
+	var skipped []skippedScriptFile
+	threshold := largeFileThreshold()
 
-	appendFile := func(targetPath string, sourcePath string, size int64, encodedData string) {
+	appendFile := func(targetPath string, sourcePath string, size int64, encodedData string, gzipData string) {
 		normalizedPath := normalizeScriptPath(targetPath)
 		filesToSend = append(filesToSend, scriptFileData{
 			Path:           targetPath,
 			NormalizedPath: normalizedPath,
 			SourcePath:     sourcePath,
 			Data:           encodedData,
+			GzipData:       gzipData,
 			Size:           size,
 			IsMainJSON:     isMainJSONPath(normalizedPath),
 		})
@@ -788,20 +848,30 @@ func collectScriptFiles(scriptRootPath string, scriptName string, isDir bool, is
 	if !isDir {
 		content, err := os.ReadFile(scriptRootPath)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		fileSize := int64(len(content))
 		encodedData := ""
-		if fileSize < scriptLargeFileThreshold {
+		gzipData := ""
+		if fileSize < threshold {
 			encodedData = base64.StdEncoding.EncodeToString(content)
+			gzipData = encodeScriptFileGzip(content, len(encodedData))
 		}
 
-		appendFile("lua/scripts/"+scriptName, scriptRootPath, fileSize, encodedData)
-		return filesToSend, nil
+		appendFile("lua/scripts/"+scriptName, scriptRootPath, fileSize, encodedData, gzipData)
+		return filesToSend, nil, nil
+	}
+
+	var onError func(path string, err error) error
+	if skipUnreadable {
+		onError = func(path string, err error) error {
+			skipped = append(skipped, newSkippedScriptFile(scriptRootPath, path, err))
+			return nil
+		}
 	}
 
-	walkErr := walkScriptFiles(scriptRootPath, func(path string, info os.FileInfo) error {
+	walkErr := walkScriptFilesWithErrorHandler(scriptRootPath, func(path string, info os.FileInfo) error {
 		relPath, _ := filepath.Rel(scriptRootPath, path)
 		normalizedRelPath := normalizeScriptPath(relPath)
 
@@ -812,23 +882,35 @@ func collectScriptFiles(scriptRootPath string, scriptName string, isDir bool, is
 
 		fileSize := info.Size()
 		encodedData := ""
-		if fileSize < scriptLargeFileThreshold {
+		gzipData := ""
+		if fileSize < threshold {
 			content, readErr := os.ReadFile(path)
 			if readErr != nil {
+				if onError != nil {
+					return onError(path, readErr)
+				}
 				return readErr
 			}
 			encodedData = base64.StdEncoding.EncodeToString(content)
+			gzipData = encodeScriptFileGzip(content, len(encodedData))
+		} else if skipUnreadable {
+			// Large files are streamed later; probe readability now so they are skipped up front.
+			file, openErr := os.Open(path)
+			if openErr != nil {
+				return onError(path, openErr)
+			}
+			file.Close()
 		}
 
-		appendFile(targetPath, path, fileSize, encodedData)
+		appendFile(targetPath, path, fileSize, encodedData, gzipData)
 		return nil
-	})
+	}, onError)
 
 	if walkErr != nil {
-		return nil, walkErr
+		return nil, nil, walkErr
 	}
 
-	return filesToSend, nil
+	return filesToSend, skipped, nil
 }
 
 func calculateLargeFileMD5(filesToSend []scriptFileData) map[string]md5Result {
@@ -843,7 +925,7 @@ func calculateLargeFileMD5(filesToSend []scriptFileData) map[string]md5Result {
 
 		md5Hash, err := calculateFileMD5Cached(f.SourcePath, nil)
 		if err != nil {
-			fmt.Printf("❌ Failed to calculate MD5 for %s: %v\n", f.SourcePath, err)
+			slog.Error("Failed to calculate MD5", "path", f.SourcePath, "error", err)
 			largeFileMD5[f.SourcePath] = md5Result{err: err}
 			continue
 		}
@@ -895,7 +977,7 @@ func resolveEntryIsDir(basePath string, entry os.DirEntry) bool {
 // Returns a list of scripts with name (display name) and path (actual script to select)
 // For piled scripts, path is "main.lua" or "main.xxt" depending on entry point
 func selectableScriptsHandler(c *gin.Context) {
-	scriptsDir := filepath.Join(serverConfig.DataDir, "scripts")
+	scriptsDir := filepath.Join(getServerConfig().DataDir, "scripts")
 
 	if _, err := os.Stat(scriptsDir); os.IsNotExist(err) {
 		c.JSON(http.StatusOK, gin.H{"scripts": []gin.H{}})
@@ -1060,6 +1142,23 @@ type scriptSendRequest struct {
 	Name           string   `json:"name"`
 	SelectedGroups []string `json:"selectedGroups"`
 	ServerBaseUrl  string   `json:"serverBaseUrl"`
+	ForceResend    bool     `json:"forceResend"` // send-and-start: ignore the per-device sent manifest
+	// DeviceArgs maps UDID -> runtime arguments passed as script/run "args" (send-and-start only).
+	DeviceArgs map[string][]string `json:"deviceArgs"`
+}
+
+// buildScriptRunPayload marshals the script/run message, adding args when non-empty.
+func buildScriptRunPayload(runName string, args []string) ([]byte, error) {
+	body := gin.H{
+		"name": runName,
+	}
+	if len(args) > 0 {
+		body["args"] = args
+	}
+	return json.Marshal(Message{
+		Type: "script/run",
+		Body: body,
+	})
 }
 
 // buildMergedMainJSON merges a group config into a main.json template,
@@ -1098,11 +1197,13 @@ type scriptFileSender struct {
 	deviceConfigIndex map[string]map[string]interface{}
 
 	basePutPayloadCache   map[string][]byte
+	gzipPutPayloadCache   map[string][]byte
 	mergedPutPayloadCache map[string][]byte
 	groupConfigKeyCache   map[uintptr]string
 	groupConfigKeySeq     int
 	mainJSONTemplates     map[string]map[string]interface{}
 	mainJSONParsed        map[string]bool
+	payloadFingerprints   map[string]string
 }
 
 func newScriptFileSender(files []scriptFileData, configIndex map[string]map[string]interface{}) *scriptFileSender {
@@ -1110,10 +1211,12 @@ func newScriptFileSender(files []scriptFileData, configIndex map[string]map[stri
 		files:                 files,
 		deviceConfigIndex:     configIndex,
 		basePutPayloadCache:   make(map[string][]byte, len(files)),
+		gzipPutPayloadCache:   make(map[string][]byte),
 		mergedPutPayloadCache: make(map[string][]byte),
 		groupConfigKeyCache:   make(map[uintptr]string),
 		mainJSONTemplates:     make(map[string]map[string]interface{}),
 		mainJSONParsed:        make(map[string]bool),
+		payloadFingerprints:   make(map[string]string),
 	}
 }
 
@@ -1149,28 +1252,41 @@ func (s *scriptFileSender) parseMainJSONTemplate(pathKey string, encoded string)
 	return mainObj
 }
 
-// sendSmallFile sends a single small file (f.Data != "") to conn, applying config merge if needed.
-func (s *scriptFileSender) sendSmallFile(conn *SafeConn, f scriptFileData, groupConfig map[string]interface{}, configKey string) {
+// smallFilePayload returns the file/put payload of a small file (f.Data != ""), applying
+// config merge if needed, plus the cache key it is stored under. With useGzip, files that
+// compress well are sent gzip+base64 encoded (merged main.json is always plain).
+func (s *scriptFileSender) smallFilePayload(f scriptFileData, groupConfig map[string]interface{}, configKey string, useGzip bool) ([]byte, string, bool) {
+	if useGzip && f.GzipData != "" && (!f.IsMainJSON || groupConfig == nil) {
+		payload, ok := s.gzipPutPayloadCache[f.Path]
+		if !ok {
+			encoded, buildErr := buildGzipFilePutPayload(f.Path, f.GzipData)
+			if buildErr != nil {
+				return nil, "", false
+			}
+			payload = encoded
+			s.gzipPutPayloadCache[f.Path] = payload
+		}
+		return payload, "gzip|" + f.Path, true
+	}
+
 	if !f.IsMainJSON || groupConfig == nil {
 		payload, ok := s.basePutPayloadCache[f.Path]
 		if !ok {
 			encoded, buildErr := buildFilePutPayload(f.Path, f.Data)
 			if buildErr != nil {
-				return
+				return nil, "", false
 			}
 			payload = encoded
 			s.basePutPayloadCache[f.Path] = payload
 		}
-		writeTextMessageAsync(conn, payload)
-		return
+		return payload, f.Path, true
 	}
 
 	cacheKey := ""
 	if configKey != "" {
 		cacheKey = f.NormalizedPath + "|" + configKey
 		if cachedPayload, ok := s.mergedPutPayloadCache[cacheKey]; ok {
-			writeTextMessageAsync(conn, cachedPayload)
-			return
+			return cachedPayload, cacheKey, true
 		}
 	}
 
@@ -1182,24 +1298,55 @@ func (s *scriptFileSender) sendSmallFile(conn *SafeConn, f scriptFileData, group
 
 	payload, buildErr := buildFilePutPayload(f.Path, finalData)
 	if buildErr != nil {
-		return
+		return nil, "", false
 	}
 	if cacheKey != "" {
 		s.mergedPutPayloadCache[cacheKey] = payload
 	}
-	writeTextMessageAsync(conn, payload)
+	return payload, cacheKey, true
+}
+
+// payloadFingerprint returns the MD5 of a file/put payload, cached by its payload cache key.
+func (s *scriptFileSender) payloadFingerprint(cacheKey string, payload []byte) string {
+	if cacheKey == "" {
+		return smallFileFingerprint(payload)
+	}
+	if fingerprint, ok := s.payloadFingerprints[cacheKey]; ok {
+		return fingerprint
+	}
+	fingerprint := smallFileFingerprint(payload)
+	s.payloadFingerprints[cacheKey] = fingerprint
+	return fingerprint
 }
 
-// sendSmallFilesToConn sends all small files to a specific device connection.
-func (s *scriptFileSender) sendSmallFilesToConn(conn *SafeConn, udid string) {
+// sendSmallFilesToConn sends all small files to a specific device connection. With dedup,
+// files already sent unchanged to udid are skipped; it returns how many were skipped.
+func (s *scriptFileSender) sendSmallFilesToConn(conn *SafeConn, udid string, dedup bool) int {
 	groupConfig := s.deviceConfigIndex[udid]
 	configKey := s.groupConfigKey(groupConfig)
+	useGzip := scriptGzipEnabledForDevice(udid)
+	unchanged := 0
 	for _, f := range s.files {
 		if f.Data == "" {
 			continue
 		}
-		s.sendSmallFile(conn, f, groupConfig, configKey)
+		payload, cacheKey, ok := s.smallFilePayload(f, groupConfig, configKey, useGzip)
+		if !ok {
+			continue
+		}
+		if !dedup {
+			writeTextMessageBulk(conn, payload)
+			continue
+		}
+		fingerprint := s.payloadFingerprint(cacheKey, payload)
+		if deviceHasSentFile(udid, f.Path, fingerprint) {
+			unchanged++
+			continue
+		}
+		writeTextMessageBulk(conn, payload)
+		recordDeviceSentFile(udid, f.Path, fingerprint)
 	}
+	return unchanged
 }
 
 // scriptsSendHandler handles POST /api/scripts/send
@@ -1244,7 +1391,7 @@ func scriptsSendHandler(c *gin.Context) {
 		}
 	}
 
-	filesToSend, err := collectScriptFilesCached(scriptPath, scriptName, isDir, isPiled)
+	filesToSend, skippedFiles, err := collectScriptPackageCached(scriptPath, scriptName, isDir, isPiled, getServerConfig().ScriptSkipUnreadable)
 	if err != nil {
 		errorMsg := "failed to read script directory"
 		if !isDir {
@@ -1265,7 +1412,7 @@ func scriptsSendHandler(c *gin.Context) {
 		if conn, exists := deviceConns[udid]; exists {
 			broadcastDeviceMessage(udid, fmt.Sprintf("上传脚本 (%d小文件, %d大文件)", smallFilesCount, largeFilesCount))
 
-			sender.sendSmallFilesToConn(conn, udid)
+			sender.sendSmallFilesToConn(conn, udid, false)
 
 			for _, f := range filesToSend {
 				if f.Data != "" {
@@ -1281,6 +1428,7 @@ func scriptsSendHandler(c *gin.Context) {
 				md5Hash := md5Info.hash
 
 				token := uuid.New().String()
+				chunkSize := resolveTransferChunkSize(udid, 0)
 				transferTokensMu.Lock()
 				transferTokens[token] = &TransferToken{
 					Type:       "download",
@@ -1291,6 +1439,7 @@ func scriptsSendHandler(c *gin.Context) {
 					OneTime:    true,
 					TotalBytes: f.Size,
 					MD5:        md5Hash,
+					ChunkSize:  chunkSize,
 				}
 				transferTokensMu.Unlock()
 
@@ -1304,20 +1453,131 @@ func scriptsSendHandler(c *gin.Context) {
 						"md5":        md5Hash,
 						"totalBytes": f.Size,
 						"timeout":    300,
+						"chunkSize":  chunkSize,
 					},
 				}
 				fetchPayload, marshalErr := json.Marshal(fetchMsg)
 				if marshalErr != nil {
 					continue
 				}
-				writeTextMessageAsync(conn, fetchPayload)
+				writeTextMessageBulk(conn, fetchPayload)
 			}
 
 			broadcastDeviceMessage(udid, "脚本已上传")
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "files_sent": len(filesToSend)})
+	recordAudit(auditEntry{RemoteAddr: c.ClientIP(), Type: "scripts/send", Devices: req.Devices, Detail: scriptName})
+	response := gin.H{"success": true, "files_sent": len(filesToSend)}
+	if len(skippedFiles) > 0 {
+		response["skipped_files"] = skippedFiles
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+type scriptManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Method string `json:"method"` // file/put or transfer/fetch
+	Large  bool   `json:"large"`
+	MD5    string `json:"md5,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// buildScriptManifest describes how each file of a package would be sent.
+func buildScriptManifest(filesToSend []scriptFileData) ([]scriptManifestEntry, int64) {
+	largeFileMD5 := calculateLargeFileMD5(filesToSend)
+
+	entries := make([]scriptManifestEntry, 0, len(filesToSend))
+	var totalBytes int64
+	for _, f := range filesToSend {
+		entry := scriptManifestEntry{
+			Path:   f.Path,
+			Size:   f.Size,
+			Method: "file/put",
+		}
+		if f.Data == "" {
+			entry.Method = "transfer/fetch"
+			entry.Large = true
+			if md5Info, ok := largeFileMD5[f.SourcePath]; ok {
+				if md5Info.err != nil {
+					entry.Error = md5Info.err.Error()
+				} else {
+					entry.MD5 = md5Info.hash
+				}
+			}
+		}
+		totalBytes += f.Size
+		entries = append(entries, entry)
+	}
+	return entries, totalBytes
+}
+
+// scriptsManifestHandler handles POST /api/scripts/manifest
+// Returns the files a script send would transmit, without sending anything
+func scriptsManifestHandler(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "script name is required"})
+		return
+	}
+
+	resolved, err := resolveScriptPath(req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scriptPath := resolved.absPath
+	scriptName := resolved.normalizedName
+
+	fileInfo, err := os.Stat(scriptPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "script not found"})
+		return
+	}
+
+	isDir := fileInfo.IsDir()
+	isPiled := false
+	if isDir {
+		if _, err := os.Stat(filepath.Join(scriptPath, "lua", "scripts")); err == nil {
+			isPiled = true
+		}
+	}
+
+	filesToSend, skippedFiles, err := collectScriptPackageCached(scriptPath, scriptName, isDir, isPiled, getServerConfig().ScriptSkipUnreadable)
+	if err != nil {
+		errorMsg := "failed to read script directory"
+		if !isDir {
+			errorMsg = "failed to read script file"
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+		return
+	}
+
+	files, totalBytes := buildScriptManifest(filesToSend)
+	smallFilesCount, largeFilesCount := countScriptFileKinds(filesToSend)
+
+	response := gin.H{
+		"name":                 scriptName,
+		"files":                files,
+		"total_files":          len(files),
+		"total_bytes":          totalBytes,
+		"small_files":          smallFilesCount,
+		"large_files":          largeFilesCount,
+		"large_file_threshold": largeFileThreshold(),
+	}
+	if len(skippedFiles) > 0 {
+		response["skipped_files"] = skippedFiles
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // scriptsSendAndStartHandler handles POST /api/scripts/send-and-start
@@ -1350,6 +1610,7 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 			}
 		}
 
+		recordAudit(auditEntry{RemoteAddr: c.ClientIP(), Type: "scripts/send-and-start", Devices: req.Devices})
 		c.JSON(http.StatusOK, gin.H{"success": true, "device_selected": true})
 		return
 	}
@@ -1376,7 +1637,7 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 		}
 	}
 
-	filesToSend, err := collectScriptFilesCached(scriptPath, scriptName, isDir, isPiled)
+	filesToSend, skippedFiles, err := collectScriptPackageCached(scriptPath, scriptName, isDir, isPiled, getServerConfig().ScriptSkipUnreadable)
 	if err != nil {
 		errorMsg := "failed to read script directory"
 		if !isDir {
@@ -1400,15 +1661,12 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 		}
 	}
 
-	runPayload, runPayloadErr := json.Marshal(Message{
-		Type: "script/run",
-		Body: gin.H{
-			"name": runName,
-		},
-	})
+	runPayload, runPayloadErr := buildScriptRunPayload(runName, nil)
 	runPayloadPrepared := runPayloadErr == nil
 	transferBaseURL := resolveTransferBaseURL(c, req.ServerBaseUrl)
 
+	dedup := scriptSendDedupEnabled()
+	filesUnchanged := 0
 	deviceConns := snapshotDeviceConns(req.Devices)
 	type plannedLargeFetch struct {
 		file      scriptFileData
@@ -1416,9 +1674,20 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 	}
 	for _, udid := range req.Devices {
 		if conn, exists := deviceConns[udid]; exists {
+			if req.ForceResend {
+				clearDeviceSentManifest(udid)
+			}
+			unchanged := 0
 			plannedLargeFetches := make([]plannedLargeFetch, 0, largeFilesCount)
 			for _, f := range filesToSend {
 				if f.Data == "" {
+					if dedup {
+						if md5Info, ok := largeFileMD5[f.SourcePath]; ok && md5Info.err == nil &&
+							deviceHasSentFile(udid, f.Path, largeFileFingerprint(md5Info.hash, f.Size)) {
+							unchanged++
+							continue
+						}
+					}
 					plannedLargeFetches = append(plannedLargeFetches, plannedLargeFetch{
 						file:      f,
 						requestID: uuid.New().String(),
@@ -1433,7 +1702,16 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 				})
 			}
 			largeTransferPrepareFailed := false
-			generation, ok := createScriptStartSession(udid, runPayload, runPayloadPrepared, runName, scriptStartPhasePreparing, pendingFetchRequests)
+			devicePayload, devicePayloadPrepared := runPayload, runPayloadPrepared
+			if args := req.DeviceArgs[udid]; len(args) > 0 {
+				payload, err := buildScriptRunPayload(runName, args)
+				if err != nil {
+					broadcastDeviceMessage(udid, "脚本启动已取消: 启动参数无效")
+					continue
+				}
+				devicePayload, devicePayloadPrepared = payload, true
+			}
+			generation, ok := createScriptStartSession(udid, devicePayload, devicePayloadPrepared, runName, scriptStartPhasePreparing, pendingFetchRequests)
 			if !ok {
 				broadcastDeviceMessage(udid, "脚本启动已取消: 上一次脚本启动尚未完成，请稍后重试")
 				continue
@@ -1441,7 +1719,11 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 
 			broadcastDeviceMessage(udid, fmt.Sprintf("发送脚本 (%d小文件, %d大文件)", smallFilesCount, largeFilesCount))
 
-			sender.sendSmallFilesToConn(conn, udid)
+			unchanged += sender.sendSmallFilesToConn(conn, udid, dedup)
+			if unchanged > 0 {
+				broadcastDeviceMessage(udid, fmt.Sprintf("跳过 %d 个未变更文件", unchanged))
+				filesUnchanged += unchanged
+			}
 
 			for _, planned := range plannedLargeFetches {
 				f := planned.file
@@ -1457,6 +1739,7 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 				md5Hash := md5Info.hash
 
 				token := uuid.New().String()
+				chunkSize := resolveTransferChunkSize(udid, 0)
 				transferTokensMu.Lock()
 				transferTokens[token] = &TransferToken{
 					Type:       "download",
@@ -1467,6 +1750,7 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 					OneTime:    true,
 					TotalBytes: f.Size,
 					MD5:        md5Hash,
+					ChunkSize:  chunkSize,
 				}
 				transferTokensMu.Unlock()
 
@@ -1480,6 +1764,7 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 						"md5":        md5Hash,
 						"totalBytes": f.Size,
 						"timeout":    300, // 5 minutes
+						"chunkSize":  chunkSize,
 					},
 				}
 				fetchPayload, marshalErr := json.Marshal(fetchMsg)
@@ -1490,7 +1775,13 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 					largeTransferPrepareFailed = true
 					break
 				}
-				writeTextMessageAsync(conn, fetchPayload)
+				registerTransferCompletion(udid, planned.requestID, scriptStartWaitTimeout+transferTokenTTLGrace, func(result transferFetchResult) {
+					completeScriptStartFetch(udid, result)
+				})
+				writeTextMessageBulk(conn, fetchPayload)
+				if dedup {
+					recordDeviceSentFetchPending(udid, planned.requestID, f.Path, largeFileFingerprint(md5Hash, f.Size))
+				}
 			}
 
 			if largeTransferPrepareFailed {
@@ -1509,13 +1800,21 @@ func scriptsSendAndStartHandler(c *gin.Context) {
 
 			broadcastDeviceMessage(udid, "启动脚本...")
 			updateScriptStartSessionPhase(udid, generation, scriptStartPhaseStarting, true)
-			startScriptOnDevice(udid, generation, runPayload, runPayloadPrepared, runName, ScriptStartDelay)
+			startScriptOnDevice(udid, generation, devicePayload, devicePayloadPrepared, runName, ScriptStartDelay)
 		} else {
 			broadcastDeviceMessage(udid, "脚本启动失败: 设备未连接")
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "files_sent": len(filesToSend)})
+	recordAudit(auditEntry{RemoteAddr: c.ClientIP(), Type: "scripts/send-and-start", Devices: req.Devices, Detail: scriptName})
+	response := gin.H{"success": true, "files_sent": len(filesToSend)}
+	if len(skippedFiles) > 0 {
+		response["skipped_files"] = skippedFiles
+	}
+	if dedup {
+		response["files_unchanged"] = filesUnchanged
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // scriptsSendAndStartCancelHandler handles POST /api/scripts/send-and-start/cancel
@@ -1667,3 +1966,77 @@ func scriptConfigSaveHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+// scriptsPreviewMergeHandler handles POST /api/scripts/preview-merge
+// Returns main.json merged with a config exactly as a script send would deliver it.
+func scriptsPreviewMergeHandler(c *gin.Context) {
+	var req struct {
+		Name    string                 `json:"name"`
+		Config  map[string]interface{} `json:"config"`
+		GroupID string                 `json:"groupId"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	resolved, err := resolveScriptPath(req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	mainJsonPath := filepath.Join(resolved.absPath, "lua", "scripts", "main.json")
+
+	data, err := os.ReadFile(mainJsonPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "main.json not found"})
+		return
+	}
+
+	var template map[string]interface{}
+	if err := json.Unmarshal(data, &template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse main.json"})
+		return
+	}
+
+	groupConfig := req.Config
+	if groupConfig == nil && req.GroupID != "" {
+		groupScriptConfigsMu.RLock()
+		if scripts, ok := groupScriptConfigs[req.GroupID]; ok {
+			groupConfig = scripts[req.Name]
+		}
+		groupScriptConfigsMu.RUnlock()
+	}
+
+	// Without a config the template is sent unchanged.
+	encoded := base64.StdEncoding.EncodeToString(data)
+	applied := false
+	if mergedData, ok := buildMergedMainJSON(template, groupConfig); ok {
+		encoded = mergedData
+		applied = true
+	}
+
+	rawJSON, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to decode merged main.json"})
+		return
+	}
+	var merged map[string]interface{}
+	if err := json.Unmarshal(rawJSON, &merged); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse merged main.json"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":    req.Name,
+		"applied": applied,
+		"merged":  merged,
+		"config":  merged["Config"],
+		"data":    encoded,
+	})
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -107,6 +109,29 @@ func TestCollectScriptFilesCachedInvalidatesOnDirectoryFileAdd(t *testing.T) {
 	}
 }
 
+func TestCollectScriptFilesCachedHonorsLargeFileThresholdChanges(t *testing.T) {
+	resetScriptPackageCacheForTest()
+	prevThreshold := getServerConfig().LargeFileThresholdBytes
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.LargeFileThresholdBytes = prevThreshold }) })
+
+	scriptPath := filepath.Join(t.TempDir(), "main.lua")
+	if err := os.WriteFile(scriptPath, []byte(strings.Repeat("x", 64)), 0o644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LargeFileThresholdBytes = 0 })
+	files, err := collectScriptFilesCached(scriptPath, "main.lua", false, false)
+	if err != nil || len(files) != 1 || files[0].Data == "" {
+		t.Fatalf("expected an inline file with the default threshold, got %+v, %v", files, err)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LargeFileThresholdBytes = 32 })
+	files, err = collectScriptFilesCached(scriptPath, "main.lua", false, false)
+	if err != nil || len(files) != 1 || files[0].Data != "" {
+		t.Fatalf("expected a large file after lowering the threshold, got %+v, %v", files, err)
+	}
+}
+
 func TestCollectScriptFilesCached_SkipNestedDirectorySymlinkAndIncludeFileSymlink(t *testing.T) {
 	resetScriptPackageCacheForTest()
 
@@ -163,3 +188,94 @@ func TestCollectScriptFilesCached_SkipNestedDirectorySymlinkAndIncludeFileSymlin
 		t.Fatalf("symlink file not found in package")
 	}
 }
+
+func TestCollectScriptPackageCached_SkipUnreadableMode(t *testing.T) {
+	resetScriptPackageCacheForTest()
+
+	scriptDir := filepath.Join(t.TempDir(), "bundle")
+	if err := os.MkdirAll(scriptDir, 0o755); err != nil {
+		t.Fatalf("failed to create script dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(scriptDir, "a.lua"), []byte("print('a')"), 0o644); err != nil {
+		t.Fatalf("failed to write regular file: %v", err)
+	}
+	createScriptSymlinkOrSkip(t, filepath.Join(scriptDir, "missing.lua"), filepath.Join(scriptDir, "broken.lua"))
+
+	if _, _, err := collectScriptPackageCached(scriptDir, "bundle", true, false, false); err == nil {
+		t.Fatalf("expected fail-fast mode to return an error for a dangling symlink")
+	}
+
+	files, skipped, err := collectScriptPackageCached(scriptDir, "bundle", true, false, true)
+	if err != nil {
+		t.Fatalf("collect in skip mode failed: %v", err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0].NormalizedPath, "/a.lua") {
+		t.Fatalf("expected only a.lua to be collected, got %+v", files)
+	}
+	if len(skipped) != 1 || skipped[0].Path != "broken.lua" || skipped[0].Error == "" {
+		t.Fatalf("unexpected skipped files: %+v", skipped)
+	}
+
+	scriptPackageCache.RLock()
+	cached := len(scriptPackageCache.entries)
+	scriptPackageCache.RUnlock()
+	if cached != 0 {
+		t.Fatalf("expected packages with skipped files not to be cached, got %d entries", cached)
+	}
+}
+
+func TestScriptsManifestHandler_ClassifiesSmallAndLargeFiles(t *testing.T) {
+	resetScriptPackageCacheForTest()
+	dataDir := setupFileHandlersTestDataDir(t)
+
+	scriptDir := filepath.Join(dataDir, "scripts", "demo")
+	if err := os.MkdirAll(scriptDir, 0o755); err != nil {
+		t.Fatalf("mkdir script dir failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(scriptDir, "main.lua"), []byte("print('a')"), 0o644); err != nil {
+		t.Fatalf("write small file failed: %v", err)
+	}
+	large := make([]byte, largeFileThreshold()+1)
+	if err := os.WriteFile(filepath.Join(scriptDir, "big.bin"), large, 0o644); err != nil {
+		t.Fatalf("write large file failed: %v", err)
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/manifest", map[string]any{"name": "demo"}, scriptsManifestHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Files      []scriptManifestEntry `json:"files"`
+		TotalBytes int64                 `json:"total_bytes"`
+		LargeFiles int                   `json:"large_files"`
+		SmallFiles int                   `json:"small_files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Files) != 2 || resp.LargeFiles != 1 || resp.SmallFiles != 1 {
+		t.Fatalf("unexpected manifest: %+v", resp)
+	}
+	if resp.TotalBytes != int64(len(large))+int64(len("print('a')")) {
+		t.Fatalf("unexpected total bytes: %d", resp.TotalBytes)
+	}
+	for _, f := range resp.Files {
+		switch filepath.Base(f.Path) {
+		case "big.bin":
+			if f.Method != "transfer/fetch" || !f.Large || f.MD5 == "" {
+				t.Fatalf("unexpected large file entry: %+v", f)
+			}
+		case "main.lua":
+			if f.Method != "file/put" || f.Large || f.MD5 != "" {
+				t.Fatalf("unexpected small file entry: %+v", f)
+			}
+		default:
+			t.Fatalf("unexpected file in manifest: %+v", f)
+		}
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/manifest", map[string]any{"name": "missing"}, scriptsManifestHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
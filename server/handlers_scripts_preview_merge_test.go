@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptsPreviewMerge_MergesConfigAndGroupConfig(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	scriptDir := filepath.Join(dataDir, "scripts", "demo", "lua", "scripts")
+	if err := os.MkdirAll(scriptDir, 0o755); err != nil {
+		t.Fatalf("mkdir script dir: %v", err)
+	}
+	mainJSON := `{"ScriptInfo":{"Name":"demo"},"Config":{"a":1,"b":2}}`
+	if err := os.WriteFile(filepath.Join(scriptDir, "main.json"), []byte(mainJSON), 0o644); err != nil {
+		t.Fatalf("write main.json: %v", err)
+	}
+
+	groupScriptConfigsMu.Lock()
+	configsBackup := groupScriptConfigs
+	groupScriptConfigs = map[string]map[string]map[string]interface{}{
+		"g1": {"demo": {"b": "group"}},
+	}
+	groupScriptConfigsMu.Unlock()
+	t.Cleanup(func() {
+		groupScriptConfigsMu.Lock()
+		groupScriptConfigs = configsBackup
+		groupScriptConfigsMu.Unlock()
+	})
+
+	decode := func(t *testing.T, code int, body []byte) map[string]interface{} {
+		t.Helper()
+		if code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", code, body)
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp
+	}
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/preview-merge", map[string]interface{}{
+		"name":   "demo",
+		"config": map[string]interface{}{"b": 3, "c": 4},
+	}, scriptsPreviewMergeHandler)
+	resp := decode(t, w.Code, w.Body.Bytes())
+	config, _ := resp["config"].(map[string]interface{})
+	if resp["applied"] != true || config["a"] != float64(1) || config["b"] != float64(3) || config["c"] != float64(4) {
+		t.Fatalf("unexpected merged config: %#v", resp)
+	}
+	merged, _ := resp["merged"].(map[string]interface{})
+	if info, _ := merged["ScriptInfo"].(map[string]interface{}); info["Name"] != "demo" {
+		t.Fatalf("template fields should be preserved, got %#v", merged)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/preview-merge", map[string]interface{}{
+		"name":    "demo",
+		"groupId": "g1",
+	}, scriptsPreviewMergeHandler)
+	resp = decode(t, w.Code, w.Body.Bytes())
+	config, _ = resp["config"].(map[string]interface{})
+	if config["b"] != "group" || config["a"] != float64(1) {
+		t.Fatalf("expected group config to be merged, got %#v", config)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/preview-merge", map[string]interface{}{
+		"name": "demo",
+	}, scriptsPreviewMergeHandler)
+	resp = decode(t, w.Code, w.Body.Bytes())
+	if resp["applied"] != false {
+		t.Fatalf("expected template to be returned unchanged, got %#v", resp)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/preview-merge", map[string]interface{}{
+		"name": "missing",
+	}, scriptsPreviewMergeHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for script without main.json, got %d", w.Code)
+	}
+}
@@ -22,9 +22,9 @@ func TestSelectableScriptsHandler_IncludesSymlinkedPiledScriptDirectory(t *testi
 	gin.SetMode(gin.TestMode)
 
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	scriptsDir := filepath.Join(dataDir, "scripts")
 	if err := os.MkdirAll(scriptsDir, 0o755); err != nil {
@@ -78,9 +78,9 @@ func TestSelectableScriptsHandler_IncludesSymlinkedXPPDirectory(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	dataDir := t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	scriptsDir := filepath.Join(dataDir, "scripts")
 	if err := os.MkdirAll(scriptsDir, 0o755); err != nil {
@@ -3,7 +3,12 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/gorilla/websocket"
 )
 
 func TestScriptsStartStateHandlerReturnsOnlyActiveSessions(t *testing.T) {
@@ -84,3 +89,58 @@ func TestScriptsSendAndStartCancelHandlerReturnsCanceledAndIgnored(t *testing.T)
 		t.Fatalf("canceled session should be cleared")
 	}
 }
+
+func TestScriptsSendAndStartHandlerInjectsDeviceArgs(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	resetScriptStartSessionsForTest()
+	defer resetScriptStartSessionsForTest()
+	if err := os.WriteFile(filepath.Join(dataDir, "scripts", "demo.lua"), []byte("print(1)"), 0o644); err != nil {
+		t.Fatalf("write script failed: %v", err)
+	}
+
+	conn1, client1 := newTestWebSocketPair(t)
+	conn2, client2 := newTestWebSocketPair(t)
+	mu.Lock()
+	prevLinks, prevControllers := deviceLinks, controllers
+	deviceLinks = map[string]*SafeConn{"d1": conn1, "d2": conn2}
+	controllers = make(map[*SafeConn]bool)
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		deviceLinks, controllers = prevLinks, prevControllers
+		mu.Unlock()
+	}()
+
+	w := performJSONHandlerRequest(
+		t,
+		http.MethodPost,
+		"/api/scripts/send-and-start",
+		map[string]any{
+			"devices":    []string{"d1", "d2"},
+			"name":       "demo.lua",
+			"deviceArgs": map[string][]string{"d1": {"--account", "3"}},
+		},
+		scriptsSendAndStartHandler,
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	readRun := func(client *websocket.Conn) map[string]interface{} {
+		for {
+			msg := readTestMessage(t, client)
+			if msg.Type == "script/run" {
+				body, _ := msg.Body.(map[string]interface{})
+				return body
+			}
+		}
+	}
+	body1 := readRun(client1)
+	if !reflect.DeepEqual(body1["args"], []interface{}{"--account", "3"}) || body1["name"] != "demo.lua" {
+		t.Fatalf("unexpected d1 script/run body: %#v", body1)
+	}
+	body2 := readRun(client2)
+	if _, ok := body2["args"]; ok || body2["name"] != "demo.lua" {
+		t.Fatalf("d2 should get the shared script/run body, got %#v", body2)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scriptTreeDefaultDepth = 8
+	scriptTreeMaxDepth     = 32
+)
+
+// scriptTreeNode is one entry of GET /api/scripts/tree.
+type scriptTreeNode struct {
+	Name         string           `json:"name"`
+	Path         string           `json:"path"` // Relative to the scripts category, "/"-separated
+	Type         string           `json:"type"` // "file" or "dir"
+	Size         int64            `json:"size"`
+	ModTime      string           `json:"modTime"`
+	IsSymlink    bool             `json:"isSymlink,omitempty"`
+	Piled        bool             `json:"piled,omitempty"`        // Directory contains lua/scripts
+	Configurable bool             `json:"configurable,omitempty"` // Piled script with lua/scripts/main.json
+	Truncated    bool             `json:"truncated,omitempty"`    // Children not listed (depth cap or directory symlink)
+	Children     []scriptTreeNode `json:"children,omitempty"`
+}
+
+// markPiledScript flags node when dirPath is a piled script root.
+func markPiledScript(node *scriptTreeNode, dirPath string) {
+	if info, err := os.Stat(filepath.Join(dirPath, "lua", "scripts")); err == nil && info.IsDir() {
+		node.Piled = true
+		if _, err := os.Stat(filepath.Join(dirPath, "lua", "scripts", "main.json")); err == nil {
+			node.Configurable = true
+		}
+	}
+}
+
+// buildScriptTree lists dirPath recursively up to depth levels. It follows the same
+// symlink rules as walkScriptFiles: file symlinks are resolved, while nested directory
+// symlinks are listed but not descended (request them as the tree root to expand them).
+func buildScriptTree(dirPath string, relPath string, depth int) ([]scriptTreeNode, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]scriptTreeNode, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		fileType, size, modTime, isSymlink := classifyEntry(dirPath, entry, true)
+		node := scriptTreeNode{
+			Name:      name,
+			Path:      strings.TrimPrefix(relPath+"/"+name, "/"),
+			Type:      fileType,
+			Size:      size,
+			ModTime:   modTime,
+			IsSymlink: isSymlink,
+		}
+		if fileType == "dir" {
+			entryPath := filepath.Join(dirPath, name)
+			markPiledScript(&node, entryPath)
+			if isSymlink || depth <= 1 {
+				node.Truncated = true
+			} else if children, err := buildScriptTree(entryPath, node.Path, depth-1); err == nil {
+				node.Children = children
+			} else {
+				node.Truncated = true
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Type != nodes[j].Type {
+			return nodes[i].Type == "dir"
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes, nil
+}
+
+// scriptsTreeHandler handles GET /api/scripts/tree?path=&depth=
+// Returns the scripts category (or a subdirectory) as a nested tree.
+func scriptsTreeHandler(c *gin.Context) {
+	subPath := strings.Trim(strings.ReplaceAll(c.Query("path"), "\\", "/"), "/")
+	depth := scriptTreeDefaultDepth
+	if raw := c.Query("depth"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be a positive integer"})
+			return
+		}
+		depth = v
+	}
+	if depth > scriptTreeMaxDepth {
+		depth = scriptTreeMaxDepth
+	}
+
+	rootPath, err := validatePath("scripts", subPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Report the cleaned path ("a/../b" -> "b") so node paths stay canonical
+	if baseDir, err := filepath.Abs(filepath.Join(getServerConfig().DataDir, "scripts")); err == nil {
+		if rel, err := filepath.Rel(baseDir, rootPath); err == nil {
+			subPath = filepath.ToSlash(rel)
+			if subPath == "." {
+				subPath = ""
+			}
+		}
+	}
+	info, err := os.Stat(rootPath)
+	if os.IsNotExist(err) {
+		if subPath == "" {
+			c.JSON(http.StatusOK, scriptTreeNode{Name: "scripts", Type: "dir", Children: []scriptTreeNode{}})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "directory not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is not a directory"})
+		return
+	}
+
+	children, err := buildScriptTree(rootPath, subPath, depth)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read directory"})
+		return
+	}
+	root := scriptTreeNode{
+		Name:     filepath.Base(rootPath),
+		Path:     subPath,
+		Type:     "dir",
+		ModTime:  info.ModTime().Format("2006-01-02 15:04:05"),
+		Children: children,
+	}
+	if subPath == "" {
+		root.Name = "scripts"
+	} else {
+		markPiledScript(&root, rootPath)
+	}
+	c.JSON(http.StatusOK, root)
+}
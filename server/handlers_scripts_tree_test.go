@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func findScriptTreeNode(nodes []scriptTreeNode, name string) *scriptTreeNode {
+	for i := range nodes {
+		if nodes[i].Name == name {
+			return &nodes[i]
+		}
+	}
+	return nil
+}
+
+func TestScriptsTreeHandler_NestedTreeWithPiledFlags(t *testing.T) {
+	dataDir := setupFileHandlersTestDataDir(t)
+	scriptsDir := filepath.Join(dataDir, "scripts")
+
+	piledScripts := filepath.Join(scriptsDir, "game", "lua", "scripts")
+	if err := os.MkdirAll(piledScripts, 0o755); err != nil {
+		t.Fatalf("mkdir piled script: %v", err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(piledScripts, "main.lua"):  "print(1)",
+		filepath.Join(piledScripts, "main.json"): "{}",
+		filepath.Join(scriptsDir, "single.lua"):  "print(2)",
+		filepath.Join(scriptsDir, ".hidden"):     "x",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	outsideDir := t.TempDir()
+	createSymlinkOrSkip(t, outsideDir, filepath.Join(scriptsDir, "linked"))
+
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/scripts/tree?depth=3", nil, scriptsTreeHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("tree status=%d body=%s", w.Code, w.Body.String())
+	}
+	var root scriptTreeNode
+	if err := json.Unmarshal(w.Body.Bytes(), &root); err != nil {
+		t.Fatalf("decode tree: %v", err)
+	}
+	if len(root.Children) != 3 || findScriptTreeNode(root.Children, ".hidden") != nil {
+		t.Fatalf("unexpected top-level entries: %+v", root.Children)
+	}
+
+	game := findScriptTreeNode(root.Children, "game")
+	if game == nil || !game.Piled || !game.Configurable || game.Type != "dir" {
+		t.Fatalf("game should be a configurable piled script: %+v", game)
+	}
+	lua := findScriptTreeNode(game.Children, "lua")
+	scripts := findScriptTreeNode(lua.Children, "scripts")
+	if scripts == nil || !scripts.Truncated || scripts.Path != "game/lua/scripts" {
+		t.Fatalf("depth 3 should stop at game/lua/scripts: %+v", scripts)
+	}
+
+	linked := findScriptTreeNode(root.Children, "linked")
+	if linked == nil || !linked.IsSymlink || !linked.Truncated || linked.Children != nil {
+		t.Fatalf("directory symlink should be listed but not descended: %+v", linked)
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/scripts/tree?path=game", nil, scriptsTreeHandler)
+	if err := json.Unmarshal(w.Body.Bytes(), &root); err != nil || !root.Piled || root.Path != "game" {
+		t.Fatalf("subtree root should carry piled flags: %+v %v", root, err)
+	}
+	w = performJSONHandlerRequest(t, http.MethodGet, "/api/scripts/tree?path=game/../..", nil, scriptsTreeHandler)
+	if err := json.Unmarshal(w.Body.Bytes(), &root); err != nil || root.Name != "scripts" || root.Path != "" {
+		t.Fatalf("path traversal should resolve to the scripts root: %+v %v", root, err)
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverConfigPatchMu serializes PATCH /api/server-config so concurrent edits cannot
+// overwrite each other's read-modify-write.
+var serverConfigPatchMu sync.Mutex
+
+// restartRequiredConfigKeys are top-level config keys only read at startup.
+// All other fields are read on use or hot-applied by applyServerConfigChanges.
+var restartRequiredConfigKeys = map[string]bool{
+	"port":                    true,
+	"bindAddress":             true,
+	"metricsEnabled":          true,
+	"metricsAddr":             true,
+	"persistTransferTokens":   true,
+	"encryptDeviceData":       true,
+	"frontend_dir":            true,
+	"data_dir":                true,
+	"tlsEnabled":              true,
+	"tlsCertFile":             true,
+	"tlsKeyFile":              true,
+	"httpsRedirectPort":       true,
+	"acmeDomains":             true,
+	"acmeCacheDir":            true,
+	"turnEnabled":             true,
+	"turnPort":                true,
+	"turnPublicIP":            true,
+	"turnPublicAddr":          true,
+	"turnRealm":               true,
+	"turnSecretKey":           true,
+	"turnCredentialTTL":       true,
+	"turnRelayPortMin":        true,
+	"turnRelayPortMax":        true,
+	"customIceServers":        true,
+	"deviceStateFlushSeconds": true,
+	"deviceExportSeconds":     true,
+	"upstreams":               true,
+}
+
+// forbiddenConfigPatchKeys cannot be changed through the config API.
+var forbiddenConfigPatchKeys = map[string]string{
+	"passhash":      "passhash must be changed with -set-password",
+	"signingSecret": "signingSecret must be changed in the config file, followed by -set-password",
+}
+
+// mergeJSONPatch applies an RFC 7386 style merge patch: objects merge recursively,
+// null removes a key (restoring its default), anything else replaces.
+func mergeJSONPatch(target, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		if patchObj, ok := value.(map[string]interface{}); ok {
+			if targetObj, ok := target[key].(map[string]interface{}); ok {
+				mergeJSONPatch(targetObj, patchObj)
+				continue
+			}
+		}
+		target[key] = value
+	}
+}
+
+// applyServerConfigPatch merges patch into base and decodes the result on top of
+// DefaultConfig. Unknown fields are rejected.
+func applyServerConfigPatch(base ServerConfig, patch map[string]interface{}) (ServerConfig, error) {
+	baseData, err := json.Marshal(base)
+	if err != nil {
+		return ServerConfig{}, err
+	}
+	merged := make(map[string]interface{})
+	if err := json.Unmarshal(baseData, &merged); err != nil {
+		return ServerConfig{}, err
+	}
+	mergeJSONPatch(merged, patch)
+
+	mergedData, err := json.Marshal(merged)
+	if err != nil {
+		return ServerConfig{}, err
+	}
+	result := DefaultConfig
+	decoder := json.NewDecoder(bytes.NewReader(mergedData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return ServerConfig{}, fmt.Errorf("invalid config: %v", err)
+	}
+	return result, nil
+}
+
+// validateServerConfig checks a merged config before it is persisted or applied.
+func validateServerConfig(cfg ServerConfig) error {
+	if err := validateUpstreams(cfg.Upstreams); err != nil {
+		return err
+	}
+	if err := validateACMEDomains(cfg.ACMEDomains); err != nil {
+		return err
+	}
+	switch {
+	case cfg.Port <= 0 || cfg.Port > 65535:
+		return fmt.Errorf("port must be between 1 and 65535")
+	case cfg.BindAddress != "" && net.ParseIP(cfg.BindAddress) == nil:
+		return fmt.Errorf("bindAddress must be an IP address")
+	case cfg.PingInterval <= 0:
+		return fmt.Errorf("ping_interval must be positive")
+	case cfg.PingTimeout <= 0:
+		return fmt.Errorf("ping_timeout must be positive")
+	case cfg.StateInterval <= 0:
+		return fmt.Errorf("state_interval must be positive")
+	case cfg.DataDir == "":
+		return fmt.Errorf("data_dir cannot be empty")
+	case cfg.TLSEnabled && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == ""):
+		return fmt.Errorf("tlsCertFile and tlsKeyFile are required when TLS is enabled")
+	case cfg.HTTPSRedirectPort < 0 || cfg.HTTPSRedirectPort > 65535:
+		return fmt.Errorf("httpsRedirectPort must be between 0 and 65535")
+	case cfg.HTTPSRedirectPort != 0 && cfg.HTTPSRedirectPort == cfg.Port:
+		return fmt.Errorf("httpsRedirectPort must differ from port")
+	case len(cfg.ACMEDomains) > 0 && cfg.Port == acmeChallengePort:
+		return fmt.Errorf("port cannot be 80 when acmeDomains is set; port 80 serves the ACME challenge")
+	case len(cfg.ACMEDomains) > 0 && cfg.BindAddress != "" && net.ParseIP(cfg.BindAddress).IsLoopback():
+		return fmt.Errorf("acmeDomains requires a bindAddress reachable from the internet, not loopback")
+	case cfg.TURNPort < 0 || cfg.TURNPort > 65535:
+		return fmt.Errorf("turnPort must be between 0 and 65535")
+	case cfg.TURNRelayPortMin > 0 && cfg.TURNRelayPortMax > 0 && cfg.TURNRelayPortMin > cfg.TURNRelayPortMax:
+		return fmt.Errorf("turnRelayPortMin cannot exceed turnRelayPortMax")
+	case cfg.DestructiveConfirmThreshold < 0:
+		return fmt.Errorf("destructiveConfirmThreshold cannot be negative")
+	case cfg.ClockSkewThresholdSeconds < 0:
+		return fmt.Errorf("clockSkewThresholdSeconds cannot be negative")
+	case cfg.LogStaleSeconds < 0:
+		return fmt.Errorf("logStaleSeconds cannot be negative")
+	case cfg.RefreshCoalesceMs < 0:
+		return fmt.Errorf("refreshCoalesceMs cannot be negative")
+	case cfg.DevicesPageSize < 0:
+		return fmt.Errorf("devicesPageSize cannot be negative")
+	case cfg.BinaryMaxChunkCount < 0 || cfg.BinaryMaxChunkBytes < 0:
+		return fmt.Errorf("binary chunk limits cannot be negative")
+	case cfg.SignatureCacheSize < 0:
+		return fmt.Errorf("signatureCacheSize cannot be negative")
+	case cfg.LandingRedirect != "" && !isLocalRedirectPath(cfg.LandingRedirect):
+		return fmt.Errorf("landingRedirect must be a local path starting with /")
+	case cfg.DeviceStateFlushSeconds < 0:
+		return fmt.Errorf("deviceStateFlushSeconds cannot be negative")
+	case cfg.DeviceExportSeconds < 0:
+		return fmt.Errorf("deviceExportSeconds cannot be negative")
+	case cfg.DeviceExportURL != "" && !isDeviceExportURL(cfg.DeviceExportURL):
+		return fmt.Errorf("deviceExportUrl must be an http(s) URL")
+	case cfg.ShutdownGraceSeconds < 0:
+		return fmt.Errorf("shutdownGraceSeconds cannot be negative")
+	case cfg.TransferChunkSize < 0:
+		return fmt.Errorf("transferChunkSize cannot be negative")
+	case !validDisabledEndpoints(cfg.DisabledEndpoints):
+		return fmt.Errorf("disabledEndpoints entries must start with /api/")
+	case cfg.SignatureSkewSeconds < 0:
+		return fmt.Errorf("signatureSkewSeconds cannot be negative")
+	case cfg.ControlRateLimit < 0 || cfg.DeviceRateLimit < 0 || cfg.ControlRateBurst < 0 || cfg.DeviceRateBurst < 0:
+		return fmt.Errorf("rate limits cannot be negative")
+	case !validLogFormat(cfg.LogFormat):
+		return fmt.Errorf("logFormat must be text or json")
+	case !isValidLogLevel(cfg.LogLevel):
+		return fmt.Errorf("logLevel must be debug, info, warn or error")
+	case cfg.ScreenFrameMaxFPS < 0:
+		return fmt.Errorf("screenFrameMaxFps cannot be negative")
+	case cfg.ScreenshotCacheSize < 0:
+		return fmt.Errorf("screenshotCacheSize cannot be negative")
+	case cfg.ScreenshotMaxAgeSeconds < 0:
+		return fmt.Errorf("screenshotMaxAgeSeconds cannot be negative")
+	case cfg.DeviceWriteQueueDepth < 0:
+		return fmt.Errorf("deviceWriteQueueDepth cannot be negative")
+	case cfg.DisconnectGraceSeconds < 0:
+		return fmt.Errorf("disconnectGraceSeconds cannot be negative")
+	case cfg.WebSocketCompressionMinBytes < 0:
+		return fmt.Errorf("webSocketCompressionMinBytes cannot be negative")
+	case cfg.HTTPCompressionMinBytes < 0:
+		return fmt.Errorf("httpCompressionMinBytes cannot be negative")
+	case cfg.MaxWebSocketMessageBytes < 0:
+		return fmt.Errorf("maxWebSocketMessageBytes cannot be negative")
+	case cfg.MaxWebSocketMessageBytes > 0 && cfg.MaxWebSocketMessageBytes < minWebSocketMessageBytes(cfg):
+		return fmt.Errorf("maxWebSocketMessageBytes must be at least %d to fit file/put of largeFileThresholdBytes and binary chunks", minWebSocketMessageBytes(cfg))
+	case cfg.MaxDevices < 0:
+		return fmt.Errorf("maxDevices cannot be negative")
+	case cfg.MaxConnAgeSeconds < 0:
+		return fmt.Errorf("maxConnAgeSeconds cannot be negative")
+	case cfg.CommandHistoryLimit < 0:
+		return fmt.Errorf("commandHistoryLimit cannot be negative")
+	case cfg.LargeFileThresholdBytes < 0:
+		return fmt.Errorf("largeFileThresholdBytes cannot be negative")
+	case cfg.UploadZipMaxBytes < 0:
+		return fmt.Errorf("uploadZipMaxBytes cannot be negative")
+	case !validWebhookURLs(cfg.Webhooks):
+		return fmt.Errorf("webhooks entries must be http(s) URLs")
+	case !isValidUpdateChannel(cfg.Update.Channel):
+		return fmt.Errorf("update.channel must be \"stable\" or \"beta\"")
+	}
+	return nil
+}
+
+// applyServerConfigChanges swaps in the new running config and resets timers whose
+// interval changed.
+func applyServerConfigChanges(oldCfg, newCfg ServerConfig) {
+	setServerConfig(newCfg)
+	if oldCfg.PingInterval != newCfg.PingInterval && pingTicker != nil {
+		pingTicker.Reset(time.Duration(newCfg.PingInterval) * time.Second)
+	}
+	if oldCfg.StateInterval != newCfg.StateInterval && stateRefreshTicker != nil {
+		stateRefreshTicker.Reset(time.Duration(newCfg.StateInterval) * time.Second)
+	}
+	if oldCfg.LogFormat != newCfg.LogFormat || oldCfg.LogLevel != newCfg.LogLevel {
+		initLogger(newCfg.LogFormat, newCfg.LogLevel)
+	}
+	if oldCfg.Passhash != newCfg.Passhash {
+		setPasshash(newCfg.Passhash)
+	}
+	if oldCfg.Update.Source.DownloadConnectTimeoutSeconds != newCfg.Update.Source.DownloadConnectTimeoutSeconds && updaterService != nil {
+		updaterService.setHTTPClient(newUpdaterHTTPClient(newCfg.Update.Source.DownloadConnectTimeoutSeconds))
+	}
+}
+
+// readPersistedServerConfig returns the config as stored on disk, without env overrides.
+func readPersistedServerConfig(configPath string) (ServerConfig, error) {
+	cfg := DefaultConfig
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return cfg, nil
+}
+
+func serverConfigAsMap(cfg ServerConfig) (map[string]interface{}, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// changedConfigKeys returns the sorted top-level keys whose values differ between two
+// serverConfigAsMap results.
+func changedConfigKeys(before, after map[string]interface{}) []string {
+	changed := make([]string, 0)
+	for key, value := range after {
+		if !reflect.DeepEqual(before[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// reloadServerConfigFromFile re-reads the config file and hot-applies it like a PATCH of
+// every key in the file, with environment overrides still taking precedence. It returns
+// the keys whose running value changed and the changed startup-only keys, which keep
+// their running value until restart. passhash and signingSecret are only taken from the
+// file when includeCredentials is set (SIGHUP).
+func reloadServerConfigFromFile(includeCredentials bool) ([]string, []string, error) {
+	serverConfigPatchMu.Lock()
+	defer serverConfigPatchMu.Unlock()
+
+	if serverConfigPath == "" {
+		return nil, nil, fmt.Errorf("server is running without a config file")
+	}
+	data, err := os.ReadFile(serverConfigPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fileConfig map[string]interface{}
+	if err := json.Unmarshal(data, &fileConfig); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if !includeCredentials {
+		for key := range forbiddenConfigPatchKeys {
+			delete(fileConfig, key)
+		}
+	}
+
+	// Compare startup-only keys after environment overrides, which still win over the file.
+	current := *getServerConfig()
+	fromFile, err := applyServerConfigPatch(current, fileConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyEnvOverridesTo(&fromFile)
+	running, err := serverConfigAsMap(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	wanted, err := serverConfigAsMap(fromFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restartRequired := make([]string, 0)
+	hotPatch := make(map[string]interface{}, len(fileConfig))
+	for key, value := range fileConfig {
+		if !restartRequiredConfigKeys[key] {
+			hotPatch[key] = value
+		} else if !reflect.DeepEqual(running[key], wanted[key]) {
+			restartRequired = append(restartRequired, key)
+		}
+	}
+	sort.Strings(restartRequired)
+
+	reloaded, err := applyServerConfigPatch(current, hotPatch)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyEnvOverridesTo(&reloaded)
+	if err := validateServerConfig(reloaded); err != nil {
+		return nil, nil, err
+	}
+	if includeCredentials && len(reloaded.Passhash) != PasshashLength {
+		return nil, nil, fmt.Errorf("passhash must be %d hex characters", PasshashLength)
+	}
+	applied, err := serverConfigAsMap(reloaded)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyServerConfigChanges(current, reloaded)
+	return changedConfigKeys(running, applied), restartRequired, nil
+}
+
+// serverConfigPatchHandler handles PATCH /api/server-config
+func serverConfigPatchHandler(c *gin.Context) {
+	var patch map[string]interface{}
+	if err := c.ShouldBindJSON(&patch); err != nil || patch == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	for key := range patch {
+		if reason, forbidden := forbiddenConfigPatchKeys[key]; forbidden {
+			c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+			return
+		}
+	}
+
+	serverConfigPatchMu.Lock()
+	defer serverConfigPatchMu.Unlock()
+
+	current := *getServerConfig()
+	merged, err := applyServerConfigPatch(current, patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateServerConfig(merged); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Startup-only fields are persisted but keep their running value until restart.
+	restartRequired := make([]string, 0)
+	hotPatch := make(map[string]interface{}, len(patch))
+	for key, value := range patch {
+		if restartRequiredConfigKeys[key] {
+			restartRequired = append(restartRequired, key)
+			continue
+		}
+		hotPatch[key] = value
+	}
+	sort.Strings(restartRequired)
+	running, err := applyServerConfigPatch(current, hotPatch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Persist against the on-disk config so env overrides are not baked into the file.
+	persisted := false
+	if serverConfigPath != "" {
+		stored, err := readPersistedServerConfig(serverConfigPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		stored, err = applyServerConfigPatch(stored, patch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := json.MarshalIndent(stored, "", "  ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := writeFileAtomic(serverConfigPath, data, 0644); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save config"})
+			return
+		}
+		persisted = true
+	}
+
+	applyServerConfigChanges(current, running)
+	slog.Info("Server config patched", "persisted", persisted, "restart_required", restartRequired)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":         true,
+		"persisted":       persisted,
+		"restartRequired": restartRequired,
+	})
+}
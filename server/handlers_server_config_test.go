@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setupServerConfigPatchFixture(t *testing.T) string {
+	t.Helper()
+	dataDir := setupPersistenceWritableDataDir(t)
+
+	configBackup := *getServerConfig()
+	pathBackup := serverConfigPath
+	t.Cleanup(func() {
+		setServerConfig(configBackup)
+		serverConfigPath = pathBackup
+	})
+
+	configPath := filepath.Join(dataDir, "config.json")
+	stored := DefaultConfig
+	stored.Passhash = "secret"
+	data, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatalf("marshal config failed: %v", err)
+	}
+	if err := os.WriteFile(configPath, data, 0o644); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+	serverConfigPath = configPath
+	setServerConfig(DefaultConfig)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	return configPath
+}
+
+func TestServerConfigPatch_MergesPersistsAndReportsRestart(t *testing.T) {
+	configPath := setupServerConfigPatchFixture(t)
+	runningPort := getServerConfig().Port
+
+	w := performJSONHandlerRequest(t, http.MethodPatch, "/api/server-config", map[string]any{
+		"state_interval": 20,
+		"port":           47000,
+		"update":         map[string]any{"channel": "beta"},
+	}, serverConfigPatchHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Persisted       bool     `json:"persisted"`
+		RestartRequired []string `json:"restartRequired"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !resp.Persisted || !reflect.DeepEqual(resp.RestartRequired, []string{"port"}) {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	if getServerConfig().StateInterval != 20 {
+		t.Fatalf("expected state_interval to be hot-applied, got %d", getServerConfig().StateInterval)
+	}
+	if getServerConfig().Update.Channel != "beta" {
+		t.Fatalf("expected update channel to be hot-applied, got %q", getServerConfig().Update.Channel)
+	}
+	if getServerConfig().Port != runningPort {
+		t.Fatalf("expected running port to stay %d until restart, got %d", runningPort, getServerConfig().Port)
+	}
+
+	stored, err := readPersistedServerConfig(configPath)
+	if err != nil {
+		t.Fatalf("read persisted config failed: %v", err)
+	}
+	if stored.Port != 47000 || stored.StateInterval != 20 || stored.Update.Channel != "beta" {
+		t.Fatalf("unexpected persisted config: port=%d state=%d channel=%q", stored.Port, stored.StateInterval, stored.Update.Channel)
+	}
+	if stored.Passhash != "secret" || stored.Update.Source.Repository != DefaultConfig.Update.Source.Repository {
+		t.Fatalf("expected untouched fields to be preserved")
+	}
+}
+
+func TestServerConfigPatch_RejectsInvalidPatches(t *testing.T) {
+	configPath := setupServerConfigPatchFixture(t)
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("read config failed: %v", err)
+	}
+
+	for _, patch := range []map[string]any{
+		{"ping_interval": 0},
+		{"notAField": true},
+		{"passhash": "x"},
+		{"port": "abc"},
+		{"bindAddress": "lan0"},
+		{"update": map[string]any{"channel": "nightly"}},
+	} {
+		w := performJSONHandlerRequest(t, http.MethodPatch, "/api/server-config", patch, serverConfigPatchHandler)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %v, got %d body=%s", patch, w.Code, w.Body.String())
+		}
+	}
+
+	after, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("read config failed: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Fatalf("expected rejected patches to leave config untouched")
+	}
+}
+
+func TestServerConfigPatch_BindAddressRequiresRestart(t *testing.T) {
+	setupServerConfigPatchFixture(t)
+
+	w := performJSONHandlerRequest(t, http.MethodPatch, "/api/server-config", map[string]any{
+		"bindAddress": "127.0.0.1",
+	}, serverConfigPatchHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		RestartRequired []string `json:"restartRequired"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(resp.RestartRequired, []string{"bindAddress"}) || getServerConfig().BindAddress != DefaultConfig.BindAddress {
+		t.Fatalf("expected bindAddress to apply on restart, got %+v running=%q", resp, getServerConfig().BindAddress)
+	}
+}
+
+// "update" is not a restart-required key, so every field under it must apply to the
+// running config (and to the updater's HTTP client) as soon as it is patched.
+func TestServerConfigPatch_AppliesEveryUpdateFieldLive(t *testing.T) {
+	setupServerConfigPatchFixture(t)
+	serviceBackup := updaterService
+	t.Cleanup(func() { updaterService = serviceBackup })
+	initialClient := &http.Client{}
+	updaterService = &UpdaterService{httpClient: initialClient}
+
+	patched := UpdateConfig{
+		Enabled:            !DefaultConfig.Update.Enabled,
+		Channel:            updateChannelBeta,
+		CheckIntervalHours: DefaultConfig.Update.CheckIntervalHours + 1,
+		PromptOnNewVersion: !DefaultConfig.Update.PromptOnNewVersion,
+		IgnoredVersions:    []string{"v202601010000"},
+		Source: UpdateSourceConfig{
+			Repository:                    "example/mirror",
+			ManifestURLs:                  []string{"https://mirror.example.com/a/update-manifest.json"},
+			ManifestURL:                   "https://mirror.example.com/update-manifest.json",
+			RequestTimeoutSeconds:         DefaultConfig.Update.Source.RequestTimeoutSeconds + 1,
+			DownloadConnectTimeoutSeconds: DefaultConfig.Update.Source.DownloadConnectTimeoutSeconds + 1,
+		},
+	}
+	// Fail when a new update field is added without being covered here.
+	for _, pair := range [][2]reflect.Value{
+		{reflect.ValueOf(patched), reflect.ValueOf(DefaultConfig.Update)},
+		{reflect.ValueOf(patched.Source), reflect.ValueOf(DefaultConfig.Update.Source)},
+	} {
+		for i := 0; i < pair[0].NumField(); i++ {
+			if pair[0].Type().Field(i).Name == "Source" {
+				continue
+			}
+			if reflect.DeepEqual(pair[0].Field(i).Interface(), pair[1].Field(i).Interface()) {
+				t.Fatalf("test must change update field %s", pair[0].Type().Field(i).Name)
+			}
+		}
+	}
+
+	data, err := json.Marshal(patched)
+	if err != nil {
+		t.Fatalf("marshal update failed: %v", err)
+	}
+	var updatePatch map[string]any
+	if err := json.Unmarshal(data, &updatePatch); err != nil {
+		t.Fatalf("unmarshal update failed: %v", err)
+	}
+	w := performJSONHandlerRequest(t, http.MethodPatch, "/api/server-config", map[string]any{"update": updatePatch}, serverConfigPatchHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		RestartRequired []string `json:"restartRequired"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.RestartRequired) != 0 {
+		t.Fatalf("expected no restart for update fields, got %v", resp.RestartRequired)
+	}
+	if got := getServerConfig().Update; !reflect.DeepEqual(got, patched) {
+		t.Fatalf("expected update config to be hot-applied:\n got %+v\nwant %+v", got, patched)
+	}
+	if updaterService.client() == initialClient {
+		t.Fatalf("expected downloadConnectTimeoutSeconds to rebuild the updater HTTP client")
+	}
+}
@@ -8,10 +8,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,9 +32,12 @@ type TransferToken struct {
 	TotalBytes int64     // File size (for progress calculation)
 	MD5        string    // File MD5 hash (for download verification)
 	Category   string    // File category (scripts/files/reports)
+	ChunkSize  int       // Streaming buffer size for download (bytes, 0 = default)
 	// SharedSourceID links multiple one-time tokens to one temp source file.
 	// When all related tokens are consumed/expired, the temp file is deleted once.
 	SharedSourceID string
+	// BatchID is the pull-batch an upload token belongs to (empty for single pulls).
+	BatchID string
 }
 
 type md5CacheEntry struct {
@@ -85,10 +89,10 @@ func clearTransferRequestDeadlines(c *gin.Context) {
 	}
 	rc := http.NewResponseController(c.Writer)
 	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
-		debugLogf("⚠️ Failed to clear transfer read deadline: %v", err)
+		logDebug("Failed to clear transfer read deadline", "error", err)
 	}
 	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
-		debugLogf("⚠️ Failed to clear transfer write deadline: %v", err)
+		logDebug("Failed to clear transfer write deadline", "error", err)
 	}
 }
 
@@ -110,7 +114,7 @@ func makeTransferDeadlineTouchers(c *gin.Context, idleTimeout time.Duration) (to
 				readUnsupported = true
 				return
 			}
-			debugLogf("⚠️ Failed to update transfer read deadline: %v", err)
+			logDebug("Failed to update transfer read deadline", "error", err)
 		}
 	}
 
@@ -123,7 +127,7 @@ func makeTransferDeadlineTouchers(c *gin.Context, idleTimeout time.Duration) (to
 				writeUnsupported = true
 				return
 			}
-			debugLogf("⚠️ Failed to update transfer write deadline: %v", err)
+			logDebug("Failed to update transfer write deadline", "error", err)
 		}
 	}
 
@@ -146,6 +150,9 @@ var (
 	}{
 		entries: make(map[string]md5CacheEntry),
 	}
+	// uploadsInProgress holds upload tokens with a request currently writing, so resume
+	// attempts cannot interleave with a still-running upload. Guarded by transferTokensMu.
+	uploadsInProgress = make(map[string]bool)
 )
 
 // TransferProgress represents file transfer progress
@@ -157,6 +164,10 @@ type TransferProgress struct {
 	TotalBytes   int64   `json:"totalBytes"`
 	CurrentBytes int64   `json:"currentBytes"`
 	Percent      float64 `json:"percent"`
+	// Set on the aggregate progress of a pull-batch (type "pull-batch")
+	BatchID    string `json:"batchId,omitempty"`
+	FilesDone  int    `json:"filesDone,omitempty"`
+	FilesTotal int    `json:"filesTotal,omitempty"`
 }
 
 func isTempFilePath(filePath string) bool {
@@ -196,7 +207,7 @@ func removeTempFileWithRetry(filePath string) {
 		err := os.Remove(filePath)
 		if err == nil || os.IsNotExist(err) {
 			if err == nil {
-				debugLogf("🧹 Cleaned temp file: %s", filepath.Base(filePath))
+				logDebug("Cleaned temp file", "file", filepath.Base(filePath))
 			}
 			return
 		}
@@ -204,7 +215,7 @@ func removeTempFileWithRetry(filePath string) {
 			time.Sleep(300 * time.Millisecond)
 		}
 	}
-	log.Printf("⚠️ Failed to clean temp file: %s", filePath)
+	slog.Warn("Failed to clean temp file", "path", filePath)
 }
 
 func trimMD5CacheLocked() {
@@ -296,6 +307,7 @@ func createTransferTokenHandler(c *gin.Context) {
 		TargetPath string `json:"targetPath"` // Device-side target path (for download)
 		ExpireSecs int    `json:"expireSecs"` // Token TTL in seconds (default: 300)
 		OneTime    *bool  `json:"oneTime"`    // Invalidate after use (default: true)
+		ChunkSize  int    `json:"chunkSize"`  // Download chunk size in bytes (default: per-device/server)
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -323,6 +335,7 @@ func createTransferTokenHandler(c *gin.Context) {
 	// For download, file must exist
 	var fileSize int64
 	var fileMD5 string
+	var chunkSize int
 	if req.Type == "download" {
 		info, err := os.Stat(filePath)
 		if os.IsNotExist(err) {
@@ -348,6 +361,12 @@ func createTransferTokenHandler(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "targetPath is required for download"})
 			return
 		}
+		req.TargetPath, err = resolveDeviceTargetPath(req.TargetPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		chunkSize = resolveTransferChunkSize(req.DeviceSN, req.ChunkSize)
 	}
 
 	// For upload, create parent directory if needed
@@ -386,6 +405,7 @@ func createTransferTokenHandler(c *gin.Context) {
 		TotalBytes: fileSize,
 		MD5:        fileMD5,
 		Category:   req.Category,
+		ChunkSize:  chunkSize,
 	}
 	transferTokensMu.Unlock()
 
@@ -397,7 +417,7 @@ func createTransferTokenHandler(c *gin.Context) {
 		transferURL = fmt.Sprintf("/api/transfer/upload/%s", token)
 	}
 
-	debugLogf("🔑 Transfer token created: %s (%s) for device %s", token[:8]+"...", req.Type, req.DeviceSN)
+	logDebug("Transfer token created", "token", token[:8]+"...", "transfer_type", req.Type, "udid", req.DeviceSN)
 
 	c.JSON(http.StatusOK, gin.H{
 		"token":      token,
@@ -406,6 +426,8 @@ func createTransferTokenHandler(c *gin.Context) {
 		"expiresAt":  expiresAt.Unix(),
 		"totalBytes": fileSize,
 		"md5":        fileMD5,
+		"targetPath": req.TargetPath,
+		"chunkSize":  chunkSize,
 	})
 }
 
@@ -500,6 +522,8 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 // transferDownloadHandler handles GET /api/transfer/download/:token
 // This endpoint does NOT require authentication - the token IS the auth
 func transferDownloadHandler(c *gin.Context) {
+	defer beginTransfer()()
+
 	clearTransferRequestDeadlines(c)
 	_, touchWriteDeadline := makeTransferDeadlineTouchers(c, transferIOIdleTimeout)
 	if touchWriteDeadline != nil {
@@ -598,17 +622,24 @@ func transferDownloadHandler(c *gin.Context) {
 		},
 	}
 
-	debugLogf("📥 Download started: %s → device %s (%d bytes)",
-		fileName, tokenInfo.DeviceSN, info.Size())
+	logDebug("Transfer download started", "file", fileName, "udid", tokenInfo.DeviceSN, "bytes", info.Size())
 
-	// Stream file content
-	_, err = io.Copy(pw, file)
+	// Stream file content, hashing it on the way so on-disk corruption is caught
+	// without a second read of the file
+	hasher := md5.New()
+	copied, err := copyWithChunkSize(io.MultiWriter(pw, hasher), file, tokenInfo.ChunkSize)
+	recordMetricsTransferBytes("download", copied)
 	if err != nil {
-		log.Printf("❌ Download failed: %s - %v", fileName, err)
+		slog.Error("Transfer download failed", "file", fileName, "udid", tokenInfo.DeviceSN, "error", err)
 		return
 	}
+	if tokenInfo.MD5 != "" {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != tokenInfo.MD5 {
+			reportCorruptDownloadSource(tokenInfo, actual)
+		}
+	}
 
-	debugLogf("✅ Download completed: %s → device %s", fileName, tokenInfo.DeviceSN)
+	logDebug("Transfer download completed", "file", fileName, "udid", tokenInfo.DeviceSN)
 	// Do not treat HTTP stream completion as device fetch completion.
 	// Script-start orchestration must only be driven by device WS message:
 	// transfer/fetch/complete.
@@ -624,6 +655,8 @@ func transferDownloadHandler(c *gin.Context) {
 // transferUploadHandler handles PUT /api/transfer/upload/:token
 // This endpoint does NOT require authentication - the token IS the auth
 func transferUploadHandler(c *gin.Context) {
+	defer beginTransfer()()
+
 	clearTransferRequestDeadlines(c)
 	touchReadDeadline, _ := makeTransferDeadlineTouchers(c, transferIOIdleTimeout)
 	if touchReadDeadline != nil {
@@ -661,28 +694,79 @@ func transferUploadHandler(c *gin.Context) {
 		return
 	}
 
-	// Invalidate one-time token
-	if tokenInfo.OneTime {
-		transferTokensMu.Lock()
-		delete(transferTokens, token)
+	// One-time tokens stay valid across resume attempts until the upload completes,
+	// but only one request may write at a time.
+	transferTokensMu.Lock()
+	if uploadsInProgress[token] {
 		transferTokensMu.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "upload already in progress"})
+		return
 	}
+	uploadsInProgress[token] = true
+	transferTokensMu.Unlock()
+	defer func() {
+		transferTokensMu.Lock()
+		delete(uploadsInProgress, token)
+		transferTokensMu.Unlock()
+	}()
 
 	// Get content length
 	contentLength := c.Request.ContentLength
 
-	// Create file
-	file, err := os.Create(tokenInfo.FilePath)
+	uploadRange, hasRange, err := parseUploadContentRange(c.GetHeader("Content-Range"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	expectedTotal := tokenInfo.TotalBytes
+	if hasRange && expectedTotal <= 0 {
+		expectedTotal = uploadRange.total
+	}
+
+	var file *os.File
+	if hasRange {
+		var currentSize int64
+		if info, statErr := os.Stat(tokenInfo.FilePath); statErr == nil {
+			currentSize = info.Size()
+		} else if !os.IsNotExist(statErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stat file"})
+			return
+		}
+		if rangeErr := validateUploadRange(uploadRange, currentSize, tokenInfo.TotalBytes, contentLength); rangeErr != nil {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", currentSize))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": rangeErr.Error(), "uploaded": currentSize})
+			return
+		}
+		if uploadRange.start == 0 {
+			file, err = os.Create(tokenInfo.FilePath)
+		} else {
+			file, err = os.OpenFile(tokenInfo.FilePath, os.O_WRONLY|os.O_APPEND, 0644)
+		}
+	} else {
+		file, err = os.Create(tokenInfo.FilePath)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create file"})
 		return
 	}
 	defer file.Close()
 
+	var body io.Reader = c.Request.Body
+	progressTotal := contentLength
+	var offset int64
+	if hasRange {
+		body = io.LimitReader(body, uploadRange.end-uploadRange.start+1)
+		offset = uploadRange.start
+		if expectedTotal > 0 {
+			progressTotal = expectedTotal
+		}
+	}
+
 	// Create progress reader
 	pr := &ProgressReader{
-		r:           c.Request.Body,
-		total:       contentLength,
+		r:           body,
+		total:       progressTotal,
+		read:        offset,
 		token:       token,
 		deviceSN:    tokenInfo.DeviceSN,
 		filePath:    tokenInfo.FilePath,
@@ -695,42 +779,148 @@ func transferUploadHandler(c *gin.Context) {
 	}
 
 	fileName := filepath.Base(tokenInfo.FilePath)
-	debugLogf("📤 Upload started: device %s → %s (%d bytes)",
-		tokenInfo.DeviceSN, fileName, contentLength)
+	logDebug("Transfer upload started", "udid", tokenInfo.DeviceSN, "file", fileName, "bytes", contentLength, "offset", offset)
 
 	// Copy with progress tracking
 	hashWriter := md5.New()
 	written, err := io.Copy(io.MultiWriter(file, hashWriter), pr)
+	recordMetricsTransferBytes("upload", written)
 	if err != nil {
-		log.Printf("❌ Upload failed: %s - %v", fileName, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write file"})
+		slog.Error("Transfer upload failed", "file", fileName, "udid", tokenInfo.DeviceSN, "offset", offset, "bytes", written, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to write file", "uploaded": offset + written})
+		return
+	}
+
+	info, statErr := file.Stat()
+	if statErr != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stat file"})
+		return
+	}
+	uploaded := info.Size()
+	if hasRange && expectedTotal > 0 && uploaded < expectedTotal {
+		logDebug("Transfer upload chunk stored", "udid", tokenInfo.DeviceSN, "file", fileName, "uploaded", uploaded, "total", expectedTotal)
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"complete": false,
+			"bytes":    written,
+			"uploaded": uploaded,
+			"path":     tokenInfo.FilePath,
+		})
 		return
 	}
 
-	// MD5 is computed while streaming upload data to avoid a second full-file read.
+	// A single-request upload is hashed while streaming; a resumed one is re-hashed in full.
 	md5Hash := hex.EncodeToString(hashWriter.Sum(nil))
-	if info, statErr := file.Stat(); statErr == nil {
-		md5Cache.Lock()
-		trimMD5CacheLocked()
-		md5Cache.entries[tokenInfo.FilePath] = md5CacheEntry{
-			size:    info.Size(),
-			modTime: info.ModTime().UnixNano(),
-			hash:    md5Hash,
+	if offset > 0 {
+		if md5Hash, err = calculateFileMD5(tokenInfo.FilePath); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash file"})
+			return
 		}
-		md5Cache.Unlock()
 	}
+	md5Cache.Lock()
+	trimMD5CacheLocked()
+	md5Cache.entries[tokenInfo.FilePath] = md5CacheEntry{
+		size:    uploaded,
+		modTime: info.ModTime().UnixNano(),
+		hash:    md5Hash,
+	}
+	md5Cache.Unlock()
 
-	debugLogf("✅ Upload completed: device %s → %s (%d bytes, MD5: %s)",
-		tokenInfo.DeviceSN, fileName, written, md5Hash)
+	// Invalidate one-time token
+	if tokenInfo.OneTime {
+		transferTokensMu.Lock()
+		delete(transferTokens, token)
+		transferTokensMu.Unlock()
+	}
+
+	logDebug("Transfer upload completed", "udid", tokenInfo.DeviceSN, "file", fileName, "bytes", written, "md5", md5Hash)
+	notePullBatchUploadComplete(tokenInfo.BatchID, token)
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"bytes":   written,
-		"md5":     md5Hash,
-		"path":    tokenInfo.FilePath,
+		"success":  true,
+		"complete": true,
+		"bytes":    written,
+		"uploaded": uploaded,
+		"md5":      md5Hash,
+		"path":     tokenInfo.FilePath,
 	})
 }
 
+// uploadContentRange is a parsed "Content-Range: bytes start-end/total" header
+// (total is -1 for "*").
+type uploadContentRange struct {
+	start int64
+	end   int64
+	total int64
+}
+
+func parseUploadContentRange(header string) (uploadContentRange, bool, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return uploadContentRange{}, false, nil
+	}
+	invalid := errors.New("invalid Content-Range header")
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return uploadContentRange{}, false, invalid
+	}
+	span, totalPart, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	if !ok {
+		return uploadContentRange{}, false, invalid
+	}
+	startPart, endPart, ok := strings.Cut(span, "-")
+	if !ok {
+		return uploadContentRange{}, false, invalid
+	}
+	r := uploadContentRange{total: -1}
+	var err error
+	if r.start, err = strconv.ParseInt(startPart, 10, 64); err != nil || r.start < 0 {
+		return uploadContentRange{}, false, invalid
+	}
+	if r.end, err = strconv.ParseInt(endPart, 10, 64); err != nil || r.end < r.start {
+		return uploadContentRange{}, false, invalid
+	}
+	if totalPart != "*" {
+		if r.total, err = strconv.ParseInt(totalPart, 10, 64); err != nil || r.total <= r.end {
+			return uploadContentRange{}, false, invalid
+		}
+	}
+	return r, true, nil
+}
+
+// validateUploadRange checks that a chunk continues exactly at the size already on disk.
+func validateUploadRange(r uploadContentRange, currentSize int64, tokenTotal int64, contentLength int64) error {
+	if r.start != currentSize {
+		return fmt.Errorf("range start %d does not match uploaded size %d", r.start, currentSize)
+	}
+	if tokenTotal > 0 && r.total >= 0 && r.total != tokenTotal {
+		return fmt.Errorf("range total %d does not match expected size %d", r.total, tokenTotal)
+	}
+	if tokenTotal > 0 && r.end >= tokenTotal {
+		return fmt.Errorf("range end %d exceeds expected size %d", r.end, tokenTotal)
+	}
+	if contentLength >= 0 && contentLength != r.end-r.start+1 {
+		return errors.New("range length does not match Content-Length")
+	}
+	return nil
+}
+
+// reportCorruptDownloadSource handles a download whose streamed bytes did not match the
+// MD5 recorded when the token was created: the source changed or rotted on disk.
+func reportCorruptDownloadSource(tokenInfo *TransferToken, actualMD5 string) {
+	fileName := filepath.Base(tokenInfo.FilePath)
+	slog.Error("Transfer download source MD5 mismatch", "file", fileName, "udid", tokenInfo.DeviceSN, "expected", tokenInfo.MD5, "actual", actualMD5)
+
+	// Drop the cached hash so the next token for this file is hashed from disk again
+	md5Cache.Lock()
+	delete(md5Cache.entries, tokenInfo.FilePath)
+	md5Cache.Unlock()
+
+	if tokenInfo.DeviceSN != "" {
+		broadcastDeviceMessage(tokenInfo.DeviceSN, fmt.Sprintf("源文件校验失败 %s", fileName))
+	}
+}
+
 // calculateFileMD5Cached calculates the MD5 hash with a small cache keyed by path/size/mtime
 func calculateFileMD5Cached(filePath string, info os.FileInfo) (string, error) {
 	if info == nil {
@@ -798,9 +988,38 @@ func snapshotControllerConns() []*SafeConn {
 	return controllerList
 }
 
-// broadcastTransferProgress sends transfer progress to all connected controllers
+// snapshotEventRecipients returns the controllers that receive transfer/progress and
+// device/message events of udid: those subscribed to it through control/events/subscribe,
+// plus every controller without event subscriptions, which still gets all devices' events.
+// Events without a device go to every controller.
+func snapshotEventRecipients(udid string) []*SafeConn {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(controllers) == 0 {
+		return nil
+	}
+	if udid == "" || len(eventSubscriptions) == 0 {
+		return snapshotControllerConnsLocked()
+	}
+	filtered := make(map[*SafeConn]bool)
+	for _, subs := range eventSubscriptions {
+		for conn := range subs {
+			filtered[conn] = true
+		}
+	}
+	subscribed := eventSubscriptions[udid]
+	controllerList := make([]*SafeConn, 0, len(controllers))
+	for conn := range controllers {
+		if !filtered[conn] || subscribed[conn] {
+			controllerList = append(controllerList, conn)
+		}
+	}
+	return controllerList
+}
+
+// broadcastTransferProgress sends transfer progress to the controllers following its device
 func broadcastTransferProgress(progress TransferProgress) {
-	controllerList := snapshotControllerConns()
+	controllerList := snapshotEventRecipients(progress.DeviceSN)
 	if len(controllerList) == 0 {
 		return
 	}
@@ -812,7 +1031,7 @@ func broadcastTransferProgress(progress TransferProgress) {
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("❌ Failed to marshal progress: %v", err)
+		slog.Error("Failed to marshal transfer progress", "error", err)
 		return
 	}
 
@@ -821,9 +1040,9 @@ func broadcastTransferProgress(progress TransferProgress) {
 	}
 }
 
-// broadcastDeviceMessage sends a status message for a device to all connected controllers
+// broadcastDeviceMessage sends a status message for a device to the controllers following it
 func broadcastDeviceMessage(udid string, message string) {
-	controllerList := snapshotControllerConns()
+	controllerList := snapshotEventRecipients(udid)
 	if len(controllerList) == 0 {
 		return
 	}
@@ -838,7 +1057,7 @@ func broadcastDeviceMessage(udid string, message string) {
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("❌ Failed to marshal device message: %v", err)
+		slog.Error("Failed to marshal device message", "error", err)
 		return
 	}
 
@@ -848,8 +1067,28 @@ func broadcastDeviceMessage(udid string, message string) {
 	}
 }
 
-// sendFileDownloadCommand sends a file download command to a device
-func sendFileDownloadCommand(deviceSN string, downloadURL string, targetPath string, md5 string, totalBytes int64, timeout int) error {
+// broadcastControllerEvent sends an event message to all connected controllers
+func broadcastControllerEvent(msgType string, body interface{}) {
+	recordServerEvent(msgType, "", body)
+	controllerList := snapshotControllerConns()
+	if len(controllerList) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(Message{Type: msgType, Body: body})
+	if err != nil {
+		slog.Error("Failed to marshal transfer event", "msg_type", msgType, "error", err)
+		return
+	}
+
+	for _, conn := range controllerList {
+		writeTextMessageAsync(conn, data)
+	}
+}
+
+// sendFileDownloadCommand sends a file download command to a device. The device echoes
+// requestID in its transfer/fetch/complete report.
+func sendFileDownloadCommand(deviceSN string, requestID string, downloadURL string, targetPath string, md5 string, totalBytes int64, timeout int, chunkSize int) error {
 	mu.RLock()
 	conn, exists := deviceLinks[deviceSN]
 	mu.RUnlock()
@@ -863,9 +1102,11 @@ func sendFileDownloadCommand(deviceSN string, downloadURL string, targetPath str
 		Body: map[string]interface{}{
 			"url":        downloadURL,
 			"targetPath": targetPath,
+			"requestId":  requestID,
 			"md5":        md5,
 			"totalBytes": totalBytes,
 			"timeout":    timeout,
+			"chunkSize":  chunkSize,
 		},
 	}
 
@@ -877,8 +1118,28 @@ func sendFileDownloadCommand(deviceSN string, downloadURL string, targetPath str
 	return conn.WriteMessage(1, data)
 }
 
-// sendFileUploadCommand sends a file upload command to a device
-func sendFileUploadCommand(deviceSN string, uploadURL string, sourcePath string, savePath string, timeout int) error {
+// reportPushCompletion tells controllers how a push-to-device transfer/fetch ended.
+func reportPushCompletion(deviceSN, path, targetPath string, result transferFetchResult) {
+	name := filepath.Base(path)
+	if result.Success {
+		broadcastDeviceMessage(deviceSN, fmt.Sprintf("文件 %s 传输完成", name))
+	} else if result.Error != "" {
+		broadcastDeviceMessage(deviceSN, fmt.Sprintf("文件 %s 传输失败: %s", name, result.Error))
+	} else {
+		broadcastDeviceMessage(deviceSN, fmt.Sprintf("文件 %s 传输失败", name))
+	}
+	recordServerEvent("transfer/push/complete", deviceSN, gin.H{
+		"requestId":  result.RequestID,
+		"path":       path,
+		"targetPath": targetPath,
+		"success":    result.Success,
+		"error":      result.Error,
+	})
+}
+
+// sendFileUploadCommand sends a file upload command to a device; batchID is set for
+// files of a pull-batch.
+func sendFileUploadCommand(deviceSN string, uploadURL string, sourcePath string, savePath string, timeout int, batchID string) error {
 	mu.RLock()
 	conn, exists := deviceLinks[deviceSN]
 	mu.RUnlock()
@@ -887,14 +1148,18 @@ func sendFileUploadCommand(deviceSN string, uploadURL string, sourcePath string,
 		return fmt.Errorf("device %s not connected", deviceSN)
 	}
 
+	body := map[string]interface{}{
+		"url":        uploadURL,
+		"sourcePath": sourcePath,
+		"savePath":   savePath,
+		"timeout":    timeout,
+	}
+	if batchID != "" {
+		body["batchId"] = batchID
+	}
 	cmd := Message{
 		Type: "transfer/send",
-		Body: map[string]interface{}{
-			"url":        uploadURL,
-			"sourcePath": sourcePath,
-			"savePath":   savePath,
-			"timeout":    timeout,
-		},
+		Body: body,
 	}
 
 	data, err := json.Marshal(cmd)
@@ -907,7 +1172,7 @@ func sendFileUploadCommand(deviceSN string, uploadURL string, sourcePath string,
 
 // pushFileToDeviceHandler handles POST /api/transfer/push-to-device
 // High-level API that creates token and sends command in one call
-// Uses file/put for small files (<128KB) and transfer/fetch for large files
+// Uses file/put for small files (<largeFileThresholdBytes) and transfer/fetch for large files
 func pushFileToDeviceHandler(c *gin.Context) {
 	var req struct {
 		DeviceSN       string `json:"deviceSN"`
@@ -917,6 +1182,7 @@ func pushFileToDeviceHandler(c *gin.Context) {
 		Timeout        int    `json:"timeout"`       // Download timeout in seconds
 		ServerBaseUrl  string `json:"serverBaseUrl"` // Server base URL for device to download from
 		SharedSourceID string `json:"sharedSourceId"`
+		ChunkSize      int    `json:"chunkSize"` // Download chunk size in bytes (default: per-device/server)
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -929,6 +1195,13 @@ func pushFileToDeviceHandler(c *gin.Context) {
 		return
 	}
 
+	targetPath, err := resolveDeviceTargetPath(req.TargetPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.TargetPath = targetPath
+
 	// Validate file
 	filePath, err := validatePath(req.Category, req.Path)
 	if err != nil {
@@ -946,11 +1219,10 @@ func pushFileToDeviceHandler(c *gin.Context) {
 		return
 	}
 
-	const LargeFileThreshold int64 = 128 * 1024 // 128KB
 	fileSize := info.Size()
 
 	// Small file: use file/put via WebSocket
-	if fileSize < LargeFileThreshold {
+	if fileSize < largeFileThreshold() {
 		content, err := os.ReadFile(filePath)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
@@ -985,12 +1257,14 @@ func pushFileToDeviceHandler(c *gin.Context) {
 		// Broadcast status to frontend
 		broadcastDeviceMessage(req.DeviceSN, fmt.Sprintf("发送文件 %s", filepath.Base(req.Path)))
 
-		debugLogf("📤 Push file (small): %s → device %s:%s (%d bytes)", req.Path, req.DeviceSN, req.TargetPath, fileSize)
+		logDebug("Push file to device", "method", "file/put", "path", req.Path, "udid", req.DeviceSN, "target_path", req.TargetPath, "bytes", fileSize)
+		recordServerEvent("transfer/push", req.DeviceSN, gin.H{"path": req.Path, "targetPath": req.TargetPath, "totalBytes": fileSize})
 
 		c.JSON(http.StatusOK, gin.H{
 			"success":    true,
 			"method":     "file/put",
 			"totalBytes": fileSize,
+			"targetPath": req.TargetPath,
 		})
 		return
 	}
@@ -1001,6 +1275,7 @@ func pushFileToDeviceHandler(c *gin.Context) {
 	expiresAt := time.Now().Add(transferTokenTTLForTimeout(timeout))
 
 	md5Hash, _ := calculateFileMD5Cached(filePath, info)
+	chunkSize := resolveTransferChunkSize(req.DeviceSN, req.ChunkSize)
 
 	transferTokensMu.Lock()
 	if req.SharedSourceID != "" {
@@ -1017,6 +1292,7 @@ func pushFileToDeviceHandler(c *gin.Context) {
 		MD5:            md5Hash,
 		Category:       req.Category,
 		SharedSourceID: req.SharedSourceID,
+		ChunkSize:      chunkSize,
 	}
 	transferTokensMu.Unlock()
 
@@ -1029,7 +1305,13 @@ func pushFileToDeviceHandler(c *gin.Context) {
 	// Broadcast status to frontend
 	broadcastDeviceMessage(req.DeviceSN, fmt.Sprintf("下载文件 %s", filepath.Base(req.Path)))
 
-	if err := sendFileDownloadCommand(req.DeviceSN, downloadURL, req.TargetPath, md5Hash, info.Size(), timeout); err != nil {
+	requestID := uuid.New().String()
+	registerTransferCompletion(req.DeviceSN, requestID, transferTokenTTLForTimeout(timeout), func(result transferFetchResult) {
+		reportPushCompletion(req.DeviceSN, req.Path, req.TargetPath, result)
+	})
+
+	if err := sendFileDownloadCommand(req.DeviceSN, requestID, downloadURL, req.TargetPath, md5Hash, info.Size(), timeout, chunkSize); err != nil {
+		unregisterTransferCompletion(req.DeviceSN, requestID)
 		// Cleanup token on failure
 		sharedID := ""
 		transferTokensMu.Lock()
@@ -1045,14 +1327,18 @@ func pushFileToDeviceHandler(c *gin.Context) {
 		return
 	}
 
-	debugLogf("📤 Push file (large): %s → device %s:%s (%d bytes)", req.Path, req.DeviceSN, req.TargetPath, fileSize)
+	logDebug("Push file to device", "method", "transfer/fetch", "path", req.Path, "udid", req.DeviceSN, "target_path", req.TargetPath, "bytes", fileSize)
+	recordServerEvent("transfer/push", req.DeviceSN, gin.H{"path": req.Path, "targetPath": req.TargetPath, "totalBytes": fileSize})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":    true,
 		"method":     "transfer/fetch",
 		"token":      token,
+		"requestId":  requestID,
 		"totalBytes": info.Size(),
 		"md5":        md5Hash,
+		"targetPath": req.TargetPath,
+		"chunkSize":  chunkSize,
 	})
 }
 
@@ -1115,7 +1401,7 @@ func pullFileFromDeviceHandler(c *gin.Context) {
 	uploadURL := transferBaseURL + uploadPath
 
 	// Send command to device
-	if err := sendFileUploadCommand(req.DeviceSN, uploadURL, req.SourcePath, req.Path, timeout); err != nil {
+	if err := sendFileUploadCommand(req.DeviceSN, uploadURL, req.SourcePath, req.Path, timeout, ""); err != nil {
 		// Cleanup token on failure
 		transferTokensMu.Lock()
 		delete(transferTokens, token)
@@ -1124,7 +1410,8 @@ func pullFileFromDeviceHandler(c *gin.Context) {
 		return
 	}
 
-	debugLogf("📥 Pull file initiated: device %s:%s → %s", req.DeviceSN, req.SourcePath, req.Path)
+	logDebug("Pull file from device", "udid", req.DeviceSN, "source_path", req.SourcePath, "path", req.Path)
+	recordServerEvent("transfer/pull", req.DeviceSN, gin.H{"sourcePath": req.SourcePath, "path": req.Path})
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -3,11 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -85,9 +87,9 @@ func setupTransferTokenCreateTest(t *testing.T) (dataDir string, filePath string
 	t.Cleanup(resetTransferTokensForTest)
 
 	dataDir = t.TempDir()
-	prevDataDir := serverConfig.DataDir
-	serverConfig.DataDir = dataDir
-	t.Cleanup(func() { serverConfig.DataDir = prevDataDir })
+	prevDataDir := getServerConfig().DataDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = dataDir })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = prevDataDir }) })
 
 	scriptsDir := filepath.Join(dataDir, "scripts")
 	if err := os.MkdirAll(scriptsDir, 0o755); err != nil {
@@ -260,6 +262,68 @@ func TestTransferDownloadHandler_DoesNotCompletePendingScriptStartOnHTTPCopy(t *
 	}
 }
 
+func TestTransferDownloadHandler_ReportsSourceMD5Mismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resetTransferTokensForTest()
+	resetMD5Cache()
+	t.Cleanup(resetTransferTokensForTest)
+	t.Cleanup(resetMD5Cache)
+
+	controllerConn, client := newTestWebSocketPair(t)
+	mu.Lock()
+	controllersBackup := controllers
+	controllers = map[*SafeConn]bool{controllerConn: true}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		controllers = controllersBackup
+		mu.Unlock()
+	})
+
+	filePath := filepath.Join(t.TempDir(), "rotten.bin")
+	if err := os.WriteFile(filePath, []byte("rotten payload"), 0o644); err != nil {
+		t.Fatalf("write payload failed: %v", err)
+	}
+	md5Cache.Lock()
+	md5Cache.entries[filePath] = md5CacheEntry{hash: "00000000000000000000000000000000"}
+	md5Cache.Unlock()
+
+	const token = "corrupt-token"
+	transferTokensMu.Lock()
+	transferTokens[token] = &TransferToken{
+		Type:      "download",
+		FilePath:  filePath,
+		DeviceSN:  "device-1",
+		ExpiresAt: time.Now().Add(time.Minute),
+		MD5:       "00000000000000000000000000000000",
+	}
+	transferTokensMu.Unlock()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "token", Value: token}}
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/transfer/download/"+token, nil)
+	transferDownloadHandler(c)
+
+	if w.Code != http.StatusOK || w.Body.String() != "rotten payload" {
+		t.Fatalf("unexpected download response: %d %q", w.Code, w.Body.String())
+	}
+	msg := readTestMessage(t, client)
+	for msg.Type == "transfer/progress" {
+		msg = readTestMessage(t, client)
+	}
+	body, _ := msg.Body.(map[string]interface{})
+	if msg.Type != "device/message" || body["udid"] != "device-1" || !strings.Contains(fmt.Sprint(body["message"]), "rotten.bin") {
+		t.Fatalf("unexpected corruption notice: %#v", msg)
+	}
+	md5Cache.RLock()
+	_, cached := md5Cache.entries[filePath]
+	md5Cache.RUnlock()
+	if cached {
+		t.Fatalf("mismatched MD5 should be evicted from the cache")
+	}
+}
+
 func TestNormalizeTransferTimeoutSeconds_DefaultValue(t *testing.T) {
 	got := normalizeTransferTimeoutSeconds(0)
 	if got != defaultTransferTimeoutSec {
@@ -325,3 +389,245 @@ func TestProgressReader_TouchReadCalled(t *testing.T) {
 		t.Fatalf("expected touchRead called once, got %d", got)
 	}
 }
+
+func TestResolveDeviceTargetPath(t *testing.T) {
+	backup := getServerConfig().DeviceBasePath
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceBasePath = backup }) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceBasePath = "" })
+	if got, err := resolveDeviceTargetPath("res/a.txt"); err != nil || got != "res/a.txt" {
+		t.Fatalf("expected pass-through without base, got %q err=%v", got, err)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceBasePath = "/var/mobile/Media/1ferver" })
+	cases := map[string]string{
+		"res/a.txt":     "/var/mobile/Media/1ferver/res/a.txt",
+		"./res/../b.db": "/var/mobile/Media/1ferver/b.db",
+		"res/dir/":      "/var/mobile/Media/1ferver/res/dir/",
+		"/tmp/abs.txt":  "/tmp/abs.txt",
+	}
+	for input, want := range cases {
+		got, err := resolveDeviceTargetPath(input)
+		if err != nil || got != want {
+			t.Fatalf("resolve %q: expected %q, got %q err=%v", input, want, got, err)
+		}
+	}
+
+	if _, err := resolveDeviceTargetPath("../escape.txt"); err == nil {
+		t.Fatalf("expected escaping relative path to be rejected")
+	}
+}
+
+func TestCreateTransferToken_JoinsRelativeTargetOntoDeviceBasePath(t *testing.T) {
+	setupTransferTokenCreateTest(t)
+	backup := getServerConfig().DeviceBasePath
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceBasePath = "/var/mobile/Media/1ferver" })
+	t.Cleanup(func() { updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceBasePath = backup }) })
+
+	token := createTransferTokenWithPayload(t, map[string]any{
+		"type":       "download",
+		"deviceSN":   "device-1",
+		"category":   "scripts",
+		"path":       "token.txt",
+		"targetPath": "res/token.txt",
+	})
+
+	transferTokensMu.RLock()
+	info := transferTokens[token]
+	transferTokensMu.RUnlock()
+	if info == nil || info.TargetPath != "/var/mobile/Media/1ferver/res/token.txt" {
+		t.Fatalf("expected resolved target path, got %+v", info)
+	}
+}
+
+func TestResolveTransferChunkSize_PrecedenceAndClamp(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.TransferChunkSize = 0 })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceTransferChunkSizes = map[string]int{"slow": 8 * 1024} })
+	if got := resolveTransferChunkSize("other", 0); got != defaultTransferChunkSize {
+		t.Fatalf("expected default chunk size, got %d", got)
+	}
+	if got := resolveTransferChunkSize("slow", 0); got != 8*1024 {
+		t.Fatalf("expected per-device chunk size, got %d", got)
+	}
+	if got := resolveTransferChunkSize("slow", 256*1024); got != 256*1024 {
+		t.Fatalf("expected per-push chunk size to win, got %d", got)
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.TransferChunkSize = 1 })
+	if got := resolveTransferChunkSize("other", 0); got != minTransferChunkSize {
+		t.Fatalf("expected clamp to min, got %d", got)
+	}
+	if got := resolveTransferChunkSize("other", 64*1024*1024); got != maxTransferChunkSize {
+		t.Fatalf("expected clamp to max, got %d", got)
+	}
+}
+
+type maxWriteRecorder struct {
+	bytes.Buffer
+	maxWrite int
+}
+
+func (w *maxWriteRecorder) Write(p []byte) (int, error) {
+	if len(p) > w.maxWrite {
+		w.maxWrite = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestCopyWithChunkSize_UsesRequestedBufferForFiles(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "big.bin")
+	content := bytes.Repeat([]byte("x"), 100*1024)
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatalf("open file: %v", err)
+	}
+	defer file.Close()
+
+	var dst maxWriteRecorder
+	n, err := copyWithChunkSize(&dst, file, 8*1024)
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("copy failed: n=%d err=%v", n, err)
+	}
+	if dst.maxWrite != 8*1024 {
+		t.Fatalf("expected 8KiB writes, got max write %d", dst.maxWrite)
+	}
+}
+
+func performTransferUpload(t *testing.T, token string, body []byte, contentRange string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{{Key: "token", Value: token}}
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/transfer/upload/"+token, bytes.NewReader(body))
+	if contentRange != "" {
+		c.Request.Header.Set("Content-Range", contentRange)
+	}
+	transferUploadHandler(c)
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid json body %q: %v", w.Body.String(), err)
+	}
+	return w.Code, resp
+}
+
+func TestTransferUploadHandler_ResumesWithContentRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	resetTransferTokensForTest()
+	resetMD5Cache()
+	t.Cleanup(resetTransferTokensForTest)
+
+	filePath := filepath.Join(t.TempDir(), "upload.bin")
+	const token = "upload-token"
+	payload := []byte("0123456789abcdef")
+	transferTokensMu.Lock()
+	transferTokens[token] = &TransferToken{
+		Type:       "upload",
+		FilePath:   filePath,
+		ExpiresAt:  time.Now().Add(time.Minute),
+		OneTime:    true,
+		TotalBytes: int64(len(payload)),
+	}
+	transferTokensMu.Unlock()
+
+	code, resp := performTransferUpload(t, token, payload[:6], "bytes 0-5/16")
+	if code != http.StatusOK || resp["complete"] != false || resp["uploaded"] != float64(6) {
+		t.Fatalf("unexpected first chunk response %d %v", code, resp)
+	}
+
+	code, resp = performTransferUpload(t, token, payload[4:], "bytes 4-15/16")
+	if code != http.StatusRequestedRangeNotSatisfiable || resp["uploaded"] != float64(6) {
+		t.Fatalf("expected 416 for mismatched offset, got %d %v", code, resp)
+	}
+
+	code, resp = performTransferUpload(t, token, payload[6:], "bytes 6-15/16")
+	if code != http.StatusOK || resp["complete"] != true {
+		t.Fatalf("unexpected final chunk response %d %v", code, resp)
+	}
+	wantMD5, _ := calculateFileMD5(filePath)
+	if resp["md5"] != wantMD5 {
+		t.Fatalf("expected md5 over the full file %s, got %v", wantMD5, resp["md5"])
+	}
+	if data, _ := os.ReadFile(filePath); !bytes.Equal(data, payload) {
+		t.Fatalf("unexpected file content %q", data)
+	}
+
+	transferTokensMu.RLock()
+	_, exists := transferTokens[token]
+	transferTokensMu.RUnlock()
+	if exists {
+		t.Fatalf("one-time token should be consumed after the upload completes")
+	}
+}
+
+func TestParseUploadContentRange(t *testing.T) {
+	if _, ok, err := parseUploadContentRange(""); ok || err != nil {
+		t.Fatalf("empty header should mean no range")
+	}
+	r, ok, err := parseUploadContentRange("bytes 10-19/*")
+	if !ok || err != nil || r.start != 10 || r.end != 19 || r.total != -1 {
+		t.Fatalf("unexpected range %+v ok=%t err=%v", r, ok, err)
+	}
+	for _, header := range []string{"bytes 5-1/10", "bytes 0-9/9", "items 0-1/2", "bytes 0-/*"} {
+		if _, _, err := parseUploadContentRange(header); err == nil {
+			t.Fatalf("expected %q to be rejected", header)
+		}
+	}
+}
+
+func TestEventSubscriptions_FilterTransferProgressAndDeviceMessages(t *testing.T) {
+	resetUsedNoncesForTest()
+	mu.Lock()
+	controllersBackup, subsBackup := controllers, eventSubscriptions
+	controllers = make(map[*SafeConn]bool)
+	eventSubscriptions = make(map[string]map[*SafeConn]bool)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		controllers, eventSubscriptions = controllersBackup, subsBackup
+		mu.Unlock()
+	})
+
+	everything, everythingClient := newTestWebSocketPair(t)
+	focused, focusedClient := newTestWebSocketPair(t)
+	mu.Lock()
+	controllers[everything] = true
+	mu.Unlock()
+
+	subscribe := func(msgType, nonce string, devices []string) {
+		t.Helper()
+		msg := signTestMessage(Message{Type: msgType, TS: time.Now().Unix(), Nonce: nonce, Body: map[string]interface{}{"devices": devices}})
+		if err := handleMessage(focused, msg); err != nil {
+			t.Fatalf("%s failed: %v", msgType, err)
+		}
+	}
+	subscribe("control/events/subscribe", "events-1", []string{"d1"})
+
+	broadcastDeviceMessage("d2", "queue full")
+	broadcastTransferProgress(TransferProgress{Token: "t1", DeviceSN: "d1", Type: "download", Percent: 50})
+
+	// Broadcasts are written asynchronously, so only the set of messages is fixed.
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		received[readTestMessage(t, everythingClient).Type] = true
+	}
+	if !received["device/message"] || !received["transfer/progress"] {
+		t.Fatalf("unfiltered controller should get d2's message and d1's progress, got %v", received)
+	}
+	msg := readTestMessage(t, focusedClient)
+	if body, _ := msg.Body.(map[string]interface{}); msg.Type != "transfer/progress" || body["deviceSN"] != "d1" {
+		t.Fatalf("subscribed controller should skip d2 and get d1's progress, got %+v", msg)
+	}
+
+	subscribe("control/events/unsubscribe", "events-2", nil)
+	broadcastDeviceMessage("d2", "back to everything")
+	if msg := readTestMessage(t, focusedClient); msg.Type != "device/message" {
+		t.Fatalf("unsubscribing from all should restore every device's events, got %+v", msg)
+	}
+}
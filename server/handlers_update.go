@@ -20,7 +20,7 @@ func updateCheckHandler(c *gin.Context) {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "updater not initialized"})
 		return
 	}
-	ctx, cancel := context.WithTimeout(c.Request.Context(), getUpdateCheckTimeout(serverConfig.Update.Source))
+	ctx, cancel := context.WithTimeout(c.Request.Context(), getUpdateCheckTimeout(getServerConfig().Update.Source))
 	defer cancel()
 	status, err := updaterService.Check(ctx)
 	if err != nil {
@@ -88,3 +88,23 @@ func updateApplyHandler(c *gin.Context) {
 		"status":  status,
 	})
 }
+
+func updateRollbackHandler(c *gin.Context) {
+	if updaterService == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "updater not initialized"})
+		return
+	}
+	status, err := updaterService.Rollback()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":  err.Error(),
+			"status": status,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "rollback started, server will restart shortly",
+		"status":  status,
+	})
+}
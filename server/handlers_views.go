@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// generateViewID generates a unique saved view ID
+func generateViewID() string {
+	return fmt.Sprintf("v%d", time.Now().UnixNano())
+}
+
+type savedViewRequest struct {
+	Name      string   `json:"name"`
+	DeviceIDs []string `json:"deviceIds"`
+	GroupIDs  []string `json:"groupIds"`
+}
+
+// resolveSavedViewDevices expands a saved view into concrete device IDs.
+// Explicit devices come first, followed by members of the selected groups.
+func resolveSavedViewDevices(viewID string) ([]string, bool) {
+	savedViewsMu.RLock()
+	var view SavedView
+	found := false
+	for _, v := range savedViews {
+		if v.ID == viewID {
+			view = v
+			found = true
+			break
+		}
+	}
+	savedViewsMu.RUnlock()
+
+	if !found {
+		return nil, false
+	}
+
+	devices := append([]string(nil), view.DeviceIDs...)
+	if len(view.GroupIDs) > 0 {
+		selected := make(map[string]struct{}, len(view.GroupIDs))
+		for _, id := range view.GroupIDs {
+			selected[id] = struct{}{}
+		}
+		deviceGroupsMu.RLock()
+		for _, group := range deviceGroups {
+			if _, ok := selected[group.ID]; ok {
+				devices = append(devices, group.DeviceIDs...)
+			}
+		}
+		deviceGroupsMu.RUnlock()
+	}
+
+	return uniqueDeviceIDs(devices), true
+}
+
+// expandDevicesWithView appends the devices of the given saved view (if any)
+// to an explicit device list. Unknown views contribute nothing.
+func expandDevicesWithView(devices []string, viewID string) []string {
+	viewID = strings.TrimSpace(viewID)
+	if viewID == "" {
+		return devices
+	}
+	viewDevices, ok := resolveSavedViewDevices(viewID)
+	if !ok {
+		return devices
+	}
+	return uniqueDeviceIDs(append(append([]string(nil), devices...), viewDevices...))
+}
+
+// viewsListHandler handles GET /api/views
+func viewsListHandler(c *gin.Context) {
+	savedViewsMu.RLock()
+	defer savedViewsMu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{"views": savedViews})
+}
+
+// viewsCreateHandler handles POST /api/views
+func viewsCreateHandler(c *gin.Context) {
+	var req savedViewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "View name cannot be empty"})
+		return
+	}
+
+	newView := SavedView{
+		ID:        generateViewID(),
+		Name:      name,
+		DeviceIDs: uniqueDeviceIDs(req.DeviceIDs),
+		GroupIDs:  uniqueDeviceIDs(req.GroupIDs),
+	}
+	if len(newView.GroupIDs) == 0 {
+		newView.GroupIDs = nil
+	}
+
+	savedViewsMu.Lock()
+	backupViews := cloneSavedViews(savedViews)
+	savedViews = append(savedViews, newView)
+	if err := saveSavedViewsSnapshot(savedViews); err != nil {
+		savedViews = backupViews
+		savedViewsMu.Unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save views"})
+		return
+	}
+	savedViewsMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "view": newView})
+}
+
+// viewsUpdateHandler handles PUT /api/views/:id
+func viewsUpdateHandler(c *gin.Context) {
+	viewID := c.Param("id")
+	var req struct {
+		Name      *string   `json:"name"`
+		DeviceIDs *[]string `json:"deviceIds"`
+		GroupIDs  *[]string `json:"groupIds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var name string
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "View name cannot be empty"})
+			return
+		}
+	}
+
+	savedViewsMu.Lock()
+	backupViews := cloneSavedViews(savedViews)
+
+	var updated SavedView
+	found := false
+	for i := range savedViews {
+		if savedViews[i].ID != viewID {
+			continue
+		}
+		if req.Name != nil {
+			savedViews[i].Name = name
+		}
+		if req.DeviceIDs != nil {
+			savedViews[i].DeviceIDs = uniqueDeviceIDs(*req.DeviceIDs)
+		}
+		if req.GroupIDs != nil {
+			savedViews[i].GroupIDs = uniqueDeviceIDs(*req.GroupIDs)
+			if len(savedViews[i].GroupIDs) == 0 {
+				savedViews[i].GroupIDs = nil
+			}
+		}
+		updated = savedViews[i]
+		found = true
+		break
+	}
+
+	if !found {
+		savedViewsMu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "View not found"})
+		return
+	}
+	if err := saveSavedViewsSnapshot(savedViews); err != nil {
+		savedViews = backupViews
+		savedViewsMu.Unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save views"})
+		return
+	}
+	savedViewsMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "view": updated})
+}
+
+// viewsDeleteHandler handles DELETE /api/views/:id
+func viewsDeleteHandler(c *gin.Context) {
+	viewID := c.Param("id")
+
+	savedViewsMu.Lock()
+	backupViews := cloneSavedViews(savedViews)
+
+	found := false
+	newViews := make([]SavedView, 0, len(savedViews))
+	for _, v := range savedViews {
+		if v.ID != viewID {
+			newViews = append(newViews, v)
+		} else {
+			found = true
+		}
+	}
+
+	if !found {
+		savedViewsMu.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "View not found"})
+		return
+	}
+
+	savedViews = newViews
+	if err := saveSavedViewsSnapshot(savedViews); err != nil {
+		savedViews = backupViews
+		savedViewsMu.Unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save views"})
+		return
+	}
+	savedViewsMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// viewsResolveHandler handles GET /api/views/:id/devices
+func viewsResolveHandler(c *gin.Context) {
+	devices, ok := resolveSavedViewDevices(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "View not found"})
+		return
+	}
+
+	online := make([]string, 0, len(devices))
+	mu.RLock()
+	for _, udid := range devices {
+		if _, exists := deviceLinks[udid]; exists {
+			online = append(online, udid)
+		}
+	}
+	mu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices, "online": online})
+}
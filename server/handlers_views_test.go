@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func setupSavedViewsFixture(t *testing.T) {
+	t.Helper()
+	setupPersistenceWritableDataDir(t)
+
+	savedViewsMu.Lock()
+	backupViews := cloneSavedViews(savedViews)
+	savedViews = []SavedView{
+		{ID: "v1", Name: "Checkout", DeviceIDs: []string{"d1", "d2"}, GroupIDs: []string{"g1"}},
+	}
+	savedViewsMu.Unlock()
+
+	deviceGroupsMu.Lock()
+	backupGroups := cloneGroupInfos(deviceGroups)
+	deviceGroups = []GroupInfo{
+		{ID: "g1", Name: "Group 1", DeviceIDs: []string{"d2", "d3"}},
+		{ID: "g2", Name: "Group 2", DeviceIDs: []string{"d4"}},
+	}
+	deviceGroupsMu.Unlock()
+
+	t.Cleanup(func() {
+		savedViewsMu.Lock()
+		savedViews = backupViews
+		savedViewsMu.Unlock()
+		deviceGroupsMu.Lock()
+		deviceGroups = backupGroups
+		deviceGroupsMu.Unlock()
+	})
+}
+
+func TestResolveSavedViewDevices_MergesDevicesAndGroups(t *testing.T) {
+	setupSavedViewsFixture(t)
+
+	got, ok := resolveSavedViewDevices("v1")
+	if !ok {
+		t.Fatalf("expected view to resolve")
+	}
+	expected := []string{"d1", "d2", "d3"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	if _, ok := resolveSavedViewDevices("missing"); ok {
+		t.Fatalf("expected unknown view to fail resolution")
+	}
+
+	expanded := expandDevicesWithView([]string{"d9", "d1"}, "v1")
+	if !reflect.DeepEqual(expanded, []string{"d9", "d1", "d2", "d3"}) {
+		t.Fatalf("unexpected expanded devices: %v", expanded)
+	}
+}
+
+func TestViewsHandlers_CRUDPersists(t *testing.T) {
+	setupSavedViewsFixture(t)
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/views", map[string]any{
+		"name":      " Nightly ",
+		"deviceIds": []string{"d5", "d5", " "},
+	}, viewsCreateHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var created struct {
+		View SavedView `json:"view"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if created.View.Name != "Nightly" || !reflect.DeepEqual(created.View.DeviceIDs, []string{"d5"}) {
+		t.Fatalf("unexpected created view: %+v", created.View)
+	}
+
+	data, err := os.ReadFile(getSavedViewsFilePath())
+	if err != nil {
+		t.Fatalf("expected views file to be written: %v", err)
+	}
+	var persisted []SavedView
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		t.Fatalf("failed to decode views file: %v", err)
+	}
+	if len(persisted) != 2 {
+		t.Fatalf("expected 2 persisted views, got %d", len(persisted))
+	}
+
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/views", map[string]any{"name": "  "}, viewsCreateHandler)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for empty name, got %d", w.Code)
+	}
+
+	w = performJSONRequestWithGroupID(t, http.MethodDelete, "/api/views/"+created.View.ID, created.View.ID, nil, viewsDeleteHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	w = performJSONRequestWithGroupID(t, http.MethodDelete, "/api/views/missing", "missing", nil, viewsDeleteHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	serverStartedAt = time.Now()
+	// serverReady is set once data directories and the updater service are initialized.
+	serverReady atomic.Bool
+)
+
+func markServerReady() {
+	serverReady.Store(true)
+}
+
+func buildInfo() gin.H {
+	return gin.H{
+		"version":   Version,
+		"buildTime": BuildTime,
+		"commit":    Commit,
+	}
+}
+
+// checkDataDirWritable creates and removes a temp file in the data directory.
+func checkDataDirWritable() error {
+	f, err := os.CreateTemp(getServerConfig().DataDir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	closeErr := f.Close()
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// healthHandler handles GET /api/health (liveness probe, unauthenticated)
+func healthHandler(c *gin.Context) {
+	body := buildInfo()
+	body["status"] = "ok"
+	body["uptimeSeconds"] = int64(time.Since(serverStartedAt).Seconds())
+	c.JSON(http.StatusOK, body)
+}
+
+// readyHandler handles GET /api/ready (readiness probe, unauthenticated)
+func readyHandler(c *gin.Context) {
+	body := buildInfo()
+	if !serverReady.Load() {
+		body["status"] = "starting"
+		c.JSON(http.StatusServiceUnavailable, body)
+		return
+	}
+	if err := checkDataDirWritable(); err != nil {
+		body["status"] = "unavailable"
+		body["error"] = "data directory not writable: " + err.Error()
+		c.JSON(http.StatusServiceUnavailable, body)
+		return
+	}
+	body["status"] = "ready"
+	c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func performProbeRequest(t *testing.T, path string) (int, map[string]interface{}) {
+	t.Helper()
+	r := gin.New()
+	r.Use(apiAuthMiddleware())
+	r.GET("/api/health", healthHandler)
+	r.GET("/api/ready", readyHandler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid json body %q: %v", w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+func TestHealthAndReadyProbes(t *testing.T) {
+	dataDir := setupPersistenceWritableDataDir(t)
+	prevReady := serverReady.Load()
+	serverReady.Store(false)
+	t.Cleanup(func() { serverReady.Store(prevReady) })
+
+	code, body := performProbeRequest(t, "/api/health")
+	if code != http.StatusOK || body["status"] != "ok" || body["version"] != Version || body["commit"] != Commit {
+		t.Fatalf("unexpected health response %d %v", code, body)
+	}
+
+	if code, body = performProbeRequest(t, "/api/ready"); code != http.StatusServiceUnavailable || body["status"] != "starting" {
+		t.Fatalf("expected 503 before init, got %d %v", code, body)
+	}
+
+	markServerReady()
+	if code, body = performProbeRequest(t, "/api/ready"); code != http.StatusOK || body["status"] != "ready" {
+		t.Fatalf("expected ready, got %d %v", code, body)
+	}
+	entries, _ := os.ReadDir(dataDir)
+	if len(entries) != 0 {
+		t.Fatalf("ready check should not leave files behind, got %d", len(entries))
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DataDir = filepath.Join(dataDir, "missing") })
+	if code, body = performProbeRequest(t, "/api/ready"); code != http.StatusServiceUnavailable || body["status"] != "unavailable" {
+		t.Fatalf("expected 503 for unwritable data dir, got %d %v", code, body)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// nativeTLSEnabled reports whether the main listener serves HTTPS/WSS itself, with either
+// ACME or static certificates.
+func nativeTLSEnabled() bool {
+	return acmeEnabled() || (getServerConfig().TLSEnabled && getServerConfig().TLSCertFile != "" && getServerConfig().TLSKeyFile != "")
+}
+
+// loadServerTLSConfig loads the configured certificate and key so a bad pair is reported
+// at startup instead of on the first handshake.
+func loadServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(getServerConfig().TLSCertFile, getServerConfig().TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate %q / key %q: %w", getServerConfig().TLSCertFile, getServerConfig().TLSKeyFile, err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// httpsRedirectHandler sends every request to the same host and path on the HTTPS port.
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// startHTTPSRedirectServer listens for plain HTTP on port and redirects it to the HTTPS
+// listener. It returns nil when port is 0.
+func startHTTPSRedirectServer(bindAddress string, port int) *http.Server {
+	if port == 0 {
+		return nil
+	}
+	addr := net.JoinHostPort(bindAddress, strconv.Itoa(port))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           httpsRedirectHandler(getServerConfig().Port),
+		ReadHeaderTimeout: httpServerReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTPS redirect server failed", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("Redirecting HTTP to HTTPS", "addr", addr)
+	return server
+}
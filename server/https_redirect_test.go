@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	cases := []struct {
+		host      string
+		httpsPort int
+		want      string
+	}{
+		{"example.com:8080", 46980, "https://example.com:46980/api/config?format=json"},
+		{"example.com", 443, "https://example.com/api/config?format=json"},
+		{"[::1]:8080", 443, "https://[::1]/api/config?format=json"},
+		{"192.168.1.5:80", 8443, "https://192.168.1.5:8443/api/config?format=json"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/config?format=json", nil)
+		req.Host = tc.host
+		w := httptest.NewRecorder()
+		httpsRedirectHandler(tc.httpsPort).ServeHTTP(w, req)
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tc.want {
+			t.Fatalf("host %q: expected 308 to %q, got %d %q", tc.host, tc.want, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestLoadServerTLSConfigRejectsInvalidFiles(t *testing.T) {
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+
+	dir := t.TempDir()
+	updateServerConfig(func(cfg *ServerConfig) { cfg.TLSCertFile = filepath.Join(dir, "server.crt") })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.TLSKeyFile = filepath.Join(dir, "server.key") })
+	if _, err := loadServerTLSConfig(); err == nil || !strings.Contains(err.Error(), "server.crt") {
+		t.Fatalf("expected missing certificate to be reported, got %v", err)
+	}
+
+	if err := os.WriteFile(getServerConfig().TLSCertFile, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatalf("write cert failed: %v", err)
+	}
+	if err := os.WriteFile(getServerConfig().TLSKeyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("write key failed: %v", err)
+	}
+	if _, err := loadServerTLSConfig(); err == nil {
+		t.Fatalf("expected invalid certificate to be rejected")
+	}
+}
+
+func TestConfigHandlerReportsWebSocketProtocol(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+
+	for _, tlsEnabled := range []bool{false, true} {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.TLSEnabled = tlsEnabled })
+		updateServerConfig(func(cfg *ServerConfig) { cfg.TLSCertFile = "server.crt" })
+		updateServerConfig(func(cfg *ServerConfig) { cfg.TLSKeyFile = "server.key" })
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/config?format=json", nil)
+		configHandler(c)
+
+		var resp struct {
+			Protocol  string `json:"protocol"`
+			WebSocket struct {
+				Protocol string `json:"protocol"`
+			} `json:"websocket"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		want := map[bool][2]string{false: {"http", "ws"}, true: {"https", "wss"}}[tlsEnabled]
+		if resp.Protocol != want[0] || resp.WebSocket.Protocol != want[1] {
+			t.Fatalf("tlsEnabled=%v: expected %v, got %q/%q", tlsEnabled, want, resp.Protocol, resp.WebSocket.Protocol)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logArchiveSink is the always-on log subscriber. It is registered in logSubscriptions
+// like a controller, so devices keep streaming logs while no human is watching, but its
+// pushes are appended to the archive instead of being written to a connection.
+var logArchiveSink = &SafeConn{}
+
+var logArchiveMu sync.Mutex
+
+// logArchiveEnabled reports whether the archival sink is configured.
+func logArchiveEnabled() bool {
+	return strings.TrimSpace(getServerConfig().LogArchiveDir) != ""
+}
+
+// deviceRequestsLogAutoStart reports whether an app/state body asks for logs to be
+// streamed from registration, via "autoSubscribe": ["log"].
+func deviceRequestsLogAutoStart(bodyMap map[string]interface{}) bool {
+	streams, ok := bodyMap["autoSubscribe"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, stream := range streams {
+		if name, ok := stream.(string); ok {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "log", "logs":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatLogArchiveLine renders one archived system/log/push body.
+func formatLogArchiveLine(now time.Time, body interface{}) string {
+	text, ok := body.(string)
+	if !ok {
+		if data, err := json.Marshal(body); err == nil {
+			text = string(data)
+		}
+	}
+	return fmt.Sprintf("%s %s\n", now.Format(time.RFC3339), strings.TrimRight(text, "\r\n"))
+}
+
+// appendDeviceLogArchive appends a device log push to <logArchiveDir>/<udid>.log.
+func appendDeviceLogArchive(udid string, body interface{}) error {
+	if err := validateFileName(udid); err != nil {
+		return fmt.Errorf("invalid udid for log archive: %q", udid)
+	}
+	dir := getServerConfig().LogArchiveDir
+	line, err := sealDeviceDataLine(formatLogArchiveLine(time.Now(), body))
+	if err != nil {
+		return err
+	}
+
+	logArchiveMu.Lock()
+	defer logArchiveMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, udid+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line)
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogArchive_DeviceAutoSubscribeStreamsToArchive(t *testing.T) {
+	deviceConn, client := newTestWebSocketPair(t)
+	archiveDir := filepath.Join(t.TempDir(), "logs")
+
+	backupDir := getServerConfig().LogArchiveDir
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LogArchiveDir = archiveDir })
+	mu.Lock()
+	linksBackup, linksMapBackup := deviceLinks, deviceLinksMap
+	tableBackup, lifeBackup, subsBackup := deviceTable, deviceLife, logSubscriptions
+	deviceLinks = make(map[string]*SafeConn)
+	deviceLinksMap = make(map[*SafeConn]string)
+	deviceTable = make(map[string]interface{})
+	deviceLife = make(map[string]int)
+	logSubscriptions = make(map[string]map[*SafeConn]bool)
+	mu.Unlock()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.LogArchiveDir = backupDir })
+		mu.Lock()
+		deviceLinks, deviceLinksMap = linksBackup, linksMapBackup
+		deviceTable, deviceLife, logSubscriptions = tableBackup, lifeBackup, subsBackup
+		mu.Unlock()
+	})
+
+	err := handleMessage(deviceConn, Message{
+		Type: "app/state",
+		Body: map[string]interface{}{
+			"system":        map[string]interface{}{"udid": "d1"},
+			"autoSubscribe": []interface{}{"log"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("app/state failed: %v", err)
+	}
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "system/log/subscribe" {
+		t.Fatalf("expected system/log/subscribe, got %q", msg.Type)
+	}
+
+	if err := handleMessage(deviceConn, Message{Type: "system/log/push", Body: "hello archive\n"}); err != nil {
+		t.Fatalf("system/log/push failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(archiveDir, "d1.log"))
+	if err != nil {
+		t.Fatalf("expected archive file: %v", err)
+	}
+	if !strings.HasSuffix(string(data), " hello archive\n") {
+		t.Fatalf("unexpected archive content: %q", data)
+	}
+}
+
+func TestDeviceRequestsLogAutoStart(t *testing.T) {
+	if !deviceRequestsLogAutoStart(map[string]interface{}{"autoSubscribe": []interface{}{"screen", " Logs "}}) {
+		t.Fatalf("expected logs stream to be recognized")
+	}
+	if deviceRequestsLogAutoStart(map[string]interface{}{"autoSubscribe": "log"}) {
+		t.Fatalf("expected non-array autoSubscribe to be ignored")
+	}
+	if deviceRequestsLogAutoStart(map[string]interface{}{}) {
+		t.Fatalf("expected missing autoSubscribe to be ignored")
+	}
+}
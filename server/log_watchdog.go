@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// logWatchdogInterval is how often subscribed devices are checked for a stale log stream.
+	logWatchdogInterval = 5 * time.Second
+	// logKickMaxBackoff caps the delay between automatic re-subscribes to a silent device.
+	logKickMaxBackoff = 30 * time.Minute
+)
+
+// logStreamState tracks the log stream of one subscribed device.
+type logStreamState struct {
+	lastActivity time.Time     // last system/log/push, or when system/log/subscribe was sent
+	backoff      time.Duration // delay before the next automatic kick (0 = not kicked yet)
+	nextKick     time.Time
+}
+
+// logStreams is guarded by its own lock so system/log/push only needs mu.RLock.
+var logStreams = struct {
+	sync.Mutex
+	entries map[string]*logStreamState
+}{
+	entries: make(map[string]*logStreamState),
+}
+
+var (
+	logWatchdogStop  chan struct{}
+	logWatchdogDone  chan struct{}
+	logWatchdogStart sync.Once
+)
+
+// markLogStreamActive records log activity for udid and resets its kick backoff. It is
+// called for every system/log/push and whenever system/log/subscribe is sent.
+func markLogStreamActive(udid string, now time.Time) {
+	logStreams.Lock()
+	if state := logStreams.entries[udid]; state != nil {
+		*state = logStreamState{lastActivity: now}
+	} else {
+		logStreams.entries[udid] = &logStreamState{lastActivity: now}
+	}
+	logStreams.Unlock()
+}
+
+func forgetLogStream(udid string) {
+	logStreams.Lock()
+	delete(logStreams.entries, udid)
+	logStreams.Unlock()
+}
+
+// staleLogStreams returns the subscribed devices whose log stream has been silent for at
+// least window and whose backoff has elapsed, advancing their backoff.
+func staleLogStreams(subscribed map[string]bool, now time.Time, window time.Duration) []string {
+	logStreams.Lock()
+	defer logStreams.Unlock()
+
+	for udid := range logStreams.entries {
+		if !subscribed[udid] {
+			delete(logStreams.entries, udid)
+		}
+	}
+
+	var stale []string
+	for udid := range subscribed {
+		state := logStreams.entries[udid]
+		if state == nil {
+			logStreams.entries[udid] = &logStreamState{lastActivity: now}
+			continue
+		}
+		if now.Sub(state.lastActivity) < window || now.Before(state.nextKick) {
+			continue
+		}
+		if state.backoff == 0 {
+			state.backoff = window
+		} else if state.backoff *= 2; state.backoff > logKickMaxBackoff {
+			state.backoff = logKickMaxBackoff
+		}
+		state.nextKick = now.Add(state.backoff)
+		stale = append(stale, udid)
+	}
+	return stale
+}
+
+// kickStaleLogStreams re-sends system/log/subscribe to connected devices with log
+// subscribers that have not pushed logs within logStaleSeconds.
+func kickStaleLogStreams(now time.Time) []string {
+	window := time.Duration(getServerConfig().LogStaleSeconds) * time.Second
+	if window <= 0 {
+		return nil
+	}
+
+	subscribed := make(map[string]bool)
+	conns := make(map[string]*SafeConn)
+	mu.RLock()
+	for udid, subs := range logSubscriptions {
+		if len(subs) == 0 {
+			continue
+		}
+		if deviceConn, exists := deviceLinks[udid]; exists {
+			subscribed[udid] = true
+			conns[udid] = deviceConn
+		}
+	}
+	mu.RUnlock()
+
+	stale := staleLogStreams(subscribed, now, window)
+	if len(stale) == 0 {
+		return nil
+	}
+	subscribePayload, err := json.Marshal(Message{Type: "system/log/subscribe"})
+	if err != nil {
+		return nil
+	}
+	for _, udid := range stale {
+		writeTextMessageAsync(conns[udid], subscribePayload)
+	}
+	slog.Info("Re-sent log subscription to devices with a stale log stream", "devices", strings.Join(stale, ","))
+	return stale
+}
+
+// startLogWatchdog checks for stale log streams every logWatchdogInterval. logStaleSeconds
+// is read on each tick, so the watchdog can be enabled or tuned without a restart.
+func startLogWatchdog() {
+	logWatchdogStart.Do(func() {
+		logWatchdogStop = make(chan struct{})
+		logWatchdogDone = make(chan struct{})
+		ticker := time.NewTicker(logWatchdogInterval)
+		go func() {
+			defer close(logWatchdogDone)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					kickStaleLogStreams(now)
+				case <-logWatchdogStop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+func stopLogWatchdog() {
+	if logWatchdogStop == nil {
+		return
+	}
+	select {
+	case <-logWatchdogStop:
+	default:
+		close(logWatchdogStop)
+	}
+	<-logWatchdogDone
+}
+
+// logKickHandler handles POST /api/log/kick
+// Re-sends system/log/subscribe to one device whose log stream has subscribers.
+func logKickHandler(c *gin.Context) {
+	var req struct {
+		UDID string `json:"udid"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.UDID) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "udid is required"})
+		return
+	}
+
+	resubscribed, err := resendLogSubscriptions([]string{strings.TrimSpace(req.UDID)})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(resubscribed) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device is not connected or has no log subscribers"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "udid": resubscribed[0]})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func setupLogWatchdogFixture(t *testing.T) *SafeConn {
+	t.Helper()
+	deviceConn := setupCommandAckFixture(t)
+	controllerConn, _ := newTestWebSocketPair(t)
+
+	mu.Lock()
+	subsBackup := logSubscriptions
+	logSubscriptions = map[string]map[*SafeConn]bool{"d1": {controllerConn: true}}
+	mu.Unlock()
+	logStreams.Lock()
+	streamsBackup := logStreams.entries
+	logStreams.entries = make(map[string]*logStreamState)
+	logStreams.Unlock()
+	prevStale := getServerConfig().LogStaleSeconds
+	updateServerConfig(func(cfg *ServerConfig) { cfg.LogStaleSeconds = 60 })
+	t.Cleanup(func() {
+		mu.Lock()
+		logSubscriptions = subsBackup
+		mu.Unlock()
+		logStreams.Lock()
+		logStreams.entries = streamsBackup
+		logStreams.Unlock()
+		updateServerConfig(func(cfg *ServerConfig) { cfg.LogStaleSeconds = prevStale })
+	})
+	return deviceConn
+}
+
+func TestKickStaleLogStreams_BacksOffUntilLogsResume(t *testing.T) {
+	setupLogWatchdogFixture(t)
+	start := time.Now()
+
+	if got := kickStaleLogStreams(start); got != nil {
+		t.Fatalf("first sighting should only start the window, got %v", got)
+	}
+	if got := kickStaleLogStreams(start.Add(59 * time.Second)); got != nil {
+		t.Fatalf("device within the window should not be kicked, got %v", got)
+	}
+	if got := kickStaleLogStreams(start.Add(60 * time.Second)); !reflect.DeepEqual(got, []string{"d1"}) {
+		t.Fatalf("expected d1 to be kicked, got %v", got)
+	}
+	// Backoff: next kick after another window, then after twice the window
+	if got := kickStaleLogStreams(start.Add(90 * time.Second)); got != nil {
+		t.Fatalf("kick should back off, got %v", got)
+	}
+	if got := kickStaleLogStreams(start.Add(120 * time.Second)); len(got) != 1 {
+		t.Fatalf("expected second kick after one window, got %v", got)
+	}
+	if got := kickStaleLogStreams(start.Add(200 * time.Second)); got != nil {
+		t.Fatalf("second backoff should be twice the window, got %v", got)
+	}
+	if got := kickStaleLogStreams(start.Add(240 * time.Second)); len(got) != 1 {
+		t.Fatalf("expected third kick after two windows, got %v", got)
+	}
+
+	markLogStreamActive("d1", start.Add(250*time.Second))
+	if got := kickStaleLogStreams(start.Add(300 * time.Second)); got != nil {
+		t.Fatalf("log push should reset the window, got %v", got)
+	}
+	if got := kickStaleLogStreams(start.Add(310 * time.Second)); len(got) != 1 {
+		t.Fatalf("backoff should reset after logs resume, got %v", got)
+	}
+}
+
+func TestKickStaleLogStreams_SendsSubscribeToDevice(t *testing.T) {
+	setupLogWatchdogFixture(t)
+	deviceConn, deviceClient := newTestWebSocketPair(t)
+	mu.Lock()
+	deviceLinks["d1"] = deviceConn
+	mu.Unlock()
+
+	start := time.Now()
+	kickStaleLogStreams(start)
+	kickStaleLogStreams(start.Add(time.Minute))
+	if msg := readTestMessage(t, deviceClient); msg.Type != "system/log/subscribe" {
+		t.Fatalf("unexpected message to device: %#v", msg)
+	}
+}
+
+func TestLogKickHandler_RequiresSubscribedDevice(t *testing.T) {
+	setupLogWatchdogFixture(t)
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/log/kick", map[string]string{"udid": "other"}, logKickHandler)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unsubscribed device, got %d", w.Code)
+	}
+	w = performJSONHandlerRequest(t, http.MethodPost, "/api/log/kick", map[string]string{"udid": "d1"}, logKickHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
-	"log"
+	"context"
+	"io"
+	"log/slog"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
@@ -30,24 +33,176 @@ func envDebugEnabled(key string) bool {
 	return true
 }
 
-func debugLogf(format string, args ...interface{}) {
-	if debugLogsEnabled {
-		log.Printf(format, args...)
+// logLevel is the handler threshold; configuredLogLevel is the logLevel config option.
+// The XXT_*_DEBUG variables lower the threshold to debug for their own categories only.
+var (
+	logLevel           = new(slog.LevelVar)
+	configuredLogLevel = slog.LevelInfo
+)
+
+// parseLogLevel maps debug/info/warn/error to a slog level (empty = info).
+func parseLogLevel(value string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
 	}
+	return slog.LevelInfo, false
 }
 
-func wsDebugf(format string, args ...interface{}) {
-	if debugLogsEnabled || wsDebugLogsEnabled {
-		log.Printf(format, args...)
+func isValidLogLevel(value string) bool {
+	_, ok := parseLogLevel(value)
+	return ok
+}
+
+func validLogFormat(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "text", "json":
+		return true
 	}
+	return false
+}
+
+// initLogger installs the process-wide leveled logger. format is "text" (console
+// friendly, default) or "json". The standard log package is routed through it at info level.
+func initLogger(format, level string) {
+	slog.SetDefault(slog.New(newLogHandler(os.Stderr, format, level)))
 }
 
-func httpDebugf(format string, args ...interface{}) {
-	if debugLogsEnabled || wsDebugLogsEnabled || httpDebugLogsEnabled {
-		log.Printf(format, args...)
+func newLogHandler(w io.Writer, format, level string) slog.Handler {
+	configuredLogLevel, _ = parseLogLevel(level)
+	threshold := configuredLogLevel
+	if debugLogsEnabled || wsDebugLogsEnabled || httpDebugLogsEnabled || authDebugLogsEnabled {
+		threshold = slog.LevelDebug
+	}
+	logLevel.Set(threshold)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if strings.EqualFold(strings.TrimSpace(format), "json") {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return &consoleHandler{mu: &sync.Mutex{}, w: w, level: logLevel}
+}
+
+func debugEnabled() bool {
+	return debugLogsEnabled || configuredLogLevel <= slog.LevelDebug
+}
+
+// logDebug logs a structured debug record when debug logging is enabled.
+func logDebug(msg string, args ...interface{}) {
+	if debugEnabled() {
+		slog.Debug(msg, args...)
+	}
+}
+
+func wsDebugEnabled() bool {
+	return debugEnabled() || wsDebugLogsEnabled
+}
+
+// wsDebug logs WebSocket connection lifecycle details (XXT_WS_DEBUG).
+func wsDebug(msg string, args ...interface{}) {
+	if wsDebugEnabled() {
+		slog.Debug(msg, args...)
+	}
+}
+
+// httpDebug logs control/http relay details (XXT_HTTP_DEBUG).
+func httpDebug(msg string, args ...interface{}) {
+	if wsDebugEnabled() || httpDebugLogsEnabled {
+		slog.Debug(msg, args...)
 	}
 }
 
 func authDebugEnabled() bool {
-	return debugLogsEnabled || authDebugLogsEnabled
+	return debugEnabled() || authDebugLogsEnabled
+}
+
+// consoleHandler writes "2006/01/02 15:04:05 [LEVEL] message key=value" lines, matching
+// the standard logger's look; the level is omitted for info records.
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	attrs  []byte
+	prefix string
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := make([]byte, 0, 256)
+	if !r.Time.IsZero() {
+		buf = r.Time.AppendFormat(buf, "2006/01/02 15:04:05 ")
+	}
+	if r.Level != slog.LevelInfo {
+		buf = append(buf, '[')
+		buf = append(buf, r.Level.String()...)
+		buf = append(buf, "] "...)
+	}
+	buf = append(buf, r.Message...)
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = appendConsoleAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		next.attrs = appendConsoleAttr(next.attrs, h.prefix, a)
+	}
+	return &next
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.prefix = joinLogKey(h.prefix, name)
+	return &next
+}
+
+func joinLogKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func appendConsoleAttr(buf []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+	key := joinLogKey(prefix, a.Key)
+	if a.Value.Kind() == slog.KindGroup {
+		for _, member := range a.Value.Group() {
+			buf = appendConsoleAttr(buf, key, member)
+		}
+		return buf
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+		value = strconv.Quote(value)
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, key...)
+	buf = append(buf, '=')
+	return append(buf, value...)
 }
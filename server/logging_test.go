@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warn":    slog.LevelWarn,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+	}
+	for input, want := range cases {
+		if got, ok := parseLogLevel(input); !ok || got != want {
+			t.Fatalf("parseLogLevel(%q) = %v, %v; want %v", input, got, ok, want)
+		}
+	}
+	if _, ok := parseLogLevel("verbose"); ok {
+		t.Fatalf("expected unknown level to be rejected")
+	}
+}
+
+func TestLogHandlerJSONFormat(t *testing.T) {
+	prevLevel := configuredLogLevel
+	t.Cleanup(func() {
+		configuredLogLevel = prevLevel
+		logLevel.Set(prevLevel)
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(&buf, "json", "warn"))
+	logger.Info("dropped")
+	logger.Warn("Device clock skew exceeds threshold", "udid", "d1", "skew_seconds", 42)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warn record, got %q", buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("expected json record: %v", err)
+	}
+	if record["level"] != "WARN" || record["udid"] != "d1" || record["skew_seconds"] != float64(42) {
+		t.Fatalf("unexpected record: %v", record)
+	}
+}
+
+func TestLogHandlerConsoleFormat(t *testing.T) {
+	prevLevel := configuredLogLevel
+	t.Cleanup(func() {
+		configuredLogLevel = prevLevel
+		logLevel.Set(prevLevel)
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(&buf, "text", "info")).With("remote_addr", "1.2.3.4:5")
+	logger.Info("New connection", "msg_type", "app/state")
+	logger.Error("Handle message failed", "error", "bad body")
+
+	out := buf.String()
+	if !strings.Contains(out, "New connection remote_addr=1.2.3.4:5 msg_type=app/state\n") {
+		t.Fatalf("unexpected info line: %q", out)
+	}
+	if !strings.Contains(out, `[ERROR] Handle message failed remote_addr=1.2.3.4:5 error="bad body"`) {
+		t.Fatalf("unexpected error line: %q", out)
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,6 +33,7 @@ func showUsage() {
 	fmt.Println("  " + os.Args[0] + " -set-password 12345678       # Set control password")
 	fmt.Println("  " + os.Args[0] + " -set-turn-ip 1.2.3.4         # Set TURN server public IP")
 	fmt.Println("  " + os.Args[0] + " -set-turn-port 3478          # Set TURN server UDP port")
+	fmt.Println("  " + os.Args[0] + " -decrypt-device-data x.log   # Print an encrypted device data file")
 	fmt.Println("  " + os.Args[0] + " -v                           # Show version")
 	fmt.Println("  " + os.Args[0] + " -h                           # Show help")
 }
@@ -37,6 +45,7 @@ func main() {
 	setTurnIP := flag.String("set-turn-ip", "", "Set the TURN server public IP")
 	setTurnPort := flag.Int("set-turn-port", 0, "Set the TURN server UDP port")
 	updateWorker := flag.String("update-worker", "", "Run internal update worker with job file")
+	decryptDeviceData := flag.String("decrypt-device-data", "", "Print a device data file decrypted with XXTCC_DEVICE_DATA_KEY")
 	help := flag.Bool("h", false, "Show help")
 	version := flag.Bool("v", false, "Show version")
 
@@ -60,8 +69,21 @@ func main() {
 		return
 	}
 
+	if *decryptDeviceData != "" {
+		if err := initDeviceDataCipher(); err != nil {
+			log.Fatalf("Failed to initialize device data encryption: %v", err)
+		}
+		if err := decryptDeviceDataTo(*decryptDeviceData, os.Stdout); err != nil {
+			log.Fatalf("Failed to decrypt device data: %v", err)
+		}
+		return
+	}
+
 	showHeaderInfo()
 
+	// Env-only logger until the config file is loaded
+	initLogger(os.Getenv("XXTCC_LOG_FORMAT"), os.Getenv("XXTCC_LOG_LEVEL"))
+
 	// Load configuration
 	if err := loadConfig(*configPath); err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
@@ -69,12 +91,13 @@ func main() {
 
 	// Set password if requested
 	if *setPassword != "" {
-		serverConfig.Passhash = toPasshash(*setPassword)
+		cfg := *getServerConfig()
+		cfg.Passhash = toPasshash(*setPassword)
 		targetPath := *configPath
 		if targetPath == "" {
 			targetPath = DefaultConfigFile
 		}
-		if err := saveConfig(targetPath, serverConfig); err != nil {
+		if err := saveConfig(targetPath, cfg); err != nil {
 			log.Fatalf("Failed to save configuration: %v", err)
 		}
 		fmt.Println("Password set successfully")
@@ -83,18 +106,19 @@ func main() {
 
 	// Set TURN public IP if requested
 	if *setTurnIP != "" {
-		serverConfig.TURNEnabled = true
-		serverConfig.TURNPublicIP = *setTurnIP
+		cfg := *getServerConfig()
+		cfg.TURNEnabled = true
+		cfg.TURNPublicIP = *setTurnIP
 		targetPath := *configPath
 		if targetPath == "" {
 			targetPath = DefaultConfigFile
 		}
-		if err := saveConfig(targetPath, serverConfig); err != nil {
+		if err := saveConfig(targetPath, cfg); err != nil {
 			log.Fatalf("Failed to save configuration: %v", err)
 		}
 		fmt.Printf("TURN public IP set to: %s\n", *setTurnIP)
 		fmt.Printf("Please ensure UDP/TCP port %d and UDP ports %d-%d are open on your firewall\n",
-			serverConfig.TURNPort, serverConfig.TURNRelayPortMin, serverConfig.TURNRelayPortMax)
+			cfg.TURNPort, cfg.TURNRelayPortMin, cfg.TURNRelayPortMax)
 		return
 	}
 
@@ -103,19 +127,36 @@ func main() {
 		if *setTurnPort < 1 || *setTurnPort > 65535 {
 			log.Fatalf("Invalid TURN port: %d", *setTurnPort)
 		}
-		serverConfig.TURNEnabled = true
-		serverConfig.TURNPort = *setTurnPort
+		cfg := *getServerConfig()
+		cfg.TURNEnabled = true
+		cfg.TURNPort = *setTurnPort
 		targetPath := *configPath
 		if targetPath == "" {
 			targetPath = DefaultConfigFile
 		}
-		if err := saveConfig(targetPath, serverConfig); err != nil {
+		if err := saveConfig(targetPath, cfg); err != nil {
 			log.Fatalf("Failed to save configuration: %v", err)
 		}
 		fmt.Printf("TURN port set to: %d\n", *setTurnPort)
 		return
 	}
 
+	// Startup-only settings are read from this snapshot; later reloads do not affect them.
+	startupConfig := getServerConfig()
+	if err := validateStartupConfig(*startupConfig); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	initLogger(startupConfig.LogFormat, startupConfig.LogLevel)
+	if startupConfig.SigningSecret != "" {
+		// The bundled web UI always derives passhash with the default key.
+		slog.Warn("signingSecret is set: password login in the bundled web UI is not supported, use a client that signs with the passhash directly")
+	}
+
+	if err := initDeviceDataCipher(); err != nil {
+		log.Fatalf("Failed to initialize device data encryption: %v", err)
+	}
+
 	// Start ping timer
 	startPingTimer()
 	defer stopPingTimer()
@@ -125,75 +166,140 @@ func main() {
 	defer stopStateRefreshTimer()
 
 	// Check if frontend directory exists
-	if _, err := os.Stat(serverConfig.FrontendDir); os.IsNotExist(err) {
-		fmt.Printf("Warning: Frontend directory '%s' does not exist, static files will not be served\n", serverConfig.FrontendDir)
+	if useEmbeddedFrontend(startupConfig.FrontendDir) {
+		slog.Info("Serving embedded frontend", "dir", startupConfig.FrontendDir, "preferred", startupConfig.PreferEmbeddedFrontend)
+	} else if _, err := os.Stat(startupConfig.FrontendDir); os.IsNotExist(err) {
+		slog.Warn("Frontend directory does not exist, static files will not be served", "dir", startupConfig.FrontendDir)
+	}
+
+	// Restore persisted transfer tokens before the temp directory is cleaned
+	if restored, err := loadTransferTokens(); err != nil {
+		slog.Warn("Failed to load transfer tokens", "error", err)
+	} else if restored > 0 {
+		slog.Info("Restored transfer tokens", "tokens", restored)
 	}
 
 	// Initialize data directories
 	if err := initDataDirectories(); err != nil {
 		log.Fatalf("Failed to initialize data directories: %v", err)
 	}
+	startTransferTokenStoreTimer()
+	defer stopTransferTokenStoreTimer()
 
 	if err := initUpdaterService(); err != nil {
 		log.Fatalf("Failed to initialize updater service: %v", err)
 	}
+	markServerReady()
 
 	// Load saved data
 	if err := loadGroups(); err != nil {
-		log.Printf("Warning: Failed to load groups: %v", err)
+		slog.Warn("Failed to load groups", "error", err)
+	}
+
+	if err := loadSavedViews(); err != nil {
+		slog.Warn("Failed to load saved views", "error", err)
 	}
 
+	if err := loadDeviceLabels(); err != nil {
+		slog.Warn("Failed to load device labels", "error", err)
+	}
+
+	if err := loadMacros(); err != nil {
+		slog.Warn("Failed to load macros", "error", err)
+	}
+
+	if err := loadScheduledCommands(); err != nil {
+		slog.Warn("Failed to load scheduled commands", "error", err)
+	}
+	armScheduledCommands()
+
 	if err := loadGroupScriptConfigs(); err != nil {
-		log.Printf("Warning: Failed to load group script configs: %v", err)
+		slog.Warn("Failed to load group script configs", "error", err)
 	}
 
 	if err := loadAppSettings(); err != nil {
-		log.Printf("Warning: Failed to load app settings: %v", err)
+		slog.Warn("Failed to load app settings", "error", err)
+	}
+
+	if restored, err := loadDeviceStateSnapshot(); err != nil {
+		slog.Warn("Failed to load device state snapshot", "error", err)
+	} else if restored > 0 {
+		slog.Info("Restored devices from device state snapshot (stale until reconnect)", "devices", restored)
 	}
+	startDeviceStateSnapshotTimer()
+	defer stopDeviceStateSnapshotTimer()
+	startDeviceExportTimer()
+	defer stopDeviceExportTimer()
+	startLogWatchdog()
+	defer stopLogWatchdog()
+
+	startFederation()
+	defer stopFederation()
 
 	// Initialize TURN server if enabled and either public IP or address is configured
-	turnAddrConfigured := serverConfig.TURNPublicIP != "" || serverConfig.TURNPublicAddr != ""
-	if serverConfig.TURNEnabled && turnAddrConfigured {
+	turnAddrConfigured := startupConfig.TURNPublicIP != "" || startupConfig.TURNPublicAddr != ""
+	if startupConfig.TURNEnabled && turnAddrConfigured {
 		turnConfig := TURNConfig{
-			Enabled:       serverConfig.TURNEnabled,
-			Port:          serverConfig.TURNPort,
-			PublicIP:      serverConfig.TURNPublicIP,
-			PublicAddr:    serverConfig.TURNPublicAddr,
-			Realm:         serverConfig.TURNRealm,
-			SecretKey:     serverConfig.TURNSecretKey,
-			CredentialTTL: serverConfig.TURNCredentialTTL,
-			RelayPortMin:  serverConfig.TURNRelayPortMin,
-			RelayPortMax:  serverConfig.TURNRelayPortMax,
+			Enabled:       startupConfig.TURNEnabled,
+			Port:          startupConfig.TURNPort,
+			PublicIP:      startupConfig.TURNPublicIP,
+			PublicAddr:    startupConfig.TURNPublicAddr,
+			Realm:         startupConfig.TURNRealm,
+			SecretKey:     startupConfig.TURNSecretKey,
+			CredentialTTL: startupConfig.TURNCredentialTTL,
+			RelayPortMin:  startupConfig.TURNRelayPortMin,
+			RelayPortMax:  startupConfig.TURNRelayPortMax,
 		}
 		if err := InitTURNServer(turnConfig); err != nil {
-			log.Printf("Warning: Failed to start TURN server: %v", err)
+			slog.Warn("Failed to start TURN server", "error", err)
 		} else {
 			defer StopTURNServer()
 		}
-	} else if serverConfig.TURNEnabled && !turnAddrConfigured {
-		fmt.Println("ℹ️  TURN server enabled but turnPublicIP/turnPublicAddr not configured, skipping...")
+	} else if startupConfig.TURNEnabled && !turnAddrConfigured {
+		slog.Info("TURN server enabled but turnPublicIP/turnPublicAddr not configured, skipping")
 	}
 
 	// Configure Gin
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
-	r.Use(gin.Logger())
+	r.Use(accessLogMiddleware())
 	r.Use(gin.Recovery())
 	r.Use(corsMiddleware())
+	r.Use(compressionMiddleware())
+	r.Use(disabledEndpointsMiddleware())
 	r.Use(apiAuthMiddleware())
 
+	// Prometheus metrics
+	if startupConfig.MetricsEnabled {
+		defer startMetricsServer(startupConfig.MetricsAddr).Close()
+	}
+
+	// Liveness/readiness probes
+	r.GET("/api/health", healthHandler)
+	r.GET("/api/ready", readyHandler)
+
 	// WebSocket route
 	r.GET("/api/ws", handleWebSocketConnection)
+	r.GET("/api/ws/device", handleDeviceWebSocketConnection)
+	r.GET("/api/ws/control", handleControlWebSocketConnection)
 
 	// General API routes
 	r.GET("/api/config", configHandler)
-	r.GET("/api/control/info", controlInfoHandler)
+	r.GET("/api/pulse", pulseHandler)
+	r.GET("/api/events/poll", eventsPollHandler)
 	r.GET("/api/download-bind-script", downloadBindScriptHandler)
 	r.POST("/api/devices/snapshot-save-batch", snapshotSaveBatchHandler)
+	r.POST("/api/log/resubscribe", logResubscribeHandler)
+	r.POST("/api/log/kick", logKickHandler)
+
+	// Control routes
+	r.GET("/api/control/info", controlInfoHandler)
+	r.POST("/api/control/command-collect", commandCollectHandler)
 
 	// Server file management routes
 	r.GET("/api/server-files/list", serverFilesListHandler)
 	r.POST("/api/server-files/upload", serverFilesUploadHandler)
+	r.POST("/api/server-files/upload-zip", serverFilesUploadZipHandler)
 	r.POST("/api/server-files/create", serverFilesCreateHandler)
 	r.POST("/api/server-files/rename", serverFilesRenameHandler)
 	r.GET("/api/server-files/read", serverFilesReadHandler)
@@ -201,20 +307,29 @@ func main() {
 	r.GET("/api/server-files/download/*path", serverFilesDownloadHandler)
 	r.DELETE("/api/server-files/delete", serverFilesDeleteHandler)
 	r.POST("/api/server-files/open-local", serverFilesOpenLocalHandler)
+	r.GET("/api/server-files/usage", serverFilesUsageHandler)
+	r.GET("/api/server-files/search", serverFilesSearchHandler)
 	r.POST("/api/server-files/batch-copy", serverFilesBatchCopyHandler)
 	r.POST("/api/server-files/batch-move", serverFilesBatchMoveHandler)
+	r.GET("/api/server-files/batch/:job", serverFilesBatchJobHandler)
+	r.GET("/api/confirm/impact", confirmImpactHandler)
 
 	// Script management routes
 	r.GET("/api/scripts/selectable", selectableScriptsHandler)
+	r.GET("/api/scripts/tree", scriptsTreeHandler)
 	r.POST("/api/scripts/send", scriptsSendHandler)
+	r.POST("/api/scripts/manifest", scriptsManifestHandler)
 	r.POST("/api/scripts/send-and-start", scriptsSendAndStartHandler)
 	r.POST("/api/scripts/send-and-start/cancel", scriptsSendAndStartCancelHandler)
 	r.GET("/api/scripts/start-state", scriptsStartStateHandler)
+	r.GET("/api/scripts/running", scriptsRunningHandler)
+	r.POST("/api/scripts/stop-all", scriptsStopAllHandler)
 	r.POST("/api/scripts/lancontrol-archive/inspect", lanControlArchiveInspectHandler)
 	r.POST("/api/scripts/lancontrol-archive/install", lanControlArchiveInstallHandler)
 	r.GET("/api/scripts/config-status", scriptConfigStatusHandler)
 	r.GET("/api/scripts/config", scriptConfigGetHandler)
 	r.POST("/api/scripts/config", scriptConfigSaveHandler)
+	r.POST("/api/scripts/preview-merge", scriptsPreviewMergeHandler)
 
 	// Device group management routes
 	r.GET("/api/groups", groupsListHandler)
@@ -229,16 +344,63 @@ func main() {
 	r.POST("/api/groups/:id/script-config", groupsSetScriptConfigHandler)
 	r.DELETE("/api/groups/:id/script-config", groupsDeleteScriptConfigHandler)
 
+	// Device inventory
+	r.GET("/api/devices", devicesListHandler)
+	r.GET("/api/devices/stability", deviceStabilityHandler)
+	r.GET("/api/devices/command-history", commandHistoryHandler)
+	r.GET("/api/devices/:udid/screenshot", deviceScreenshotHandler)
+	r.GET("/api/devices/:udid/stats", deviceStatsHandler)
+	r.GET("/api/devices/:udid/stream.mjpeg", deviceScreenMJPEGHandler)
+
+	// Device lease routes
+	r.GET("/api/devices/leases", deviceLeasesListHandler)
+	r.POST("/api/devices/lease", deviceLeaseAcquireHandler)
+	r.DELETE("/api/devices/lease", deviceLeaseReleaseHandler)
+
+	// Local-only admin routes
+	registerLocalAdminRoutes(r)
+
+	// Saved view routes
+	r.GET("/api/views", viewsListHandler)
+	r.POST("/api/views", viewsCreateHandler)
+	r.PUT("/api/views/:id", viewsUpdateHandler)
+	r.DELETE("/api/views/:id", viewsDeleteHandler)
+	r.GET("/api/views/:id/devices", viewsResolveHandler)
+
+	// Scheduled command routes
+	r.POST("/api/commands/schedule-once", scheduleOnceHandler)
+	r.GET("/api/commands/scheduled", scheduledCommandsListHandler)
+	r.DELETE("/api/commands/scheduled/:id", scheduledCommandCancelHandler)
+
+	// Command macro routes
+	r.GET("/api/macros", macrosListHandler)
+	r.POST("/api/macros", macrosCreateHandler)
+	r.PUT("/api/macros/:name", macrosUpdateHandler)
+	r.DELETE("/api/macros/:name", macrosDeleteHandler)
+	r.POST("/api/macros/run", macrosRunHandler)
+	r.GET("/api/macros/runs/:id", macroRunStatusHandler)
+
 	// App settings routes
 	r.GET("/api/app-settings", getAppSettingsHandler)
 	r.POST("/api/app-settings", setAppSettingsHandler)
 
+	// Admin routes
+	r.GET("/api/admin/export-bundle", adminExportBundleHandler)
+	r.POST("/api/admin/import-bundle", adminImportBundleHandler)
+	r.PATCH("/api/server-config", serverConfigPatchHandler)
+	r.GET("/api/audit", auditHandler)
+	r.GET("/api/admin/nonce-stats", adminNonceStatsHandler)
+	r.POST("/api/admin/nonce-clear", adminNonceClearHandler)
+	r.POST("/api/admin/set-password", adminSetPasswordHandler)
+	r.POST("/api/admin/reload-frontend", adminReloadFrontendHandler)
+
 	// Update routes
 	r.GET("/api/update/status", updateStatusHandler)
 	r.POST("/api/update/check", updateCheckHandler)
 	r.POST("/api/update/download", updateDownloadHandler)
 	r.POST("/api/update/download/cancel", updateDownloadCancelHandler)
 	r.POST("/api/update/apply", updateApplyHandler)
+	r.POST("/api/update/rollback", updateRollbackHandler)
 
 	// File transfer routes (token-based, no auth required)
 	r.GET("/api/transfer/download/:token", transferDownloadHandler)
@@ -248,25 +410,43 @@ func main() {
 	r.POST("/api/transfer/create-token", createTransferTokenHandler)
 	r.POST("/api/transfer/push-to-device", pushFileToDeviceHandler)
 	r.POST("/api/transfer/pull-from-device", pullFileFromDeviceHandler)
+	r.POST("/api/transfer/pull-batch", pullBatchFromDeviceHandler)
 
 	// Static file serving (NoRoute for SPA support)
 	r.NoRoute(staticFileHandler)
 
 	// Start server
-	addr := fmt.Sprintf("0.0.0.0:%d", serverConfig.Port)
+	addr := net.JoinHostPort(startupConfig.BindAddress, strconv.Itoa(startupConfig.Port))
 
 	// Check if TLS is enabled and properly configured
-	tlsEnabled := serverConfig.TLSEnabled && serverConfig.TLSCertFile != "" && serverConfig.TLSKeyFile != ""
+	tlsEnabled := nativeTLSEnabled()
+	var tlsConfig *tls.Config
+	if acmeEnabled() {
+		manager := newACMEManager()
+		tlsConfig = manager.TLSConfig()
+		defer startACMEChallengeServer(manager, startupConfig.BindAddress).Close()
+	} else if tlsEnabled {
+		var err error
+		if tlsConfig, err = loadServerTLSConfig(); err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+	}
 
 	if tlsEnabled {
-		fmt.Printf("Starting HTTPS server on: %s\n", addr)
-		printNetworkEndpoints(serverConfig.Port, true)
+		slog.Info("Starting HTTPS server", "addr", addr)
+		// With ACME, port 80 already redirects alongside the challenge handler.
+		if !acmeEnabled() || startupConfig.HTTPSRedirectPort != acmeChallengePort {
+			if redirectServer := startHTTPSRedirectServer(startupConfig.BindAddress, startupConfig.HTTPSRedirectPort); redirectServer != nil {
+				defer redirectServer.Close()
+			}
+		}
+		printNetworkEndpoints(startupConfig.BindAddress, startupConfig.Port, true)
 	} else {
-		fmt.Printf("Starting HTTP server on: %s\n", addr)
-		printNetworkEndpoints(serverConfig.Port, false)
+		slog.Info("Starting HTTP server", "addr", addr)
+		printNetworkEndpoints(startupConfig.BindAddress, startupConfig.Port, false)
 	}
 
-	fmt.Println("Press Ctrl+C to stop the server")
+	slog.Info("Press Ctrl+C to stop the server")
 
 	httpServer := &http.Server{
 		Addr:              addr,
@@ -275,19 +455,32 @@ func main() {
 		ReadTimeout:       httpServerReadTimeout,
 		WriteTimeout:      httpServerWriteTimeout,
 		IdleTimeout:       httpServerIdleTimeout,
+		TLSConfig:         tlsConfig,
 	}
 
-	var err error
-	if tlsEnabled {
-		err = httpServer.ListenAndServeTLS(serverConfig.TLSCertFile, serverConfig.TLSKeyFile)
-	} else {
-		err = httpServer.ListenAndServe()
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	watchConfigReloadSignal(ctx)
 
-	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+	serveErr := make(chan error, 1)
+	go func() {
 		if tlsEnabled {
-			log.Fatalf("HTTPS server failed to start: %v", err)
+			serveErr <- httpServer.ListenAndServeTLS("", "")
+		} else {
+			serveErr <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			if tlsEnabled {
+				log.Fatalf("HTTPS server failed to start: %v", err)
+			}
+			log.Fatalf("HTTP server failed to start: %v", err)
 		}
-		log.Fatalf("HTTP server failed to start: %v", err)
+	case <-ctx.Done():
+		stop()
+		gracefulShutdown(httpServer)
 	}
 }
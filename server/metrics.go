@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// maxMetricsMessageTypes bounds the msg_type label: message types come from clients,
+// so types beyond this many distinct values are counted as "other".
+const maxMetricsMessageTypes = 200
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricsMessagesHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xxtcc_messages_handled_total",
+		Help: "WebSocket text messages handled, by message type.",
+	}, []string{"msg_type"})
+
+	metricsTransferBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "xxtcc_transfer_bytes_total",
+		Help: "Bytes streamed through transfer tokens (download = server to device, upload = device to server).",
+	}, []string{"direction"})
+
+	metricsMessageTypes = struct {
+		sync.Mutex
+		seen map[string]bool
+	}{
+		seen: make(map[string]bool),
+	}
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metricsMessagesHandled,
+		metricsTransferBytes,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_devices_connected",
+			Help: "Devices with an open WebSocket connection.",
+		}, func() float64 {
+			mu.RLock()
+			defer mu.RUnlock()
+			return float64(len(deviceLinks))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_devices_registered",
+			Help: "Devices counted against maxDevices: connected or within their offline grace period.",
+		}, func() float64 {
+			mu.RLock()
+			defer mu.RUnlock()
+			return float64(registeredDeviceCountLocked())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_devices_max",
+			Help: "Configured maxDevices cap (0 = unlimited).",
+		}, func() float64 {
+			return float64(getServerConfig().MaxDevices)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "xxtcc_devices_rejected_total",
+			Help: "Device registrations rejected because maxDevices was reached.",
+		}, func() float64 {
+			return float64(deviceCapRejected.Load())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_controllers_connected",
+			Help: "Controllers with an open WebSocket connection.",
+		}, func() float64 {
+			mu.RLock()
+			defer mu.RUnlock()
+			return float64(len(controllers))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_transfer_tokens_active",
+			Help: "Transfer tokens that have not expired or been consumed.",
+		}, func() float64 {
+			transferTokensMu.RLock()
+			defer transferTokensMu.RUnlock()
+			return float64(len(transferTokens))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_transfers_active",
+			Help: "Transfer downloads/uploads currently streaming.",
+		}, func() float64 {
+			return float64(getActiveTransferCount())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_async_write_slots_in_use",
+			Help: "Occupied slots of the shared async write pool.",
+		}, func() float64 {
+			return float64(len(asyncWriteSlots))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_async_write_slots_capacity",
+			Help: "Capacity of the shared async write pool.",
+		}, func() float64 {
+			return float64(cap(asyncWriteSlots))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "xxtcc_script_start_sessions_in_flight",
+			Help: "Script-start sessions waiting for devices to fetch their files.",
+		}, func() float64 {
+			scriptStartSessions.Lock()
+			defer scriptStartSessions.Unlock()
+			return float64(len(scriptStartSessions.entries))
+		}),
+	)
+}
+
+// metricsMessageTypeLabel returns msgType, or "other" once the label budget is used up.
+func metricsMessageTypeLabel(msgType string) string {
+	metricsMessageTypes.Lock()
+	defer metricsMessageTypes.Unlock()
+	if metricsMessageTypes.seen[msgType] {
+		return msgType
+	}
+	if len(metricsMessageTypes.seen) >= maxMetricsMessageTypes {
+		return "other"
+	}
+	metricsMessageTypes.seen[msgType] = true
+	return msgType
+}
+
+func recordMetricsMessageHandled(msgType string) {
+	metricsMessagesHandled.WithLabelValues(metricsMessageTypeLabel(msgType)).Inc()
+}
+
+func recordMetricsTransferBytes(direction string, n int64) {
+	if n > 0 {
+		metricsTransferBytes.WithLabelValues(direction).Add(float64(n))
+	}
+}
+
+func metricsHTTPHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// metricsHandler serves the metrics text behind the local admin API.
+func metricsHandler(c *gin.Context) {
+	metricsHTTPHandler().ServeHTTP(c.Writer, c.Request)
+}
+
+// defaultMetricsAddr keeps the unauthenticated /metrics endpoint local unless
+// metricsAddr explicitly names another interface.
+const defaultMetricsAddr = "127.0.0.1:9464"
+
+// startMetricsServer serves /metrics on its own listener; an empty addr uses
+// defaultMetricsAddr. The endpoint is never mounted on the public router.
+func startMetricsServer(addr string) *http.Server {
+	if addr == "" {
+		addr = defaultMetricsAddr
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHTTPHandler())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: httpServerReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server failed", "addr", addr, "error", err)
+		}
+	}()
+	return server
+}
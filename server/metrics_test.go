@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandlerExposesGauges(t *testing.T) {
+	recordMetricsMessageHandled("control/devices")
+	recordMetricsTransferBytes("download", 128)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	metricsHTTPHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"xxtcc_devices_connected ",
+		"xxtcc_controllers_connected ",
+		"xxtcc_transfer_tokens_active ",
+		"xxtcc_async_write_slots_in_use ",
+		"xxtcc_script_start_sessions_in_flight ",
+		`xxtcc_messages_handled_total{msg_type="control/devices"}`,
+		`xxtcc_transfer_bytes_total{direction="download"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestMetricsMessageTypeLabelIsBounded(t *testing.T) {
+	metricsMessageTypes.Lock()
+	prev := metricsMessageTypes.seen
+	metricsMessageTypes.seen = make(map[string]bool)
+	metricsMessageTypes.Unlock()
+	t.Cleanup(func() {
+		metricsMessageTypes.Lock()
+		metricsMessageTypes.seen = prev
+		metricsMessageTypes.Unlock()
+	})
+
+	for i := 0; i < maxMetricsMessageTypes; i++ {
+		metricsMessageTypeLabel(strings.Repeat("x", i+1))
+	}
+	if got := metricsMessageTypeLabel("app/state-new"); got != "other" {
+		t.Fatalf("expected overflow label other, got %q", got)
+	}
+	if got := metricsMessageTypeLabel("x"); got != "x" {
+		t.Fatalf("known type should keep its label, got %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pulseWindowSeconds is the longest averaging window; one extra bucket holds the
+// second currently being counted.
+const (
+	pulseWindowSeconds = 60
+	pulseBuckets       = pulseWindowSeconds + 1
+)
+
+// pulseCounter counts events in rolling one-second buckets.
+type pulseCounter struct {
+	mu      sync.Mutex
+	counts  [pulseBuckets]int64
+	seconds [pulseBuckets]int64
+}
+
+func (p *pulseCounter) add(now time.Time, n int64) {
+	sec := now.Unix()
+	i := sec % pulseBuckets
+	p.mu.Lock()
+	if p.seconds[i] != sec {
+		p.seconds[i] = sec
+		p.counts[i] = 0
+	}
+	p.counts[i] += n
+	p.mu.Unlock()
+}
+
+// average returns the mean events per second over the last n completed seconds.
+func (p *pulseCounter) average(now time.Time, n int) float64 {
+	if n <= 0 {
+		return 0
+	}
+	if n > pulseWindowSeconds {
+		n = pulseWindowSeconds
+	}
+	current := now.Unix()
+	var total int64
+	p.mu.Lock()
+	for sec := current - int64(n); sec < current; sec++ {
+		i := sec % pulseBuckets
+		if p.seconds[i] == sec {
+			total += p.counts[i]
+		}
+	}
+	p.mu.Unlock()
+	return float64(total) / float64(n)
+}
+
+var (
+	pulseMessagesIn   pulseCounter // WebSocket messages received from controllers and devices
+	pulseMessagesSent pulseCounter // WebSocket text messages written (every fan-out target counts)
+)
+
+func recordPulseMessageIn() {
+	pulseMessagesIn.add(time.Now(), 1)
+}
+
+func recordPulseMessageSent() {
+	pulseMessagesSent.add(time.Now(), 1)
+}
+
+type pulseRates struct {
+	MessagesPerSecond   float64 `json:"messagesPerSecond"`
+	BroadcastsPerSecond float64 `json:"broadcastsPerSecond"`
+}
+
+type pulseSnapshot struct {
+	TS              int64      `json:"ts"`
+	TransfersActive int64      `json:"transfersActive"`
+	LastSecond      pulseRates `json:"lastSecond"`
+	Last10Seconds   pulseRates `json:"last10Seconds"`
+	Last60Seconds   pulseRates `json:"last60Seconds"`
+}
+
+func getPulseSnapshot(now time.Time) pulseSnapshot {
+	rates := func(n int) pulseRates {
+		return pulseRates{
+			MessagesPerSecond:   pulseMessagesIn.average(now, n),
+			BroadcastsPerSecond: pulseMessagesSent.average(now, n),
+		}
+	}
+	return pulseSnapshot{
+		TS:              now.Unix(),
+		TransfersActive: getActiveTransferCount(),
+		LastSecond:      rates(1),
+		Last10Seconds:   rates(10),
+		Last60Seconds:   rates(pulseWindowSeconds),
+	}
+}
+
+// pulseHandler handles GET /api/pulse
+func pulseHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, getPulseSnapshot(time.Now()))
+}
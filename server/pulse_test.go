@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPulseCounter_AveragesCompletedSeconds(t *testing.T) {
+	var p pulseCounter
+	base := time.Unix(1700000000, 0)
+
+	p.add(base.Add(-2*time.Second), 4)
+	p.add(base.Add(-time.Second), 6)
+	p.add(base, 100) // current second is still being counted
+
+	if got := p.average(base, 1); got != 6 {
+		t.Fatalf("expected last-second rate 6, got %v", got)
+	}
+	if got := p.average(base, 10); got != 1 {
+		t.Fatalf("expected 10s average 1, got %v", got)
+	}
+}
+
+func TestPulseCounter_IgnoresBucketsFromOlderWindows(t *testing.T) {
+	var p pulseCounter
+	base := time.Unix(1700000000, 0)
+
+	p.add(base.Add(-time.Duration(pulseBuckets+1)*time.Second), 50)
+	if got := p.average(base, pulseWindowSeconds); got != 0 {
+		t.Fatalf("stale bucket should not count, got %v", got)
+	}
+
+	p.add(base.Add(-time.Second), 60)
+	if got := p.average(base, pulseWindowSeconds); got != 1 {
+		t.Fatalf("expected 60s average 1, got %v", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// rateLimitNoticeInterval throttles "rate limit exceeded" replies so a flooding
+// connection does not get one error per dropped message.
+const rateLimitNoticeInterval = time.Second
+
+// tokenBucket refills at rate tokens/second up to burst tokens.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes one token if available. A zero-valued bucket starts full.
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	capacity := float64(burst)
+	if capacity < 1 {
+		capacity = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// connRateLimiter holds the per-connection buckets for controller and device traffic.
+type connRateLimiter struct {
+	mu         sync.Mutex
+	control    tokenBucket
+	device     tokenBucket
+	lastNotice time.Time
+}
+
+// getMessageRateLimit returns the configured messages/second and burst for a role (rate 0 = unlimited).
+func getMessageRateLimit(isController bool) (float64, int) {
+	if isController {
+		return getServerConfig().ControlRateLimit, getServerConfig().ControlRateBurst
+	}
+	return getServerConfig().DeviceRateLimit, getServerConfig().DeviceRateBurst
+}
+
+// allowMessage reports whether conn may send another text message at now. When it may not,
+// notify is true at most once per rateLimitNoticeInterval.
+func (l *connRateLimiter) allowMessage(isController bool, now time.Time) (allowed, notify bool) {
+	rate, burst := getMessageRateLimit(isController)
+	if rate <= 0 {
+		return true, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bucket := &l.device
+	if isController {
+		bucket = &l.control
+	}
+	if bucket.allow(now, rate, burst) {
+		return true, false
+	}
+	if now.Sub(l.lastNotice) < rateLimitNoticeInterval {
+		return false, false
+	}
+	l.lastNotice = now
+	return false, true
+}
+
+// enforceMessageRateLimit drops data when conn exceeds its rate limit, replying with an
+// error message instead of disconnecting. It returns false if the message was dropped.
+// Connections on a role-specific path use that role's limits from their first message.
+func enforceMessageRateLimit(conn *SafeConn, data Message) bool {
+	isController := conn.role == connRoleControl
+	if conn.role == "" {
+		mu.RLock()
+		isController = controllers[conn]
+		mu.RUnlock()
+	}
+
+	allowed, notify := conn.rateLimiter.allowMessage(isController, time.Now())
+	if allowed {
+		return true
+	}
+	if notify {
+		slog.Warn("Rate limit exceeded, dropping messages", "remote_addr", conn.RemoteAddr(), "msg_type", data.Type)
+		sendMessageAsync(conn, Message{
+			Type:      "error",
+			RequestID: data.RequestID,
+			Error:     "rate limit exceeded",
+			Body:      map[string]interface{}{"type": data.Type},
+		})
+	}
+	return false
+}
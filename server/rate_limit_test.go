@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefillsUpToBurst(t *testing.T) {
+	var bucket tokenBucket
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !bucket.allow(now, 1, 3) {
+			t.Fatalf("message %d should fit in the burst", i)
+		}
+	}
+	if bucket.allow(now, 1, 3) {
+		t.Fatalf("expected bucket to be empty after burst")
+	}
+	if !bucket.allow(now.Add(time.Second), 1, 3) {
+		t.Fatalf("expected one token after one second")
+	}
+	if bucket.allow(now.Add(time.Second), 1, 3) {
+		t.Fatalf("expected only one refilled token")
+	}
+}
+
+func TestConnRateLimiterSeparatesControllerAndDeviceLimits(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ControlRateLimit, cfg.ControlRateBurst = 1, 1 })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.DeviceRateLimit, cfg.DeviceRateBurst = 0, 0 })
+
+	var limiter connRateLimiter
+	now := time.Now()
+	if allowed, _ := limiter.allowMessage(true, now); !allowed {
+		t.Fatalf("first controller message should pass")
+	}
+	allowed, notify := limiter.allowMessage(true, now)
+	if allowed || !notify {
+		t.Fatalf("expected second controller message to be dropped with a notice, got allowed=%v notify=%v", allowed, notify)
+	}
+	if _, notify := limiter.allowMessage(true, now.Add(100*time.Millisecond)); notify {
+		t.Fatalf("notices should be throttled")
+	}
+	for i := 0; i < 100; i++ {
+		if allowed, _ := limiter.allowMessage(false, now); !allowed {
+			t.Fatalf("device messages should be unlimited when deviceRateLimit is 0")
+		}
+	}
+}
+
+func TestEnforceMessageRateLimitRepliesWithError(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ControlRateLimit, cfg.ControlRateBurst = 0.001, 1 })
+
+	conn, client := newTestWebSocketPair(t)
+	mu.Lock()
+	controllers[conn] = true
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(controllers, conn)
+		mu.Unlock()
+	})
+
+	if !enforceMessageRateLimit(conn, Message{Type: "control/refresh"}) {
+		t.Fatalf("first message should pass")
+	}
+	if enforceMessageRateLimit(conn, Message{Type: "control/command", RequestID: "r1"}) {
+		t.Fatalf("second message should be dropped")
+	}
+	msg := readTestMessage(t, client)
+	if msg.Type != "error" || msg.RequestID != "r1" || msg.Error != "rate limit exceeded" {
+		t.Fatalf("unexpected reply: %+v", msg)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// refreshCoalescer folds control/refresh requests from all controllers that arrive
+// within the coalesce window into a single fleet-wide app/state broadcast.
+var refreshCoalescer = struct {
+	sync.Mutex
+	timer    *time.Timer
+	requests int
+}{}
+
+// getRefreshCoalesceWindow returns the window refresh requests are merged over (0 = disabled).
+func getRefreshCoalesceWindow() time.Duration {
+	if getServerConfig().RefreshCoalesceMs <= 0 {
+		return 0
+	}
+	return time.Duration(getServerConfig().RefreshCoalesceMs) * time.Millisecond
+}
+
+// requestFleetRefresh schedules an app/state broadcast to all devices.
+// Requests arriving while one is already pending are merged into it.
+func requestFleetRefresh() {
+	window := getRefreshCoalesceWindow()
+	if window <= 0 {
+		broadcastFleetRefresh()
+		return
+	}
+
+	refreshCoalescer.Lock()
+	defer refreshCoalescer.Unlock()
+	refreshCoalescer.requests++
+	if refreshCoalescer.timer != nil {
+		return
+	}
+	refreshCoalescer.timer = time.AfterFunc(window, flushFleetRefresh)
+}
+
+func flushFleetRefresh() {
+	refreshCoalescer.Lock()
+	requests := refreshCoalescer.requests
+	refreshCoalescer.requests = 0
+	refreshCoalescer.timer = nil
+	refreshCoalescer.Unlock()
+
+	if requests > 1 {
+		logDebug("Coalesced refresh requests into one broadcast", "requests", requests)
+	}
+	broadcastFleetRefresh()
+}
+
+// broadcastFleetRefresh asks every connected device to report its app/state.
+func broadcastFleetRefresh() {
+	mu.RLock()
+	deviceConns := make([]*SafeConn, 0, len(deviceLinks))
+	for _, deviceConn := range deviceLinks {
+		deviceConns = append(deviceConns, deviceConn)
+	}
+	mu.RUnlock()
+
+	refreshBytes, err := json.Marshal(Message{
+		Type: "app/state",
+		Body: "",
+	})
+	if err != nil {
+		return
+	}
+	for _, deviceConn := range deviceConns {
+		writeTextMessageAsync(deviceConn, refreshBytes)
+	}
+}
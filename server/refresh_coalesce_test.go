@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestFleetRefresh_CoalescesWithinWindow(t *testing.T) {
+	deviceConn, client := newTestWebSocketPair(t)
+
+	backupWindow := getServerConfig().RefreshCoalesceMs
+	updateServerConfig(func(cfg *ServerConfig) { cfg.RefreshCoalesceMs = 50 })
+	mu.Lock()
+	linksBackup := deviceLinks
+	deviceLinks = map[string]*SafeConn{"d1": deviceConn}
+	mu.Unlock()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.RefreshCoalesceMs = backupWindow })
+		mu.Lock()
+		deviceLinks = linksBackup
+		mu.Unlock()
+	})
+
+	for i := 0; i < 3; i++ {
+		requestFleetRefresh()
+	}
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "app/state" {
+		t.Fatalf("expected app/state, got %q", msg.Type)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, payload, err := client.ReadMessage(); err == nil {
+		t.Fatalf("expected a single coalesced broadcast, got extra message %s", payload)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// screenFrameLimiter remembers when a frame was last fanned out per device,
+// so intermediate frames above the configured max FPS can be dropped.
+var screenFrameLimiter = struct {
+	sync.Mutex
+	lastSent map[string]time.Time
+}{
+	lastSent: make(map[string]time.Time),
+}
+
+// getScreenFrameMinInterval returns the minimum gap between forwarded frames (0 = unlimited).
+func getScreenFrameMinInterval() time.Duration {
+	fps := getServerConfig().ScreenFrameMaxFPS
+	if fps <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(fps)
+}
+
+// allowScreenFrame reports whether a frame from udid may be forwarded at now,
+// recording it as sent when allowed.
+func allowScreenFrame(udid string, now time.Time) bool {
+	minInterval := getScreenFrameMinInterval()
+
+	screenFrameLimiter.Lock()
+	defer screenFrameLimiter.Unlock()
+	if minInterval > 0 {
+		if last, ok := screenFrameLimiter.lastSent[udid]; ok && now.Sub(last) < minInterval {
+			return false
+		}
+	}
+	screenFrameLimiter.lastSent[udid] = now
+	return true
+}
+
+func resetScreenFrameLimiter(udid string) {
+	screenFrameLimiter.Lock()
+	delete(screenFrameLimiter.lastSent, udid)
+	screenFrameLimiter.Unlock()
+}
+
+// sendScreenStreamControl sends screen/stream/start or screen/stream/stop to devices.
+func sendScreenStreamControl(msgType string, deviceConns []*SafeConn) {
+	if len(deviceConns) == 0 {
+		return
+	}
+	payload, err := json.Marshal(Message{Type: msgType})
+	if err != nil {
+		return
+	}
+	for _, deviceConn := range deviceConns {
+		writeTextMessageAsync(deviceConn, payload)
+	}
+}
+
+// forwardScreenFrame fans out a device screen frame to its subscribers,
+// dropping frames that exceed the configured max FPS. The frame image is also cached
+// for /api/devices/:udid/screenshot and handed to MJPEG viewers.
+func forwardScreenFrame(conn *SafeConn, data Message) error {
+	var (
+		udid           string
+		subscriberList []*SafeConn
+		viewers        []*mjpegViewer
+	)
+	mu.RLock()
+	if mappedUDID, exists := deviceLinksMap[conn]; exists {
+		udid = mappedUDID
+		if subs, ok := screenSubscriptions[udid]; ok && len(subs) > 0 {
+			subscriberList = make([]*SafeConn, 0, len(subs))
+			for controllerConn := range subs {
+				if controllerConn == mjpegScreenSink {
+					continue
+				}
+				subscriberList = append(subscriberList, controllerConn)
+			}
+		}
+		viewers = snapshotMJPEGViewersLocked(udid)
+	}
+	mu.RUnlock()
+
+	if udid == "" {
+		return nil
+	}
+	publishScreenFrameImage(udid, data.Body, viewers, time.Now())
+	if len(subscriberList) == 0 {
+		return nil
+	}
+	if !allowScreenFrame(udid, time.Now()) {
+		return nil
+	}
+
+	data.UDID = udid
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	for _, controllerConn := range subscriberList {
+		writeTextMessageAsync(controllerConn, encodedData)
+	}
+	return nil
+}
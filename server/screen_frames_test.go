@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllowScreenFrame_DropsFramesAboveMaxFPS(t *testing.T) {
+	backup := *getServerConfig()
+	t.Cleanup(func() {
+		setServerConfig(backup)
+		resetScreenFrameLimiter("udid-fps")
+	})
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenFrameMaxFPS = 10 })
+	base := time.Now()
+	if !allowScreenFrame("udid-fps", base) {
+		t.Fatalf("expected first frame to pass")
+	}
+	if allowScreenFrame("udid-fps", base.Add(50*time.Millisecond)) {
+		t.Fatalf("expected frame within 100ms window to be dropped")
+	}
+	if !allowScreenFrame("udid-fps", base.Add(100*time.Millisecond)) {
+		t.Fatalf("expected frame after 100ms to pass")
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenFrameMaxFPS = 0 })
+	if !allowScreenFrame("udid-fps", base.Add(101*time.Millisecond)) {
+		t.Fatalf("expected unlimited mode to pass every frame")
+	}
+}
+
+func TestForwardScreenFrame_FansOutToSubscribersOnly(t *testing.T) {
+	backup := *getServerConfig()
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScreenFrameMaxFPS = 1 })
+	controllerConn, client := newTestWebSocketPair(t)
+	deviceConn := &SafeConn{}
+
+	mu.Lock()
+	linkMapBackup := deviceLinksMap
+	subsBackup := screenSubscriptions
+	deviceLinksMap = map[*SafeConn]string{deviceConn: "udid-screen"}
+	screenSubscriptions = map[string]map[*SafeConn]bool{"udid-screen": {controllerConn: true}}
+	mu.Unlock()
+	t.Cleanup(func() {
+		setServerConfig(backup)
+		mu.Lock()
+		deviceLinksMap = linkMapBackup
+		screenSubscriptions = subsBackup
+		mu.Unlock()
+		resetScreenFrameLimiter("udid-screen")
+	})
+
+	if err := forwardScreenFrame(deviceConn, Message{Type: "screen/frame", Body: map[string]interface{}{"seq": 1}}); err != nil {
+		t.Fatalf("forward failed: %v", err)
+	}
+	// Second frame inside the 1fps window must be dropped.
+	if err := forwardScreenFrame(deviceConn, Message{Type: "screen/frame", Body: map[string]interface{}{"seq": 2}}); err != nil {
+		t.Fatalf("forward failed: %v", err)
+	}
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "screen/frame" || msg.UDID != "udid-screen" {
+		t.Fatalf("unexpected forwarded message: %+v", msg)
+	}
+	body, _ := msg.Body.(map[string]interface{})
+	if seq, _ := toInt(body["seq"]); seq != 1 {
+		t.Fatalf("expected first frame to be forwarded, got %+v", msg.Body)
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Fatalf("expected rate-limited frame to be dropped")
+	}
+}
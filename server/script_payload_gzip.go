@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+)
+
+// filePutEncodingGzip marks a file/put whose data is base64 of gzip-compressed bytes.
+const filePutEncodingGzip = "gzip+base64"
+
+// encodeScriptFileGzip returns base64(gzip(content)) when it is smaller than the plain
+// base64 encoding, or "" when compression does not pay off.
+func encodeScriptFileGzip(content []byte, plainEncodedLen int) string {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return ""
+	}
+	if _, err := zw.Write(content); err != nil {
+		return ""
+	}
+	if err := zw.Close(); err != nil {
+		return ""
+	}
+	if base64.StdEncoding.EncodedLen(buf.Len()) >= plainEncodedLen {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func buildGzipFilePutPayload(path string, data string) ([]byte, error) {
+	return json.Marshal(Message{
+		Type: "file/put",
+		Body: gin.H{
+			"path":     path,
+			"data":     data,
+			"encoding": filePutEncodingGzip,
+		},
+	})
+}
+
+// deviceSupportsFeature reports whether udid's last app/state lists feature in system.features.
+func deviceSupportsFeature(udid string, feature string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	state, ok := deviceTable[udid].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	system, ok := state["system"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	features, ok := system["features"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, f := range features {
+		if name, ok := f.(string); ok && name == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptGzipEnabledForDevice reports whether small script files may be sent gzip-encoded to udid.
+func scriptGzipEnabledForDevice(udid string) bool {
+	return getServerConfig().ScriptGzipPayloads && deviceSupportsFeature(udid, "gzip")
+}
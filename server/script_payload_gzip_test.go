@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSendSmallFilesToConn_GzipForCapableDevices(t *testing.T) {
+	root := t.TempDir()
+	content := []byte(strings.Repeat("print('hello')\n", 200))
+	if err := os.WriteFile(filepath.Join(root, "main.lua"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := collectScriptPackage(root, "demo", true, false, false)
+	if err != nil || len(files) != 1 || files[0].GzipData == "" {
+		t.Fatalf("expected compressible file to carry gzip data, got %+v (%v)", files, err)
+	}
+
+	prevGzip := getServerConfig().ScriptGzipPayloads
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ScriptGzipPayloads = true })
+	mu.Lock()
+	tableBackup := deviceTable
+	deviceTable = map[string]interface{}{
+		"new": map[string]interface{}{"system": map[string]interface{}{"features": []interface{}{"gzip"}}},
+		"old": map[string]interface{}{"system": map[string]interface{}{}},
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.ScriptGzipPayloads = prevGzip })
+		mu.Lock()
+		deviceTable = tableBackup
+		mu.Unlock()
+	})
+
+	conn, client := newTestWebSocketPair(t)
+	sender := newScriptFileSender(files, nil)
+
+	sender.sendSmallFilesToConn(conn, "new", false)
+	body, _ := readTestMessage(t, client).Body.(map[string]interface{})
+	if body["encoding"] != filePutEncodingGzip {
+		t.Fatalf("expected gzip encoding for capable device, got %v", body["encoding"])
+	}
+	compressed, _ := base64.StdEncoding.DecodeString(body["data"].(string))
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("invalid gzip data: %v", err)
+	}
+	if raw, _ := io.ReadAll(zr); !bytes.Equal(raw, content) {
+		t.Fatalf("decompressed data mismatch")
+	}
+
+	sender.sendSmallFilesToConn(conn, "old", false)
+	body, _ = readTestMessage(t, client).Body.(map[string]interface{})
+	if _, ok := body["encoding"]; ok || body["data"] != files[0].Data {
+		t.Fatalf("expected plain base64 for legacy device, got %v", body)
+	}
+}
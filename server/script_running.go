@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runningScriptInfo is the last-known script state of one device.
+type runningScriptInfo struct {
+	Name      string `json:"name,omitempty"`
+	Running   bool   `json:"running"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// runningScripts tracks script/run and script/stop sent to devices, the devices' replies
+// and the running flag reported in app/state.
+var runningScripts = struct {
+	sync.Mutex
+	entries map[string]runningScriptInfo
+}{
+	entries: make(map[string]runningScriptInfo),
+}
+
+func scriptNameFromBody(body interface{}) string {
+	bodyMap, ok := body.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := bodyMap["name"].(string)
+	return strings.TrimSpace(name)
+}
+
+// setRunningScript updates udid's entry; an empty name keeps the previously known one.
+func setRunningScript(udid, name string, running bool) {
+	runningScripts.Lock()
+	entry := runningScripts.entries[udid]
+	if name != "" {
+		entry.Name = name
+	}
+	entry.Running = running
+	entry.UpdatedAt = time.Now().Unix()
+	runningScripts.entries[udid] = entry
+	runningScripts.Unlock()
+}
+
+// noteScriptCommandSent records a script/run or script/stop sent to udid.
+func noteScriptCommandSent(udid, cmdType string, body interface{}) {
+	switch cmdType {
+	case "script/run":
+		setRunningScript(udid, scriptNameFromBody(body), true)
+	case "script/stop":
+		setRunningScript(udid, "", false)
+	}
+}
+
+// noteScriptReply records a device's reply to script/run or script/stop; a failed
+// script/run means nothing is running.
+func noteScriptReply(udid string, data Message) {
+	switch data.Type {
+	case "script/run":
+		setRunningScript(udid, scriptNameFromBody(data.Body), data.Error == "")
+	case "script/stop":
+		if data.Error == "" {
+			setRunningScript(udid, "", false)
+		}
+	}
+}
+
+// noteScriptStateFromAppState applies system.running from an app/state body. The device's
+// selected script fills in the name when none was seen in a script/run.
+func noteScriptStateFromAppState(udid string, bodyMap map[string]interface{}) {
+	system, ok := bodyMap["system"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	running, ok := system["running"].(bool)
+	if !ok {
+		return
+	}
+
+	runningScripts.Lock()
+	defer runningScripts.Unlock()
+	entry, known := runningScripts.entries[udid]
+	if known && entry.Running == running && entry.Name != "" {
+		return
+	}
+	if entry.Name == "" {
+		if script, ok := bodyMap["script"].(map[string]interface{}); ok {
+			entry.Name = stateString(script, "select")
+		}
+	}
+	entry.Running = running
+	entry.UpdatedAt = time.Now().Unix()
+	runningScripts.entries[udid] = entry
+}
+
+func forgetRunningScript(udid string) {
+	runningScripts.Lock()
+	delete(runningScripts.entries, udid)
+	runningScripts.Unlock()
+}
+
+// scriptsRunningHandler handles GET /api/scripts/running
+func scriptsRunningHandler(c *gin.Context) {
+	runningScripts.Lock()
+	devices := make(map[string]runningScriptInfo)
+	for udid, entry := range runningScripts.entries {
+		if entry.Running {
+			devices[udid] = entry
+		}
+	}
+	runningScripts.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// scriptsStopAllHandler handles POST /api/scripts/stop-all
+func scriptsStopAllHandler(c *gin.Context) {
+	mu.RLock()
+	udids := make([]string, 0, len(deviceLinks))
+	for udid := range deviceLinks {
+		udids = append(udids, udid)
+	}
+	mu.RUnlock()
+
+	sent, err := dispatchCommandToDevices(udids, "script/stop", nil, "", "http")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send script/stop"})
+		return
+	}
+	recordServerEvent("control/command", "", gin.H{"type": "script/stop", "devices": udids})
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "sent": sent})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func resetRunningScriptsForTest(t *testing.T) {
+	t.Helper()
+	runningScripts.Lock()
+	backup := runningScripts.entries
+	runningScripts.entries = make(map[string]runningScriptInfo)
+	runningScripts.Unlock()
+	t.Cleanup(func() {
+		runningScripts.Lock()
+		runningScripts.entries = backup
+		runningScripts.Unlock()
+	})
+}
+
+func decodeRunningScripts(t *testing.T) map[string]runningScriptInfo {
+	t.Helper()
+	w := performJSONHandlerRequest(t, http.MethodGet, "/api/scripts/running", nil, scriptsRunningHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Devices map[string]runningScriptInfo `json:"devices"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response failed: %v", err)
+	}
+	return resp.Devices
+}
+
+func TestRunningScriptsTracksLifecycle(t *testing.T) {
+	resetRunningScriptsForTest(t)
+
+	noteScriptCommandSent("d1", "script/run", map[string]interface{}{"name": "demo.lua"})
+	noteScriptCommandSent("d2", "script/run", map[string]interface{}{"name": "other.lua"})
+	noteScriptReply("d2", Message{Type: "script/run", Error: "script not found"})
+	noteScriptStateFromAppState("d3", map[string]interface{}{
+		"system": map[string]interface{}{"running": true},
+		"script": map[string]interface{}{"select": "selected.lua"},
+	})
+
+	devices := decodeRunningScripts(t)
+	if len(devices) != 2 || devices["d1"].Name != "demo.lua" || devices["d3"].Name != "selected.lua" {
+		t.Fatalf("unexpected running scripts: %+v", devices)
+	}
+
+	noteScriptReply("d1", Message{Type: "script/stop"})
+	noteScriptStateFromAppState("d3", map[string]interface{}{"system": map[string]interface{}{"running": false}})
+	if devices := decodeRunningScripts(t); len(devices) != 0 {
+		t.Fatalf("stopped scripts should not be listed, got %+v", devices)
+	}
+}
+
+func TestScriptsStopAllHandlerSendsStopToEveryDevice(t *testing.T) {
+	resetRunningScriptsForTest(t)
+	conn1, client1 := newTestWebSocketPair(t)
+	conn2, client2 := newTestWebSocketPair(t)
+	mu.Lock()
+	prevLinks, prevControllers := deviceLinks, controllers
+	deviceLinks = map[string]*SafeConn{"d1": conn1, "d2": conn2}
+	controllers = make(map[*SafeConn]bool)
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		deviceLinks, controllers = prevLinks, prevControllers
+		mu.Unlock()
+	})
+	noteScriptCommandSent("d1", "script/run", map[string]interface{}{"name": "demo.lua"})
+
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/scripts/stop-all", nil, scriptsStopAllHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Sent int `json:"sent"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Sent != 2 {
+		t.Fatalf("expected sent=2, got %s", w.Body.String())
+	}
+	if msg := readTestMessage(t, client1); msg.Type != "script/stop" {
+		t.Fatalf("d1 expected script/stop, got %q", msg.Type)
+	}
+	if msg := readTestMessage(t, client2); msg.Type != "script/stop" {
+		t.Fatalf("d2 expected script/stop, got %q", msg.Type)
+	}
+	if devices := decodeRunningScripts(t); len(devices) != 0 {
+		t.Fatalf("stop-all should clear running scripts, got %+v", devices)
+	}
+}
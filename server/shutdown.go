@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownNoticeTimeout bounds how long shutdown waits to deliver server/shutdown.
+const shutdownNoticeTimeout = 2 * time.Second
+
+// activeTransferCount is the number of transfer uploads/downloads currently streaming.
+var activeTransferCount int64
+
+// beginTransfer marks a transfer as in flight; call the returned func when it ends.
+func beginTransfer() func() {
+	atomic.AddInt64(&activeTransferCount, 1)
+	return func() {
+		atomic.AddInt64(&activeTransferCount, -1)
+	}
+}
+
+func getActiveTransferCount() int64 {
+	return atomic.LoadInt64(&activeTransferCount)
+}
+
+func getShutdownGracePeriod() time.Duration {
+	return time.Duration(getServerConfig().ShutdownGraceSeconds) * time.Second
+}
+
+// snapshotAllSocketsLocked returns every controller and device socket.
+// Caller must hold mu lock (read or write).
+func snapshotAllSocketsLocked() []*SafeConn {
+	conns := snapshotControllerConnsLocked()
+	for deviceConn := range deviceLinksMap {
+		conns = append(conns, deviceConn)
+	}
+	return conns
+}
+
+// notifyServerShutdown tells every controller and device that the server is going away.
+// It waits (at most shutdownNoticeTimeout) for the writes so the notice is sent before
+// sockets are closed, without letting one stuck socket hold up shutdown.
+func notifyServerShutdown(grace time.Duration) []*SafeConn {
+	mu.RLock()
+	conns := snapshotAllSocketsLocked()
+	mu.RUnlock()
+
+	payload, err := json.Marshal(Message{
+		Type: "server/shutdown",
+		Body: map[string]interface{}{"graceSeconds": int(grace / time.Second)},
+	})
+	if err != nil {
+		slog.Error("Failed to marshal shutdown message", "error", err)
+		return conns
+	}
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *SafeConn) {
+			defer wg.Done()
+			_ = writeTextMessage(conn, payload)
+		}(conn)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownNoticeTimeout):
+	}
+	return conns
+}
+
+// gracefulShutdown stops accepting connections, notifies sockets, waits up to the grace
+// period for in-flight HTTP requests (including transfers) and then closes everything.
+// Tickers and other background work are stopped by main's deferred calls on return.
+func gracefulShutdown(httpServer *http.Server) {
+	grace := getShutdownGracePeriod()
+	slog.Info("Shutting down", "grace_period", grace, "transfers_in_flight", getActiveTransferCount())
+
+	conns := notifyServerShutdown(grace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Warn("Grace period expired, forcing close", "transfers_in_flight", getActiveTransferCount(), "error", err)
+		_ = httpServer.Close()
+	}
+
+	// WebSocket connections are hijacked, so http.Server.Shutdown does not close them.
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	flushAuditLog()
+	slog.Info("Server stopped")
+}
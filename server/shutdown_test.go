@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGracefulShutdown_NotifiesSocketsAndStopsServer(t *testing.T) {
+	controllerConn, client := newTestWebSocketPair(t)
+
+	prevConfig := *getServerConfig()
+	mu.Lock()
+	controllersBackup := controllers
+	controllers = map[*SafeConn]bool{controllerConn: true}
+	mu.Unlock()
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ShutdownGraceSeconds = 1 })
+	t.Cleanup(func() {
+		setServerConfig(prevConfig)
+		mu.Lock()
+		controllers = controllersBackup
+		mu.Unlock()
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	httpServer := &http.Server{Handler: http.NotFoundHandler()}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- httpServer.Serve(listener) }()
+
+	gracefulShutdown(httpServer)
+
+	msg := readTestMessage(t, client)
+	if msg.Type != "server/shutdown" {
+		t.Fatalf("expected server/shutdown, got %q", msg.Type)
+	}
+	body, _ := msg.Body.(map[string]interface{})
+	if body["graceSeconds"] != float64(1) {
+		t.Fatalf("expected graceSeconds 1, got %#v", msg.Body)
+	}
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("expected ErrServerClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not stop")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); err == nil {
+		t.Fatalf("controller socket should be closed after shutdown")
+	}
+}
+
+func TestBeginTransfer_TracksActiveCount(t *testing.T) {
+	before := getActiveTransferCount()
+	done := beginTransfer()
+	if got := getActiveTransferCount(); got != before+1 {
+		t.Fatalf("expected %d active transfers, got %d", before+1, got)
+	}
+	done()
+	if got := getActiveTransferCount(); got != before {
+		t.Fatalf("expected %d active transfers after done, got %d", before, got)
+	}
+}
@@ -23,9 +23,9 @@ func resolveTransferBaseURL(c *gin.Context, preferred string) string {
 	}
 
 	if ip, ok := firstNonLoopbackIPv4(); ok {
-		return fmt.Sprintf("%s://%s:%d", scheme, ip, serverConfig.Port)
+		return fmt.Sprintf("%s://%s:%d", scheme, ip, getServerConfig().Port)
 	}
-	return fmt.Sprintf("http://127.0.0.1:%d", serverConfig.Port)
+	return fmt.Sprintf("http://127.0.0.1:%d", getServerConfig().Port)
 }
 
 func requestTransferScheme(c *gin.Context) string {
@@ -92,8 +92,8 @@ func normalizeTransferBaseURL(parsed *url.URL, fallbackScheme string) string {
 			switch {
 			case port != "":
 				parsed.Host = net.JoinHostPort(ip, port)
-			case serverConfig.Port > 0:
-				parsed.Host = net.JoinHostPort(ip, strconv.Itoa(serverConfig.Port))
+			case getServerConfig().Port > 0:
+				parsed.Host = net.JoinHostPort(ip, strconv.Itoa(getServerConfig().Port))
 			default:
 				parsed.Host = ip
 			}
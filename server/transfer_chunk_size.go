@@ -0,0 +1,55 @@
+package main
+
+import "io"
+
+const (
+	minTransferChunkSize     = 4 * 1024
+	maxTransferChunkSize     = 4 * 1024 * 1024
+	defaultTransferChunkSize = 32 * 1024
+
+	defaultLargeFileThreshold = 128 * 1024
+)
+
+// largeFileThreshold is the size from which script files and pushed files are sent with
+// transfer/fetch over HTTP instead of inline as base64 in file/put.
+func largeFileThreshold() int64 {
+	if getServerConfig().LargeFileThresholdBytes > 0 {
+		return getServerConfig().LargeFileThresholdBytes
+	}
+	return defaultLargeFileThreshold
+}
+
+// clampTransferChunkSize bounds a chunk size to [minTransferChunkSize, maxTransferChunkSize].
+func clampTransferChunkSize(size int) int {
+	if size < minTransferChunkSize {
+		return minTransferChunkSize
+	}
+	if size > maxTransferChunkSize {
+		return maxTransferChunkSize
+	}
+	return size
+}
+
+// resolveTransferChunkSize picks the chunk size for a transfer to udid: the per-push
+// request wins, then the per-device override, then the server default.
+func resolveTransferChunkSize(udid string, requested int) int {
+	if requested > 0 {
+		return clampTransferChunkSize(requested)
+	}
+	if size, ok := getServerConfig().DeviceTransferChunkSizes[udid]; ok && size > 0 {
+		return clampTransferChunkSize(size)
+	}
+	if getServerConfig().TransferChunkSize > 0 {
+		return clampTransferChunkSize(getServerConfig().TransferChunkSize)
+	}
+	return defaultTransferChunkSize
+}
+
+// copyWithChunkSize streams src to dst in chunkSize pieces. Both ends are wrapped so
+// io.CopyBuffer cannot bypass the buffer through WriterTo (*os.File) or ReaderFrom.
+func copyWithChunkSize(dst io.Writer, src io.Reader, chunkSize int) (int64, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultTransferChunkSize
+	}
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, chunkSize))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// transferFetchResult is a device's transfer/fetch/complete report.
+type transferFetchResult struct {
+	RequestID  string
+	TargetPath string
+	Success    bool
+	Error      string
+}
+
+// transferCompletionKey identifies one outstanding transfer/fetch on one device.
+type transferCompletionKey struct {
+	DeviceID  string
+	RequestID string
+}
+
+type transferCompletion struct {
+	callback func(transferFetchResult)
+	timer    *time.Timer
+}
+
+// transferCompletions holds the callbacks of transfer/fetch commands sent with a requestId.
+var transferCompletions = struct {
+	sync.Mutex
+	entries map[transferCompletionKey]*transferCompletion
+}{
+	entries: make(map[transferCompletionKey]*transferCompletion),
+}
+
+// parseTransferFetchResult reads a transfer/fetch/complete body. Both requestId and the
+// legacy requestID spelling are accepted, and success may arrive as a bool, string or number.
+func parseTransferFetchResult(body interface{}) (transferFetchResult, bool) {
+	bodyMap, ok := body.(map[string]interface{})
+	if !ok {
+		return transferFetchResult{}, false
+	}
+
+	var result transferFetchResult
+	requestID, _ := bodyMap["requestId"].(string)
+	if strings.TrimSpace(requestID) == "" {
+		requestID, _ = bodyMap["requestID"].(string)
+	}
+	result.RequestID = strings.TrimSpace(requestID)
+
+	targetPath, _ := bodyMap["targetPath"].(string)
+	result.TargetPath = strings.TrimSpace(targetPath)
+
+	switch value := bodyMap["success"].(type) {
+	case bool:
+		result.Success = value
+	case string:
+		result.Success = strings.EqualFold(value, "true")
+	case float64:
+		result.Success = value != 0
+	}
+
+	if value, ok := bodyMap["error"].(string); ok {
+		result.Error = value
+	}
+	return result, true
+}
+
+// registerTransferCompletion arranges for callback to run once when deviceID reports the
+// transfer/fetch tagged requestID as complete. If no report arrives within timeout (when
+// positive), or the device disconnects first, callback runs with a failed result instead.
+func registerTransferCompletion(deviceID, requestID string, timeout time.Duration, callback func(transferFetchResult)) {
+	if deviceID == "" || requestID == "" || callback == nil {
+		return
+	}
+
+	key := transferCompletionKey{DeviceID: deviceID, RequestID: requestID}
+	entry := &transferCompletion{callback: callback}
+
+	transferCompletions.Lock()
+	if existing := transferCompletions.entries[key]; existing != nil && existing.timer != nil {
+		existing.timer.Stop()
+	}
+	if timeout > 0 {
+		entry.timer = time.AfterFunc(timeout, func() {
+			if takeTransferCompletion(key, entry) {
+				callback(transferFetchResult{RequestID: requestID, Error: "transfer timed out"})
+			}
+		})
+	}
+	transferCompletions.entries[key] = entry
+	transferCompletions.Unlock()
+}
+
+// takeTransferCompletion removes entry if it is still registered under key.
+func takeTransferCompletion(key transferCompletionKey, entry *transferCompletion) bool {
+	transferCompletions.Lock()
+	defer transferCompletions.Unlock()
+	if transferCompletions.entries[key] != entry {
+		return false
+	}
+	delete(transferCompletions.entries, key)
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	return true
+}
+
+// unregisterTransferCompletion drops a callback whose transfer/fetch could not be sent.
+func unregisterTransferCompletion(deviceID, requestID string) {
+	key := transferCompletionKey{DeviceID: deviceID, RequestID: requestID}
+	transferCompletions.Lock()
+	entry := transferCompletions.entries[key]
+	transferCompletions.Unlock()
+	if entry != nil {
+		takeTransferCompletion(key, entry)
+	}
+}
+
+// dispatchTransferFetchCompletion routes a transfer/fetch/complete from deviceID to the
+// callback registered for its requestId. Reports without a registered callback (legacy
+// clients omit requestId) fall back to the script-start handling.
+func dispatchTransferFetchCompletion(deviceID string, body interface{}) {
+	result, ok := parseTransferFetchResult(body)
+	if !ok {
+		return
+	}
+
+	if result.RequestID != "" {
+		key := transferCompletionKey{DeviceID: deviceID, RequestID: result.RequestID}
+		transferCompletions.Lock()
+		entry := transferCompletions.entries[key]
+		transferCompletions.Unlock()
+		if entry != nil && takeTransferCompletion(key, entry) {
+			entry.callback(result)
+			return
+		}
+	}
+
+	completeScriptStartFetch(deviceID, result)
+}
+
+// failTransferCompletionsForDevice runs deviceID's outstanding callbacks with a failed
+// result after it disconnects.
+func failTransferCompletionsForDevice(deviceID string) {
+	var failed []transferFetchResult
+	var callbacks []func(transferFetchResult)
+
+	transferCompletions.Lock()
+	for key, entry := range transferCompletions.entries {
+		if key.DeviceID != deviceID {
+			continue
+		}
+		delete(transferCompletions.entries, key)
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+		failed = append(failed, transferFetchResult{RequestID: key.RequestID, Error: "device disconnected"})
+		callbacks = append(callbacks, entry.callback)
+	}
+	transferCompletions.Unlock()
+
+	for i, callback := range callbacks {
+		callback(failed[i])
+	}
+}
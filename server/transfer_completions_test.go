@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func resetTransferCompletionsForTest() {
+	transferCompletions.Lock()
+	for _, entry := range transferCompletions.entries {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+	}
+	transferCompletions.entries = make(map[transferCompletionKey]*transferCompletion)
+	transferCompletions.Unlock()
+}
+
+func TestDispatchTransferFetchCompletionRunsRegisteredCallbackOnce(t *testing.T) {
+	resetTransferCompletionsForTest()
+	defer resetTransferCompletionsForTest()
+
+	var results []transferFetchResult
+	registerTransferCompletion("d1", "req-1", time.Minute, func(result transferFetchResult) {
+		results = append(results, result)
+	})
+
+	// Same requestId from another device must not match.
+	dispatchTransferFetchCompletion("d2", map[string]interface{}{"requestId": "req-1", "success": true})
+	if len(results) != 0 {
+		t.Fatalf("callback should not run for another device, got %+v", results)
+	}
+
+	body := map[string]interface{}{"requestID": "req-1", "targetPath": "/tmp/a.bin", "success": "false", "error": "md5 mismatch"}
+	dispatchTransferFetchCompletion("d1", body)
+	dispatchTransferFetchCompletion("d1", body)
+	if len(results) != 1 {
+		t.Fatalf("expected callback to run once, got %d", len(results))
+	}
+	want := transferFetchResult{RequestID: "req-1", TargetPath: "/tmp/a.bin", Success: false, Error: "md5 mismatch"}
+	if results[0] != want {
+		t.Fatalf("unexpected result: %+v", results[0])
+	}
+}
+
+func TestTransferCompletionFailsOnDisconnectAndTimeout(t *testing.T) {
+	resetTransferCompletionsForTest()
+	defer resetTransferCompletionsForTest()
+
+	done := make(chan transferFetchResult, 2)
+	registerTransferCompletion("d1", "req-disconnect", time.Minute, func(result transferFetchResult) { done <- result })
+	registerTransferCompletion("d1", "req-timeout", 20*time.Millisecond, func(result transferFetchResult) { done <- result })
+	registerTransferCompletion("d1", "req-dropped", time.Minute, func(result transferFetchResult) { done <- result })
+	unregisterTransferCompletion("d1", "req-dropped")
+
+	select {
+	case result := <-done:
+		if result.RequestID != "req-timeout" || result.Success || result.Error != "transfer timed out" {
+			t.Fatalf("unexpected timeout result: %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout callback did not run")
+	}
+
+	failTransferCompletionsForDevice("d1")
+	select {
+	case result := <-done:
+		if result.RequestID != "req-disconnect" || result.Success || result.Error != "device disconnected" {
+			t.Fatalf("unexpected disconnect result: %+v", result)
+		}
+	default:
+		t.Fatalf("disconnect callback did not run")
+	}
+	select {
+	case result := <-done:
+		t.Fatalf("unexpected extra callback: %+v", result)
+	default:
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxPullBatchFiles = 500
+
+// pullBatchEntry is one file of a pull-batch request.
+type pullBatchEntry struct {
+	SourcePath string `json:"sourcePath"`
+	Category   string `json:"category"`
+	Path       string `json:"path"`
+	Token      string `json:"token,omitempty"`
+	Status     string `json:"status,omitempty"` // "pending", "done" or "failed"
+}
+
+// pullBatch tracks the uploads of one POST /api/transfer/pull-batch.
+type pullBatch struct {
+	ID       string
+	DeviceSN string
+	Entries  []pullBatchEntry
+	byToken  map[string]int
+	done     int
+	failed   int
+	timer    *time.Timer
+}
+
+var pullBatches = struct {
+	sync.Mutex
+	entries map[string]*pullBatch
+}{
+	entries: make(map[string]*pullBatch),
+}
+
+func (b *pullBatch) finished() bool {
+	return b.done+b.failed >= len(b.Entries)
+}
+
+// progress is the aggregate transfer/progress for the batch: files pulled so far.
+func (b *pullBatch) progress() TransferProgress {
+	return TransferProgress{
+		Token:      b.ID,
+		DeviceSN:   b.DeviceSN,
+		Type:       "pull-batch",
+		BatchID:    b.ID,
+		FilesDone:  b.done,
+		FilesTotal: len(b.Entries),
+		Percent:    float64(b.done) * 100 / float64(len(b.Entries)),
+	}
+}
+
+func registerPullBatch(batch *pullBatch, ttl time.Duration) {
+	pullBatches.Lock()
+	defer pullBatches.Unlock()
+	pullBatches.entries[batch.ID] = batch
+	batch.timer = time.AfterFunc(ttl, func() {
+		pullBatches.Lock()
+		if pullBatches.entries[batch.ID] == batch {
+			delete(pullBatches.entries, batch.ID)
+		}
+		pullBatches.Unlock()
+	})
+}
+
+// finishPullBatchLocked drops a batch whose files have all completed or failed and
+// records the outcome. Caller must hold pullBatches.
+func finishPullBatchLocked(batch *pullBatch) {
+	if !batch.finished() {
+		return
+	}
+	if batch.timer != nil {
+		batch.timer.Stop()
+	}
+	delete(pullBatches.entries, batch.ID)
+	recordServerEvent("transfer/pull-batch/complete", batch.DeviceSN, gin.H{
+		"batchId": batch.ID,
+		"done":    batch.done,
+		"failed":  batch.failed,
+		"total":   len(batch.Entries),
+	})
+}
+
+// notePullBatchUploadComplete counts a finished upload toward its batch and broadcasts
+// the batch progress.
+func notePullBatchUploadComplete(batchID, token string) {
+	if batchID == "" {
+		return
+	}
+	pullBatches.Lock()
+	batch, ok := pullBatches.entries[batchID]
+	if !ok {
+		pullBatches.Unlock()
+		return
+	}
+	i, ok := batch.byToken[token]
+	if !ok || batch.Entries[i].Status != "pending" {
+		pullBatches.Unlock()
+		return
+	}
+	batch.Entries[i].Status = "done"
+	batch.done++
+	progress := batch.progress()
+	finishPullBatchLocked(batch)
+	pullBatches.Unlock()
+
+	broadcastTransferProgress(progress)
+}
+
+// failPullBatch marks the unfinished files of a batch failed and revokes their tokens,
+// as when the device reports transfer/send-batch/failed or disconnects.
+func failPullBatch(deviceSN, batchID, reason string) bool {
+	pullBatches.Lock()
+	batch, ok := pullBatches.entries[batchID]
+	if !ok || batch.DeviceSN != deviceSN {
+		pullBatches.Unlock()
+		return false
+	}
+	var unused []string
+	for i := range batch.Entries {
+		if batch.Entries[i].Status == "pending" {
+			batch.Entries[i].Status = "failed"
+			batch.failed++
+			unused = append(unused, batch.Entries[i].Token)
+		}
+	}
+	progress := batch.progress()
+	finishPullBatchLocked(batch)
+	pullBatches.Unlock()
+
+	transferTokensMu.Lock()
+	for _, token := range unused {
+		if !uploadsInProgress[token] {
+			delete(transferTokens, token)
+		}
+	}
+	transferTokensMu.Unlock()
+
+	logDebug("Pull batch failed", "udid", deviceSN, "batch_id", batchID, "reason", reason, "revoked", len(unused))
+	broadcastTransferProgress(progress)
+	return true
+}
+
+// failPullBatchesForDevice fails the open batches of a disconnected device.
+func failPullBatchesForDevice(deviceSN string) {
+	pullBatches.Lock()
+	var ids []string
+	for id, batch := range pullBatches.entries {
+		if batch.DeviceSN == deviceSN {
+			ids = append(ids, id)
+		}
+	}
+	pullBatches.Unlock()
+
+	for _, id := range ids {
+		failPullBatch(deviceSN, id, "device disconnected")
+	}
+}
+
+// handlePullBatchFailedMessage handles transfer/send-batch/failed {"batchId", "error"}.
+func handlePullBatchFailedMessage(deviceSN string, body interface{}) {
+	bodyMap, ok := body.(map[string]interface{})
+	if !ok {
+		return
+	}
+	batchID, _ := bodyMap["batchId"].(string)
+	reason, _ := bodyMap["error"].(string)
+	if batchID != "" {
+		failPullBatch(deviceSN, batchID, reason)
+	}
+}
+
+// pullBatchFromDeviceHandler handles POST /api/transfer/pull-batch
+// Creates all upload tokens up front and sends one transfer/send per file, tagged with
+// a shared batchId.
+func pullBatchFromDeviceHandler(c *gin.Context) {
+	var req struct {
+		DeviceSN      string           `json:"deviceSN"`
+		Files         []pullBatchEntry `json:"files"`
+		Timeout       int              `json:"timeout"`       // Upload timeout in seconds, per file
+		ServerBaseUrl string           `json:"serverBaseUrl"` // Server base URL for device to upload to
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if req.DeviceSN == "" || len(req.Files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deviceSN and files are required"})
+		return
+	}
+	if len(req.Files) > maxPullBatchFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d files per batch", maxPullBatchFiles)})
+		return
+	}
+
+	filePaths := make([]string, len(req.Files))
+	for i, entry := range req.Files {
+		if strings.TrimSpace(entry.SourcePath) == "" || entry.Category == "" || entry.Path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("files[%d]: sourcePath, category, and path are required", i)})
+			return
+		}
+		filePath, err := validatePath(entry.Category, entry.Path)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("files[%d]: %v", i, err)})
+			return
+		}
+		filePaths[i] = filePath
+	}
+
+	mu.RLock()
+	_, online := deviceLinks[req.DeviceSN]
+	mu.RUnlock()
+	if !online {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("device %s not connected", req.DeviceSN)})
+		return
+	}
+
+	for _, filePath := range filePaths {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create directory"})
+			return
+		}
+	}
+
+	batch := &pullBatch{
+		ID:       uuid.New().String(),
+		DeviceSN: req.DeviceSN,
+		Entries:  make([]pullBatchEntry, len(req.Files)),
+		byToken:  make(map[string]int, len(req.Files)),
+	}
+	timeout := normalizeTransferTimeoutSeconds(req.Timeout)
+	ttl := transferTokenTTLForTimeout(timeout)
+	expiresAt := time.Now().Add(ttl)
+
+	transferTokensMu.Lock()
+	for i, entry := range req.Files {
+		token := uuid.New().String()
+		transferTokens[token] = &TransferToken{
+			Type:       "upload",
+			FilePath:   filePaths[i],
+			TargetPath: entry.SourcePath,
+			DeviceSN:   req.DeviceSN,
+			ExpiresAt:  expiresAt,
+			OneTime:    true,
+			Category:   entry.Category,
+			BatchID:    batch.ID,
+		}
+		entry.Token = token
+		entry.Status = "pending"
+		batch.Entries[i] = entry
+		batch.byToken[token] = i
+	}
+	transferTokensMu.Unlock()
+	files := append([]pullBatchEntry(nil), batch.Entries...)
+	registerPullBatch(batch, ttl)
+
+	transferBaseURL := resolveTransferBaseURL(c, req.ServerBaseUrl)
+	for _, entry := range files {
+		uploadURL := transferBaseURL + fmt.Sprintf("/api/transfer/upload/%s", entry.Token)
+		if err := sendFileUploadCommand(req.DeviceSN, uploadURL, entry.SourcePath, entry.Path, timeout, batch.ID); err != nil {
+			failPullBatch(req.DeviceSN, batch.ID, err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	logDebug("Pull batch from device", "udid", req.DeviceSN, "batch_id", batch.ID, "files", len(files))
+	recordServerEvent("transfer/pull-batch", req.DeviceSN, gin.H{"batchId": batch.ID, "files": len(files)})
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"batchId": batch.ID,
+		"files":   files,
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPullBatchCountsUploadsAndRevokesTokensOnFailure(t *testing.T) {
+	setupFileHandlersTestDataDir(t)
+	resetTransferTokensForTest()
+	t.Cleanup(resetTransferTokensForTest)
+
+	deviceConn, device := newTestWebSocketPair(t)
+	mu.Lock()
+	deviceLinks["d1"] = deviceConn
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(deviceLinks, "d1")
+		mu.Unlock()
+	})
+
+	payload := map[string]any{
+		"deviceSN":      "d1",
+		"serverBaseUrl": "http://127.0.0.1:46980",
+		"files": []map[string]any{
+			{"sourcePath": "/var/mobile/a.txt", "category": "reports", "path": "d1/a.txt"},
+			{"sourcePath": "/var/mobile/b.txt", "category": "reports", "path": "d1/b.txt"},
+			{"sourcePath": "/var/mobile/c.txt", "category": "reports", "path": "d1/c.txt"},
+		},
+	}
+	w := performJSONHandlerRequest(t, http.MethodPost, "/api/transfer/pull-batch", payload, pullBatchFromDeviceHandler)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		BatchID string           `json:"batchId"`
+		Files   []pullBatchEntry `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.BatchID == "" || len(resp.Files) != 3 {
+		t.Fatalf("unexpected response %s: %v", w.Body.String(), err)
+	}
+	for range resp.Files {
+		msg := readTestMessage(t, device)
+		body, _ := msg.Body.(map[string]interface{})
+		if msg.Type != "transfer/send" || body["batchId"] != resp.BatchID {
+			t.Fatalf("expected transfer/send tagged with the batch, got %+v", msg)
+		}
+	}
+
+	code, _ := performTransferUpload(t, resp.Files[0].Token, []byte("a"), "")
+	if code != http.StatusOK {
+		t.Fatalf("upload failed with %d", code)
+	}
+	pullBatches.Lock()
+	batch := pullBatches.entries[resp.BatchID]
+	done := batch.done
+	pullBatches.Unlock()
+	if done != 1 {
+		t.Fatalf("expected 1 file pulled, got %d", done)
+	}
+
+	handlePullBatchFailedMessage("d1", map[string]interface{}{"batchId": resp.BatchID, "error": "disk full"})
+	pullBatches.Lock()
+	_, open := pullBatches.entries[resp.BatchID]
+	pullBatches.Unlock()
+	if open || batch.failed != 2 {
+		t.Fatalf("expected the batch to finish with 2 failures, open=%v failed=%d", open, batch.failed)
+	}
+	transferTokensMu.RLock()
+	remaining := len(transferTokens)
+	transferTokensMu.RUnlock()
+	if remaining != 0 {
+		t.Fatalf("expected unused tokens to be revoked, %d left", remaining)
+	}
+}
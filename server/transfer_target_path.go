@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// resolveDeviceTargetPath applies deviceBasePath to a device-side target path.
+// Absolute paths pass through unchanged; relative paths are joined onto the base
+// and may not climb out of it. With no base configured the path is sent as-is.
+func resolveDeviceTargetPath(targetPath string) (string, error) {
+	targetPath = strings.TrimSpace(targetPath)
+	base := strings.TrimSpace(getServerConfig().DeviceBasePath)
+	if targetPath == "" || base == "" || strings.HasPrefix(targetPath, "/") {
+		return targetPath, nil
+	}
+
+	rel := path.Clean(strings.ReplaceAll(targetPath, "\\", "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("targetPath escapes deviceBasePath")
+	}
+	resolved := path.Join(base, rel)
+	if strings.HasSuffix(targetPath, "/") && !strings.HasSuffix(resolved, "/") {
+		resolved += "/"
+	}
+	return resolved, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transferTokenFlushInterval is how often active download tokens are written to disk
+// when persistTransferTokens is enabled.
+const transferTokenFlushInterval = 5 * time.Second
+
+// persistedTransferToken is one download token in the on-disk token file.
+type persistedTransferToken struct {
+	FilePath       string `json:"filePath"`
+	TargetPath     string `json:"targetPath"`
+	DeviceSN       string `json:"deviceSN,omitempty"`
+	ExpiresAt      int64  `json:"expiresAt"`
+	OneTime        bool   `json:"oneTime"`
+	TotalBytes     int64  `json:"totalBytes"`
+	MD5            string `json:"md5"`
+	Category       string `json:"category,omitempty"`
+	ChunkSize      int    `json:"chunkSize,omitempty"`
+	SharedSourceID string `json:"sharedSourceId,omitempty"`
+}
+
+var (
+	transferTokenStoreMu    sync.Mutex // serializes token file writes
+	transferTokenSavedData  []byte
+	transferTokenStoreStop  chan struct{}
+	transferTokenStoreDone  chan struct{}
+	transferTokenStoreStart sync.Once
+)
+
+// getTransferTokensFilePath returns the path to the persisted transfer tokens file
+func getTransferTokensFilePath() string {
+	return filepath.Join(getServerConfig().DataDir, "transfer-tokens.json")
+}
+
+// snapshotDownloadTokens returns the unexpired download tokens. Upload tokens are not
+// persisted: devices cannot resume a half-finished upload across a restart anyway.
+func snapshotDownloadTokens(now time.Time) map[string]persistedTransferToken {
+	transferTokensMu.RLock()
+	defer transferTokensMu.RUnlock()
+	tokens := make(map[string]persistedTransferToken)
+	for token, tt := range transferTokens {
+		if tt.Type != "download" || !now.Before(tt.ExpiresAt) {
+			continue
+		}
+		tokens[token] = persistedTransferToken{
+			FilePath:       tt.FilePath,
+			TargetPath:     tt.TargetPath,
+			DeviceSN:       tt.DeviceSN,
+			ExpiresAt:      tt.ExpiresAt.Unix(),
+			OneTime:        tt.OneTime,
+			TotalBytes:     tt.TotalBytes,
+			MD5:            tt.MD5,
+			Category:       tt.Category,
+			ChunkSize:      tt.ChunkSize,
+			SharedSourceID: tt.SharedSourceID,
+		}
+	}
+	return tokens
+}
+
+// flushTransferTokens writes active download tokens to disk. Nothing is written when the
+// feature is disabled or the tokens have not changed since the last successful write.
+func flushTransferTokens() error {
+	if !getServerConfig().PersistTransferTokens {
+		return nil
+	}
+
+	transferTokenStoreMu.Lock()
+	defer transferTokenStoreMu.Unlock()
+
+	data, err := json.MarshalIndent(snapshotDownloadTokens(time.Now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	if transferTokenSavedData != nil && bytes.Equal(data, transferTokenSavedData) {
+		return nil
+	}
+	if err := writeFileAtomic(getTransferTokensFilePath(), data, 0644); err != nil {
+		return err
+	}
+	transferTokenSavedData = data
+	return nil
+}
+
+// readPersistedTransferTokens reads the token file, dropping expired tokens and tokens
+// whose source file no longer exists.
+func readPersistedTransferTokens(now time.Time) (map[string]persistedTransferToken, error) {
+	data, err := os.ReadFile(getTransferTokensFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var tokens map[string]persistedTransferToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse transfer tokens file: %v", err)
+	}
+	for token, pt := range tokens {
+		if token == "" || pt.FilePath == "" || !now.Before(time.Unix(pt.ExpiresAt, 0)) {
+			delete(tokens, token)
+			continue
+		}
+		if _, err := os.Stat(pt.FilePath); err != nil {
+			delete(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// loadTransferTokens restores persisted download tokens. It must run before
+// initDataDirectories so the temp files they reference survive the startup cleanup.
+func loadTransferTokens() (int, error) {
+	if !getServerConfig().PersistTransferTokens {
+		return 0, nil
+	}
+	tokens, err := readPersistedTransferTokens(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for token, pt := range tokens {
+		transferTokensMu.Lock()
+		_, exists := transferTokens[token]
+		if !exists {
+			transferTokens[token] = &TransferToken{
+				Type:           "download",
+				FilePath:       pt.FilePath,
+				TargetPath:     pt.TargetPath,
+				DeviceSN:       pt.DeviceSN,
+				ExpiresAt:      time.Unix(pt.ExpiresAt, 0),
+				OneTime:        pt.OneTime,
+				TotalBytes:     pt.TotalBytes,
+				MD5:            pt.MD5,
+				Category:       pt.Category,
+				ChunkSize:      pt.ChunkSize,
+				SharedSourceID: pt.SharedSourceID,
+			}
+		}
+		transferTokensMu.Unlock()
+		if exists {
+			continue
+		}
+		registerSharedTempRef(pt.SharedSourceID, pt.FilePath)
+		restored++
+	}
+	return restored, nil
+}
+
+// persistedTempFilePaths returns the temp files referenced by restored download tokens.
+func persistedTempFilePaths() map[string]bool {
+	transferTokensMu.RLock()
+	defer transferTokensMu.RUnlock()
+	paths := make(map[string]bool)
+	for _, tt := range transferTokens {
+		if !isTempFilePath(tt.FilePath) {
+			continue
+		}
+		if abs, err := filepath.Abs(tt.FilePath); err == nil {
+			paths[abs] = true
+		}
+	}
+	return paths
+}
+
+// cleanTempTransferDir empties tempDir, keeping top-level entries that contain a file
+// still referenced by a restored transfer token.
+func cleanTempTransferDir(tempDir string, keep map[string]bool) error {
+	if len(keep) == 0 {
+		if err := os.RemoveAll(tempDir); err != nil {
+			return err
+		}
+		return os.MkdirAll(tempDir, 0755)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.MkdirAll(tempDir, 0755)
+		}
+		return err
+	}
+	absDir, err := filepath.Abs(tempDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(absDir, entry.Name())
+		referenced := false
+		for path := range keep {
+			if path == entryPath || strings.HasPrefix(path, entryPath+string(filepath.Separator)) {
+				referenced = true
+				break
+			}
+		}
+		if referenced {
+			continue
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startTransferTokenStoreTimer flushes download tokens every transferTokenFlushInterval.
+func startTransferTokenStoreTimer() {
+	if !getServerConfig().PersistTransferTokens {
+		return
+	}
+	transferTokenStoreStart.Do(func() {
+		transferTokenStoreStop = make(chan struct{})
+		transferTokenStoreDone = make(chan struct{})
+		ticker := time.NewTicker(transferTokenFlushInterval)
+		go func() {
+			defer close(transferTokenStoreDone)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := flushTransferTokens(); err != nil {
+						slog.Warn("Failed to save transfer tokens", "error", err)
+					}
+				case <-transferTokenStoreStop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// stopTransferTokenStoreTimer stops the periodic flush and writes the tokens one last time.
+func stopTransferTokenStoreTimer() {
+	if transferTokenStoreStop != nil {
+		select {
+		case <-transferTokenStoreStop:
+		default:
+			close(transferTokenStoreStop)
+		}
+		<-transferTokenStoreDone
+	}
+	if err := flushTransferTokens(); err != nil {
+		slog.Warn("Failed to save transfer tokens", "error", err)
+	}
+}
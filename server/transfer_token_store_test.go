@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransferTokenStore_RoundTripPrunesAndKeepsTempFiles(t *testing.T) {
+	dataDir := setupPersistenceWritableDataDir(t)
+	prevPersist := getServerConfig().PersistTransferTokens
+	updateServerConfig(func(cfg *ServerConfig) { cfg.PersistTransferTokens = true })
+	transferTokensMu.Lock()
+	tokensBackup := transferTokens
+	transferTokens = make(map[string]*TransferToken)
+	transferTokensMu.Unlock()
+	sharedTempRefs.Lock()
+	refsBackup := sharedTempRefs.entries
+	sharedTempRefs.entries = make(map[string]*sharedTempRef)
+	sharedTempRefs.Unlock()
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.PersistTransferTokens = prevPersist })
+		transferTokensMu.Lock()
+		transferTokens = tokensBackup
+		transferTokensMu.Unlock()
+		sharedTempRefs.Lock()
+		sharedTempRefs.entries = refsBackup
+		sharedTempRefs.Unlock()
+	})
+
+	tempDir := filepath.Join(dataDir, "files", "_temp")
+	kept := filepath.Join(tempDir, "batch", "pkg.zip")
+	stray := filepath.Join(tempDir, "stray.txt")
+	for _, p := range []string{kept, stray} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	transferTokensMu.Lock()
+	transferTokens["live"] = &TransferToken{Type: "download", FilePath: kept, TargetPath: "/var/mobile/pkg.zip", ExpiresAt: now.Add(time.Hour), OneTime: true, TotalBytes: 4, MD5: "abc", SharedSourceID: "s1"}
+	transferTokens["missing"] = &TransferToken{Type: "download", FilePath: filepath.Join(tempDir, "gone.zip"), ExpiresAt: now.Add(time.Hour)}
+	transferTokens["upload"] = &TransferToken{Type: "upload", FilePath: stray, ExpiresAt: now.Add(time.Hour)}
+	transferTokensMu.Unlock()
+	if err := flushTransferTokens(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	transferTokensMu.Lock()
+	transferTokens = make(map[string]*TransferToken)
+	transferTokensMu.Unlock()
+	restored, err := loadTransferTokens()
+	if err != nil || restored != 1 {
+		t.Fatalf("expected 1 restored token, got %d (%v)", restored, err)
+	}
+	transferTokensMu.RLock()
+	tt := transferTokens["live"]
+	transferTokensMu.RUnlock()
+	if tt == nil || tt.MD5 != "abc" || tt.TargetPath != "/var/mobile/pkg.zip" || tt.ExpiresAt.Unix() != now.Add(time.Hour).Unix() {
+		t.Fatalf("unexpected restored token: %+v", tt)
+	}
+	sharedTempRefs.Lock()
+	ref := sharedTempRefs.entries["s1"]
+	sharedTempRefs.Unlock()
+	if ref == nil || ref.remaining != 1 {
+		t.Fatalf("expected shared temp ref to be rebuilt, got %+v", ref)
+	}
+
+	if err := cleanTempTransferDir(tempDir, persistedTempFilePaths()); err != nil {
+		t.Fatalf("clean failed: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("referenced temp file should survive cleanup: %v", err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Fatalf("unreferenced temp file should be removed, got %v", err)
+	}
+}
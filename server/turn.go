@@ -6,7 +6,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -100,7 +100,7 @@ func NewTURNServer(config TURNConfig) (*TURNServer, error) {
 			if publicIP == nil {
 				return nil, fmt.Errorf("TURN address %s has no IPv4 record (only IPv6), which is not supported", config.PublicAddr)
 			}
-			log.Printf("Resolved TURN address %s to IPv4 %s", config.PublicAddr, publicIP)
+			slog.Info("Resolved TURN address to IPv4", "addr", config.PublicAddr, "ip", publicIP.String())
 		}
 	} else {
 		return nil, fmt.Errorf("TURN public IP or address is required when TURN is enabled")
@@ -109,7 +109,7 @@ func NewTURNServer(config TURNConfig) (*TURNServer, error) {
 	// Generate secret key if not provided
 	if config.SecretKey == "" {
 		config.SecretKey = generateTURNSecret()
-		log.Println("Generated ephemeral TURN secret key (set turnSecretKey to persist)")
+		slog.Info("Generated ephemeral TURN secret key (set turnSecretKey to persist)")
 	}
 
 	if config.Port == 0 {
@@ -221,8 +221,8 @@ func (t *TURNServer) Start() error {
 	}
 
 	t.running = true
-	log.Printf("🔄 TURN server started on UDP/TCP port %d (Public: %s, Relay IP: %s, Relay ports: %d-%d)\n",
-		t.config.Port, t.publicAddr, t.publicIP.String(), t.config.RelayPortMin, t.config.RelayPortMax)
+	slog.Info("TURN server started", "port", t.config.Port, "public_addr", t.publicAddr, "relay_ip", t.publicIP.String(),
+		"relay_port_min", t.config.RelayPortMin, "relay_port_max", t.config.RelayPortMax)
 	return nil
 }
 
@@ -232,13 +232,13 @@ func (t *TURNServer) authHandler(username, realm string, srcAddr net.Addr) ([]by
 	// The timestamp is when the credential expires
 	expireTime, ok := parseTURNExpiry(username)
 	if !ok {
-		log.Printf("TURN auth failed: invalid username format: %s\n", username)
+		slog.Warn("TURN auth failed: invalid username format", "username", username)
 		return nil, false
 	}
 
 	// Check if credential is expired
 	if time.Now().Unix() > expireTime {
-		log.Printf("TURN auth failed: credential expired (username: %s)\n", username)
+		slog.Warn("TURN auth failed: credential expired", "username", username)
 		return nil, false
 	}
 
@@ -270,7 +270,7 @@ func (t *TURNServer) Stop() error {
 
 	t.running = false
 	t.server = nil
-	log.Println("🔄 TURN server stopped")
+	slog.Info("TURN server stopped")
 	return nil
 }
 
@@ -325,7 +325,7 @@ func GetTURNICEServers() []map[string]interface{} {
 	}
 
 	// Add custom ICE servers from config (skip invalid entries)
-	for _, custom := range serverConfig.CustomICEServers {
+	for _, custom := range getServerConfig().CustomICEServers {
 		// Skip entries with empty or nil URLs
 		if len(custom.URLs) == 0 {
 			continue
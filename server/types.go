@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -29,9 +30,17 @@ const (
 // Allowed directory categories for file management
 var AllowedCategories = []string{"scripts", "files", "reports"}
 
+// UpstreamConfig describes an upstream XXTCloudControl server to federate
+type UpstreamConfig struct {
+	Region   string `json:"region"`   // Prefix for the upstream's device IDs
+	URL      string `json:"url"`      // Upstream WebSocket URL, e.g. ws://10.0.0.2:46980/api/ws
+	Passhash string `json:"passhash"` // Upstream server passhash used to sign control messages
+}
+
 // ServerConfig represents the server configuration
 type ServerConfig struct {
 	Port          int    `json:"port"`
+	BindAddress   string `json:"bindAddress"` // IP to listen on (empty or 0.0.0.0 = all interfaces)
 	Passhash      string `json:"passhash"`
 	PingInterval  int    `json:"ping_interval"`
 	PingTimeout   int    `json:"ping_timeout"`
@@ -44,6 +53,16 @@ type ServerConfig struct {
 	TLSCertFile string `json:"tlsCertFile"` // Path to TLS certificate file
 	TLSKeyFile  string `json:"tlsKeyFile"`  // Path to TLS private key file
 
+	// With TLS active, also listen for plain HTTP on this port and redirect it to HTTPS
+	// (0 = disabled)
+	HTTPSRedirectPort int `json:"httpsRedirectPort"`
+
+	// Obtain and renew certificates for these domains from Let's Encrypt (takes precedence
+	// over tlsCertFile/tlsKeyFile). Port 80 on bindAddress must be reachable for the
+	// HTTP-01 challenge. Certificates are cached in acmeCacheDir (empty = <data_dir>/acme)
+	ACMEDomains  []string `json:"acmeDomains"`
+	ACMECacheDir string   `json:"acmeCacheDir"`
+
 	// TURN server configuration
 	TURNEnabled       bool   `json:"turnEnabled"`       // Enable embedded TURN server
 	TURNPort          int    `json:"turnPort"`          // TURN UDP port (default: 3478)
@@ -58,6 +77,189 @@ type ServerConfig struct {
 	// Custom ICE servers (external STUN/TURN services)
 	CustomICEServers []ICEServer `json:"customIceServers"` // External ICE servers to merge with local TURN
 
+	// Script packaging: skip unreadable files instead of aborting the whole send
+	ScriptSkipUnreadable bool `json:"scriptSkipUnreadable"`
+
+	// Script send-and-start: skip files already sent unchanged to the same device during
+	// its current connection (requests can pass forceResend to bypass)
+	ScriptSendDedup bool `json:"scriptSendDedup"`
+
+	// Script send: gzip small files for devices advertising "gzip" in system.features
+	ScriptGzipPayloads bool `json:"scriptGzipPayloads"`
+
+	// Destructive operations (folder delete, batch move, device reboot) affecting at least
+	// this many files/devices require a token from /api/confirm/impact (0 = disabled)
+	DestructiveConfirmThreshold int `json:"destructiveConfirmThreshold"`
+
+	// Devices whose reported clock differs from server time by more than this many seconds
+	// are flagged in the device list and reported via device/clock-skew
+	ClockSkewThresholdSeconds int `json:"clockSkewThresholdSeconds"`
+
+	// control/refresh requests from all controllers within this many milliseconds
+	// are merged into one fleet-wide app/state broadcast (0 = disabled)
+	RefreshCoalesceMs int `json:"refreshCoalesceMs"`
+
+	// Devices per control/devices/page message when a controller asks for a paged device
+	// list with {"paged": true} and no pageSize of its own (0 = 200)
+	DevicesPageSize int `json:"devicesPageSize"`
+
+	// Always-on log archive: devices registering with "autoSubscribe": ["log"] stream their
+	// logs to <logArchiveDir>/<udid>.log even with no controller subscribed (empty = disabled)
+	LogArchiveDir string `json:"logArchiveDir"`
+
+	// Devices with log subscribers that send no system/log/push for this many seconds get
+	// system/log/subscribe re-sent, backing off up to 30 minutes while silent (0 = disabled)
+	LogStaleSeconds int `json:"logStaleSeconds"`
+
+	// Binary relay limits: frames with more chunks or larger chunks are dropped (0 = unlimited)
+	BinaryMaxChunkCount int `json:"binaryMaxChunkCount"`
+	BinaryMaxChunkBytes int `json:"binaryMaxChunkBytes"`
+
+	// Device base path that relative transfer targetPaths are joined onto
+	// (absolute paths pass through; empty = send targetPath as-is)
+	DeviceBasePath string `json:"deviceBasePath"`
+
+	// Max recently verified signatures kept to skip recomputing HMAC for repeated
+	// signed messages; entries expire with the timestamp window (0 = disabled)
+	SignatureCacheSize int `json:"signatureCacheSize"`
+
+	// Root path "/" issues a 302 to this frontend route instead of serving index.html
+	// (must be a local path starting with "/"; empty = serve the SPA index)
+	LandingRedirect string `json:"landingRedirect"`
+
+	// Serve the frontend embedded in the binary even when frontend_dir exists (the
+	// embedded copy is always used when frontend_dir is missing)
+	PreferEmbeddedFrontend bool `json:"preferEmbeddedFrontend"`
+
+	// Device table snapshot: written every deviceStateFlushSeconds and restored at startup
+	// with entries marked stale until the device reconnects (empty = disabled)
+	DeviceStateFile         string `json:"deviceStateFile"`
+	DeviceStateFlushSeconds int    `json:"deviceStateFlushSeconds"`
+
+	// Read-only device table export for external tools (CMDB): checked every
+	// deviceExportSeconds and written to the file and/or POSTed to the URL on change
+	DeviceExportFile    string `json:"deviceExportFile"`
+	DeviceExportURL     string `json:"deviceExportUrl"`
+	DeviceExportSeconds int    `json:"deviceExportSeconds"`
+
+	// On SIGINT/SIGTERM, seconds to wait for in-flight requests and transfers before
+	// closing remaining connections
+	ShutdownGraceSeconds int `json:"shutdownGraceSeconds"`
+
+	// Transfer chunk size in bytes for transfer/fetch streaming, clamped to 4KiB-4MiB;
+	// deviceTransferChunkSizes overrides it per device UDID (0 = 32KiB default)
+	TransferChunkSize        int            `json:"transferChunkSize"`
+	DeviceTransferChunkSizes map[string]int `json:"deviceTransferChunkSizes"`
+
+	// API routes answered with 404, matched against the request path or route pattern
+	// (e.g. "/api/server-files/open-local"); a trailing "*" matches a prefix ("/api/update/*")
+	DisabledEndpoints []string `json:"disabledEndpoints"`
+
+	// Unknown device message types (the default forward branch): types listed in
+	// forwardDenyTypes are dropped; a non-empty forwardAllowTypes forwards only those types.
+	// A trailing "*" matches a prefix (e.g. "telemetry/*")
+	ForwardDenyTypes  []string `json:"forwardDenyTypes"`
+	ForwardAllowTypes []string `json:"forwardAllowTypes"`
+
+	// HMAC key used to derive passhash from the password (empty = "XXTouch").
+	// Changing it invalidates existing passhashes: reset the password afterwards. The bundled
+	// web UI always derives with "XXTouch", so its password login stops working when set.
+	SigningSecret string `json:"signingSecret,omitempty"`
+
+	// Upstream servers this server connects to as a controller; their devices appear
+	// locally as "<region>/<udid>" and commands for them are relayed upstream
+	Upstreams []UpstreamConfig `json:"upstreams"`
+
+	// Allowed distance in seconds between a signed ts and server time (HTTP and WS).
+	// Nonces are kept for at least twice this window
+	SignatureSkewSeconds int `json:"signatureSkewSeconds"`
+
+	// Per-connection text message rate limits (messages/second and burst, 0 = unlimited).
+	// Controllers use the control limits; devices and unidentified connections the device limits
+	ControlRateLimit float64 `json:"controlRateLimit"`
+	ControlRateBurst int     `json:"controlRateBurst"`
+	DeviceRateLimit  float64 `json:"deviceRateLimit"`
+	DeviceRateBurst  int     `json:"deviceRateBurst"`
+
+	// Log output: logFormat "text" (console, default) or "json"; logLevel debug/info/warn/error
+	LogFormat string `json:"logFormat"`
+	LogLevel  string `json:"logLevel"`
+
+	// Prometheus metrics at /metrics (unauthenticated), served only on the separate
+	// metricsAddr listener (empty = "127.0.0.1:9464"), never on the main port
+	MetricsEnabled bool   `json:"metricsEnabled"`
+	MetricsAddr    string `json:"metricsAddr"`
+
+	// Keep active download tokens in data_dir/transfer-tokens.json so devices can finish
+	// pending downloads after a restart (expired tokens are pruned on load)
+	PersistTransferTokens bool `json:"persistTransferTokens"`
+
+	// Encrypt persisted device data (device state snapshot, device labels, log archive) with
+	// AES-GCM using the key from XXTCC_DEVICE_DATA_KEY; plaintext files are still readable
+	EncryptDeviceData bool `json:"encryptDeviceData"`
+
+	// Pending async writes per device connection; a device whose queue fills up is
+	// disconnected so it cannot stall fan-out to other devices (0 = 512)
+	DeviceWriteQueueDepth int `json:"deviceWriteQueueDepth"`
+
+	// A disconnected device keeps its last state and subscriptions for this many seconds,
+	// and device/disconnect is only sent if it has not reconnected by then (0 = immediate)
+	DisconnectGraceSeconds int `json:"disconnectGraceSeconds"`
+
+	// Negotiate permessage-deflate with clients that offer it; messages smaller than
+	// webSocketCompressionMinBytes are still sent uncompressed (0 = 1024)
+	WebSocketCompression         bool `json:"webSocketCompression"`
+	WebSocketCompressionMinBytes int  `json:"webSocketCompressionMinBytes"`
+
+	// Gzip text, JSON, JavaScript and CSS HTTP responses for clients sending
+	// Accept-Encoding: gzip; smaller bodies are sent as is (0 = 1024)
+	HTTPCompression         bool `json:"httpCompression"`
+	HTTPCompressionMinBytes int  `json:"httpCompressionMinBytes"`
+
+	// Largest incoming WebSocket message; a connection sending more is closed. Must fit a
+	// base64 file/put of a file just under largeFileThresholdBytes (0 = 8MiB)
+	MaxWebSocketMessageBytes int64 `json:"maxWebSocketMessageBytes"`
+
+	// WebSocket connections older than this are closed with a reconnect hint the next time
+	// they send a message, forcing a fresh handshake (0 = disabled)
+	MaxConnAgeSeconds int `json:"maxConnAgeSeconds"`
+
+	// Devices beyond this many connected (or within their offline grace period) are
+	// rejected when they first register; controllers do not count (0 = unlimited)
+	MaxDevices int `json:"maxDevices"`
+
+	// Screen frame fan-out: max frames per second forwarded per device (0 = unlimited)
+	ScreenFrameMaxFPS int `json:"screenFrameMaxFps"`
+
+	// Latest screen/frame image kept per device for GET /api/devices/:udid/screenshot:
+	// at most this many devices, least recently updated evicted first (0 = disabled)
+	ScreenshotCacheSize int `json:"screenshotCacheSize"`
+	// Cached screenshots older than this many seconds are not served (0 = no limit)
+	ScreenshotMaxAgeSeconds int `json:"screenshotMaxAgeSeconds"`
+
+	// Serve /api/local-admin/* (reload-config, kick, update-check, metrics) without
+	// signature auth to loopback clients that send "X-Local-Admin: 1" and no proxy or
+	// Origin headers
+	LocalAdminEnabled bool `json:"localAdminEnabled"`
+
+	// Commands and device replies kept per device in <dataDir>/command_history for
+	// GET /api/devices/command-history (0 = disabled)
+	CommandHistoryLimit int `json:"commandHistoryLimit"`
+
+	// Script files and pushed files smaller than this are sent inline as base64 in
+	// file/put; larger ones use transfer/fetch with a temporary token (0 = 131072)
+	LargeFileThresholdBytes int64 `json:"largeFileThresholdBytes"`
+
+	// Max total uncompressed bytes extracted by /api/server-files/upload-zip (0 = unlimited)
+	UploadZipMaxBytes int64 `json:"uploadZipMaxBytes"`
+
+	// Append control commands and script sends to <dataDir>/audit/audit.jsonl for GET /api/audit
+	AuditLogEnabled bool `json:"auditLogEnabled"`
+
+	// URLs POSTed a signed JSON event when a device connects, disconnects or runs out of
+	// life; the X-XXTCC-Signature header is "sha256=" + hex HMAC-SHA256 of the body keyed by passhash
+	Webhooks []string `json:"webhooks"`
+
 	// Self-update configuration
 	Update UpdateConfig `json:"update"`
 }
@@ -65,7 +267,7 @@ type ServerConfig struct {
 // UpdateConfig represents self-update behavior and source settings.
 type UpdateConfig struct {
 	Enabled            bool               `json:"enabled"`
-	Channel            string             `json:"channel"`
+	Channel            string             `json:"channel"` // "stable" or "beta" (pre-releases)
 	CheckIntervalHours int                `json:"checkIntervalHours"`
 	PromptOnNewVersion bool               `json:"promptOnNewVersion"`
 	IgnoredVersions    []string           `json:"ignoredVersions"`
@@ -84,6 +286,7 @@ type UpdateSourceConfig struct {
 // DefaultConfig returns the default server configuration
 var DefaultConfig = ServerConfig{
 	Port:          46980,
+	BindAddress:   "0.0.0.0",
 	Passhash:      "",
 	PingInterval:  15,
 	PingTimeout:   10,
@@ -91,6 +294,35 @@ var DefaultConfig = ServerConfig{
 	FrontendDir:   "./frontend",
 	DataDir:       "./data",
 
+	SignatureSkewSeconds:      60,
+	ControlRateLimit:          50,
+	ControlRateBurst:          200,
+	DeviceRateLimit:           200,
+	DeviceRateBurst:           1000,
+	LogFormat:                 "text",
+	LogLevel:                  "info",
+	MetricsEnabled:            true,
+	MetricsAddr:               defaultMetricsAddr,
+	ScriptGzipPayloads:        true,
+	HTTPCompression:           true,
+	ScreenFrameMaxFPS:         10,
+	ScreenshotCacheSize:       64,
+	ScreenshotMaxAgeSeconds:   300,
+	DeviceWriteQueueDepth:     512,
+	UploadZipMaxBytes:         512 * 1024 * 1024,
+	LargeFileThresholdBytes:   128 * 1024,
+	ClockSkewThresholdSeconds: 30,
+	RefreshCoalesceMs:         250,
+	DevicesPageSize:           200,
+	CommandHistoryLimit:       1000,
+	AuditLogEnabled:           true,
+	LogStaleSeconds:           120,
+	BinaryMaxChunkCount:       65536,
+	BinaryMaxChunkBytes:       1024 * 1024,
+	DeviceStateFlushSeconds:   30,
+	DeviceExportSeconds:       10,
+	ShutdownGraceSeconds:      30,
+
 	// TURN defaults (user only needs to fill TURNPublicIP to enable)
 	TURNEnabled:      true,
 	TURNPort:         43478,
@@ -112,22 +344,68 @@ var DefaultConfig = ServerConfig{
 	},
 }
 
-// Global configuration
-var serverConfig ServerConfig
+// serverConfigValue holds the running configuration. Readers go through
+// getServerConfig and treat the result as read-only; writers publish a whole new
+// value, serialized by serverConfigPatchMu once the server is running.
+var serverConfigValue atomic.Pointer[ServerConfig]
+
+func init() {
+	serverConfigValue.Store(&ServerConfig{})
+}
+
+// getServerConfig returns the running configuration. The result must not be modified.
+func getServerConfig() *ServerConfig {
+	return serverConfigValue.Load()
+}
+
+// setServerConfig publishes cfg as the running configuration.
+func setServerConfig(cfg ServerConfig) {
+	serverConfigValue.Store(&cfg)
+}
+
+// updateServerConfig publishes a copy of the running configuration changed by mutate.
+// Concurrent writers must be serialized by the caller.
+func updateServerConfig(mutate func(cfg *ServerConfig)) {
+	cfg := *getServerConfig()
+	mutate(&cfg)
+	setServerConfig(cfg)
+}
 
-// Passhash for signature validation
-var passhash []byte
+// Passhash for signature validation. Guarded by passhashMu once the server is running,
+// since a SIGHUP reload can replace it.
+var (
+	passhash   []byte
+	passhashMu sync.RWMutex
+)
+
+// connRole is the role a client declares by connecting to /api/ws/device or
+// /api/ws/control. Connections on /api/ws leave it empty and are classified by the
+// messages they send.
+type connRole string
+
+const (
+	connRoleDevice  connRole = "device"
+	connRoleControl connRole = "control"
+)
 
 // SafeConn is a thread-safe WebSocket connection wrapper
 type SafeConn struct {
 	conn *websocket.Conn
 	mu   sync.Mutex
+	// role is set before the read loop starts and never changes.
+	role connRole
+
+	rateLimiter connRateLimiter
+	// writeQueue is set for device connections; async writes go through it.
+	writeQueue atomic.Pointer[deviceWriteQueue]
 }
 
 // WriteMessage writes a message to the WebSocket connection (thread-safe)
 func (sc *SafeConn) WriteMessage(messageType int, data []byte) error {
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
+	// Only takes effect when permessage-deflate was negotiated at upgrade.
+	sc.conn.EnableWriteCompression(shouldCompressWebSocketMessage(len(data)))
 	return sc.conn.WriteMessage(messageType, data)
 }
 
@@ -160,10 +438,15 @@ type Message struct {
 
 // ControlCommand represents a single control command
 type ControlCommand struct {
-	Devices   []string    `json:"devices"`
-	Type      string      `json:"type"`
-	Body      interface{} `json:"body,omitempty"`
-	RequestID string      `json:"requestId,omitempty"`
+	Devices      []string    `json:"devices"`
+	View         string      `json:"view,omitempty"`   // Saved view ID resolved to devices at command time
+	Groups       []string    `json:"groups,omitempty"` // Group IDs whose members are targeted ("__all__" = every connected device)
+	Tags         []string    `json:"tags,omitempty"`   // Group names whose members are targeted ("__all__" = every connected device)
+	Type         string      `json:"type"`
+	Body         interface{} `json:"body,omitempty"`
+	RequestID    string      `json:"requestId,omitempty"`
+	ConfirmToken string      `json:"confirmToken,omitempty"` // Required for destructive commands above the confirm threshold
+	Timeout      int         `json:"timeout,omitempty"`      // Seconds to wait for device replies before control/command/timeout (default 10)
 }
 
 // LogSubscribeRequest represents log subscription control for devices
@@ -173,19 +456,28 @@ type LogSubscribeRequest struct {
 
 // ControlCommands represents multiple control commands
 type ControlCommands struct {
-	Devices  []string  `json:"devices"`
-	Commands []Command `json:"commands"`
+	Devices      []string  `json:"devices"`
+	View         string    `json:"view,omitempty"`   // Saved view ID resolved to devices at command time
+	Groups       []string  `json:"groups,omitempty"` // Group IDs whose members are targeted ("__all__" = every connected device)
+	Tags         []string  `json:"tags,omitempty"`   // Group names whose members are targeted ("__all__" = every connected device)
+	Commands     []Command `json:"commands"`
+	ConfirmToken string    `json:"confirmToken,omitempty"` // Required for destructive commands above the confirm threshold
+	BatchID      string    `json:"batchId,omitempty"`      // Aggregate replies to commands with a requestId into one commands/result
+	Timeout      int       `json:"timeout,omitempty"`      // Seconds to wait for batch replies before reporting timeouts (default 10)
 }
 
 // Command represents a single command in ControlCommands
 type Command struct {
-	Type string      `json:"type"`
-	Body interface{} `json:"body,omitempty"`
+	Type      string      `json:"type"`
+	Body      interface{} `json:"body,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
 }
 
 // HTTPProxyRequest represents an HTTP proxy request to be forwarded to a device
 type HTTPProxyRequest struct {
 	Devices   []string               `json:"devices"`
+	Groups    []string               `json:"groups,omitempty"`
+	Tags      []string               `json:"tags,omitempty"`
 	RequestID string                 `json:"requestId"`
 	Method    string                 `json:"method"`
 	Path      string                 `json:"path"`
@@ -232,6 +524,40 @@ type GroupInfo struct {
 	ScriptPath string   `json:"scriptPath,omitempty"`
 }
 
+// SavedView represents a named device selection (purely a selection convenience,
+// unlike groups it does not participate in script config resolution)
+type SavedView struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	DeviceIDs []string `json:"deviceIds"`
+	GroupIDs  []string `json:"groupIds,omitempty"` // Optional selector: members of these groups are included
+}
+
+// ScheduledCommand is a one-shot command fired at a specific time and then removed
+type ScheduledCommand struct {
+	ID        string      `json:"id"`
+	At        int64       `json:"at"` // Unix seconds
+	Devices   []string    `json:"devices"`
+	Type      string      `json:"type"`
+	Body      interface{} `json:"body,omitempty"`
+	CreatedAt int64       `json:"createdAt"`
+}
+
+// MacroStep is one command of a macro, sent DelayMs after the previous step
+type MacroStep struct {
+	Type    string      `json:"type"`
+	Body    interface{} `json:"body,omitempty"`
+	DelayMs int         `json:"delayMs"`
+}
+
+// Macro is a named command sequence orchestrated by the server (unlike scripts,
+// which run on the device)
+type Macro struct {
+	Name      string      `json:"name"`
+	Steps     []MacroStep `json:"steps"`
+	UpdatedAt int64       `json:"updatedAt"`
+}
+
 // ICEServer represents an ICE server configuration for WebRTC
 type ICEServer struct {
 	URLs       FlexibleURLs `json:"urls"`                 // Server URLs (stun: or turn:), can be string or []string
@@ -276,13 +602,15 @@ type AppSettings struct {
 // Global state variables
 var (
 	// Device management
-	deviceTable      = make(map[string]interface{})
-	deviceLinks      = make(map[string]*SafeConn)
-	deviceLinksMap   = make(map[*SafeConn]string)
-	controllers      = make(map[*SafeConn]bool)
-	deviceLife       = make(map[string]int)
-	logSubscriptions = make(map[string]map[*SafeConn]bool)
-	binaryRoutes     = make(map[string]*BinaryRoute)
+	deviceTable         = make(map[string]interface{})
+	deviceLinks         = make(map[string]*SafeConn)
+	deviceLinksMap      = make(map[*SafeConn]string)
+	controllers         = make(map[*SafeConn]bool)
+	deviceLife          = make(map[string]int)
+	logSubscriptions    = make(map[string]map[*SafeConn]bool)
+	screenSubscriptions = make(map[string]map[*SafeConn]bool)
+	eventSubscriptions  = make(map[string]map[*SafeConn]bool) // transfer/progress and device/message filters
+	binaryRoutes        = make(map[string]*BinaryRoute)
 
 	// Mutex for device state
 	mu sync.RWMutex
@@ -297,6 +625,19 @@ var (
 	deviceGroups   = make([]GroupInfo, 0)
 	deviceGroupsMu sync.RWMutex
 
+	// Saved device views
+	savedViews   = make([]SavedView, 0)
+	savedViewsMu sync.RWMutex
+
+	// One-shot scheduled commands
+	scheduledCommands      = make([]ScheduledCommand, 0)
+	scheduledCommandTimers = make(map[string]*time.Timer)
+	scheduledCommandsMu    sync.Mutex
+
+	// Command macros
+	macros   = make([]Macro, 0)
+	macrosMu sync.RWMutex
+
 	// Group script configs: map[groupID]map[scriptPath]config
 	groupScriptConfigs   = make(map[string]map[string]map[string]interface{})
 	groupScriptConfigsMu sync.RWMutex
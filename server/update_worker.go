@@ -25,6 +25,9 @@ type updateWorkerJob struct {
 	WorkingDir        string   `json:"workingDir"`
 	RestartArgs       []string `json:"restartArgs"`
 	TargetVersion     string   `json:"targetVersion"`
+	PreviousVersion   string   `json:"previousVersion,omitempty"`
+	// Rollback marks a job that restores the backup kept by the last apply.
+	Rollback bool `json:"rollback,omitempty"`
 }
 
 func runUpdateWorker(jobPath string) error {
@@ -66,6 +69,14 @@ func runUpdateWorker(jobPath string) error {
 		return replaceErr
 	}
 
+	if job.Rollback {
+		// The restored binary may predate rollback support, so record the final
+		// state before starting it instead of relying on its startup reconcile.
+		updateStateByWorker(job.StateFile, func(state *UpdaterState) {
+			markRolledBack(state, job)
+		})
+	}
+
 	if err := startTargetProcess(job); err != nil {
 		rollbackFromBackup(job)
 		updateStateByWorker(job.StateFile, func(state *UpdaterState) {
@@ -75,27 +86,29 @@ func runUpdateWorker(jobPath string) error {
 		return err
 	}
 
-	updateStateByWorker(job.StateFile, func(state *UpdaterState) {
-		state.Stage = updateStageIdle
-		state.LastError = ""
-		state.HasUpdate = false
-		state.Ignored = false
-		state.DownloadTotalBytes = 0
-		state.DownloadedBytes = 0
-		state.AppliedVersion = job.TargetVersion
-		state.DownloadedVersion = ""
-		state.DownloadedAsset = ""
-		state.DownloadedFile = ""
-		state.StagingDir = ""
-		state.SourceBinary = ""
-		state.SourceFrontendDir = ""
-	})
+	if !job.Rollback {
+		updateStateByWorker(job.StateFile, func(state *UpdaterState) {
+			state.Stage = updateStageIdle
+			state.LastError = ""
+			state.HasUpdate = false
+			state.Ignored = false
+			state.DownloadTotalBytes = 0
+			state.DownloadedBytes = 0
+			state.AppliedVersion = job.TargetVersion
+			state.RolledBackFrom = ""
+			state.DownloadedVersion = ""
+			state.DownloadedAsset = ""
+			state.DownloadedFile = ""
+			state.StagingDir = ""
+			state.SourceBinary = ""
+			state.SourceFrontendDir = ""
+		})
+	}
 
 	if strings.TrimSpace(job.DownloadedFile) != "" {
 		_ = os.Remove(job.DownloadedFile)
 	}
-	_ = os.Remove(job.BackupBinary)
-	_ = os.RemoveAll(job.BackupFrontendDir)
+	// The .bak binary and frontend are kept for POST /api/update/rollback.
 	if job.StagingDir != "" {
 		_ = os.RemoveAll(job.StagingDir)
 	}
@@ -9,13 +9,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,6 +35,11 @@ const (
 	updateStageFailed      = "failed"
 )
 
+const (
+	updateChannelStable = "stable"
+	updateChannelBeta   = "beta"
+)
+
 // UpdateAsset describes a single update artifact in update-manifest.json.
 type UpdateAsset struct {
 	OS          string `json:"os"`
@@ -53,11 +61,15 @@ type UpdateManifest struct {
 	ChecksumsURL       string        `json:"checksumsUrl"`
 	LatestChecksumsURL string        `json:"latestChecksumsUrl,omitempty"`
 	Assets             []UpdateAsset `json:"assets"`
+	// FrontendManifest optionally maps each file under the package's frontend
+	// directory (slash-separated relative path) to its sha256.
+	FrontendManifest map[string]string `json:"frontendManifest,omitempty"`
 }
 
 // UpdaterState is persisted in data/updater/state.json.
 type UpdaterState struct {
 	Stage              string      `json:"stage"`
+	Channel            string      `json:"channel,omitempty"`
 	LastError          string      `json:"lastError,omitempty"`
 	LastCheckedAt      int64       `json:"lastCheckedAt,omitempty"`
 	ManifestURL        string      `json:"manifestUrl,omitempty"`
@@ -75,6 +87,11 @@ type UpdaterState struct {
 	SourceBinary       string      `json:"sourceBinary,omitempty"`
 	SourceFrontendDir  string      `json:"sourceFrontendDir,omitempty"`
 	AppliedVersion     string      `json:"appliedVersion,omitempty"`
+	// RolledBackFrom is the version replaced by the last rollback; cleared by the next apply.
+	RolledBackFrom string `json:"rolledBackFrom,omitempty"`
+
+	// LatestFrontendManifest is kept for the download job and omitted from Status.
+	LatestFrontendManifest map[string]string `json:"latestFrontendManifest,omitempty"`
 }
 
 // UpdateStatusResponse is returned by updater APIs.
@@ -86,6 +103,8 @@ type UpdateStatusResponse struct {
 	PlatformArch   string       `json:"platformArch"`
 	Config         UpdateConfig `json:"config"`
 	State          UpdaterState `json:"state"`
+	// RollbackAvailable reports whether a .bak binary from the last apply exists.
+	RollbackAvailable bool `json:"rollbackAvailable"`
 }
 
 type UpdaterService struct {
@@ -143,39 +162,17 @@ func newUpdaterService() (*UpdaterService, error) {
 	if err != nil {
 		return nil, err
 	}
-	dataDir := serverConfig.DataDir
+	dataDir := getServerConfig().DataDir
 	if !filepath.IsAbs(dataDir) {
 		dataDir = filepath.Join(workingDir, dataDir)
 	}
-	frontendDir := serverConfig.FrontendDir
+	frontendDir := getServerConfig().FrontendDir
 	if !filepath.IsAbs(frontendDir) {
 		frontendDir = filepath.Join(workingDir, frontendDir)
 	}
 
-	connectTimeoutSeconds := serverConfig.Update.Source.DownloadConnectTimeoutSeconds
-	if connectTimeoutSeconds <= 0 {
-		connectTimeoutSeconds = 60
-	}
-	connectTimeout := time.Duration(connectTimeoutSeconds) * time.Second
-	transport := &http.Transport{
-		Proxy:             http.ProxyFromEnvironment,
-		ForceAttemptHTTP2: true,
-	}
-	if baseTransport, ok := http.DefaultTransport.(*http.Transport); ok && baseTransport != nil {
-		transport = baseTransport.Clone()
-	}
-	transport.DialContext = (&net.Dialer{
-		Timeout:   connectTimeout,
-		KeepAlive: 30 * time.Second,
-	}).DialContext
-	transport.TLSHandshakeTimeout = connectTimeout
-
-	httpClient := &http.Client{
-		Transport: transport,
-	}
-
 	service := &UpdaterService{
-		httpClient:  httpClient,
+		httpClient:  newUpdaterHTTPClient(getServerConfig().Update.Source.DownloadConnectTimeoutSeconds),
 		updaterDir:  filepath.Join(dataDir, "updater"),
 		cacheDir:    filepath.Join(dataDir, "updater", "cache"),
 		stagingRoot: filepath.Join(dataDir, "updater", "staging"),
@@ -207,11 +204,49 @@ func newUpdaterService() (*UpdaterService, error) {
 		keep.stagingDir = service.state.StagingDir
 	}
 	if err := cleanupUpdaterArtifacts(service.updaterDir, keep); err != nil {
-		log.Printf("updater cleanup warning: %v", err)
+		slog.Warn("Updater cleanup failed", "error", err)
 	}
 	return service, nil
 }
 
+// newUpdaterHTTPClient builds the client used for manifests and downloads;
+// connectTimeoutSeconds <= 0 means 60.
+func newUpdaterHTTPClient(connectTimeoutSeconds int) *http.Client {
+	if connectTimeoutSeconds <= 0 {
+		connectTimeoutSeconds = 60
+	}
+	connectTimeout := time.Duration(connectTimeoutSeconds) * time.Second
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		ForceAttemptHTTP2: true,
+	}
+	if baseTransport, ok := http.DefaultTransport.(*http.Transport); ok && baseTransport != nil {
+		transport = baseTransport.Clone()
+	}
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+// setHTTPClient swaps the client used by later requests; in-flight downloads keep theirs.
+func (u *UpdaterService) setHTTPClient(client *http.Client) {
+	u.mu.Lock()
+	u.httpClient = client
+	u.mu.Unlock()
+}
+
+func (u *UpdaterService) client() *http.Client {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.httpClient
+}
+
 func (u *UpdaterService) ensureDirs() error {
 	for _, dir := range []string{u.updaterDir, u.cacheDir, u.stagingRoot, u.workerDir} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -260,6 +295,7 @@ func (u *UpdaterService) reconcileStateOnStartup() {
 			u.state.DownloadTotalBytes = 0
 			u.state.DownloadedBytes = 0
 			u.state.AppliedVersion = Version
+			u.state.RolledBackFrom = ""
 			u.state.DownloadedVersion = ""
 			u.state.DownloadedAsset = ""
 			u.state.DownloadedFile = ""
@@ -381,25 +417,31 @@ func writeUpdaterStateFile(path string, state UpdaterState) error {
 func (u *UpdaterService) Status() UpdateStatusResponse {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
+	state := u.state
+	state.LatestFrontendManifest = nil
 	return UpdateStatusResponse{
 		CurrentVersion: Version,
 		BuildTime:      BuildTime,
 		Commit:         Commit,
 		PlatformOS:     runtime.GOOS,
 		PlatformArch:   runtime.GOARCH,
-		Config:         serverConfig.Update,
-		State:          u.state,
+		Config:         getServerConfig().Update,
+		State:          state,
+
+		RollbackAvailable: u.execPath != "" && exists(u.execPath+".bak"),
 	}
 }
 
 func (u *UpdaterService) Check(ctx context.Context) (UpdateStatusResponse, error) {
-	if !serverConfig.Update.Enabled {
+	if !getServerConfig().Update.Enabled {
 		return u.Status(), fmt.Errorf("update is disabled")
 	}
 
-	manifestURLs := resolveManifestURLs(serverConfig.Update.Source)
+	channel := normalizeUpdateChannel(getServerConfig().Update.Channel)
+	manifestURLs := resolveChannelManifestURLs(getServerConfig().Update.Source, channel)
 	u.mu.Lock()
 	u.state.Stage = updateStageChecking
+	u.state.Channel = channel
 	u.state.LastError = ""
 	u.state.DownloadTotalBytes = 0
 	u.state.DownloadedBytes = 0
@@ -423,8 +465,10 @@ func (u *UpdaterService) Check(ctx context.Context) (UpdateStatusResponse, error
 	}
 
 	cmp := compareVersionStrings(candidate.manifest.Version, Version)
-	ignored := isIgnoredVersion(serverConfig.Update.IgnoredVersions, candidate.manifest.Version)
-	hasUpdate := cmp > 0 && !ignored
+	ignored := isIgnoredVersion(getServerConfig().Update.IgnoredVersions, candidate.manifest.Version)
+	// After switching back to stable, the stable release is offered even if it is
+	// older, so beta testers can return to stable.
+	hasUpdate := (cmp > 0 || isReturnToStableVersion(candidate.manifest.Version, Version, channel)) && !ignored
 
 	u.mu.Lock()
 	u.state.LastCheckedAt = nowUnix
@@ -433,6 +477,7 @@ func (u *UpdaterService) Check(ctx context.Context) (UpdateStatusResponse, error
 	u.state.LatestVersion = candidate.manifest.Version
 	u.state.LatestPublishedAt = candidate.manifest.PublishedAt
 	u.state.LatestAsset = candidate.asset
+	u.state.LatestFrontendManifest = candidate.manifest.FrontendManifest
 	u.state.HasUpdate = hasUpdate
 	u.state.Ignored = ignored
 	if hasUpdate {
@@ -450,15 +495,16 @@ func (u *UpdaterService) Check(ctx context.Context) (UpdateStatusResponse, error
 }
 
 func (u *UpdaterService) Download() (UpdateStatusResponse, error) {
-	if !serverConfig.Update.Enabled {
+	if !getServerConfig().Update.Enabled {
 		return u.Status(), fmt.Errorf("update is disabled")
 	}
 
 	u.mu.RLock()
-	needCheck := u.state.LatestVersion == "" || u.state.LatestAsset.Name == ""
+	needCheck := u.state.LatestVersion == "" || u.state.LatestAsset.Name == "" ||
+		u.state.Channel != normalizeUpdateChannel(getServerConfig().Update.Channel)
 	u.mu.RUnlock()
 	if needCheck {
-		checkCtx, cancel := context.WithTimeout(context.Background(), getUpdateCheckTimeout(serverConfig.Update.Source))
+		checkCtx, cancel := context.WithTimeout(context.Background(), getUpdateCheckTimeout(getServerConfig().Update.Source))
 		_, err := u.Check(checkCtx)
 		cancel()
 		if err != nil {
@@ -477,6 +523,7 @@ func (u *UpdaterService) Download() (UpdateStatusResponse, error) {
 	}
 	asset := u.state.LatestAsset
 	version := u.state.LatestVersion
+	frontendManifest := u.state.LatestFrontendManifest
 	u.state.Stage = updateStageDownloading
 	u.state.LastError = ""
 	u.state.DownloadTotalBytes = 0
@@ -498,11 +545,11 @@ func (u *UpdaterService) Download() (UpdateStatusResponse, error) {
 	u.downloadCancel = cancel
 	u.mu.Unlock()
 
-	go u.runDownloadJob(jobID, downloadCtx, cancel, asset, version)
+	go u.runDownloadJob(jobID, downloadCtx, cancel, asset, version, frontendManifest)
 	return u.Status(), nil
 }
 
-func (u *UpdaterService) runDownloadJob(jobID uint64, ctx context.Context, cancel context.CancelFunc, asset UpdateAsset, version string) {
+func (u *UpdaterService) runDownloadJob(jobID uint64, ctx context.Context, cancel context.CancelFunc, asset UpdateAsset, version string, frontendManifest map[string]string) {
 	defer func() {
 		cancel()
 		u.mu.Lock()
@@ -547,6 +594,10 @@ func (u *UpdaterService) runDownloadJob(jobID uint64, ctx context.Context, cance
 		_, _ = u.markDownloadError("frontend directory not found in package")
 		return
 	}
+	if err := verifyFrontendManifest(sourceFrontend, frontendManifest); err != nil {
+		_, _ = u.markDownloadError(err.Error())
+		return
+	}
 
 	if runtime.GOOS == "darwin" {
 		if err := removeMacOSQuarantine(stagingDir); err != nil {
@@ -610,7 +661,7 @@ func (u *UpdaterService) markDownloadError(message string) (UpdateStatusResponse
 }
 
 func (u *UpdaterService) Apply() (UpdateStatusResponse, error) {
-	if !serverConfig.Update.Enabled {
+	if !getServerConfig().Update.Enabled {
 		return u.Status(), fmt.Errorf("update is disabled")
 	}
 
@@ -633,6 +684,7 @@ func (u *UpdaterService) Apply() (UpdateStatusResponse, error) {
 		WorkingDir:        u.workingDir,
 		RestartArgs:       append([]string(nil), u.restartArgs...),
 		TargetVersion:     u.state.DownloadedVersion,
+		PreviousVersion:   Version,
 	}
 	u.state.Stage = updateStageApplying
 	u.state.LastError = ""
@@ -646,9 +698,14 @@ func (u *UpdaterService) Apply() (UpdateStatusResponse, error) {
 		go u.applyInDocker(job)
 		return u.Status(), nil
 	}
+	return u.startUpdateWorker(job)
+}
 
+// startUpdateWorker hands job to a copy of the current binary running in
+// worker mode, then exits so the worker can replace the files in place.
+func (u *UpdaterService) startUpdateWorker(job updateWorkerJob) (UpdateStatusResponse, error) {
 	if err := cleanupUpdaterEntries(u.workerDir, ""); err != nil {
-		log.Printf("updater worker cleanup warning: %v", err)
+		slog.Warn("Updater worker cleanup failed", "error", err)
 	}
 
 	helperName := "xxtcc-worker-" + strconv.FormatInt(time.Now().UnixNano(), 10)
@@ -686,6 +743,155 @@ func (u *UpdaterService) Apply() (UpdateStatusResponse, error) {
 	return u.Status(), nil
 }
 
+// Rollback swaps the .bak binary and frontend kept by the last apply back into
+// place and restarts, using the same worker mechanism as Apply. The replaced
+// version becomes the new backup, so a rollback can itself be rolled back.
+func (u *UpdaterService) Rollback() (UpdateStatusResponse, error) {
+	if !getServerConfig().Update.Enabled {
+		return u.Status(), fmt.Errorf("update is disabled")
+	}
+
+	u.mu.RLock()
+	stage := u.state.Stage
+	u.mu.RUnlock()
+	switch stage {
+	case updateStageChecking, updateStageDownloading, updateStageApplying:
+		return u.Status(), fmt.Errorf("another update operation is in progress")
+	}
+
+	backupBinary := u.execPath + ".bak"
+	backupFrontendDir := u.frontendDir + ".bak"
+	if err := ensureFile(backupBinary); err != nil {
+		return u.Status(), fmt.Errorf("no backup available to roll back to")
+	}
+	backupVersion, err := validateBackupBinaryForRollback(backupBinary)
+	if err != nil {
+		return u.Status(), err
+	}
+	sourceFrontendDir := backupFrontendDir
+	if ensureDir(sourceFrontendDir) != nil {
+		// The backup has no frontend when none existed before the last apply;
+		// keep the current one rather than leaving the target empty.
+		sourceFrontendDir = u.frontendDir
+		if err := ensureDir(sourceFrontendDir); err != nil {
+			return u.Status(), fmt.Errorf("no frontend backup available to roll back to")
+		}
+	}
+
+	// Stage copies so the .bak paths are free to receive the replaced version.
+	stagingDir := filepath.Join(u.stagingRoot, "rollback-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	stagedBinary := filepath.Join(stagingDir, filepath.Base(u.execPath))
+	stagedFrontendDir := filepath.Join(stagingDir, "frontend")
+	if err := copyFile(backupBinary, stagedBinary); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return u.Status(), err
+	}
+	if err := copyDir(sourceFrontendDir, stagedFrontendDir); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return u.Status(), err
+	}
+
+	u.mu.Lock()
+	if u.state.Stage != stage {
+		u.mu.Unlock()
+		_ = os.RemoveAll(stagingDir)
+		return u.Status(), fmt.Errorf("another update operation is in progress")
+	}
+	job := updateWorkerJob{
+		ParentPID:         os.Getpid(),
+		StateFile:         u.stateFile,
+		SourceBinary:      stagedBinary,
+		SourceFrontendDir: stagedFrontendDir,
+		StagingDir:        stagingDir,
+		TargetBinary:      u.execPath,
+		TargetFrontendDir: u.frontendDir,
+		BackupBinary:      backupBinary,
+		BackupFrontendDir: backupFrontendDir,
+		WorkingDir:        u.workingDir,
+		RestartArgs:       append([]string(nil), u.restartArgs...),
+		TargetVersion:     backupVersion,
+		PreviousVersion:   Version,
+		Rollback:          true,
+	}
+	u.state.Stage = updateStageApplying
+	u.state.LastError = ""
+	if err := u.saveStateLocked(); err != nil {
+		u.mu.Unlock()
+		_ = os.RemoveAll(stagingDir)
+		return u.Status(), err
+	}
+	u.mu.Unlock()
+
+	if isDockerRuntime() {
+		go u.rollbackInDocker(job)
+		return u.Status(), nil
+	}
+	return u.startUpdateWorker(job)
+}
+
+// validateBackupBinaryForRollback probes the backup binary and returns its version.
+func validateBackupBinaryForRollback(binaryPath string) (string, error) {
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(binaryPath); err == nil && info.Mode()&0111 == 0 {
+			if err := os.Chmod(binaryPath, 0755); err != nil {
+				return "", fmt.Errorf("backup binary is not executable: %w", err)
+			}
+		}
+	}
+	backupVersion, err := probeBinaryVersion(binaryPath)
+	if err != nil {
+		return "", fmt.Errorf("backup binary check failed: %w", err)
+	}
+	if compareVersionStrings(backupVersion, Version) == 0 {
+		return "", fmt.Errorf("backup binary is already the current version (%s)", backupVersion)
+	}
+	return backupVersion, nil
+}
+
+// markRolledBack records a completed rollback to job.TargetVersion.
+func markRolledBack(state *UpdaterState, job updateWorkerJob) {
+	state.Stage = updateStageIdle
+	state.LastError = ""
+	state.HasUpdate = false
+	state.Ignored = false
+	state.DownloadTotalBytes = 0
+	state.DownloadedBytes = 0
+	state.AppliedVersion = job.TargetVersion
+	state.RolledBackFrom = job.PreviousVersion
+	state.DownloadedVersion = ""
+	state.DownloadedAsset = ""
+	state.DownloadedFile = ""
+	state.StagingDir = ""
+	state.SourceBinary = ""
+	state.SourceFrontendDir = ""
+}
+
+func (u *UpdaterService) rollbackInDocker(job updateWorkerJob) {
+	// Let HTTP handler flush response before replacing/executing current binary.
+	time.Sleep(300 * time.Millisecond)
+
+	if err := applyUpdateReplacement(job); err != nil {
+		_ = os.RemoveAll(job.StagingDir)
+		if isPermissionOrReadonlyError(err) {
+			err = fmt.Errorf("docker 文件系统不可写，请改用旧版本镜像重建容器完成回滚: %w", err)
+		}
+		_, _ = u.markApplyError(err)
+		return
+	}
+	_ = os.RemoveAll(job.StagingDir)
+	// The restored binary may predate rollback support, so record the final
+	// state before handing over instead of relying on its startup reconcile.
+	u.mu.Lock()
+	markRolledBack(&u.state, job)
+	_ = u.saveStateLocked()
+	u.mu.Unlock()
+	if err := execUpdatedBinary(job.TargetBinary, job.RestartArgs, u.restartEnv, job.WorkingDir); err != nil {
+		rollbackFromBackup(job)
+		_, _ = u.markApplyError(err)
+		return
+	}
+}
+
 func (u *UpdaterService) markApplyError(err error) (UpdateStatusResponse, error) {
 	u.mu.Lock()
 	u.state.Stage = updateStageFailed
@@ -741,7 +947,7 @@ func validateDownloadedBinaryForExec(binaryPath string, expectedVersion string)
 	if expectedVersion != "" && compareVersionStrings(detectedVersion, expectedVersion) != 0 {
 		return fmt.Errorf("downloaded binary version mismatch: got %s, expected %s", detectedVersion, expectedVersion)
 	}
-	if compareVersionStrings(detectedVersion, Version) <= 0 {
+	if compareVersionStrings(detectedVersion, Version) <= 0 && !isReturnToStableVersion(detectedVersion, Version, getServerConfig().Update.Channel) {
 		return fmt.Errorf("downloaded binary (%s) is not newer than current version (%s)", detectedVersion, Version)
 	}
 	return nil
@@ -814,7 +1020,7 @@ func (u *UpdaterService) fetchManifest(ctx context.Context, manifestURL string)
 	}
 	req.Header.Set("User-Agent", "XXTCloudControl-Updater/"+Version)
 
-	resp, err := u.httpClient.Do(req)
+	resp, err := u.client().Do(req)
 	if err != nil {
 		return UpdateManifest{}, err
 	}
@@ -918,7 +1124,7 @@ func (u *UpdaterService) downloadFile(ctx context.Context, url string, target st
 	}
 	req.Header.Set("User-Agent", "XXTCloudControl-Updater/"+Version)
 
-	resp, err := u.httpClient.Do(req)
+	resp, err := u.client().Do(req)
 	if err != nil {
 		return err
 	}
@@ -990,6 +1196,73 @@ func verifyFileSHA256(path string, expected string) error {
 	return nil
 }
 
+// verifyFrontendManifest checks that frontendDir contains exactly the files listed in
+// manifest with matching sha256. An empty manifest skips the check.
+func verifyFrontendManifest(frontendDir string, manifest map[string]string) error {
+	if len(manifest) == 0 {
+		return nil
+	}
+
+	expected := make(map[string]string, len(manifest))
+	for rawPath, sum := range manifest {
+		relPath := path.Clean(strings.TrimPrefix(strings.ReplaceAll(rawPath, "\\", "/"), "./"))
+		if relPath == "." || path.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			return fmt.Errorf("frontend integrity check failed: invalid manifest path %q", rawPath)
+		}
+		expected[relPath] = sum
+	}
+
+	var unexpected []string
+	err := filepath.WalkDir(frontendDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(frontendDir, filePath)
+		if err != nil {
+			return err
+		}
+		if _, ok := expected[filepath.ToSlash(rel)]; !ok {
+			unexpected = append(unexpected, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("frontend integrity check failed: %w", err)
+	}
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		return fmt.Errorf("frontend integrity check failed: unexpected file %s", unexpected[0])
+	}
+
+	relPaths := make([]string, 0, len(expected))
+	for relPath := range expected {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		filePath := filepath.Join(frontendDir, filepath.FromSlash(relPath))
+		if fi, err := os.Stat(filePath); err != nil || fi.IsDir() {
+			return fmt.Errorf("frontend integrity check failed: missing file %s", relPath)
+		}
+		if strings.TrimSpace(expected[relPath]) == "" {
+			return fmt.Errorf("frontend integrity check failed: missing sha256 for %s", relPath)
+		}
+		if err := verifyFileSHA256(filePath, expected[relPath]); err != nil {
+			return fmt.Errorf("frontend integrity check failed: checksum mismatch for %s", relPath)
+		}
+	}
+	return nil
+}
+
+// secureZipEntryPath joins a zip entry name onto destClean, rejecting names that escape it.
+func secureZipEntryPath(destClean string, name string) (string, error) {
+	cleanPath := filepath.Clean(filepath.Join(destClean, name))
+	if cleanPath != destClean && !strings.HasPrefix(cleanPath, destClean+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal zip entry path: %s", name)
+	}
+	return cleanPath, nil
+}
+
 func unzipSecure(zipPath string, destDir string) error {
 	r, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -998,12 +1271,10 @@ func unzipSecure(zipPath string, destDir string) error {
 	defer r.Close()
 
 	destClean := filepath.Clean(destDir)
-	destPrefix := destClean + string(os.PathSeparator)
 	for _, f := range r.File {
-		filePath := filepath.Join(destClean, f.Name)
-		cleanPath := filepath.Clean(filePath)
-		if cleanPath != destClean && !strings.HasPrefix(cleanPath, destPrefix) {
-			return fmt.Errorf("illegal zip entry path: %s", f.Name)
+		cleanPath, err := secureZipEntryPath(destClean, f.Name)
+		if err != nil {
+			return err
 		}
 		if f.FileInfo().IsDir() {
 			if err := os.MkdirAll(cleanPath, f.Mode()); err != nil {
@@ -1068,6 +1339,68 @@ func resolveManifestURLs(source UpdateSourceConfig) []string {
 	return []string{"https://github.com/" + repo + "/releases/latest/download/update-manifest.json"}
 }
 
+// resolveChannelManifestURLs returns resolveManifestURLs for the stable channel.
+// For beta, each "releases/latest/download/" URL is pointed at the "beta" release
+// tag instead, and other URLs get a "beta/" directory before the manifest file.
+func resolveChannelManifestURLs(source UpdateSourceConfig, channel string) []string {
+	urls := resolveManifestURLs(source)
+	if normalizeUpdateChannel(channel) != updateChannelBeta {
+		return urls
+	}
+	out := make([]string, 0, len(urls))
+	for _, manifestURL := range urls {
+		out = append(out, betaManifestURL(manifestURL))
+	}
+	return out
+}
+
+func betaManifestURL(manifestURL string) string {
+	if before, after, found := strings.Cut(manifestURL, "/releases/latest/download/"); found {
+		return before + "/releases/download/" + updateChannelBeta + "/" + after
+	}
+	if idx := strings.LastIndex(manifestURL, "/"); idx >= 0 {
+		return manifestURL[:idx+1] + updateChannelBeta + "/" + manifestURL[idx+1:]
+	}
+	return manifestURL
+}
+
+// normalizeUpdateChannel maps an empty channel to stable and lowercases the rest.
+func normalizeUpdateChannel(channel string) string {
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if channel == "" {
+		return updateChannelStable
+	}
+	return channel
+}
+
+func isValidUpdateChannel(channel string) bool {
+	switch normalizeUpdateChannel(channel) {
+	case updateChannelStable, updateChannelBeta:
+		return true
+	}
+	return false
+}
+
+// versionUpdateChannel infers a release's channel from its version: tags with a
+// pre-release suffix (v202602211900-beta.1, v1.2.0-rc.1) are beta, the rest stable.
+func versionUpdateChannel(version string) string {
+	if idx := strings.Index(normalizeVersionTag(version), "-"); idx > 0 {
+		return updateChannelBeta
+	}
+	return updateChannelStable
+}
+
+// isReturnToStableVersion reports whether a beta build has switched to the stable
+// channel and candidate is a stable release, which is installable even if it is older.
+// Only this direction may downgrade: the channel is inferred from the version suffix,
+// so an unusual stable tag containing "-" must never let beta roll a build back.
+func isReturnToStableVersion(candidate, current, channel string) bool {
+	return normalizeUpdateChannel(channel) == updateChannelStable &&
+		compareVersionStrings(candidate, current) != 0 &&
+		versionUpdateChannel(candidate) == updateChannelStable &&
+		versionUpdateChannel(current) == updateChannelBeta
+}
+
 func resolveAssetDownloadURLs(asset UpdateAsset) []string {
 	return normalizeUpdateURLs([]string{asset.URL, asset.FallbackURL, asset.LatestURL})
 }
@@ -530,3 +530,206 @@ func testManifestJSON(version string, assetURL string, fallbackURL string) strin
 		]
 	}`, version, runtime.GOOS, runtime.GOARCH, assetURL, fallbackURL)
 }
+
+func TestVerifyFrontendManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "assets"), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	files := map[string]string{
+		"index.html":     "<html></html>",
+		"assets/app.js":  "console.log(1)",
+		"assets/app.css": "body{}",
+	}
+	manifest := make(map[string]string, len(files))
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s failed: %v", name, err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+
+	if err := verifyFrontendManifest(dir, nil); err != nil {
+		t.Fatalf("empty manifest should skip verification: %v", err)
+	}
+	if err := verifyFrontendManifest(dir, manifest); err != nil {
+		t.Fatalf("expected frontend to verify: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "assets", "app.js"), []byte("tampered"), 0o644); err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	if err := verifyFrontendManifest(dir, manifest); err == nil || !strings.Contains(err.Error(), "checksum mismatch for assets/app.js") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "assets", "app.js")); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if err := verifyFrontendManifest(dir, manifest); err == nil || !strings.Contains(err.Error(), "missing file assets/app.js") {
+		t.Fatalf("expected missing file error, got %v", err)
+	}
+
+	delete(manifest, "assets/app.js")
+	if err := os.WriteFile(filepath.Join(dir, "extra.js"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write extra failed: %v", err)
+	}
+	if err := verifyFrontendManifest(dir, manifest); err == nil || !strings.Contains(err.Error(), "unexpected file extra.js") {
+		t.Fatalf("expected unexpected file error, got %v", err)
+	}
+
+	if err := verifyFrontendManifest(dir, map[string]string{"../index.html": manifest["index.html"]}); err == nil {
+		t.Fatalf("expected invalid manifest path to be rejected")
+	}
+}
+
+func TestRollbackRefusesWithoutUsableBackup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script backup is not executable on windows")
+	}
+	backupEnabled := getServerConfig().Update.Enabled
+	backupVersion := Version
+	updateServerConfig(func(cfg *ServerConfig) { cfg.Update.Enabled = true })
+	Version = "v1.0.0"
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.Update.Enabled = backupEnabled })
+		Version = backupVersion
+	})
+
+	root := t.TempDir()
+	u := &UpdaterService{
+		execPath:    filepath.Join(root, "xxtcloudserver"),
+		frontendDir: filepath.Join(root, "frontend"),
+		stagingRoot: filepath.Join(root, "staging"),
+		stateFile:   filepath.Join(root, "state.json"),
+		state:       UpdaterState{Stage: updateStageIdle, AppliedVersion: Version},
+	}
+
+	status, err := u.Rollback()
+	if err == nil || !strings.Contains(err.Error(), "no backup") {
+		t.Fatalf("expected missing backup error, got %v", err)
+	}
+	if status.RollbackAvailable || status.State.Stage != updateStageIdle {
+		t.Fatalf("unexpected status after refused rollback: %+v", status)
+	}
+
+	script := fmt.Sprintf("#!/bin/sh\necho %s\n", Version)
+	if err := os.WriteFile(u.execPath+".bak", []byte(script), 0755); err != nil {
+		t.Fatalf("write backup binary: %v", err)
+	}
+	status, err = u.Rollback()
+	if err == nil || !strings.Contains(err.Error(), "already the current version") {
+		t.Fatalf("expected same-version backup to be refused, got %v", err)
+	}
+	if !status.RollbackAvailable || status.State.Stage != updateStageIdle {
+		t.Fatalf("unexpected status after refused rollback: %+v", status)
+	}
+	if _, err := os.Stat(u.stagingRoot); !os.IsNotExist(err) {
+		t.Fatalf("refused rollback should not stage files, stat err=%v", err)
+	}
+
+	u.state.Stage = updateStageDownloading
+	if _, err := u.Rollback(); err == nil || !strings.Contains(err.Error(), "in progress") {
+		t.Fatalf("expected rollback to be refused while downloading, got %v", err)
+	}
+}
+
+func TestMarkRolledBackRecordsVersions(t *testing.T) {
+	state := UpdaterState{
+		Stage:             updateStageApplying,
+		HasUpdate:         true,
+		AppliedVersion:    "v2.0.0",
+		DownloadedVersion: "v2.1.0",
+		StagingDir:        "/tmp/rollback",
+	}
+	markRolledBack(&state, updateWorkerJob{TargetVersion: "v1.9.0", PreviousVersion: "v2.0.0", Rollback: true})
+	if state.Stage != updateStageIdle || state.HasUpdate {
+		t.Fatalf("unexpected stage after rollback: %+v", state)
+	}
+	if state.AppliedVersion != "v1.9.0" || state.RolledBackFrom != "v2.0.0" {
+		t.Fatalf("unexpected versions after rollback: applied=%q from=%q", state.AppliedVersion, state.RolledBackFrom)
+	}
+	if state.DownloadedVersion != "" || state.StagingDir != "" {
+		t.Fatalf("download artifacts should be cleared, got %+v", state)
+	}
+}
+
+func TestResolveChannelManifestURLs(t *testing.T) {
+	originalDefaults := DefaultUpdateManifestURLsCSV
+	DefaultUpdateManifestURLsCSV = ""
+	defer func() {
+		DefaultUpdateManifestURLsCSV = originalDefaults
+	}()
+
+	source := UpdateSourceConfig{Repository: "custom/repo"}
+	if got := resolveChannelManifestURLs(source, ""); len(got) != 1 || got[0] != "https://github.com/custom/repo/releases/latest/download/update-manifest.json" {
+		t.Fatalf("empty channel should resolve like stable, got %#v", got)
+	}
+	if got := resolveChannelManifestURLs(source, "Beta"); len(got) != 1 || got[0] != "https://github.com/custom/repo/releases/download/beta/update-manifest.json" {
+		t.Fatalf("beta should use the beta release tag, got %#v", got)
+	}
+
+	got := resolveChannelManifestURLs(UpdateSourceConfig{
+		ManifestURLs: []string{"https://mirror.example.com/xxtcc/update-manifest.json"},
+	}, updateChannelBeta)
+	if len(got) != 1 || got[0] != "https://mirror.example.com/xxtcc/beta/update-manifest.json" {
+		t.Fatalf("custom manifest urls should get a beta directory, got %#v", got)
+	}
+}
+
+func TestCheckChannelSwitchOffersOtherChannelRelease(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/releases/latest/download/update-manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testManifestJSON("v202602211800", server.URL+"/stable/pkg.zip", "")))
+	})
+	mux.HandleFunc("/releases/download/beta/update-manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testManifestJSON("v202602211800-beta.1", server.URL+"/beta/pkg.zip", "")))
+	})
+
+	updateBackup, versionBackup := getServerConfig().Update, Version
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.Update = updateBackup })
+		Version = versionBackup
+	})
+	updateServerConfig(func(cfg *ServerConfig) {
+		cfg.Update = UpdateConfig{
+			Enabled: true,
+			Source:  UpdateSourceConfig{ManifestURL: server.URL + "/releases/latest/download/update-manifest.json"},
+		}
+	})
+	u := &UpdaterService{
+		httpClient: server.Client(),
+		stateFile:  filepath.Join(t.TempDir(), "state.json"),
+		state:      UpdaterState{Stage: updateStageIdle},
+	}
+
+	cases := []struct {
+		current   string
+		channel   string
+		hasUpdate bool
+	}{
+		// A beta build switching back to stable gets the lower stable release.
+		{current: "v202602211900-beta.2", channel: updateChannelStable, hasUpdate: true},
+		// Staying on beta never downgrades.
+		{current: "v202602211900-beta.2", channel: updateChannelBeta, hasUpdate: false},
+		// A stable build opting into beta gets a newer beta release, never an older one.
+		{current: "v202602211700", channel: updateChannelBeta, hasUpdate: true},
+		{current: "v202602211900", channel: updateChannelBeta, hasUpdate: false},
+		{current: "v202602211900", channel: updateChannelStable, hasUpdate: false},
+	}
+	for _, tc := range cases {
+		Version = tc.current
+		updateServerConfig(func(cfg *ServerConfig) { cfg.Update.Channel = tc.channel })
+		status, err := u.Check(context.Background())
+		if err != nil {
+			t.Fatalf("check %s on %s failed: %v", tc.current, tc.channel, err)
+		}
+		if status.State.Channel != tc.channel || status.State.HasUpdate != tc.hasUpdate {
+			t.Fatalf("check %s on %s: channel=%q hasUpdate=%v, want hasUpdate=%v",
+				tc.current, tc.channel, status.State.Channel, status.State.HasUpdate, tc.hasUpdate)
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -23,6 +25,49 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+const defaultWebSocketCompressionMinBytes = 1024
+
+// shouldCompressWebSocketMessage reports whether a message of size bytes is worth
+// deflating; small control messages cost more CPU than they save.
+func shouldCompressWebSocketMessage(size int) bool {
+	if !getServerConfig().WebSocketCompression {
+		return false
+	}
+	minBytes := getServerConfig().WebSocketCompressionMinBytes
+	if minBytes <= 0 {
+		minBytes = defaultWebSocketCompressionMinBytes
+	}
+	return size >= minBytes
+}
+
+const defaultMaxWebSocketMessageBytes = 8 * 1024 * 1024
+
+// webSocketMessageHeadroom covers the JSON envelope around a base64 file/put payload.
+const webSocketMessageHeadroom = 64 * 1024
+
+// maxWebSocketMessageBytes is the read limit set on every accepted connection. The
+// default grows with largeFileThresholdBytes so inline file/put payloads always fit.
+func maxWebSocketMessageBytes() int64 {
+	if getServerConfig().MaxWebSocketMessageBytes > 0 {
+		return getServerConfig().MaxWebSocketMessageBytes
+	}
+	return max(defaultMaxWebSocketMessageBytes, minWebSocketMessageBytes(*getServerConfig()))
+}
+
+// minWebSocketMessageBytes is the smallest read limit that still fits an inline file/put
+// of a file just under cfg's large-file threshold and a binary chunk of the maximum size.
+func minWebSocketMessageBytes(cfg ServerConfig) int64 {
+	threshold := cfg.LargeFileThresholdBytes
+	if threshold <= 0 {
+		threshold = defaultLargeFileThreshold
+	}
+	minBytes := int64(base64.StdEncoding.EncodedLen(int(threshold))) + webSocketMessageHeadroom
+	if chunk := int64(cfg.BinaryMaxChunkBytes) + binaryHeaderSize; chunk > minBytes {
+		minBytes = chunk
+	}
+	return minBytes
+}
+
 const binaryHeaderSize = 24
 const stateRefreshIdleInterval = 300 * time.Second
 
@@ -53,6 +98,20 @@ func parseBinaryHeader(data []byte) (string, uint32, uint32, bool) {
 	return reqID, seq, total, true
 }
 
+// validateBinaryChunk checks a relayed binary frame's header against the configured limits.
+func validateBinaryChunk(seq, total uint32, chunkSize int) error {
+	if total == 0 || seq >= total {
+		return fmt.Errorf("invalid seq %d for total %d", seq, total)
+	}
+	if maxCount := getServerConfig().BinaryMaxChunkCount; maxCount > 0 && int64(total) > int64(maxCount) {
+		return fmt.Errorf("total %d exceeds max chunk count %d", total, maxCount)
+	}
+	if maxBytes := getServerConfig().BinaryMaxChunkBytes; maxBytes > 0 && chunkSize > maxBytes {
+		return fmt.Errorf("chunk size %d exceeds max %d bytes", chunkSize, maxBytes)
+	}
+	return nil
+}
+
 func sendBinaryMessage(conn *SafeConn, payload []byte) error {
 	if conn == nil {
 		return nil
@@ -64,16 +123,51 @@ func writeTextMessage(conn *SafeConn, payload []byte) error {
 	if conn == nil {
 		return nil
 	}
+	recordPulseMessageSent()
 	return conn.WriteMessage(websocket.TextMessage, payload)
 }
 
 func writeTextMessageAsync(conn *SafeConn, payload []byte) {
+	writeTextMessageAsyncWithPriority(conn, payload, writePriorityNormal)
+}
+
+// writeTextMessageAsyncWithPriority uses the device write queue when conn has one.
+func writeTextMessageAsyncWithPriority(conn *SafeConn, payload []byte, priority writePriority) {
+	if enqueueDeviceWrite(conn, websocket.TextMessage, payload, priority) {
+		return
+	}
+	runAsyncWrite(func() {
+		_ = writeTextMessage(conn, payload)
+	})
+}
+
+// writeTextMessageBulk is writeTextMessageAsync for bulk sends to a device (file pushes,
+// fetch commands): it waits for room in the device write queue instead of overflowing it.
+// Callers must not hold mu.
+func writeTextMessageBulk(conn *SafeConn, payload []byte) {
+	if enqueueDeviceWriteWait(conn, websocket.TextMessage, payload) {
+		return
+	}
 	runAsyncWrite(func() {
 		_ = writeTextMessage(conn, payload)
 	})
 }
 
+// sendBinaryMessageBulk relays a binary chunk to a device with the same backpressure as
+// writeTextMessageBulk.
+func sendBinaryMessageBulk(conn *SafeConn, payload []byte) {
+	if enqueueDeviceWriteWait(conn, websocket.BinaryMessage, payload) {
+		return
+	}
+	runAsyncWrite(func() {
+		_ = sendBinaryMessage(conn, payload)
+	})
+}
+
 func sendBinaryMessageAsync(conn *SafeConn, payload []byte) {
+	if enqueueDeviceWrite(conn, websocket.BinaryMessage, payload, writePriorityNormal) {
+		return
+	}
 	runAsyncWrite(func() {
 		_ = sendBinaryMessage(conn, payload)
 	})
@@ -220,6 +314,21 @@ func parseControlCommandBody(body interface{}) (ControlCommand, error) {
 	} else if _, exists := bodyMap["devices"]; exists {
 		return ControlCommand{}, fmt.Errorf("invalid devices in control/command")
 	}
+	if view, ok := toString(bodyMap["view"]); ok {
+		out.View = view
+	} else if _, exists := bodyMap["view"]; exists {
+		return ControlCommand{}, fmt.Errorf("invalid view in control/command")
+	}
+	if groups, ok := toStringSlice(bodyMap["groups"]); ok {
+		out.Groups = groups
+	} else if _, exists := bodyMap["groups"]; exists {
+		return ControlCommand{}, fmt.Errorf("invalid groups in control/command")
+	}
+	if tags, ok := toStringSlice(bodyMap["tags"]); ok {
+		out.Tags = tags
+	} else if _, exists := bodyMap["tags"]; exists {
+		return ControlCommand{}, fmt.Errorf("invalid tags in control/command")
+	}
 	if typ, ok := toString(bodyMap["type"]); ok {
 		out.Type = typ
 	} else if _, exists := bodyMap["type"]; exists {
@@ -231,6 +340,16 @@ func parseControlCommandBody(body interface{}) (ControlCommand, error) {
 	} else if _, exists := bodyMap["requestId"]; exists {
 		return ControlCommand{}, fmt.Errorf("invalid requestId in control/command")
 	}
+	if confirmToken, ok := toString(bodyMap["confirmToken"]); ok {
+		out.ConfirmToken = confirmToken
+	} else if _, exists := bodyMap["confirmToken"]; exists {
+		return ControlCommand{}, fmt.Errorf("invalid confirmToken in control/command")
+	}
+	if timeout, ok := toInt(bodyMap["timeout"]); ok {
+		out.Timeout = timeout
+	} else if _, exists := bodyMap["timeout"]; exists {
+		return ControlCommand{}, fmt.Errorf("invalid timeout in control/command")
+	}
 
 	return out, nil
 }
@@ -257,6 +376,11 @@ func toCommands(value interface{}) ([]Command, bool) {
 				return nil, false
 			}
 			cmd.Body = cmdMap["body"]
+			if requestID, ok := toString(cmdMap["requestId"]); ok {
+				cmd.RequestID = requestID
+			} else if _, exists := cmdMap["requestId"]; exists {
+				return nil, false
+			}
 			out = append(out, cmd)
 		}
 		return out, true
@@ -277,12 +401,42 @@ func parseControlCommandsBody(body interface{}) (ControlCommands, error) {
 	} else if _, exists := bodyMap["devices"]; exists {
 		return ControlCommands{}, fmt.Errorf("invalid devices in control/commands")
 	}
+	if view, ok := toString(bodyMap["view"]); ok {
+		out.View = view
+	} else if _, exists := bodyMap["view"]; exists {
+		return ControlCommands{}, fmt.Errorf("invalid view in control/commands")
+	}
+	if groups, ok := toStringSlice(bodyMap["groups"]); ok {
+		out.Groups = groups
+	} else if _, exists := bodyMap["groups"]; exists {
+		return ControlCommands{}, fmt.Errorf("invalid groups in control/commands")
+	}
+	if tags, ok := toStringSlice(bodyMap["tags"]); ok {
+		out.Tags = tags
+	} else if _, exists := bodyMap["tags"]; exists {
+		return ControlCommands{}, fmt.Errorf("invalid tags in control/commands")
+	}
 
 	if commands, ok := toCommands(bodyMap["commands"]); ok {
 		out.Commands = commands
 	} else if _, exists := bodyMap["commands"]; exists {
 		return ControlCommands{}, fmt.Errorf("invalid commands in control/commands")
 	}
+	if confirmToken, ok := toString(bodyMap["confirmToken"]); ok {
+		out.ConfirmToken = confirmToken
+	} else if _, exists := bodyMap["confirmToken"]; exists {
+		return ControlCommands{}, fmt.Errorf("invalid confirmToken in control/commands")
+	}
+	if batchID, ok := toString(bodyMap["batchId"]); ok {
+		out.BatchID = batchID
+	} else if _, exists := bodyMap["batchId"]; exists {
+		return ControlCommands{}, fmt.Errorf("invalid batchId in control/commands")
+	}
+	if timeout, ok := toInt(bodyMap["timeout"]); ok {
+		out.Timeout = timeout
+	} else if _, exists := bodyMap["timeout"]; exists {
+		return ControlCommands{}, fmt.Errorf("invalid timeout in control/commands")
+	}
 
 	return out, nil
 }
@@ -299,6 +453,16 @@ func parseHTTPProxyRequestBody(body interface{}) (HTTPProxyRequest, error) {
 	} else if _, exists := bodyMap["devices"]; exists {
 		return HTTPProxyRequest{}, fmt.Errorf("invalid devices in control/http")
 	}
+	if groups, ok := toStringSlice(bodyMap["groups"]); ok {
+		out.Groups = groups
+	} else if _, exists := bodyMap["groups"]; exists {
+		return HTTPProxyRequest{}, fmt.Errorf("invalid groups in control/http")
+	}
+	if tags, ok := toStringSlice(bodyMap["tags"]); ok {
+		out.Tags = tags
+	} else if _, exists := bodyMap["tags"]; exists {
+		return HTTPProxyRequest{}, fmt.Errorf("invalid tags in control/http")
+	}
 	if requestID, ok := toString(bodyMap["requestId"]); ok {
 		out.RequestID = requestID
 	} else if _, exists := bodyMap["requestId"]; exists {
@@ -467,19 +631,21 @@ func ensureController(conn *SafeConn) {
 
 	mu.Lock()
 	controllers[conn] = true
+	assignControllerIDLocked(conn)
 	mu.Unlock()
 }
 
-// addLogSubscriberLocked registers a controller as a log subscriber for a device.
+// addSubscriberLocked registers a controller as a subscriber for a device in table.
+// Returns true when it is the first subscriber for the device.
 // Caller must hold mu.Lock.
-func addLogSubscriberLocked(udid string, conn *SafeConn) bool {
+func addSubscriberLocked(table map[string]map[*SafeConn]bool, udid string, conn *SafeConn) bool {
 	if udid == "" || conn == nil {
 		return false
 	}
-	subs := logSubscriptions[udid]
+	subs := table[udid]
 	if subs == nil {
 		subs = make(map[*SafeConn]bool)
-		logSubscriptions[udid] = subs
+		table[udid] = subs
 	}
 	if subs[conn] {
 		return false
@@ -489,13 +655,14 @@ func addLogSubscriberLocked(udid string, conn *SafeConn) bool {
 	return wasEmpty
 }
 
-// removeLogSubscriberLocked removes a controller from a device's log subscription.
+// removeSubscriberLocked removes a controller from a device's subscription in table.
+// Returns true when the last subscriber was removed.
 // Caller must hold mu.Lock.
-func removeLogSubscriberLocked(udid string, conn *SafeConn) bool {
+func removeSubscriberLocked(table map[string]map[*SafeConn]bool, udid string, conn *SafeConn) bool {
 	if udid == "" || conn == nil {
 		return false
 	}
-	subs, ok := logSubscriptions[udid]
+	subs, ok := table[udid]
 	if !ok {
 		return false
 	}
@@ -504,24 +671,25 @@ func removeLogSubscriberLocked(udid string, conn *SafeConn) bool {
 	}
 	delete(subs, conn)
 	if len(subs) == 0 {
-		delete(logSubscriptions, udid)
+		delete(table, udid)
 		return true
 	}
 	return false
 }
 
-// removeLogSubscriberFromAllLocked removes a controller from all device log subscriptions.
+// removeSubscriberFromAllLocked removes a controller from all device subscriptions in table.
+// Returns the devices that no longer have subscribers.
 // Caller must hold mu.Lock.
-func removeLogSubscriberFromAllLocked(conn *SafeConn) []string {
+func removeSubscriberFromAllLocked(table map[string]map[*SafeConn]bool, conn *SafeConn) []string {
 	if conn == nil {
 		return nil
 	}
 	emptied := make([]string, 0)
-	for udid, subs := range logSubscriptions {
+	for udid, subs := range table {
 		if subs[conn] {
 			delete(subs, conn)
 			if len(subs) == 0 {
-				delete(logSubscriptions, udid)
+				delete(table, udid)
 				emptied = append(emptied, udid)
 			}
 		}
@@ -529,6 +697,24 @@ func removeLogSubscriberFromAllLocked(conn *SafeConn) []string {
 	return emptied
 }
 
+// addLogSubscriberLocked registers a controller as a log subscriber for a device.
+// Caller must hold mu.Lock.
+func addLogSubscriberLocked(udid string, conn *SafeConn) bool {
+	return addSubscriberLocked(logSubscriptions, udid, conn)
+}
+
+// removeLogSubscriberLocked removes a controller from a device's log subscription.
+// Caller must hold mu.Lock.
+func removeLogSubscriberLocked(udid string, conn *SafeConn) bool {
+	return removeSubscriberLocked(logSubscriptions, udid, conn)
+}
+
+// removeLogSubscriberFromAllLocked removes a controller from all device log subscriptions.
+// Caller must hold mu.Lock.
+func removeLogSubscriberFromAllLocked(conn *SafeConn) []string {
+	return removeSubscriberFromAllLocked(logSubscriptions, conn)
+}
+
 // getReadableCommandName returns a human-readable name for typical device commands
 func getReadableCommandName(cmdType string) string {
 	switch cmdType {
@@ -580,8 +766,8 @@ func isDataValid(data Message) bool {
 }
 
 func getDeviceLifeLimit() int {
-	if serverConfig.PingTimeout > 0 {
-		return serverConfig.PingTimeout
+	if getServerConfig().PingTimeout > 0 {
+		return getServerConfig().PingTimeout
 	}
 	if DefaultConfig.PingTimeout > 0 {
 		return DefaultConfig.PingTimeout
@@ -602,16 +788,18 @@ func resetDeviceLife(conn *SafeConn) {
 // checkAndUpdateDeviceLife checks and updates all device life counters
 func checkAndUpdateDeviceLife() {
 	disconnectTargets := make([]deviceTarget, 0)
+	exhaustedSystems := make(map[string]map[string]interface{})
 
 	mu.Lock()
 	for udid, life := range deviceLife {
 		if life <= 0 {
-			wsDebugf("Device %s life exhausted, will disconnect", udid)
+			wsDebug("Device life exhausted, will disconnect", "udid", udid)
 			if deviceConn, exists := deviceLinks[udid]; exists {
 				disconnectTargets = append(disconnectTargets, deviceTarget{
 					udid: udid,
 					conn: deviceConn,
 				})
+				exhaustedSystems[udid] = deviceSystemInfoLocked(udid)
 			}
 			continue
 		}
@@ -620,46 +808,84 @@ func checkAndUpdateDeviceLife() {
 	mu.Unlock()
 
 	for _, target := range disconnectTargets {
+		notifyDeviceWebhooks("device/life-exhausted", target.udid, exhaustedSystems[target.udid])
 		go func(dc *SafeConn, deviceUDID string) {
-			wsDebugf("Disconnecting device %s due to life exhaustion", deviceUDID)
+			wsDebug("Disconnecting device due to life exhaustion", "udid", deviceUDID)
 			dc.Close()
 			handleDisconnection(dc)
 		}(target.conn, target.udid)
 	}
 }
 
-// handleWebSocketConnection handles WebSocket connections
+// handleWebSocketConnection handles /api/ws, where the role is inferred from the first
+// messages (control/* or app/state).
 func handleWebSocketConnection(c *gin.Context) {
+	serveWebSocketConnection(c, "")
+}
+
+// handleDeviceWebSocketConnection handles /api/ws/device
+func handleDeviceWebSocketConnection(c *gin.Context) {
+	serveWebSocketConnection(c, connRoleDevice)
+}
+
+// handleControlWebSocketConnection handles /api/ws/control
+func handleControlWebSocketConnection(c *gin.Context) {
+	serveWebSocketConnection(c, connRoleControl)
+}
+
+func serveWebSocketConnection(c *gin.Context, role connRole) {
 	w := c.Writer
 	r := c.Request
-	conn, err := upgrader.Upgrade(w, r, nil)
+	connUpgrader := upgrader
+	connUpgrader.EnableCompression = getServerConfig().WebSocketCompression
+	conn, err := connUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.Error("WebSocket upgrade failed", "remote_addr", r.RemoteAddr, "error", err)
 		return
 	}
 
-	safeConn := &SafeConn{conn: conn}
+	safeConn := &SafeConn{conn: conn, role: role}
 	defer safeConn.Close()
+	readLimit := maxWebSocketMessageBytes()
+	safeConn.conn.SetReadLimit(readLimit)
 
 	// Count PONG frames as liveness signals to avoid false disconnects when
-	// device has no frequent text/binary traffic.
-	safeConn.conn.SetPongHandler(func(string) error {
-		resetDeviceLife(safeConn)
-		return nil
-	})
+	// device has no frequent text/binary traffic. Declared controllers have no device life.
+	tracksDeviceLife := role != connRoleControl
+	if tracksDeviceLife {
+		safeConn.conn.SetPongHandler(func(string) error {
+			resetDeviceLife(safeConn)
+			return nil
+		})
+	}
 
-	wsDebugf("New connection from: %s", safeConn.RemoteAddr())
+	wsDebug("New connection", "remote_addr", safeConn.RemoteAddr(), "role", string(role))
+	connectedAt := time.Now()
+	stopMaxAgeWatcher := watchConnMaxAge(safeConn, connectedAt)
+	defer stopMaxAgeWatcher()
 
 	for {
+		if connectionPastMaxAge(connectedAt, time.Now()) {
+			closeConnPastMaxAge(safeConn)
+			break
+		}
+
 		messageType, messageBytes, err := safeConn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+			if errors.Is(err, websocket.ErrReadLimit) {
+				udid, _ := getDeviceUDIDByConn(safeConn)
+				slog.Warn("WebSocket message exceeds maxWebSocketMessageBytes, closing connection",
+					"remote_addr", safeConn.RemoteAddr(), "udid", udid, "limit", readLimit)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				slog.Warn("WebSocket read failed", "remote_addr", safeConn.RemoteAddr(), "error", err)
 			}
 			break
 		}
 
-		resetDeviceLife(safeConn)
+		if tracksDeviceLife {
+			resetDeviceLife(safeConn)
+		}
+		recordPulseMessageIn()
 
 		if messageType == websocket.BinaryMessage {
 			handleBinaryMessage(safeConn, messageBytes)
@@ -676,13 +902,71 @@ func handleWebSocketConnection(c *gin.Context) {
 		}
 
 		if err := handleMessage(safeConn, data); err != nil {
-			log.Printf("Handle message error: %v", err)
+			slog.Error("Handle message failed", "remote_addr", safeConn.RemoteAddr(), "msg_type", data.Type, "error", err)
 		}
 	}
 
 	handleDisconnection(safeConn)
 }
 
+// connectionPastMaxAge reports whether a connection opened at connectedAt has outlived
+// MaxConnAgeSeconds. Always false when the limit is disabled.
+func connectionPastMaxAge(connectedAt, now time.Time) bool {
+	maxAge := time.Duration(getServerConfig().MaxConnAgeSeconds) * time.Second
+	return maxAge > 0 && now.Sub(connectedAt) >= maxAge
+}
+
+// maxConnAgeRecheckInterval bounds how long watchConnMaxAge sleeps, so a changed
+// MaxConnAgeSeconds also reaches connections that are already open.
+var maxConnAgeRecheckInterval = 10 * time.Second
+
+// watchConnMaxAge closes conn once it outlives MaxConnAgeSeconds, even if it never sends
+// another message and the read loop stays blocked. The returned func stops the watcher
+// and waits for it to exit.
+func watchConnMaxAge(conn *SafeConn, connectedAt time.Time) func() {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		timer := time.NewTimer(nextMaxConnAgeCheck(connectedAt, time.Now()))
+		defer timer.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-timer.C:
+				if connectionPastMaxAge(connectedAt, now) {
+					closeConnPastMaxAge(conn)
+					// Unblocks the read loop, which then runs the usual disconnect cleanup.
+					conn.Close()
+					return
+				}
+				timer.Reset(nextMaxConnAgeCheck(connectedAt, now))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// nextMaxConnAgeCheck returns how long to wait before checking the connection's age again.
+func nextMaxConnAgeCheck(connectedAt, now time.Time) time.Duration {
+	wait := maxConnAgeRecheckInterval
+	if maxAge := time.Duration(getServerConfig().MaxConnAgeSeconds) * time.Second; maxAge > 0 {
+		wait = min(wait, connectedAt.Add(maxAge).Sub(now))
+	}
+	return max(wait, 0)
+}
+
+// closeConnPastMaxAge asks the client to reconnect because its connection is too old.
+func closeConnPastMaxAge(conn *SafeConn) {
+	wsDebug("Closing connection past max age", "remote_addr", conn.RemoteAddr())
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "max connection age reached, please reconnect")
+	_ = conn.WriteMessage(websocket.CloseMessage, closeMsg)
+}
+
 func getDeviceUDIDByConn(conn *SafeConn) (string, bool) {
 	mu.RLock()
 	udid, exists := deviceLinksMap[conn]
@@ -690,6 +974,43 @@ func getDeviceUDIDByConn(conn *SafeConn) (string, bool) {
 	return udid, exists
 }
 
+// messageAllowedForRole reports whether a connection with role may send msgType: declared
+// devices may not send control/* messages and declared controllers may only send them.
+// Connections on /api/ws accept everything.
+func messageAllowedForRole(role connRole, msgType string) bool {
+	switch role {
+	case connRoleDevice:
+		return !strings.HasPrefix(msgType, "control/")
+	case connRoleControl:
+		return strings.HasPrefix(msgType, "control/")
+	}
+	return true
+}
+
+// matchMessageTypePattern matches msgType against an exact type or a "prefix*" pattern.
+func matchMessageTypePattern(msgType string, patterns []string) bool {
+	for _, raw := range patterns {
+		pattern := strings.TrimSpace(raw)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(msgType, prefix) {
+				return true
+			}
+		} else if pattern != "" && msgType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldForwardDeviceMessage applies ForwardAllowTypes/ForwardDenyTypes to unknown
+// device message types; the deny list wins when a type is in both.
+func shouldForwardDeviceMessage(msgType string) bool {
+	if len(getServerConfig().ForwardAllowTypes) > 0 && !matchMessageTypePattern(msgType, getServerConfig().ForwardAllowTypes) {
+		return false
+	}
+	return !matchMessageTypePattern(msgType, getServerConfig().ForwardDenyTypes)
+}
+
 func forwardDeviceMessageToControllers(conn *SafeConn, data Message) error {
 	var (
 		udid           string
@@ -710,7 +1031,7 @@ func forwardDeviceMessageToControllers(conn *SafeConn, data Message) error {
 
 	// 记录转发的消息类型
 	if data.Type == "http/response" || data.Type == "http/request" {
-		httpDebugf("[%s] Forwarding %s from device %s to %d controllers", data.Type, data.Type, udid, len(controllerList))
+		httpDebug("Forwarding device message to controllers", "msg_type", data.Type, "udid", udid, "controllers", len(controllerList))
 	}
 	data.UDID = udid
 	encodedData, err := json.Marshal(data)
@@ -723,8 +1044,60 @@ func forwardDeviceMessageToControllers(conn *SafeConn, data Message) error {
 	return nil
 }
 
+// dispatchCommandToDevices sends a single command to the connected devices among udids
+// and returns how many devices it was sent to. controllerID names the sender in the
+// command history.
+func dispatchCommandToDevices(udids []string, cmdType string, body interface{}, requestID, controllerID string) (int, error) {
+	var deviceConns map[string]*SafeConn
+	mu.RLock()
+	deviceConns = snapshotDeviceConnsByIDsLocked(udids)
+	mu.RUnlock()
+
+	cmdMsg := Message{
+		Type:      cmdType,
+		Body:      body,
+		RequestID: requestID,
+	}
+	cmdBytes, err := json.Marshal(cmdMsg)
+	if err != nil {
+		return 0, err
+	}
+
+	readableName := getReadableCommandName(cmdType)
+	priority := commandWritePriority(cmdType)
+
+	sent := make([]string, 0, len(deviceConns))
+	for _, udid := range udids {
+		if deviceConn, exists := deviceConns[udid]; exists {
+			if readableName != "" {
+				broadcastDeviceMessage(udid, readableName)
+			}
+			writeTextMessageAsyncWithPriority(deviceConn, cmdBytes, priority)
+			noteScriptCommandSent(udid, cmdType, body)
+			sent = append(sent, udid)
+		}
+	}
+	recordCommandsSent(sent, cmdType, requestID, controllerID)
+	return len(sent), nil
+}
+
 // handleMessage processes incoming WebSocket messages
 func handleMessage(conn *SafeConn, data Message) error {
+	if !enforceMessageRateLimit(conn, data) {
+		return nil
+	}
+	if !messageAllowedForRole(conn.role, data.Type) {
+		wsDebug("Dropped message not allowed for connection role", "remote_addr", conn.RemoteAddr(), "role", string(conn.role), "msg_type", data.Type)
+		sendMessageAsync(conn, Message{
+			Type:      "error",
+			RequestID: data.RequestID,
+			Error:     fmt.Sprintf("%s is not accepted on /api/ws/%s", data.Type, conn.role),
+			Body:      map[string]interface{}{"type": data.Type},
+		})
+		return nil
+	}
+	recordMetricsMessageHandled(data.Type)
+
 	switch data.Type {
 	case "control/devices":
 		if !isDataValid(data) {
@@ -733,51 +1106,49 @@ func handleMessage(conn *SafeConn, data Message) error {
 		}
 
 		ensureController(conn)
+		return sendDevicesList(conn, data)
 
-		mu.RLock()
-		deviceTableSnapshot := make(map[string]interface{}, len(deviceTable))
-		for udid, deviceState := range deviceTable {
-			deviceTableSnapshot[udid] = deviceState
+	case "control/refresh":
+		if !isDataValid(data) {
+			conn.Close()
+			return nil
 		}
-		mu.RUnlock()
 
-		response := Message{
-			Type: "control/devices",
-			Body: deviceTableSnapshot,
-		}
-		responseBytes, err := json.Marshal(response)
-		if err != nil {
-			return err
-		}
-		return writeTextMessage(conn, responseBytes)
+		ensureController(conn)
+		requestFleetRefresh()
 
-	case "control/refresh":
+	case "control/identity":
 		if !isDataValid(data) {
 			conn.Close()
 			return nil
 		}
 
 		ensureController(conn)
+		sendMessageAsync(conn, Message{Type: "control/identity", Body: map[string]interface{}{"controllerId": getControllerID(conn)}})
 
-		var deviceConns []*SafeConn
-		mu.RLock()
-		deviceConns = make([]*SafeConn, 0, len(deviceLinks))
-		for _, deviceConn := range deviceLinks {
-			deviceConns = append(deviceConns, deviceConn)
+	case "control/device/label":
+		if !isDataValid(data) {
+			conn.Close()
+			return nil
 		}
-		mu.RUnlock()
 
-		refreshMsg := Message{
-			Type: "app/state",
-			Body: "",
-		}
-		refreshBytes, err := json.Marshal(refreshMsg)
+		ensureController(conn)
+		udid, label, err := parseDeviceLabelBody(data.Body)
 		if err != nil {
-			return err
+			sendMessageAsync(conn, Message{Type: "control/device/label/error", RequestID: data.RequestID, Error: err.Error()})
+			return nil
 		}
-		for _, deviceConn := range deviceConns {
-			writeTextMessageAsync(deviceConn, refreshBytes)
+		if err := setDeviceLabel(udid, label); err != nil {
+			slog.Error("Failed to save device labels", "udid", udid, "error", err)
+			sendMessageAsync(conn, Message{Type: "control/device/label/error", RequestID: data.RequestID, Error: "failed to save device labels"})
+			return nil
 		}
+		relabelDeviceTableEntry(udid)
+		broadcastControllerEvent("device/label", map[string]interface{}{
+			"udid":  udid,
+			"label": label.Label,
+			"color": label.Color,
+		})
 
 	case "control/command":
 		if !isDataValid(data) {
@@ -791,33 +1162,47 @@ func handleMessage(conn *SafeConn, data Message) error {
 		}
 
 		ensureController(conn)
+		cmdBody.Devices = expandDevicesWithSelectors(expandDevicesWithView(cmdBody.Devices, cmdBody.View), cmdBody.Groups, cmdBody.Tags)
 
-		var deviceConns map[string]*SafeConn
-		mu.RLock()
-		deviceConns = snapshotDeviceConnsByIDsLocked(cmdBody.Devices)
-		mu.RUnlock()
-
-		cmdMsg := Message{
-			Type:      cmdBody.Type,
-			Body:      cmdBody.Body,
-			RequestID: cmdBody.RequestID,
-		}
-		cmdBytes, err := json.Marshal(cmdMsg)
-		if err != nil {
-			return err
+		if isRebootCommand(cmdBody.Type) {
+			devices := uniqueDeviceIDs(cmdBody.Devices)
+			if err := checkConfirmToken(confirmOpDeviceReboot, deviceRebootConfirmScope(devices), len(devices), cmdBody.ConfirmToken); err != nil {
+				sendMessageAsync(conn, Message{Type: "control/command/error", RequestID: cmdBody.RequestID, Error: err.Error()})
+				return nil
+			}
 		}
 
-		readableName := getReadableCommandName(cmdBody.Type)
+		var leased []string
+		cmdBody.Devices, leased = filterLeasedDevices(conn, cmdBody.Devices)
+		if len(leased) > 0 {
+			sendMessageAsync(conn, Message{Type: "control/command/error", RequestID: cmdBody.RequestID, Error: errDeviceLeased.Error(), Body: map[string]interface{}{"devices": leased}})
+			if len(cmdBody.Devices) == 0 {
+				return nil
+			}
+		}
 
-		for _, udid := range cmdBody.Devices {
-			if deviceConn, exists := deviceConns[udid]; exists {
-				if readableName != "" {
-					broadcastDeviceMessage(udid, readableName)
-				}
-				writeTextMessageAsync(deviceConn, cmdBytes)
+		var remote map[string][]string
+		cmdBody.Devices, remote = splitFederatedDevices(cmdBody.Devices)
+		for region, devices := range remote {
+			if err := relayControlCommandUpstream(region, devices, cmdBody); err != nil {
+				sendMessageAsync(conn, Message{Type: "control/command/error", RequestID: cmdBody.RequestID, Error: err.Error(), Body: map[string]interface{}{"region": region}})
 			}
 		}
 
+		recordServerEvent("control/command", "", map[string]interface{}{"type": cmdBody.Type, "devices": cmdBody.Devices, "requestId": cmdBody.RequestID})
+		recordAudit(auditEntry{
+			RemoteAddr:   connRemoteAddr(conn),
+			ControllerID: getControllerID(conn),
+			Type:         "control/command",
+			Commands:     []string{cmdBody.Type},
+			Devices:      cmdBody.Devices,
+			RequestID:    cmdBody.RequestID,
+		})
+		trackPendingCommand(conn, cmdBody.RequestID, cmdBody.Type, cmdBody.Devices, commandAckTimeout(cmdBody.Timeout))
+		if _, err := dispatchCommandToDevices(cmdBody.Devices, cmdBody.Type, cmdBody.Body, cmdBody.RequestID, getControllerID(conn)); err != nil {
+			return err
+		}
+
 	case "control/commands":
 		if !isDataValid(data) {
 			conn.Close()
@@ -830,18 +1215,65 @@ func handleMessage(conn *SafeConn, data Message) error {
 		}
 
 		ensureController(conn)
+		cmdsBody.Devices = expandDevicesWithSelectors(expandDevicesWithView(cmdsBody.Devices, cmdsBody.View), cmdsBody.Groups, cmdsBody.Tags)
+
+		for _, cmd := range cmdsBody.Commands {
+			if !isRebootCommand(cmd.Type) {
+				continue
+			}
+			devices := uniqueDeviceIDs(cmdsBody.Devices)
+			if err := checkConfirmToken(confirmOpDeviceReboot, deviceRebootConfirmScope(devices), len(devices), cmdsBody.ConfirmToken); err != nil {
+				sendMessageAsync(conn, Message{Type: "control/commands/error", Error: err.Error()})
+				return nil
+			}
+			break
+		}
+
+		var leased []string
+		cmdsBody.Devices, leased = filterLeasedDevices(conn, cmdsBody.Devices)
+		if len(leased) > 0 {
+			sendMessageAsync(conn, Message{Type: "control/commands/error", Error: errDeviceLeased.Error(), Body: map[string]interface{}{"devices": leased}})
+			if len(cmdsBody.Devices) == 0 {
+				return nil
+			}
+		}
+
+		var remote map[string][]string
+		cmdsBody.Devices, remote = splitFederatedDevices(cmdsBody.Devices)
+		for region, devices := range remote {
+			if err := relayControlCommandsUpstream(region, devices, cmdsBody.Commands); err != nil {
+				sendMessageAsync(conn, Message{Type: "control/commands/error", Error: err.Error(), Body: map[string]interface{}{"region": region}})
+			}
+		}
+
+		commandTypes := make([]string, 0, len(cmdsBody.Commands))
+		for _, cmd := range cmdsBody.Commands {
+			commandTypes = append(commandTypes, cmd.Type)
+		}
+		recordServerEvent("control/commands", "", map[string]interface{}{"types": commandTypes, "devices": cmdsBody.Devices})
+		recordAudit(auditEntry{
+			RemoteAddr:   connRemoteAddr(conn),
+			ControllerID: getControllerID(conn),
+			Type:         "control/commands",
+			Commands:     commandTypes,
+			Devices:      cmdsBody.Devices,
+			BatchID:      cmdsBody.BatchID,
+		})
 
 		var deviceConns map[string]*SafeConn
 		mu.RLock()
 		deviceConns = snapshotDeviceConnsByIDsLocked(cmdsBody.Devices)
 		mu.RUnlock()
 
+		trackPendingBatch(conn, cmdsBody.BatchID, cmdsBody.Commands, cmdsBody.Devices, commandAckTimeout(cmdsBody.Timeout))
+
 		commandPayloads := make([][]byte, 0, len(cmdsBody.Commands))
 		commandNames := make([]string, 0, len(cmdsBody.Commands))
 		for _, cmd := range cmdsBody.Commands {
 			cmdMsg := Message{
-				Type: cmd.Type,
-				Body: cmd.Body,
+				Type:      cmd.Type,
+				Body:      cmd.Body,
+				RequestID: cmd.RequestID,
 			}
 			payload, err := json.Marshal(cmdMsg)
 			if err != nil {
@@ -851,6 +1283,7 @@ func handleMessage(conn *SafeConn, data Message) error {
 			commandNames = append(commandNames, getReadableCommandName(cmd.Type))
 		}
 
+		sentTo := make([]string, 0, len(deviceConns))
 		for _, udid := range cmdsBody.Devices {
 			if deviceConn, exists := deviceConns[udid]; exists {
 				for i, payload := range commandPayloads {
@@ -858,10 +1291,15 @@ func handleMessage(conn *SafeConn, data Message) error {
 					if readableName != "" {
 						broadcastDeviceMessage(udid, readableName)
 					}
-					writeTextMessageAsync(deviceConn, payload)
+					writeTextMessageAsyncWithPriority(deviceConn, payload, commandWritePriority(cmdsBody.Commands[i].Type))
 				}
+				sentTo = append(sentTo, udid)
 			}
 		}
+		controllerID := getControllerID(conn)
+		for _, cmd := range cmdsBody.Commands {
+			recordCommandsSent(sentTo, cmd.Type, cmd.RequestID, controllerID)
+		}
 
 	case "control/http":
 		// HTTP 代理：将 HTTP 请求转发到目标设备（使用 http.request）
@@ -872,11 +1310,12 @@ func handleMessage(conn *SafeConn, data Message) error {
 
 		httpReq, err := parseHTTPProxyRequestBody(data.Body)
 		if err != nil {
-			log.Printf("[http] Failed to parse request: %v", err)
+			slog.Warn("Failed to parse control/http request", "remote_addr", conn.RemoteAddr(), "error", err)
 			return err
 		}
+		httpReq.Devices = expandDevicesWithSelectors(httpReq.Devices, httpReq.Groups, httpReq.Tags)
 
-		httpDebugf("[http] Received control/http for devices: %v, path: %s", httpReq.Devices, httpReq.Path)
+		httpDebug("Received control/http", "devices", httpReq.Devices, "path", httpReq.Path)
 
 		// 构建发送给设备的消息
 		httpBody := map[string]interface{}{
@@ -916,7 +1355,7 @@ func handleMessage(conn *SafeConn, data Message) error {
 				newBodyBytes, err := json.Marshal(originalBody)
 				if err == nil {
 					httpBody["body"] = base64.StdEncoding.EncodeToString(newBodyBytes)
-					httpDebugf("[http] Injected TURN server config for WebRTC start request")
+					httpDebug("Injected TURN server config for WebRTC start request")
 				}
 			}
 		}
@@ -931,6 +1370,22 @@ func handleMessage(conn *SafeConn, data Message) error {
 		}
 
 		ensureController(conn)
+		var leased []string
+		httpReq.Devices, leased = filterLeasedDevices(conn, httpReq.Devices)
+		if len(leased) > 0 {
+			sendMessageAsync(conn, Message{Type: "control/http/error", RequestID: httpReq.RequestID, Error: errDeviceLeased.Error(), Body: map[string]interface{}{"devices": leased}})
+			if len(httpReq.Devices) == 0 {
+				return nil
+			}
+		}
+		recordAudit(auditEntry{
+			RemoteAddr:   connRemoteAddr(conn),
+			ControllerID: getControllerID(conn),
+			Type:         "control/http",
+			Devices:      httpReq.Devices,
+			RequestID:    httpReq.RequestID,
+			Detail:       httpReq.Method + " " + httpReq.Path,
+		})
 
 		var deviceConns map[string]*SafeConn
 		mu.RLock()
@@ -941,14 +1396,14 @@ func handleMessage(conn *SafeConn, data Message) error {
 			if deviceConn, exists := deviceConns[udid]; exists {
 				deviceUDID := udid
 				dc := deviceConn
-				httpDebugf("[http] Sending http/request to device %s", udid)
+				httpDebug("Sending http/request to device", "udid", udid)
 				runAsyncWrite(func() {
 					if err := writeTextMessage(dc, httpBytes); err != nil {
-						log.Printf("[http] Failed to send to device %s: %v", deviceUDID, err)
+						slog.Warn("Failed to send http/request to device", "udid", deviceUDID, "error", err)
 					}
 				})
 			} else {
-				httpDebugf("[http] Device %s not found in deviceLinks", udid)
+				httpDebug("control/http target device not connected", "udid", udid)
 			}
 		}
 
@@ -960,7 +1415,7 @@ func handleMessage(conn *SafeConn, data Message) error {
 
 		httpReq, err := parseHTTPProxyRequestBinBody(data.Body)
 		if err != nil {
-			log.Printf("[http-bin] Failed to parse request: %v", err)
+			slog.Warn("Failed to parse control/http-bin request", "remote_addr", conn.RemoteAddr(), "error", err)
 			return err
 		}
 		if httpReq.RequestID == "" {
@@ -988,6 +1443,14 @@ func handleMessage(conn *SafeConn, data Message) error {
 		}
 
 		ensureController(conn)
+		var leased []string
+		httpReq.Devices, leased = filterLeasedDevices(conn, httpReq.Devices)
+		if len(leased) > 0 {
+			sendMessageAsync(conn, Message{Type: "control/http-bin/error", RequestID: httpReq.RequestID, Error: errDeviceLeased.Error(), Body: map[string]interface{}{"devices": leased}})
+			if len(httpReq.Devices) == 0 {
+				return nil
+			}
+		}
 
 		var deviceConns map[string]*SafeConn
 		mu.Lock()
@@ -1002,14 +1465,14 @@ func handleMessage(conn *SafeConn, data Message) error {
 			if deviceConn, exists := deviceConns[udid]; exists {
 				deviceUDID := udid
 				dc := deviceConn
-				httpDebugf("[http-bin] Sending http/request-bin to device %s", udid)
+				httpDebug("Sending http/request-bin to device", "udid", udid)
 				runAsyncWrite(func() {
 					if err := writeTextMessage(dc, httpBytes); err != nil {
-						log.Printf("[http-bin] Failed to send to device %s: %v", deviceUDID, err)
+						slog.Warn("Failed to send http/request-bin to device", "udid", deviceUDID, "error", err)
 					}
 				})
 			} else {
-				httpDebugf("[http-bin] Device %s not found in deviceLinks", udid)
+				httpDebug("control/http-bin target device not connected", "udid", udid)
 			}
 		}
 
@@ -1024,7 +1487,7 @@ func handleMessage(conn *SafeConn, data Message) error {
 			return err
 		}
 
-		subscribeTargets := make([]*SafeConn, 0, len(req.Devices))
+		subscribeTargets := make([]deviceTarget, 0, len(req.Devices))
 		mu.Lock()
 		if !controllers[conn] {
 			controllers[conn] = true
@@ -1033,7 +1496,7 @@ func handleMessage(conn *SafeConn, data Message) error {
 			first := addLogSubscriberLocked(udid, conn)
 			if first {
 				if deviceConn, exists := deviceLinks[udid]; exists {
-					subscribeTargets = append(subscribeTargets, deviceConn)
+					subscribeTargets = append(subscribeTargets, deviceTarget{udid: udid, conn: deviceConn})
 				}
 			}
 		}
@@ -1044,8 +1507,10 @@ func handleMessage(conn *SafeConn, data Message) error {
 			if err != nil {
 				return err
 			}
-			for _, deviceConn := range subscribeTargets {
-				writeTextMessageAsync(deviceConn, subscribePayload)
+			now := time.Now()
+			for _, target := range subscribeTargets {
+				writeTextMessageAsync(target.conn, subscribePayload)
+				markLogStreamActive(target.udid, now)
 			}
 		}
 
@@ -1085,6 +1550,89 @@ func handleMessage(conn *SafeConn, data Message) error {
 			}
 		}
 
+	case "control/events/subscribe", "control/events/unsubscribe":
+		if !isDataValid(data) {
+			conn.Close()
+			return nil
+		}
+
+		req, err := parseLogSubscribeRequestBody(data.Body)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		if !controllers[conn] {
+			controllers[conn] = true
+		}
+		if data.Type == "control/events/subscribe" {
+			for _, udid := range req.Devices {
+				addSubscriberLocked(eventSubscriptions, udid, conn)
+			}
+		} else if len(req.Devices) == 0 {
+			// Dropping every filter brings back all devices' events
+			removeSubscriberFromAllLocked(eventSubscriptions, conn)
+		} else {
+			for _, udid := range req.Devices {
+				removeSubscriberLocked(eventSubscriptions, udid, conn)
+			}
+		}
+		mu.Unlock()
+
+	case "control/screen/subscribe":
+		if !isDataValid(data) {
+			conn.Close()
+			return nil
+		}
+
+		req, err := parseLogSubscribeRequestBody(data.Body)
+		if err != nil {
+			return err
+		}
+
+		startTargets := make([]*SafeConn, 0, len(req.Devices))
+		mu.Lock()
+		if !controllers[conn] {
+			controllers[conn] = true
+		}
+		for _, udid := range req.Devices {
+			if addSubscriberLocked(screenSubscriptions, udid, conn) {
+				if deviceConn, exists := deviceLinks[udid]; exists {
+					startTargets = append(startTargets, deviceConn)
+				}
+			}
+		}
+		mu.Unlock()
+
+		sendScreenStreamControl("screen/stream/start", startTargets)
+
+	case "control/screen/unsubscribe":
+		if !isDataValid(data) {
+			conn.Close()
+			return nil
+		}
+
+		req, err := parseLogSubscribeRequestBody(data.Body)
+		if err != nil {
+			return err
+		}
+
+		stopTargets := make([]*SafeConn, 0, len(req.Devices))
+		mu.Lock()
+		if !controllers[conn] {
+			controllers[conn] = true
+		}
+		for _, udid := range req.Devices {
+			if removeSubscriberLocked(screenSubscriptions, udid, conn) {
+				if deviceConn, exists := deviceLinks[udid]; exists {
+					stopTargets = append(stopTargets, deviceConn)
+				}
+			}
+		}
+		mu.Unlock()
+
+		sendScreenStreamControl("screen/stream/stop", stopTargets)
+
 	case "http/response-bin":
 		// 服务端内部截图请求也复用 http/response-bin，必须先拦截，避免再转发给控制端。
 		if handleInternalHTTPResponseBinMeta(conn, data) {
@@ -1163,29 +1711,89 @@ func handleMessage(conn *SafeConn, data Message) error {
 			return fmt.Errorf("invalid udid in app/state")
 		}
 
+		var clockSkew *deviceClockSkewInfo
+		if deviceTS, ok := extractDeviceTimestamp(data, systemMap); ok {
+			info := measureDeviceClockSkew(udid, deviceTS, time.Now())
+			clockSkew = &info
+			bodyMap["clockSkew"] = gin.H{
+				"seconds": info.SkewSeconds,
+				"flagged": info.Flagged,
+			}
+		}
+
+		applyDeviceLabel(udid, bodyMap)
+
 		var (
-			needsLogSubscribe bool
-			controllerList    []*SafeConn
+			needsLogSubscribe    bool
+			needsScreenSubscribe bool
+			controllerList       []*SafeConn
 		)
 		mu.Lock()
+		if deviceLinks[udid] != conn && deviceCapReachedLocked(udid) {
+			mu.Unlock()
+			rejectDeviceOverCap(conn, udid)
+			return nil
+		}
+		newlyConnected := deviceLinks[udid] != conn
+		if newlyConnected {
+			resetDeviceStateSeqLocked(udid)
+		}
+		if !acceptDeviceStateSeqLocked(udid, bodyMap) {
+			mu.Unlock()
+			wsDebug("Ignored out-of-order app/state", "udid", udid, "state_seq", bodyMap["stateSeq"])
+			return nil
+		}
+		resumed := false
+		if newlyConnected {
+			recordDeviceConnectLocked(udid, time.Now())
+			resumed = cancelDeviceOfflineLocked(udid)
+		}
+		bodyMap["stability"] = deviceStabilityStateLocked(udid)
 		deviceLinks[udid] = conn
 		deviceLinksMap[conn] = udid
 		deviceTable[udid] = data.Body
+		touchDeviceStateLocked(udid, time.Now())
 		deviceLife[udid] = getDeviceLifeLimit()
+		newlySkewed := false
+		if clockSkew != nil {
+			newlySkewed = updateDeviceClockSkewFlagLocked(udid, clockSkew.Flagged)
+		}
+		if logArchiveEnabled() && deviceRequestsLogAutoStart(bodyMap) {
+			addLogSubscriberLocked(udid, logArchiveSink)
+		}
 		if subs, ok := logSubscriptions[udid]; ok && len(subs) > 0 {
 			needsLogSubscribe = true
 		}
+		if subs, ok := screenSubscriptions[udid]; ok && len(subs) > 0 {
+			needsScreenSubscribe = true
+		}
 		if len(controllers) > 0 {
 			controllerList = snapshotControllerConnsLocked()
 		}
 		mu.Unlock()
 
+		if newlyConnected {
+			startDeviceWriteQueue(conn)
+			if !resumed {
+				recordServerEvent("device/connect", udid, nil)
+				notifyDeviceWebhooks("device/connect", udid, systemMap)
+			}
+		}
+		noteScriptStateFromAppState(udid, bodyMap)
 		if needsLogSubscribe {
 			subscribePayload, err := json.Marshal(Message{Type: "system/log/subscribe"})
 			if err != nil {
 				return err
 			}
 			writeTextMessageAsync(conn, subscribePayload)
+			markLogStreamActive(udid, time.Now())
+		}
+		if needsScreenSubscribe {
+			sendScreenStreamControl("screen/stream/start", []*SafeConn{conn})
+		}
+		if newlySkewed {
+			slog.Warn("Device clock skew exceeds threshold", "udid", udid, "skew_seconds", clockSkew.SkewSeconds)
+			broadcastControllerEvent("device/clock-skew", clockSkew)
 		}
 
 		if len(controllerList) > 0 {
@@ -1208,6 +1816,7 @@ func handleMessage(conn *SafeConn, data Message) error {
 		var (
 			udid           string
 			subscriberList []*SafeConn
+			archive        bool
 		)
 		mu.RLock()
 		if mappedUDID, exists := deviceLinksMap[conn]; exists {
@@ -1215,12 +1824,25 @@ func handleMessage(conn *SafeConn, data Message) error {
 			if subs, ok := logSubscriptions[udid]; ok && len(subs) > 0 {
 				subscriberList = make([]*SafeConn, 0, len(subs))
 				for controllerConn := range subs {
+					if controllerConn == logArchiveSink {
+						archive = true
+						continue
+					}
 					subscriberList = append(subscriberList, controllerConn)
 				}
 			}
 		}
 		mu.RUnlock()
 
+		if udid != "" {
+			markLogStreamActive(udid, time.Now())
+		}
+		if archive {
+			if err := appendDeviceLogArchive(udid, data.Body); err != nil {
+				slog.Warn("Failed to archive device log", "udid", udid, "error", err)
+			}
+		}
+
 		if udid != "" && len(subscriberList) > 0 {
 			data.UDID = udid
 			encodedData, err := json.Marshal(data)
@@ -1233,13 +1855,33 @@ func handleMessage(conn *SafeConn, data Message) error {
 		}
 		return nil
 
+	case "screen/frame":
+		return forwardScreenFrame(conn, data)
+
+	case "transfer/send-batch/failed":
+		if udid, ok := getDeviceUDIDByConn(conn); ok {
+			handlePullBatchFailedMessage(udid, data.Body)
+		}
+		return forwardDeviceMessageToControllers(conn, data)
+
 	case "transfer/fetch/complete":
 		if udid, ok := getDeviceUDIDByConn(conn); ok {
-			handleTransferFetchCompletionForScriptStart(udid, data.Body)
+			dispatchTransferFetchCompletion(udid, data.Body)
 		}
 		return forwardDeviceMessageToControllers(conn, data)
 
 	default:
+		if udid, ok := getDeviceUDIDByConn(conn); ok {
+			if data.RequestID != "" {
+				acknowledgePendingCommand(udid, data)
+				collectBatchReply(udid, data)
+				recordCommandResult(udid, data)
+			}
+			noteScriptReply(udid, data)
+		}
+		if !shouldForwardDeviceMessage(data.Type) {
+			return nil
+		}
 		return forwardDeviceMessageToControllers(conn, data)
 	}
 
@@ -1255,6 +1897,10 @@ func handleBinaryMessage(conn *SafeConn, payload []byte) {
 	if handleInternalHTTPResponseBinChunk(conn, reqID, seq, total, payload[binaryHeaderSize:]) {
 		return
 	}
+	if err := validateBinaryChunk(seq, total, len(payload)-binaryHeaderSize); err != nil {
+		slog.Warn("Dropped binary frame", "request_id", reqID, "remote_addr", conn.RemoteAddr(), "error", err)
+		return
+	}
 
 	var (
 		deviceTargets   []*SafeConn
@@ -1276,7 +1922,7 @@ func handleBinaryMessage(conn *SafeConn, payload []byte) {
 		mu.RUnlock()
 
 		for _, deviceConn := range deviceTargets {
-			sendBinaryMessageAsync(deviceConn, payload)
+			sendBinaryMessageBulk(deviceConn, payload)
 		}
 		return
 	}
@@ -1327,45 +1973,65 @@ func sendMessageAsync(conn *SafeConn, msg Message) {
 func handleDisconnection(conn *SafeConn) {
 	var (
 		unsubscribeTargets []*SafeConn
+		screenStopTargets  []*SafeConn
 		disconnectTargets  []*SafeConn
 		disconnectUDID     string
+		disconnectSystem   map[string]interface{}
 		disconnectedUDID   string
+		commandNotices     []pendingCommandNotice
 	)
 
+	stopDeviceWriteQueue(conn)
+
 	mu.Lock()
-	wsDebugf("Connection closed: %s", conn.RemoteAddr())
+	wsDebug("Connection closed", "remote_addr", conn.RemoteAddr())
 
 	if _, isController := controllers[conn]; isController {
-		wsDebugf("Controller %s disconnected", conn.RemoteAddr())
+		wsDebug("Controller disconnected", "remote_addr", conn.RemoteAddr())
 		emptied := removeLogSubscriberFromAllLocked(conn)
 		for _, udid := range emptied {
 			if deviceConn, exists := deviceLinks[udid]; exists {
 				unsubscribeTargets = append(unsubscribeTargets, deviceConn)
 			}
 		}
+		removeSubscriberFromAllLocked(eventSubscriptions, conn)
+		for _, udid := range removeSubscriberFromAllLocked(screenSubscriptions, conn) {
+			if deviceConn, exists := deviceLinks[udid]; exists {
+				screenStopTargets = append(screenStopTargets, deviceConn)
+			}
+		}
 		for id, route := range binaryRoutes {
 			if route != nil && route.Controller == conn {
 				delete(binaryRoutes, id)
 			}
 		}
+		dropPendingCommandsForControllerLocked(conn)
+		dropPendingBatchesForControllerLocked(conn)
 		delete(controllers, conn)
+		controllerID := controllerIDs[conn]
+		delete(controllerIDs, conn)
 		mu.Unlock()
 
+		if released := releaseControllerLeases(controllerID); released > 0 {
+			wsDebug("Released device leases held by controller", "leases", released, "controller_id", controllerID)
+		}
+
 		if len(unsubscribeTargets) > 0 {
 			unsubscribePayload, err := json.Marshal(Message{Type: "system/log/unsubscribe"})
 			if err != nil {
-				log.Printf("Failed to marshal unsubscribe message: %v", err)
+				slog.Error("Failed to marshal unsubscribe message", "error", err)
 			} else {
 				for _, deviceConn := range unsubscribeTargets {
 					writeTextMessageAsync(deviceConn, unsubscribePayload)
 				}
 			}
 		}
+		sendScreenStreamControl("screen/stream/stop", screenStopTargets)
 		return
 	}
 
 	if udid, exists := deviceLinksMap[conn]; exists {
-		wsDebugf("Device %s disconnected", udid)
+		wsDebug("Device disconnected", "udid", udid, "remote_addr", conn.RemoteAddr())
 		disconnectedUDID = udid
 
 		delete(deviceLinksMap, conn)
@@ -1375,10 +2041,12 @@ func handleDisconnection(conn *SafeConn) {
 			return
 		}
 
-		delete(deviceTable, udid)
 		delete(deviceLinks, udid)
 		delete(deviceLife, udid)
-		delete(logSubscriptions, udid)
+		delete(deviceClockSkewFlagged, udid)
+		recordDeviceDisconnectLocked(udid)
+		clearDeviceSentManifest(udid)
+		commandNotices = append(failPendingCommandsForDeviceLocked(udid), failPendingBatchesForDeviceLocked(udid)...)
 		for id, route := range binaryRoutes {
 			if route != nil {
 				for _, deviceID := range route.Devices {
@@ -1390,38 +2058,45 @@ func handleDisconnection(conn *SafeConn) {
 			}
 		}
 
-		if len(controllers) > 0 {
+		// Within the grace period the device keeps its last state and subscriptions, and
+		// controllers only hear about the disconnect if it does not come back in time.
+		if grace := getDisconnectGrace(); grace > 0 {
+			delete(deviceStateSeq, udid)
+			markDeviceRetainedLocked(udid, time.Now())
+			scheduleDeviceOfflineLocked(udid, grace)
+		} else {
+			disconnectSystem = deviceSystemInfoLocked(udid)
+			forgetOfflineDeviceLocked(udid)
 			disconnectUDID = udid
-			disconnectTargets = snapshotControllerConnsLocked()
+			if len(controllers) > 0 {
+				disconnectTargets = snapshotControllerConnsLocked()
+			}
 		}
 	}
 	mu.Unlock()
 
+	for _, notice := range commandNotices {
+		sendMessageAsync(notice.conn, notice.msg)
+	}
+
 	if disconnectedUDID != "" {
 		clearPendingScriptStart(disconnectedUDID)
+		failTransferCompletionsForDevice(disconnectedUDID)
+		failPullBatchesForDevice(disconnectedUDID)
+		forgetRunningScript(disconnectedUDID)
+		forgetOpenCommandHistory(disconnectedUDID)
+		resetScreenFrameLimiter(disconnectedUDID)
 		abortInternalHTTPBinRequestsForDevice(disconnectedUDID, "device disconnected")
 	}
 
-	if disconnectUDID != "" && len(disconnectTargets) > 0 {
-		disconnectMsg := Message{
-			Type: "device/disconnect",
-			Body: disconnectUDID,
-		}
-		disconnectPayload, err := json.Marshal(disconnectMsg)
-		if err != nil {
-			log.Printf("Failed to marshal disconnect message for %s: %v", disconnectUDID, err)
-			return
-		}
-
-		for _, controllerConn := range disconnectTargets {
-			writeTextMessageAsync(controllerConn, disconnectPayload)
-		}
+	if disconnectUDID != "" {
+		announceDeviceDisconnect(disconnectUDID, disconnectSystem, disconnectTargets)
 	}
 }
 
 // startPingTimer starts the periodic WebSocket PING timer
 func startPingTimer() {
-	pingIntervalDuration := time.Duration(serverConfig.PingInterval) * time.Second
+	pingIntervalDuration := time.Duration(getServerConfig().PingInterval) * time.Second
 	pingTicker = time.NewTicker(pingIntervalDuration)
 
 	go func() {
@@ -1436,7 +2111,7 @@ func startPingTimer() {
 		}
 	}()
 
-	fmt.Printf("Ping timer started (interval: %v)\n", pingIntervalDuration)
+	slog.Info("Ping timer started", "interval", pingIntervalDuration.String())
 }
 
 // stopPingTimer stops the periodic WebSocket PING timer
@@ -1447,12 +2122,12 @@ func stopPingTimer() {
 		default:
 		}
 	}
-	fmt.Println("Ping timer stopped")
+	slog.Info("Ping timer stopped")
 }
 
 // startStateRefreshTimer starts the periodic app/state request timer
 func startStateRefreshTimer() {
-	stateIntervalDuration := time.Duration(serverConfig.StateInterval) * time.Second
+	stateIntervalDuration := time.Duration(getServerConfig().StateInterval) * time.Second
 	stateRefreshTicker = time.NewTicker(stateIntervalDuration)
 
 	go func() {
@@ -1467,7 +2142,7 @@ func startStateRefreshTimer() {
 		}
 	}()
 
-	fmt.Printf("State refresh timer started (interval: %v)\n", stateIntervalDuration)
+	slog.Info("State refresh timer started", "interval", stateIntervalDuration.String())
 }
 
 // stopStateRefreshTimer stops the periodic app/state request timer
@@ -1478,7 +2153,7 @@ func stopStateRefreshTimer() {
 		default:
 		}
 	}
-	fmt.Println("State refresh timer stopped")
+	slog.Info("State refresh timer stopped")
 }
 
 // sendStateRequestToAllDevices sends app/state requests to all connected devices
@@ -1524,7 +2199,7 @@ func sendStateRequestToAllDevices() {
 	}
 	statePayload, err := json.Marshal(stateMsg)
 	if err != nil {
-		log.Printf("Failed to marshal state request: %v", err)
+		slog.Error("Failed to marshal state request", "error", err)
 		return
 	}
 
@@ -1533,7 +2208,7 @@ func sendStateRequestToAllDevices() {
 		dc := target.conn
 		runAsyncWrite(func() {
 			if err := writeTextMessage(dc, statePayload); err != nil {
-				log.Printf("Failed to send state request to device %s: %v", deviceUDID, err)
+				slog.Warn("Failed to send state request to device", "udid", deviceUDID, "error", err)
 			}
 		})
 	}
@@ -1554,7 +2229,7 @@ func sendPingToAllDevices() {
 		dc := target.conn
 		runAsyncWrite(func() {
 			if err := dc.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
-				log.Printf("Failed to send ping to device %s: %v", deviceUDID, err)
+				slog.Warn("Failed to send ping to device", "udid", deviceUDID, "error", err)
 			}
 		})
 	}
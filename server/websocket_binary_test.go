@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestValidateBinaryChunk(t *testing.T) {
+	backupCount, backupBytes := getServerConfig().BinaryMaxChunkCount, getServerConfig().BinaryMaxChunkBytes
+	updateServerConfig(func(cfg *ServerConfig) { cfg.BinaryMaxChunkCount = 4 })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.BinaryMaxChunkBytes = 16 })
+	t.Cleanup(func() {
+		updateServerConfig(func(cfg *ServerConfig) { cfg.BinaryMaxChunkCount = backupCount })
+		updateServerConfig(func(cfg *ServerConfig) { cfg.BinaryMaxChunkBytes = backupBytes })
+	})
+
+	cases := []struct {
+		name      string
+		seq       uint32
+		total     uint32
+		chunkSize int
+		wantErr   bool
+	}{
+		{name: "first chunk", seq: 0, total: 4, chunkSize: 16},
+		{name: "last chunk", seq: 3, total: 4, chunkSize: 0},
+		{name: "seq equals total", seq: 4, total: 4, chunkSize: 1, wantErr: true},
+		{name: "zero total", seq: 0, total: 0, chunkSize: 1, wantErr: true},
+		{name: "too many chunks", seq: 0, total: 5, chunkSize: 1, wantErr: true},
+		{name: "chunk too large", seq: 0, total: 1, chunkSize: 17, wantErr: true},
+	}
+	for _, tc := range cases {
+		err := validateBinaryChunk(tc.seq, tc.total, tc.chunkSize)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: expected error=%t, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.BinaryMaxChunkCount = 0 })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.BinaryMaxChunkBytes = 0 })
+	if err := validateBinaryChunk(0, 1<<20, 1<<24); err != nil {
+		t.Fatalf("expected zero limits to disable checks, got %v", err)
+	}
+}
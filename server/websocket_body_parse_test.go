@@ -128,3 +128,28 @@ func TestParseLogSubscribeRequestBody(t *testing.T) {
 		t.Fatalf("unexpected devices: %+v", got.Devices)
 	}
 }
+
+func TestShouldForwardDeviceMessage(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ForwardAllowTypes = nil })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ForwardDenyTypes = []string{"telemetry/*", "app/heartbeat"} })
+	if shouldForwardDeviceMessage("telemetry/cpu") || shouldForwardDeviceMessage("app/heartbeat") {
+		t.Fatalf("expected denied types to be dropped")
+	}
+	if !shouldForwardDeviceMessage("script/run") {
+		t.Fatalf("expected other types to be forwarded")
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ForwardAllowTypes = []string{"script/*", "telemetry/*"} })
+	if !shouldForwardDeviceMessage("script/run") {
+		t.Fatalf("expected allowlisted type to be forwarded")
+	}
+	if shouldForwardDeviceMessage("touch/down") {
+		t.Fatalf("expected non-allowlisted type to be dropped")
+	}
+	if shouldForwardDeviceMessage("telemetry/cpu") {
+		t.Fatalf("expected deny list to win over allow list")
+	}
+}
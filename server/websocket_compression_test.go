@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShouldCompressWebSocketMessage(t *testing.T) {
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompression = false })
+	if shouldCompressWebSocketMessage(1 << 20) {
+		t.Fatalf("compression disabled should never compress")
+	}
+	updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompression = true })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompressionMinBytes = 0 })
+	if shouldCompressWebSocketMessage(defaultWebSocketCompressionMinBytes-1) || !shouldCompressWebSocketMessage(defaultWebSocketCompressionMinBytes) {
+		t.Fatalf("expected the default threshold of %d bytes", defaultWebSocketCompressionMinBytes)
+	}
+	updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompressionMinBytes = 64 })
+	if shouldCompressWebSocketMessage(63) || !shouldCompressWebSocketMessage(64) {
+		t.Fatalf("expected the configured threshold of 64 bytes")
+	}
+}
+
+func TestWebSocketUpgradeNegotiatesCompressionWhenEnabled(t *testing.T) {
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			// Each subtest joins its own server before the next one flips the config.
+			updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompression = enabled })
+			dial := newWebSocketHandlerTestServer(t, &websocket.Dialer{EnableCompression: true})
+			_, resp := dial()
+			negotiated := strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+			if negotiated != enabled {
+				t.Fatalf("webSocketCompression=%v but permessage-deflate negotiated=%v", enabled, negotiated)
+			}
+		})
+	}
+}
+
+// benchmarkAppStatePayload builds a forwarded app/state of roughly 4KB.
+func benchmarkAppStatePayload(b *testing.B) []byte {
+	b.Helper()
+	apps := make([]interface{}, 0, 40)
+	for i := 0; i < 40; i++ {
+		apps = append(apps, map[string]interface{}{"bid": fmt.Sprintf("com.example.app%d", i), "name": fmt.Sprintf("App %d", i), "version": "1.0.0"})
+	}
+	payload, err := json.Marshal(Message{Type: "app/state", UDID: "00008030-001A2B3C4D5E6F70", Body: map[string]interface{}{
+		"system": map[string]interface{}{"udid": "00008030-001A2B3C4D5E6F70", "name": "iPhone", "version": "16.5", "running": true},
+		"apps":   apps,
+	}})
+	if err != nil {
+		b.Fatalf("marshal failed: %v", err)
+	}
+	return payload
+}
+
+// BenchmarkWebSocketWrite compares sending a forwarded app/state with and without
+// permessage-deflate; run with -benchmem to see the CPU and allocation cost.
+func BenchmarkWebSocketWrite(b *testing.B) {
+	payload := benchmarkAppStatePayload(b)
+	for _, compress := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compress=%v", compress), func(b *testing.B) {
+			backup := *getServerConfig()
+			b.Cleanup(func() { setServerConfig(backup) })
+			updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompression = compress })
+			updateServerConfig(func(cfg *ServerConfig) { cfg.WebSocketCompressionMinBytes = 0 })
+
+			serverConns := make(chan *websocket.Conn, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				connUpgrader := upgrader
+				connUpgrader.EnableCompression = compress
+				conn, err := connUpgrader.Upgrade(w, r, nil)
+				if err == nil {
+					serverConns <- conn
+				}
+			}))
+			defer server.Close()
+			client, _, err := (&websocket.Dialer{EnableCompression: true}).Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				b.Fatalf("dial failed: %v", err)
+			}
+			defer client.Close()
+			sc := &SafeConn{conn: <-serverConns}
+			defer sc.Close()
+			go func() {
+				for {
+					if _, _, err := client.NextReader(); err != nil {
+						return
+					}
+				}
+			}()
+
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := sc.WriteMessage(websocket.TextMessage, payload); err != nil {
+					b.Fatalf("write failed: %v", err)
+				}
+			}
+		})
+	}
+}
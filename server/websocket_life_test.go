@@ -1,26 +1,36 @@
 package main
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
 
 func TestGetDeviceLifeLimitUsesPingTimeoutConfig(t *testing.T) {
-	backup := serverConfig
+	backup := *getServerConfig()
 	defer func() {
-		serverConfig = backup
+		setServerConfig(backup)
 	}()
 
-	serverConfig.PingTimeout = 7
+	updateServerConfig(func(cfg *ServerConfig) { cfg.PingTimeout = 7 })
 	if got := getDeviceLifeLimit(); got != 7 {
 		t.Fatalf("expected life limit 7, got %d", got)
 	}
 }
 
 func TestGetDeviceLifeLimitFallsBackToDefaultConfig(t *testing.T) {
-	backup := serverConfig
+	backup := *getServerConfig()
 	defer func() {
-		serverConfig = backup
+		setServerConfig(backup)
 	}()
 
-	serverConfig.PingTimeout = 0
+	updateServerConfig(func(cfg *ServerConfig) { cfg.PingTimeout = 0 })
 	want := DefaultConfig.PingTimeout
 	if want <= 0 {
 		want = DefaultDeviceLife
@@ -32,7 +42,7 @@ func TestGetDeviceLifeLimitFallsBackToDefaultConfig(t *testing.T) {
 }
 
 func TestResetDeviceLifeUsesConfiguredLimit(t *testing.T) {
-	configBackup := serverConfig
+	configBackup := *getServerConfig()
 	mu.Lock()
 	linksBackup := deviceLinksMap
 	lifeBackup := deviceLife
@@ -40,14 +50,14 @@ func TestResetDeviceLifeUsesConfiguredLimit(t *testing.T) {
 	deviceLife = make(map[string]int)
 	mu.Unlock()
 	defer func() {
-		serverConfig = configBackup
+		setServerConfig(configBackup)
 		mu.Lock()
 		deviceLinksMap = linksBackup
 		deviceLife = lifeBackup
 		mu.Unlock()
 	}()
 
-	serverConfig.PingTimeout = 9
+	updateServerConfig(func(cfg *ServerConfig) { cfg.PingTimeout = 9 })
 	conn := &SafeConn{}
 	udid := "udid-test"
 
@@ -65,3 +75,86 @@ func TestResetDeviceLifeUsesConfiguredLimit(t *testing.T) {
 		t.Fatalf("expected life reset to 9, got %d", got)
 	}
 }
+
+func TestConnectionPastMaxAge(t *testing.T) {
+	backup := *getServerConfig()
+	defer func() {
+		setServerConfig(backup)
+	}()
+
+	connectedAt := time.Unix(1700000000, 0)
+	updateServerConfig(func(cfg *ServerConfig) { cfg.MaxConnAgeSeconds = 0 })
+	if connectionPastMaxAge(connectedAt, connectedAt.Add(24*time.Hour)) {
+		t.Fatalf("max age 0 should never expire connections")
+	}
+
+	updateServerConfig(func(cfg *ServerConfig) { cfg.MaxConnAgeSeconds = 60 })
+	if connectionPastMaxAge(connectedAt, connectedAt.Add(59*time.Second)) {
+		t.Fatalf("connection younger than max age should stay open")
+	}
+	if !connectionPastMaxAge(connectedAt, connectedAt.Add(60*time.Second)) {
+		t.Fatalf("connection at max age should be closed")
+	}
+}
+
+func TestHandleWebSocketConnectionClosesAfterMaxAge(t *testing.T) {
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.MaxConnAgeSeconds = 1 })
+
+	dial := newWebSocketHandlerTestServer(t, websocket.DefaultDialer)
+
+	client, _ := dial()
+	time.Sleep(1100 * time.Millisecond)
+	// The write may race the server's close; the close frame is what matters.
+	_ = client.WriteMessage(websocket.TextMessage, []byte("not json"))
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Fatalf("expected close %d, got %v", websocket.CloseServiceRestart, err)
+	}
+
+	// A connection that never sends anything is closed at its max age too.
+	silent, _ := dial()
+	_ = silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = silent.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseServiceRestart) {
+		t.Fatalf("expected silent connection to get close %d, got %v", websocket.CloseServiceRestart, err)
+	}
+}
+
+// newWebSocketHandlerTestServer serves handleWebSocketConnection and returns a dial
+// func. Its cleanup closes every client and waits for the handlers to return, so a
+// config restore registered earlier never races a connection goroutine.
+func newWebSocketHandlerTestServer(t *testing.T, dialer *websocket.Dialer) func() (*websocket.Conn, *http.Response) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var handlers sync.WaitGroup
+	router := gin.New()
+	router.GET("/api/ws", func(c *gin.Context) {
+		handlers.Add(1)
+		defer handlers.Done()
+		handleWebSocketConnection(c)
+	})
+	server := httptest.NewServer(router)
+	var clients []*websocket.Conn
+	t.Cleanup(func() {
+		for _, client := range clients {
+			client.Close()
+		}
+		server.Close()
+		handlers.Wait()
+	})
+	return func() (*websocket.Conn, *http.Response) {
+		t.Helper()
+		client, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		// Skip the close echo: the server drops the socket right after its close
+		// frame, and a failed echo would mask the close code.
+		client.SetCloseHandler(func(int, string) error { return nil })
+		clients = append(clients, client)
+		return client, resp
+	}
+}
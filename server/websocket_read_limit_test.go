@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketReadLimitClosesOversizedMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	backup := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(backup) })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.MaxWebSocketMessageBytes = 1024 })
+
+	r := gin.New()
+	r.GET("/api/ws", handleWebSocketConnection)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"`+strings.Repeat("x", 2048)+`"}`)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("expected close 1009 for an oversized message, got %v", err)
+	}
+}
+
+func TestValidateServerConfig_MaxWebSocketMessageBytesFitsInlineFiles(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.LargeFileThresholdBytes = 1024 * 1024
+	cfg.MaxWebSocketMessageBytes = 1024 * 1024
+	if err := validateServerConfig(cfg); err == nil {
+		t.Fatal("expected a limit below the base64 size of largeFileThresholdBytes to be rejected")
+	}
+	cfg.MaxWebSocketMessageBytes = minWebSocketMessageBytes(cfg)
+	if err := validateServerConfig(cfg); err != nil {
+		t.Fatalf("expected the minimum limit to be accepted, got %v", err)
+	}
+	if got := minWebSocketMessageBytes(cfg); got < 1024*1024*4/3 {
+		t.Fatalf("minimum %d does not fit a base64 file/put of 1MiB", got)
+	}
+}
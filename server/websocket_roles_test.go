@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestMessageAllowedForRole(t *testing.T) {
+	cases := []struct {
+		role    connRole
+		msgType string
+		allowed bool
+	}{
+		{"", "control/command", true},
+		{"", "app/state", true},
+		{connRoleDevice, "app/state", true},
+		{connRoleDevice, "screen/frame", true},
+		{connRoleDevice, "control/refresh", false},
+		{connRoleControl, "control/command", true},
+		{connRoleControl, "app/state", false},
+		{connRoleControl, "register", false},
+	}
+	for _, tc := range cases {
+		if got := messageAllowedForRole(tc.role, tc.msgType); got != tc.allowed {
+			t.Errorf("role %q, type %q: allowed=%v, want %v", tc.role, tc.msgType, got, tc.allowed)
+		}
+	}
+}
+
+func TestDeclaredRoleAppliesFromFirstMessage(t *testing.T) {
+	prev := *getServerConfig()
+	t.Cleanup(func() { setServerConfig(prev) })
+	updateServerConfig(func(cfg *ServerConfig) { cfg.ControlRateLimit, cfg.ControlRateBurst = 0.001, 1 })
+
+	conn, _ := newTestWebSocketPair(t)
+	conn.role = connRoleControl
+	if !enforceMessageRateLimit(conn, Message{Type: "control/devices"}) {
+		t.Fatalf("first message should pass")
+	}
+	if enforceMessageRateLimit(conn, Message{Type: "control/devices"}) {
+		t.Fatalf("a declared controller should get the control limits before it is registered")
+	}
+
+	deviceConn, client := newTestWebSocketPair(t)
+	deviceConn.role = connRoleDevice
+	if err := handleMessage(deviceConn, Message{Type: "control/refresh", RequestID: "r1"}); err != nil {
+		t.Fatalf("handleMessage failed: %v", err)
+	}
+	msg := readTestMessage(t, client)
+	if msg.Type != "error" || msg.RequestID != "r1" || msg.Error != "control/refresh is not accepted on /api/ws/device" {
+		t.Fatalf("unexpected reply: %+v", msg)
+	}
+	mu.RLock()
+	registered := controllers[deviceConn]
+	mu.RUnlock()
+	if registered {
+		t.Fatalf("a declared device must not become a controller")
+	}
+}